@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/shiv6146/blayzen-sip/internal/apikey"
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+// bootstrapAccount creates the initial admin account when the database has no
+// accounts. The API key is taken from BOOTSTRAP_API_KEY(_FILE) when set,
+// otherwise one is generated and printed once.
+func bootstrapAccount(ctx context.Context, cfg *config.Config, pgStore *store.PostgresStore) error {
+	apiKey, err := bootstrapAPIKey(cfg)
+	if err != nil {
+		return err
+	}
+
+	generated := apiKey == ""
+	if generated {
+		if apiKey, err = apikey.Generate(); err != nil {
+			return err
+		}
+	}
+
+	account, err := pgStore.CreateInitialAccount(ctx, cfg.BootstrapAccountName, apiKey)
+	if err != nil {
+		return fmt.Errorf("failed to create account: %w", err)
+	}
+	if account == nil {
+		// Accounts already exist
+		return nil
+	}
+
+	log.Printf("Created initial account %q (%s)", account.Name, account.ID)
+	if !generated {
+		log.Println("Using the API key from the bootstrap configuration")
+		return nil
+	}
+
+	log.Println("")
+	log.Println("========================================")
+	log.Println("Initial API credentials (shown only once)")
+	log.Println("========================================")
+	log.Printf("Account ID: %s", account.ID)
+	log.Printf("API key:    %s", apiKey)
+	log.Println("========================================")
+	log.Println("")
+	return nil
+}
+
+// bootstrapAPIKey returns the configured API key, read from a file (e.g. a
+// Docker/Kubernetes secret) or the environment, or "" when none is set
+func bootstrapAPIKey(cfg *config.Config) (string, error) {
+	apiKey := cfg.BootstrapAPIKey
+	if cfg.BootstrapAPIKeyFile != "" {
+		data, err := os.ReadFile(cfg.BootstrapAPIKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read API key file: %w", err)
+		}
+		apiKey = strings.TrimSpace(string(data))
+		if apiKey == "" {
+			return "", fmt.Errorf("API key file %s is empty", cfg.BootstrapAPIKeyFile)
+		}
+	}
+	return apiKey, nil
+}
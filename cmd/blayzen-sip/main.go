@@ -4,15 +4,25 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/shiv6146/blayzen-sip/internal/api"
+	"github.com/shiv6146/blayzen-sip/internal/apitoken"
 	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/failover"
+	"github.com/shiv6146/blayzen-sip/internal/leader"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/privacy"
 	"github.com/shiv6146/blayzen-sip/internal/server"
+	"github.com/shiv6146/blayzen-sip/internal/softphone"
+	"github.com/shiv6146/blayzen-sip/internal/storage"
 	"github.com/shiv6146/blayzen-sip/internal/store"
+	"github.com/shiv6146/blayzen-sip/internal/version"
 
 	_ "github.com/shiv6146/blayzen-sip/docs" // Import generated swagger docs
 )
@@ -34,11 +44,42 @@ import (
 
 // @securityDefinitions.basic BasicAuth
 
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+
+// @securityDefinitions.apikey AdminAuth
+// @in header
+// @name Authorization
+
 func main() {
-	log.Println("Starting blayzen-sip...")
+	// Subcommands run without the server's configuration
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "tail":
+			os.Exit(tail(os.Args[2:]))
+		case "version":
+			os.Exit(printVersion(os.Args[2:]))
+		}
+	}
 
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	logging.Setup(cfg.LogLevel, cfg.LogFormat)
+	logging.SetRateLimit(cfg.LogRateLimitBurst, cfg.LogRateLimitInterval)
+
+	log.Printf("Starting %s...", version.Get())
+	if cfg.Profile != "" {
+		log.Printf("Using config profile %s (%s)", cfg.Profile, cfg.ConfigDir)
+	}
+
+	// Number masking for logs and call records
+	if err := privacy.Configure(cfg); err != nil {
+		fatal("Invalid number privacy configuration", err)
+	}
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -48,18 +89,49 @@ func main() {
 	log.Println("Connecting to PostgreSQL...")
 	pgStore, err := store.NewPostgresStore(ctx, cfg.DatabaseURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+		fatal("Failed to connect to PostgreSQL", err)
 	}
 	defer pgStore.Close()
 	log.Println("PostgreSQL connected")
 
+	// Refuse a database missing migrations this build needs
+	if err := checkSchema(ctx, cfg, pgStore); err != nil {
+		fatal("Database schema check failed", err)
+	}
+
+	// Connect to the read replica (optional)
+	if cfg.DatabaseReplicaURL != "" {
+		log.Println("Connecting to PostgreSQL read replica...")
+		if err := pgStore.AttachReplica(ctx, cfg.DatabaseReplicaURL); err != nil {
+			slog.Warn("Failed to connect to read replica, reporting will use the primary", "error", err)
+		} else {
+			log.Println("PostgreSQL read replica connected")
+		}
+	}
+
+	// Singleton background jobs run on whichever instance leads for them
+	jobsCtx, stopJobs := context.WithCancel(ctx)
+	jobs := leader.New(pgStore, cfg.LeaderElectionInterval)
+
+	// Keep call log partitions ahead of time and prune expired months
+	jobs.Go(jobsCtx, "partition-maintenance", func(ctx context.Context) {
+		pgStore.RunPartitionMaintenance(ctx, cfg.CallLogPartitionsAhead, cfg.CallLogRetentionMonths)
+	})
+
+	// Create the first account on a fresh database
+	if cfg.BootstrapAccount {
+		if err := bootstrapAccount(ctx, cfg, pgStore); err != nil {
+			fatal("Failed to bootstrap account", err)
+		}
+	}
+
 	// Connect to Valkey (optional)
 	var cache *store.Cache
 	if cfg.ValkeyURL != "" {
 		log.Println("Connecting to Valkey...")
 		cache, err = store.NewCache(ctx, cfg.ValkeyURL, cfg.ValkeyPassword, cfg.ValkeyDB, cfg.CacheRouteTTL)
 		if err != nil {
-			log.Printf("Warning: Failed to connect to Valkey: %v (continuing without cache)", err)
+			slog.Warn("Failed to connect to Valkey, continuing without cache", "error", err)
 			cache = nil
 		} else {
 			defer cache.Close()
@@ -67,25 +139,89 @@ func main() {
 		}
 	}
 
+	// Blob storage for recordings, prompts and saved exports
+	blobs, err := storage.NewFromConfig(cfg)
+	if err != nil {
+		fatal("Invalid storage configuration", err)
+	}
+
+	// Delete recordings and exports past their retention: on each instance
+	// when they're on local disk, else on one
+	lifecycle := storage.NewLifecycle(cfg, blobs, pgStore)
+	if cfg.StorageBackend == storage.BackendLocal {
+		go lifecycle.Run(ctx)
+	} else {
+		jobs.Go(jobsCtx, "storage-lifecycle", lifecycle.Run)
+	}
+
 	// Create and start SIP server
 	log.Println("Starting SIP server...")
-	sipServer, err := server.NewSIPServer(cfg, pgStore, cache)
+	sipServer, err := server.NewSIPServer(cfg, pgStore, cache, blobs)
 	if err != nil {
-		log.Fatalf("Failed to create SIP server: %v", err)
+		fatal("Failed to create SIP server", err)
 	}
 
-	if err := sipServer.Start(ctx); err != nil {
-		log.Fatalf("Failed to start SIP server: %v", err)
+	// One of an active/standby pair serves SIP only while active
+	pair, err := failover.New(cfg, pgStore)
+	if err != nil {
+		fatal("Invalid failover configuration", err)
+	}
+
+	startSIP := func() {
+		if err := sipServer.Start(ctx); err != nil {
+			fatal("Failed to start SIP server", err)
+		}
+		log.Printf("SIP server listening on %s:%d (%s)", cfg.SIPHost, cfg.SIPPort, cfg.SIPTransport)
+		if cfg.SIPHost6 != "" {
+			log.Printf("SIP server listening on [%s]:%d (%s)", cfg.SIPHost6, cfg.SIPPort, cfg.SIPTransport)
+		}
+
+		// End the calls a failed active instance left in progress
+		go sipServer.Calls().EndOrphanedCalls(ctx)
+
+		// Register trunks that require it with their providers
+		jobs.Go(jobsCtx, "trunk-registration", sipServer.Trunks().Run)
+	}
+	if pair.Enabled() {
+		log.Printf("Standing by as instance %s of an active/standby pair...", cfg.InstanceID)
+		go pair.Run(ctx)
+		go func() {
+			select {
+			case <-pair.Active():
+				startSIP()
+			case <-ctx.Done():
+			}
+		}()
+	} else {
+		startSIP()
+	}
+
+	metrics.NewGaugeFunc("blayzen_sip_active_calls", "Calls in progress", func() float64 {
+		return float64(sipServer.Calls().ActiveCount())
+	})
+
+	// Browser softphone gateway (optional)
+	var phone *softphone.Gateway
+	if cfg.WebRTCEnabled {
+		phone, err = softphone.New(cfg, sipServer.Calls())
+		if err != nil {
+			fatal("Failed to create softphone gateway", err)
+		}
+	}
+
+	// Bearer tokens for the REST API (optional)
+	tokens, err := apitoken.NewIssuer(cfg.APITokenSecret, cfg.APITokenTTL)
+	if err != nil {
+		fatal("Invalid API token configuration", err)
 	}
-	log.Printf("SIP server listening on %s:%d (%s)", cfg.SIPHost, cfg.SIPPort, cfg.SIPTransport)
 
 	// Create and start API server
 	log.Println("Starting REST API server...")
-	apiServer := api.NewServer(cfg, pgStore, cache)
+	apiServer := api.NewServer(cfg, pgStore, cache, blobs, phone, sipServer.Calls(), sipServer.RoutingDefaults(), sipServer.NumberOverrides(), sipServer.Maintenance(), sipServer.ACL(), sipServer.ScannerGuard(), sipServer.CPS(), pair, tokens)
 
 	go func() {
 		if err := apiServer.Start(); err != nil {
-			log.Printf("API server error: %v", err)
+			slog.Error("API server error", "error", err)
 		}
 	}()
 	log.Printf("REST API server listening on %s:%d", cfg.APIHost, cfg.APIPort)
@@ -100,15 +236,27 @@ func main() {
 	log.Printf("REST API: http://%s:%d/api/v1", cfg.APIHost, cfg.APIPort)
 	log.Printf("Swagger:  http://%s:%d/swagger/index.html", cfg.APIHost, cfg.APIPort)
 	log.Printf("Health:   http://%s:%d/health", cfg.APIHost, cfg.APIPort)
+	if phone != nil {
+		log.Printf("Phone:    http://%s:%d/softphone", cfg.APIHost, cfg.APIPort)
+	}
+	if pair.Enabled() {
+		log.Printf("Failover: %s (instance %s)", cfg.HAMode, cfg.InstanceID)
+	}
 	log.Println("========================================")
 	log.Println("")
 
 	// Wait for shutdown signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-
-	log.Println("Shutdown signal received, stopping services...")
+	lost := false
+	select {
+	case <-sigChan:
+		log.Println("Shutdown signal received, stopping services...")
+	case <-pair.Lost():
+		// Another instance serves SIP now; restart to stand by
+		log.Println("No longer the active instance, stopping services...")
+		lost = true
+	}
 
 	// Graceful shutdown
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -116,15 +264,30 @@ func main() {
 
 	// Stop API server
 	if err := apiServer.Shutdown(shutdownCtx); err != nil {
-		log.Printf("API server shutdown error: %v", err)
+		slog.Error("API server shutdown error", "error", err)
 	}
 
+	// Stop background jobs, unregistering trunks, so a standby takes over
+	stopJobs()
+	jobs.Wait()
+
 	// Stop SIP server
 	if err := sipServer.Stop(); err != nil {
-		log.Printf("SIP server shutdown error: %v", err)
+		slog.Error("SIP server shutdown error", "error", err)
 	}
 
+	// Hand over to the standby at once
+	pair.Release(shutdownCtx)
+
 	cancel()
 	log.Println("blayzen-sip stopped")
+	if lost {
+		os.Exit(1)
+	}
 }
 
+// fatal logs an error that prevents startup and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
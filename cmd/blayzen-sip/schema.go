@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+// Schema check modes (DB_SCHEMA_CHECK)
+const (
+	schemaCheckEnforce = "enforce" // Refuse to start on an older schema
+	schemaCheckWarn    = "warn"    // Only log a mismatch
+	schemaCheckOff     = "off"
+)
+
+// checkSchema verifies the database has the migrations this build expects.
+// An older schema fails the check when enforced, as queries would hit
+// missing tables and columns mid-call; a newer one, as when migrations run
+// ahead of a rolling upgrade, is only logged, since migrations add to the
+// schema without breaking older builds.
+func checkSchema(ctx context.Context, cfg *config.Config, pgStore *store.PostgresStore) error {
+	switch cfg.DBSchemaCheck {
+	case schemaCheckOff:
+		return nil
+	case schemaCheckEnforce, schemaCheckWarn:
+	default:
+		return fmt.Errorf("invalid DB_SCHEMA_CHECK %q: must be %s, %s or %s", cfg.DBSchemaCheck, schemaCheckEnforce, schemaCheckWarn, schemaCheckOff)
+	}
+
+	applied, err := pgStore.CheckSchema(ctx)
+	switch {
+	case err == nil:
+		log.Printf("Database schema at %s", applied)
+		return nil
+	case errors.Is(err, store.ErrSchemaBehind) && cfg.DBSchemaCheck == schemaCheckEnforce:
+		return err
+	case errors.Is(err, store.ErrSchemaBehind), errors.Is(err, store.ErrSchemaAhead):
+		slog.Warn("Database schema mismatch", "error", err)
+		return nil
+	default:
+		return err
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shiv6146/blayzen-sip/internal/eventstream"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// tailReconnectDelay is how long tail waits before reconnecting a stream
+// that ended
+const tailReconnectDelay = 2 * time.Second
+
+// errTailRejected is returned when the API refuses the stream, which
+// reconnecting won't fix
+var errTailRejected = errors.New("stream refused")
+
+// ANSI colors of the event kinds
+const (
+	colorReset   = "\033[0m"
+	colorDim     = "\033[2m"
+	colorRed     = "\033[31m"
+	colorGreen   = "\033[32m"
+	colorYellow  = "\033[33m"
+	colorMagenta = "\033[35m"
+	colorCyan    = "\033[36m"
+)
+
+// tail runs `blayzen-sip tail`: it follows an account's calls, or one call,
+// through the REST API's event stream and prints their SIP messages, agent
+// and media events and state changes as they happen. It returns the exit
+// code.
+func tail(args []string) int {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	apiURL := fs.String("api", envOr("BLAYZEN_API_URL", "http://localhost:8080"), "REST API base URL (or BLAYZEN_API_URL)")
+	account := fs.String("account", "", "Account ID, authenticating with --key")
+	key := fs.String("key", os.Getenv("BLAYZEN_API_KEY"), "Account API key (or BLAYZEN_API_KEY)")
+	token := fs.String("token", os.Getenv("BLAYZEN_API_TOKEN"), "Bearer token, instead of --account and --key (or BLAYZEN_API_TOKEN)")
+	callID := fs.String("call", "", "Only follow this call: its record ID or SIP Call-ID")
+	noColor := fs.Bool("no-color", false, "Print without colors")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: blayzen-sip tail --account <id> --key <api-key> [--call <id>]")
+		fmt.Fprintln(fs.Output(), "       blayzen-sip tail --token <token> [--call <id>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	header := http.Header{}
+	switch {
+	case *token != "":
+		header.Set("Authorization", "Bearer "+*token)
+	case *account != "" && *key != "":
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(*account+":"+*key)))
+	default:
+		fmt.Fprintln(os.Stderr, "tail: --account and --key, or --token, are required")
+		fs.Usage()
+		return 2
+	}
+
+	streamURL, err := eventStreamURL(*apiURL, *callID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	p := &tailPrinter{out: os.Stdout, color: !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)}
+	for {
+		err := followEvents(ctx, streamURL, header, p)
+		if ctx.Err() != nil {
+			return 0
+		}
+		if errors.Is(err, errTailRejected) {
+			fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+			return 1
+		}
+		p.notice(fmt.Sprintf("Stream ended (%v), reconnecting...", err))
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(tailReconnectDelay):
+		}
+	}
+}
+
+// eventStreamURL returns the WebSocket URL of the event stream with trace
+// events, of one call when callID is set
+func eventStreamURL(apiURL, callID string) (string, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid API URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("invalid API URL %q: must be http or https", apiURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v1/events/stream"
+
+	query := url.Values{"trace": {"true"}}
+	if callID != "" {
+		query.Set("call_id", callID)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// followEvents prints the stream's events until it ends or ctx is cancelled
+func followEvents(ctx context.Context, streamURL string, header http.Header, p *tailPrinter) error {
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, streamURL, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return fmt.Errorf("%w: %s", errTailRejected, resp.Status)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	// Closing the connection ends the read below on Ctrl-C
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		var event eventstream.Event
+		if err := conn.ReadJSON(&event); err != nil {
+			return err
+		}
+		p.print(event)
+	}
+}
+
+// tailPrinter prints events one per line, colored by kind on terminals
+type tailPrinter struct {
+	out   io.Writer
+	color bool
+}
+
+// print prints an event: its time, call, kind, direction and what happened
+func (p *tailPrinter) print(event eventstream.Event) {
+	if event.Event == eventstream.EventSnapshot {
+		p.notice(fmt.Sprintf("Following calls (%d active)", event.ActiveCalls))
+		return
+	}
+
+	kind, color := "CALL", colorGreen
+	detail := string(event.Status)
+	switch event.Event {
+	case eventstream.EventSIP:
+		kind, color, detail = "SIP", colorCyan, event.Detail
+	case eventstream.EventAgent:
+		kind, color, detail = "AGENT", colorMagenta, event.Detail
+	case eventstream.EventMedia:
+		kind, color, detail = "MEDIA", colorYellow, event.Detail
+	default:
+		if event.Status != models.CallStatusCompleted && event.Status != models.CallStatusAnswered &&
+			event.Status != models.CallStatusRinging && event.Status != models.CallStatusInitiated {
+			color = colorRed
+		}
+		detail = fmt.Sprintf("%s (%d active)", detail, event.ActiveCalls)
+	}
+
+	arrow := " "
+	switch event.Direction {
+	case string(models.SIPMessageInbound):
+		arrow = "←"
+	case string(models.SIPMessageOutbound):
+		arrow = "→"
+	}
+
+	call := event.CallID
+	if call == "" {
+		call = event.ID
+	}
+	if len(call) > 12 {
+		call = call[:12]
+	}
+
+	fmt.Fprintf(p.out, "%s %-12s %s %s %s\n",
+		p.paint(colorDim, event.Timestamp.Local().Format("15:04:05.000")),
+		call,
+		p.paint(color, fmt.Sprintf("%-5s", kind)),
+		arrow,
+		detail,
+	)
+}
+
+// notice prints a line about the stream itself
+func (p *tailPrinter) notice(text string) {
+	fmt.Fprintln(p.out, p.paint(colorDim, "-- "+text))
+}
+
+// paint colors text when printing in color
+func (p *tailPrinter) paint(color, text string) string {
+	if !p.color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// envOr returns an environment variable, or fallback when it's unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
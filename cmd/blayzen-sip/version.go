@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/shiv6146/blayzen-sip/internal/version"
+)
+
+// printVersion runs `blayzen-sip version`: it prints the build's version,
+// commit, branch, build date and Go runtime, for bug reports and checking a
+// fleet's upgrades. It returns the exit code.
+func printVersion(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: blayzen-sip version [--json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	info := version.Get()
+	if !*asJSON {
+		fmt.Println(info)
+		return 0
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,295 @@
+// Package accounting sends RADIUS accounting records (RFC 2866) for calls to
+// an operator's accounting server: Start when a call is answered, periodic
+// Interim-Updates while it lasts and Stop when it ends, for settlement.
+package accounting
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+)
+
+var logger = logging.Component("accounting")
+
+// Acct-Status-Type values
+type StatusType uint32
+
+const (
+	StatusStart   StatusType = 1
+	StatusStop    StatusType = 2
+	StatusInterim StatusType = 3 // Interim-Update
+)
+
+func (t StatusType) String() string {
+	switch t {
+	case StatusStart:
+		return "start"
+	case StatusStop:
+		return "stop"
+	case StatusInterim:
+		return "interim"
+	}
+	return fmt.Sprintf("status-%d", uint32(t))
+}
+
+// Acct-Terminate-Cause values used for calls
+const (
+	TerminateUserRequest        = 1  // The caller hung up
+	TerminateLostService        = 3  // The agent was lost
+	TerminateIdleTimeout        = 4  // Silence timeout
+	TerminateSessionTimeout     = 5  // The session timer expired
+	TerminateNASRequest         = 10 // blayzen-sip ended the call
+	TerminateNASReboot          = 11 // blayzen-sip shut down
+	TerminatePortPreempted      = 13 // Preempted by a higher-priority call
+	TerminateServiceUnavailable = 15 // No agent could be reached
+	TerminateHostRequest        = 18 // The agent hung up
+)
+
+// RADIUS packet codes and attribute types
+const (
+	codeAccountingRequest  = 4
+	codeAccountingResponse = 5
+
+	attrUserName            = 1
+	attrCalledStationID     = 30
+	attrCallingStationID    = 31
+	attrNASIdentifier       = 32
+	attrAcctStatusType      = 40
+	attrAcctDelayTime       = 41
+	attrAcctInputOctets     = 42
+	attrAcctOutputOctets    = 43
+	attrAcctSessionID       = 44
+	attrAcctSessionTime     = 46
+	attrAcctInputPackets    = 47
+	attrAcctOutputPackets   = 48
+	attrAcctTerminateCause  = 49
+	attrAcctInputGigawords  = 52
+	attrAcctOutputGigawords = 53
+	attrEventTimestamp      = 55
+)
+
+// Packet limits
+const (
+	headerLength = 20
+	maxPacket    = 4096
+	maxAttrValue = 253
+)
+
+// Record is the accounting data of a call at one point in its life
+type Record struct {
+	SessionID      string // SIP Call-ID
+	UserName       string // Account ID
+	CallingStation string // Caller number, masked as in call records
+	CalledStation  string // Dialed number, masked as in call records
+	SessionTime    time.Duration
+	InputOctets    int64 // RTP bytes from the caller
+	OutputOctets   int64 // RTP bytes to the caller
+	InputPackets   int64
+	OutputPackets  int64
+	TerminateCause int // Stop records only
+	Timestamp      time.Time
+}
+
+// Client sends accounting records to one server. A nil client sends nothing.
+type Client struct {
+	server   string
+	secret   []byte
+	nasID    string
+	interval time.Duration
+	timeout  time.Duration
+	retries  int
+	slots    chan struct{} // Bounds records in flight
+}
+
+// maxInFlight bounds records awaiting a response; more are dropped
+const maxInFlight = 256
+
+// NewFromConfig returns the accounting client, or nil when no server is
+// configured
+func NewFromConfig(cfg *config.Config) (*Client, error) {
+	if cfg.RadiusAcctServer == "" {
+		return nil, nil
+	}
+	if cfg.RadiusAcctSecret == "" {
+		return nil, errors.New("RADIUS_ACCT_SECRET is required with RADIUS_ACCT_SERVER")
+	}
+
+	server := cfg.RadiusAcctServer
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "1813")
+	}
+	return &Client{
+		server:   server,
+		secret:   []byte(cfg.RadiusAcctSecret),
+		nasID:    cfg.RadiusNASIdentifier,
+		interval: cfg.RadiusAcctInterimInterval,
+		timeout:  cfg.RadiusAcctTimeout,
+		retries:  max(cfg.RadiusAcctRetries, 0),
+		slots:    make(chan struct{}, maxInFlight),
+	}, nil
+}
+
+// InterimInterval returns how often Interim-Updates are sent for calls in
+// progress, 0 if never
+func (c *Client) InterimInterval() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.interval
+}
+
+// Send sends a record in the background, retransmitting it until the server
+// acknowledges it or retries run out
+func (c *Client) Send(status StatusType, rec Record) {
+	if c == nil {
+		return
+	}
+
+	log := logger.With("call_id", rec.SessionID, "status", status.String())
+	select {
+	case c.slots <- struct{}{}:
+	default:
+		log.Warn("Too many accounting records in flight, dropping record")
+		return
+	}
+
+	go func() {
+		defer func() { <-c.slots }()
+
+		if err := c.exchange(status, rec); err != nil {
+			log.Error("Accounting record not acknowledged", "server", c.server, "error", err)
+			return
+		}
+		log.Debug("Accounting record acknowledged")
+	}()
+}
+
+// exchange sends a record and waits for the matching Accounting-Response
+func (c *Client) exchange(status StatusType, rec Record) error {
+	packet, err := c.encode(status, rec)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("udp", c.server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, maxPacket)
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(c.timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break // Retransmit
+				}
+				return err
+			}
+			if c.acknowledges(buf[:n], packet) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no response after %d attempts", c.retries+1)
+}
+
+// encode builds an Accounting-Request with a fresh identifier
+func (c *Client) encode(status StatusType, rec Record) ([]byte, error) {
+	var attrs bytes.Buffer
+	addString := func(typ byte, value string) {
+		if value == "" {
+			return
+		}
+		if len(value) > maxAttrValue {
+			value = value[:maxAttrValue]
+		}
+		attrs.WriteByte(typ)
+		attrs.WriteByte(byte(2 + len(value)))
+		attrs.WriteString(value)
+	}
+	addInt := func(typ byte, value uint32) {
+		attrs.WriteByte(typ)
+		attrs.WriteByte(6)
+		_ = binary.Write(&attrs, binary.BigEndian, value)
+	}
+
+	addInt(attrAcctStatusType, uint32(status))
+	addString(attrAcctSessionID, rec.SessionID)
+	addString(attrUserName, rec.UserName)
+	addString(attrNASIdentifier, c.nasID)
+	addString(attrCallingStationID, rec.CallingStation)
+	addString(attrCalledStationID, rec.CalledStation)
+	addInt(attrEventTimestamp, uint32(rec.Timestamp.Unix()))
+	addInt(attrAcctDelayTime, uint32(time.Since(rec.Timestamp).Seconds()))
+	if status != StatusStart {
+		addInt(attrAcctSessionTime, uint32(rec.SessionTime.Seconds()))
+		addInt(attrAcctInputOctets, uint32(rec.InputOctets))
+		addInt(attrAcctInputGigawords, uint32(rec.InputOctets>>32))
+		addInt(attrAcctOutputOctets, uint32(rec.OutputOctets))
+		addInt(attrAcctOutputGigawords, uint32(rec.OutputOctets>>32))
+		addInt(attrAcctInputPackets, uint32(rec.InputPackets))
+		addInt(attrAcctOutputPackets, uint32(rec.OutputPackets))
+	}
+	if status == StatusStop && rec.TerminateCause != 0 {
+		addInt(attrAcctTerminateCause, uint32(rec.TerminateCause))
+	}
+
+	length := headerLength + attrs.Len()
+	if length > maxPacket {
+		return nil, fmt.Errorf("accounting record too large (%d bytes)", length)
+	}
+
+	var id [1]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	// Request Authenticator: MD5(Code+Identifier+Length+16 zero octets+
+	// Attributes+Secret)
+	packet := make([]byte, headerLength, length)
+	packet[0] = codeAccountingRequest
+	packet[1] = id[0]
+	binary.BigEndian.PutUint16(packet[2:4], uint16(length))
+	packet = append(packet, attrs.Bytes()...)
+
+	hash := md5.New()
+	hash.Write(packet)
+	hash.Write(c.secret)
+	copy(packet[4:20], hash.Sum(nil))
+	return packet, nil
+}
+
+// acknowledges reports whether a response is the server's Accounting-Response
+// to a request: matching identifier and a valid Response Authenticator,
+// MD5(Code+Identifier+Length+Request Authenticator+Attributes+Secret)
+func (c *Client) acknowledges(response, request []byte) bool {
+	if len(response) < headerLength || response[0] != codeAccountingResponse || response[1] != request[1] {
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(response[2:4]))
+	if length < headerLength || length > len(response) {
+		return false
+	}
+
+	hash := md5.New()
+	hash.Write(response[:4])
+	hash.Write(request[4:20])
+	hash.Write(response[headerLength:length])
+	hash.Write(c.secret)
+	return bytes.Equal(hash.Sum(nil), response[4:20])
+}
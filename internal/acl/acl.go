@@ -0,0 +1,250 @@
+// Package acl decides which sources may send SIP requests, by address
+// ranges allowed or denied for every caller and the SBC ranges of trunks'
+// carriers. Ranges come from the configuration and from entries managed
+// through the admin API, which are saved in Postgres and picked up by the
+// other instances within refreshInterval.
+package acl
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+var logger = logging.Component("acl")
+
+// refreshInterval is how often entries changed on another instance are
+// picked up
+const refreshInterval = 15 * time.Second
+
+// Policies for sources in no allow range
+const (
+	DefaultAllow = "allow"
+	DefaultDeny  = "deny"
+)
+
+// What refused requests get
+const (
+	ActionDrop   = "drop"
+	ActionReject = "reject"
+)
+
+// Decision is whether a source may send requests
+type Decision struct {
+	Allowed bool
+	TrunkID string // The trunk whose ranges the source is in, if any
+}
+
+// trunkRanges are the ranges of a trunk's carrier
+type trunkRanges struct {
+	id    string
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// rules are the ranges in effect
+type rules struct {
+	allow  []netip.Prefix
+	deny   []netip.Prefix
+	trunks []*trunkRanges
+}
+
+// ACL holds the access control list in effect
+type ACL struct {
+	store       *store.PostgresStore
+	defaultDeny bool
+	reject      bool
+
+	// Configured global ranges, ahead of the managed entries
+	allow []netip.Prefix
+	deny  []netip.Prefix
+
+	rules atomic.Pointer[rules]
+}
+
+// New creates the access control list with the configured ranges, without
+// the managed entries until loaded
+func New(cfg *config.Config, st *store.PostgresStore) (*ACL, error) {
+	a := &ACL{store: st}
+	switch cfg.SIPACLDefault {
+	case DefaultAllow:
+	case DefaultDeny:
+		a.defaultDeny = true
+	default:
+		return nil, fmt.Errorf("invalid SIP_ACL_DEFAULT %q: must be %s or %s", cfg.SIPACLDefault, DefaultAllow, DefaultDeny)
+	}
+	switch cfg.SIPACLAction {
+	case ActionDrop:
+	case ActionReject:
+		a.reject = true
+	default:
+		return nil, fmt.Errorf("invalid SIP_ACL_ACTION %q: must be %s or %s", cfg.SIPACLAction, ActionDrop, ActionReject)
+	}
+
+	var err error
+	if a.allow, err = parseRanges(cfg.SIPACLAllow); err != nil {
+		return nil, fmt.Errorf("invalid SIP_ACL_ALLOW: %w", err)
+	}
+	if a.deny, err = parseRanges(cfg.SIPACLDeny); err != nil {
+		return nil, fmt.Errorf("invalid SIP_ACL_DENY: %w", err)
+	}
+	a.rules.Store(&rules{allow: a.allow, deny: a.deny})
+	return a, nil
+}
+
+// parseRanges parses a comma-separated list of CIDRs
+func parseRanges(list string) ([]netip.Prefix, error) {
+	var ranges []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		prefix, err := models.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, prefix)
+	}
+	return ranges, nil
+}
+
+// Rejects reports whether refused requests are answered 403 rather than
+// dropped
+func (a *ACL) Rejects() bool {
+	return a.reject
+}
+
+// Check decides whether addr may send requests. Global deny ranges win;
+// then a trunk's ranges, less its deny ranges, and the global allow ranges
+// let it through. A source only in a trunk's deny ranges is refused, and
+// one in no range at all gets the default policy.
+func (a *ACL) Check(addr netip.Addr) Decision {
+	addr = addr.Unmap()
+	r := a.rules.Load()
+	if contains(r.deny, addr) {
+		return Decision{}
+	}
+
+	carvedOut := false
+	for _, t := range r.trunks {
+		if !contains(t.allow, addr) {
+			continue
+		}
+		if contains(t.deny, addr) {
+			carvedOut = true
+			continue
+		}
+		return Decision{Allowed: true, TrunkID: t.id}
+	}
+
+	if contains(r.allow, addr) {
+		return Decision{Allowed: true}
+	}
+	return Decision{Allowed: !carvedOut && !a.defaultDeny}
+}
+
+// contains reports whether addr is in any of the ranges
+func contains(ranges []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range ranges {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads the managed entries from the database
+func (a *ACL) Load(ctx context.Context) error {
+	entries, err := a.store.ListACLEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	r := &rules{
+		allow: append([]netip.Prefix(nil), a.allow...),
+		deny:  append([]netip.Prefix(nil), a.deny...),
+	}
+	trunks := make(map[string]*trunkRanges)
+	for _, entry := range entries {
+		prefix, err := models.ParseCIDR(entry.CIDR)
+		if err != nil {
+			logger.Warn("Skipping invalid ACL entry", "id", entry.ID, "error", err)
+			continue
+		}
+
+		allow, deny := &r.allow, &r.deny
+		if entry.TrunkID != nil {
+			t := trunks[*entry.TrunkID]
+			if t == nil {
+				t = &trunkRanges{id: *entry.TrunkID}
+				trunks[t.id] = t
+				r.trunks = append(r.trunks, t)
+			}
+			allow, deny = &t.allow, &t.deny
+		}
+		if entry.Action == models.ACLDeny {
+			*deny = append(*deny, prefix)
+		} else {
+			*allow = append(*allow, prefix)
+		}
+	}
+
+	a.rules.Store(r)
+	return nil
+}
+
+// Create validates and saves an entry, and applies it on this instance at
+// once
+func (a *ACL) Create(ctx context.Context, entry *models.ACLEntry) (*models.ACLEntry, error) {
+	if err := entry.Validate(); err != nil {
+		return nil, err
+	}
+	saved, err := a.store.CreateACLEntry(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Load(ctx); err != nil {
+		return nil, err
+	}
+	log := logger.With("id", saved.ID, "action", saved.Action, "cidr", saved.CIDR)
+	if saved.TrunkID != nil {
+		log = log.With("trunk_id", *saved.TrunkID)
+	}
+	log.Info("ACL entry added")
+	return saved, nil
+}
+
+// Delete removes an entry, on this instance at once
+func (a *ACL) Delete(ctx context.Context, id string) error {
+	if err := a.store.DeleteACLEntry(ctx, id); err != nil {
+		return err
+	}
+	logger.Info("ACL entry removed", "id", id)
+	return a.Load(ctx)
+}
+
+// Run reloads the entries periodically until ctx is cancelled
+func (a *ACL) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.Load(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to load ACL entries", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
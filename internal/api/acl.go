@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// pgForeignKeyViolation is the Postgres error code of a foreign key
+// constraint violation
+const pgForeignKeyViolation = "23503"
+
+// CreateACLEntryRequest is the request body for adding an ACL entry
+type CreateACLEntryRequest struct {
+	TrunkID     *string `json:"trunk_id,omitempty"`                               // Makes the range one of the trunk's carrier; global when omitted
+	Action      string  `json:"action" binding:"required" example:"allow"`        // allow or deny
+	CIDR        string  `json:"cidr" binding:"required" example:"203.0.113.0/24"` // A bare address is a range of one
+	Description *string `json:"description,omitempty" example:"Carrier SBCs (east)"`
+}
+
+// AdminListACLEntries godoc
+// @Summary List ACL entries
+// @Description List the SIP access control list entries managed through the API: global ones and those of active trunks. Ranges set by SIP_ACL_ALLOW and SIP_ACL_DENY aren't included. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Success 200 {array} models.ACLEntry
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/acl [get]
+func (h *Handler) AdminListACLEntries(c *gin.Context) {
+	entries, err := h.store.ListACLEntries(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to list ACL entries", Details: err.Error()})
+		return
+	}
+	if entries == nil {
+		entries = []*models.ACLEntry{}
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// AdminCreateACLEntry godoc
+// @Summary Add an ACL entry
+// @Description Allow or deny INVITEs and OPTIONS from an address range, for every caller or as one of a trunk's carrier ranges. INVITEs from a trunk's ranges are taken as the trunk's, less its deny ranges. This instance applies the entry at once, the others within 15 seconds. Requires the admin API key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security AdminAuth
+// @Param entry body CreateACLEntryRequest true "ACL entry"
+// @Success 201 {object} models.ACLEntry
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/acl [post]
+func (h *Handler) AdminCreateACLEntry(c *gin.Context) {
+	var req CreateACLEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	entry := &models.ACLEntry{TrunkID: req.TrunkID, Action: req.Action, CIDR: req.CIDR, Description: req.Description}
+	if err := entry.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if entry.TrunkID != nil {
+		if _, err := uuid.Parse(*entry.TrunkID); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Trunk not found"})
+			return
+		}
+	}
+
+	created, err := h.acl.Create(c.Request.Context(), entry)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Trunk not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to add ACL entry", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// AdminDeleteACLEntry godoc
+// @Summary Remove an ACL entry
+// @Description Remove an entry from the SIP access control list, on this instance at once and on the others within 15 seconds. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Param id path string true "ACL entry ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/acl/{id} [delete]
+func (h *Handler) AdminDeleteACLEntry(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "ACL entry not found"})
+		return
+	}
+
+	err := h.acl.Delete(c.Request.Context(), id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "ACL entry not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to remove ACL entry", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "ACL entry removed successfully"})
+}
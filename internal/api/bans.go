@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/scanner"
+)
+
+// CreateBanRequest is the request body for banning a SIP source
+type CreateBanRequest struct {
+	Address         string `json:"address" binding:"required" example:"198.51.100.23"`
+	Reason          string `json:"reason,omitempty" example:"Toll fraud attempts"`
+	DurationSeconds int    `json:"duration_seconds,omitempty" example:"86400"` // SCANNER_BAN_DURATION when omitted
+}
+
+// AdminListBans godoc
+// @Summary List banned SIP sources
+// @Description List the source addresses banned by scanner protection, whose SIP requests every instance drops until the ban expires. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Success 200 {array} models.Ban
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/bans [get]
+func (h *Handler) AdminListBans(c *gin.Context) {
+	if h.guard == nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Scanner protection is disabled"})
+		return
+	}
+
+	bans, err := h.guard.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to list bans", Details: err.Error()})
+		return
+	}
+	if bans == nil {
+		bans = []*models.Ban{}
+	}
+	c.JSON(http.StatusOK, bans)
+}
+
+// AdminCreateBan godoc
+// @Summary Ban a SIP source
+// @Description Drop the SIP requests of a source address on every instance until the ban expires, replacing any ban it has. Requires the admin API key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security AdminAuth
+// @Param ban body CreateBanRequest true "Ban"
+// @Success 201 {object} models.Ban
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/bans [post]
+func (h *Handler) AdminCreateBan(c *gin.Context) {
+	if h.guard == nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Scanner protection is disabled"})
+		return
+	}
+
+	var req CreateBanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	addr, err := netip.ParseAddr(req.Address)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "address must be an IP address"})
+		return
+	}
+	if req.DurationSeconds < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "duration_seconds must not be negative"})
+		return
+	}
+	duration := h.config.ScannerBanDuration
+	if req.DurationSeconds > 0 {
+		duration = time.Duration(req.DurationSeconds) * time.Second
+	}
+	if req.Reason == "" {
+		req.Reason = "banned through the admin API"
+	}
+
+	ban, err := h.guard.Ban(c.Request.Context(), addr.Unmap().String(), req.Reason, duration)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to ban address", Details: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, ban)
+}
+
+// AdminDeleteBan godoc
+// @Summary Unban a SIP source
+// @Description Lift the ban of a source address, on every instance within 10 seconds, and reset its failed request count. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Param address path string true "Banned address"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/bans/{address} [delete]
+func (h *Handler) AdminDeleteBan(c *gin.Context) {
+	if h.guard == nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Scanner protection is disabled"})
+		return
+	}
+
+	addr, err := netip.ParseAddr(c.Param("address"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Ban not found"})
+		return
+	}
+
+	err = h.guard.Unban(c.Request.Context(), addr.Unmap().String())
+	if errors.Is(err, scanner.ErrNotBanned) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Ban not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to lift ban", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Ban lifted successfully"})
+}
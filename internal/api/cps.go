@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiv6146/blayzen-sip/internal/cps"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// CPSLimitsResponse is the INVITE rate limits changed at runtime and the
+// limits new calls are held to as a result
+type CPSLimitsResponse struct {
+	Overrides *models.CPSLimits    `json:"overrides"`
+	Effective *cps.EffectiveLimits `json:"effective"`
+}
+
+// AdminGetCPSLimits godoc
+// @Summary Get the INVITE rate limits
+// @Description Return the calls-per-second limits changed at runtime and those in effect on the instance answering the request: new INVITEs from one source address, and to one account's routes, with the bursts allowed above them. A rate of 0 doesn't limit. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Success 200 {object} CPSLimitsResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/admin/cps-limits [get]
+func (h *Handler) AdminGetCPSLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, CPSLimitsResponse{
+		Overrides: h.cps.Overrides(),
+		Effective: h.cps.Get(),
+	})
+}
+
+// AdminSetCPSLimits godoc
+// @Summary Change the INVITE rate limits
+// @Description Replace the calls-per-second limits changed at runtime, without a restart: fields left out revert to the configured values, a rate of 0 lifts a limit and a burst of 0 is one second's worth of calls. Every instance applies the limits to the calls it receives: this one at once, the others within 15 seconds. INVITEs over a limit are answered 503 with Retry-After. Requires the admin API key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security AdminAuth
+// @Param limits body models.CPSLimits true "INVITE rate limits"
+// @Success 200 {object} CPSLimitsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/cps-limits [put]
+func (h *Handler) AdminSetCPSLimits(c *gin.Context) {
+	var req models.CPSLimits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	req.UpdatedAt = nil
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	overrides, err := h.cps.Set(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save INVITE rate limits", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, CPSLimitsResponse{Overrides: overrides, Effective: h.cps.Get()})
+}
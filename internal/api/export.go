@@ -0,0 +1,384 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/storage"
+)
+
+// Call export formats
+const (
+	exportCSV    = "csv"
+	exportNDJSON = "ndjson"
+)
+
+// exportFlushRows is how many records are written between flushes to the
+// client, so exports stream steadily instead of in buffer-sized bursts
+const exportFlushRows = 500
+
+// cdrColumns are the CSV columns of exported calls
+var cdrColumns = []string{
+	"id", "call_id", "direction", "status", "from_uri", "to_uri", "from_user", "to_user",
+	"route_id", "trunk_id", "websocket_url", "call_priority",
+	"initiated_at", "ringing_at", "answered_at", "ended_at", "duration_seconds",
+	"hangup_cause", "hangup_party", "amd_result", "offered_media",
+	"transfer_target", "transferred_at", "recording_duration_ms", "media_bytes_in", "media_bytes_out", "conversation_id", "agent_region", "split_target", "custom_data", "created_at",
+}
+
+// ExportCalls godoc
+// @Summary Export calls
+// @Description Stream all of the account's call detail records matching the filters, oldest first unless sorted otherwise, as CSV (with a header row) or newline-delimited JSON. Timestamps are in the account's timezone. A transfer cut short means the export failed midway and should be retried.
+// @Tags Calls
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param format query string false "csv or ndjson" default(csv)
+// @Param sort query string false "Order: created_at, -created_at, duration or -duration" default(created_at)
+// @Param from query string false "First day (YYYY-MM-DD, in the account's timezone)"
+// @Param to query string false "Last day, inclusive (YYYY-MM-DD, in the account's timezone)"
+// @Param direction query string false "inbound or outbound"
+// @Param status query string false "Call status"
+// @Param from_user query string false "Caller number"
+// @Param to_user query string false "Called number"
+// @Param route_id query string false "Route ID"
+// @Param trunk_id query string false "Trunk ID"
+// @Param conversation_id query string false "Conversation ID"
+// @Success 200 {file} file "The call records"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls/export [get]
+func (h *Handler) ExportCalls(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	loc := accountLocation(c)
+
+	filter, err := callFilter(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", exportCSV)
+	buf := bufio.NewWriterSize(c.Writer, 32<<10)
+	w, contentType, err := newCDRWriter(format, buf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	c.Header("Content-Type", contentType)
+
+	filename := exportFilename(format, loc)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("X-Accel-Buffering", "no") // Don't let nginx buffer the export
+	c.Status(http.StatusOK)
+
+	flush := func() error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	rows := 0
+	err = h.store.ExportCalls(c.Request.Context(), accountID, filter, func(call *models.CallLog) error {
+		call.Localize(loc)
+		if err := w.Write(call); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		return
+	}
+
+	logger.Error("Call export failed", "account_id", accountID, "rows", rows, "error", err)
+	if !c.Writer.Written() {
+		// Nothing sent yet, so the failure can still be reported
+		c.Writer.Header().Del("Content-Disposition")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to export calls", Details: err.Error()})
+		return
+	}
+	abortStream(c)
+}
+
+// abortStream cuts the connection of a response already under way, so the
+// client sees a truncated transfer instead of a complete one. HTTP/2
+// connections can't be cut and end normally.
+func abortStream(c *gin.Context) {
+	if conn, _, err := c.Writer.Hijack(); err == nil {
+		_ = conn.Close()
+	}
+}
+
+// SaveCallExport godoc
+// @Summary Save a call export
+// @Description Export the account's call detail records matching the filters, as GET /api/v1/calls/export does, to storage instead of the response, for download later. Saved exports are deleted after EXPORT_RETENTION_DAYS.
+// @Tags Calls
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param format query string false "csv or ndjson" default(csv)
+// @Param sort query string false "Order: created_at, -created_at, duration or -duration" default(created_at)
+// @Param from query string false "First day (YYYY-MM-DD, in the account's timezone)"
+// @Param to query string false "Last day, inclusive (YYYY-MM-DD, in the account's timezone)"
+// @Param direction query string false "inbound or outbound"
+// @Param status query string false "Call status"
+// @Param from_user query string false "Caller number"
+// @Param to_user query string false "Called number"
+// @Param route_id query string false "Route ID"
+// @Param trunk_id query string false "Trunk ID"
+// @Param conversation_id query string false "Conversation ID"
+// @Success 201 {object} SavedExport
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls/exports [post]
+func (h *Handler) SaveCallExport(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	loc := accountLocation(c)
+
+	filter, err := callFilter(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", exportCSV)
+	var data bytes.Buffer
+	buf := bufio.NewWriterSize(&data, 32<<10)
+	w, contentType, err := newCDRWriter(format, buf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	err = h.store.ExportCalls(c.Request.Context(), accountID, filter, func(call *models.CallLog) error {
+		call.Localize(loc)
+		return w.Write(call)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to export calls", Details: err.Error()})
+		return
+	}
+
+	name := exportFilename(format, loc)
+	if _, err := h.blobs.Put(c.Request.Context(), exportKey(accountID, name), data.Bytes(), contentType); err != nil {
+		logger.Error("Failed to save call export", "account_id", accountID, "error", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save export", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, h.savedExport(name, int64(data.Len()), time.Now(), loc))
+}
+
+// ListCallExports godoc
+// @Summary List saved call exports
+// @Description List the account's saved call exports, newest first
+// @Tags Calls
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {array} SavedExport
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls/exports [get]
+func (h *Handler) ListCallExports(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	loc := accountLocation(c)
+
+	exports := []SavedExport{}
+	prefix := exportKey(accountID, "") + "/"
+	err := h.blobs.List(c.Request.Context(), prefix, func(object storage.Object) error {
+		name := strings.TrimPrefix(object.Key, prefix)
+		exports = append(exports, h.savedExport(name, object.Size, object.Modified, loc))
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to list exports", Details: err.Error()})
+		return
+	}
+
+	sort.Slice(exports, func(i, j int) bool { return exports[i].CreatedAt.After(exports[j].CreatedAt) })
+	c.JSON(http.StatusOK, exports)
+}
+
+// GetCallExport godoc
+// @Summary Download a saved call export
+// @Description Download a saved call export, redirecting to a presigned URL valid for 15 minutes when exports are kept in object storage
+// @Tags Calls
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param name path string true "Export name"
+// @Success 200 {file} file "The call records"
+// @Success 302 "Redirect to the export"
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/calls/exports/{name} [get]
+func (h *Handler) GetCallExport(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	name := c.Param("name")
+	if !exportNamePattern.MatchString(name) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Export not found"})
+		return
+	}
+
+	h.serveBlob(c, h.blobs.Location(exportKey(accountID, name)), name)
+}
+
+// SavedExport is a call export saved to storage
+type SavedExport struct {
+	Name      string     `json:"name" example:"calls-20260115-093000.csv"`
+	Size      int64      `json:"size" example:"52311"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // When deleted, unless kept
+}
+
+// savedExport describes an export saved at a time, in the account's timezone
+func (h *Handler) savedExport(name string, size int64, created time.Time, loc *time.Location) SavedExport {
+	export := SavedExport{Name: name, Size: size, CreatedAt: created.In(loc)}
+	if days := h.config.ExportRetentionDays; days > 0 {
+		expires := export.CreatedAt.AddDate(0, 0, days)
+		export.ExpiresAt = &expires
+	}
+	return export
+}
+
+// exportNamePattern matches the names exports are saved under
+var exportNamePattern = regexp.MustCompile(`^calls-\d{8}-\d{6}\.(csv|ndjson)$`)
+
+// exportFilename names an export made now, in the account's timezone
+func exportFilename(format string, loc *time.Location) string {
+	return fmt.Sprintf("calls-%s.%s", time.Now().In(loc).Format("20060102-150405"), format)
+}
+
+// exportKey returns the storage key of an account's saved export
+func exportKey(accountID, name string) string {
+	return path.Join(storage.PrefixExports, accountID, name)
+}
+
+// cdrWriter writes exported calls
+type cdrWriter interface {
+	Write(call *models.CallLog) error
+	Flush() error // Writes buffered records out
+}
+
+// newCDRWriter returns the writer of an export format to buf, and the
+// export's content type
+func newCDRWriter(format string, buf *bufio.Writer) (cdrWriter, string, error) {
+	switch format {
+	case exportCSV:
+		return newCSVWriter(buf), "text/csv; charset=utf-8", nil
+	case exportNDJSON:
+		return &ndjsonWriter{buf: buf, enc: json.NewEncoder(buf)}, "application/x-ndjson", nil
+	default:
+		return nil, "", fmt.Errorf("format: must be csv or ndjson")
+	}
+}
+
+// csvWriter writes calls as CSV rows after a header row
+type csvWriter struct {
+	buf *bufio.Writer
+	csv *csv.Writer
+}
+
+func newCSVWriter(buf *bufio.Writer) *csvWriter {
+	w := &csvWriter{buf: buf, csv: csv.NewWriter(buf)}
+	_ = w.csv.Write(cdrColumns)
+	return w
+}
+
+func (w *csvWriter) Write(call *models.CallLog) error {
+	var customData string
+	if len(call.CustomData) > 0 {
+		data, err := json.Marshal(call.CustomData)
+		if err != nil {
+			return err
+		}
+		customData = string(data)
+	}
+
+	return w.csv.Write([]string{
+		call.ID, call.CallID, string(call.Direction), string(call.Status),
+		call.FromURI, call.ToURI, call.FromUser, call.ToUser,
+		formatString(call.RouteID), formatString(call.TrunkID), call.WebSocketURL, strconv.Itoa(call.CallPriority),
+		formatTime(&call.InitiatedAt), formatTime(call.RingingAt), formatTime(call.AnsweredAt),
+		formatTime(call.EndedAt), formatInt(call.DurationSeconds),
+		formatString(call.HangupCause), formatString(call.HangupParty), formatString(call.AMDResult),
+		strings.Join(call.OfferedMedia, " "), formatString(call.TransferTarget), formatTime(call.TransferredAt),
+		formatInt(call.RecordingDurationMs), formatInt(call.MediaBytesIn), formatInt(call.MediaBytesOut), formatString(call.ConversationID), formatString(call.AgentRegion), formatString(call.SplitTarget), customData, formatTime(&call.CreatedAt),
+	})
+}
+
+func (w *csvWriter) Flush() error {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return err
+	}
+	return w.buf.Flush()
+}
+
+// ndjsonWriter writes calls as JSON objects, one per line, as the calls API
+// returns them
+type ndjsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(call *models.CallLog) error {
+	return w.enc.Encode(call)
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+// formatString formats an optional string for CSV
+func formatString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// formatTime formats an optional timestamp for CSV
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// formatInt formats an optional number for CSV
+func formatInt[T int | int64](n *T) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.FormatInt(int64(*n), 10)
+}
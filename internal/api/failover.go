@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminGetFailover godoc
+// @Summary Get the failover status
+// @Description Return the mode of the instance answering the request, its role in its active/standby pair and the pair's active instance with its last heartbeat. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Success 200 {object} failover.Status
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/failover [get]
+func (h *Handler) AdminGetFailover(c *gin.Context) {
+	status, err := h.pair.Status(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get failover status", Details: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// AdminPromote godoc
+// @Summary Take over as active instance
+// @Description Make the standby instance answering the request take over SIP from the active one at once, without waiting for its heartbeat to stop, e.g. from a VRRP notify script once the virtual IP moved. The active instance steps down on its next heartbeat. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Success 200 {object} SuccessResponse "Already active"
+// @Success 202 {object} SuccessResponse "Taking over"
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/admin/failover/promote [post]
+func (h *Handler) AdminPromote(c *gin.Context) {
+	if !h.pair.Enabled() {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Instance is not part of an active/standby pair"})
+		return
+	}
+	if h.pair.IsActive() {
+		c.JSON(http.StatusOK, SuccessResponse{Message: "Instance is already active"})
+		return
+	}
+
+	h.pair.Promote()
+	c.JSON(http.StatusAccepted, SuccessResponse{Message: "Taking over as active instance"})
+}
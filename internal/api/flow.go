@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Call flow SVG layout (pixels)
+const (
+	flowColumnWidth = 260
+	flowMarginX     = 80
+	flowHeaderY     = 40
+	flowFirstRowY   = 90
+	flowRowHeight   = 40
+)
+
+// renderCallFlowSVG renders a call flow as a ladder diagram
+func renderCallFlowSVG(flow *models.CallFlow) []byte {
+	columns := make(map[string]int, len(flow.Parties))
+	for i, party := range flow.Parties {
+		columns[party] = flowMarginX + i*flowColumnWidth
+	}
+
+	width := flowMarginX*2 + max(len(flow.Parties)-1, 0)*flowColumnWidth
+	height := flowFirstRowY + len(flow.Messages)*flowRowHeight + flowRowHeight
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="12">`, width, height)
+	b.WriteString(`<defs><marker id="arrow" markerWidth="10" markerHeight="7" refX="10" refY="3.5" orient="auto"><polygon points="0 0, 10 3.5, 0 7"/></marker></defs>`)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`, width, height)
+
+	// Party headers and lifelines
+	for _, party := range flow.Parties {
+		x := columns[party]
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle" font-weight="bold">%s</text>`, x, flowHeaderY, html.EscapeString(party))
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999" stroke-dasharray="4"/>`, x, flowHeaderY+10, x, height-10)
+	}
+
+	// Messages
+	for i, m := range flow.Messages {
+		y := flowFirstRowY + i*flowRowHeight
+		x1, x2 := columns[m.From], columns[m.To]
+
+		color := "#1f6feb"
+		if strings.HasPrefix(m.Label, "4") || strings.HasPrefix(m.Label, "5") || strings.HasPrefix(m.Label, "6") {
+			color = "#d1242f"
+		}
+
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" marker-end="url(#arrow)"/>`, x1, y, x2, y, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle" fill="%s">%s</text>`, (x1+x2)/2, y-5, color, html.EscapeString(m.Label))
+		fmt.Fprintf(&b, `<text x="5" y="%d" fill="#666">+%dms</text>`, y+4, m.OffsetMs)
+	}
+
+	b.WriteString(`</svg>`)
+	return []byte(b.String())
+}
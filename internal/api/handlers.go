@@ -2,24 +2,80 @@
 package api
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/shiv6146/blayzen-sip/internal/acl"
+	"github.com/shiv6146/blayzen-sip/internal/apikey"
+	"github.com/shiv6146/blayzen-sip/internal/apitoken"
+	"github.com/shiv6146/blayzen-sip/internal/call"
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/cps"
+	"github.com/shiv6146/blayzen-sip/internal/eventstream"
+	"github.com/shiv6146/blayzen-sip/internal/failover"
 	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/privacy"
+	"github.com/shiv6146/blayzen-sip/internal/routing"
+	"github.com/shiv6146/blayzen-sip/internal/scanner"
+	"github.com/shiv6146/blayzen-sip/internal/softphone"
+	"github.com/shiv6146/blayzen-sip/internal/storage"
 	"github.com/shiv6146/blayzen-sip/internal/store"
+	"github.com/shiv6146/blayzen-sip/internal/trace"
+	"github.com/shiv6146/blayzen-sip/internal/version"
+	"github.com/shiv6146/blayzen-sip/internal/webhook"
 )
 
 // Handler holds the API dependencies
 type Handler struct {
-	store *store.PostgresStore
-	cache *store.Cache
+	config    *config.Config
+	store     *store.PostgresStore
+	cache     *store.Cache
+	blobs     storage.Store
+	softphone *softphone.Gateway
+	calls     *call.Manager
+	defaults  *routing.Defaults
+	overrides *routing.NumberOverrides
+	acl       *acl.ACL
+	guard     *scanner.Guard
+	cps       *cps.Limiter
+	pair      *failover.Pair
+	tokens    *apitoken.Issuer
+
+	// Route maintenance windows, applied on this instance as scheduled
+	maintenance *routing.Maintenance
 }
 
-// NewHandler creates a new API handler
-func NewHandler(store *store.PostgresStore, cache *store.Cache) *Handler {
+// NewHandler creates a new API handler. phone may be nil when the browser
+// softphone is disabled, guard when scanner protection is and tokens when
+// bearer tokens are; blobs keeps recordings and saved exports.
+func NewHandler(cfg *config.Config, store *store.PostgresStore, cache *store.Cache, blobs storage.Store, phone *softphone.Gateway, calls *call.Manager, defaults *routing.Defaults, overrides *routing.NumberOverrides, maintenance *routing.Maintenance, sourceACL *acl.ACL, guard *scanner.Guard, limiter *cps.Limiter, pair *failover.Pair, tokens *apitoken.Issuer) *Handler {
 	return &Handler{
-		store: store,
-		cache: cache,
+		config:    cfg,
+		store:     store,
+		cache:     cache,
+		blobs:     blobs,
+		softphone: phone,
+		calls:     calls,
+		defaults:  defaults,
+		overrides: overrides,
+		acl:       sourceACL,
+		guard:     guard,
+		cps:       limiter,
+		pair:      pair,
+		tokens:    tokens,
+
+		maintenance: maintenance,
 	}
 }
 
@@ -29,56 +85,141 @@ func NewHandler(store *store.PostgresStore, cache *store.Cache) *Handler {
 
 // CreateRouteRequest is the request body for creating a route
 type CreateRouteRequest struct {
-	Name                string                 `json:"name" binding:"required" example:"Support Line"`
-	Priority            int                    `json:"priority" example:"10"`
-	MatchToUser         *string                `json:"match_to_user,omitempty" example:"1000"`
-	MatchFromUser       *string                `json:"match_from_user,omitempty" example:"+14155551234"`
-	MatchSIPHeader      *string                `json:"match_sip_header,omitempty" example:"X-Customer-Tier"`
-	MatchSIPHeaderValue *string                `json:"match_sip_header_value,omitempty" example:"vip"`
-	WebSocketURL        string                 `json:"websocket_url" binding:"required" example:"ws://agent:8081/ws"`
-	CustomData          map[string]interface{} `json:"custom_data,omitempty"`
+	Name                  string                   `json:"name" binding:"required" example:"Support Line"`
+	Priority              int                      `json:"priority" example:"10"`
+	MatchToUser           *string                  `json:"match_to_user,omitempty" example:"1000"`
+	MatchFromUser         *string                  `json:"match_from_user,omitempty" example:"+14155551234"`
+	MatchToUserType       string                   `json:"match_to_user_type,omitempty" example:"exact"`     // exact (default), prefix, regex or pattern
+	MatchFromUserType     string                   `json:"match_from_user_type,omitempty" example:"pattern"` // exact (default), prefix, regex or pattern
+	MatchSIPHeader        *string                  `json:"match_sip_header,omitempty" example:"X-Customer-Tier"`
+	MatchSIPHeaderValue   *string                  `json:"match_sip_header_value,omitempty" example:"vip"`
+	MatchHeaders          []models.HeaderCondition `json:"match_headers,omitempty"`
+	MatchGroups           []models.MatchGroup      `json:"match_groups,omitempty"`
+	WebSocketURL          string                   `json:"websocket_url" binding:"required" example:"ws://agent:8081/ws"`
+	AgentURLs             []string                 `json:"agent_urls,omitempty"`
+	AgentLBStrategy       string                   `json:"agent_lb_strategy,omitempty" example:"round_robin"`
+	FallbackWebSocketURLs []string                 `json:"fallback_websocket_urls,omitempty"`
+	AgentRegions          []models.AgentRegionPool `json:"agent_regions,omitempty"`                   // Tried before agent_urls, local region first
+	AgentSplits           []models.AgentSplit      `json:"agent_splits,omitempty"`                    // Weighted agents tried first, for A/B tests
+	SplitStrategy         string                   `json:"split_strategy,omitempty" example:"random"` // random (default) or caller
+	CustomData            map[string]interface{}   `json:"custom_data,omitempty"`
+	AudioFormat           *models.AudioFormat      `json:"audio_format,omitempty"`
+	AgentProtocol         string                   `json:"agent_protocol,omitempty" example:"exotel"`
+	CallPriority          int                      `json:"call_priority" example:"0"`
+	BinaryAudio           bool                     `json:"binary_audio" example:"false"`
+	AgentAuth             *models.AgentAuth        `json:"agent_auth,omitempty"`
+	DetectHuman           bool                     `json:"detect_human" example:"false"`
+	Record                bool                     `json:"record" example:"false"`
+	HeaderRules           []models.HeaderRule      `json:"header_rules,omitempty"`
+	Ringback              *string                  `json:"ringback,omitempty" example:"tone:us"`
+	FaxPolicy             string                   `json:"fax_policy,omitempty" example:"ignore"`
+	FaxTarget             *string                  `json:"fax_target,omitempty" example:"sip:fax@fax.example.com"`
+	Language              *string                  `json:"language,omitempty" example:"es-MX"`
+	EarlyMedia            bool                     `json:"early_media" example:"false"`
+	ConnectRetry          *models.ConnectRetry     `json:"connect_retry,omitempty"`
+	MaxConcurrentCalls    *int                     `json:"max_concurrent_calls,omitempty" example:"10"` // Unlimited when omitted
+	MaxBitrateKbps        *int                     `json:"max_bitrate_kbps,omitempty" example:"80"`     // Per call, each way; unlimited when omitted
+	DTMFShortcuts         []models.DTMFShortcut    `json:"dtmf_shortcuts,omitempty"`
+	Schedule              *models.RouteSchedule    `json:"schedule,omitempty"` // Any time when omitted
 }
 
 // UpdateRouteRequest is the request body for updating a route
 type UpdateRouteRequest struct {
-	Name                string                 `json:"name" binding:"required" example:"Support Line"`
-	Priority            int                    `json:"priority" example:"10"`
-	MatchToUser         *string                `json:"match_to_user,omitempty" example:"1000"`
-	MatchFromUser       *string                `json:"match_from_user,omitempty" example:"+14155551234"`
-	MatchSIPHeader      *string                `json:"match_sip_header,omitempty" example:"X-Customer-Tier"`
-	MatchSIPHeaderValue *string                `json:"match_sip_header_value,omitempty" example:"vip"`
-	WebSocketURL        string                 `json:"websocket_url" binding:"required" example:"ws://agent:8081/ws"`
-	CustomData          map[string]interface{} `json:"custom_data,omitempty"`
-	Active              bool                   `json:"active" example:"true"`
+	Name                  string                   `json:"name" binding:"required" example:"Support Line"`
+	Priority              int                      `json:"priority" example:"10"`
+	MatchToUser           *string                  `json:"match_to_user,omitempty" example:"1000"`
+	MatchFromUser         *string                  `json:"match_from_user,omitempty" example:"+14155551234"`
+	MatchToUserType       string                   `json:"match_to_user_type,omitempty" example:"exact"`     // exact (default), prefix, regex or pattern
+	MatchFromUserType     string                   `json:"match_from_user_type,omitempty" example:"pattern"` // exact (default), prefix, regex or pattern
+	MatchSIPHeader        *string                  `json:"match_sip_header,omitempty" example:"X-Customer-Tier"`
+	MatchSIPHeaderValue   *string                  `json:"match_sip_header_value,omitempty" example:"vip"`
+	MatchHeaders          []models.HeaderCondition `json:"match_headers,omitempty"`
+	MatchGroups           []models.MatchGroup      `json:"match_groups,omitempty"`
+	WebSocketURL          string                   `json:"websocket_url" binding:"required" example:"ws://agent:8081/ws"`
+	AgentURLs             []string                 `json:"agent_urls,omitempty"`
+	AgentLBStrategy       string                   `json:"agent_lb_strategy,omitempty" example:"round_robin"`
+	FallbackWebSocketURLs []string                 `json:"fallback_websocket_urls,omitempty"`
+	AgentRegions          []models.AgentRegionPool `json:"agent_regions,omitempty"`                   // Tried before agent_urls, local region first
+	AgentSplits           []models.AgentSplit      `json:"agent_splits,omitempty"`                    // Weighted agents tried first, for A/B tests
+	SplitStrategy         string                   `json:"split_strategy,omitempty" example:"random"` // random (default) or caller
+	CustomData            map[string]interface{}   `json:"custom_data,omitempty"`
+	AudioFormat           *models.AudioFormat      `json:"audio_format,omitempty"`
+	AgentProtocol         string                   `json:"agent_protocol,omitempty" example:"exotel"`
+	CallPriority          int                      `json:"call_priority" example:"0"`
+	BinaryAudio           bool                     `json:"binary_audio" example:"false"`
+	AgentAuth             *models.AgentAuth        `json:"agent_auth,omitempty"`
+	DetectHuman           bool                     `json:"detect_human" example:"false"`
+	Record                bool                     `json:"record" example:"false"`
+	HeaderRules           []models.HeaderRule      `json:"header_rules,omitempty"`
+	Ringback              *string                  `json:"ringback,omitempty" example:"tone:us"`
+	FaxPolicy             string                   `json:"fax_policy,omitempty" example:"ignore"`
+	FaxTarget             *string                  `json:"fax_target,omitempty" example:"sip:fax@fax.example.com"`
+	Language              *string                  `json:"language,omitempty" example:"es-MX"`
+	EarlyMedia            bool                     `json:"early_media" example:"false"`
+	ConnectRetry          *models.ConnectRetry     `json:"connect_retry,omitempty"`
+	MaxConcurrentCalls    *int                     `json:"max_concurrent_calls,omitempty" example:"10"` // Unlimited when omitted
+	MaxBitrateKbps        *int                     `json:"max_bitrate_kbps,omitempty" example:"80"`     // Per call, each way; unlimited when omitted
+	DTMFShortcuts         []models.DTMFShortcut    `json:"dtmf_shortcuts,omitempty"`
+	Schedule              *models.RouteSchedule    `json:"schedule,omitempty"` // Any time when omitted
+	Active                bool                     `json:"active" example:"true"`
 }
 
 // CreateTrunkRequest is the request body for creating a trunk
 type CreateTrunkRequest struct {
-	Name             string  `json:"name" binding:"required" example:"Primary Trunk"`
-	Host             string  `json:"host" binding:"required" example:"sip.provider.com"`
-	Port             int     `json:"port" example:"5060"`
-	Transport        string  `json:"transport" example:"udp"`
-	Username         *string `json:"username,omitempty" example:"user"`
-	Password         *string `json:"password,omitempty" example:"secret"`
-	FromUser         *string `json:"from_user,omitempty" example:"+14155551234"`
-	FromHost         *string `json:"from_host,omitempty" example:"sip.provider.com"`
-	Register         bool    `json:"register" example:"false"`
-	RegisterInterval int     `json:"register_interval" example:"3600"`
+	Name             string              `json:"name" binding:"required" example:"Primary Trunk"`
+	Host             string              `json:"host" binding:"required" example:"sip.provider.com"`
+	Port             int                 `json:"port" example:"5060"`
+	Transport        string              `json:"transport" example:"udp"`
+	Username         *string             `json:"username,omitempty" example:"user"`
+	Password         *string             `json:"password,omitempty" example:"secret"`
+	FromUser         *string             `json:"from_user,omitempty" example:"+14155551234"`
+	FromHost         *string             `json:"from_host,omitempty" example:"sip.provider.com"`
+	Register         bool                `json:"register" example:"false"`
+	RegisterInterval int                 `json:"register_interval" example:"3600"`
+	OutboundProxy    *string             `json:"outbound_proxy,omitempty" example:"sbc.example.com:5060"`
+	HeaderRules      []models.HeaderRule `json:"header_rules,omitempty"`
+	SymmetricRTP     *bool               `json:"symmetric_rtp,omitempty" example:"true"`  // Defaults to true
+	MaxBitrateKbps   *int                `json:"max_bitrate_kbps,omitempty" example:"80"` // Per call, each way; unlimited when omitted
 }
 
 // UpdateTrunkRequest is the request body for updating a trunk
 type UpdateTrunkRequest struct {
-	Name             string  `json:"name" binding:"required" example:"Primary Trunk"`
-	Host             string  `json:"host" binding:"required" example:"sip.provider.com"`
-	Port             int     `json:"port" example:"5060"`
-	Transport        string  `json:"transport" example:"udp"`
-	Username         *string `json:"username,omitempty" example:"user"`
-	Password         *string `json:"password,omitempty" example:"secret"`
-	FromUser         *string `json:"from_user,omitempty" example:"+14155551234"`
-	FromHost         *string `json:"from_host,omitempty" example:"sip.provider.com"`
-	Register         bool    `json:"register" example:"false"`
-	RegisterInterval int     `json:"register_interval" example:"3600"`
-	Active           bool    `json:"active" example:"true"`
+	Name             string              `json:"name" binding:"required" example:"Primary Trunk"`
+	Host             string              `json:"host" binding:"required" example:"sip.provider.com"`
+	Port             int                 `json:"port" example:"5060"`
+	Transport        string              `json:"transport" example:"udp"`
+	Username         *string             `json:"username,omitempty" example:"user"`
+	Password         *string             `json:"password,omitempty" example:"secret"`
+	FromUser         *string             `json:"from_user,omitempty" example:"+14155551234"`
+	FromHost         *string             `json:"from_host,omitempty" example:"sip.provider.com"`
+	Register         bool                `json:"register" example:"false"`
+	RegisterInterval int                 `json:"register_interval" example:"3600"`
+	OutboundProxy    *string             `json:"outbound_proxy,omitempty" example:"sbc.example.com:5060"`
+	HeaderRules      []models.HeaderRule `json:"header_rules,omitempty"`
+	SymmetricRTP     *bool               `json:"symmetric_rtp,omitempty" example:"true"`  // Defaults to true
+	MaxBitrateKbps   *int                `json:"max_bitrate_kbps,omitempty" example:"80"` // Per call, each way; unlimited when omitted
+	Active           bool                `json:"active" example:"true"`
+}
+
+// ConfigResponse is the effective configuration of the instance answering
+type ConfigResponse struct {
+	Instance string           `json:"instance" example:"sip-1"`
+	Profile  string           `json:"profile,omitempty" example:"prod"`
+	Settings []config.Setting `json:"settings"`
+}
+
+// RouteSplitStatsResponse is the calls assigned each of a route's agent
+// split targets
+type RouteSplitStatsResponse struct {
+	RouteID string              `json:"route_id"`
+	Targets []*models.SplitStat `json:"targets"`
+}
+
+// TrunkStatsResponse is a trunk's final SIP responses by direction, method
+// and status code
+type TrunkStatsResponse struct {
+	TrunkID   string                      `json:"trunk_id"`
+	Responses []*models.TrunkResponseStat `json:"responses"`
 }
 
 // InitiateCallRequest is the request body for initiating an outbound call
@@ -90,6 +231,140 @@ type InitiateCallRequest struct {
 	CustomData   map[string]interface{} `json:"custom_data,omitempty"`
 }
 
+// SuperviseCallRequest is the request body for supervising a call
+type SuperviseCallRequest struct {
+	Mode string `json:"mode" example:"listen"` // listen (default), whisper or barge
+}
+
+// SuperviseCallResponse holds the WebSocket a supervisor connects to
+type SuperviseCallResponse struct {
+	Mode         string `json:"mode" example:"listen"`
+	WebSocketURL string `json:"websocket_url" example:"ws://localhost:8080/supervise/token"`
+	ExpiresIn    int    `json:"expires_in" example:"30"` // Seconds left to connect
+}
+
+// PlayCallRequest is the request body for playing audio from a URL on a call
+type PlayCallRequest struct {
+	URL       string `json:"url" binding:"required" example:"https://example.com/recording-notice.wav"`
+	Mode      string `json:"mode,omitempty" example:"mix"` // mix (default) or replace
+	Interrupt bool   `json:"interrupt" example:"false"`    // Discard agent audio queued so far
+}
+
+// PlayCallResponse describes audio queued on a call
+type PlayCallResponse struct {
+	DurationMs int64 `json:"duration_ms" example:"4500"`
+}
+
+// TransferCallRequest is the request body for transferring a call
+type TransferCallRequest struct {
+	Target string `json:"target" binding:"required" example:"+14155551234"` // Phone number, or sip:, sips: or tel: URI
+}
+
+// TransferCallResponse confirms a transfer accepted by the caller's side
+type TransferCallResponse struct {
+	Status string `json:"status" example:"transferred"`
+	Target string `json:"target" example:"+14155551234"`
+}
+
+// SoftphoneCallRequest is the request body for calling a route from a browser
+type SoftphoneCallRequest struct {
+	RouteID string `json:"route_id" binding:"required" example:"route-uuid"`
+	From    string `json:"from,omitempty" example:"browser"`
+	SDP     string `json:"sdp" binding:"required"`
+}
+
+// SoftphoneCallResponse is the answer to a browser call
+type SoftphoneCallResponse struct {
+	CallID string `json:"call_id" example:"call-uuid"`
+	SDP    string `json:"sdp"`
+}
+
+// UpdateAccountRequest is the request body for updating account settings.
+// Omitted settings are kept.
+type UpdateAccountRequest struct {
+	Timezone         string           `json:"timezone,omitempty" example:"America/New_York"`
+	AllowedAgentURLs []string         `json:"allowed_agent_urls,omitempty" example:"*.mycompany.com"` // [] allows any agent URL
+	Branding         *models.Branding `json:"branding,omitempty"`                                     // {} restores blayzen-sip's own
+}
+
+// CreateAccountRequest is the request body for creating an account
+type CreateAccountRequest struct {
+	Name               string           `json:"name" binding:"required" example:"Acme Corp"`
+	Timezone           string           `json:"timezone,omitempty" example:"America/New_York"`     // UTC when omitted
+	AllowedAgentURLs   []string         `json:"allowed_agent_urls,omitempty" example:"*.acme.com"` // Any agent URL when omitted
+	Branding           *models.Branding `json:"branding,omitempty"`
+	MaxConcurrentCalls *int             `json:"max_concurrent_calls,omitempty" example:"20"` // Unlimited when omitted
+	CallsPerSecond     *int             `json:"calls_per_second,omitempty" example:"5"`      // The per-account limit when omitted
+	MaxBandwidthKbps   *int             `json:"max_bandwidth_kbps,omitempty" example:"2000"` // Unlimited when omitted
+}
+
+// CreateAccountResponse is a new account and its API key, which is only
+// returned now
+type CreateAccountResponse struct {
+	Account *models.Account `json:"account"`
+	APIKey  *models.APIKey  `json:"api_key"`
+}
+
+// AdminUpdateAccountRequest is the request body for updating an account as
+// an admin. Omitted fields are kept.
+type AdminUpdateAccountRequest struct {
+	Name               string           `json:"name,omitempty" example:"Acme Corp"`
+	Timezone           string           `json:"timezone,omitempty" example:"America/New_York"`
+	AllowedAgentURLs   []string         `json:"allowed_agent_urls,omitempty" example:"*.acme.com"` // [] allows any agent URL
+	Branding           *models.Branding `json:"branding,omitempty"`                                // {} restores blayzen-sip's own
+	MaxConcurrentCalls *int             `json:"max_concurrent_calls,omitempty" example:"20"`       // 0 removes the limit
+	CallsPerSecond     *int             `json:"calls_per_second,omitempty" example:"5"`            // 0 reverts to the per-account limit
+	MaxBandwidthKbps   *int             `json:"max_bandwidth_kbps,omitempty" example:"2000"`       // 0 removes the limit
+	Active             *bool            `json:"active,omitempty" example:"true"`
+}
+
+// CreateAPIKeyRequest is the request body for generating an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name,omitempty" example:"ci"`
+	Scopes    []string   `json:"scopes,omitempty" example:"read"`                     // read and/or calls; full access when omitted
+	ExpiresAt *time.Time `json:"expires_at,omitempty" example:"2026-01-01T00:00:00Z"` // Never expires when omitted
+}
+
+// RotateAPIKeyRequest is the request body for rotating an API key
+type RotateAPIKeyRequest struct {
+	GracePeriod int `json:"grace_period,omitempty" example:"86400"` // Seconds the old key keeps working; revoked now when 0
+}
+
+// IssueTokenRequest is the request body for issuing a bearer token
+type IssueTokenRequest struct {
+	Scopes []string `json:"scopes,omitempty" example:"read"` // Narrows the API key's scopes
+}
+
+// TokenResponse is a bearer token for the API
+type TokenResponse struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type" example:"Bearer"`
+	ExpiresIn   int       `json:"expires_in" example:"900"` // Seconds
+	ExpiresAt   time.Time `json:"expires_at"`
+	Scopes      []string  `json:"scopes,omitempty" example:"read"`
+}
+
+// CreateWebhookRequest is the request body for registering a webhook
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required" example:"https://example.com/hooks/calls"`
+	Secret *string  `json:"secret,omitempty" example:"whsec_..."` // Generated when omitted
+	Events []string `json:"events,omitempty" example:"call.answered,call.completed"`
+}
+
+// UpdateWebhookRequest is the request body for updating a webhook
+type UpdateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required" example:"https://example.com/hooks/calls"`
+	Secret *string  `json:"secret,omitempty" example:"whsec_..."` // Kept when omitted
+	Events []string `json:"events,omitempty" example:"call.answered,call.completed"`
+	Active bool     `json:"active" example:"true"`
+}
+
+// CallNumbersResponse holds the unmasked numbers of a call
+type CallNumbersResponse struct {
+	FromUser string `json:"from_user" example:"+14155555678"`
+	ToUser   string `json:"to_user" example:"+14155551234"`
+}
+
 // ErrorResponse represents an API error
 type ErrorResponse struct {
 	Error   string `json:"error" example:"Invalid request"`
@@ -102,456 +377,2481 @@ type SuccessResponse struct {
 }
 
 // =============================================================================
-// Route Handlers
+// Account Handlers
 // =============================================================================
 
-// ListRoutes godoc
-// @Summary List all routes
-// @Description Get all SIP routing rules for the account
-// @Tags Routes
+// accountLocation returns the authenticated account's timezone, or UTC when
+// API auth is disabled
+func accountLocation(c *gin.Context) *time.Location {
+	if loc, ok := c.Get("account_location"); ok {
+		return loc.(*time.Location)
+	}
+	return time.UTC
+}
+
+// accountAllowedAgentURLs returns the authenticated account's allowed agent
+// URL patterns; none when API auth is disabled
+func accountAllowedAgentURLs(c *gin.Context) []string {
+	if patterns, ok := c.Get("account_allowed_agent_urls"); ok {
+		return patterns.([]string)
+	}
+	return nil
+}
+
+// GetAccount godoc
+// @Summary Get the account
+// @Description Get the authenticated account and its settings
+// @Tags Account
 // @Accept json
 // @Produce json
 // @Security BasicAuth
-// @Success 200 {array} models.Route
+// @Security BearerAuth
+// @Success 200 {object} models.Account
 // @Failure 401 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
-// @Router /api/v1/routes [get]
-func (h *Handler) ListRoutes(c *gin.Context) {
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/account [get]
+func (h *Handler) GetAccount(c *gin.Context) {
 	accountID := c.GetString("account_id")
 
-	routes, err := h.store.ListRoutes(c.Request.Context(), accountID)
+	account, err := h.store.GetAccount(c.Request.Context(), accountID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch routes", Details: err.Error()})
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Account not found"})
+		return
+	}
+
+	account.Localize(account.Location())
+	c.JSON(http.StatusOK, account)
+}
+
+// UpdateAccount godoc
+// @Summary Update the account
+// @Description Update the authenticated account's settings. The timezone (IANA name) is used for timestamps in API responses and for reporting date ranges. Allowed agent URLs are patterns (e.g. *.mycompany.com, wss://agent.example.com:8443) every agent WebSocket URL of the account's routes must match; an empty list allows any. Branding sets the reason phrases and extra headers of the responses rejecting the account's calls and the SDP session name of its answers, for white-labeling.
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param account body UpdateAccountRequest true "Account settings"
+// @Success 200 {object} models.Account
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/account [put]
+func (h *Handler) UpdateAccount(c *gin.Context) {
+	accountID := c.GetString("account_id")
+
+	var req UpdateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if _, err := models.LoadTimezone(req.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := models.ValidateAgentURLPatterns(req.AllowedAgentURLs); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
 		return
 	}
 
-	if routes == nil {
-		routes = []*models.Route{}
+	if req.Branding != nil {
+		if err := req.Branding.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	account, err := h.store.UpdateAccount(c.Request.Context(), accountID, store.AccountUpdate{
+		Timezone:         req.Timezone,
+		AllowedAgentURLs: req.AllowedAgentURLs,
+		Branding:         req.Branding,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update account", Details: err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, routes)
+	account.Localize(account.Location())
+	c.JSON(http.StatusOK, account)
 }
 
-// GetRoute godoc
-// @Summary Get a route
-// @Description Get a specific SIP routing rule by ID
-// @Tags Routes
+// IssueToken godoc
+// @Summary Issue a bearer token
+// @Description Exchange API key credentials for a short-lived bearer token, verified without a database lookup. The token carries the account's settings and the key's scopes, optionally narrowed; changes to either, and key revocation, apply to tokens issued after them.
+// @Tags Account
 // @Accept json
 // @Produce json
 // @Security BasicAuth
-// @Param id path string true "Route ID"
-// @Success 200 {object} models.Route
+// @Param token body IssueTokenRequest false "Scopes"
+// @Success 201 {object} TokenResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/auth/token [post]
+func (h *Handler) IssueToken(c *gin.Context) {
+	var req IssueTokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	// Tokens can't be refreshed with a token, so they lapse with their key
+	account, hasAccount := c.Get("account")
+	key, hasKey := c.Get("api_key")
+	if !hasAccount || !hasKey {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Forbidden", Details: "tokens are issued for API key credentials"})
+		return
+	}
+
+	scopes, err := models.NarrowScopes(key.(*models.APIKey).Scopes, req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	token, expires, err := h.tokens.Issue(account.(*models.Account), key.(*models.APIKey), scopes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to issue token", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(h.tokens.TTL().Seconds()),
+		ExpiresAt:   expires.In(accountLocation(c)),
+		Scopes:      scopes,
+	})
+}
+
+// =============================================================================
+// Admin Handlers
+// =============================================================================
+
+// AdminListAccounts godoc
+// @Summary List accounts
+// @Description List all accounts. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Success 200 {array} models.Account
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/accounts [get]
+func (h *Handler) AdminListAccounts(c *gin.Context) {
+	accounts, err := h.store.ListAccounts(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch accounts", Details: err.Error()})
+		return
+	}
+
+	if accounts == nil {
+		accounts = []*models.Account{}
+	}
+	for _, account := range accounts {
+		account.Localize(account.Location())
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+// AdminGetAccount godoc
+// @Summary Get an account
+// @Description Get an account by ID. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Param id path string true "Account ID"
+// @Success 200 {object} models.Account
 // @Failure 401 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Router /api/v1/admin/accounts/{id} [get]
+func (h *Handler) AdminGetAccount(c *gin.Context) {
+	account, err := h.store.GetAccount(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Account not found"})
+		return
+	}
+
+	account.Localize(account.Location())
+	c.JSON(http.StatusOK, account)
+}
+
+// AdminCreateAccount godoc
+// @Summary Create an account
+// @Description Create an account with a generated API key. The key is only returned now. Requires the admin API key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security AdminAuth
+// @Param account body CreateAccountRequest true "Account"
+// @Success 201 {object} CreateAccountResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /api/v1/routes/{id} [get]
-func (h *Handler) GetRoute(c *gin.Context) {
-	accountID := c.GetString("account_id")
-	routeID := c.Param("id")
+// @Router /api/v1/admin/accounts [post]
+func (h *Handler) AdminCreateAccount(c *gin.Context) {
+	var req CreateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if _, err := models.LoadTimezone(req.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := models.ValidateAgentURLPatterns(req.AllowedAgentURLs); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.Branding != nil {
+		if err := req.Branding.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	if req.MaxConcurrentCalls != nil && *req.MaxConcurrentCalls < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_concurrent_calls must be at least 1"})
+		return
+	}
+	if req.CallsPerSecond != nil && *req.CallsPerSecond < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "calls_per_second must be at least 1"})
+		return
+	}
+	if req.MaxBandwidthKbps != nil && *req.MaxBandwidthKbps < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_bandwidth_kbps must be at least 1"})
+		return
+	}
+
+	key, err := apikey.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create account", Details: err.Error()})
+		return
+	}
+
+	account := &models.Account{
+		Name:               req.Name,
+		Timezone:           req.Timezone,
+		AllowedAgentURLs:   req.AllowedAgentURLs,
+		MaxConcurrentCalls: req.MaxConcurrentCalls,
+		CallsPerSecond:     req.CallsPerSecond,
+		MaxBandwidthKbps:   req.MaxBandwidthKbps,
+	}
+	if req.Branding != nil {
+		account.Branding = *req.Branding
+	}
+	account, apiKey, err := h.store.CreateAccount(c.Request.Context(), account, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create account", Details: err.Error()})
+		return
+	}
+
+	loc := account.Location()
+	account.Localize(loc)
+	apiKey.Localize(loc)
+	c.JSON(http.StatusCreated, CreateAccountResponse{Account: account, APIKey: apiKey})
+}
+
+// AdminUpdateAccount godoc
+// @Summary Update an account
+// @Description Update an account's name, settings or active flag. Omitted fields are kept. The concurrent call limit caps the account's calls in progress on all instances; further calls are rejected with 503 until one ends. The bandwidth limit (kbps) caps the media bitrate of those calls the same way, each call taking its RTP bitrate each way (80 kbps over IPv4, 88 over IPv6). Deactivated accounts can't use the API and their routes and trunks stop taking calls. Requires the admin API key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security AdminAuth
+// @Param id path string true "Account ID"
+// @Param account body AdminUpdateAccountRequest true "Account settings"
+// @Success 200 {object} models.Account
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/admin/accounts/{id} [put]
+func (h *Handler) AdminUpdateAccount(c *gin.Context) {
+	var req AdminUpdateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if _, err := models.LoadTimezone(req.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := models.ValidateAgentURLPatterns(req.AllowedAgentURLs); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.Branding != nil {
+		if err := req.Branding.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	if req.MaxConcurrentCalls != nil && *req.MaxConcurrentCalls < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_concurrent_calls can't be negative"})
+		return
+	}
+	if req.CallsPerSecond != nil && *req.CallsPerSecond < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "calls_per_second can't be negative"})
+		return
+	}
+	if req.MaxBandwidthKbps != nil && *req.MaxBandwidthKbps < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_bandwidth_kbps can't be negative"})
+		return
+	}
+
+	account, err := h.store.UpdateAccount(c.Request.Context(), c.Param("id"), store.AccountUpdate{
+		Name:               req.Name,
+		Timezone:           req.Timezone,
+		AllowedAgentURLs:   req.AllowedAgentURLs,
+		Branding:           req.Branding,
+		MaxConcurrentCalls: req.MaxConcurrentCalls,
+		CallsPerSecond:     req.CallsPerSecond,
+		MaxBandwidthKbps:   req.MaxBandwidthKbps,
+		Active:             req.Active,
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Account not found", Details: err.Error()})
+		return
+	}
+
+	// Routes of a deactivated (or reactivated) account may be cached
+	if req.Active != nil && h.cache != nil {
+		_ = h.cache.InvalidateRouteCache(c.Request.Context())
+	}
+
+	account.Localize(account.Location())
+	c.JSON(http.StatusOK, account)
+}
+
+// AdminDeactivateAccount godoc
+// @Summary Deactivate an account
+// @Description Deactivate an account: its API keys stop working and its routes and trunks stop taking calls. Its data is kept; reactivate it by updating it with active true. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Param id path string true "Account ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/admin/accounts/{id} [delete]
+func (h *Handler) AdminDeactivateAccount(c *gin.Context) {
+	inactive := false
+	if _, err := h.store.UpdateAccount(c.Request.Context(), c.Param("id"), store.AccountUpdate{Active: &inactive}); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Account not found", Details: err.Error()})
+		return
+	}
+
+	if h.cache != nil {
+		_ = h.cache.InvalidateRouteCache(c.Request.Context())
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Account deactivated successfully"})
+}
+
+// AdminListAPIKeys godoc
+// @Summary List an account's API keys
+// @Description List an account's API keys, including revoked and expired ones. Only key prefixes are returned. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Param id path string true "Account ID"
+// @Success 200 {array} models.APIKey
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/accounts/{id}/api-keys [get]
+func (h *Handler) AdminListAPIKeys(c *gin.Context) {
+	account, err := h.store.GetAccount(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Account not found"})
+		return
+	}
+
+	keys, err := h.store.ListAPIKeys(c.Request.Context(), account.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch API keys", Details: err.Error()})
+		return
+	}
+
+	if keys == nil {
+		keys = []*models.APIKey{}
+	}
+	loc := account.Location()
+	for _, key := range keys {
+		key.Localize(loc)
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// AdminCreateAPIKey godoc
+// @Summary Generate an API key
+// @Description Generate an additional API key for an account, optionally limited by scopes: read (GET requests) and calls (the calls API and event stream). The key is only returned now. Requires the admin API key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security AdminAuth
+// @Param id path string true "Account ID"
+// @Param key body CreateAPIKeyRequest false "Key name and expiry"
+// @Success 201 {object} models.APIKey
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/accounts/{id}/api-keys [post]
+func (h *Handler) AdminCreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+	if err := models.ValidateAPIKeyScopes(req.Scopes); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "expires_at must be in the future"})
+		return
+	}
+
+	account, err := h.store.GetAccount(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Account not found"})
+		return
+	}
+
+	key, err := apikey.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create API key", Details: err.Error()})
+		return
+	}
+
+	apiKey, err := h.store.CreateAPIKey(c.Request.Context(), account.ID, req.Name, key, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create API key", Details: err.Error()})
+		return
+	}
+
+	apiKey.Localize(account.Location())
+	c.JSON(http.StatusCreated, apiKey)
+}
+
+// AdminRotateAPIKey godoc
+// @Summary Rotate an API key
+// @Description Replace an API key with a new one of the same name and scopes, returned only now. The old key keeps working for the grace period, so clients can switch over, or is revoked right away without one. Requires the admin API key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security AdminAuth
+// @Param id path string true "Account ID"
+// @Param keyId path string true "API key ID"
+// @Param rotation body RotateAPIKeyRequest false "Grace period"
+// @Success 201 {object} models.APIKey
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/admin/accounts/{id}/api-keys/{keyId}/rotate [post]
+func (h *Handler) AdminRotateAPIKey(c *gin.Context) {
+	var req RotateAPIKeyRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+	if req.GracePeriod < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "grace_period must not be negative"})
+		return
+	}
+
+	account, err := h.store.GetAccount(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Account not found"})
+		return
+	}
+
+	key, err := apikey.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to rotate API key", Details: err.Error()})
+		return
+	}
+
+	grace := time.Duration(req.GracePeriod) * time.Second
+	apiKey, err := h.store.RotateAPIKey(c.Request.Context(), account.ID, c.Param("keyId"), key, grace)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "API key not found", Details: err.Error()})
+		return
+	}
+
+	apiKey.Localize(account.Location())
+	c.JSON(http.StatusCreated, apiKey)
+}
+
+// AdminRevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Revoke an API key immediately. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Param id path string true "Account ID"
+// @Param keyId path string true "API key ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/admin/accounts/{id}/api-keys/{keyId} [delete]
+func (h *Handler) AdminRevokeAPIKey(c *gin.Context) {
+	if err := h.store.RevokeAPIKey(c.Request.Context(), c.Param("id"), c.Param("keyId")); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "API key not found", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "API key revoked successfully"})
+}
+
+// AdminGetConfig godoc
+// @Summary Get the running configuration
+// @Description Return the configuration of the instance answering the request: every variable with the value in use and where it came from (env, .env, profile or default), secrets redacted. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Success 200 {object} ConfigResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/admin/config [get]
+func (h *Handler) AdminGetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, ConfigResponse{
+		Instance: h.config.InstanceID,
+		Profile:  h.config.Profile,
+		Settings: h.config.Settings(),
+	})
+}
+
+// =============================================================================
+// Route Handlers
+// =============================================================================
+
+// ListRoutes godoc
+// @Summary List all routes
+// @Description Get all SIP routing rules for the account
+// @Tags Routes
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {array} models.Route
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/routes [get]
+func (h *Handler) ListRoutes(c *gin.Context) {
+	accountID := c.GetString("account_id")
+
+	routes, err := h.store.ListRoutes(c.Request.Context(), accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch routes", Details: err.Error()})
+		return
+	}
+
+	loc := accountLocation(c)
+	redacted := make([]*models.Route, 0, len(routes))
+	for _, route := range routes {
+		route.Localize(loc)
+		redacted = append(redacted, route.Redacted())
+	}
+
+	c.JSON(http.StatusOK, redacted)
+}
+
+// GetRoute godoc
+// @Summary Get a route
+// @Description Get a specific SIP routing rule by ID
+// @Tags Routes
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Route ID"
+// @Success 200 {object} models.Route
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/routes/{id} [get]
+func (h *Handler) GetRoute(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	routeID := c.Param("id")
+
+	route, err := h.store.GetRoute(c.Request.Context(), accountID, routeID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Route not found"})
+		return
+	}
+
+	route.Localize(accountLocation(c))
+	c.JSON(http.StatusOK, route.Redacted())
+}
+
+// CreateRoute godoc
+// @Summary Create a route
+// @Description Create a new SIP routing rule
+// @Tags Routes
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param route body CreateRouteRequest true "Route configuration"
+// @Success 201 {object} models.Route
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/routes [post]
+func (h *Handler) CreateRoute(c *gin.Context) {
+	accountID := c.GetString("account_id")
+
+	var req CreateRouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := validateMatchConditions(req.MatchHeaders, req.MatchGroups); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.MatchToUserType == "" {
+		req.MatchToUserType = models.NumberMatchExact
+	}
+	if req.MatchFromUserType == "" {
+		req.MatchFromUserType = models.NumberMatchExact
+	}
+	if err := models.ValidateNumberMatch("match_to_user", req.MatchToUserType, req.MatchToUser); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if err := models.ValidateNumberMatch("match_from_user", req.MatchFromUserType, req.MatchFromUser); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.AudioFormat != nil {
+		if err := req.AudioFormat.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	if err := validateHeaderRules(req.HeaderRules); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := validateAgentURLs("agent url", req.AgentURLs); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := validateAgentURLs("fallback websocket url", req.FallbackWebSocketURLs); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := models.ValidateAgentRegions(req.AgentRegions); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.SplitStrategy == "" {
+		req.SplitStrategy = models.SplitRandom
+	}
+	if err := models.ValidateAgentSplits(req.AgentSplits, req.SplitStrategy); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.AgentLBStrategy == "" {
+		req.AgentLBStrategy = models.AgentLBRoundRobin
+	}
+	if err := models.ValidateAgentLBStrategy(req.AgentLBStrategy); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.AgentProtocol == "" {
+		req.AgentProtocol = models.AgentProtocolExotel
+	}
+	if err := models.ValidateAgentProtocol(req.AgentProtocol); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.AgentAuth != nil {
+		if err := req.AgentAuth.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	if req.Ringback != nil {
+		if _, _, err := models.ParseRingback(*req.Ringback); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	if req.FaxPolicy == "" {
+		req.FaxPolicy = models.FaxPolicyIgnore
+	}
+	if err := models.ValidateFaxPolicy(req.FaxPolicy, req.FaxTarget); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if err := models.ValidateLanguage(req.Language); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if req.EarlyMedia && req.DetectHuman {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "early_media and detect_human can't both be set: human detection answers calls first"})
+		return
+	}
+	if req.ConnectRetry != nil {
+		if err := req.ConnectRetry.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+	if req.MaxConcurrentCalls != nil && *req.MaxConcurrentCalls < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_concurrent_calls must be at least 1"})
+		return
+	}
+	if req.MaxBitrateKbps != nil && *req.MaxBitrateKbps < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_bitrate_kbps must be at least 1"})
+		return
+	}
+	if err := models.ValidateDTMFShortcuts(req.DTMFShortcuts); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if req.Schedule != nil {
+		if req.Schedule.Timezone == "" {
+			req.Schedule.Timezone = accountLocation(c).String()
+		}
+		if err := req.Schedule.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	route := &models.Route{
+		Name:                  req.Name,
+		Priority:              req.Priority,
+		MatchToUser:           req.MatchToUser,
+		MatchFromUser:         req.MatchFromUser,
+		MatchToUserType:       req.MatchToUserType,
+		MatchFromUserType:     req.MatchFromUserType,
+		MatchSIPHeader:        req.MatchSIPHeader,
+		MatchSIPHeaderValue:   req.MatchSIPHeaderValue,
+		MatchHeaders:          req.MatchHeaders,
+		MatchGroups:           req.MatchGroups,
+		WebSocketURL:          req.WebSocketURL,
+		AgentURLs:             req.AgentURLs,
+		AgentLBStrategy:       req.AgentLBStrategy,
+		FallbackWebSocketURLs: req.FallbackWebSocketURLs,
+		AgentRegions:          req.AgentRegions,
+		AgentSplits:           req.AgentSplits,
+		SplitStrategy:         req.SplitStrategy,
+		AudioFormat:           req.AudioFormat,
+		AgentProtocol:         req.AgentProtocol,
+		CallPriority:          req.CallPriority,
+		BinaryAudio:           req.BinaryAudio,
+		AgentAuth:             req.AgentAuth,
+		DetectHuman:           req.DetectHuman,
+		Record:                req.Record,
+		HeaderRules:           req.HeaderRules,
+		Ringback:              req.Ringback,
+		FaxPolicy:             req.FaxPolicy,
+		FaxTarget:             req.FaxTarget,
+		Language:              req.Language,
+		EarlyMedia:            req.EarlyMedia,
+		ConnectRetry:          req.ConnectRetry,
+		MaxConcurrentCalls:    req.MaxConcurrentCalls,
+		MaxBitrateKbps:        req.MaxBitrateKbps,
+		DTMFShortcuts:         req.DTMFShortcuts,
+		Schedule:              req.Schedule,
+	}
+
+	if err := checkAllowedAgentURLs(accountAllowedAgentURLs(c), route); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	created, err := h.store.CreateRoute(c.Request.Context(), accountID, route)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create route", Details: err.Error()})
+		return
+	}
+
+	created.Localize(accountLocation(c))
+	c.JSON(http.StatusCreated, created.Redacted())
+}
+
+// UpdateRoute godoc
+// @Summary Update a route
+// @Description Update an existing SIP routing rule
+// @Tags Routes
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Route ID"
+// @Param route body UpdateRouteRequest true "Route configuration"
+// @Success 200 {object} models.Route
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/routes/{id} [put]
+func (h *Handler) UpdateRoute(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	routeID := c.Param("id")
+
+	var req UpdateRouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := validateMatchConditions(req.MatchHeaders, req.MatchGroups); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.MatchToUserType == "" {
+		req.MatchToUserType = models.NumberMatchExact
+	}
+	if req.MatchFromUserType == "" {
+		req.MatchFromUserType = models.NumberMatchExact
+	}
+	if err := models.ValidateNumberMatch("match_to_user", req.MatchToUserType, req.MatchToUser); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if err := models.ValidateNumberMatch("match_from_user", req.MatchFromUserType, req.MatchFromUser); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.AudioFormat != nil {
+		if err := req.AudioFormat.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	if err := validateHeaderRules(req.HeaderRules); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := validateAgentURLs("agent url", req.AgentURLs); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := validateAgentURLs("fallback websocket url", req.FallbackWebSocketURLs); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := models.ValidateAgentRegions(req.AgentRegions); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.SplitStrategy == "" {
+		req.SplitStrategy = models.SplitRandom
+	}
+	if err := models.ValidateAgentSplits(req.AgentSplits, req.SplitStrategy); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.AgentLBStrategy == "" {
+		req.AgentLBStrategy = models.AgentLBRoundRobin
+	}
+	if err := models.ValidateAgentLBStrategy(req.AgentLBStrategy); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.AgentProtocol == "" {
+		req.AgentProtocol = models.AgentProtocolExotel
+	}
+	if err := models.ValidateAgentProtocol(req.AgentProtocol); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if req.AgentAuth != nil {
+		// Credentials aren't returned by the API, so an update that omits
+		// them keeps the stored ones
+		if existing, err := h.store.GetRoute(c.Request.Context(), accountID, routeID); err == nil {
+			req.AgentAuth.KeepSecrets(existing.AgentAuth)
+		}
+		if err := req.AgentAuth.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	if req.Ringback != nil {
+		if _, _, err := models.ParseRingback(*req.Ringback); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	if req.FaxPolicy == "" {
+		req.FaxPolicy = models.FaxPolicyIgnore
+	}
+	if err := models.ValidateFaxPolicy(req.FaxPolicy, req.FaxTarget); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if err := models.ValidateLanguage(req.Language); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if req.EarlyMedia && req.DetectHuman {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "early_media and detect_human can't both be set: human detection answers calls first"})
+		return
+	}
+	if req.ConnectRetry != nil {
+		if err := req.ConnectRetry.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+	if req.MaxConcurrentCalls != nil && *req.MaxConcurrentCalls < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_concurrent_calls must be at least 1"})
+		return
+	}
+	if req.MaxBitrateKbps != nil && *req.MaxBitrateKbps < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_bitrate_kbps must be at least 1"})
+		return
+	}
+	if err := models.ValidateDTMFShortcuts(req.DTMFShortcuts); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if req.Schedule != nil {
+		if req.Schedule.Timezone == "" {
+			req.Schedule.Timezone = accountLocation(c).String()
+		}
+		if err := req.Schedule.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	route := &models.Route{
+		ID:                    routeID,
+		Name:                  req.Name,
+		Priority:              req.Priority,
+		MatchToUser:           req.MatchToUser,
+		MatchFromUser:         req.MatchFromUser,
+		MatchToUserType:       req.MatchToUserType,
+		MatchFromUserType:     req.MatchFromUserType,
+		MatchSIPHeader:        req.MatchSIPHeader,
+		MatchSIPHeaderValue:   req.MatchSIPHeaderValue,
+		MatchHeaders:          req.MatchHeaders,
+		MatchGroups:           req.MatchGroups,
+		WebSocketURL:          req.WebSocketURL,
+		AgentURLs:             req.AgentURLs,
+		AgentLBStrategy:       req.AgentLBStrategy,
+		FallbackWebSocketURLs: req.FallbackWebSocketURLs,
+		AgentRegions:          req.AgentRegions,
+		AgentSplits:           req.AgentSplits,
+		SplitStrategy:         req.SplitStrategy,
+		AudioFormat:           req.AudioFormat,
+		AgentProtocol:         req.AgentProtocol,
+		CallPriority:          req.CallPriority,
+		BinaryAudio:           req.BinaryAudio,
+		AgentAuth:             req.AgentAuth,
+		DetectHuman:           req.DetectHuman,
+		Record:                req.Record,
+		HeaderRules:           req.HeaderRules,
+		Ringback:              req.Ringback,
+		FaxPolicy:             req.FaxPolicy,
+		FaxTarget:             req.FaxTarget,
+		Language:              req.Language,
+		EarlyMedia:            req.EarlyMedia,
+		ConnectRetry:          req.ConnectRetry,
+		MaxConcurrentCalls:    req.MaxConcurrentCalls,
+		MaxBitrateKbps:        req.MaxBitrateKbps,
+		DTMFShortcuts:         req.DTMFShortcuts,
+		Schedule:              req.Schedule,
+		Active:                req.Active,
+	}
+
+	if err := checkAllowedAgentURLs(accountAllowedAgentURLs(c), route); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	updated, err := h.store.UpdateRoute(c.Request.Context(), accountID, route)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update route", Details: err.Error()})
+		return
+	}
+
+	updated.Localize(accountLocation(c))
+	c.JSON(http.StatusOK, updated.Redacted())
+}
+
+// DeleteRoute godoc
+// @Summary Delete a route
+// @Description Delete a SIP routing rule
+// @Tags Routes
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Route ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/routes/{id} [delete]
+func (h *Handler) DeleteRoute(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	routeID := c.Param("id")
+
+	if err := h.store.DeleteRoute(c.Request.Context(), accountID, routeID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete route", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Route deleted successfully"})
+}
+
+// GetRouteSplitStats godoc
+// @Summary Get route agent split stats
+// @Description Count the calls assigned each of a route's agent split targets, how many were answered and failed, and their average duration, to compare the targets of an A/B test. Targets are counted as assigned, including calls that failed over to the route's other agents.
+// @Tags Routes
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Route ID"
+// @Param from query string false "First day (YYYY-MM-DD, in the account's timezone)"
+// @Param to query string false "Last day, inclusive (YYYY-MM-DD, in the account's timezone)"
+// @Success 200 {object} RouteSplitStatsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/routes/{id}/splits [get]
+func (h *Handler) GetRouteSplitStats(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	loc := accountLocation(c)
+
+	from, err := dayStart(c.Query("from"), loc, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "from: " + err.Error()})
+		return
+	}
+	to, err := dayStart(c.Query("to"), loc, 1)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "to: " + err.Error()})
+		return
+	}
+
+	route, err := h.store.GetRoute(c.Request.Context(), accountID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Route not found"})
+		return
+	}
+
+	stats, err := h.store.GetRouteSplitStats(c.Request.Context(), accountID, route.ID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch split stats", Details: err.Error()})
+		return
+	}
+
+	if stats == nil {
+		stats = []*models.SplitStat{}
+	}
+	c.JSON(http.StatusOK, RouteSplitStatsResponse{RouteID: route.ID, Targets: stats})
+}
+
+// validateMatchConditions checks the header conditions and match groups of a route
+func validateMatchConditions(conds []models.HeaderCondition, groups []models.MatchGroup) error {
+	for _, cond := range conds {
+		if err := cond.Validate(); err != nil {
+			return err
+		}
+	}
+	for i, group := range groups {
+		if err := group.Validate(); err != nil {
+			return fmt.Errorf("match group %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validateAgentURLs checks a route's replica or fallback agent URLs
+func validateAgentURLs(name string, urls []string) error {
+	for i, u := range urls {
+		if err := models.ValidateAgentURL(u); err != nil {
+			return fmt.Errorf("%s %d: %w", name, i, err)
+		}
+	}
+	return nil
+}
+
+// checkAllowedAgentURLs checks a route's agent, replica, regional, split,
+// fallback and after-hours URLs against the account's allowed agent URL
+// patterns
+func checkAllowedAgentURLs(patterns []string, route *models.Route) error {
+	urls := append(route.AgentPool(), route.FallbackWebSocketURLs...)
+	for _, pool := range route.AgentRegions {
+		urls = append(urls, pool.URLs...)
+	}
+	for _, split := range route.AgentSplits {
+		urls = append(urls, split.WebSocketURL)
+	}
+	if route.Schedule != nil && route.Schedule.AfterHoursWebSocketURL != nil {
+		urls = append(urls, *route.Schedule.AfterHoursWebSocketURL)
+	}
+	for _, u := range urls {
+		if err := models.CheckAgentURL(patterns, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateHeaderRules checks a route's or trunk's header manipulation rules
+func validateHeaderRules(rules []models.HeaderRule) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("header rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// Trunk Handlers
+// =============================================================================
+
+// ListTrunks godoc
+// @Summary List all trunks
+// @Description Get all SIP trunks for the account
+// @Tags Trunks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {array} models.Trunk
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/trunks [get]
+func (h *Handler) ListTrunks(c *gin.Context) {
+	accountID := c.GetString("account_id")
+
+	trunks, err := h.store.ListTrunks(c.Request.Context(), accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch trunks", Details: err.Error()})
+		return
+	}
+
+	if trunks == nil {
+		trunks = []*models.Trunk{}
+	}
+	loc := accountLocation(c)
+	for _, trunk := range trunks {
+		trunk.Localize(loc)
+	}
+
+	c.JSON(http.StatusOK, trunks)
+}
+
+// GetTrunk godoc
+// @Summary Get a trunk
+// @Description Get a specific SIP trunk by ID
+// @Tags Trunks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Trunk ID"
+// @Success 200 {object} models.Trunk
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/trunks/{id} [get]
+func (h *Handler) GetTrunk(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	trunkID := c.Param("id")
+
+	trunk, err := h.store.GetTrunk(c.Request.Context(), accountID, trunkID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Trunk not found"})
+		return
+	}
+
+	trunk.Localize(accountLocation(c))
+	c.JSON(http.StatusOK, trunk)
+}
+
+// CreateTrunk godoc
+// @Summary Create a trunk
+// @Description Create a new SIP trunk
+// @Tags Trunks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param trunk body CreateTrunkRequest true "Trunk configuration"
+// @Success 201 {object} models.Trunk
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/trunks [post]
+func (h *Handler) CreateTrunk(c *gin.Context) {
+	accountID := c.GetString("account_id")
+
+	var req CreateTrunkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := validateHeaderRules(req.HeaderRules); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if req.MaxBitrateKbps != nil && *req.MaxBitrateKbps < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_bitrate_kbps must be at least 1"})
+		return
+	}
+
+	port := req.Port
+	if port == 0 {
+		port = 5060
+	}
+
+	transport := req.Transport
+	if transport == "" {
+		transport = "udp"
+	}
+
+	trunk := &models.Trunk{
+		Name:             req.Name,
+		Host:             req.Host,
+		Port:             port,
+		Transport:        transport,
+		Username:         req.Username,
+		Password:         req.Password,
+		FromUser:         req.FromUser,
+		FromHost:         req.FromHost,
+		Register:         req.Register,
+		RegisterInterval: req.RegisterInterval,
+		OutboundProxy:    req.OutboundProxy,
+		HeaderRules:      req.HeaderRules,
+		SymmetricRTP:     req.SymmetricRTP == nil || *req.SymmetricRTP,
+		MaxBitrateKbps:   req.MaxBitrateKbps,
+	}
+
+	created, err := h.store.CreateTrunk(c.Request.Context(), accountID, trunk)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create trunk", Details: err.Error()})
+		return
+	}
+
+	created.Localize(accountLocation(c))
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateTrunk godoc
+// @Summary Update a trunk
+// @Description Update an existing SIP trunk
+// @Tags Trunks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Trunk ID"
+// @Param trunk body UpdateTrunkRequest true "Trunk configuration"
+// @Success 200 {object} models.Trunk
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/trunks/{id} [put]
+func (h *Handler) UpdateTrunk(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	trunkID := c.Param("id")
+
+	var req UpdateTrunkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := validateHeaderRules(req.HeaderRules); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if req.MaxBitrateKbps != nil && *req.MaxBitrateKbps < 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "max_bitrate_kbps must be at least 1"})
+		return
+	}
+
+	port := req.Port
+	if port == 0 {
+		port = 5060
+	}
+
+	transport := req.Transport
+	if transport == "" {
+		transport = "udp"
+	}
+
+	trunk := &models.Trunk{
+		ID:               trunkID,
+		Name:             req.Name,
+		Host:             req.Host,
+		Port:             port,
+		Transport:        transport,
+		Username:         req.Username,
+		Password:         req.Password,
+		FromUser:         req.FromUser,
+		FromHost:         req.FromHost,
+		Register:         req.Register,
+		RegisterInterval: req.RegisterInterval,
+		OutboundProxy:    req.OutboundProxy,
+		HeaderRules:      req.HeaderRules,
+		SymmetricRTP:     req.SymmetricRTP == nil || *req.SymmetricRTP,
+		MaxBitrateKbps:   req.MaxBitrateKbps,
+		Active:           req.Active,
+	}
+
+	updated, err := h.store.UpdateTrunk(c.Request.Context(), accountID, trunk)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update trunk", Details: err.Error()})
+		return
+	}
+
+	updated.Localize(accountLocation(c))
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteTrunk godoc
+// @Summary Delete a trunk
+// @Description Delete a SIP trunk
+// @Tags Trunks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Trunk ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/trunks/{id} [delete]
+func (h *Handler) DeleteTrunk(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	trunkID := c.Param("id")
+
+	if err := h.store.DeleteTrunk(c.Request.Context(), accountID, trunkID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete trunk", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Trunk deleted successfully"})
+}
+
+// GetTrunkStats godoc
+// @Summary Get trunk response stats
+// @Description Count the final SIP responses exchanged with a trunk by direction, method and status code: inbound responses were sent by us to the trunk's requests, outbound ones by the trunk to ours. Responses are counted by the hour.
+// @Tags Trunks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Trunk ID"
+// @Param from query string false "First day (YYYY-MM-DD, in the account's timezone)"
+// @Param to query string false "Last day, inclusive (YYYY-MM-DD, in the account's timezone)"
+// @Success 200 {object} TrunkStatsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/trunks/{id}/stats [get]
+func (h *Handler) GetTrunkStats(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	loc := accountLocation(c)
+
+	from, err := dayStart(c.Query("from"), loc, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "from: " + err.Error()})
+		return
+	}
+	to, err := dayStart(c.Query("to"), loc, 1)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "to: " + err.Error()})
+		return
+	}
+
+	trunk, err := h.store.GetTrunk(c.Request.Context(), accountID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Trunk not found"})
+		return
+	}
+
+	stats, err := h.store.GetTrunkResponseStats(c.Request.Context(), trunk.ID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch trunk stats", Details: err.Error()})
+		return
+	}
+
+	if stats == nil {
+		stats = []*models.TrunkResponseStat{}
+	}
+	c.JSON(http.StatusOK, TrunkStatsResponse{TrunkID: trunk.ID, Responses: stats})
+}
+
+// =============================================================================
+// Call Handlers
+// =============================================================================
+
+// ListCalls godoc
+// @Summary List calls
+// @Description Get a page of the account's call detail records, newest first unless sorted otherwise. The number of matching calls is returned in the X-Total-Count header.
+// @Tags Calls
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param limit query int false "Maximum number of records (up to 1000)" default(100)
+// @Param offset query int false "Records to skip" default(0)
+// @Param sort query string false "Order: created_at, -created_at, duration or -duration" default(-created_at)
+// @Param from query string false "First day (YYYY-MM-DD, in the account's timezone)"
+// @Param to query string false "Last day, inclusive (YYYY-MM-DD, in the account's timezone)"
+// @Param direction query string false "inbound or outbound"
+// @Param status query string false "Call status"
+// @Param from_user query string false "Caller number"
+// @Param to_user query string false "Called number"
+// @Param route_id query string false "Route ID"
+// @Param trunk_id query string false "Trunk ID"
+// @Param conversation_id query string false "Conversation ID, for the calls of one customer journey"
+// @Success 200 {array} models.CallLog
+// @Header 200 {integer} X-Total-Count "Number of matching calls"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls [get]
+func (h *Handler) ListCalls(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	loc := accountLocation(c)
+
+	filter, err := callFilter(c, loc)
+	if err == nil {
+		err = callPage(c, &filter)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	calls, total, err := h.store.ListCalls(c.Request.Context(), accountID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch calls", Details: err.Error()})
+		return
+	}
+
+	if calls == nil {
+		calls = []*models.CallLog{}
+	}
+	for _, call := range calls {
+		call.Localize(loc)
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.JSON(http.StatusOK, calls)
+}
+
+// ListActiveCalls godoc
+// @Summary List active calls
+// @Description Get the live state of the account's calls in progress, oldest first: duration so far, route, agent URL, caller RTP address, packet and audio counters, and last-activity times. With Valkey configured, calls on other instances are included as of their last snapshot (at most 5 seconds old).
+// @Tags Calls
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {array} models.ActiveCall
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/calls/active [get]
+func (h *Handler) ListActiveCalls(c *gin.Context) {
+	loc := accountLocation(c)
+
+	calls := h.calls.ActiveCalls(c.Request.Context(), c.GetString("account_id"))
+	for _, call := range calls {
+		call.Localize(loc)
+	}
+	c.JSON(http.StatusOK, calls)
+}
+
+// GetAccountBandwidth godoc
+// @Summary Get the account's media bandwidth
+// @Description Get the media bitrate, each way, of the account's calls in progress on all instances, in total and by route and trunk, the RTP bytes they exchanged so far, and the account's bandwidth limit. Each call counts its RTP bitrate on the wire: 80 kbps over IPv4, 88 over IPv6.
+// @Tags Account
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {object} models.BandwidthUsage
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/account/bandwidth [get]
+func (h *Handler) GetAccountBandwidth(c *gin.Context) {
+	c.JSON(http.StatusOK, h.calls.Bandwidth(c.Request.Context(), c.GetString("account_id")))
+}
+
+// maxCallsPage is the most calls listed at once
+const maxCallsPage = 1000
+
+// callFilter reads the query parameters selecting and ordering calls, for
+// listing and export
+func callFilter(c *gin.Context, loc *time.Location) (store.CallFilter, error) {
+	filter := store.CallFilter{
+		Direction:      models.CallDirection(c.Query("direction")),
+		Status:         models.CallStatus(c.Query("status")),
+		RouteID:        c.Query("route_id"),
+		TrunkID:        c.Query("trunk_id"),
+		ConversationID: c.Query("conversation_id"),
+		Sort:           c.Query("sort"),
+	}
+
+	var err error
+	if filter.From, err = dayStart(c.Query("from"), loc, 0); err != nil {
+		return filter, fmt.Errorf("from: %w", err)
+	}
+	if filter.To, err = dayStart(c.Query("to"), loc, 1); err != nil {
+		return filter, fmt.Errorf("to: %w", err)
+	}
+
+	if _, ok := store.CallSorts[filter.Sort]; filter.Sort != "" && !ok {
+		return filter, fmt.Errorf("sort: must be created_at, -created_at, duration or -duration")
+	}
+
+	switch filter.Direction {
+	case "", models.CallDirectionInbound, models.CallDirectionOutbound:
+	default:
+		return filter, fmt.Errorf("direction: must be %s or %s", models.CallDirectionInbound, models.CallDirectionOutbound)
+	}
+	if filter.Status != "" && !slices.Contains(models.CallStatuses, filter.Status) {
+		return filter, fmt.Errorf("status: unknown status %q", filter.Status)
+	}
+	for name, id := range map[string]string{"route_id": filter.RouteID, "trunk_id": filter.TrunkID, "conversation_id": filter.ConversationID} {
+		if id != "" && uuid.Validate(id) != nil {
+			return filter, fmt.Errorf("%s: must be a UUID", name)
+		}
+	}
+
+	// Stored numbers may be masked, so are searched for masked
+	if v := c.Query("from_user"); v != "" {
+		filter.FromUser = privacy.CDRNumber(v)
+	}
+	if v := c.Query("to_user"); v != "" {
+		filter.ToUser = privacy.CDRNumber(v)
+	}
+	return filter, nil
+}
+
+// callPage reads ListCalls' paging parameters into a filter
+func callPage(c *gin.Context, filter *store.CallFilter) error {
+	var err error
+	filter.Limit = 100
+	if v := c.Query("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > maxCallsPage {
+			return fmt.Errorf("limit: must be 1-%d", maxCallsPage)
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			return fmt.Errorf("offset: must not be negative")
+		}
+	}
+	return nil
+}
+
+// dayStart returns midnight in loc of a YYYY-MM-DD date plus a number of
+// days, or nil for an empty date
+func dayStart(date string, loc *time.Location, days int) (*time.Time, error) {
+	if date == "" {
+		return nil, nil
+	}
+	day, err := time.ParseInLocation(time.DateOnly, date, loc)
+	if err != nil {
+		return nil, fmt.Errorf("must be a date (YYYY-MM-DD)")
+	}
+	day = day.AddDate(0, 0, days)
+	return &day, nil
+}
+
+// ListPreemptions godoc
+// @Summary List call preemptions
+// @Description Get the audit trail of calls refused or hung up because of capacity limits
+// @Tags Calls
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {array} models.CallPreemption
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/preemptions [get]
+func (h *Handler) ListPreemptions(c *gin.Context) {
+	accountID := c.GetString("account_id")
+
+	preemptions, err := h.store.ListCallPreemptions(c.Request.Context(), accountID, 100)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch preemptions", Details: err.Error()})
+		return
+	}
+
+	if preemptions == nil {
+		preemptions = []*models.CallPreemption{}
+	}
+	loc := accountLocation(c)
+	for _, p := range preemptions {
+		p.Localize(loc)
+	}
+
+	c.JSON(http.StatusOK, preemptions)
+}
+
+// GetCall godoc
+// @Summary Get a call
+// @Description Get a specific call detail record by ID
+// @Tags Calls
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Call ID"
+// @Success 200 {object} models.CallLog
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls/{id} [get]
+func (h *Handler) GetCall(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	callID := c.Param("id")
+
+	call, err := h.store.GetCall(c.Request.Context(), accountID, callID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
+		return
+	}
+
+	call.Localize(accountLocation(c))
+	c.JSON(http.StatusOK, call)
+}
+
+// GetCallFlow godoc
+// @Summary Get a call flow
+// @Description Get the SIP ladder diagram (parties, messages, timestamps) for a call, as JSON or a rendered SVG
+// @Tags Calls
+// @Accept json
+// @Produce json,image/svg+xml
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Call ID"
+// @Param format query string false "Response format (json or svg)" default(json)
+// @Success 200 {object} models.CallFlow
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls/{id}/flow [get]
+func (h *Handler) GetCallFlow(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	callID := c.Param("id")
+
+	call, err := h.store.GetCall(c.Request.Context(), accountID, callID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
+		return
+	}
+
+	messages, err := h.store.ListSIPMessages(c.Request.Context(), call.CallID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch call flow", Details: err.Error()})
+		return
+	}
+
+	flow := models.NewCallFlow(call.CallID, messages)
+	flow.Localize(accountLocation(c))
+
+	if c.Query("format") == "svg" {
+		c.Data(http.StatusOK, "image/svg+xml", renderCallFlowSVG(flow))
+		return
+	}
+
+	c.JSON(http.StatusOK, flow)
+}
+
+// GetCallNumbers godoc
+// @Summary Get a call's unmasked numbers
+// @Description Decrypt the caller and callee numbers of a call stored with CDR number masking
+// @Tags Calls
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Call ID"
+// @Success 200 {object} CallNumbersResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls/{id}/numbers [get]
+func (h *Handler) GetCallNumbers(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	callID := c.Param("id")
+
+	call, err := h.store.GetCall(c.Request.Context(), accountID, callID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
+		return
+	}
+
+	if call.FromUserEncrypted == nil || call.ToUserEncrypted == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Encrypted numbers not found"})
+		return
+	}
+
+	from, err := privacy.DecryptNumber(*call.FromUserEncrypted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to decrypt numbers", Details: err.Error()})
+		return
+	}
+	to, err := privacy.DecryptNumber(*call.ToUserEncrypted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to decrypt numbers", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, CallNumbersResponse{FromUser: from, ToUser: to})
+}
+
+// GetCallRecording godoc
+// @Summary Download a call recording
+// @Description Download the stereo WAV recording of a call (caller left, agent right). Recordings in object storage are served as a redirect to a short-lived presigned URL.
+// @Tags Calls
+// @Produce audio/wav
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Call ID"
+// @Success 200 {file} file
+// @Success 302 "Redirect to the recording in object storage"
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls/{id}/recording [get]
+func (h *Handler) GetCallRecording(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	callID := c.Param("id")
+
+	call, err := h.store.GetCall(c.Request.Context(), accountID, callID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
+		return
+	}
+
+	if call.RecordingPath == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Recording not found"})
+		return
+	}
+
+	h.serveBlob(c, *call.RecordingPath, call.ID+".wav")
+}
+
+// blobURLExpiry is how long presigned recording and export download URLs
+// are valid
+const blobURLExpiry = 15 * time.Minute
+
+// serveBlob sends a stored blob as an attachment named filename: a
+// redirect to a presigned URL for an object, or the file itself for a
+// local file
+func (h *Handler) serveBlob(c *gin.Context, location, filename string) {
+	if storage.IsRemote(location) {
+		url, err := h.blobs.URL(location, blobURLExpiry)
+		if err == nil && url == "" {
+			err = fmt.Errorf("%s storage can't serve objects", h.config.StorageBackend)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to sign download URL", Details: err.Error()})
+			return
+		}
+
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+
+	if _, err := os.Stat(location); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "File not found", Details: err.Error()})
+		return
+	}
+
+	c.FileAttachment(location, filename)
+}
+
+// GetCallTrace godoc
+// @Summary Download a call's SIP trace
+// @Description Download the SIP messages and RTP headers traced for a call (SIP_TRACE_ENABLED), as a pcap file for Wireshark or a text dump
+// @Tags Calls
+// @Produce application/vnd.tcpdump.pcap,plain
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Call ID"
+// @Param format query string false "Trace format (pcap or text)" default(pcap)
+// @Success 200 {file} file
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls/{id}/trace [get]
+func (h *Handler) GetCallTrace(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	callID := c.Param("id")
+
+	format := c.DefaultQuery("format", "pcap")
+	if format != "pcap" && format != "text" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "format must be pcap or text"})
+		return
+	}
+
+	call, err := h.store.GetCall(c.Request.Context(), accountID, callID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
+		return
+	}
+
+	messages, err := h.store.ListSIPMessages(c.Request.Context(), call.CallID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch trace", Details: err.Error()})
+		return
+	}
+	packets := sipTracePackets(messages)
+
+	data, err := h.store.GetRTPTrace(c.Request.Context(), call.CallID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch trace", Details: err.Error()})
+		return
+	}
+	if data != nil {
+		rtpPackets, err := trace.Unmarshal(data)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch trace", Details: err.Error()})
+			return
+		}
+		packets = append(packets, rtpPackets...)
+	}
+
+	if len(packets) == 0 {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Trace not found"})
+		return
+	}
+	sort.SliceStable(packets, func(i, j int) bool { return packets[i].Time.Before(packets[j].Time) })
+
+	var buf bytes.Buffer
+	if format == "text" {
+		loc := accountLocation(c)
+		for i := range packets {
+			packets[i].Time = packets[i].Time.In(loc)
+		}
+		if err := trace.WriteText(&buf, packets); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to render trace", Details: err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+		return
+	}
+
+	if err := trace.WritePCAP(&buf, packets); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to render trace", Details: err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pcap"`, call.ID))
+	c.Data(http.StatusOK, "application/vnd.tcpdump.pcap", buf.Bytes())
+}
+
+// sipTracePackets converts the SIP messages captured with their full text
+// into trace packets
+func sipTracePackets(messages []*models.SIPMessage) []trace.Packet {
+	var packets []trace.Packet
+	for _, m := range messages {
+		if m.Raw == nil {
+			continue
+		}
+
+		local := ""
+		if m.LocalAddr != nil {
+			local = *m.LocalAddr
+		}
+		src, dst := local, m.RemoteAddr
+		if m.Direction == models.SIPMessageInbound {
+			src, dst = dst, src
+		}
+		packets = append(packets, trace.Packet{
+			Kind:   trace.KindSIP,
+			Time:   m.CapturedAt,
+			Src:    src,
+			Dst:    dst,
+			Data:   []byte(*m.Raw),
+			Length: len(*m.Raw),
+		})
+	}
+	return packets
+}
+
+// InitiateCall godoc
+// @Summary Initiate an outbound call
+// @Description Start a new outbound call via SIP trunk
+// @Tags Calls
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param call body InitiateCallRequest true "Call configuration"
+// @Success 202 {object} models.CallLog
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/calls [post]
+func (h *Handler) InitiateCall(c *gin.Context) {
+	// This is a placeholder - actual implementation requires the SIP server
+	c.JSON(http.StatusNotImplemented, ErrorResponse{Error: "Outbound calling not yet implemented"})
+}
+
+// =============================================================================
+// Supervision Handlers
+// =============================================================================
+
+// supervisorUpgrader accepts supervisor WebSockets from any origin; the
+// single-use token in the URL authenticates them
+var supervisorUpgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// SuperviseCall godoc
+// @Summary Supervise a call
+// @Description Reserve a supervisor leg on an active call and get the WebSocket to connect it to, within 30 seconds. The supervisor hears the caller and agent mixed, as binary 20ms 8kHz PCMU frames, and may send PCMU audio: in whisper mode it is heard by the agent only, in barge mode by both. The call must be on the instance answering the request.
+// @Tags Calls
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Call ID"
+// @Param supervision body SuperviseCallRequest false "Supervision mode"
+// @Success 201 {object} SuperviseCallResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/calls/{id}/supervise [post]
+func (h *Handler) SuperviseCall(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	callID := c.Param("id")
+
+	var req SuperviseCallRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+	if req.Mode == "" {
+		req.Mode = models.SupervisionListen
+	}
+	if err := models.ValidateSupervisionMode(req.Mode); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	callLog, err := h.store.GetCall(c.Request.Context(), accountID, callID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
+		return
+	}
+
+	token, err := h.calls.Supervise(callLog.CallID, req.Mode)
+	if err != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Call not active", Details: err.Error()})
+		return
+	}
 
-	route, err := h.store.GetRoute(c.Request.Context(), accountID, routeID)
+	scheme := "ws"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "wss"
+	}
+	c.JSON(http.StatusCreated, SuperviseCallResponse{
+		Mode:         req.Mode,
+		WebSocketURL: scheme + "://" + c.Request.Host + "/supervise/" + token,
+		ExpiresIn:    int(call.SupervisionTokenTTL.Seconds()),
+	})
+}
+
+// SuperviseStream connects a supervisor's WebSocket to the call its token
+// was issued for
+func (h *Handler) SuperviseStream(c *gin.Context) {
+	session, mode, err := h.calls.ClaimSupervision(c.Param("token"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Route not found"})
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Supervision not found", Details: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, route)
+	conn, err := supervisorUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // The upgrader has replied
+	}
+	if err := session.Supervise(conn, mode); err != nil {
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, err.Error()))
+		_ = conn.Close()
+	}
 }
 
-// CreateRoute godoc
-// @Summary Create a route
-// @Description Create a new SIP routing rule
-// @Tags Routes
-// @Accept json
-// @Produce json
+// =============================================================================
+// Event Stream Handlers
+// =============================================================================
+
+// eventStreamPing is how often idle event streams are pinged, keeping
+// proxies from closing them
+const eventStreamPing = 15 * time.Second
+
+// StreamEvents godoc
+// @Summary Stream call events
+// @Description Push the account's call state changes (call.initiated, call.ringing, call.answered, call.completed, call.failed) with its active-call count as they happen, starting with a snapshot event. With trace, the calls' SIP messages, agent connection and messages, and media changes are pushed too (sip, agent and media events). Served as Server-Sent Events, or as JSON messages when the request is a WebSocket upgrade.
+// @Tags Calls
+// @Produce text/event-stream
 // @Security BasicAuth
-// @Param route body CreateRouteRequest true "Route configuration"
-// @Success 201 {object} models.Route
-// @Failure 400 {object} ErrorResponse
+// @Security BearerAuth
+// @Param trace query bool false "Include trace events"
+// @Param call_id query string false "Only the events of this call (record ID or SIP Call-ID)"
+// @Success 200 {object} eventstream.Event
 // @Failure 401 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
-// @Router /api/v1/routes [post]
-func (h *Handler) CreateRoute(c *gin.Context) {
+// @Router /api/v1/events/stream [get]
+func (h *Handler) StreamEvents(c *gin.Context) {
 	accountID := c.GetString("account_id")
+	loc := accountLocation(c)
+	broker := h.calls.Events()
 
-	var req CreateRouteRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
-		return
+	// Following one call, only its events are sent
+	callID := c.Query("call_id")
+	wanted := func(event eventstream.Event) bool {
+		return callID == "" || event.ID == callID || event.CallID == callID
 	}
 
-	route := &models.Route{
-		Name:                req.Name,
-		Priority:            req.Priority,
-		MatchToUser:         req.MatchToUser,
-		MatchFromUser:       req.MatchFromUser,
-		MatchSIPHeader:      req.MatchSIPHeader,
-		MatchSIPHeaderValue: req.MatchSIPHeaderValue,
-		WebSocketURL:        req.WebSocketURL,
+	events, unsubscribe := broker.Subscribe(accountID, c.Query("trace") == "true")
+	defer unsubscribe()
+
+	snapshot := eventstream.Event{Event: eventstream.EventSnapshot, Timestamp: time.Now().In(loc)}
+	if count, err := broker.ActiveCalls(c.Request.Context(), accountID); err == nil {
+		snapshot.ActiveCalls = count
 	}
 
-	created, err := h.store.CreateRoute(c.Request.Context(), accountID, route)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create route", Details: err.Error()})
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		streamEventsWebSocket(c, snapshot, events, wanted, loc)
 		return
 	}
 
-	// Invalidate route cache
-	if h.cache != nil {
-		_ = h.cache.InvalidateRouteCache(c.Request.Context())
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Don't let nginx buffer the stream
+	c.SSEvent(snapshot.Event, snapshot)
+	c.Writer.Flush()
+
+	ping := time.NewTicker(eventStreamPing)
+	defer ping.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			if !wanted(event) {
+				return true
+			}
+			event.Timestamp = event.Timestamp.In(loc)
+			c.SSEvent(event.Event, event)
+		case <-ping.C:
+			_, _ = io.WriteString(w, ": ping\n\n")
+		case <-c.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+}
+
+// streamEventsWebSocket sends call events as JSON WebSocket messages until
+// the client disconnects. Browsers' cross-origin connections are refused,
+// as their credentials would be sent along.
+func streamEventsWebSocket(c *gin.Context, snapshot eventstream.Event, events <-chan eventstream.Event, wanted func(eventstream.Event) bool, loc *time.Location) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // The upgrader has replied
+	}
+	defer conn.Close()
+
+	// Reading is only needed to notice the client leaving
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(eventStreamPing)
+	defer ping.Stop()
+
+	if err := conn.WriteJSON(snapshot); err != nil {
+		return
 	}
+	for {
+		select {
+		case event := <-events:
+			if !wanted(event) {
+				continue
+			}
+			event.Timestamp = event.Timestamp.In(loc)
+			_ = conn.SetWriteDeadline(time.Now().Add(eventStreamPing))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventStreamPing)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
 
-	c.JSON(http.StatusCreated, created)
+// =============================================================================
+// Softphone Handlers
+// =============================================================================
+
+// SoftphonePage serves the browser softphone test page
+func (h *Handler) SoftphonePage(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", softphone.Page)
 }
 
-// UpdateRoute godoc
-// @Summary Update a route
-// @Description Update an existing SIP routing rule
-// @Tags Routes
+// CreateSoftphoneCall godoc
+// @Summary Call a route from a browser
+// @Description Answer a browser's WebRTC offer with a call to one of the account's routes. The answer includes all ICE candidates.
+// @Tags Softphone
 // @Accept json
 // @Produce json
 // @Security BasicAuth
-// @Param id path string true "Route ID"
-// @Param route body UpdateRouteRequest true "Route configuration"
-// @Success 200 {object} models.Route
+// @Security BearerAuth
+// @Param call body SoftphoneCallRequest true "Route and SDP offer"
+// @Success 201 {object} SoftphoneCallResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
-// @Router /api/v1/routes/{id} [put]
-func (h *Handler) UpdateRoute(c *gin.Context) {
+// @Failure 503 {object} ErrorResponse
+// @Router /api/v1/softphone/calls [post]
+func (h *Handler) CreateSoftphoneCall(c *gin.Context) {
 	accountID := c.GetString("account_id")
-	routeID := c.Param("id")
 
-	var req UpdateRouteRequest
+	var req SoftphoneCallRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
 		return
 	}
-
-	route := &models.Route{
-		ID:                  routeID,
-		Name:                req.Name,
-		Priority:            req.Priority,
-		MatchToUser:         req.MatchToUser,
-		MatchFromUser:       req.MatchFromUser,
-		MatchSIPHeader:      req.MatchSIPHeader,
-		MatchSIPHeaderValue: req.MatchSIPHeaderValue,
-		WebSocketURL:        req.WebSocketURL,
-		Active:              req.Active,
+	if req.From == "" {
+		req.From = "browser"
 	}
 
-	updated, err := h.store.UpdateRoute(c.Request.Context(), accountID, route)
+	route, err := h.store.GetRoute(c.Request.Context(), accountID, req.RouteID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update route", Details: err.Error()})
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Route not found"})
 		return
 	}
 
-	// Invalidate route cache
-	if h.cache != nil {
-		_ = h.cache.InvalidateRouteCache(c.Request.Context())
+	result, err := h.softphone.Dial(c.Request.Context(), route, req.From, req.SDP)
+	if err != nil {
+		switch {
+		case errors.Is(err, softphone.ErrInvalidOffer):
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		case errors.Is(err, call.ErrNoCapacity), errors.Is(err, call.ErrRouteBusy), errors.Is(err, softphone.ErrAgentUnavailable):
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "Call failed", Details: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Call failed", Details: err.Error()})
+		}
+		return
 	}
 
-	c.JSON(http.StatusOK, updated)
+	c.JSON(http.StatusCreated, SoftphoneCallResponse{CallID: result.ID, SDP: result.Answer})
 }
 
-// DeleteRoute godoc
-// @Summary Delete a route
-// @Description Delete a SIP routing rule
-// @Tags Routes
-// @Accept json
+// EndSoftphoneCall godoc
+// @Summary Hang up a browser call
+// @Tags Softphone
 // @Produce json
 // @Security BasicAuth
-// @Param id path string true "Route ID"
+// @Security BearerAuth
+// @Param id path string true "Call ID"
 // @Success 200 {object} SuccessResponse
 // @Failure 401 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
-// @Router /api/v1/routes/{id} [delete]
-func (h *Handler) DeleteRoute(c *gin.Context) {
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/softphone/calls/{id} [delete]
+func (h *Handler) EndSoftphoneCall(c *gin.Context) {
 	accountID := c.GetString("account_id")
-	routeID := c.Param("id")
 
-	if err := h.store.DeleteRoute(c.Request.Context(), accountID, routeID); err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete route", Details: err.Error()})
+	if !h.softphone.Hangup(accountID, c.Param("id")) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
 		return
 	}
 
-	// Invalidate route cache
-	if h.cache != nil {
-		_ = h.cache.InvalidateRouteCache(c.Request.Context())
-	}
-
-	c.JSON(http.StatusOK, SuccessResponse{Message: "Route deleted successfully"})
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Call ended"})
 }
 
 // =============================================================================
-// Trunk Handlers
+// Webhook Handlers
 // =============================================================================
 
-// ListTrunks godoc
-// @Summary List all trunks
-// @Description Get all SIP trunks for the account
-// @Tags Trunks
+// ListWebhooks godoc
+// @Summary List all webhooks
+// @Description Get all call event webhooks for the account (secrets are not shown)
+// @Tags Webhooks
 // @Accept json
 // @Produce json
 // @Security BasicAuth
-// @Success 200 {array} models.Trunk
+// @Security BearerAuth
+// @Success 200 {array} models.Webhook
 // @Failure 401 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /api/v1/trunks [get]
-func (h *Handler) ListTrunks(c *gin.Context) {
+// @Router /api/v1/webhooks [get]
+func (h *Handler) ListWebhooks(c *gin.Context) {
 	accountID := c.GetString("account_id")
 
-	trunks, err := h.store.ListTrunks(c.Request.Context(), accountID)
+	hooks, err := h.store.ListWebhooks(c.Request.Context(), accountID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch trunks", Details: err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch webhooks", Details: err.Error()})
 		return
 	}
 
-	if trunks == nil {
-		trunks = []*models.Trunk{}
+	if hooks == nil {
+		hooks = []*models.Webhook{}
+	}
+	loc := accountLocation(c)
+	for _, hook := range hooks {
+		hook.Secret = ""
+		hook.Localize(loc)
 	}
 
-	c.JSON(http.StatusOK, trunks)
+	c.JSON(http.StatusOK, hooks)
 }
 
-// GetTrunk godoc
-// @Summary Get a trunk
-// @Description Get a specific SIP trunk by ID
-// @Tags Trunks
+// GetWebhook godoc
+// @Summary Get a webhook
+// @Description Get a specific call event webhook by ID (the secret is not shown)
+// @Tags Webhooks
 // @Accept json
 // @Produce json
 // @Security BasicAuth
-// @Param id path string true "Trunk ID"
-// @Success 200 {object} models.Trunk
+// @Security BearerAuth
+// @Param id path string true "Webhook ID"
+// @Success 200 {object} models.Webhook
 // @Failure 401 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
-// @Router /api/v1/trunks/{id} [get]
-func (h *Handler) GetTrunk(c *gin.Context) {
+// @Router /api/v1/webhooks/{id} [get]
+func (h *Handler) GetWebhook(c *gin.Context) {
 	accountID := c.GetString("account_id")
-	trunkID := c.Param("id")
+	webhookID := c.Param("id")
 
-	trunk, err := h.store.GetTrunk(c.Request.Context(), accountID, trunkID)
+	hook, err := h.store.GetWebhook(c.Request.Context(), accountID, webhookID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Trunk not found"})
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Webhook not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, trunk)
+	hook.Secret = ""
+	hook.Localize(accountLocation(c))
+	c.JSON(http.StatusOK, hook)
 }
 
-// CreateTrunk godoc
-// @Summary Create a trunk
-// @Description Create a new SIP trunk
-// @Tags Trunks
+// CreateWebhook godoc
+// @Summary Register a webhook
+// @Description Register a URL to receive call events, signed with the webhook's secret. The secret is only returned here.
+// @Tags Webhooks
 // @Accept json
 // @Produce json
 // @Security BasicAuth
-// @Param trunk body CreateTrunkRequest true "Trunk configuration"
-// @Success 201 {object} models.Trunk
+// @Security BearerAuth
+// @Param webhook body CreateWebhookRequest true "Webhook configuration"
+// @Success 201 {object} models.Webhook
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /api/v1/trunks [post]
-func (h *Handler) CreateTrunk(c *gin.Context) {
+// @Router /api/v1/webhooks [post]
+func (h *Handler) CreateWebhook(c *gin.Context) {
 	accountID := c.GetString("account_id")
 
-	var req CreateTrunkRequest
+	var req CreateWebhookRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
 		return
 	}
 
-	port := req.Port
-	if port == 0 {
-		port = 5060
+	hook := &models.Webhook{
+		URL:    req.URL,
+		Events: req.Events,
 	}
-
-	transport := req.Transport
-	if transport == "" {
-		transport = "udp"
+	if err := hook.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
 	}
 
-	trunk := &models.Trunk{
-		Name:             req.Name,
-		Host:             req.Host,
-		Port:             port,
-		Transport:        transport,
-		Username:         req.Username,
-		Password:         req.Password,
-		FromUser:         req.FromUser,
-		FromHost:         req.FromHost,
-		Register:         req.Register,
-		RegisterInterval: req.RegisterInterval,
+	if req.Secret != nil && *req.Secret != "" {
+		hook.Secret = *req.Secret
+	} else {
+		secret, err := webhook.GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate webhook secret", Details: err.Error()})
+			return
+		}
+		hook.Secret = secret
 	}
 
-	created, err := h.store.CreateTrunk(c.Request.Context(), accountID, trunk)
+	created, err := h.store.CreateWebhook(c.Request.Context(), accountID, hook)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create trunk", Details: err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create webhook", Details: err.Error()})
 		return
 	}
 
+	created.Localize(accountLocation(c))
 	c.JSON(http.StatusCreated, created)
 }
 
-// UpdateTrunk godoc
-// @Summary Update a trunk
-// @Description Update an existing SIP trunk
-// @Tags Trunks
+// UpdateWebhook godoc
+// @Summary Update a webhook
+// @Description Update a call event webhook's URL, events or secret
+// @Tags Webhooks
 // @Accept json
 // @Produce json
 // @Security BasicAuth
-// @Param id path string true "Trunk ID"
-// @Param trunk body UpdateTrunkRequest true "Trunk configuration"
-// @Success 200 {object} models.Trunk
+// @Security BearerAuth
+// @Param id path string true "Webhook ID"
+// @Param webhook body UpdateWebhookRequest true "Webhook configuration"
+// @Success 200 {object} models.Webhook
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /api/v1/trunks/{id} [put]
-func (h *Handler) UpdateTrunk(c *gin.Context) {
+// @Router /api/v1/webhooks/{id} [put]
+func (h *Handler) UpdateWebhook(c *gin.Context) {
 	accountID := c.GetString("account_id")
-	trunkID := c.Param("id")
+	webhookID := c.Param("id")
 
-	var req UpdateTrunkRequest
+	var req UpdateWebhookRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
 		return
 	}
 
-	port := req.Port
-	if port == 0 {
-		port = 5060
+	hook := &models.Webhook{
+		ID:     webhookID,
+		URL:    req.URL,
+		Events: req.Events,
+		Active: req.Active,
 	}
-
-	transport := req.Transport
-	if transport == "" {
-		transport = "udp"
+	if req.Secret != nil {
+		hook.Secret = *req.Secret
 	}
-
-	trunk := &models.Trunk{
-		ID:               trunkID,
-		Name:             req.Name,
-		Host:             req.Host,
-		Port:             port,
-		Transport:        transport,
-		Username:         req.Username,
-		Password:         req.Password,
-		FromUser:         req.FromUser,
-		FromHost:         req.FromHost,
-		Register:         req.Register,
-		RegisterInterval: req.RegisterInterval,
-		Active:           req.Active,
+	if err := hook.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
 	}
 
-	updated, err := h.store.UpdateTrunk(c.Request.Context(), accountID, trunk)
+	updated, err := h.store.UpdateWebhook(c.Request.Context(), accountID, hook)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update trunk", Details: err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update webhook", Details: err.Error()})
 		return
 	}
 
+	updated.Secret = ""
+	updated.Localize(accountLocation(c))
 	c.JSON(http.StatusOK, updated)
 }
 
-// DeleteTrunk godoc
-// @Summary Delete a trunk
-// @Description Delete a SIP trunk
-// @Tags Trunks
+// DeleteWebhook godoc
+// @Summary Delete a webhook
+// @Description Delete a call event webhook
+// @Tags Webhooks
 // @Accept json
 // @Produce json
 // @Security BasicAuth
-// @Param id path string true "Trunk ID"
+// @Security BearerAuth
+// @Param id path string true "Webhook ID"
 // @Success 200 {object} SuccessResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /api/v1/trunks/{id} [delete]
-func (h *Handler) DeleteTrunk(c *gin.Context) {
+// @Router /api/v1/webhooks/{id} [delete]
+func (h *Handler) DeleteWebhook(c *gin.Context) {
 	accountID := c.GetString("account_id")
-	trunkID := c.Param("id")
+	webhookID := c.Param("id")
 
-	if err := h.store.DeleteTrunk(c.Request.Context(), accountID, trunkID); err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete trunk", Details: err.Error()})
+	if err := h.store.DeleteWebhook(c.Request.Context(), accountID, webhookID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete webhook", Details: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{Message: "Trunk deleted successfully"})
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Webhook deleted successfully"})
 }
 
-// =============================================================================
-// Call Handlers
-// =============================================================================
-
-// ListCalls godoc
-// @Summary List recent calls
-// @Description Get recent call detail records for the account
-// @Tags Calls
+// ListWebhookDeadLetters godoc
+// @Summary List undelivered webhook events
+// @Description Get the most recent call events that could not be delivered to a webhook after all retries
+// @Tags Webhooks
 // @Accept json
 // @Produce json
 // @Security BasicAuth
-// @Param limit query int false "Maximum number of records" default(100)
-// @Success 200 {array} models.CallLog
+// @Security BearerAuth
+// @Success 200 {array} models.WebhookDeadLetter
 // @Failure 401 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /api/v1/calls [get]
-func (h *Handler) ListCalls(c *gin.Context) {
+// @Router /api/v1/webhooks/dead-letters [get]
+func (h *Handler) ListWebhookDeadLetters(c *gin.Context) {
 	accountID := c.GetString("account_id")
 
-	calls, err := h.store.ListCalls(c.Request.Context(), accountID, 100)
+	letters, err := h.store.ListWebhookDeadLetters(c.Request.Context(), accountID, 100)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch calls", Details: err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch dead letters", Details: err.Error()})
 		return
 	}
 
-	if calls == nil {
-		calls = []*models.CallLog{}
+	if letters == nil {
+		letters = []*models.WebhookDeadLetter{}
 	}
-
-	c.JSON(http.StatusOK, calls)
-}
-
-// GetCall godoc
-// @Summary Get a call
-// @Description Get a specific call detail record by ID
-// @Tags Calls
-// @Accept json
-// @Produce json
-// @Security BasicAuth
-// @Param id path string true "Call ID"
-// @Success 200 {object} models.CallLog
-// @Failure 401 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
-// @Router /api/v1/calls/{id} [get]
-func (h *Handler) GetCall(c *gin.Context) {
-	accountID := c.GetString("account_id")
-	callID := c.Param("id")
-
-	call, err := h.store.GetCall(c.Request.Context(), accountID, callID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
-		return
+	loc := accountLocation(c)
+	for _, d := range letters {
+		d.Localize(loc)
 	}
 
-	c.JSON(http.StatusOK, call)
-}
-
-// InitiateCall godoc
-// @Summary Initiate an outbound call
-// @Description Start a new outbound call via SIP trunk
-// @Tags Calls
-// @Accept json
-// @Produce json
-// @Security BasicAuth
-// @Param call body InitiateCallRequest true "Call configuration"
-// @Success 202 {object} models.CallLog
-// @Failure 400 {object} ErrorResponse
-// @Failure 401 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
-// @Router /api/v1/calls [post]
-func (h *Handler) InitiateCall(c *gin.Context) {
-	// This is a placeholder - actual implementation requires the SIP server
-	c.JSON(http.StatusNotImplemented, ErrorResponse{Error: "Outbound calling not yet implemented"})
+	c.JSON(http.StatusOK, letters)
 }
 
 // =============================================================================
@@ -566,9 +2866,22 @@ func (h *Handler) InitiateCall(c *gin.Context) {
 // @Success 200 {object} map[string]string
 // @Router /health [get]
 func (h *Handler) HealthCheck(c *gin.Context) {
+	info := version.Get()
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "healthy",
 		"service": "blayzen-sip",
+		"version": info.Version,
+		"commit":  info.Commit,
 	})
 }
 
+// Version godoc
+// @Summary Build version
+// @Description The running build's version, commit, branch, build date and Go runtime
+// @Tags Health
+// @Produce json
+// @Success 200 {object} version.Info
+// @Router /version [get]
+func (h *Handler) Version(c *gin.Context) {
+	c.JSON(http.StatusOK, version.Get())
+}
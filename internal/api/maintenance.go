@@ -0,0 +1,152 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// ScheduleMaintenanceRequest is the request body for scheduling a route
+// maintenance window
+type ScheduleMaintenanceRequest struct {
+	StartsAt        *time.Time `json:"starts_at,omitempty"`                       // Now when omitted
+	EndsAt          *time.Time `json:"ends_at,omitempty"`                         // Either ends_at or duration_seconds
+	DurationSeconds int        `json:"duration_seconds,omitempty" example:"3600"` // From starts_at
+	StatusCode      int        `json:"status_code,omitempty" example:"503"`       // 503 when omitted
+	Reason          *string    `json:"reason,omitempty" example:"Agent Maintenance"`
+	DivertTo        *string    `json:"divert_to,omitempty" example:"sip:backup@pbx.example.com"`
+	Announcement    *string    `json:"announcement,omitempty" example:"file:maintenance.wav"`
+}
+
+// ListMaintenanceWindows godoc
+// @Summary List route maintenance windows
+// @Description Get a route's maintenance windows, latest first, including those that ended
+// @Tags Routes
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Route ID"
+// @Success 200 {array} models.MaintenanceWindow
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/routes/{id}/maintenance [get]
+func (h *Handler) ListMaintenanceWindows(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	routeID := c.Param("id")
+
+	if _, err := h.store.GetRoute(c.Request.Context(), accountID, routeID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Route not found"})
+		return
+	}
+
+	windows, err := h.store.ListRouteMaintenanceWindows(c.Request.Context(), accountID, routeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch maintenance windows", Details: err.Error()})
+		return
+	}
+
+	if windows == nil {
+		windows = []*models.MaintenanceWindow{}
+	}
+	loc := accountLocation(c)
+	for _, w := range windows {
+		w.Localize(loc)
+	}
+	c.JSON(http.StatusOK, windows)
+}
+
+// ScheduleMaintenance godoc
+// @Summary Schedule route maintenance
+// @Description Take a route offline for a period: its calls are redirected (302) to divert_to, or rejected with status_code, after playing the announcement (a ringback spec) once as early media when set. 503 rejections carry a Retry-After of when the window ends. The window applies on this instance at once and on the others within 15 seconds, and stops applying at its end; calls in progress are unaffected.
+// @Tags Routes
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Route ID"
+// @Param window body ScheduleMaintenanceRequest true "Maintenance window"
+// @Success 201 {object} models.MaintenanceWindow
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/routes/{id}/maintenance [post]
+func (h *Handler) ScheduleMaintenance(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	routeID := c.Param("id")
+
+	var req ScheduleMaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if _, err := h.store.GetRoute(c.Request.Context(), accountID, routeID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Route not found"})
+		return
+	}
+
+	window := &models.MaintenanceWindow{
+		AccountID:    accountID,
+		RouteID:      routeID,
+		StartsAt:     time.Now(),
+		StatusCode:   req.StatusCode,
+		Reason:       req.Reason,
+		DivertTo:     req.DivertTo,
+		Announcement: req.Announcement,
+	}
+	if req.StartsAt != nil {
+		window.StartsAt = *req.StartsAt
+	}
+	switch {
+	case req.EndsAt != nil && req.DurationSeconds == 0:
+		window.EndsAt = *req.EndsAt
+	case req.EndsAt == nil && req.DurationSeconds > 0:
+		window.EndsAt = window.StartsAt.Add(time.Duration(req.DurationSeconds) * time.Second)
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "exactly one of ends_at and a positive duration_seconds must be set"})
+		return
+	}
+	if window.StatusCode == 0 {
+		window.StatusCode = models.DefaultMaintenanceStatus
+	}
+	if err := window.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	saved, err := h.maintenance.Schedule(c.Request.Context(), window)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to schedule maintenance", Details: err.Error()})
+		return
+	}
+
+	saved.Localize(accountLocation(c))
+	c.JSON(http.StatusCreated, saved)
+}
+
+// DeleteMaintenanceWindow godoc
+// @Summary Delete a route maintenance window
+// @Description Remove a maintenance window, ending it early or cancelling it before it starts; the route takes calls again
+// @Tags Routes
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Route ID"
+// @Param window_id path string true "Maintenance window ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/routes/{id}/maintenance/{window_id} [delete]
+func (h *Handler) DeleteMaintenanceWindow(c *gin.Context) {
+	err := h.maintenance.Delete(c.Request.Context(), c.GetString("account_id"), c.Param("id"), c.Param("window_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Maintenance window not found", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Maintenance window deleted successfully"})
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// SetNumberOverrideRequest is the target of an emergency number override
+type SetNumberOverrideRequest struct {
+	WebSocketURL *string `json:"websocket_url,omitempty" example:"wss://standby.example.com/ws"` // Agent taking the calls
+	TrunkID      *string `json:"trunk_id,omitempty"`                                             // Trunk the calls are redirected to
+	Reason       *string `json:"reason,omitempty" example:"Primary agent outage"`
+	TTLSeconds   int     `json:"ttl_seconds,omitempty" example:"3600"` // How long the override lasts; 1 hour when omitted, 7 days at most
+}
+
+// AdminListNumberOverrides godoc
+// @Summary List number overrides
+// @Description List the emergency number overrides that haven't expired. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Success 200 {array} models.NumberOverride
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/number-overrides [get]
+func (h *Handler) AdminListNumberOverrides(c *gin.Context) {
+	overrides, err := h.store.ListNumberOverrides(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to list number overrides", Details: err.Error()})
+		return
+	}
+	if overrides == nil {
+		overrides = []*models.NumberOverride{}
+	}
+	c.JSON(http.StatusOK, overrides)
+}
+
+// AdminSetNumberOverride godoc
+// @Summary Override a number
+// @Description Send the calls to a number (the user part of the To URI) to another agent, or redirect them (302) out through a trunk, ahead of any route, until the override expires. Replaces any previous override of the number. New calls on this instance use it at once, and on the other instances within 15 seconds; calls in progress are unaffected. Requires the admin API key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security AdminAuth
+// @Param number path string true "Number"
+// @Param override body SetNumberOverrideRequest true "Override target"
+// @Success 200 {object} models.NumberOverride
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/number-overrides/{number} [put]
+func (h *Handler) AdminSetNumberOverride(c *gin.Context) {
+	var req SetNumberOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	ttl := models.DefaultNumberOverrideTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > models.MaxNumberOverrideTTL {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "ttl_seconds must be between 1 and 604800"})
+		return
+	}
+
+	override := &models.NumberOverride{
+		Number:       c.Param("number"),
+		WebSocketURL: req.WebSocketURL,
+		TrunkID:      req.TrunkID,
+		Reason:       req.Reason,
+		ExpiresAt:    time.Now().Add(ttl),
+	}
+	if err := override.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	saved, err := h.overrides.Set(c.Request.Context(), override)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save number override", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// AdminDeleteNumberOverride godoc
+// @Summary Remove a number override
+// @Description Remove the override of a number before it expires, routing its calls as usual again. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Param number path string true "Number"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/admin/number-overrides/{number} [delete]
+func (h *Handler) AdminDeleteNumberOverride(c *gin.Context) {
+	if err := h.overrides.Delete(c.Request.Context(), c.Param("number")); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Number override not found", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Number override removed successfully"})
+}
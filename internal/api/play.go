@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiv6146/blayzen-sip/internal/call"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// maxAnnouncementBytes bounds audio uploaded or fetched to play on a call,
+// enough for 5 minutes of 16-bit WAV
+const maxAnnouncementBytes = 10 << 20
+
+// announcementClient fetches audio played on calls from URLs
+var announcementClient = &http.Client{Timeout: 15 * time.Second}
+
+// PlayCall godoc
+// @Summary Play audio on a call
+// @Description Play an announcement to the caller of an answered call, e.g. a compliance notice. Send the audio as the body (an 8kHz mono WAV file, or raw 8kHz mu-law as audio/basic) with mode and interrupt as query parameters, or send JSON with the URL to fetch it from. In mix mode the audio is heard over the agent; in replace mode the agent's audio is discarded while it plays. interrupt discards agent audio queued so far. Announcements play one after another. The call must be on the instance answering the request.
+// @Tags Calls
+// @Accept json
+// @Accept audio/wav
+// @Accept audio/basic
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Call ID"
+// @Param mode query string false "mix or replace, for uploaded audio" default(mix)
+// @Param interrupt query bool false "Discard queued agent audio, for uploaded audio" default(false)
+// @Param play body PlayCallRequest false "Audio URL, when not uploading the audio"
+// @Success 202 {object} PlayCallResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /api/v1/calls/{id}/play [post]
+func (h *Handler) PlayCall(c *gin.Context) {
+	accountID := c.GetString("account_id")
+	fromURL := c.ContentType() == gin.MIMEJSON
+
+	var req PlayCallRequest
+	if fromURL {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	} else {
+		req.Mode = c.Query("mode")
+		if v := c.Query("interrupt"); v != "" {
+			interrupt, err := strconv.ParseBool(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "interrupt: must be true or false"})
+				return
+			}
+			req.Interrupt = interrupt
+		}
+	}
+	if req.Mode == "" {
+		req.Mode = models.PlayModeMix
+	}
+	if err := models.ValidatePlayMode(req.Mode); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	callLog, err := h.store.GetCall(c.Request.Context(), accountID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
+		return
+	}
+
+	var data []byte
+	contentType := c.ContentType()
+	if fromURL {
+		data, contentType, err = fetchAnnouncement(c.Request.Context(), req.URL)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, ErrorResponse{Error: "Failed to fetch audio", Details: err.Error()})
+			return
+		}
+	} else {
+		data, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxAnnouncementBytes))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	pcmu, err := call.ParseAnnouncement(contentType, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	length, err := h.calls.Play(callLog.CallID, pcmu, req.Mode, req.Interrupt)
+	switch {
+	case errors.Is(err, call.ErrCallNotActive), errors.Is(err, call.ErrCallNotAnswered):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Call not active", Details: err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, PlayCallResponse{DurationMs: length.Milliseconds()})
+}
+
+// fetchAnnouncement downloads audio to play on a call, returning it with its
+// content type
+func fetchAnnouncement(ctx context.Context, rawURL string) ([]byte, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", fmt.Errorf("url: must be an http or https URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := announcementClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAnnouncementBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxAnnouncementBytes {
+		return nil, "", fmt.Errorf("audio larger than %d bytes", maxAnnouncementBytes)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
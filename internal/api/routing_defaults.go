@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/routing"
+)
+
+// RoutingDefaultsResponse is the routing defaults changed at runtime and the
+// defaults calls are routed with as a result
+type RoutingDefaultsResponse struct {
+	Overrides *models.RoutingDefaults    `json:"overrides"`
+	Effective *routing.EffectiveDefaults `json:"effective"`
+}
+
+// AdminGetRoutingDefaults godoc
+// @Summary Get the routing defaults
+// @Description Return the routing defaults changed at runtime and those in effect on the instance answering the request: the default agent of calls matching no route, the statuses calls are rejected with when no route matches or no agent can be reached, and the agent connect timeout. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Success 200 {object} RoutingDefaultsResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/admin/routing-defaults [get]
+func (h *Handler) AdminGetRoutingDefaults(c *gin.Context) {
+	c.JSON(http.StatusOK, RoutingDefaultsResponse{
+		Overrides: h.defaults.Overrides(),
+		Effective: h.defaults.Get(),
+	})
+}
+
+// AdminSetRoutingDefaults godoc
+// @Summary Change the routing defaults
+// @Description Replace the routing defaults changed at runtime, without a restart: fields left out revert to the configured values, and an empty default_websocket_url rejects calls matching no route. New calls on this instance use them at once, and on the other instances within 15 seconds; calls in progress are unaffected. Requires the admin API key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security AdminAuth
+// @Param defaults body models.RoutingDefaults true "Routing defaults"
+// @Success 200 {object} RoutingDefaultsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/admin/routing-defaults [put]
+func (h *Handler) AdminSetRoutingDefaults(c *gin.Context) {
+	var req models.RoutingDefaults
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	req.UpdatedAt = nil
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	overrides, err := h.defaults.Set(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save routing defaults", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, RoutingDefaultsResponse{Overrides: overrides, Effective: h.defaults.Get()})
+}
@@ -2,18 +2,34 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shiv6146/blayzen-sip/internal/acl"
+	"github.com/shiv6146/blayzen-sip/internal/apitoken"
+	"github.com/shiv6146/blayzen-sip/internal/call"
 	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/cps"
+	"github.com/shiv6146/blayzen-sip/internal/failover"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/routing"
+	"github.com/shiv6146/blayzen-sip/internal/scanner"
+	"github.com/shiv6146/blayzen-sip/internal/softphone"
+	"github.com/shiv6146/blayzen-sip/internal/storage"
 	"github.com/shiv6146/blayzen-sip/internal/store"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
+var logger = logging.Component("api")
+
 // Server represents the REST API server
 type Server struct {
 	config     *config.Config
@@ -24,15 +40,17 @@ type Server struct {
 	httpServer *http.Server
 }
 
-// NewServer creates a new API server
-func NewServer(cfg *config.Config, store *store.PostgresStore, cache *store.Cache) *Server {
+// NewServer creates a new API server. phone is nil when the browser
+// softphone is disabled, guard when scanner protection is, and tokens when
+// bearer tokens are; calls are the SIP server's active calls.
+func NewServer(cfg *config.Config, store *store.PostgresStore, cache *store.Cache, blobs storage.Store, phone *softphone.Gateway, calls *call.Manager, defaults *routing.Defaults, overrides *routing.NumberOverrides, maintenance *routing.Maintenance, sourceACL *acl.ACL, guard *scanner.Guard, limiter *cps.Limiter, pair *failover.Pair, tokens *apitoken.Issuer) *Server {
 	gin.SetMode(cfg.GinMode)
 
 	router := gin.New()
-	router.Use(gin.Logger())
+	router.Use(requestLogger(cfg.MetricsPath))
 	router.Use(gin.Recovery())
 
-	handler := NewHandler(store, cache)
+	handler := NewHandler(cfg, store, cache, blobs, phone, calls, defaults, overrides, maintenance, sourceACL, guard, limiter, pair, tokens)
 
 	s := &Server{
 		config:  cfg,
@@ -50,10 +68,16 @@ func NewServer(cfg *config.Config, store *store.PostgresStore, cache *store.Cach
 func (s *Server) setupRoutes() {
 	// Health check (no auth required)
 	s.router.GET("/health", s.handler.HealthCheck)
+	s.router.GET("/version", s.handler.Version)
 
 	// Swagger documentation
 	s.router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Prometheus metrics (no auth required)
+	if s.config.MetricsEnabled {
+		s.router.GET(s.config.MetricsPath, gin.WrapH(metrics.Handler()))
+	}
+
 	// API v1 routes
 	v1 := s.router.Group("/api/v1")
 
@@ -62,6 +86,16 @@ func (s *Server) setupRoutes() {
 		v1.Use(s.authMiddleware())
 	}
 
+	// Account settings
+	v1.GET("/account", s.handler.GetAccount)
+	v1.PUT("/account", s.handler.UpdateAccount)
+	v1.GET("/account/bandwidth", s.handler.GetAccountBandwidth)
+
+	// Bearer tokens in exchange for API key credentials
+	if s.config.APIAuthEnabled && s.handler.tokens != nil {
+		v1.POST("/auth/token", s.handler.IssueToken)
+	}
+
 	// Routes
 	routes := v1.Group("/routes")
 	{
@@ -70,6 +104,10 @@ func (s *Server) setupRoutes() {
 		routes.POST("", s.handler.CreateRoute)
 		routes.PUT("/:id", s.handler.UpdateRoute)
 		routes.DELETE("/:id", s.handler.DeleteRoute)
+		routes.GET("/:id/maintenance", s.handler.ListMaintenanceWindows)
+		routes.POST("/:id/maintenance", s.handler.ScheduleMaintenance)
+		routes.DELETE("/:id/maintenance/:window_id", s.handler.DeleteMaintenanceWindow)
+		routes.GET("/:id/splits", s.handler.GetRouteSplitStats)
 	}
 
 	// Trunks
@@ -80,20 +118,120 @@ func (s *Server) setupRoutes() {
 		trunks.POST("", s.handler.CreateTrunk)
 		trunks.PUT("/:id", s.handler.UpdateTrunk)
 		trunks.DELETE("/:id", s.handler.DeleteTrunk)
+		trunks.GET("/:id/stats", s.handler.GetTrunkStats)
 	}
 
 	// Calls
 	calls := v1.Group("/calls")
 	{
 		calls.GET("", s.handler.ListCalls)
+		calls.GET("/export", s.handler.ExportCalls)
+		calls.GET("/exports", s.handler.ListCallExports)
+		calls.GET("/exports/:name", s.handler.GetCallExport)
+		calls.GET("/active", s.handler.ListActiveCalls)
 		calls.GET("/:id", s.handler.GetCall)
+		calls.GET("/:id/flow", s.handler.GetCallFlow)
+		calls.GET("/:id/recording", s.handler.GetCallRecording)
+		calls.GET("/:id/numbers", s.handler.GetCallNumbers)
+		calls.GET("/:id/trace", s.handler.GetCallTrace)
 		calls.POST("", s.handler.InitiateCall)
+		calls.POST("/exports", s.handler.SaveCallExport)
+		calls.POST("/:id/supervise", s.handler.SuperviseCall)
+		calls.POST("/:id/play", s.handler.PlayCall)
+		calls.POST("/:id/transfer", s.handler.TransferCall)
+	}
+
+	// Supervisor WebSockets, authenticated by the token from /supervise
+	s.router.GET("/supervise/:token", s.handler.SuperviseStream)
+
+	// Call event webhooks
+	hooks := v1.Group("/webhooks")
+	{
+		hooks.GET("", s.handler.ListWebhooks)
+		hooks.GET("/dead-letters", s.handler.ListWebhookDeadLetters)
+		hooks.GET("/:id", s.handler.GetWebhook)
+		hooks.POST("", s.handler.CreateWebhook)
+		hooks.PUT("/:id", s.handler.UpdateWebhook)
+		hooks.DELETE("/:id", s.handler.DeleteWebhook)
+	}
+
+	// SIP credentials of callers that aren't a trunk
+	creds := v1.Group("/sip-credentials")
+	{
+		creds.GET("", s.handler.ListSIPCredentials)
+		creds.POST("", s.handler.CreateSIPCredential)
+		creds.PUT("/:id", s.handler.UpdateSIPCredential)
+		creds.DELETE("/:id", s.handler.DeleteSIPCredential)
+	}
+
+	// Domains attributing inbound calls to the account
+	domains := v1.Group("/sip-domains")
+	{
+		domains.GET("", s.handler.ListSIPDomains)
+		domains.POST("", s.handler.CreateSIPDomain)
+		domains.DELETE("/:id", s.handler.DeleteSIPDomain)
+	}
+
+	// Real-time call events (SSE or WebSocket)
+	v1.GET("/events/stream", s.handler.StreamEvents)
+
+	// Capacity preemption audit trail
+	v1.GET("/preemptions", s.handler.ListPreemptions)
+
+	// Admin API for accounts and their API keys, with its own credential
+	if s.config.AdminAPIKey != "" {
+		admin := s.router.Group("/api/v1/admin", s.adminAuthMiddleware())
+		{
+			admin.GET("/accounts", s.handler.AdminListAccounts)
+			admin.GET("/accounts/:id", s.handler.AdminGetAccount)
+			admin.POST("/accounts", s.handler.AdminCreateAccount)
+			admin.PUT("/accounts/:id", s.handler.AdminUpdateAccount)
+			admin.DELETE("/accounts/:id", s.handler.AdminDeactivateAccount)
+			admin.GET("/accounts/:id/api-keys", s.handler.AdminListAPIKeys)
+			admin.POST("/accounts/:id/api-keys", s.handler.AdminCreateAPIKey)
+			admin.POST("/accounts/:id/api-keys/:keyId/rotate", s.handler.AdminRotateAPIKey)
+			admin.DELETE("/accounts/:id/api-keys/:keyId", s.handler.AdminRevokeAPIKey)
+			admin.GET("/config", s.handler.AdminGetConfig)
+			admin.GET("/routing-defaults", s.handler.AdminGetRoutingDefaults)
+			admin.PUT("/routing-defaults", s.handler.AdminSetRoutingDefaults)
+			admin.GET("/cps-limits", s.handler.AdminGetCPSLimits)
+			admin.PUT("/cps-limits", s.handler.AdminSetCPSLimits)
+			admin.GET("/number-overrides", s.handler.AdminListNumberOverrides)
+			admin.PUT("/number-overrides/:number", s.handler.AdminSetNumberOverride)
+			admin.DELETE("/number-overrides/:number", s.handler.AdminDeleteNumberOverride)
+			admin.GET("/acl", s.handler.AdminListACLEntries)
+			admin.POST("/acl", s.handler.AdminCreateACLEntry)
+			admin.DELETE("/acl/:id", s.handler.AdminDeleteACLEntry)
+			admin.GET("/bans", s.handler.AdminListBans)
+			admin.POST("/bans", s.handler.AdminCreateBan)
+			admin.DELETE("/bans/:address", s.handler.AdminDeleteBan)
+			admin.GET("/sessions/:call_id", s.handler.AdminGetSession)
+			admin.GET("/failover", s.handler.AdminGetFailover)
+			admin.POST("/failover/promote", s.handler.AdminPromote)
+		}
+	}
+
+	// Browser softphone; the page itself needs no auth
+	if s.handler.softphone != nil {
+		s.router.GET("/softphone", s.handler.SoftphonePage)
+
+		phone := v1.Group("/softphone")
+		{
+			phone.POST("/calls", s.handler.CreateSoftphoneCall)
+			phone.DELETE("/calls/:id", s.handler.EndSoftphoneCall)
+		}
 	}
 }
 
-// authMiddleware validates Basic Auth credentials against the database
+// authMiddleware validates Basic Auth credentials against the database, or
+// bearer tokens by their signature
 func (s *Server) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && s.handler.tokens != nil {
+			s.authenticateToken(c, token)
+			return
+		}
+
 		accountID, apiKey, ok := c.Request.BasicAuth()
 		if !ok {
 			c.Header("WWW-Authenticate", `Basic realm="blayzen-sip"`)
@@ -103,7 +241,7 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		account, err := s.store.ValidateAPIKey(c.Request.Context(), accountID, apiKey)
+		account, key, err := s.store.ValidateAPIKey(c.Request.Context(), accountID, apiKey)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
 				Error: "Invalid credentials",
@@ -111,14 +249,102 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if !key.Allows(c.Request.Method, c.FullPath()) {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{
+				Error:   "Forbidden",
+				Details: "API key scopes do not allow this request",
+			})
+			return
+		}
+
 		// Store account info in context
+		c.Set("account", account)
+		c.Set("api_key", key)
 		c.Set("account_id", account.ID)
 		c.Set("account_name", account.Name)
+		c.Set("account_location", account.Location())
+		c.Set("account_allowed_agent_urls", account.AllowedAgentURLs)
+
+		c.Next()
+	}
+}
+
+// authenticateToken authenticates a request by a bearer token from
+// /auth/token, taking the account's settings from its claims
+func (s *Server) authenticateToken(c *gin.Context, token string) {
+	claims, err := s.handler.tokens.Verify(token, time.Now())
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer realm="blayzen-sip", error="invalid_token"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "Invalid credentials",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	if !models.ScopesAllow(claims.Scopes, c.Request.Method, c.FullPath()) {
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{
+			Error:   "Forbidden",
+			Details: "API token scopes do not allow this request",
+		})
+		return
+	}
+
+	account := &models.Account{Timezone: claims.Timezone}
+	c.Set("account_id", claims.Subject)
+	c.Set("account_name", claims.AccountName)
+	c.Set("account_location", account.Location())
+	c.Set("account_allowed_agent_urls", claims.AllowedAgentURLs)
+
+	c.Next()
+}
+
+// adminAuthMiddleware checks for the admin API key as a bearer token. Account
+// credentials can't use the admin API, nor the admin key the account API.
+func (s *Server) adminAuthMiddleware() gin.HandlerFunc {
+	want := []byte("Bearer " + s.config.AdminAPIKey)
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), want) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="blayzen-sip admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Admin authentication required",
+			})
+			return
+		}
 
 		c.Next()
 	}
 }
 
+// requestLogger logs each request with its account. Health checks and
+// metrics scrapes are logged at debug level.
+func requestLogger(metricsPath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if path := c.Request.URL.Path; path == "/health" || path == metricsPath {
+			level = slog.LevelDebug
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"client_ip", c.ClientIP(),
+		}
+		if accountID := c.GetString("account_id"); accountID != "" {
+			attrs = append(attrs, "account_id", accountID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+		logger.Log(c.Request.Context(), level, "Request", attrs...)
+	}
+}
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	addr := fmt.Sprintf("%s:%d", s.config.APIHost, s.config.APIPort)
@@ -129,8 +355,8 @@ func (s *Server) Start() error {
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
-	log.Printf("REST API server starting on %s", addr)
-	log.Printf("Swagger UI available at http://%s/swagger/index.html", addr)
+	logger.Info("REST API server starting", "addr", addr)
+	logger.Info("Swagger UI available", "url", fmt.Sprintf("http://%s/swagger/index.html", addr))
 
 	return s.httpServer.ListenAndServe()
 }
@@ -147,4 +373,3 @@ func (s *Server) Shutdown(ctx context.Context) error {
 func (s *Server) Router() *gin.Engine {
 	return s.router
 }
-
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminGetSession godoc
+// @Summary Get a call's session state
+// @Description Dump the in-memory state of a call in progress on the instance answering the request, by SIP Call-ID or call record ID, to diagnose stuck calls and one-way audio: its live state, the goroutines running for it, buffer depths, the last RTP and agent activity, and the remote addresses of its SIP, RTP and agent legs. Ask the instance handling the call, as listed in the account's active calls. Requires the admin API key.
+// @Tags Admin
+// @Produce json
+// @Security AdminAuth
+// @Param call_id path string true "SIP Call-ID or call record ID"
+// @Success 200 {object} models.SessionSnapshot
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/admin/sessions/{call_id} [get]
+func (h *Handler) AdminGetSession(c *gin.Context) {
+	snapshot := h.calls.SessionSnapshot(c.Param("call_id"))
+	if snapshot == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Session not found", Details: "no such call in progress on instance " + h.config.InstanceID})
+		return
+	}
+	c.JSON(http.StatusOK, snapshot)
+}
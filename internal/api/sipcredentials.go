@@ -0,0 +1,174 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// pgUniqueViolation is the Postgres error code of a unique constraint
+// violation
+const pgUniqueViolation = "23505"
+
+// CreateSIPCredentialRequest is the request body for creating a SIP
+// credential
+type CreateSIPCredentialRequest struct {
+	Username string `json:"username" binding:"required" example:"office-pbx"` // Unique within the account
+	Password string `json:"password" binding:"required"`                      // At least 12 characters
+}
+
+// UpdateSIPCredentialRequest is the request body for changing a SIP
+// credential's password
+type UpdateSIPCredentialRequest struct {
+	Password string `json:"password" binding:"required"` // At least 12 characters
+}
+
+// ListSIPCredentials godoc
+// @Summary List SIP credentials
+// @Description Get the account's SIP credentials (passwords are not shown)
+// @Tags SIP Credentials
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {array} models.SIPCredential
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/sip-credentials [get]
+func (h *Handler) ListSIPCredentials(c *gin.Context) {
+	creds, err := h.store.ListSIPCredentials(c.Request.Context(), c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch SIP credentials", Details: err.Error()})
+		return
+	}
+
+	if creds == nil {
+		creds = []*models.SIPCredential{}
+	}
+	loc := accountLocation(c)
+	for _, cred := range creds {
+		cred.Localize(loc)
+	}
+	c.JSON(http.StatusOK, creds)
+}
+
+// CreateSIPCredential godoc
+// @Summary Create a SIP credential
+// @Description Create a username and password that callers which aren't one of the account's trunks, e.g. a PBX, answer digest challenges (407) with to call the account's routes, when SIP_AUTH_ENABLED is set
+// @Tags SIP Credentials
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param credential body CreateSIPCredentialRequest true "SIP credential"
+// @Success 201 {object} models.SIPCredential
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/sip-credentials [post]
+func (h *Handler) CreateSIPCredential(c *gin.Context) {
+	var req CreateSIPCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	cred := &models.SIPCredential{Username: req.Username, Password: req.Password}
+	if err := cred.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	cred.Realm = h.config.SIPAuthRealm
+	cred.HA1 = models.DigestHA1(cred.Username, cred.Realm, cred.Password)
+
+	created, err := h.store.CreateSIPCredential(c.Request.Context(), c.GetString("account_id"), cred)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Username already taken"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create SIP credential", Details: err.Error()})
+		return
+	}
+
+	created.Localize(accountLocation(c))
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateSIPCredential godoc
+// @Summary Change a SIP credential's password
+// @Description Change the password of a SIP credential, saving it for the current realm; challenges answered with the old one fail from now on
+// @Tags SIP Credentials
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "SIP credential ID"
+// @Param credential body UpdateSIPCredentialRequest true "New password"
+// @Success 200 {object} models.SIPCredential
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/sip-credentials/{id} [put]
+func (h *Handler) UpdateSIPCredential(c *gin.Context) {
+	var req UpdateSIPCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := models.ValidateSIPPassword(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	accountID := c.GetString("account_id")
+	cred, err := h.store.GetSIPCredential(ctx, accountID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "SIP credential not found"})
+		return
+	}
+
+	realm := h.config.SIPAuthRealm
+	updated, err := h.store.UpdateSIPCredentialHA1(ctx, accountID, cred.ID, realm, models.DigestHA1(cred.Username, realm, req.Password))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Username already taken"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update SIP credential", Details: err.Error()})
+		return
+	}
+
+	updated.Localize(accountLocation(c))
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteSIPCredential godoc
+// @Summary Delete a SIP credential
+// @Description Delete a SIP credential; calls in progress are unaffected
+// @Tags SIP Credentials
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "SIP credential ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/sip-credentials/{id} [delete]
+func (h *Handler) DeleteSIPCredential(c *gin.Context) {
+	if err := h.store.DeleteSIPCredential(c.Request.Context(), c.GetString("account_id"), c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete SIP credential", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "SIP credential deleted successfully"})
+}
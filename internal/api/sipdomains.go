@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// CreateSIPDomainRequest is the request body for adding a SIP domain
+type CreateSIPDomainRequest struct {
+	Domain string `json:"domain" binding:"required" example:"sip.example.com"` // Unique across accounts
+}
+
+// ListSIPDomains godoc
+// @Summary List SIP domains
+// @Description Get the domains the account's inbound calls are attributed by
+// @Tags SIP Domains
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {array} models.SIPDomain
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/sip-domains [get]
+func (h *Handler) ListSIPDomains(c *gin.Context) {
+	domains, err := h.store.ListSIPDomains(c.Request.Context(), c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch SIP domains", Details: err.Error()})
+		return
+	}
+
+	if domains == nil {
+		domains = []*models.SIPDomain{}
+	}
+	loc := accountLocation(c)
+	for _, d := range domains {
+		d.Localize(loc)
+	}
+	c.JSON(http.StatusOK, domains)
+}
+
+// CreateSIPDomain godoc
+// @Summary Add a SIP domain
+// @Description Add a domain callers address INVITEs to (the Request-URI host). Calls to it from neither one of the account's trunks nor with its SIP credentials are attributed to the account, and only its routes are considered.
+// @Tags SIP Domains
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param domain body CreateSIPDomainRequest true "SIP domain"
+// @Success 201 {object} models.SIPDomain
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/sip-domains [post]
+func (h *Handler) CreateSIPDomain(c *gin.Context) {
+	var req CreateSIPDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	domain := &models.SIPDomain{Domain: strings.TrimSuffix(strings.ToLower(strings.TrimSpace(req.Domain)), ".")}
+	if err := domain.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	created, err := h.store.CreateSIPDomain(c.Request.Context(), c.GetString("account_id"), domain.Domain)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Domain already taken"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create SIP domain", Details: err.Error()})
+		return
+	}
+
+	created.Localize(accountLocation(c))
+	c.JSON(http.StatusCreated, created)
+}
+
+// DeleteSIPDomain godoc
+// @Summary Delete a SIP domain
+// @Description Remove a SIP domain; calls to it are no longer attributed to the account
+// @Tags SIP Domains
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "SIP domain ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/sip-domains/{id} [delete]
+func (h *Handler) DeleteSIPDomain(c *gin.Context) {
+	if err := h.store.DeleteSIPDomain(c.Request.Context(), c.GetString("account_id"), c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete SIP domain", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "SIP domain deleted successfully"})
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiv6146/blayzen-sip/internal/call"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// TransferCall godoc
+// @Summary Transfer a call
+// @Description Transfer the caller of an answered call to a phone number or SIP URI (blind transfer with REFER). Phone numbers are sent to the caller's SIP domain to route. Once the caller's side accepts the transfer, the call ends with hangup cause transferred and the target is recorded on the call log. The call must be on the instance answering the request.
+// @Tags Calls
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "Call ID"
+// @Param transfer body TransferCallRequest true "Transfer target"
+// @Success 200 {object} TransferCallResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /api/v1/calls/{id}/transfer [post]
+func (h *Handler) TransferCall(c *gin.Context) {
+	accountID := c.GetString("account_id")
+
+	var req TransferCallRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	if err := models.ValidateTransferTarget(req.Target); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	callLog, err := h.store.GetCall(c.Request.Context(), accountID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Call not found"})
+		return
+	}
+
+	err = h.calls.Transfer(c.Request.Context(), callLog.CallID, req.Target)
+	switch {
+	case errors.Is(err, call.ErrCallNotActive), errors.Is(err, call.ErrCallNotAnswered),
+		errors.Is(err, call.ErrTransferUnsupported), errors.Is(err, call.ErrTransferInProgress):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Call can't be transferred", Details: err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadGateway, ErrorResponse{Error: "Transfer failed", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, TransferCallResponse{Status: "transferred", Target: req.Target})
+}
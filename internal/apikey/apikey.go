@@ -0,0 +1,132 @@
+// Package apikey generates account API keys, and hashes and verifies them
+// as stored
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// prefixLength is how much of a key is kept in the clear, to tell keys apart
+// and find a key's hash
+const prefixLength = 8
+
+// Argon2id parameters for new hashes (OWASP's minimum recommendation)
+const (
+	argonTime    = 2
+	argonMemory  = 19 * 1024 // KiB
+	argonThreads = 1
+	argonKeyLen  = 32
+	saltLength   = 16
+)
+
+// maxVerified bounds the verification memo; it is cleared when full
+const maxVerified = 10000
+
+// verified memoizes successful verifications, by stored hash and key, so
+// authenticating every API request doesn't pay for argon2id. Failures aren't
+// memoized, so guessing keys does.
+var (
+	verifiedMu sync.Mutex
+	verified   = make(map[[sha256.Size]byte]struct{})
+)
+
+// Generate returns a random 256-bit key, hex encoded
+func Generate() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Hash returns the argon2id hash of a key with a random salt, in PHC string
+// format, as stored
+func Hash(key string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	sum := argon2.IDKey([]byte(key), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(sum)), nil
+}
+
+// Verify reports whether a key matches a stored hash. Hex SHA-256 hashes,
+// stored before keys were hashed with argon2id, are still accepted.
+func Verify(key, hash string) bool {
+	memo := sha256.Sum256([]byte(hash + "\x00" + key))
+	verifiedMu.Lock()
+	_, ok := verified[memo]
+	verifiedMu.Unlock()
+	if ok {
+		return true
+	}
+
+	if !verify(key, hash) {
+		return false
+	}
+
+	verifiedMu.Lock()
+	if len(verified) >= maxVerified {
+		clear(verified)
+	}
+	verified[memo] = struct{}{}
+	verifiedMu.Unlock()
+	return true
+}
+
+// verify checks a key against a stored hash
+func verify(key, hash string) bool {
+	if NeedsRehash(hash) {
+		sum := sha256.Sum256([]byte(key))
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(hash)) == 1
+	}
+
+	// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+
+	got := argon2.IDKey([]byte(key), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// NeedsRehash reports whether a stored hash predates argon2id and should be
+// replaced once its key is next verified
+func NeedsRehash(hash string) bool {
+	return !strings.HasPrefix(hash, "$argon2id$")
+}
+
+// Prefix returns the first characters of a key
+func Prefix(key string) string {
+	return key[:min(len(key), prefixLength)]
+}
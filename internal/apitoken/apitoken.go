@@ -0,0 +1,141 @@
+// Package apitoken issues the short-lived bearer tokens accounts can use on
+// the REST API instead of their API key, and verifies them without a
+// database lookup. Tokens are HS256 JWTs carrying the account's ID, settings
+// and scopes.
+package apitoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Issuer and audience of the tokens, telling them apart from agent handshake
+// tokens
+const (
+	TokenIssuer   = "blayzen-sip"
+	TokenAudience = "blayzen-sip-api"
+)
+
+// MinSecretLength is the shortest signing secret accepted
+const MinSecretLength = 32
+
+// Token verification errors
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Claims are a token's claims: the account as it was when the token was
+// issued, and the scopes of the API key it was issued for
+type Claims struct {
+	Issuer           string   `json:"iss"`
+	Audience         string   `json:"aud"`
+	Subject          string   `json:"sub"` // Account ID
+	IssuedAt         int64    `json:"iat"`
+	ExpiresAt        int64    `json:"exp"`
+	KeyID            string   `json:"key_id"`
+	AccountName      string   `json:"account_name"`
+	Timezone         string   `json:"timezone,omitempty"`
+	AllowedAgentURLs []string `json:"allowed_agent_urls,omitempty"`
+	Scopes           []string `json:"scopes,omitempty"` // Empty for full access
+}
+
+// jwtHeader is the fixed JOSE header of the tokens
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Issuer signs and verifies tokens
+type Issuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewIssuer creates an issuer of tokens valid for ttl. It returns nil when
+// secret is empty, as bearer tokens are then disabled.
+func NewIssuer(secret string, ttl time.Duration) (*Issuer, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	if len(secret) < MinSecretLength {
+		return nil, fmt.Errorf("API token secret must be at least %d characters", MinSecretLength)
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("API token TTL must be positive")
+	}
+	return &Issuer{secret: []byte(secret), ttl: ttl}, nil
+}
+
+// TTL returns how long tokens are valid
+func (i *Issuer) TTL() time.Duration {
+	return i.ttl
+}
+
+// Issue returns a token for an account, limited to scopes, and when it
+// expires
+func (i *Issuer) Issue(account *models.Account, key *models.APIKey, scopes []string) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(i.ttl)
+	claims := &Claims{
+		Issuer:           TokenIssuer,
+		Audience:         TokenAudience,
+		Subject:          account.ID,
+		IssuedAt:         now.Unix(),
+		ExpiresAt:        expires.Unix(),
+		KeyID:            key.ID,
+		AccountName:      account.Name,
+		Timezone:         account.Timezone,
+		AllowedAgentURLs: account.AllowedAgentURLs,
+		Scopes:           scopes,
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + i.sign(signingInput), expires, nil
+}
+
+// Verify checks a token's signature, audience and expiry and returns its
+// claims
+func (i *Issuer) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, ErrInvalidToken
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(i.sign(parts[0]+"."+parts[1]))) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Issuer != TokenIssuer || claims.Audience != TokenAudience || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// sign computes the base64url HMAC-SHA256 signature of the signing input
+func (i *Issuer) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
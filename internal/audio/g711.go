@@ -0,0 +1,109 @@
+// Package audio provides sample format conversion for call media
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// G.711 mu-law constants
+const (
+	mulawBias = 0x84
+	mulawClip = 32635
+)
+
+// mulawTable holds the decoded value of every mu-law byte
+var mulawTable [256]int16
+
+func init() {
+	for i := range mulawTable {
+		mulawTable[i] = decodeMulaw(byte(i))
+	}
+}
+
+// decodeMulaw decodes a single mu-law byte to a linear sample
+func decodeMulaw(b byte) int16 {
+	u := ^b
+	exponent := (u >> 4) & 0x07
+	mantissa := u & 0x0F
+
+	sample := ((int(mantissa) << 3) + mulawBias) << exponent
+	sample -= mulawBias
+
+	if u&0x80 != 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+// EncodeMulaw encodes a linear sample as mu-law
+func EncodeMulaw(sample int16) byte {
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > mulawClip {
+		s = mulawClip
+	}
+	s += mulawBias
+
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0F
+
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+// DecodeMulaw decodes a mu-law byte to a linear sample
+func DecodeMulaw(b byte) int16 {
+	return mulawTable[b]
+}
+
+// MulawToSamples decodes a mu-law buffer to linear samples
+func MulawToSamples(data []byte) []int16 {
+	samples := make([]int16, len(data))
+	for i, b := range data {
+		samples[i] = mulawTable[b]
+	}
+	return samples
+}
+
+// SamplesToMulaw encodes linear samples as mu-law
+func SamplesToMulaw(samples []int16) []byte {
+	data := make([]byte, len(samples))
+	for i, s := range samples {
+		data[i] = EncodeMulaw(s)
+	}
+	return data
+}
+
+// PCM16ToSamples decodes 16-bit little-endian PCM. A trailing odd byte is ignored.
+func PCM16ToSamples(data []byte) []int16 {
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[i*2:]))
+	}
+	return samples
+}
+
+// SamplesToPCM16 encodes samples as 16-bit little-endian PCM
+func SamplesToPCM16(samples []int16) []byte {
+	data := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(s))
+	}
+	return data
+}
+
+// MixMulaw mixes src into dst, both mu-law, sample by sample with clipping.
+// Samples of dst past the end of src are left unchanged.
+func MixMulaw(dst, src []byte) {
+	for i := range min(len(dst), len(src)) {
+		sum := int32(mulawTable[dst[i]]) + int32(mulawTable[src[i]])
+		dst[i] = EncodeMulaw(int16(max(min(sum, math.MaxInt16), math.MinInt16)))
+	}
+}
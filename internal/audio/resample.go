@@ -0,0 +1,56 @@
+package audio
+
+// Resampler converts a sample stream between two rates where one is an
+// integer multiple of the other. It keeps state across calls so frames can
+// be processed one at a time without discontinuities.
+type Resampler struct {
+	from int
+	to   int
+
+	// Upsampling: last input sample, interpolated toward the next one
+	prev int16
+
+	// Downsampling: running sum of the current output sample's inputs
+	acc  int32
+	accN int
+}
+
+// NewResampler creates a resampler from one sample rate to another
+func NewResampler(from, to int) *Resampler {
+	return &Resampler{from: from, to: to}
+}
+
+// Process resamples the next block of samples
+func (r *Resampler) Process(in []int16) []int16 {
+	switch {
+	case r.to == r.from:
+		return in
+
+	case r.to > r.from:
+		// Linear interpolation
+		k := int32(r.to / r.from)
+		out := make([]int16, 0, len(in)*int(k))
+		for _, x := range in {
+			for j := int32(1); j <= k; j++ {
+				out = append(out, int16(int32(r.prev)+(int32(x)-int32(r.prev))*j/k))
+			}
+			r.prev = x
+		}
+		return out
+
+	default:
+		// Averaging decimation
+		k := r.from / r.to
+		out := make([]int16, 0, len(in)/k+1)
+		for _, x := range in {
+			r.acc += int32(x)
+			r.accN++
+			if r.accN == k {
+				out = append(out, int16(r.acc/int32(k)))
+				r.acc = 0
+				r.accN = 0
+			}
+		}
+		return out
+	}
+}
@@ -0,0 +1,99 @@
+package call
+
+import (
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/accounting"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/privacy"
+)
+
+// startAccounting sends the Start record of an answered call, then
+// Interim-Updates until the call ends
+func (s *Session) startAccounting() {
+	if s.acct == nil {
+		return
+	}
+	s.acct.Send(accounting.StatusStart, s.accountingRecord())
+
+	interval := s.acct.InterimInterval()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-ticker.C:
+				if s.isClosed() {
+					return
+				}
+				s.acct.Send(accounting.StatusInterim, s.accountingRecord())
+			}
+		}
+	}()
+}
+
+// stopAccounting sends the Stop record of a call that was answered
+func (s *Session) stopAccounting() {
+	if s.acct == nil || s.mediaStarted.Load() == 0 {
+		return
+	}
+
+	rec := s.accountingRecord()
+	s.hangupMu.Lock()
+	rec.TerminateCause = terminateCause(s.hangupCause, s.hangupParty)
+	s.hangupMu.Unlock()
+	s.acct.Send(accounting.StatusStop, rec)
+}
+
+// accountingRecord describes the call so far
+func (s *Session) accountingRecord() accounting.Record {
+	now := time.Now()
+	rec := accounting.Record{
+		SessionID:      s.CallID,
+		UserName:       s.Route.AccountID,
+		CallingStation: privacy.CDRNumber(s.FromUser),
+		CalledStation:  privacy.CDRNumber(s.ToUser),
+		InputOctets:    s.bytesIn.Load(),
+		OutputOctets:   s.bytesOut.Load(),
+		InputPackets:   s.packetsIn.Load(),
+		OutputPackets:  s.packetsOut.Load(),
+		Timestamp:      now,
+	}
+	if started := s.mediaStarted.Load(); started != 0 {
+		rec.SessionTime = now.Sub(time.Unix(0, started))
+	}
+	return rec
+}
+
+// terminateCause maps who hung up and why to an Acct-Terminate-Cause
+func terminateCause(cause, party string) int {
+	switch party {
+	case models.HangupPartyCaller:
+		return accounting.TerminateUserRequest
+	case models.HangupPartyAgent:
+		return accounting.TerminateHostRequest
+	case "":
+		// Sessions closed without a recorded hangup, on shutdown
+		return accounting.TerminateNASReboot
+	}
+
+	switch cause {
+	case models.HangupCauseSilenceTimeout:
+		return accounting.TerminateIdleTimeout
+	case models.HangupCausePreempted:
+		return accounting.TerminatePortPreempted
+	case models.HangupCauseSessionExpired:
+		return accounting.TerminateSessionTimeout
+	case models.HangupCauseAgentLost:
+		return accounting.TerminateLostService
+	case models.HangupCauseAgentUnavailable:
+		return accounting.TerminateServiceUnavailable
+	}
+	return accounting.TerminateNASRequest
+}
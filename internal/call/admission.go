@@ -0,0 +1,237 @@
+package call
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// ErrNoCapacity is returned by CreateSession when a call is refused because
+// the server, or the call's account, is at capacity
+var ErrNoCapacity = errors.New("no capacity for call")
+
+// ErrRouteBusy is returned by CreateSession when a call is refused because
+// its route has as many calls in progress as it may
+var ErrRouteBusy = errors.New("route busy")
+
+// hangupTimeout bounds the BYE sent to a preempted call
+const hangupTimeout = 5 * time.Second
+
+// capacityExceeded returns why a call of the given priority can't be admitted
+// right now, or "" when it can. Calls without a positive priority may not use
+// the capacity reserved for priority calls. Callers must hold m.mu.
+func (m *Manager) capacityExceeded(priority int) string {
+	limit := m.config.MaxConcurrentCalls
+	if limit <= 0 {
+		return ""
+	}
+
+	active := len(m.sessions) + len(m.admitting)
+	if active >= limit {
+		return fmt.Sprintf("concurrent call limit %d reached", limit)
+	}
+	if priority <= 0 && active >= limit-m.config.PriorityReservedCalls {
+		return "remaining capacity reserved for priority calls"
+	}
+	return ""
+}
+
+// Capacity returns the number of active calls and the call limit: the
+// configured maximum, or the size of the RTP port range when unlimited (half
+// of it with rtcp-mux off, as every call then takes a port pair)
+func (m *Manager) Capacity() (active, limit int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	limit = m.config.MaxConcurrentCalls
+	if limit <= 0 {
+		limit = m.config.RTPPortMax - m.config.RTPPortMin + 1
+		if !m.config.RTCPMux {
+			limit /= 2
+		}
+	}
+	return len(m.sessions), limit
+}
+
+// admit admits a call to a route needing kbps each way, returning its new
+// session. The account's limits are read and the call's slots claimed in
+// Valkey before taking m.mu, which is only held to count the calls in memory.
+func (m *Manager) admit(ctx context.Context, callID, caller string, route *models.Route, kbps int) (*Session, error) {
+	slots, local, err := m.admitLimits(ctx, callID, route, kbps)
+	if err != nil {
+		return nil, err
+	}
+
+	var session *Session
+	m.mu.Lock()
+	err = m.admitLocal(callID, route, kbps, local)
+	if err == nil {
+		session = m.newSession(callID, caller, route)
+		session.slots = slots
+		session.bitrate = kbps
+		m.admitting[callID] = session
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		m.releaseSlots(ctx, callID, slots)
+		return nil, err
+	}
+	return session, nil
+}
+
+// admitLocal admits a call under the limits on this instance's calls,
+// preempting a lower-priority one when the server is full, and under the
+// call limits left to count locally. The cap on sessions in memory is never
+// preempted. Callers must hold m.mu.
+func (m *Manager) admitLocal(callID string, route *models.Route, kbps int, local []callLimit) error {
+	if reason := m.sessionsExceeded(); reason != "" {
+		return m.reject(callID, route, metrics.LimitSessions, reason)
+	}
+	if reason := m.capacityExceeded(route.CallPriority); reason != "" {
+		if !m.preempt(callID, route.CallPriority, reason) {
+			return m.reject(callID, route, metrics.LimitServer, reason)
+		}
+	}
+	for _, l := range local {
+		if m.localCalls(l) >= l.limit {
+			return m.reject(callID, route, l.kind, l.reason(kbps))
+		}
+	}
+	return nil
+}
+
+// unadmit drops an admitted call refused before it was registered
+func (m *Manager) unadmit(ctx context.Context, s *Session) {
+	m.mu.Lock()
+	delete(m.admitting, s.CallID)
+	m.mu.Unlock()
+
+	m.releaseSlots(ctx, s.CallID, s.slots)
+}
+
+// preempt hangs up the oldest answered call with the lowest priority below
+// the given one to make room for callID. It reports whether a call was
+// preempted. Callers must hold m.mu.
+func (m *Manager) preempt(callID string, priority int, reason string) bool {
+	if !m.config.CallPreemption {
+		return false
+	}
+
+	var victim *Session
+	for _, s := range m.sessions {
+		p := s.Route.CallPriority
+		if p >= priority || s.answer == nil {
+			continue
+		}
+		if victim == nil || p < victim.Route.CallPriority ||
+			(p == victim.Route.CallPriority && s.createdAt.Before(victim.createdAt)) {
+			victim = s
+		}
+	}
+	if victim == nil {
+		return false
+	}
+
+	victim.log.Warn("Preempting call", "priority", victim.Route.CallPriority,
+		"preempted_by", callID, "preempted_by_priority", priority, "reason", reason)
+
+	m.endCall(victim, models.CallStatusPreempted, models.HangupCausePreempted)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), hangupTimeout)
+		defer cancel()
+
+		m.auditPreemption(ctx, victim.Route.AccountID, victim.CallID, victim.Route.CallPriority,
+			models.PreemptionTerminated, &callID, reason)
+	}()
+
+	return true
+}
+
+// endCall hangs up a call from our side. The slot and RTP port are freed
+// right away; the caller is sent a BYE and the call log closed in the
+// background. Callers must hold m.mu.
+func (m *Manager) endCall(s *Session, status models.CallStatus, cause string) {
+	delete(m.sessions, s.CallID)
+	s.SetHangup(cause, models.HangupPartySystem)
+	s.Close()
+
+	// A hangup recorded earlier wins, e.g. a transfer the agent asked for
+	s.hangupMu.Lock()
+	cause, party := s.hangupCause, s.hangupParty
+	s.hangupMu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), hangupTimeout)
+		defer cancel()
+
+		if err := s.Hangup(ctx); err != nil {
+			s.log.Error("Failed to hang up call", "error", err)
+		}
+		if err := m.store.UpdateCallStatus(ctx, s.CallID, status); err != nil {
+			s.log.Error("Failed to update call status", "error", err)
+		}
+		if err := m.store.SetCallHangup(ctx, s.CallID, cause, party); err != nil {
+			s.log.Error("Failed to record hangup cause", "error", err)
+		}
+		m.recordMediaUsage(ctx, s)
+		s.notify(status)
+		m.forgetDialog(ctx, s)
+		m.releaseCall(ctx, s)
+		m.releaseSlots(ctx, s.CallID, s.slots)
+		if m.cache != nil {
+			_ = m.cache.RemoveActiveCall(ctx, s.CallID)
+		}
+	}()
+}
+
+// hangupSession ends an active call from our side, e.g. on silence timeout
+func (m *Manager) hangupSession(callID, cause string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[callID]; ok {
+		s.log.Info("Hanging up call", "cause", cause)
+		m.endCall(s, models.CallStatusCompleted, cause)
+	}
+}
+
+// auditPreemption records a call refused or ended for capacity
+func (m *Manager) auditPreemption(ctx context.Context, accountID, callID string, priority int,
+	action models.PreemptionAction, preemptedBy *string, reason string) {
+	p := &models.CallPreemption{
+		AccountID:    &accountID,
+		CallID:       callID,
+		Action:       action,
+		CallPriority: priority,
+		PreemptedBy:  preemptedBy,
+		Reason:       reason,
+	}
+	if err := m.store.CreateCallPreemption(ctx, p); err != nil {
+		callLogger(callID, accountID).Error("Failed to record preemption", "error", err)
+	}
+}
+
+// reject refuses a call for lack of capacity under the given limit
+// (metrics.LimitServer, LimitAccount, LimitRoute, LimitBandwidth or
+// LimitSessions). The refusal is audited in the background, as callers may
+// hold m.mu.
+func (m *Manager) reject(callID string, route *models.Route, limit, reason string) error {
+	callLogger(callID, route.AccountID).Warn("Rejecting call", "priority", route.CallPriority, "limit", limit, "reason", reason)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), hangupTimeout)
+		defer cancel()
+
+		m.auditPreemption(ctx, route.AccountID, callID, route.CallPriority, models.PreemptionRejected, nil, reason)
+	}()
+	metrics.CallsLimited.With(limit).Inc()
+	if limit == metrics.LimitRoute {
+		return fmt.Errorf("%w: %s", ErrRouteBusy, reason)
+	}
+	return fmt.Errorf("%w: %s", ErrNoCapacity, reason)
+}
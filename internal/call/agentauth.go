@@ -0,0 +1,51 @@
+package call
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/pkg/agentproto"
+)
+
+// agentHeaders builds the agent WebSocket handshake headers from the route's
+// auth config. JWTs are signed per call and carry its metadata.
+func (s *Session) agentHeaders(agentURL string) (http.Header, error) {
+	auth := s.Route.AgentAuth
+	if auth == nil {
+		return nil, nil
+	}
+
+	header := http.Header{}
+	for name, value := range auth.Headers {
+		header.Set(name, value)
+	}
+
+	switch auth.Type {
+	case models.AgentAuthBearer:
+		header.Set("Authorization", "Bearer "+auth.Token)
+
+	case models.AgentAuthJWT:
+		now := time.Now()
+		token, err := agentproto.SignToken(&agentproto.TokenClaims{
+			Issuer:    agentproto.TokenIssuer,
+			Subject:   s.CallID,
+			Audience:  agentURL,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(auth.TokenTTL()).Unix(),
+			CallID:    s.CallID,
+			StreamSID: s.StreamSID,
+			AccountID: s.Route.AccountID,
+			RouteID:   s.Route.ID,
+			From:      s.FromUser,
+			To:        s.ToUser,
+		}, []byte(auth.Secret))
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign agent token: %w", err)
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	return header, nil
+}
@@ -0,0 +1,334 @@
+package call
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/pkg/agentproto"
+	"github.com/shiv6146/blayzen/pkg/protocol/exotel"
+)
+
+// agentEvent is a decoded agent message, independent of the wire protocol.
+// Event is one of the exotel event names.
+type agentEvent struct {
+	Event      string
+	Audio      []byte // media
+	Digits     string // dtmf
+	DurationMs int    // dtmf, 0 when unspecified
+	Mark       string // mark
+	Target     string // transfer
+}
+
+// agentCodec encodes and decodes the messages of an agent WebSocket protocol
+type agentCodec interface {
+	Connected() interface{}
+	Start(s *Session, customData map[string]interface{}) interface{}
+	Media(s *Session, chunk []byte) interface{}
+	DTMF(s *Session, digit string, durationMs int) interface{}
+	Mark(s *Session, name string) interface{}
+	Stop(s *Session) interface{}
+	Summary(s *Session, summary agentproto.CallSummary) interface{}
+	Fax(s *Session, source string) interface{}
+	Hold(s *Session, held bool) interface{}
+	Error(s *Session, protocolError agentproto.ProtocolError) interface{}
+
+	// Decode parses an agent message, returning an agentMessageError for
+	// one to reject
+	Decode(data []byte) (*agentEvent, error)
+}
+
+// newAgentCodec returns the codec for a route's agent protocol
+func newAgentCodec(protocol string) agentCodec {
+	if protocol == models.AgentProtocolTwilio {
+		return &twilioCodec{}
+	}
+	return exotelCodec{}
+}
+
+// exotelCodec speaks the exotel protocol with the agentproto extensions
+type exotelCodec struct{}
+
+func (exotelCodec) Connected() interface{} {
+	return exotel.NewConnectedMessage()
+}
+
+func (exotelCodec) Start(s *Session, customData map[string]interface{}) interface{} {
+	msg := exotel.NewStartMessage(s.StreamSID, s.CallID, s.Route.AccountID, s.FromUser, s.ToUser)
+	customData[agentproto.CustomDataMediaFormat] = s.agentAudio.MediaFormat()
+	msg.CustomData = customData
+	return msg
+}
+
+func (exotelCodec) Media(s *Session, chunk []byte) interface{} {
+	return exotel.NewMediaMessage(s.StreamSID, chunk, s.chunkCount, time.Now().UnixMilli())
+}
+
+func (exotelCodec) DTMF(s *Session, digit string, durationMs int) interface{} {
+	return agentproto.NewDTMFMessage(s.StreamSID, digit, durationMs)
+}
+
+func (exotelCodec) Mark(s *Session, name string) interface{} {
+	return exotel.NewMarkMessage(name)
+}
+
+func (exotelCodec) Stop(s *Session) interface{} {
+	return exotel.NewStopMessage(s.StreamSID)
+}
+
+func (exotelCodec) Summary(s *Session, summary agentproto.CallSummary) interface{} {
+	return agentproto.NewSummaryMessage(s.StreamSID, summary)
+}
+
+func (exotelCodec) Fax(s *Session, source string) interface{} {
+	return agentproto.NewFaxMessage(s.StreamSID, source)
+}
+
+func (exotelCodec) Hold(s *Session, held bool) interface{} {
+	return agentproto.NewHoldMessage(s.StreamSID, held)
+}
+
+func (exotelCodec) Error(s *Session, protocolError agentproto.ProtocolError) interface{} {
+	return agentproto.NewErrorMessage(s.StreamSID, protocolError)
+}
+
+func (exotelCodec) Decode(data []byte) (*agentEvent, error) {
+	// DTMF uses the agentproto payload, which the exotel parser rejects
+	var envelope exotel.Message
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, malformedMessage(fmt.Errorf("failed to parse message: %w", err))
+	}
+	switch envelope.Event {
+	case agentproto.EventDTMF:
+		msg, err := agentproto.ParseDTMFMessage(data)
+		if err != nil {
+			return nil, invalidMessage(envelope.Event, "%v", err)
+		}
+		return &agentEvent{Event: exotel.EventDTMF, Digits: msg.DTMF.Digit, DurationMs: msg.DurationMs(0)}, nil
+	case agentproto.EventTransfer:
+		msg, err := agentproto.ParseTransferMessage(data)
+		if err != nil {
+			return nil, invalidMessage(envelope.Event, "%v", err)
+		}
+		return &agentEvent{Event: agentproto.EventTransfer, Target: msg.Transfer.Target}, nil
+	case agentproto.EventAnswer:
+		return &agentEvent{Event: agentproto.EventAnswer}, nil
+	case exotel.EventMedia, exotel.EventMark, exotel.EventClear, exotel.EventStop:
+	default:
+		return nil, unknownEvent(envelope.Event)
+	}
+
+	msg, err := exotel.ParseMessage(data)
+	if err != nil {
+		return nil, invalidMessage(envelope.Event, "%v", err)
+	}
+
+	switch m := msg.(type) {
+	case *exotel.MediaMessage:
+		audio, err := m.DecodeAudio()
+		if err != nil {
+			return nil, invalidMessage(envelope.Event, "failed to decode audio: %v", err)
+		}
+		return &agentEvent{Event: exotel.EventMedia, Audio: audio}, nil
+	case *exotel.MarkMessage:
+		return &agentEvent{Event: exotel.EventMark, Mark: m.Name}, nil
+	case *exotel.ClearMessage:
+		return &agentEvent{Event: exotel.EventClear}, nil
+	case *exotel.StopMessage:
+		return &agentEvent{Event: exotel.EventStop}, nil
+	}
+	return nil, unknownEvent(envelope.Event)
+}
+
+// twilioCodec speaks the Twilio Media Streams protocol
+type twilioCodec struct {
+	mu    sync.Mutex
+	seq   int
+	start time.Time
+}
+
+// next returns the next message sequence number
+func (c *twilioCodec) next() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	return strconv.Itoa(c.seq)
+}
+
+func (c *twilioCodec) Connected() interface{} {
+	return &agentproto.TwilioMessage{
+		Event:    agentproto.TwilioEventConnected,
+		Protocol: "Call",
+		Version:  "1.0.0",
+	}
+}
+
+func (c *twilioCodec) Start(s *Session, customData map[string]interface{}) interface{} {
+	c.mu.Lock()
+	c.start = time.Now()
+	c.mu.Unlock()
+
+	// Twilio has no caller fields, pass them as parameters unless the route
+	// already sets them
+	if _, ok := customData["from"]; !ok {
+		customData["from"] = s.FromUser
+	}
+	if _, ok := customData["to"]; !ok {
+		customData["to"] = s.ToUser
+	}
+
+	format := s.agentAudio.MediaFormat()
+	return &agentproto.TwilioMessage{
+		Event:          agentproto.TwilioEventStart,
+		SequenceNumber: c.next(),
+		StreamSID:      s.StreamSID,
+		Start: &agentproto.TwilioStart{
+			StreamSID:        s.StreamSID,
+			AccountSID:       s.Route.AccountID,
+			CallSID:          s.CallID,
+			Tracks:           []string{agentproto.TwilioTrackInbound},
+			CustomParameters: customData,
+			MediaFormat: agentproto.TwilioMediaFormat{
+				Encoding:   agentproto.TwilioEncoding(format.Encoding),
+				SampleRate: format.SampleRate,
+				Channels:   1,
+			},
+		},
+	}
+}
+
+func (c *twilioCodec) Media(s *Session, chunk []byte) interface{} {
+	c.mu.Lock()
+	elapsed := time.Since(c.start).Milliseconds()
+	c.mu.Unlock()
+
+	return &agentproto.TwilioMessage{
+		Event:          agentproto.TwilioEventMedia,
+		SequenceNumber: c.next(),
+		StreamSID:      s.StreamSID,
+		Media: &agentproto.TwilioMedia{
+			Track:     agentproto.TwilioTrackInbound,
+			Chunk:     strconv.Itoa(s.chunkCount),
+			Timestamp: strconv.FormatInt(elapsed, 10),
+			Payload:   base64.StdEncoding.EncodeToString(chunk),
+		},
+	}
+}
+
+func (c *twilioCodec) DTMF(s *Session, digit string, durationMs int) interface{} {
+	return &agentproto.TwilioMessage{
+		Event:          agentproto.TwilioEventDTMF,
+		SequenceNumber: c.next(),
+		StreamSID:      s.StreamSID,
+		DTMF: &agentproto.TwilioDTMF{
+			Track: agentproto.TwilioTrackInboundDTMF,
+			Digit: digit,
+		},
+	}
+}
+
+func (c *twilioCodec) Mark(s *Session, name string) interface{} {
+	return &agentproto.TwilioMessage{
+		Event:          agentproto.TwilioEventMark,
+		SequenceNumber: c.next(),
+		StreamSID:      s.StreamSID,
+		Mark:           &agentproto.TwilioMark{Name: name},
+	}
+}
+
+func (c *twilioCodec) Stop(s *Session) interface{} {
+	return &agentproto.TwilioMessage{
+		Event:          agentproto.TwilioEventStop,
+		SequenceNumber: c.next(),
+		StreamSID:      s.StreamSID,
+		Stop: &agentproto.TwilioStop{
+			AccountSID: s.Route.AccountID,
+			CallSID:    s.CallID,
+		},
+	}
+}
+
+func (c *twilioCodec) Summary(s *Session, summary agentproto.CallSummary) interface{} {
+	return &agentproto.TwilioMessage{
+		Event:          agentproto.TwilioEventSummary,
+		SequenceNumber: c.next(),
+		StreamSID:      s.StreamSID,
+		Summary:        &summary,
+	}
+}
+
+func (c *twilioCodec) Fax(s *Session, source string) interface{} {
+	return &agentproto.TwilioMessage{
+		Event:          agentproto.TwilioEventFax,
+		SequenceNumber: c.next(),
+		StreamSID:      s.StreamSID,
+		Fax:            &agentproto.FaxDetection{Source: source},
+	}
+}
+
+func (c *twilioCodec) Hold(s *Session, held bool) interface{} {
+	event := agentproto.TwilioEventResume
+	if held {
+		event = agentproto.TwilioEventHold
+	}
+	return &agentproto.TwilioMessage{
+		Event:          event,
+		SequenceNumber: c.next(),
+		StreamSID:      s.StreamSID,
+	}
+}
+
+func (c *twilioCodec) Error(s *Session, protocolError agentproto.ProtocolError) interface{} {
+	return &agentproto.TwilioMessage{
+		Event:          agentproto.TwilioEventError,
+		SequenceNumber: c.next(),
+		StreamSID:      s.StreamSID,
+		Error:          &protocolError,
+	}
+}
+
+func (c *twilioCodec) Decode(data []byte) (*agentEvent, error) {
+	msg, err := agentproto.ParseTwilioMessage(data)
+	if err != nil {
+		return nil, malformedMessage(err)
+	}
+
+	switch msg.Event {
+	case agentproto.TwilioEventMedia:
+		if msg.Media == nil {
+			return nil, invalidMessage(msg.Event, "media message without media")
+		}
+		audio, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+		if err != nil {
+			return nil, invalidMessage(msg.Event, "failed to decode audio: %v", err)
+		}
+		return &agentEvent{Event: exotel.EventMedia, Audio: audio}, nil
+	case agentproto.TwilioEventMark:
+		if msg.Mark == nil {
+			return nil, invalidMessage(msg.Event, "mark message without mark")
+		}
+		return &agentEvent{Event: exotel.EventMark, Mark: msg.Mark.Name}, nil
+	case agentproto.TwilioEventClear:
+		return &agentEvent{Event: exotel.EventClear}, nil
+	case agentproto.TwilioEventDTMF:
+		if msg.DTMF == nil {
+			return nil, invalidMessage(msg.Event, "dtmf message without dtmf")
+		}
+		return &agentEvent{Event: exotel.EventDTMF, Digits: msg.DTMF.Digit}, nil
+	case agentproto.TwilioEventTransfer:
+		if msg.Transfer == nil {
+			return nil, invalidMessage(msg.Event, "transfer message without transfer")
+		}
+		return &agentEvent{Event: agentproto.EventTransfer, Target: msg.Transfer.Target}, nil
+	case agentproto.TwilioEventAnswer:
+		return &agentEvent{Event: agentproto.EventAnswer}, nil
+	case agentproto.TwilioEventStop:
+		return &agentEvent{Event: exotel.EventStop}, nil
+	}
+	return nil, unknownEvent(msg.Event)
+}
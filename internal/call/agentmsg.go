@@ -0,0 +1,184 @@
+package call
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shiv6146/blayzen-sip/internal/eventstream"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/pkg/agentproto"
+	"github.com/shiv6146/blayzen-sip/pkg/rtp"
+	"github.com/shiv6146/blayzen/pkg/protocol/exotel"
+)
+
+// Limits on the fields of agent messages
+const (
+	maxAgentDTMFDigits   = 32
+	maxAgentDTMFDuration = 10000 // Milliseconds
+	maxAgentMarkName     = 256
+	maxAgentTarget       = 256
+)
+
+// agentErrorWindow is the period over which an agent's rejected messages
+// are counted against AgentErrorBudget
+const agentErrorWindow = time.Minute
+
+// errAgentErrorBudget is why an agent over its error budget was lost
+var errAgentErrorBudget = errors.New("agent disconnected for sending too many invalid messages")
+
+// agentMessageError is an agent message rejected, reported back to the
+// agent as an error event
+type agentMessageError struct {
+	agentproto.ProtocolError
+	err error
+}
+
+func (e *agentMessageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *agentMessageError) Unwrap() error {
+	return e.err
+}
+
+// rejectMessage returns the error rejecting an agent message for a reason,
+// an agentproto error code
+func rejectMessage(code, event string, err error) *agentMessageError {
+	return &agentMessageError{
+		ProtocolError: agentproto.ProtocolError{Code: code, Message: err.Error(), Event: event},
+		err:           err,
+	}
+}
+
+// malformedMessage rejects an agent message that doesn't parse
+func malformedMessage(err error) *agentMessageError {
+	return rejectMessage(agentproto.ErrorMalformed, "", err)
+}
+
+// invalidMessage rejects an agent message of an event whose fields are
+// missing or invalid
+func invalidMessage(event, format string, args ...any) *agentMessageError {
+	return rejectMessage(agentproto.ErrorInvalid, event, fmt.Errorf(format, args...))
+}
+
+// unknownEvent rejects an agent message of an event agents don't send
+func unknownEvent(event string) *agentMessageError {
+	if event == "" {
+		return invalidMessage("", "message without event")
+	}
+	return rejectMessage(agentproto.ErrorUnknownEvent, event, fmt.Errorf("unknown event %q", event))
+}
+
+// validate checks a decoded agent message's fields, whatever the protocol
+func (ev *agentEvent) validate() error {
+	switch ev.Event {
+	case exotel.EventMedia:
+		if len(ev.Audio) == 0 {
+			return invalidMessage(ev.Event, "media without audio")
+		}
+	case exotel.EventDTMF:
+		if ev.Digits == "" || len(ev.Digits) > maxAgentDTMFDigits {
+			return invalidMessage(ev.Event, "dtmf needs 1 to %d digits", maxAgentDTMFDigits)
+		}
+		for _, r := range strings.ToUpper(ev.Digits) {
+			if !strings.ContainsRune(rtp.DTMFEvents, r) {
+				return invalidMessage(ev.Event, "invalid dtmf digit %q", r)
+			}
+		}
+		if ev.DurationMs < 0 || ev.DurationMs > maxAgentDTMFDuration {
+			return invalidMessage(ev.Event, "dtmf duration must be 0 to %d ms", maxAgentDTMFDuration)
+		}
+	case exotel.EventMark:
+		if ev.Mark == "" || len(ev.Mark) > maxAgentMarkName {
+			return invalidMessage(ev.Event, "mark needs a name of 1 to %d bytes", maxAgentMarkName)
+		}
+	case agentproto.EventTransfer:
+		if ev.Target == "" || len(ev.Target) > maxAgentTarget {
+			return invalidMessage(ev.Event, "transfer needs a target of 1 to %d bytes", maxAgentTarget)
+		}
+	}
+	return nil
+}
+
+// readAgentMessage reads the agent's next message. One over limit bytes (0
+// for no limit) is discarded without being held in memory and returned as
+// an agentMessageError; other errors are the connection's.
+func readAgentMessage(conn *websocket.Conn, limit int) (int, []byte, error) {
+	msgType, r, err := conn.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	if limit <= 0 {
+		data, err := io.ReadAll(r)
+		return msgType, data, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) > limit {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return 0, nil, err
+		}
+		return msgType, nil, rejectMessage(agentproto.ErrorMessageTooLarge, "",
+			fmt.Errorf("message over %d bytes", limit))
+	}
+	return msgType, data, nil
+}
+
+// agentErrorBudget counts an agent connection's rejected messages
+type agentErrorBudget struct {
+	limit int // Per agentErrorWindow; 0 for no limit
+	count int
+	since time.Time
+}
+
+// spend counts a rejected message, reporting false once the budget for the
+// current window is exceeded
+func (b *agentErrorBudget) spend(now time.Time) bool {
+	if b.limit <= 0 {
+		return true
+	}
+	if now.Sub(b.since) >= agentErrorWindow {
+		b.count, b.since = 0, now
+	}
+	b.count++
+	return b.count <= b.limit
+}
+
+// rejectAgentMessage reports a rejected agent message to the agent and
+// counts it against the connection's budget. Unknown events aren't counted,
+// so newer agents can talk to older blayzen-sip. An agent over budget is
+// disconnected with a policy violation close, returning false; the call
+// then goes on as for any lost agent.
+func (s *Session) rejectAgentMessage(conn *websocket.Conn, budget *agentErrorBudget, err error) bool {
+	var rejected *agentMessageError
+	if !errors.As(err, &rejected) {
+		rejected = malformedMessage(err)
+	}
+	agentDecodeErrors.log(s.log, slog.LevelWarn, "Rejected agent message",
+		"code", rejected.Code, "event", rejected.Event, "error", rejected.err)
+	s.trace(eventstream.EventAgent, models.SIPMessageOutbound, "error "+rejected.Code)
+	if err := s.sendWSMessage(s.agent.Error(s, rejected.ProtocolError)); err != nil {
+		s.log.Debug("Failed to send error to agent", "error", err)
+	}
+
+	if rejected.Code == agentproto.ErrorUnknownEvent || budget.spend(time.Now()) {
+		return true
+	}
+
+	s.log.Warn("Disconnecting agent over its error budget", "errors", budget.count, "window", agentErrorWindow)
+	s.wsMu.Lock()
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many invalid messages"),
+		time.Now().Add(time.Second))
+	s.wsMu.Unlock()
+	_ = conn.Close()
+	return false
+}
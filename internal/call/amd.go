@@ -0,0 +1,136 @@
+package call
+
+import (
+	"context"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Word segmentation for answering machine detection
+const (
+	amdMinWordLength       = 100 * time.Millisecond // Voiced run counted as a word
+	amdBetweenWordsSilence = 50 * time.Millisecond  // Silence that ends a word
+)
+
+// amdDetector classifies the start of an answered call as human or machine
+// from its voice activity: people answer with a short "hello?" and wait,
+// machines play a long greeting or nothing at all
+type amdDetector struct {
+	cfg *config.Config
+
+	elapsed  time.Duration
+	voiced   time.Duration // Current run of speech
+	silence  time.Duration // Current run of silence
+	inWord   bool
+	words    int
+	greeting time.Duration // Speech heard since the first word
+}
+
+// newAMDDetector creates a detector using the configured thresholds
+func newAMDDetector(cfg *config.Config) *amdDetector {
+	return &amdDetector{cfg: cfg}
+}
+
+// Process adds a frame of audio and returns the result with its reason once
+// decided, or "" while undecided
+func (d *amdDetector) Process(speech bool, length time.Duration) (result, reason string) {
+	d.elapsed += length
+
+	if speech {
+		d.voiced += length
+		if d.voiced >= amdMinWordLength {
+			d.silence = 0
+			if !d.inWord {
+				d.inWord = true
+				d.words++
+				d.greeting += d.voiced - length
+			}
+		}
+		if d.words > 0 {
+			d.greeting += length
+		}
+	} else {
+		d.voiced = 0
+		d.silence += length
+		if d.inWord && d.silence >= amdBetweenWordsSilence {
+			d.inWord = false
+		}
+	}
+
+	switch {
+	case d.words == 0 && d.silence >= d.cfg.AMDInitialSilence:
+		return models.AMDResultMachine, "initial silence"
+	case d.words > d.cfg.AMDMaxWords:
+		return models.AMDResultMachine, "too many words"
+	case d.greeting >= d.cfg.AMDGreeting:
+		return models.AMDResultMachine, "long greeting"
+	case d.words > 0 && d.silence >= d.cfg.AMDAfterGreetingSilence:
+		return models.AMDResultHuman, "silence after greeting"
+	case d.elapsed >= d.cfg.AMDTotalAnalysisTime:
+		return models.AMDResultNotSure, "analysis time exceeded"
+	}
+	return "", ""
+}
+
+// detectHuman runs answering machine detection on an answered call and
+// connects the agent once a human is detected. Machines are hung up.
+func (s *Session) detectHuman() {
+	defer s.running(loopDetectHuman)()
+
+	result, ok := s.runAMD()
+	if !ok {
+		return
+	}
+
+	if err := s.store.SetCallAMDResult(context.Background(), s.CallID, result); err != nil {
+		s.log.Error("Failed to store AMD result", "error", err)
+	}
+
+	if result == models.AMDResultMachine {
+		s.hangup(models.HangupCauseMachineDetected)
+		return
+	}
+
+	s.amdResult = result
+	if err := s.ConnectAgent(context.Background()); err != nil {
+		s.log.Error("Failed to connect to agent", "error", err)
+		s.hangup(models.HangupCauseAgentUnavailable)
+		return
+	}
+
+	go s.forwardToAgent()
+	go s.monitorSilence()
+}
+
+// runAMD feeds caller audio to the detector every 20ms until it decides.
+// Intervals without audio count as silence. It reports false if the call
+// ends first.
+func (s *Session) runAMD() (string, bool) {
+	detector := newAMDDetector(s.config)
+
+	ticker := time.NewTicker(playoutInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return "", false
+		case <-ticker.C:
+		}
+
+		payload, ok := s.jitter.Pop()
+		if !ok {
+			payload = silenceFrame
+		} else {
+			s.record(recordCaller, payload)
+		}
+
+		length := time.Duration(len(payload)) * time.Second / 8000
+		if result, reason := detector.Process(s.isSpeech(payload), length); result != "" {
+			s.log.Info("AMD result", "result", result, "reason", reason)
+			return result, true
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package call
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/audio"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// maxAnnouncementAudio caps the audio queued for a call's announcements
+// (5 minutes of PCMU)
+const maxAnnouncementAudio = 8000 * 300
+
+// ErrCallNotAnswered is returned when playing audio on a call not answered yet
+var ErrCallNotAnswered = errors.New("call has not been answered yet")
+
+// announcement is audio injected toward the caller through the API, mixed
+// with the agent's audio or replacing it while it plays
+type announcement struct {
+	audio   []byte // PCMU not yet played
+	replace bool
+}
+
+// ParseAnnouncement returns the audio of an announcement as PCMU: an 8kHz
+// mono WAV file (16-bit PCM or mu-law), or raw 8kHz mu-law when the content
+// type says so (audio/basic, audio/PCMU)
+func ParseAnnouncement(contentType string, data []byte) ([]byte, error) {
+	if len(data) >= 4 && string(data[0:4]) == "RIFF" {
+		return wavToMulaw(data)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "audio/basic", "audio/pcmu":
+		return data, nil
+	}
+	return nil, fmt.Errorf("unsupported audio %q: need a WAV file or raw mu-law (audio/basic)", mediaType)
+}
+
+// Play injects audio toward the caller of an active call on this instance.
+// It returns how long the audio lasts.
+func (m *Manager) Play(callID string, pcmu []byte, mode string, interrupt bool) (time.Duration, error) {
+	session := m.GetSession(callID)
+	if session == nil {
+		return 0, ErrCallNotActive
+	}
+	return session.Play(pcmu, mode, interrupt)
+}
+
+// Play queues audio for the caller after any announcement still playing,
+// mixed with the agent's audio or replacing it (the agent's audio is then
+// discarded while it plays). interrupt first discards the agent audio queued
+// so far, as a barge-in does.
+func (s *Session) Play(pcmu []byte, mode string, interrupt bool) (time.Duration, error) {
+	if s.isClosed() {
+		return 0, ErrCallNotActive
+	}
+	if s.mediaStarted.Load() == 0 {
+		return 0, ErrCallNotAnswered
+	}
+	if len(pcmu) == 0 {
+		return 0, errors.New("no audio")
+	}
+
+	s.announceMu.Lock()
+	queued := 0
+	for _, a := range s.announcements {
+		queued += len(a.audio)
+	}
+	if queued+len(pcmu) > maxAnnouncementAudio {
+		s.announceMu.Unlock()
+		return 0, fmt.Errorf("too much audio queued: at most %s", time.Duration(maxAnnouncementAudio)*time.Second/8000)
+	}
+	s.announcements = append(s.announcements, &announcement{
+		audio:   append([]byte(nil), pcmu...),
+		replace: mode == models.PlayModeReplace,
+	})
+	s.announceMu.Unlock()
+
+	if interrupt {
+		s.clearPlayout()
+	}
+
+	length := time.Duration(len(pcmu)) * time.Second / 8000
+	s.log.Info("Playing announcement", "duration", length, "mode", mode, "interrupt", interrupt)
+	return length, nil
+}
+
+// announce returns the agent's playout frame (nil when the agent is silent)
+// with the next frame of any announcement mixed in, or replaced by it
+func (s *Session) announce(frame []byte) []byte {
+	s.announceMu.Lock()
+	defer s.announceMu.Unlock()
+
+	if len(s.announcements) == 0 {
+		return frame
+	}
+	a := s.announcements[0]
+	chunk := take(&a.audio, playoutFrameSize)
+	if len(a.audio) == 0 {
+		s.announcements = s.announcements[1:]
+	}
+
+	out := make([]byte, playoutFrameSize)
+	if frame == nil || a.replace {
+		copy(out, silenceFrame)
+	} else {
+		copy(out, frame)
+	}
+	audio.MixMulaw(out, chunk)
+	return out
+}
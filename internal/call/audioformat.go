@@ -0,0 +1,103 @@
+package call
+
+import (
+	"github.com/shiv6146/blayzen-sip/internal/audio"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/pkg/agentproto"
+)
+
+// callerSampleRate is the sample rate of the caller leg (PCMU)
+const callerSampleRate = 8000
+
+// agentAudio converts media between the caller leg (8kHz mu-law, 20ms
+// frames) and the audio format configured for the agent on the route
+type agentAudio struct {
+	format   models.AudioFormat
+	toAgent  *audio.Resampler
+	toCaller *audio.Resampler
+
+	// Converted caller audio waiting to fill an agent chunk
+	pending []byte
+
+	// Trailing odd byte of agent L16 audio split across messages
+	partial []byte
+}
+
+// newAgentAudio creates a converter for the given agent audio format
+func newAgentAudio(format models.AudioFormat) *agentAudio {
+	return &agentAudio{
+		format:   format,
+		toAgent:  audio.NewResampler(callerSampleRate, format.SampleRate),
+		toCaller: audio.NewResampler(format.SampleRate, callerSampleRate),
+	}
+}
+
+// passthrough reports whether the agent uses the caller's native encoding
+func (a *agentAudio) passthrough() bool {
+	return a.format.Encoding == models.AudioEncodingMulaw && a.format.SampleRate == callerSampleRate
+}
+
+// FromCaller converts a frame of caller audio and returns any agent chunks
+// that are now complete
+func (a *agentAudio) FromCaller(mulaw []byte) [][]byte {
+	converted := mulaw
+	if !a.passthrough() {
+		samples := a.toAgent.Process(audio.MulawToSamples(mulaw))
+		if a.format.Encoding == models.AudioEncodingL16 {
+			converted = audio.SamplesToPCM16(samples)
+		} else {
+			converted = audio.SamplesToMulaw(samples)
+		}
+	}
+
+	a.pending = append(a.pending, converted...)
+
+	size := a.format.ChunkBytes()
+	var chunks [][]byte
+	for len(a.pending) >= size {
+		chunk := make([]byte, size)
+		copy(chunk, a.pending)
+		chunks = append(chunks, chunk)
+		a.pending = a.pending[size:]
+	}
+
+	return chunks
+}
+
+// ToCaller converts agent audio to 8kHz mu-law for the caller leg
+func (a *agentAudio) ToCaller(data []byte) []byte {
+	if a.passthrough() {
+		return data
+	}
+
+	var samples []int16
+	if a.format.Encoding == models.AudioEncodingL16 {
+		if len(a.partial) > 0 {
+			data = append(a.partial, data...)
+			a.partial = nil
+		}
+		if len(data)%2 == 1 {
+			a.partial = []byte{data[len(data)-1]}
+			data = data[:len(data)-1]
+		}
+		samples = audio.PCM16ToSamples(data)
+	} else {
+		samples = audio.MulawToSamples(data)
+	}
+
+	return audio.SamplesToMulaw(a.toCaller.Process(samples))
+}
+
+// MediaFormat describes the agent audio format for the start message
+func (a *agentAudio) MediaFormat() agentproto.MediaFormat {
+	chunkMs := a.format.ChunkMs
+	if chunkMs == 0 {
+		chunkMs = models.DefaultAudioFormat.ChunkMs
+	}
+
+	return agentproto.MediaFormat{
+		Encoding:   a.format.Encoding,
+		SampleRate: a.format.SampleRate,
+		ChunkMs:    chunkMs,
+	}
+}
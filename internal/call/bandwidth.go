@@ -0,0 +1,88 @@
+package call
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Media bandwidth of calls. Every call carries 20ms PCMU packets each way,
+// 50 a second, of 160 bytes of audio with RTP (12) and UDP (8) headers, and
+// IPv4 (20) or IPv6 (40) headers on the wire.
+const (
+	rtpPacketsPerSecond = 50
+	rtpPacketBytes      = 160 + 12 + 8
+)
+
+// ErrBitrateExceeded is returned by CreateSession when a call is refused
+// because its media needs more bitrate than its route or trunk allows
+var ErrBitrateExceeded = errors.New("media bitrate over limit")
+
+// MediaBitrateKbps returns the bitrate of a call's media on the wire, each
+// way
+func MediaBitrateKbps(ipv6 bool) int {
+	ipHeader := 20
+	if ipv6 {
+		ipHeader = 40
+	}
+	return (rtpPacketBytes + ipHeader) * 8 * rtpPacketsPerSecond / 1000
+}
+
+// bitrateExceeded returns why a call needing kbps each way can't be carried
+// on its route or trunk, or "" when it can
+func bitrateExceeded(route *models.Route, trunk *models.Trunk, kbps int) string {
+	if route.MaxBitrateKbps != nil && kbps > *route.MaxBitrateKbps {
+		return fmt.Sprintf("call needs %d kbps, route allows %d", kbps, *route.MaxBitrateKbps)
+	}
+	if trunk != nil && trunk.MaxBitrateKbps != nil && kbps > *trunk.MaxBitrateKbps {
+		return fmt.Sprintf("call needs %d kbps, trunk allows %d", kbps, *trunk.MaxBitrateKbps)
+	}
+	return ""
+}
+
+// countMedia sets the counters of the RTP bytes the session exchanges with
+// the caller, by route and trunk
+func (s *Session) countMedia() {
+	trunkID := ""
+	if s.trunk != nil {
+		trunkID = s.trunk.ID
+	}
+	s.mediaBytesIn = metrics.MediaBytes.With(s.Route.ID, trunkID, metrics.DirectionIn)
+	s.mediaBytesOut = metrics.MediaBytes.With(s.Route.ID, trunkID, metrics.DirectionOut)
+}
+
+// recordMediaUsage stores the RTP bytes of a call that ended in its record
+func (m *Manager) recordMediaUsage(ctx context.Context, s *Session) {
+	if err := m.store.SetCallMediaUsage(ctx, s.CallID, s.bytesIn.Load(), s.bytesOut.Load()); err != nil {
+		s.log.Error("Failed to record media usage", "error", err)
+	}
+}
+
+// Bandwidth returns the media bandwidth an account's calls in progress use
+// now, on every instance, against its limit
+func (m *Manager) Bandwidth(ctx context.Context, accountID string) *models.BandwidthUsage {
+	usage := &models.BandwidthUsage{
+		ByRoute: make(map[string]int),
+		ByTrunk: make(map[string]int),
+	}
+	for _, a := range m.ActiveCalls(ctx, accountID) {
+		usage.ActiveCalls++
+		usage.BitrateKbps += a.BitrateKbps
+		usage.BytesIn += a.BytesIn
+		usage.BytesOut += a.BytesOut
+		if a.RouteID != "" {
+			usage.ByRoute[a.RouteID] += a.BitrateKbps
+		}
+		if a.TrunkID != "" {
+			usage.ByTrunk[a.TrunkID] += a.BitrateKbps
+		}
+	}
+
+	if account, err := m.store.GetAccount(ctx, accountID); err == nil {
+		usage.MaxBandwidthKbps = account.MaxBandwidthKbps
+	}
+	return usage
+}
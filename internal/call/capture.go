@@ -0,0 +1,155 @@
+package call
+
+import (
+	"context"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+// CaptureRequest records a SIP request received or sent for a call, and
+// traces it for streams following the call
+func (m *Manager) CaptureRequest(req *sip.Request, direction models.SIPMessageDirection, remoteAddr string) {
+	msg := captureRequest(m.captures, m.config, req, direction, remoteAddr)
+	if s := m.GetSession(msg.CallID); s != nil {
+		s.traceSIP(msg)
+	}
+}
+
+// CaptureResponse records a SIP response received or sent for a call, and
+// traces it for streams following the call
+func (m *Manager) CaptureResponse(resp *sip.Response, direction models.SIPMessageDirection, remoteAddr string) {
+	msg := captureResponse(m.captures, m.config, resp, direction, remoteAddr)
+	if s := m.GetSession(msg.CallID); s != nil {
+		s.traceSIP(msg)
+	}
+}
+
+// captureRequest records a request for the call flow, in full when tracing
+func captureRequest(q *captureQueue, cfg *config.Config, req *sip.Request, direction models.SIPMessageDirection, remoteAddr string) *models.SIPMessage {
+	msg := &models.SIPMessage{
+		CallID:     req.CallID().Value(),
+		Direction:  direction,
+		Method:     string(req.Method),
+		RemoteAddr: remoteAddr,
+		CapturedAt: time.Now(),
+	}
+	traceMessage(cfg, msg, req)
+
+	q.add(msg)
+	return msg
+}
+
+// captureResponse records a response for the call flow, in full when tracing
+func captureResponse(q *captureQueue, cfg *config.Config, resp *sip.Response, direction models.SIPMessageDirection, remoteAddr string) *models.SIPMessage {
+	statusCode := int(resp.StatusCode)
+	reason := resp.Reason
+
+	msg := &models.SIPMessage{
+		CallID:     resp.CallID().Value(),
+		Direction:  direction,
+		StatusCode: &statusCode,
+		Reason:     &reason,
+		RemoteAddr: remoteAddr,
+		CapturedAt: time.Now(),
+	}
+	if cseq := resp.CSeq(); cseq != nil {
+		msg.Method = string(cseq.MethodName)
+	}
+	traceMessage(cfg, msg, resp)
+
+	q.add(msg)
+	return msg
+}
+
+// traceMessage keeps the full text of a captured message and our address
+// when SIP tracing is enabled
+func traceMessage(cfg *config.Config, msg *models.SIPMessage, sipMsg sip.Message) {
+	if cfg == nil || !cfg.SIPTraceEnabled {
+		return
+	}
+
+	raw := sipMsg.String()
+	local := localSIPAddr(cfg.SIPPort)
+	msg.Raw = &raw
+	msg.LocalAddr = &local
+}
+
+// Captured messages are stored in batches of up to captureBatchSize, at
+// least every captureFlushInterval
+const (
+	captureBatchSize     = 100
+	captureFlushInterval = time.Second
+)
+
+// captureQueue stores captured messages from a bounded queue, without
+// blocking signaling: messages captured while it's full are dropped. A nil
+// queue, with capture disabled, stores nothing.
+type captureQueue struct {
+	store *store.PostgresStore
+	queue chan *models.SIPMessage
+}
+
+// newCaptureQueue starts the capture queue, or returns nil when neither
+// capture nor tracing is enabled
+func newCaptureQueue(cfg *config.Config, st *store.PostgresStore) *captureQueue {
+	if st == nil || (!cfg.SIPCaptureEnabled && !cfg.SIPTraceEnabled) {
+		return nil
+	}
+
+	q := &captureQueue{store: st, queue: make(chan *models.SIPMessage, max(cfg.SIPCaptureQueueSize, 1))}
+	go q.run()
+	return q
+}
+
+// add queues a captured message for storage, dropping it when the queue is
+// full
+func (q *captureQueue) add(msg *models.SIPMessage) {
+	if q == nil {
+		return
+	}
+
+	select {
+	case q.queue <- msg:
+	default:
+		metrics.SIPCaptureDropped.Inc()
+	}
+}
+
+// run stores queued messages in batches
+func (q *captureQueue) run() {
+	ticker := time.NewTicker(captureFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*models.SIPMessage, 0, captureBatchSize)
+	for {
+		select {
+		case msg := <-q.queue:
+			batch = append(batch, msg)
+			if len(batch) < captureBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		q.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush stores a batch of captured messages
+func (q *captureQueue) flush(batch []*models.SIPMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := q.store.RecordSIPMessages(ctx, batch); err != nil {
+		logger.Warn("Failed to capture SIP messages", "messages", len(batch), "error", err)
+	}
+}
@@ -0,0 +1,56 @@
+package call
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shiv6146/blayzen-sip/internal/privacy"
+)
+
+// ConversationIDHeader is the INVITE header naming the conversation a call
+// joins, as dialers set for campaign attempts and transfers back to us carry
+const ConversationIDHeader = "X-Conversation-ID"
+
+// conversationID returns the conversation a new call joins: the one its
+// INVITE names, or that of the caller's latest call to the account started
+// within ConversationWindow. Other calls start a conversation of their own.
+func (m *Manager) conversationID(ctx context.Context, s *Session) string {
+	if s.inviteReq != nil {
+		if h := s.inviteReq.GetHeader(ConversationIDHeader); h != nil {
+			if id, err := uuid.Parse(strings.TrimSpace(h.Value())); err == nil {
+				return id.String()
+			}
+		}
+	}
+
+	// Masked numbers that don't tell callers apart can't link their calls
+	window := m.config.ConversationWindow
+	if window > 0 && s.FromUser != "" && s.Route.AccountID != "" && privacy.CDRNumbersDistinct() {
+		id, err := m.store.FindRecentConversation(ctx, s.Route.AccountID, privacy.CDRNumber(s.FromUser), time.Now().Add(-window))
+		if err == nil {
+			return id
+		}
+	}
+	return uuid.NewString()
+}
+
+// ConversationID returns the ID of the conversation the call belongs to
+func (s *Session) ConversationID() string {
+	return s.conversationID
+}
+
+// conversationURI adds the call's conversation to a transfer target as a
+// URI header (RFC 3261 section 19.1.1), so the call the caller's side
+// places to it carries X-Conversation-ID
+func (s *Session) conversationURI(uri string) string {
+	if s.conversationID == "" {
+		return uri
+	}
+	sep := "?"
+	if strings.Contains(uri, "?") {
+		sep = "&"
+	}
+	return uri + sep + ConversationIDHeader + "=" + s.conversationID
+}
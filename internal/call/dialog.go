@@ -0,0 +1,203 @@
+package call
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/sipheader"
+)
+
+// SetAnswer stores the final 2xx response sent for the INVITE. Together with
+// the INVITE it describes the dialog used for requests toward the caller,
+// persisted in an active/standby pair.
+func (s *Session) SetAnswer(resp *sip.Response) {
+	s.answer = resp
+	s.answeredAt.CompareAndSwap(0, time.Now().UnixNano())
+	s.persistAnswer(resp)
+}
+
+// SetEgressRules sets the header rules (route then trunk) applied to SIP
+// messages sent toward the caller
+func (s *Session) SetEgressRules(rules []models.HeaderRule) {
+	s.egressRules = rules
+}
+
+// EgressRules returns the header rules applied to messages toward the caller
+func (s *Session) EgressRules() []models.HeaderRule {
+	return s.egressRules
+}
+
+// newDialogRequest builds an in-dialog request from us (UAS) toward the caller
+func (s *Session) newDialogRequest(method sip.RequestMethod) (*sip.Request, error) {
+	if s.inviteReq == nil || s.answer == nil {
+		return nil, fmt.Errorf("dialog not established for call %s", s.CallID)
+	}
+
+	// Target the caller's Contact, falling back to the From URI
+	target := s.inviteReq.From().Address
+	if contact := s.inviteReq.Contact(); contact != nil {
+		target = contact.Address
+	}
+
+	req := sip.NewRequest(method, target)
+
+	// Route set is taken from Record-Route in the order received
+	for _, rr := range s.inviteReq.GetHeaders("Record-Route") {
+		req.AppendHeader(sip.NewHeader("Route", rr.Value()))
+	}
+
+	// Our side of the dialog is the To of the answer (carrying our tag)
+	localParty := s.answer.To()
+	req.AppendHeader(&sip.FromHeader{
+		DisplayName: localParty.DisplayName,
+		Address:     localParty.Address,
+		Params:      localParty.Params.Clone(),
+	})
+
+	remoteParty := s.inviteReq.From()
+	req.AppendHeader(&sip.ToHeader{
+		DisplayName: remoteParty.DisplayName,
+		Address:     remoteParty.Address,
+		Params:      remoteParty.Params.Clone(),
+	})
+
+	callID := sip.CallIDHeader(s.CallID)
+	req.AppendHeader(&callID)
+	req.AppendHeader(&sip.CSeqHeader{
+		SeqNo:      atomic.AddUint32(&s.localCSeq, 1),
+		MethodName: method,
+	})
+	maxForwards := sip.MaxForwardsHeader(70)
+	req.AppendHeader(&maxForwards)
+
+	// Send back to where the INVITE came from so NATed callers are reachable,
+	// or behind a proxy to the dialog's first hop, unless all egress SIP must
+	// go via an outbound proxy: the call's trunk's, else the global one.
+	req.SetTransport(s.inviteReq.Transport())
+	req.SetDestination(s.inviteReq.Source())
+	if s.config.SIPEdgeMode {
+		if hop := nextHop(req, target); hop != "" {
+			req.SetDestination(hop)
+		}
+	}
+	proxy := s.config.SIPOutboundProxy
+	if s.trunk != nil {
+		proxy = s.trunk.Proxy(proxy)
+	}
+	if proxy != "" {
+		req.PrependHeader(sip.NewHeader("Route", fmt.Sprintf("<sip:%s;lr>", proxy)))
+		req.SetDestination(proxy)
+	}
+
+	return req, nil
+}
+
+// nextHop returns the address a request within a dialog is sent to: its
+// first Route when loose routing, else the remote target (RFC 3261 section
+// 12.2.1.1), or "" when it has no port-resolvable host
+func nextHop(req *sip.Request, target sip.Uri) string {
+	if route := req.GetHeader("Route"); route != nil {
+		var uri sip.Uri
+		if _, err := sip.ParseAddressValue(route.Value(), &uri, sip.NewParams()); err == nil && uri.UriParams.Has("lr") {
+			target = uri
+		}
+	}
+	if target.Host == "" {
+		return ""
+	}
+	port := target.Port
+	if port == 0 {
+		port = 5060
+		if target.IsEncrypted() {
+			port = 5061
+		}
+	}
+	return net.JoinHostPort(strings.Trim(target.Host, "[]"), strconv.Itoa(port))
+}
+
+// Hangup ends an established call from our side with a BYE to the caller.
+// Browser calls have no dialog; closing the session closes their connection.
+func (s *Session) Hangup(ctx context.Context) error {
+	if s.inviteReq == nil {
+		return nil
+	}
+
+	req, err := s.newDialogRequest(sip.BYE)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.doDialogRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !res.IsSuccess() {
+		return fmt.Errorf("BYE rejected: %d %s", res.StatusCode, res.Reason)
+	}
+	return nil
+}
+
+// Refer asks the caller's side to transfer the call to target, a SIP URI
+// (blind transfer, RFC 3515), without the NOTIFYs reporting how the transfer
+// went (RFC 4488). The call is still ours to hang up once accepted.
+func (s *Session) Refer(ctx context.Context, target string) error {
+	req, err := s.newDialogRequest(sip.REFER)
+	if err != nil {
+		return err
+	}
+	req.AppendHeader(sip.NewHeader("Refer-To", "<"+target+">"))
+	req.AppendHeader(sip.NewHeader("Referred-By", "<"+s.answer.To().Address.String()+">"))
+	req.AppendHeader(sip.NewHeader("Refer-Sub", "false"))
+
+	res, err := s.doDialogRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !res.IsSuccess() {
+		return fmt.Errorf("REFER rejected: %d %s", res.StatusCode, res.Reason)
+	}
+	return nil
+}
+
+// doDialogRequest sends an in-dialog request and waits for its final response
+func (s *Session) doDialogRequest(ctx context.Context, req *sip.Request) (*sip.Response, error) {
+	if s.client == nil {
+		return nil, fmt.Errorf("no SIP client available")
+	}
+
+	if err := sipheader.Apply(req, s.egressRules, models.HeaderRuleEgress); err != nil {
+		s.log.Warn("Failed to apply header rules", "error", err)
+	}
+
+	tx, err := s.client.TransactionRequest(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", req.Method, err)
+	}
+	defer tx.Terminate()
+	s.traceSIP(captureRequest(s.captures, s.config, req, models.SIPMessageOutbound, req.Destination()))
+
+	for {
+		select {
+		case res := <-tx.Responses():
+			s.traceSIP(captureResponse(s.captures, s.config, res, models.SIPMessageInbound, req.Destination()))
+			if res.IsProvisional() {
+				continue
+			}
+			if s.trunk != nil {
+				recordTrunkResponse(s.store, s.trunk.ID, models.CallDirectionOutbound, string(req.Method), int(res.StatusCode))
+			}
+			return res, nil
+		case <-tx.Done():
+			return nil, fmt.Errorf("%s transaction terminated: %w", req.Method, tx.Err())
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
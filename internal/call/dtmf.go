@@ -0,0 +1,292 @@
+package call
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/eventstream"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/pkg/rtp"
+	"github.com/shiv6146/blayzen-sip/pkg/sdp"
+)
+
+// telephoneEventClockRate is the sample rate used for telephone-event durations
+const telephoneEventClockRate = 8000
+
+// defaultDTMFDuration is used when the agent does not specify a tone length
+const defaultDTMFDuration = 200
+
+// dtmfInterDigitGap is the pause between consecutive generated digits
+const dtmfInterDigitGap = 100 * time.Millisecond
+
+// handleTelephoneEvent processes an RFC 2833 telephone-event RTP packet.
+// Senders repeat every event for its whole duration and send the end packet
+// three times, so an event is only forwarded once, on its first end packet.
+func (s *Session) handleTelephoneEvent(rtpTimestamp uint32, payload []byte) {
+	event, err := rtp.UnmarshalTelephoneEvent(payload)
+	if err != nil || !event.End {
+		return
+	}
+
+	// Ignore retransmitted end packets for an event we already forwarded
+	if s.dtmfSeen && s.lastDTMFTimestamp == rtpTimestamp {
+		return
+	}
+	s.dtmfSeen = true
+	s.lastDTMFTimestamp = rtpTimestamp
+
+	digit, ok := event.Digit()
+	if !ok {
+		s.log.Debug("Ignoring unsupported telephone-event", "event", event.Event)
+		return
+	}
+
+	durationMs := int(event.Duration) * 1000 / telephoneEventClockRate
+	s.log.Debug("DTMF received", "digit", string(digit), "duration_ms", durationMs)
+	s.trace(eventstream.EventMedia, models.SIPMessageInbound, "dtmf "+string(digit))
+	s.markActivity()
+
+	if err := s.sendWSMessage(s.agent.DTMF(s, string(digit), durationMs)); err != nil {
+		s.log.Warn("Failed to send DTMF to agent", "error", err)
+	}
+	s.pressShortcut(string(digit))
+}
+
+// offeredEventPT returns the payload type an SDP offer maps telephone-event
+// to on its audio stream, or -1 when it offers none
+func offeredEventPT(offer string) int32 {
+	desc, err := sdp.Parse([]byte(offer))
+	if err != nil {
+		return -1
+	}
+	audio := desc.FirstMedia("audio")
+	if audio == nil {
+		return -1
+	}
+	pt, ok := audio.PayloadType("telephone-event")
+	if !ok {
+		return -1
+	}
+	return int32(pt)
+}
+
+// offeredEventFmtp returns the events an SDP offer's fmtp lists for its
+// telephone-event payload type, or 0-16 (the DTMF events) when it lists none
+func offeredEventFmtp(offer string, pt int32) string {
+	desc, err := sdp.Parse([]byte(offer))
+	if err != nil {
+		return "0-16"
+	}
+	prefix := strconv.Itoa(int(pt)) + " "
+	if audio := desc.FirstMedia("audio"); audio != nil {
+		for _, a := range audio.Attributes {
+			if a.Key == "fmtp" && strings.HasPrefix(a.Value, prefix) {
+				return strings.TrimSpace(strings.TrimPrefix(a.Value, prefix))
+			}
+		}
+	}
+	return "0-16"
+}
+
+// pressShortcut adds a digit to those the caller pressed, and runs the
+// route's DTMF shortcut they complete, if any. Digits further apart than
+// DTMFShortcutGap start over.
+func (s *Session) pressShortcut(digit string) {
+	if len(s.Route.DTMFShortcuts) == 0 {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(s.dtmfPressedAt) > models.DTMFShortcutGap {
+		s.dtmfPressed = ""
+	}
+	s.dtmfPressedAt = now
+	s.dtmfPressed += digit
+	if len(s.dtmfPressed) > models.MaxDTMFShortcutDigits {
+		s.dtmfPressed = s.dtmfPressed[len(s.dtmfPressed)-models.MaxDTMFShortcutDigits:]
+	}
+
+	shortcut := models.MatchDTMFShortcut(s.Route.DTMFShortcuts, s.dtmfPressed)
+	if shortcut == nil {
+		return
+	}
+	s.dtmfPressed = ""
+
+	// Off the RTP loop, as transfers wait for the caller's side
+	go s.runShortcut(shortcut)
+}
+
+// runShortcut notifies webhooks of a DTMF shortcut and takes its action
+func (s *Session) runShortcut(shortcut *models.DTMFShortcut) {
+	s.log.Info("DTMF shortcut pressed", "digits", shortcut.Digits, "action", shortcut.Action)
+	s.trace(eventstream.EventMedia, models.SIPMessageInbound, "dtmf shortcut "+shortcut.Digits+" ("+shortcut.Action+")")
+	s.events.DTMFShortcut(s.Route.AccountID, s.CallID, shortcut)
+
+	switch shortcut.Action {
+	case models.DTMFActionTransfer:
+		if err := s.Transfer(context.Background(), shortcut.Target, models.HangupPartyCaller); err != nil {
+			s.log.Warn("DTMF shortcut transfer failed", "target", shortcut.Target, "error", err)
+		}
+
+	case models.DTMFActionHangup:
+		s.SetHangup(models.HangupCauseDTMFShortcut, models.HangupPartyCaller)
+		if s.hangup != nil {
+			s.hangup(models.HangupCauseDTMFShortcut)
+		}
+
+	case models.DTMFActionSet:
+		var value interface{} = true
+		if shortcut.Value != nil {
+			value = shortcut.Value
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.store.SetCallData(ctx, s.CallID, shortcut.Key, value); err != nil {
+			s.log.Error("Failed to set call data for DTMF shortcut", "key", shortcut.Key, "error", err)
+		}
+	}
+}
+
+// handleAgentDTMF generates DTMF toward the caller for a "dtmf" agent message
+func (s *Session) handleAgentDTMF(digits string, durationMs int) {
+	if durationMs <= 0 {
+		durationMs = defaultDTMFDuration
+	}
+
+	// Generate on a separate goroutine so the agent read loop keeps running,
+	// serialised so digits from consecutive messages don't overlap
+	go func() {
+		s.dtmfMu.Lock()
+		defer s.dtmfMu.Unlock()
+
+		if err := s.SendDTMF(digits, durationMs); err != nil {
+			s.log.Warn("Failed to send DTMF", "error", err)
+		}
+	}()
+}
+
+// SendDTMF plays the given digits toward the caller. RFC 2833 telephone-events
+// are used when the caller offered them, otherwise each digit is sent as a
+// SIP INFO request.
+func (s *Session) SendDTMF(digits string, durationMs int) error {
+	useRTP := s.supportsTelephoneEvent()
+
+	for i, r := range strings.ToUpper(digits) {
+		event := strings.IndexRune(rtp.DTMFEvents, r)
+		if event < 0 {
+			return fmt.Errorf("invalid DTMF digit %q", r)
+		}
+
+		if i > 0 {
+			select {
+			case <-s.stopChan:
+				return fmt.Errorf("session closed")
+			case <-time.After(dtmfInterDigitGap):
+			}
+		}
+
+		s.log.Debug("Sending DTMF", "digit", string(r), "duration_ms", durationMs)
+
+		if useRTP {
+			if err := s.sendTelephoneEvent(byte(event), durationMs); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.sendDTMFInfo(string(r), durationMs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// supportsTelephoneEvent reports whether the caller's SDP offered RFC 2833
+func (s *Session) supportsTelephoneEvent() bool {
+	return s.eventPT.Load() >= 0
+}
+
+// sendTelephoneEvent plays a single RFC 2833 event toward the caller, on the
+// payload type their offer maps telephone-event to
+func (s *Session) sendTelephoneEvent(event byte, durationMs int) error {
+	if s.media == nil || !s.media.Ready() {
+		return fmt.Errorf("remote RTP address not known yet")
+	}
+	pt := s.eventPT.Load()
+	if pt < 0 {
+		return fmt.Errorf("telephone-events not negotiated")
+	}
+
+	const packetInterval = 20 * time.Millisecond
+	samplesPerPacket := telephoneEventClockRate * int(packetInterval/time.Millisecond) / 1000
+	totalSamples := durationMs * telephoneEventClockRate / 1000
+
+	// All packets of one event share the timestamp of its start
+	s.txMu.Lock()
+	start := s.txTimestamp
+	s.txMu.Unlock()
+
+	ticker := time.NewTicker(packetInterval)
+	defer ticker.Stop()
+
+	for elapsed := samplesPerPacket; elapsed < totalSamples; elapsed += samplesPerPacket {
+		s.writeRTP(byte(pt), elapsed == samplesPerPacket, start, telephoneEventPayload(event, false, elapsed))
+
+		select {
+		case <-s.stopChan:
+			return fmt.Errorf("session closed")
+		case <-ticker.C:
+		}
+	}
+
+	// The end packet is sent three times for robustness against loss
+	for i := 0; i < 3; i++ {
+		s.writeRTP(byte(pt), false, start, telephoneEventPayload(event, true, totalSamples))
+	}
+
+	s.txMu.Lock()
+	if end := start + uint32(totalSamples); int32(end-s.txTimestamp) > 0 {
+		s.txTimestamp = end
+	}
+	s.txMu.Unlock()
+
+	return nil
+}
+
+// telephoneEventPayload encodes an RFC 2833 event payload
+func telephoneEventPayload(event byte, end bool, duration int) []byte {
+	return (&rtp.TelephoneEvent{
+		Event:    event,
+		End:      end,
+		Volume:   10, // -10 dBm0
+		Duration: uint16(duration),
+	}).Marshal()
+}
+
+// sendDTMFInfo sends a digit as a SIP INFO request (application/dtmf-relay)
+func (s *Session) sendDTMFInfo(digit string, durationMs int) error {
+	req, err := s.newDialogRequest(sip.INFO)
+	if err != nil {
+		return err
+	}
+
+	req.AppendHeader(sip.NewHeader("Content-Type", "application/dtmf-relay"))
+	req.SetBody([]byte(fmt.Sprintf("Signal=%s\r\nDuration=%d\r\n", digit, durationMs)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.doDialogRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !res.IsSuccess() {
+		return fmt.Errorf("INFO rejected: %d %s", res.StatusCode, res.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package call
+
+import (
+	"time"
+)
+
+// StartEarlyMedia streams between the caller and the agent of an early media
+// route before the call is answered, once 183 Session Progress with our SDP
+// has been sent. RTP is sent to the address in the caller's SDP offer until
+// a packet from the caller shows where to send it.
+func (s *Session) StartEarlyMedia() {
+	if !s.earlyMedia.CompareAndSwap(false, true) {
+		return
+	}
+	if udp, ok := s.media.(*udpTransport); ok {
+		if addr := s.offeredRTPAddr(); addr != nil {
+			udp.Offer(addr)
+		}
+	}
+	s.log.Info("Starting early media")
+
+	go s.receiveRTP()
+	go s.runPlayout()
+	go s.forwardToAgent()
+}
+
+// WaitForAnswer waits for the agent streaming early media to ask for the
+// call to be answered, or EarlyMediaTimeout, after which it is answered
+// anyway. It returns ErrCallNotActive if the call ends first: the agent
+// stopped it or the caller's side cancelled it.
+func (s *Session) WaitForAnswer() error {
+	timer := time.NewTimer(s.config.EarlyMediaTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-s.answerNow:
+		s.log.Info("Agent answered the call")
+	case <-timer.C:
+		s.log.Info("Agent didn't answer early media in time, answering", "timeout", s.config.EarlyMediaTimeout)
+	case <-s.stopChan:
+		return ErrCallNotActive
+	}
+	return nil
+}
+
+// requestAnswer handles the agent asking for the call to be answered. It is
+// ignored outside early media.
+func (s *Session) requestAnswer() {
+	if !s.earlyMedia.Load() {
+		return
+	}
+	s.answerOnce.Do(func() { close(s.answerNow) })
+}
@@ -0,0 +1,164 @@
+package call
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/failover"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// persistsDialogs reports whether dialogs are persisted for the instance
+// taking over from this one
+func (m *Manager) persistsDialogs() bool {
+	return m.config.HAMode == failover.ModeActiveStandby
+}
+
+// persistDialog saves the dialog of a new SIP call
+func (m *Manager) persistDialog(ctx context.Context, s *Session) {
+	if !m.persistsDialogs() || s.inviteReq == nil {
+		return
+	}
+	err := m.store.SaveFailoverDialog(ctx, &models.FailoverDialog{
+		CallID:     s.CallID,
+		InstanceID: m.config.InstanceID,
+		AccountID:  &s.Route.AccountID,
+		Invite:     s.inviteReq.String(),
+		Source:     s.inviteReq.Source(),
+		Transport:  s.inviteReq.Transport(),
+	})
+	if err != nil {
+		s.log.Warn("Failed to persist dialog", "error", err)
+	}
+}
+
+// persistAnswer adds the 2xx answering the call to its persisted dialog
+func (s *Session) persistAnswer(resp *sip.Response) {
+	if s.config.HAMode != failover.ModeActiveStandby || s.inviteReq == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hangupTimeout)
+	defer cancel()
+	if err := s.store.SetFailoverDialogAnswer(ctx, s.CallID, resp.String(), s.egressRules); err != nil {
+		s.log.Warn("Failed to persist dialog answer", "error", err)
+	}
+}
+
+// forgetDialog removes the persisted dialog of a call that ended
+func (m *Manager) forgetDialog(ctx context.Context, s *Session) {
+	if !m.persistsDialogs() || s.inviteReq == nil {
+		return
+	}
+	if err := m.store.DeleteFailoverDialog(ctx, s.CallID); err != nil {
+		s.log.Warn("Failed to forget dialog", "error", err)
+	}
+}
+
+// EndOrphanedCalls ends the calls left in progress by an instance that
+// failed, from the dialogs it persisted: answered calls are hung up with a
+// BYE toward the caller, whose media can't be picked up where it was, and
+// every call is recorded as ended by failover. The instance taking over in
+// an active/standby pair runs it once it listens for SIP.
+func (m *Manager) EndOrphanedCalls(ctx context.Context) {
+	if !m.persistsDialogs() {
+		return
+	}
+	dialogs, err := m.store.ListFailoverDialogs(ctx)
+	if err != nil {
+		logger.Error("Failed to load persisted dialogs", "error", err)
+		return
+	}
+
+	ended := 0
+	for _, d := range dialogs {
+		if m.GetSession(d.CallID) != nil {
+			continue
+		}
+		m.endOrphanedCall(ctx, d)
+		ended++
+	}
+	if ended > 0 {
+		logger.Warn("Ended calls left by a failed instance", "count", ended)
+	}
+}
+
+// endOrphanedCall hangs up and records the end of a call left by another
+// instance
+func (m *Manager) endOrphanedCall(ctx context.Context, d *models.FailoverDialog) {
+	accountID := ""
+	if d.AccountID != nil {
+		accountID = *d.AccountID
+	}
+	log := callLogger(d.CallID, accountID).With("instance", d.InstanceID)
+
+	status := models.CallStatusFailed
+	if d.Answer != nil {
+		status = models.CallStatusCompleted
+		s, err := m.orphanedSession(d, accountID)
+		if err == nil {
+			s.log = log
+			hangupCtx, cancel := context.WithTimeout(ctx, hangupTimeout)
+			err = s.Hangup(hangupCtx)
+			cancel()
+		}
+		if err != nil {
+			log.Warn("Failed to hang up orphaned call", "error", err)
+		}
+	}
+
+	if err := m.store.UpdateCallStatus(ctx, d.CallID, status); err != nil {
+		log.Error("Failed to update call status", "error", err)
+	}
+	if err := m.store.SetCallHangup(ctx, d.CallID, models.HangupCauseFailover, models.HangupPartySystem); err != nil {
+		log.Error("Failed to record hangup cause", "error", err)
+	}
+	if m.cache != nil {
+		_ = m.cache.RemoveActiveCall(ctx, d.CallID)
+	}
+	if err := m.store.DeleteFailoverDialog(ctx, d.CallID); err != nil {
+		log.Warn("Failed to forget dialog", "error", err)
+	}
+	log.Info("Orphaned call ended", "status", status)
+}
+
+// orphanedSession rebuilds enough of a session from a persisted dialog to
+// send requests toward the caller
+func (m *Manager) orphanedSession(d *models.FailoverDialog, accountID string) (*Session, error) {
+	msg, err := sip.ParseMessage([]byte(d.Invite))
+	if err != nil {
+		return nil, fmt.Errorf("invalid persisted INVITE: %w", err)
+	}
+	invite, ok := msg.(*sip.Request)
+	if !ok {
+		return nil, fmt.Errorf("persisted INVITE is not a request")
+	}
+	invite.SetSource(d.Source)
+	invite.SetTransport(d.Transport)
+
+	msg, err = sip.ParseMessage([]byte(*d.Answer))
+	if err != nil {
+		return nil, fmt.Errorf("invalid persisted answer: %w", err)
+	}
+	answer, ok := msg.(*sip.Response)
+	if !ok {
+		return nil, fmt.Errorf("persisted answer is not a response")
+	}
+
+	return &Session{
+		CallID:      d.CallID,
+		Route:       &models.Route{AccountID: accountID},
+		client:      m.client,
+		stream:      m.stream,
+		config:      m.config,
+		store:       m.store,
+		captures:    m.captures,
+		inviteReq:   invite,
+		answer:      answer,
+		egressRules: d.EgressRules,
+		// Requests the failed instance sent in the dialog aren't known; at
+		// most one a second, so the CSeq starts above them
+		localCSeq: uint32(time.Since(d.CreatedAt).Seconds()) + 1000,
+	}, nil
+}
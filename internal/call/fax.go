@@ -0,0 +1,141 @@
+package call
+
+import (
+	"context"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/audio"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/pkg/agentproto"
+	"github.com/shiv6146/blayzen-sip/pkg/sdp"
+)
+
+// CNG (T.30 calling tone): 1100 Hz, on for 0.5s (±15%) every 3.5s
+const (
+	cngFrequency = 1100
+	cngMinOn     = 425 * time.Millisecond
+	cngMaxOn     = 575*time.Millisecond + playoutInterval // One frame of slack at each end
+
+	// Share of a frame's energy that must be at 1100 Hz for it to count as
+	// tone, and the mean absolute level below which it is silence
+	cngMinPurity = 0.7
+	cngMinLevel  = 100
+)
+
+// faxDivertTimeout bounds the REFER transferring a fax call to its target
+const faxDivertTimeout = 10 * time.Second
+
+// cngDetector spots the calling tone of a fax machine in caller audio
+type cngDetector struct {
+	on time.Duration // Current run of tone
+}
+
+// Process adds a frame of PCMU caller audio and reports whether it ended a
+// burst of tone as long as a CNG
+func (d *cngDetector) Process(payload []byte) bool {
+	if isCNGTone(payload) {
+		d.on += time.Duration(len(payload)) * time.Second / 8000
+		return false
+	}
+
+	burst := d.on >= cngMinOn && d.on <= cngMaxOn
+	d.on = 0
+	return burst
+}
+
+// isCNGTone reports whether a frame of PCMU audio is an 1100 Hz tone: loud
+// enough, with almost all of its energy in the 1100 Hz Goertzel bin
+func isCNGTone(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	samples := audio.MulawToSamples(payload)
+
+	var energy, level float64
+	for _, sample := range samples {
+		x := float64(sample)
+		energy += x * x
+		level += math.Abs(x)
+	}
+	n := float64(len(samples))
+	if level/n < cngMinLevel {
+		return false
+	}
+
+	coeff := 2 * math.Cos(2*math.Pi*cngFrequency/8000)
+	var s1, s2 float64
+	for _, sample := range samples {
+		s0 := float64(sample) + coeff*s1 - s2
+		s2, s1 = s1, s0
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+
+	// A pure tone at the bin frequency has power n²A²/4 and energy nA²/2
+	return 2*power/(n*energy) >= cngMinPurity
+}
+
+// OffersT38 reports whether an SDP offer switches the call to T.38 fax relay
+func OffersT38(body []byte) bool {
+	desc, err := sdp.Parse(body)
+	if err != nil {
+		return false
+	}
+	for _, m := range desc.Media {
+		if m.Type == "image" && m.Port != 0 && slices.Contains(m.Formats, "t38") {
+			return true
+		}
+	}
+	return false
+}
+
+// detectFax looks for a fax machine's calling tone in a frame of caller
+// audio, on routes with a fax policy
+func (s *Session) detectFax(payload []byte) {
+	if s.cng == nil || s.faxDetected.Load() {
+		return
+	}
+	if s.cng.Process(payload) {
+		go s.HandleFax(agentproto.FaxSourceCNG)
+	}
+}
+
+// HandleFax applies the route's fax policy to a call found to be a fax
+// machine, once per call: hangs up, transfers the call to the route's fax
+// target, or tells the agent. Diverted calls the caller's side refuses to
+// transfer are hung up.
+func (s *Session) HandleFax(source string) {
+	policy := s.Route.FaxPolicy
+	if policy == "" || policy == models.FaxPolicyIgnore || !s.faxDetected.CompareAndSwap(false, true) {
+		return
+	}
+	s.log.Info("Fax detected", "source", source, "policy", policy)
+	metrics.FaxCalls.With(source, policy).Inc()
+
+	switch policy {
+	case models.FaxPolicyReject:
+		s.hangupFax(models.HangupCauseFaxDetected)
+	case models.FaxPolicyDivert:
+		ctx, cancel := context.WithTimeout(context.Background(), faxDivertTimeout)
+		defer cancel()
+		if err := s.Refer(ctx, *s.Route.FaxTarget); err != nil {
+			s.log.Error("Failed to divert fax call", "target", *s.Route.FaxTarget, "error", err)
+			s.hangupFax(models.HangupCauseFaxDetected)
+			return
+		}
+		s.hangupFax(models.HangupCauseFaxDiverted)
+	case models.FaxPolicyNotify:
+		if err := s.sendWSMessage(s.agent.Fax(s, source)); err != nil {
+			s.log.Warn("Failed to notify agent of fax", "error", err)
+		}
+	}
+}
+
+// hangupFax ends a fax call from our side
+func (s *Session) hangupFax(cause string) {
+	if s.hangup != nil {
+		s.hangup(cause)
+	}
+}
@@ -0,0 +1,142 @@
+package call
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Jitter buffer bounds, in 20ms frames
+const (
+	jitterMinDepth = 2
+	jitterMaxDepth = 10
+)
+
+// jitterBuffer reorders inbound RTP packets and absorbs network jitter before
+// audio is forwarded to the agent. Its target depth adapts to the measured
+// interarrival jitter (RFC 3550, section 6.4.1).
+type jitterBuffer struct {
+	mu      sync.Mutex
+	packets map[uint16][]byte
+	nextSeq uint16
+	started bool
+	playing bool
+	depth   int
+
+	// Packets skipped as lost, and dropped for arriving after their slot
+	lost int64
+	late int64
+
+	// Interarrival jitter estimate, in timestamp units (samples)
+	jitter        float64
+	epoch         time.Time
+	lastArrival   int64
+	lastTimestamp uint32
+}
+
+// newJitterBuffer creates an empty jitter buffer
+func newJitterBuffer() *jitterBuffer {
+	return &jitterBuffer{
+		packets: make(map[uint16][]byte),
+		depth:   jitterMinDepth,
+	}
+}
+
+// Push adds a received packet. The payload is copied.
+func (jb *jitterBuffer) Push(seq uint16, timestamp uint32, payload []byte, arrival time.Time) {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+
+	arrivalSamples := int64(arrival.Sub(jb.epoch) * 8000 / time.Second)
+
+	if !jb.started {
+		jb.started = true
+		jb.nextSeq = seq
+		jb.epoch = arrival
+		arrivalSamples = 0
+	} else {
+		// Update the jitter estimate and adapt the target depth
+		d := float64(arrivalSamples-jb.lastArrival) - float64(int32(timestamp-jb.lastTimestamp))
+		jb.jitter += (math.Abs(d) - jb.jitter) / 16
+		jb.depth = min(max(1+int(math.Ceil(2*jb.jitter/160)), jitterMinDepth), jitterMaxDepth)
+
+		// Too late, its slot has already been played out
+		if int16(seq-jb.nextSeq) < 0 {
+			jb.late++
+			return
+		}
+	}
+	jb.lastArrival = arrivalSamples
+	jb.lastTimestamp = timestamp
+
+	// Bound memory if the reader stalls
+	if len(jb.packets) >= jitterMaxDepth*4 {
+		return
+	}
+
+	jb.packets[seq] = append([]byte(nil), payload...)
+}
+
+// Pop returns the next payload in sequence order once the buffer has reached
+// its target depth. Lost packets are skipped when the buffer is full enough.
+func (jb *jitterBuffer) Pop() ([]byte, bool) {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+
+	if len(jb.packets) == 0 {
+		// Buffer ran dry, rebuffer before playing again
+		jb.playing = false
+		return nil, false
+	}
+
+	if !jb.playing {
+		if len(jb.packets) < jb.depth {
+			return nil, false
+		}
+		jb.playing = true
+	}
+
+	if payload, ok := jb.packets[jb.nextSeq]; ok {
+		delete(jb.packets, jb.nextSeq)
+		jb.nextSeq++
+		return payload, true
+	}
+
+	// Next packet is missing; give it time unless the buffer is full
+	if len(jb.packets) < jb.depth {
+		return nil, false
+	}
+
+	// Treat it as lost and jump to the oldest buffered packet
+	oldest := jb.nextSeq
+	first := true
+	for seq := range jb.packets {
+		if first || int16(seq-oldest) < 0 {
+			oldest = seq
+			first = false
+		}
+	}
+
+	payload := jb.packets[oldest]
+	delete(jb.packets, oldest)
+	jb.lost += int64(oldest - jb.nextSeq)
+	jb.nextSeq = oldest + 1
+	return payload, true
+}
+
+// Stats returns the packets lost and late so far, and the current jitter
+// estimate in milliseconds
+func (jb *jitterBuffer) Stats() (lost, late int64, jitterMs float64) {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+
+	return jb.lost, jb.late, jb.jitter / 8
+}
+
+// Backlog returns how many packets are buffered beyond the target depth
+func (jb *jitterBuffer) Backlog() int {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+
+	return len(jb.packets) - jb.depth
+}
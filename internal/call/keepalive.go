@@ -0,0 +1,145 @@
+package call
+
+import (
+	"context"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/gorilla/websocket"
+	"github.com/shiv6146/blayzen-sip/internal/eventstream"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// keepaliveTimeout bounds each in-dialog keepalive request
+const keepaliveTimeout = 32 * time.Second
+
+// pingAgent pings the agent every WSPingInterval until the call ends. A
+// failed ping closes the connection, which ends the read loop.
+func (s *Session) pingAgent(conn *websocket.Conn) {
+	defer s.running(loopPingAgent)()
+
+	interval := s.config.WSPingInterval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.config.WSWriteTimeout)); err != nil {
+			s.log.Warn("Agent ping failed", "error", err)
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// keepDialogAlive sends the caller's side an in-dialog OPTIONS or UPDATE
+// every SIPKeepaliveInterval until the call ends, so NAT bindings and the
+// carrier's session state don't expire on long quiet calls. The call is hung
+// up when the caller's side no longer knows it (481) or stops answering;
+// other rejections, e.g. 405 from peers not supporting the method, still
+// refresh the path and are ignored.
+func (s *Session) keepDialogAlive() {
+	defer s.running(loopKeepalive)()
+
+	interval := s.config.SIPKeepaliveInterval
+	if interval <= 0 || s.inviteReq == nil {
+		return
+	}
+	method := sip.RequestMethod(s.config.SIPKeepaliveMethod)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		req, err := s.newDialogRequest(method)
+		if err != nil {
+			s.log.Warn("Failed to build keepalive", "error", err)
+			continue
+		}
+		if method == sip.UPDATE {
+			// UPDATE refreshes the dialog's target, so it carries our Contact
+			if contact := s.answer.Contact(); contact != nil {
+				req.AppendHeader(contact.Clone())
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), keepaliveTimeout)
+		res, err := s.doDialogRequest(ctx, req)
+		cancel()
+		if s.isClosed() {
+			return
+		}
+
+		switch {
+		case err != nil:
+			s.log.Warn("Keepalive unanswered, hanging up", "method", method, "error", err)
+		case res.StatusCode == sip.StatusCallTransactionDoesNotExists || res.StatusCode == sip.StatusRequestTimeout:
+			s.log.Warn("Keepalive rejected, hanging up", "method", method, "status", res.StatusCode)
+		default:
+			s.log.Debug("Keepalive answered", "method", method, "status", res.StatusCode)
+			continue
+		}
+		if s.hangup != nil {
+			s.hangup(models.HangupCauseSessionLost)
+		}
+		return
+	}
+}
+
+// extendAgentDeadline gives the agent another WSReadTimeout to send a
+// message or pong before it is considered dead
+func (s *Session) extendAgentDeadline(conn *websocket.Conn) {
+	if s.config.WSReadTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(s.config.WSReadTimeout))
+	}
+}
+
+// isClosed reports whether the session has been closed
+func (s *Session) isClosed() bool {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	return s.closed
+}
+
+// agentLost handles an agent connection that dropped or stopped responding,
+// unless the call is already ending. The agent is reconnected when the
+// reconnect policy allows, otherwise the call is hung up.
+func (s *Session) agentLost(err error) {
+	if s.isClosed() || s.hangup == nil {
+		return
+	}
+
+	s.log.Warn("Agent connection lost", "error", err)
+	s.trace(eventstream.EventAgent, "", "connection lost: "+err.Error())
+
+	// A clean close is the agent ending the call
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) && s.reconnectAgent() {
+		return
+	}
+	if !s.isClosed() {
+		s.hangup(models.HangupCauseAgentLost)
+	}
+}
+
+// setWriteDeadline bounds the next write to the agent so a stalled agent
+// can't block media. The caller holds s.wsMu.
+func (s *Session) setWriteDeadline() {
+	if s.config.WSWriteTimeout > 0 {
+		_ = s.wsConn.SetWriteDeadline(time.Now().Add(s.config.WSWriteTimeout))
+	}
+}
@@ -0,0 +1,104 @@
+package call
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/storage"
+)
+
+// languageVariants returns the tags tried for a language's assets, most
+// specific first: "zh-Hant-TW", "zh-Hant", then "zh"
+func languageVariants(language string) []string {
+	parts := strings.Split(language, "-")
+	variants := make([]string, 0, len(parts))
+	for i := len(parts); i > 0; i-- {
+		variants = append(variants, strings.Join(parts[:i], "-"))
+	}
+	return variants
+}
+
+// localizedPath returns the version of an audio file for a language: the
+// file of the same name in a subdirectory named after the language (or a
+// less specific tag) next to it, e.g. prompts/es/silence.wav for
+// prompts/silence.wav. path itself is returned when there is none.
+func localizedPath(path, language string) string {
+	if language == "" {
+		return path
+	}
+	dir, name := filepath.Split(path)
+	for _, tag := range languageVariants(language) {
+		candidate := filepath.Join(dir, tag, name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return path
+}
+
+// promptFiles reads the audio files played on calls: from a directory on
+// local disk, or from the prompts in blob storage when dir is empty
+type promptFiles struct {
+	dir   string
+	blobs storage.Store
+}
+
+// promptFetchTimeout bounds reading a prompt from blob storage
+const promptFetchTimeout = 15 * time.Second
+
+// read returns the file named name in a language: its version in a
+// subdirectory (or prefix) named after the language when there is one, as
+// localizedPath finds it, else the file itself. localized reports whether
+// a version for the language was found.
+func (p promptFiles) read(name, language string) (data []byte, localized bool, err error) {
+	if p.dir != "" {
+		file := filepath.Join(p.dir, name)
+		localizedFile := localizedPath(file, language)
+		data, err := os.ReadFile(localizedFile)
+		return data, localizedFile != file, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), promptFetchTimeout)
+	defer cancel()
+	if language != "" {
+		for _, tag := range languageVariants(language) {
+			data, err := p.blobs.Get(ctx, path.Join(storage.PrefixPrompts, tag, name))
+			if err == nil {
+				return data, true, nil
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, false, err
+			}
+		}
+	}
+	data, err = p.blobs.Get(ctx, path.Join(storage.PrefixPrompts, name))
+	return data, false, err
+}
+
+// silencePromptFor returns the silence prompt in a language, loaded once,
+// or the default prompt when the language has none
+func (m *Manager) silencePromptFor(language *string) []byte {
+	if language == nil || m.silenceName == "" {
+		return m.silencePrompt
+	}
+
+	m.promptsMu.Lock()
+	defer m.promptsMu.Unlock()
+	if prompt, ok := m.silencePrompts[*language]; ok {
+		return prompt
+	}
+	prompt, localized, err := m.loadSilencePrompt(*language)
+	if err != nil {
+		logger.Warn("Using default silence prompt", "language", *language, "error", err)
+	}
+	if err != nil || !localized {
+		prompt = m.silencePrompt
+	}
+	m.silencePrompts[*language] = prompt
+	return prompt
+}
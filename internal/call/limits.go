@@ -0,0 +1,159 @@
+package call
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Concurrent call limits of accounts and routes, and bandwidth limits of
+// accounts. With Valkey, calls hold slots shared by every instance,
+// refreshed with the active-call snapshots so the slots of calls lost with
+// their instance free themselves. Without Valkey, or while it's unreachable,
+// each instance counts its own calls.
+
+// callSlotTTL is how long a call's slot outlives its last refresh
+const callSlotTTL = liveCallsTTL
+
+// callLimit is a concurrent call limit applying to a call
+type callLimit struct {
+	kind    string // metrics.LimitAccount, LimitRoute or LimitBandwidth
+	scope   string // Its slots in Valkey
+	limit   int
+	matches func(*models.Route) bool // Whether calls to a route count against it
+}
+
+// callLimits returns the concurrent call limits of the route and its account
+// for a call needing kbps each way. The account's bandwidth limit is held as
+// the number of such calls fitting in it.
+func (m *Manager) callLimits(ctx context.Context, route *models.Route, kbps int) []callLimit {
+	var limits []callLimit
+	account := m.limitsAccount(ctx, route.AccountID)
+	if account != nil && account.MaxConcurrentCalls != nil {
+		limits = append(limits, callLimit{
+			kind:    metrics.LimitAccount,
+			scope:   "account:" + route.AccountID,
+			limit:   *account.MaxConcurrentCalls,
+			matches: func(r *models.Route) bool { return r.AccountID == route.AccountID },
+		})
+	}
+	if account != nil && account.MaxBandwidthKbps != nil {
+		limits = append(limits, callLimit{
+			kind:    metrics.LimitBandwidth,
+			scope:   "bandwidth:" + route.AccountID,
+			limit:   *account.MaxBandwidthKbps / kbps,
+			matches: func(r *models.Route) bool { return r.AccountID == route.AccountID },
+		})
+	}
+	if route.MaxConcurrentCalls != nil && route.ID != "" {
+		limits = append(limits, callLimit{
+			kind:    metrics.LimitRoute,
+			scope:   "route:" + route.ID,
+			limit:   *route.MaxConcurrentCalls,
+			matches: func(r *models.Route) bool { return r.ID == route.ID },
+		})
+	}
+	return limits
+}
+
+// limitsAccount returns the account whose limits apply to a call, or nil
+// when it can't be read
+func (m *Manager) limitsAccount(ctx context.Context, accountID string) *models.Account {
+	if accountID == "" {
+		return nil
+	}
+	account, err := m.store.GetAccount(ctx, accountID)
+	if err != nil {
+		logger.Warn("Failed to read account call limits", "account_id", accountID, "error", err)
+		return nil
+	}
+	return account
+}
+
+// admitLimits admits a call needing kbps each way under its account's and
+// route's concurrent call limits and its account's bandwidth limit, as far
+// as Valkey can: it returns the slots the call took there, and the limits
+// left to count against this instance's calls, those without Valkey or while
+// it's unreachable. Called without m.mu, as it reads the account and claims
+// slots in Valkey.
+func (m *Manager) admitLimits(ctx context.Context, callID string, route *models.Route, kbps int) (slots []string, local []callLimit, err error) {
+	for _, l := range m.callLimits(ctx, route, kbps) {
+		ok, shared := m.claimSlot(ctx, callID, l)
+		switch {
+		case !shared:
+			local = append(local, l)
+		case !ok:
+			m.releaseSlots(ctx, callID, slots)
+			return nil, nil, m.reject(callID, route, l.kind, l.reason(kbps))
+		default:
+			slots = append(slots, l.scope)
+		}
+	}
+	return slots, local, nil
+}
+
+// claimSlot takes one of a limit's slots for a call in Valkey. The limit
+// isn't shared, and is left for localCalls to count, without Valkey or when
+// it can't be reached.
+func (m *Manager) claimSlot(ctx context.Context, callID string, l callLimit) (ok, shared bool) {
+	if m.cache == nil {
+		return true, false
+	}
+	ok, err := m.cache.ClaimCallSlot(ctx, l.scope, callID, l.limit, callSlotTTL)
+	if err != nil {
+		logger.Warn("Failed to claim call slot, counting this instance's calls", "scope", l.scope, "error", err)
+		return true, false
+	}
+	return ok, true
+}
+
+// localCalls counts this instance's calls, admitted ones included, under a
+// limit. Callers must hold m.mu.
+func (m *Manager) localCalls(l callLimit) int {
+	active := 0
+	for _, s := range m.sessions {
+		if l.matches(s.Route) {
+			active++
+		}
+	}
+	for _, s := range m.admitting {
+		if l.matches(s.Route) {
+			active++
+		}
+	}
+	return active
+}
+
+// reason returns why a call needing kbps each way is refused under the limit
+func (l callLimit) reason(kbps int) string {
+	if l.kind == metrics.LimitBandwidth {
+		return fmt.Sprintf("bandwidth limit reached: %d calls of %d kbps", l.limit, kbps)
+	}
+	return fmt.Sprintf("%s concurrent call limit %d reached", l.kind, l.limit)
+}
+
+// releaseSlots frees the slots a call holds in Valkey
+func (m *Manager) releaseSlots(ctx context.Context, callID string, slots []string) {
+	for _, scope := range slots {
+		if err := m.cache.ReleaseCallSlot(ctx, scope, callID); err != nil {
+			logger.Warn("Failed to release call slot", "call_id", callID, "scope", scope, "error", err)
+		}
+	}
+}
+
+// refreshSlots holds the slots of this instance's calls for another
+// callSlotTTL
+func (m *Manager) refreshSlots(ctx context.Context) error {
+	m.mu.RLock()
+	slots := make(map[string][]string)
+	for _, s := range m.sessions {
+		for _, scope := range s.slots {
+			slots[scope] = append(slots[scope], s.CallID)
+		}
+	}
+	m.mu.RUnlock()
+
+	return m.cache.RefreshCallSlots(ctx, slots, callSlotTTL)
+}
@@ -0,0 +1,163 @@
+package call
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/privacy"
+)
+
+// Active-call snapshots shared through Valkey, so any instance can list
+// every instance's calls. Snapshots expire when an instance stops refreshing
+// them.
+const (
+	liveCallsInterval = 5 * time.Second
+	liveCallsTTL      = 3 * liveCallsInterval
+)
+
+// ActiveCalls returns the live state of an account's calls in progress,
+// oldest first: this instance's as they are now, and with Valkey configured
+// other instances' as of their last snapshot. Only this instance's calls are
+// returned while Valkey is unreachable.
+func (m *Manager) ActiveCalls(ctx context.Context, accountID string) []*models.ActiveCall {
+	now := time.Now()
+	calls := m.localActiveCalls(accountID, now)
+
+	if m.cache != nil {
+		snapshots, err := m.cache.GetInstanceCalls(ctx)
+		if err != nil {
+			logger.Warn("Failed to read other instances' active calls", "error", err)
+		}
+		for instance, payload := range snapshots {
+			if instance == m.config.InstanceID {
+				continue
+			}
+			var remote []*models.ActiveCall
+			if err := json.Unmarshal(payload, &remote); err != nil {
+				logger.Warn("Ignoring malformed active-call snapshot", "instance", instance, "error", err)
+				continue
+			}
+			for _, a := range remote {
+				if a.AccountID == accountID {
+					a.DurationSeconds = int(now.Sub(a.StartedAt).Seconds())
+					calls = append(calls, a)
+				}
+			}
+		}
+	}
+
+	slices.SortFunc(calls, func(a, b *models.ActiveCall) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return calls
+}
+
+// localActiveCalls returns the live state of this instance's calls, for one
+// account or, with accountID empty, all of them
+func (m *Manager) localActiveCalls(accountID string, now time.Time) []*models.ActiveCall {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	calls := make([]*models.ActiveCall, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		if accountID == "" || s.Route.AccountID == accountID {
+			calls = append(calls, s.activeCall(m.config.InstanceID, now))
+		}
+	}
+	return calls
+}
+
+// PublishActiveCalls shares this instance's calls through Valkey, when
+// configured, and holds their concurrent call limit slots and registry
+// entries until ctx is cancelled, then withdraws the calls
+func (m *Manager) PublishActiveCalls(ctx context.Context) {
+	if m.cache == nil {
+		return
+	}
+
+	ticker := time.NewTicker(liveCallsInterval)
+	defer ticker.Stop()
+
+	for {
+		payload, err := json.Marshal(m.localActiveCalls("", time.Now()))
+		if err == nil {
+			err = m.cache.SetInstanceCalls(ctx, m.config.InstanceID, payload, liveCallsTTL)
+		}
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to publish active calls", "error", err)
+		}
+		if err := m.refreshSlots(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to refresh call slots", "error", err)
+		}
+		if err := m.refreshCallOwners(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to refresh call registry", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_ = m.cache.RemoveInstanceCalls(ctx, m.config.InstanceID)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// activeCall describes the session's live state
+func (s *Session) activeCall(instance string, now time.Time) *models.ActiveCall {
+	a := &models.ActiveCall{
+		ID:              s.callLogID,
+		CallID:          s.CallID,
+		AccountID:       s.Route.AccountID,
+		Instance:        instance,
+		Status:          models.CallStatusInitiated,
+		FromUser:        privacy.CDRNumber(s.FromUser),
+		ToUser:          privacy.CDRNumber(s.ToUser),
+		RouteID:         s.Route.ID,
+		RouteName:       s.Route.Name,
+		ConversationID:  s.conversationID,
+		RTPRemoteAddr:   s.mediaRemoteAddr(),
+		StartedAt:       s.createdAt,
+		DurationSeconds: int(now.Sub(s.createdAt).Seconds()),
+		PacketsIn:       s.packetsIn.Load(),
+		PacketsOut:      s.packetsOut.Load(),
+		BytesIn:         s.bytesIn.Load(),
+		BytesOut:        s.bytesOut.Load(),
+		BitrateKbps:     s.bitrate,
+		ChunksSent:      s.chunksSent.Load(),
+		ChunksReceived:  s.chunksReceived.Load(),
+		LastRTPAt:       unixNanoTime(s.lastRTP.Load()),
+		LastAgentAt:     unixNanoTime(s.lastAgentMsg.Load()),
+		LastActivityAt:  unixNanoTime(s.lastActivity.Load()),
+		UpdatedAt:       now,
+	}
+
+	if s.trunk != nil {
+		a.TrunkID = s.trunk.ID
+	}
+	if answered := unixNanoTime(s.mediaStarted.Load()); answered != nil {
+		a.Status = models.CallStatusAnswered
+		a.AnsweredAt = answered
+	} else if s.ringing.Load() {
+		a.Status = models.CallStatusRinging
+	}
+
+	s.wsMu.Lock()
+	a.AgentURL = s.WebSocketURL
+	a.AgentConnected = s.wsConn != nil
+	s.wsMu.Unlock()
+	return a
+}
+
+// unixNanoTime converts a unix nanosecond timestamp, 0 when unset
+func unixNanoTime(nanos int64) *time.Time {
+	if nanos == 0 {
+		return nil
+	}
+	t := time.Unix(0, nanos)
+	return &t
+}
@@ -0,0 +1,104 @@
+package call
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// agentOrder returns the agent URLs to try for a new call on the route: the
+// replicas of its agent regions, region by region as ranked, then its own
+// replicas, each pool in the order chosen by the route's load-balancing
+// strategy, then its fallbacks. The ranking is returned for routes with
+// agent regions. Callers must hold m.mu.
+func (m *Manager) agentOrder(route *models.Route) ([]string, *models.RegionDecision) {
+	var order []string
+	var decision *models.RegionDecision
+	if len(route.AgentRegions) > 0 {
+		decision = m.rankRegions(route)
+		for _, ranked := range decision.Regions {
+			for _, pool := range route.AgentRegions {
+				if pool.Region == ranked.Region {
+					order = append(order, m.balance(route, route.ID+"/"+pool.Region, pool.URLs)...)
+				}
+			}
+		}
+	}
+
+	order = append(order, m.balance(route, route.ID, route.AgentPool())...)
+	return append(order, route.FallbackWebSocketURLs...), decision
+}
+
+// balance orders a pool of agent replicas by the route's load-balancing
+// strategy, keeping round-robin turns under key. Callers must hold m.mu.
+func (m *Manager) balance(route *models.Route, key string, urls []string) []string {
+	pool := append([]string(nil), urls...)
+	if len(pool) < 2 {
+		return pool
+	}
+
+	switch route.AgentLBStrategy {
+	case models.AgentLBRandom:
+		rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	case models.AgentLBLeastActive:
+		active := m.activeAgentCalls()
+		sort.SliceStable(pool, func(i, j int) bool { return active[pool[i]] < active[pool[j]] })
+
+	default:
+		// Round robin, rotating the whole pool so failover also spreads
+		n := int(m.rrCounters[key] % uint64(len(pool)))
+		m.rrCounters[key]++
+		pool = append(pool[n:], pool[:n]...)
+	}
+	return pool
+}
+
+// splitTarget assigns a new call on the route one of its agent splits by
+// the route's split strategy, or returns nil for routes without splits.
+// Callers are hashed per route, so each route splits them independently;
+// anonymous ones are split by Call-ID.
+func splitTarget(route *models.Route, callID, caller string) *models.AgentSplit {
+	total := route.SplitWeight()
+	if total == 0 {
+		return nil
+	}
+	if route.SplitStrategy != models.SplitCaller {
+		return route.SplitTarget(rand.Intn(total))
+	}
+
+	key := caller
+	if key == "" || strings.EqualFold(key, "anonymous") {
+		key = callID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(route.ID + "/" + key))
+	return route.SplitTarget(int(h.Sum32() % uint32(total)))
+}
+
+// withSplit puts a call's split target ahead of the route's other agents,
+// which remain its failover
+func withSplit(split *models.AgentSplit, urls []string) []string {
+	order := []string{split.WebSocketURL}
+	for _, u := range urls {
+		if u != split.WebSocketURL {
+			order = append(order, u)
+		}
+	}
+	return order
+}
+
+// activeAgentCalls counts the active calls balanced onto each agent URL.
+// Callers must hold m.mu.
+func (m *Manager) activeAgentCalls() map[string]int {
+	active := make(map[string]int)
+	for _, s := range m.sessions {
+		if len(s.agentURLs) > 0 {
+			active[s.agentURLs[0]]++
+		}
+	}
+	return active
+}
@@ -3,92 +3,309 @@ package call
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
+	"github.com/google/uuid"
+	"github.com/shiv6146/blayzen-sip/internal/accounting"
 	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/eventstream"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
 	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/privacy"
+	"github.com/shiv6146/blayzen-sip/internal/routing"
+	"github.com/shiv6146/blayzen-sip/internal/storage"
 	"github.com/shiv6146/blayzen-sip/internal/store"
+	"github.com/shiv6146/blayzen-sip/internal/webhook"
 )
 
+var logger = logging.Component("call")
+
+// callLogger returns a logger tagged with a call and its account
+func callLogger(callID, accountID string) *slog.Logger {
+	return logger.With("call_id", callID, "account_id", accountID)
+}
+
 // Manager manages active call sessions
 type Manager struct {
 	config   *config.Config
+	defaults *routing.Defaults
 	store    *store.PostgresStore
 	cache    *store.Cache
+	client   *sipgo.Client
+	resolver *net.Resolver
+	blobs    storage.Store
+	events   *webhook.Dispatcher
+	stream   *eventstream.Broker
+	acct     *accounting.Client
 	sessions map[string]*Session
 	mu       sync.RWMutex
+
+	// Sessions of calls admitted but not yet registered, which count against
+	// the limits like those registered
+	admitting map[string]*Session
+
+	// Per-route round-robin counters for agent load balancing
+	rrCounters map[string]uint64
+
+	// Health of the agent regions of routes with regional pools
+	regions *regionHealth
+
+	// Played to callers before a silence hangup: the file (empty for the
+	// default beeps) and where it's read from, the default prompt, and those
+	// of route languages, by language
+	silenceName    string
+	silenceFiles   promptFiles
+	silencePrompt  []byte
+	promptsMu      sync.Mutex
+	silencePrompts map[string][]byte
+
+	// Routes' ringback audio
+	ringbacks *ringbackCache
+
+	// Stores captured SIP messages, nil when capture is disabled
+	captures *captureQueue
+
+	// Supervisor legs reserved through the API, by token
+	supervisionMu sync.Mutex
+	supervisions  map[string]pendingSupervision
 }
 
-// NewManager creates a new call manager
-func NewManager(cfg *config.Config, store *store.PostgresStore, cache *store.Cache) *Manager {
-	return &Manager{
-		config:   cfg,
-		store:    store,
-		cache:    cache,
-		sessions: make(map[string]*Session),
+// NewManager creates a new call manager. Agents are dialed with the routing
+// defaults in effect and their hostnames looked up with resolver; acct, if
+// not nil, receives accounting records of calls.
+func NewManager(cfg *config.Config, defaults *routing.Defaults, store *store.PostgresStore, cache *store.Cache, blobs storage.Store, client *sipgo.Client, resolver *net.Resolver, acct *accounting.Client) *Manager {
+	m := &Manager{
+		config:       cfg,
+		defaults:     defaults,
+		store:        store,
+		cache:        cache,
+		client:       client,
+		resolver:     resolver,
+		blobs:        blobs,
+		events:       webhook.NewFromConfig(cfg, store),
+		stream:       eventstream.New(store, cache),
+		acct:         acct,
+		sessions:     make(map[string]*Session),
+		admitting:    make(map[string]*Session),
+		rrCounters:   make(map[string]uint64),
+		regions:      newRegionHealth(),
+		supervisions: make(map[string]pendingSupervision),
+		ringbacks:    newRingbackCache(promptFiles{dir: cfg.RingbackDir, blobs: blobs}),
+		captures:     newCaptureQueue(cfg, store),
 	}
+	m.silencePrompts = make(map[string][]byte)
+
+	// A local file takes precedence over a prompt in storage
+	m.silenceName, m.silenceFiles = cfg.SilencePrompt, promptFiles{blobs: blobs}
+	if cfg.SilencePromptFile != "" {
+		m.silenceName = filepath.Base(cfg.SilencePromptFile)
+		m.silenceFiles = promptFiles{dir: filepath.Dir(cfg.SilencePromptFile)}
+	}
+
+	if cfg.SilenceTimeout > 0 {
+		prompt, _, err := m.loadSilencePrompt("")
+		if err != nil {
+			logger.Warn("Using default beeps for silence prompt", "error", err)
+			prompt = beepPrompt()
+		}
+		m.silencePrompt = prompt
+	}
+
+	return m
 }
 
 // CreateSession creates a new call session
-func (m *Manager) CreateSession(ctx context.Context, callID string, req *sip.Request, route *models.Route) (*Session, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (m *Manager) CreateSession(ctx context.Context, callID string, req *sip.Request, route *models.Route, trunk *models.Trunk) (*Session, error) {
+	// Media over the route's or trunk's bitrate limit can't be carried
+	kbps := MediaBitrateKbps(offerIPv6(string(req.Body())))
+	if reason := bitrateExceeded(route, trunk, kbps); reason != "" {
+		callLogger(callID, route.AccountID).Warn("Rejecting call", "reason", reason)
+		return nil, fmt.Errorf("%w: %s", ErrBitrateExceeded, reason)
+	}
 
 	// Extract call details
 	toURI := req.To().Address
 	fromURI := req.From().Address
 
+	session, err := m.admit(ctx, callID, fromURI.User, route, kbps)
+	if err != nil {
+		return nil, err
+	}
+	session.FromURI = fromURI.String()
+	session.ToURI = toURI.String()
+	session.FromUser = fromURI.User
+	session.ToUser = toURI.User
+	session.RemoteSDP = string(req.Body())
+	session.eventPT.Store(offeredEventPT(session.RemoteSDP))
+	session.inviteReq = req
+	session.trunk = trunk
+
+	// Allocate RTP ports, which may also be exhausted
+	if err := session.allocateRTPPorts(); err != nil {
+		m.mu.Lock()
+		preempted := m.preempt(callID, route.CallPriority, err.Error())
+		m.mu.Unlock()
+		if !preempted {
+			m.unadmit(ctx, session)
+			return nil, m.reject(callID, route, metrics.LimitServer, err.Error())
+		}
+		if err := session.allocateRTPPorts(); err != nil {
+			m.unadmit(ctx, session)
+			return nil, m.reject(callID, route, metrics.LimitServer, err.Error())
+		}
+	}
+
+	m.register(ctx, session)
+	session.traceSIP(&models.SIPMessage{Direction: models.SIPMessageInbound, Method: string(req.Method), RemoteAddr: req.Source()})
+	return session, nil
+}
+
+// CreateWebRTCSession creates a session for a browser call straight to a
+// route, with media carried by the browser's peer connection
+func (m *Manager) CreateWebRTCSession(ctx context.Context, callID, fromUser string, route *models.Route, media MediaTransport) (*Session, error) {
+	session, err := m.admit(ctx, callID, fromUser, route, MediaBitrateKbps(false))
+	if err != nil {
+		return nil, err
+	}
+	session.FromURI = "webrtc:" + fromUser
+	session.ToURI = "webrtc:" + route.Name
+	session.FromUser = fromUser
+	session.media = media
+
+	m.register(ctx, session)
+	return session, nil
+}
+
+// newSession builds a session for an admitted call from caller to a route
+func (m *Manager) newSession(callID, caller string, route *models.Route) *Session {
+	agentURLs, decision := m.agentOrder(route)
+	split := splitTarget(route, callID, caller)
+	if split != nil {
+		agentURLs = withSplit(split, agentURLs)
+		metrics.SplitCalls.With(route.ID, split.Name).Inc()
+	}
+
 	session := &Session{
 		CallID:       callID,
-		FromURI:      fromURI.String(),
-		ToURI:        toURI.String(),
-		FromUser:     fromURI.User,
-		ToUser:       toURI.User,
+		StreamSID:    uuid.New().String(),
 		Route:        route,
-		WebSocketURL: route.WebSocketURL,
+		WebSocketURL: agentURLs[0],
+		client:       m.client,
+		resolver:     m.resolver,
+		agentAudio:   newAgentAudio(route.EffectiveAudioFormat()),
+		agent:        newAgentCodec(route.AgentProtocol),
+		blobs:        m.blobs,
+		events:       m.events,
+		stream:       m.stream,
+		acct:         m.acct,
 		config:       m.config,
+		defaults:     m.defaults,
 		store:        m.store,
+		captures:     m.captures,
+		stopChan:     make(chan struct{}),
+		timerRefresh: make(chan struct{}, 1),
+		answerNow:    make(chan struct{}),
+		txSeq:        uint16(rand.Uint32()),
+		txTimestamp:  rand.Uint32(),
+		txSSRC:       rand.Uint32(),
+		jitter:       newJitterBuffer(),
+		rtpTrace:     newRTPTracer(m.config.SIPTraceEnabled, m.config.SIPTraceMaxRTPPackets),
+		createdAt:    time.Now(),
 	}
-
-	// Allocate RTP ports
-	if err := session.allocateRTPPorts(); err != nil {
-		return nil, err
+	session.agentURLs = agentURLs
+	session.eventPT.Store(-1)
+	session.regions, session.regionDecision = m.regions, decision
+	if split != nil {
+		session.splitTarget = split.Name
+	}
+	session.log = callLogger(callID, route.AccountID)
+	if m.config.SilenceTimeout > 0 {
+		session.silencePrompt = m.silencePromptFor(route.Language)
 	}
+	session.ringbacks = m.ringbacks
+	session.hangup = func(cause string) { m.hangupSession(callID, cause) }
+	if route.FaxPolicy != "" && route.FaxPolicy != models.FaxPolicyIgnore {
+		session.cng = &cngDetector{}
+	}
+	return session
+}
+
+// register logs a new session's call and starts tracking it in place of
+// its admission. Called without m.mu, as it writes the call log.
+func (m *Manager) register(ctx context.Context, session *Session) {
+	route := session.Route
+	callID := session.CallID
+	session.conversationID = m.conversationID(ctx, session)
 
 	// Create call log entry
 	callLog := &models.CallLog{
-		AccountID:    &route.AccountID,
-		CallID:       callID,
-		Direction:    models.CallDirectionInbound,
-		FromURI:      session.FromURI,
-		ToURI:        session.ToURI,
-		FromUser:     session.FromUser,
-		ToUser:       session.ToUser,
-		RouteID:      &route.ID,
-		WebSocketURL: route.WebSocketURL,
-		Status:       models.CallStatusInitiated,
-	}
-
-	if _, err := m.store.CreateCallLog(ctx, callLog); err != nil {
-		log.Printf("[Call] Failed to create call log: %v", err)
+		AccountID:      &route.AccountID,
+		CallID:         callID,
+		Direction:      models.CallDirectionInbound,
+		FromURI:        session.FromURI,
+		ToURI:          session.ToURI,
+		FromUser:       session.FromUser,
+		ToUser:         session.ToUser,
+		RouteID:        &route.ID,
+		WebSocketURL:   session.WebSocketURL,
+		CallPriority:   route.CallPriority,
+		Status:         models.CallStatusInitiated,
+		OfferedMedia:   OfferedMedia([]byte(session.RemoteSDP)),
+		InstanceID:     &m.config.InstanceID,
+		ConversationID: &session.conversationID,
+		RegionDecision: session.regionDecision,
+	}
+	if region := route.AgentRegion(session.WebSocketURL); region != "" {
+		callLog.AgentRegion = &region
+	}
+	if session.splitTarget != "" {
+		callLog.SplitTarget = &session.splitTarget
+	}
+
+	if session.trunk != nil {
+		callLog.TrunkID = &session.trunk.ID
+	}
+
+	// Mask stored numbers; the session keeps them for the agent
+	if err := privacy.MaskCallLog(callLog); err != nil {
+		session.log.Error("Failed to mask call log numbers", "error", err)
+	}
+
+	if created, err := m.store.CreateCallLog(ctx, callLog); err != nil {
+		session.log.Error("Failed to create call log", "error", err)
 		// Don't fail the call, just log the error
+	} else {
+		session.callLogID = created.ID
+		session.notify(models.CallStatusInitiated)
 	}
 
 	// Track in cache
 	if m.cache != nil {
 		_ = m.cache.SetActiveCall(ctx, callID, map[string]string{
-			"from":   session.FromUser,
-			"to":     session.ToUser,
+			"from":   callLog.FromUser,
+			"to":     callLog.ToUser,
 			"status": string(models.CallStatusInitiated),
 		})
 	}
 
-	m.sessions[callID] = session
-	log.Printf("[Call] Session created: %s", callID)
+	session.countMedia()
+	m.persistDialog(ctx, session)
+	m.claimCall(ctx, session)
 
-	return session, nil
+	m.mu.Lock()
+	delete(m.admitting, callID)
+	m.sessions[callID] = session
+	m.mu.Unlock()
+	session.log.Info("Session created")
 }
 
 // GetSession returns a session by call ID
@@ -98,41 +315,73 @@ func (m *Manager) GetSession(callID string) *Session {
 	return m.sessions[callID]
 }
 
-// RemoveSession removes a session
+// RemoveSession removes the session of a call that ended normally
 func (m *Manager) RemoveSession(callID string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.EndSession(callID, models.CallStatusCompleted)
+}
 
-	if session, ok := m.sessions[callID]; ok {
-		session.Close()
-		delete(m.sessions, callID)
+// EndSession removes a call's session, recording the status the call ended
+// with (completed, cancelled or failed). The session is closed and the call
+// log updated after m.mu is released, so a slow agent or database doesn't
+// hold up other calls.
+func (m *Manager) EndSession(callID string, status models.CallStatus) {
+	m.mu.Lock()
+	session, ok := m.sessions[callID]
+	delete(m.sessions, callID)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
 
-		// Update call status
-		ctx := context.Background()
-		if err := m.store.UpdateCallStatus(ctx, callID, models.CallStatusCompleted); err != nil {
-			log.Printf("[Call] Failed to update call status: %v", err)
-		}
+	session.Close()
 
-		// Remove from cache
-		if m.cache != nil {
-			_ = m.cache.RemoveActiveCall(ctx, callID)
+	// Update call status
+	ctx := context.Background()
+	if err := m.store.UpdateCallStatus(ctx, callID, status); err != nil {
+		session.log.Error("Failed to update call status", "error", err)
+	}
+	session.hangupMu.Lock()
+	cause, party := session.hangupCause, session.hangupParty
+	session.hangupMu.Unlock()
+	if party != "" {
+		if err := m.store.SetCallHangup(ctx, callID, cause, party); err != nil {
+			session.log.Error("Failed to record hangup cause", "error", err)
 		}
+	}
+	m.recordMediaUsage(ctx, session)
+	session.notify(status)
+	m.forgetDialog(ctx, session)
+	m.releaseCall(ctx, session)
+	m.releaseSlots(ctx, callID, session.slots)
 
-		log.Printf("[Call] Session removed: %s", callID)
+	// Remove from cache
+	if m.cache != nil {
+		_ = m.cache.RemoveActiveCall(ctx, callID)
 	}
+
+	session.log.Info("Session removed")
 }
 
 // CloseAll closes all active sessions
 func (m *Manager) CloseAll() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
+	sessions := make([]*Session, 0, len(m.sessions))
 	for callID, session := range m.sessions {
-		session.Close()
+		sessions = append(sessions, session)
 		delete(m.sessions, callID)
 	}
+	m.mu.Unlock()
 
-	log.Println("[Call] All sessions closed")
+	for _, session := range sessions {
+		session.Close()
+	}
+
+	logger.Info("All sessions closed")
+}
+
+// Events returns the broker streaming call events to API clients
+func (m *Manager) Events() *eventstream.Broker {
+	return m.stream
 }
 
 // ActiveCount returns the number of active sessions
@@ -141,4 +390,3 @@ func (m *Manager) ActiveCount() int {
 	defer m.mu.RUnlock()
 	return len(m.sessions)
 }
-
@@ -0,0 +1,49 @@
+package call
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+)
+
+// Hot-path errors that can repeat for every packet of every call during an
+// outage. Each is counted on every occurrence but logged rate limited.
+var (
+	rtpReadErrors     = newMediaError(metrics.MediaErrorRTPRead)
+	rtpWriteErrors    = newMediaError(metrics.MediaErrorRTPWrite)
+	agentSendErrors   = newMediaError(metrics.MediaErrorAgentSend)
+	agentDecodeErrors = newMediaError(metrics.MediaErrorAgentDecode)
+	playoutOverflows  = newMediaError(metrics.MediaErrorPlayoutOverflow)
+)
+
+// mediaError counts one kind of media-path error and rate-limits its logging
+type mediaError struct {
+	count      *metrics.Counter
+	suppressed *metrics.Counter
+	limiter    logging.Limiter
+}
+
+func newMediaError(kind string) *mediaError {
+	return &mediaError{
+		count:      metrics.MediaErrors.With(kind),
+		suppressed: metrics.LogLinesSuppressed.With(kind),
+	}
+}
+
+// log counts the error and logs it unless rate limited, noting how many
+// lines were dropped since the last one logged
+func (e *mediaError) log(log *slog.Logger, level slog.Level, msg string, args ...any) {
+	e.count.Inc()
+
+	ok, suppressed := e.limiter.Allow()
+	if !ok {
+		e.suppressed.Inc()
+		return
+	}
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	log.Log(context.Background(), level, msg, args...)
+}
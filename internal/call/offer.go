@@ -0,0 +1,101 @@
+package call
+
+import (
+	"slices"
+
+	"github.com/shiv6146/blayzen-sip/pkg/sdp"
+)
+
+// Video policies: what happens to calls whose offer includes video, which
+// blayzen-sip doesn't carry
+const (
+	VideoPolicyAudioOnly = "audio_only" // Answer with the video stream rejected (port 0)
+	VideoPolicyDecline   = "decline"    // Decline the call with 488 Not Acceptable Here
+)
+
+// OfferedMedia returns the media types (audio, video, image, ...) an SDP
+// offer proposes, in offer order without repeats. Streams offered with port
+// 0 are disabled and not counted. It returns nil for an empty or malformed
+// offer.
+func OfferedMedia(body []byte) []string {
+	desc, err := sdp.Parse(body)
+	if err != nil {
+		return nil
+	}
+
+	var types []string
+	for _, m := range desc.Media {
+		if m.Port != 0 && !slices.Contains(types, m.Type) {
+			types = append(types, m.Type)
+		}
+	}
+	return types
+}
+
+// offersRTCPMux reports whether an SDP offer's audio stream can carry RTCP
+// on its RTP port (RFC 5761)
+func offersRTCPMux(offer string) bool {
+	desc, err := sdp.Parse([]byte(offer))
+	if err != nil {
+		return false
+	}
+	audio := desc.FirstMedia("audio")
+	if audio == nil {
+		return false
+	}
+	_, ok := audio.Attribute("rtcp-mux")
+	return ok
+}
+
+// answerDirection returns the direction of our audio stream answering an
+// offer: the caller's side putting the call on hold (sendonly) hears
+// nothing from us (recvonly), and so on (RFC 3264)
+func answerDirection(offer string) string {
+	desc, err := sdp.Parse([]byte(offer))
+	if err != nil {
+		return sdp.SendRecv
+	}
+	audio := desc.FirstMedia("audio")
+	if audio == nil {
+		return sdp.SendRecv
+	}
+	switch desc.Direction(audio) {
+	case sdp.SendOnly:
+		return sdp.RecvOnly
+	case sdp.RecvOnly:
+		return sdp.SendOnly
+	case sdp.Inactive:
+		return sdp.Inactive
+	}
+	return sdp.SendRecv
+}
+
+// answerMedia returns the m= sections answering an offer: audio in place of
+// the offer's first audio stream and every other stream rejected with port
+// 0, so the answer has as many sections as the offer in the same order
+// (RFC 3264). Without an offer only audio is returned.
+func answerMedia(offer string, audio *sdp.Media) []*sdp.Media {
+	desc, err := sdp.Parse([]byte(offer))
+	if err != nil || len(desc.Media) == 0 {
+		return []*sdp.Media{audio}
+	}
+
+	media := make([]*sdp.Media, 0, len(desc.Media))
+	answered := false
+	for _, m := range desc.Media {
+		if !answered && m.Type == "audio" && m.Port != 0 {
+			media = append(media, audio)
+			answered = true
+			continue
+		}
+		rejected := &sdp.Media{Type: m.Type, Port: 0, Proto: m.Proto}
+		if len(m.Formats) > 0 {
+			rejected.Formats = m.Formats[:1]
+		}
+		media = append(media, rejected)
+	}
+	if !answered {
+		media = append(media, audio)
+	}
+	return media
+}
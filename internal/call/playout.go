@@ -0,0 +1,233 @@
+package call
+
+import (
+	"bytes"
+	"log/slog"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/pkg/agentproto"
+)
+
+// Outbound playout framing: 20ms of 8kHz PCMU
+const (
+	playoutInterval  = 20 * time.Millisecond
+	playoutFrameSize = 160
+)
+
+// maxPlayoutBuffer caps queued agent audio (2 minutes of PCMU)
+const maxPlayoutBuffer = 8000 * 120
+
+// pcmuSilence is the mu-law encoding of a zero sample
+const pcmuSilence = 0xFF
+
+// silenceFrame is one playout frame of PCMU silence
+var silenceFrame = bytes.Repeat([]byte{pcmuSilence}, playoutFrameSize)
+
+// playoutMark is an agent mark waiting for the audio queued before it to play
+type playoutMark struct {
+	name      string
+	remaining int // Bytes of queued audio still ahead of the mark
+}
+
+// queuePlayout appends agent audio to the outbound playout buffer
+func (s *Session) queuePlayout(audio []byte) {
+	s.playoutMu.Lock()
+	defer s.playoutMu.Unlock()
+
+	if len(s.playoutBuf)+len(audio) > maxPlayoutBuffer {
+		playoutOverflows.log(s.log, slog.LevelWarn, "Playout buffer full, dropping audio", "bytes", len(audio))
+		return
+	}
+
+	s.playoutBuf = append(s.playoutBuf, audio...)
+	s.playoutQueued = true
+}
+
+// queueMark echoes an agent mark once the audio queued before it has played,
+// or right away when nothing is queued
+func (s *Session) queueMark(name string) {
+	s.playoutMu.Lock()
+	if len(s.playoutBuf) > 0 {
+		s.playoutMarks = append(s.playoutMarks, playoutMark{name: name, remaining: len(s.playoutBuf)})
+		s.playoutMu.Unlock()
+		return
+	}
+	s.playoutMu.Unlock()
+
+	s.sendMarks([]string{name})
+}
+
+// clearPlayout discards queued agent audio (barge-in). Pending marks are
+// echoed immediately.
+func (s *Session) clearPlayout() {
+	s.playoutMu.Lock()
+	s.playoutBuf = nil
+	marks := make([]string, 0, len(s.playoutMarks))
+	for _, m := range s.playoutMarks {
+		marks = append(marks, m.name)
+	}
+	s.playoutMarks = nil
+	s.playoutMu.Unlock()
+
+	s.sendMarks(marks)
+}
+
+// nextPlayoutFrame returns the next 20ms frame to send, or nil when idle,
+// and the marks reached by it. A trailing partial frame is padded with
+// silence once no more audio has arrived for a full interval.
+func (s *Session) nextPlayoutFrame() ([]byte, []string) {
+	s.playoutMu.Lock()
+	defer s.playoutMu.Unlock()
+
+	queued := s.playoutQueued
+	s.playoutQueued = false
+
+	if len(s.playoutBuf) == 0 || (len(s.playoutBuf) < playoutFrameSize && queued) {
+		return nil, nil
+	}
+
+	frame := make([]byte, playoutFrameSize)
+	n := copy(frame, s.playoutBuf)
+	for i := n; i < playoutFrameSize; i++ {
+		frame[i] = pcmuSilence
+	}
+	s.playoutBuf = s.playoutBuf[n:]
+
+	var reached []string
+	for len(s.playoutMarks) > 0 && s.playoutMarks[0].remaining <= n {
+		reached = append(reached, s.playoutMarks[0].name)
+		s.playoutMarks = s.playoutMarks[1:]
+	}
+	for i := range s.playoutMarks {
+		s.playoutMarks[i].remaining -= n
+	}
+
+	return frame, reached
+}
+
+// sendMarks echoes marks back to the agent
+func (s *Session) sendMarks(marks []string) {
+	for _, name := range marks {
+		if err := s.sendWSMessage(s.agent.Mark(s, name)); err != nil {
+			s.log.Warn("Failed to send mark", "error", err)
+		}
+	}
+}
+
+// runPlayout sends queued agent audio to the caller in paced 20ms frames
+func (s *Session) runPlayout() {
+	defer s.running(loopPlayout)()
+
+	ticker := time.NewTicker(playoutInterval)
+	defer ticker.Stop()
+
+	talking := false
+	var quiet time.Duration // Since audio was last sent to the caller
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		frame, marks := s.nextPlayoutFrame()
+		frame = s.announce(frame)
+		frame = s.superviseAgent(frame)
+		if frame == nil {
+			// Keep the RTP clock running through silence, sending a silent
+			// frame now and then so NAT bindings toward the caller stay open
+			talking = false
+			quiet += playoutInterval
+			if keepalive := s.config.RTPKeepaliveInterval; keepalive > 0 && quiet >= keepalive {
+				s.sendRTP(silenceFrame, false)
+				quiet = 0
+			} else {
+				s.advanceTimestamp(playoutFrameSize)
+			}
+			s.record(recordAgent, silenceFrame)
+			continue
+		}
+		quiet = 0
+
+		// Marker bit flags the first packet of a talkspurt
+		s.sendRTP(frame, !talking)
+		s.record(recordAgent, frame)
+		s.sendMarks(marks)
+		talking = true
+	}
+}
+
+// forwardToAgent drains the inbound jitter buffer to the agent every 20ms
+func (s *Session) forwardToAgent() {
+	defer s.running(loopForwardToAgent)()
+
+	ticker := time.NewTicker(playoutInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		payload, ok := s.jitter.Pop()
+		if !ok {
+			continue
+		}
+		s.sendMediaToAgent(payload)
+
+		// Catch up when jitter has eased and the buffer is deeper than needed
+		for s.jitter.Backlog() > 0 {
+			payload, ok := s.jitter.Pop()
+			if !ok {
+				break
+			}
+			s.sendMediaToAgent(payload)
+		}
+	}
+}
+
+// sendMediaToAgent records one frame of caller audio and sends it to the
+// agent, or holds it while the agent reconnects
+func (s *Session) sendMediaToAgent(payload []byte) {
+	s.record(recordCaller, payload)
+	s.detectSpeech(payload)
+	s.detectFax(payload)
+
+	payload = s.superviseCaller(payload)
+	if s.holdAudio(payload) {
+		return
+	}
+	s.forwardAudio(payload)
+}
+
+// forwardAudio sends caller audio to the agent in the agent's format
+func (s *Session) forwardAudio(payload []byte) {
+	for _, chunk := range s.agentAudio.FromCaller(payload) {
+		s.chunkCount++
+		s.chunksSent.Add(1)
+
+		var err error
+		if s.Route.BinaryAudio {
+			err = s.sendWSBinary(s.audioFrame(chunk))
+		} else {
+			err = s.sendWSMessage(s.agent.Media(s, chunk))
+		}
+		if err != nil {
+			agentSendErrors.log(s.log, slog.LevelWarn, "Failed to send media", "error", err)
+		}
+	}
+}
+
+// audioFrame encodes the current chunk for binary framing, timestamped by
+// the amount of audio sent so far
+func (s *Session) audioFrame(chunk []byte) []byte {
+	chunkMs := s.agentAudio.MediaFormat().ChunkMs
+	return (&agentproto.AudioFrame{
+		Sequence:  uint32(s.chunkCount),
+		Timestamp: uint32((s.chunkCount - 1) * chunkMs),
+		Payload:   chunk,
+	}).Marshal()
+}
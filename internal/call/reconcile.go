@@ -0,0 +1,174 @@
+package call
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// reconcileGrace is how old call state must be before it's repaired,
+// leaving calls being set up, and snapshots a few seconds behind, alone
+const reconcileGrace = time.Minute
+
+// reconcileBatch bounds how many unfinished calls are listed at a time
+const reconcileBatch = 500
+
+// Reconcile cross-checks the calls in memory, the active call entries in
+// Valkey and the calls recorded in progress in the database every
+// CallReconcileInterval until ctx is cancelled, repairing drift between
+// them: entries of calls no instance holds are removed, this instance's
+// calls missing an entry get one, and calls left in progress by this
+// instance, or by one no longer running, are recorded ended. Each instance
+// serving SIP runs it; repairs are idempotent.
+func (m *Manager) Reconcile(ctx context.Context) {
+	if m.config.CallReconcileInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.config.CallReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.reconcile(ctx, time.Now())
+		}
+	}
+}
+
+// reconcile runs one reconciliation pass
+func (m *Manager) reconcile(ctx context.Context, now time.Time) {
+	local := m.localActiveCalls("", now)
+	live := make(map[string]bool, len(local))
+	for _, a := range local {
+		live[a.CallID] = true
+	}
+
+	// Other instances' calls, as of their last snapshot. Without them drift
+	// can't be told from calls elsewhere, so the pass is skipped.
+	instances := map[string]bool{m.config.InstanceID: true}
+	if m.cache != nil {
+		snapshots, err := m.cache.GetInstanceCalls(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warn("Failed to read other instances' calls, skipping reconciliation", "error", err)
+			}
+			return
+		}
+		for instance, payload := range snapshots {
+			if instance == m.config.InstanceID {
+				continue
+			}
+			var remote []*models.ActiveCall
+			if err := json.Unmarshal(payload, &remote); err != nil {
+				logger.Warn("Skipping reconciliation over a malformed active-call snapshot", "instance", instance, "error", err)
+				return
+			}
+			instances[instance] = true
+			for _, a := range remote {
+				live[a.CallID] = true
+			}
+		}
+		m.reconcileCache(ctx, now, local, live)
+	}
+	m.reconcileCallLogs(ctx, now, live, instances)
+}
+
+// reconcileCache removes the active call entries of calls no instance holds
+// and adds those missing for this instance's calls
+func (m *Manager) reconcileCache(ctx context.Context, now time.Time, local []*models.ActiveCall, live map[string]bool) {
+	ages, err := m.cache.ActiveCallAges(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warn("Failed to list cached active calls", "error", err)
+		}
+		return
+	}
+
+	for callID, age := range ages {
+		if live[callID] || age < reconcileGrace {
+			continue
+		}
+		if err := m.cache.RemoveActiveCall(ctx, callID); err != nil {
+			logger.Warn("Failed to remove stale active call entry", "call_id", callID, "error", err)
+			continue
+		}
+		metrics.ReconcileRepairs.With(metrics.DriftCacheStale).Inc()
+		logger.Info("Removed stale active call entry", "call_id", callID)
+	}
+
+	for _, a := range local {
+		if _, ok := ages[a.CallID]; ok || now.Sub(a.StartedAt) < reconcileGrace {
+			continue
+		}
+		err := m.cache.SetActiveCall(ctx, a.CallID, map[string]string{
+			"from":   a.FromUser,
+			"to":     a.ToUser,
+			"status": string(a.Status),
+		})
+		if err != nil {
+			logger.Warn("Failed to restore active call entry", "call_id", a.CallID, "error", err)
+			continue
+		}
+		metrics.ReconcileRepairs.With(metrics.DriftCacheMissing).Inc()
+		logger.Info("Restored missing active call entry", "call_id", a.CallID)
+	}
+}
+
+// reconcileCallLogs records ended the calls the database has in progress
+// that no instance holds, among those taken by this instance or by one that
+// isn't running. Calls of other instances, and with no Valkey those of
+// unknown instances, are left to them.
+func (m *Manager) reconcileCallLogs(ctx context.Context, now time.Time, live, instances map[string]bool) {
+	// Page through them all: live calls are skipped, and would otherwise
+	// fill every batch ahead of stale ones
+	var after *models.CallLog
+	for {
+		calls, err := m.store.ListUnfinishedCalls(ctx, now.Add(-reconcileGrace), after, reconcileBatch)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warn("Failed to list unfinished calls", "error", err)
+			}
+			return
+		}
+
+		for _, c := range calls {
+			m.reconcileCallLog(ctx, c, live, instances)
+		}
+		if len(calls) < reconcileBatch {
+			return
+		}
+		after = calls[len(calls)-1]
+	}
+}
+
+// reconcileCallLog records one unfinished call ended if no instance holds it
+func (m *Manager) reconcileCallLog(ctx context.Context, c *models.CallLog, live, instances map[string]bool) {
+	if live[c.CallID] {
+		return
+	}
+	instance := ""
+	if c.InstanceID != nil {
+		instance = *c.InstanceID
+	}
+	if instance != m.config.InstanceID && (m.cache == nil || instances[instance]) {
+		return
+	}
+
+	log := logger.With("call_id", c.CallID, "instance", instance, "status", c.Status)
+	ended, err := m.store.EndUnfinishedCall(ctx, c.CallID, models.HangupCauseReconciled)
+	if err != nil {
+		log.Warn("Failed to end unfinished call", "error", err)
+		return
+	}
+	if !ended {
+		return // Ended since listed
+	}
+	metrics.ReconcileRepairs.With(metrics.DriftUnfinished).Inc()
+	log.Warn("Ended call left in progress")
+}
@@ -0,0 +1,101 @@
+package call
+
+import (
+	"context"
+	"time"
+)
+
+// reconnectAgent re-establishes a dropped agent connection within the
+// reconnect policy: up to AgentReconnectAttempts attempts, backing off
+// exponentially from AgentReconnectBackoff, for at most
+// AgentReconnectMaxDuration. Caller audio is held meanwhile and sent once
+// the agent is back. It reports whether the agent was reconnected.
+func (s *Session) reconnectAgent() bool {
+	attempts := s.config.AgentReconnectAttempts
+	if attempts <= 0 {
+		return false
+	}
+
+	s.startAudioGap()
+
+	// Drop the dead connection so nothing more is written to it
+	s.wsMu.Lock()
+	if s.wsConn != nil {
+		_ = s.wsConn.Close()
+		s.wsConn = nil
+	}
+	s.wsMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.AgentReconnectMaxDuration)
+	defer cancel()
+
+	backoff := s.config.AgentReconnectBackoff
+	for attempt := 1; attempt <= attempts; attempt++ {
+		select {
+		case <-s.stopChan:
+			return false
+		case <-ctx.Done():
+			s.log.Warn("Agent reconnect gave up", "after", s.config.AgentReconnectMaxDuration)
+			s.endAudioGap(false)
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		conn, err := s.dialAgent(ctx)
+		if err == nil {
+			err = s.startAgent(conn, true)
+		}
+		if err != nil {
+			s.log.Warn("Agent reconnect attempt failed", "attempt", attempt, "attempts", attempts, "error", err)
+			continue
+		}
+
+		s.log.Info("Agent reconnected")
+		s.endAudioGap(true)
+		return true
+	}
+
+	s.endAudioGap(false)
+	return false
+}
+
+// startAudioGap starts holding caller audio for the agent
+func (s *Session) startAudioGap() {
+	s.gapMu.Lock()
+	defer s.gapMu.Unlock()
+
+	s.inGap = true
+	s.gapAudio = nil
+}
+
+// endAudioGap stops holding caller audio, sending what was held to the
+// reconnected agent or discarding it
+func (s *Session) endAudioGap(send bool) {
+	s.gapMu.Lock()
+	defer s.gapMu.Unlock()
+
+	if send {
+		for off := 0; off < len(s.gapAudio); off += playoutFrameSize {
+			s.forwardAudio(s.gapAudio[off:min(off+playoutFrameSize, len(s.gapAudio))])
+		}
+	}
+	s.inGap = false
+	s.gapAudio = nil
+}
+
+// holdAudio keeps a frame of caller audio while the agent is reconnecting,
+// up to AgentReconnectMaxDuration of it. It reports false when no
+// reconnect is in progress.
+func (s *Session) holdAudio(payload []byte) bool {
+	s.gapMu.Lock()
+	defer s.gapMu.Unlock()
+
+	if !s.inGap {
+		return false
+	}
+	if limit := int(s.config.AgentReconnectMaxDuration.Seconds() * 8000); len(s.gapAudio)+len(payload) <= limit {
+		s.gapAudio = append(s.gapAudio, payload...)
+	}
+	return true
+}
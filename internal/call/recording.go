@@ -0,0 +1,239 @@
+package call
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/audio"
+	"github.com/shiv6146/blayzen-sip/internal/storage"
+)
+
+// Recordings are 8kHz 16-bit stereo WAV: caller on the left, agent on the right
+const (
+	recordingSampleRate = 8000
+	recordingChannels   = 2
+	recordingHeaderSize = 44
+)
+
+// Recording channels
+const (
+	recordCaller = 0
+	recordAgent  = 1
+)
+
+// recordingMaxLag is how far (in samples) a leg may fall behind the wall clock
+// before the gap is filled with silence, e.g. while the caller sends no RTP
+const recordingMaxLag = recordingSampleRate / 5
+
+// recorder writes both legs of a call into a stereo WAV file, keeping them
+// aligned in time
+type recorder struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	w       *bufio.Writer
+	start   time.Time
+	pending [recordingChannels][]int16
+	written int // samples per channel flushed to the file
+	closed  bool
+}
+
+// newRecorder creates the WAV file for a recording
+func newRecorder(dir, name string) (*recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	path := filepath.Join(dir, name+".wav")
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	r := &recorder{
+		path:  path,
+		file:  file,
+		w:     bufio.NewWriter(file),
+		start: time.Now(),
+	}
+
+	// Sizes are patched in when the recording is closed
+	if _, err := r.w.Write(wavHeader(0)); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to write recording header: %w", err)
+	}
+
+	return r, nil
+}
+
+// WriteMulaw records a frame of PCMU audio on the given channel
+func (r *recorder) WriteMulaw(channel int, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	// Fill gaps on both legs so they stay aligned with the wall clock
+	expected := int(time.Since(r.start)*recordingSampleRate/time.Second) - recordingMaxLag
+	for ch := range r.pending {
+		if gap := expected - r.written - len(r.pending[ch]); gap > 0 {
+			r.pending[ch] = append(r.pending[ch], make([]int16, gap)...)
+		}
+	}
+
+	r.pending[channel] = append(r.pending[channel], audio.MulawToSamples(payload)...)
+	r.flush(false)
+}
+
+// flush interleaves the samples both legs have in common into the file. When
+// final is set, the shorter leg is padded with silence first.
+func (r *recorder) flush(final bool) {
+	n := min(len(r.pending[recordCaller]), len(r.pending[recordAgent]))
+	if final {
+		n = max(len(r.pending[recordCaller]), len(r.pending[recordAgent]))
+		for ch := range r.pending {
+			if gap := n - len(r.pending[ch]); gap > 0 {
+				r.pending[ch] = append(r.pending[ch], make([]int16, gap)...)
+			}
+		}
+	}
+	if n == 0 {
+		return
+	}
+
+	frame := make([]byte, 2*recordingChannels)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint16(frame[0:2], uint16(r.pending[recordCaller][i]))
+		binary.LittleEndian.PutUint16(frame[2:4], uint16(r.pending[recordAgent][i]))
+		_, _ = r.w.Write(frame)
+	}
+
+	for ch := range r.pending {
+		r.pending[ch] = r.pending[ch][n:]
+	}
+	r.written += n
+}
+
+// Close finalises the WAV file and returns its path, size and duration
+func (r *recorder) Close() (path string, size int64, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return "", 0, 0, fmt.Errorf("recording already closed")
+	}
+	r.closed = true
+
+	r.flush(true)
+	defer r.file.Close()
+
+	if err := r.w.Flush(); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to write recording: %w", err)
+	}
+
+	dataSize := r.written * 2 * recordingChannels
+	if _, err := r.file.WriteAt(wavHeader(dataSize), 0); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to finalise recording: %w", err)
+	}
+
+	size = int64(recordingHeaderSize + dataSize)
+	duration = time.Duration(r.written) * time.Second / recordingSampleRate
+	return r.path, size, duration, nil
+}
+
+// wavHeader builds a canonical PCM WAV header for the given data size
+func wavHeader(dataSize int) []byte {
+	const bitsPerSample = 16
+	blockAlign := recordingChannels * bitsPerSample / 8
+
+	h := make([]byte, recordingHeaderSize)
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], uint32(recordingHeaderSize-8+dataSize))
+	copy(h[8:12], "WAVE")
+	copy(h[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(h[20:22], 1)  // PCM
+	binary.LittleEndian.PutUint16(h[22:24], recordingChannels)
+	binary.LittleEndian.PutUint32(h[24:28], recordingSampleRate)
+	binary.LittleEndian.PutUint32(h[28:32], uint32(recordingSampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(h[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(h[34:36], bitsPerSample)
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], uint32(dataSize))
+	return h
+}
+
+// startRecording begins recording the call when its route asks for it
+func (s *Session) startRecording() {
+	if s.Route == nil || !s.Route.Record {
+		return
+	}
+
+	rec, err := newRecorder(s.config.RecordingDir, s.StreamSID)
+	if err != nil {
+		s.log.Error("Failed to start recording", "error", err)
+		return
+	}
+
+	s.recorder = rec
+	s.log.Info("Recording call", "path", rec.path)
+}
+
+// record adds a frame of PCMU audio to the recording, if any
+func (s *Session) record(channel int, payload []byte) {
+	if s.recorder != nil {
+		s.recorder.WriteMulaw(channel, payload)
+	}
+}
+
+// stopRecording finalises the recording and stores its metadata on the call log
+func (s *Session) stopRecording() {
+	if s.recorder == nil {
+		return
+	}
+
+	path, size, duration, err := s.recorder.Close()
+	if err != nil {
+		s.log.Error("Failed to finalise recording", "error", err)
+		return
+	}
+
+	s.log.Info("Recording saved", "path", path, "bytes", size, "duration", duration)
+
+	// Store in the background so hangup isn't held up
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		key := storage.DatedKey(storage.PrefixRecordings, filepath.Base(path), time.Now())
+		location, err := storage.PutFile(ctx, s.blobs, key, path, "audio/wav")
+		if err != nil {
+			// Keep the local copy rather than lose the recording
+			s.log.Error("Failed to store recording", "error", err)
+			s.saveRecording(path, size, duration)
+			return
+		}
+
+		s.log.Info("Recording stored", "location", location)
+		s.saveRecording(location, size, duration)
+
+		if err := os.Remove(path); err != nil {
+			s.log.Warn("Failed to remove local recording", "path", path, "error", err)
+		}
+	}()
+}
+
+// saveRecording stores the recording's location and metadata on the call log
+func (s *Session) saveRecording(location string, size int64, duration time.Duration) {
+	if err := s.store.SetCallRecording(context.Background(), s.CallID, location, size, duration.Milliseconds()); err != nil {
+		s.log.Error("Failed to store recording metadata", "error", err)
+	}
+}
@@ -0,0 +1,133 @@
+package call
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// How REFERs from the caller's side are handled
+const (
+	ReferPolicyReroute = "reroute" // Hand the call to the agent of the route matching the target
+	ReferPolicyDecline = "decline" // Refuse every REFER with 603 Decline
+)
+
+// Reroute hands an active call on this instance to the agent of another
+// route, as the caller's side asked by transferring the call (REFER) to
+// target
+func (m *Manager) Reroute(ctx context.Context, callID string, route *models.Route, target string) error {
+	session := m.GetSession(callID)
+	if session == nil {
+		return ErrCallNotActive
+	}
+
+	m.mu.Lock()
+	agentURLs, _ := m.agentOrder(route)
+	m.mu.Unlock()
+	split := splitTarget(route, callID, session.FromUser)
+	if split != nil {
+		agentURLs = withSplit(split, agentURLs)
+		metrics.SplitCalls.With(route.ID, split.Name).Inc()
+	}
+	return session.reroute(ctx, route, agentURLs, split, target)
+}
+
+// reroute connects the call to the agent of route, trying agentURLs in
+// order, starting with its agent split target when it has one. Caller audio is held while the new agent connects and then sent to
+// it; the previous agent is sent a stop message and disconnected. If no new
+// agent can be reached the call stays with the previous one.
+func (s *Session) reroute(ctx context.Context, route *models.Route, agentURLs []string, split *models.AgentSplit, target string) error {
+	if s.isClosed() {
+		return ErrCallNotActive
+	}
+	if s.mediaStarted.Load() == 0 {
+		return ErrCallNotAnswered
+	}
+	if !s.transferring.CompareAndSwap(false, true) {
+		return ErrTransferInProgress
+	}
+	defer s.transferring.Store(false)
+
+	s.log.Info("Rerouting call", "target", target, "route", route.Name)
+	s.startAudioGap()
+
+	// Agent dialing and the start message follow the new route
+	s.wsMu.Lock()
+	prevRoute, prevURLs, prevURL, prevAgent, prevAudio := s.Route, s.agentURLs, s.WebSocketURL, s.agent, s.agentAudio
+	s.Route, s.agentURLs, s.WebSocketURL = route, agentURLs, agentURLs[0]
+	s.agent, s.agentAudio = newAgentCodec(route.AgentProtocol), newAgentAudio(route.EffectiveAudioFormat())
+	s.allowlistLoaded = false
+	s.wsMu.Unlock()
+
+	conn, err := s.dialAgent(ctx)
+	if err != nil {
+		s.wsMu.Lock()
+		s.Route, s.agentURLs, s.WebSocketURL = prevRoute, prevURLs, prevURL
+		s.agent, s.agentAudio = prevAgent, prevAudio
+		s.wsMu.Unlock()
+		s.endAudioGap(true)
+		return err
+	}
+
+	// Let the previous agent go; its reader sees the connection replaced
+	s.wsMu.Lock()
+	if prev := s.wsConn; prev != nil {
+		s.setWriteDeadline()
+		_ = prev.WriteJSON(prevAgent.Stop(s))
+		_ = prev.Close()
+		s.wsConn = nil
+	}
+	s.wsMu.Unlock()
+	s.clearPlayout()
+
+	if err := s.startAgent(conn, false); err != nil {
+		s.endAudioGap(false)
+		return err
+	}
+	s.endAudioGap(true)
+
+	s.splitTarget = ""
+	if split != nil {
+		s.splitTarget = split.Name
+	}
+
+	bg := context.Background()
+	if err := s.store.SetCallRoute(bg, s.CallID, route.ID, s.WebSocketURL, s.splitTarget); err != nil {
+		s.log.Error("Failed to record call route", "error", err)
+	}
+	if err := s.store.SetCallTransfer(bg, s.CallID, target); err != nil {
+		s.log.Error("Failed to record transfer", "error", err)
+	}
+	s.log.Info("Call rerouted", "route", route.Name, "agent_url", s.WebSocketURL)
+	return nil
+}
+
+// NotifyRefer reports the progress of a REFER from the caller's side with a
+// NOTIFY carrying the status line of the referred call (RFC 3515). The last
+// one terminates the implicit subscription.
+func (s *Session) NotifyRefer(ctx context.Context, status int, reason string, final bool) error {
+	req, err := s.newDialogRequest(sip.NOTIFY)
+	if err != nil {
+		return err
+	}
+	state := "active;expires=60"
+	if final {
+		state = "terminated;reason=noresource"
+	}
+	req.AppendHeader(sip.NewHeader("Event", "refer"))
+	req.AppendHeader(sip.NewHeader("Subscription-State", state))
+	req.AppendHeader(sip.NewHeader("Content-Type", "message/sipfrag;version=2.0"))
+	req.SetBody([]byte(fmt.Sprintf("SIP/2.0 %d %s\r\n", status, reason)))
+
+	res, err := s.doDialogRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !res.IsSuccess() {
+		return fmt.Errorf("NOTIFY rejected: %d %s", res.StatusCode, res.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package call
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Agent region health, measured from this instance's agent connections
+const (
+	regionFailureThreshold = 3                // Consecutive failed connections making a region unhealthy
+	regionRetryAfter       = 30 * time.Second // Before an unhealthy region is ranked by latency again
+	regionLatencyWeight    = 0.2              // Of each handshake time in the moving average
+)
+
+// regionStats is the health of one agent region
+type regionStats struct {
+	latency     time.Duration // Moving average of successful handshakes
+	failures    int           // Consecutive
+	lastFailure time.Time
+}
+
+// regionHealth tracks the agent regions of routes with regional pools, as
+// this instance's connections to their agents fare
+type regionHealth struct {
+	mu    sync.Mutex
+	stats map[string]*regionStats
+}
+
+// newRegionHealth creates a tracker with every region healthy and unmeasured
+func newRegionHealth() *regionHealth {
+	return &regionHealth{stats: make(map[string]*regionStats)}
+}
+
+// observe records an agent connection to a region: its handshake time, or
+// its failure
+func (h *regionHealth) observe(region string, elapsed time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := h.stats[region]
+	if stats == nil {
+		stats = &regionStats{}
+		h.stats[region] = stats
+	}
+	if err != nil {
+		stats.failures++
+		stats.lastFailure = time.Now()
+		metrics.AgentRegionConnects.With(region, metrics.RegionFailed).Inc()
+		return
+	}
+
+	stats.failures = 0
+	if stats.latency == 0 {
+		stats.latency = elapsed
+	} else {
+		stats.latency += time.Duration(regionLatencyWeight * float64(elapsed-stats.latency))
+	}
+	metrics.AgentRegionConnects.With(region, metrics.RegionConnected).Inc()
+}
+
+// rank returns the health of a region at now
+func (h *regionHealth) rank(region string, now time.Time) models.RegionRank {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r := models.RegionRank{Region: region, Healthy: true}
+	stats := h.stats[region]
+	if stats == nil {
+		return r
+	}
+	r.Healthy = stats.failures < regionFailureThreshold || now.Sub(stats.lastFailure) >= regionRetryAfter
+	if stats.latency > 0 {
+		ms := int(stats.latency.Milliseconds())
+		r.LatencyMs = &ms
+	}
+	return r
+}
+
+// rankRegions orders a route's agent regions for a new call: this
+// instance's region first while it's healthy, then the other healthy ones,
+// fastest first (unmeasured ones last, as listed), then the unhealthy ones,
+// this instance's first
+func (m *Manager) rankRegions(route *models.Route) *models.RegionDecision {
+	now := time.Now()
+	decision := &models.RegionDecision{LocalRegion: m.config.Region}
+	for _, pool := range route.AgentRegions {
+		decision.Regions = append(decision.Regions, m.regions.rank(pool.Region, now))
+	}
+
+	local := m.config.Region
+	tier := func(r models.RegionRank) int {
+		switch {
+		case r.Healthy && r.Region == local:
+			return 0
+		case r.Healthy:
+			return 1
+		case r.Region == local:
+			return 2
+		default:
+			return 3
+		}
+	}
+	sort.SliceStable(decision.Regions, func(i, j int) bool {
+		a, b := decision.Regions[i], decision.Regions[j]
+		if ta, tb := tier(a), tier(b); ta != tb || ta != 1 {
+			return ta < tb
+		}
+		switch {
+		case a.LatencyMs == nil:
+			return false
+		case b.LatencyMs == nil:
+			return true
+		}
+		return *a.LatencyMs < *b.LatencyMs
+	})
+	return decision
+}
@@ -0,0 +1,86 @@
+package call
+
+import (
+	"context"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// callOwnerTTL is how long a call's registry entry outlives an instance
+// that stopped refreshing it
+const callOwnerTTL = liveCallsTTL
+
+// registersCalls reports whether this instance's calls are registered in
+// the cluster's call registry, for other instances to forward in-dialog
+// requests here
+func (m *Manager) registersCalls() bool {
+	return m.cache != nil && m.config.SIPNodeAddress != ""
+}
+
+// callOwner returns the registry entry of a session on this instance
+func (m *Manager) callOwner(s *Session) *models.CallOwner {
+	return &models.CallOwner{
+		Instance:  m.config.InstanceID,
+		Address:   m.config.SIPNodeAddress,
+		AccountID: s.Route.AccountID,
+		StartedAt: s.createdAt,
+	}
+}
+
+// claimCall registers a new session's call as this instance's
+func (m *Manager) claimCall(ctx context.Context, s *Session) {
+	if !m.registersCalls() {
+		return
+	}
+	owners := map[string]*models.CallOwner{s.CallID: m.callOwner(s)}
+	if err := m.cache.SetCallOwners(ctx, owners, callOwnerTTL); err != nil {
+		s.log.Warn("Failed to register call", "error", err)
+	}
+}
+
+// releaseCall removes an ended call from the registry
+func (m *Manager) releaseCall(ctx context.Context, s *Session) {
+	if !m.registersCalls() {
+		return
+	}
+	if err := m.cache.RemoveCallOwner(ctx, s.CallID); err != nil {
+		s.log.Warn("Failed to unregister call", "error", err)
+	}
+}
+
+// refreshCallOwners keeps this instance's calls registered
+func (m *Manager) refreshCallOwners(ctx context.Context) error {
+	if !m.registersCalls() {
+		return nil
+	}
+	m.mu.RLock()
+	owners := make(map[string]*models.CallOwner, len(m.sessions))
+	for callID, s := range m.sessions {
+		owners[callID] = m.callOwner(s)
+	}
+	m.mu.RUnlock()
+	return m.cache.SetCallOwners(ctx, owners, callOwnerTTL)
+}
+
+// RemoteOwner returns the other instance holding a call this instance has
+// no session for, or nil when the call isn't registered, is registered to
+// this instance or calls aren't registered. A registry lookup failing is
+// logged and taken as no owner, leaving the request to be answered here.
+func (m *Manager) RemoteOwner(ctx context.Context, callID string) *models.CallOwner {
+	if !m.registersCalls() || m.GetSession(callID) != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	owner, err := m.cache.GetCallOwner(ctx, callID)
+	if err != nil {
+		logger.Warn("Failed to look up call owner", "call_id", callID, "error", err)
+		return nil
+	}
+	if owner == nil || owner.Instance == m.config.InstanceID || owner.Address == "" {
+		return nil
+	}
+	return owner
+}
@@ -0,0 +1,97 @@
+package call
+
+import (
+	"errors"
+	"net"
+
+	"github.com/shiv6146/blayzen-sip/internal/eventstream"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/pkg/sdp"
+)
+
+// ErrOfferNotAcceptable is returned for a new offer the call can't continue
+// with, which leaves the call as it was
+var ErrOfferNotAcceptable = errors.New("offer has no PCMU audio stream")
+
+// Renegotiate applies a new SDP offer from the caller's side, received in a
+// re-INVITE, and returns our answer. The offer may move the caller's media
+// elsewhere, as after an SBC failover, or put the call on hold or resume it,
+// which the agent is told about. Without an offer (a re-INVITE asking for
+// ours) the call is left as it is and our current SDP returned.
+func (s *Session) Renegotiate(offer []byte) (string, error) {
+	if s.answer == nil {
+		return "", ErrCallNotAnswered
+	}
+	if len(offer) == 0 {
+		return s.GenerateSDP(), nil
+	}
+
+	desc, err := sdp.Parse(offer)
+	if err != nil {
+		return "", err
+	}
+	audio := desc.FirstMedia("audio")
+	if audio == nil || audio.Port == 0 {
+		return "", ErrOfferNotAcceptable
+	}
+	if _, ok := audio.PayloadType("PCMU"); !ok {
+		return "", ErrOfferNotAcceptable
+	}
+
+	// Legacy hold (RFC 2543) zeroes the address instead of setting a direction
+	direction := desc.Direction(audio)
+	addr := desc.Address(audio)
+	legacyHold := addr == "0.0.0.0" || addr == "::"
+	held := direction == sdp.SendOnly || direction == sdp.Inactive || legacyHold
+
+	prev := s.offeredRTPAddr()
+	s.sdpMu.Lock()
+	s.RemoteSDP = string(offer)
+	s.sdpVersion++
+	s.sdpMu.Unlock()
+	s.eventPT.Store(offeredEventPT(string(offer)))
+
+	if !legacyHold {
+		s.retargetMedia(prev, s.offeredRTPAddr())
+	}
+
+	if s.onHold.Swap(held) != held {
+		if held {
+			s.log.Info("Call put on hold", "direction", direction)
+			s.trace(eventstream.EventMedia, models.SIPMessageInbound, "hold")
+		} else {
+			s.log.Info("Call resumed")
+			s.trace(eventstream.EventMedia, models.SIPMessageInbound, "resume")
+		}
+		s.markActivity()
+		if err := s.sendWSMessage(s.agent.Hold(s, held)); err != nil {
+			s.log.Warn("Failed to notify agent of hold", "held", held, "error", err)
+		}
+	}
+
+	return s.GenerateSDP(), nil
+}
+
+// retargetMedia sends RTP to the caller's new address when a new offer
+// moves it
+func (s *Session) retargetMedia(prev, addr *net.UDPAddr) {
+	udp, ok := s.media.(*udpTransport)
+	if !ok || addr == nil || (prev != nil && prev.String() == addr.String()) {
+		return
+	}
+	s.log.Info("Caller's media moved", "from", prev, "to", addr)
+	s.trace(eventstream.EventMedia, "", "caller moved to "+addr.String())
+	udp.Retarget(addr)
+}
+
+// remoteSDP returns the caller's latest SDP offer
+func (s *Session) remoteSDP() string {
+	s.sdpMu.Lock()
+	defer s.sdpMu.Unlock()
+	return s.RemoteSDP
+}
+
+// OnHold reports whether the caller's side has put the call on hold
+func (s *Session) OnHold() bool {
+	return s.onHold.Load()
+}
@@ -0,0 +1,200 @@
+package call
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/audio"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/pkg/sdp"
+)
+
+// Ringback audio
+const (
+	ringbackAmplitude = 4000 // Per frequency, summed for dual tones
+	maxRingbackAudio  = 8000 * 60
+	maxRingbacks      = 256 // Cached ringbacks; the cache is cleared when full
+)
+
+// ringbackCache holds ringback audio as PCMU, by route ringback spec and
+// language, so files are read and tones synthesised once
+type ringbackCache struct {
+	files promptFiles // RINGBACK_DIR, or the prompts in blob storage
+
+	mu    sync.Mutex
+	audio map[string][]byte
+}
+
+// newRingbackCache creates a cache reading ringback files from files
+func newRingbackCache(files promptFiles) *ringbackCache {
+	return &ringbackCache{files: files, audio: make(map[string][]byte)}
+}
+
+// Load returns the audio for a ringback spec, one cadence of a tone or a
+// whole file, to be looped. Files are taken in the callers' language when
+// there's a version for it (see promptFiles.read).
+func (c *ringbackCache) Load(spec, language string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := spec + "|" + language
+	if audio, ok := c.audio[key]; ok {
+		return audio, nil
+	}
+
+	tone, file, err := models.ParseRingback(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var audio []byte
+	if tone != nil {
+		audio = synthesizeTone(tone)
+	} else {
+		data, _, err := c.files.read(file, language)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ringback: %w", err)
+		}
+		if audio, err = wavToMulaw(data); err != nil {
+			return nil, fmt.Errorf("invalid ringback %s: %w", file, err)
+		}
+		if len(audio) == 0 {
+			return nil, fmt.Errorf("ringback %s has no audio", file)
+		}
+		audio = audio[:min(len(audio), maxRingbackAudio)]
+	}
+
+	if len(c.audio) >= maxRingbacks {
+		clear(c.audio)
+	}
+	c.audio[key] = audio
+	return audio, nil
+}
+
+// synthesizeTone renders one cadence of a ringback tone
+func synthesizeTone(tone *models.RingbackTone) []byte {
+	var samples []int16
+	for i, period := range tone.Cadence {
+		n := int(period.Seconds() * 8000)
+		if i%2 == 1 {
+			samples = append(samples, make([]int16, n)...)
+			continue
+		}
+		for j := 0; j < n; j++ {
+			t := float64(len(samples)) / 8000
+			var v float64
+			for _, f := range tone.Frequencies {
+				v += ringbackAmplitude * math.Sin(2*math.Pi*float64(f)*t)
+			}
+			samples = append(samples, int16(v))
+		}
+	}
+	return audio.SamplesToMulaw(samples)
+}
+
+// PrepareRingback loads the route's ringback, reporting whether the call
+// should be sent early media. Calls whose ringback can't be loaded fall back
+// to a plain 180 Ringing.
+func (s *Session) PrepareRingback() bool {
+	if s.Route.Ringback == nil || s.ringbacks == nil {
+		return false
+	}
+
+	var language string
+	if s.Route.Language != nil {
+		language = *s.Route.Language
+	}
+	audio, err := s.ringbacks.Load(*s.Route.Ringback, language)
+	if err != nil {
+		s.log.Warn("Failed to load ringback, sending 180 Ringing", "ringback", *s.Route.Ringback, "error", err)
+		return false
+	}
+	s.ringback = audio
+	return true
+}
+
+// RingbackDuration returns how long one play of the prepared ringback lasts
+func (s *Session) RingbackDuration() time.Duration {
+	return time.Duration(len(s.ringback)) * time.Second / 8000
+}
+
+// StartRingback streams the prepared ringback to the caller, looped, until
+// StopRingback. RTP is sent to the address in the caller's SDP offer until
+// a packet from the caller shows where to send it.
+func (s *Session) StartRingback() {
+	if s.ringback == nil {
+		return
+	}
+
+	if udp, ok := s.media.(*udpTransport); ok {
+		if addr := s.offeredRTPAddr(); addr != nil {
+			udp.Offer(addr)
+		}
+	}
+
+	s.ringbackStop = make(chan struct{})
+	s.ringbackDone = make(chan struct{})
+	go s.playRingback()
+	s.log.Debug("Playing ringback", "ringback", *s.Route.Ringback)
+}
+
+// StopRingback ends the ringback, before the call is answered, and waits for
+// its last frame to be sent
+func (s *Session) StopRingback() {
+	if s.ringbackStop == nil {
+		return
+	}
+	close(s.ringbackStop)
+	<-s.ringbackDone
+	s.ringbackStop = nil
+}
+
+// playRingback sends the ringback in paced 20ms frames
+func (s *Session) playRingback() {
+	defer s.running(loopRingback)()
+	defer close(s.ringbackDone)
+
+	ticker := time.NewTicker(playoutInterval)
+	defer ticker.Stop()
+
+	pos := 0
+	first := true
+	for {
+		select {
+		case <-s.ringbackStop:
+			return
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		frame := make([]byte, playoutFrameSize)
+		for i := range frame {
+			frame[i] = s.ringback[pos]
+			pos = (pos + 1) % len(s.ringback)
+		}
+		s.sendRTP(frame, first)
+		first = false
+	}
+}
+
+// offeredRTPAddr returns the audio address in the caller's SDP offer
+func (s *Session) offeredRTPAddr() *net.UDPAddr {
+	offer, err := sdp.Parse([]byte(s.remoteSDP()))
+	if err != nil {
+		return nil
+	}
+	media := offer.FirstMedia("audio")
+	if media == nil || media.Port == 0 {
+		return nil
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(offer.Address(media), strconv.Itoa(media.Port)))
+	if err != nil {
+		return nil
+	}
+	return addr
+}
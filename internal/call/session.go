@@ -2,21 +2,44 @@ package call
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/shiv6146/blayzen-sip/internal/accounting"
 	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/eventstream"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
 	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/routing"
+	"github.com/shiv6146/blayzen-sip/internal/storage"
 	"github.com/shiv6146/blayzen-sip/internal/store"
+	"github.com/shiv6146/blayzen-sip/internal/webhook"
+	"github.com/shiv6146/blayzen-sip/pkg/agentproto"
+	"github.com/shiv6146/blayzen-sip/pkg/rtp"
+	"github.com/shiv6146/blayzen-sip/pkg/sdp"
 	"github.com/shiv6146/blayzen/pkg/protocol/exotel"
 )
 
+// RTP counters, looked up once for the media hot path
+var (
+	rtpInPackets  = metrics.RTPPackets.With(metrics.DirectionIn)
+	rtpInBytes    = metrics.RTPBytes.With(metrics.DirectionIn)
+	rtpOutPackets = metrics.RTPPackets.With(metrics.DirectionOut)
+	rtpOutBytes   = metrics.RTPBytes.With(metrics.DirectionOut)
+)
+
 // Session represents an active call session
 type Session struct {
 	CallID       string
@@ -27,147 +50,626 @@ type Session struct {
 	ToUser       string
 	Route        *models.Route
 	WebSocketURL string
+	RemoteSDP    string
+
+	// INVITE transaction and the INVITE as received, which its responses are
+	// built from; answered is set once its final response was claimed, and
+	// answeredAt when a 2xx was sent (unix nanos)
+	tx         sip.ServerTransaction
+	txReq      *sip.Request
+	answered   atomic.Bool
+	answeredAt atomic.Int64
+
+	// SIP dialog (for requests toward the caller)
+	client    *sipgo.Client
+	inviteReq *sip.Request
+	answer    *sip.Response
+	localCSeq uint32
+
+	// Header rules applied to everything sent toward the caller
+	egressRules []models.HeaderRule
+
+	// Trunk the call came in from, if any
+	trunk *models.Trunk
+
+	// Concurrent call limits whose slots the call holds in Valkey
+	slots []string
+
+	// RTP: the local port offered in SDP (SIP calls only), whether RTCP
+	// shares it, and the transport carrying packets to and from the caller
+	rtpPort int
+	rtcpMux bool
+	media   MediaTransport
+
+	// Renegotiation by re-INVITE: RemoteSDP is replaced under sdpMu, our o=
+	// line keeps its session ID and gets a new version with each new answer,
+	// and whether the caller's side has the call on hold
+	sdpMu      sync.Mutex
+	sdpID      uint64
+	sdpVersion uint64
+	onHold     atomic.Bool
+
+	// Payload type the caller's offer maps telephone-event to, -1 when it
+	// offers none
+	eventPT atomic.Int32
+
+	// Session timer (RFC 4028) agreed by the INVITE and refreshes, and a
+	// signal restarting its clock when the caller's side refreshes
+	sessionTimer atomic.Pointer[SessionTimer]
+	timerRefresh chan struct{}
+
+	// Early media routes: whether the agent streams to the caller before the
+	// call is answered, and closed once the agent asks for the answer
+	earlyMedia atomic.Bool
+	answerNow  chan struct{}
+	answerOnce sync.Once
+
+	// Outbound RTP stream state
+	txMu        sync.Mutex
+	txSeq       uint16
+	txTimestamp uint32
+	txSSRC      uint32
+
+	// Paced outbound playout of agent audio
+	playoutMu     sync.Mutex
+	playoutBuf    []byte
+	playoutQueued bool
+	playoutMarks  []playoutMark
+
+	// Inbound jitter buffer
+	jitter *jitterBuffer
 
-	// SIP transaction
-	tx sip.ServerTransaction
+	// Silence auto-hangup: last caller speech or agent audio (unix nanos),
+	// the prompt played before hanging up, and how to end the call
+	lastActivity  atomic.Int64
+	silencePrompt []byte
+	hangup        func(cause string)
 
-	// RTP
-	rtpConn    *net.UDPConn
-	rtpPort    int
-	remoteAddr *net.UDPAddr
+	// Early media played from 183 Session Progress until the call is answered
+	ringbacks    *ringbackCache
+	ringback     []byte
+	ringbackStop chan struct{}
+	ringbackDone chan struct{}
 
-	// WebSocket connection to agent
+	// Conversion to and from the agent's audio format
+	agentAudio *agentAudio
+
+	// Answering machine detection result, for routes that connect the agent
+	// only once a human is detected
+	amdResult string
+
+	// Fax detection, on routes with a fax policy: the CNG detector run on
+	// caller audio, and whether the policy has been applied
+	cng         *cngDetector
+	faxDetected atomic.Bool
+
+	// Whether a transfer of the caller is under way or done
+	transferring atomic.Bool
+
+	// Optional stereo recording of both legs, kept in blob storage once
+	// finished
+	recorder *recorder
+	blobs    storage.Store
+
+	// DTMF (RFC 2833) de-duplication and generation
+	lastDTMFTimestamp uint32
+	dtmfSeen          bool
+
+	// Digits the caller pressed toward the route's DTMF shortcuts, and when
+	// the last one was
+	dtmfPressed   string
+	dtmfPressedAt time.Time
+	dtmfMu        sync.Mutex
+
+	// Agent URLs to try in order: load-balanced replicas, then fallbacks
+	agentURLs []string
+	resolver  *net.Resolver // Looks up agent hostnames
+
+	// Health of agent regions, fed by connections to regional agents, and
+	// how the route's regions were ranked for the call
+	regions        *regionHealth
+	regionDecision *models.RegionDecision
+
+	// Name of the route's agent split the call was assigned, if any
+	splitTarget string
+
+	// The account's allowed agent URL patterns, read on the first connect
+	// unless the account was set, and its branding
+	allowedAgentURLs []string
+	allowlistLoaded  bool
+	branding         *models.Branding
+
+	// WebSocket connection to agent, speaking the route's agent protocol
 	wsConn *websocket.Conn
 	wsMu   sync.Mutex
+	agent  agentCodec
+
+	// RTP headers kept for the call's trace, when SIP tracing is enabled
+	rtpTrace *rtpTracer
+
+	// Announcements injected toward the caller through the API, in order
+	announceMu    sync.Mutex
+	announcements []*announcement
+
+	// Supervisor legs listening in, whispering to the agent or barging in
+	supervisorsMu sync.Mutex
+	supervisors   []*supervisor
+
+	// Caller audio held while a dropped agent is reconnected
+	gapMu    sync.Mutex
+	inGap    bool
+	gapAudio []byte
 
 	// State
 	config     *config.Config
+	defaults   *routing.Defaults // Routing defaults changed at runtime
 	store      *store.PostgresStore
+	captures   *captureQueue       // Captured SIP messages, nil if disabled
+	events     *webhook.Dispatcher // Call event webhooks
+	stream     *eventstream.Broker // Real-time call event stream
+	acct       *accounting.Client  // RADIUS accounting, if configured
+	callLogID  string              // ID of the call's record, once created
+	log        *slog.Logger        // Tagged with call_id and account_id
 	closed     bool
 	closeMu    sync.Mutex
 	stopChan   chan struct{}
 	chunkCount int
+	createdAt  time.Time
+
+	// Conversation the call belongs to, linking it with related calls
+	conversationID string
+
+	// Summary sent to the agent at teardown: who hung up and why, when media
+	// started (unix nanos) and the traffic each way
+	hangupMu       sync.Mutex
+	hangupCause    string
+	hangupParty    string
+	mediaStarted   atomic.Int64
+	chunksSent     atomic.Int64 // Audio chunks sent to the agent
+	chunksReceived atomic.Int64 // Audio chunks received from the agent
+	packetsIn      atomic.Int64 // RTP packets from the caller
+	packetsOut     atomic.Int64 // RTP packets to the caller
+	bytesIn        atomic.Int64 // RTP bytes from the caller
+	bytesOut       atomic.Int64 // RTP bytes to the caller
+
+	// Media bitrate of the call each way, and its RTP bytes counted by route
+	// and trunk
+	bitrate       int
+	mediaBytesIn  *metrics.Counter
+	mediaBytesOut *metrics.Counter
+
+	// Live state for the active calls API: 180/183 sent, and the last
+	// packet from the caller and message from the agent (unix nanos)
+	ringing      atomic.Bool
+	lastRTP      atomic.Int64
+	lastAgentMsg atomic.Int64
+
+	// The call's goroutines running now, by name, for session snapshots
+	loopsMu sync.Mutex
+	loops   map[string]int
+}
+
+// SetTransaction stores the INVITE transaction and the INVITE as received,
+// for responding to it later
+func (s *Session) SetTransaction(tx sip.ServerTransaction, req *sip.Request) {
+	s.tx, s.txReq = tx, req
 }
 
-// SetTransaction stores the SIP transaction for later use
-func (s *Session) SetTransaction(tx sip.ServerTransaction) {
-	s.tx = tx
+// Transaction returns the INVITE transaction and the INVITE as received
+func (s *Session) Transaction() (sip.ServerTransaction, *sip.Request) {
+	return s.tx, s.txReq
+}
+
+// ClaimFinalResponse claims the right to send the INVITE's final response,
+// reporting false when it was claimed already: the answer (or rejection) of
+// the call and a CANCEL from the caller race, and only the first one counts
+func (s *Session) ClaimFinalResponse() bool {
+	return s.answered.CompareAndSwap(false, true)
 }
 
 // allocateRTPPorts allocates UDP ports for RTP
 func (s *Session) allocateRTPPorts() error {
-	// Find an available port in the configured range
-	for port := s.config.RTPPortMin; port <= s.config.RTPPortMax; port++ {
-		addr := &net.UDPAddr{
-			IP:   net.ParseIP("0.0.0.0"),
-			Port: port,
-		}
+	// Callers supporting rtcp-mux need a single port. Others get an even RTP
+	// port and the odd one above it for RTCP (RFC 3550), so their RTCP can't
+	// land on another call's RTP port.
+	s.rtcpMux = s.config.RTCPMux && offersRTCPMux(s.RemoteSDP)
+	first, step := s.config.RTPPortMin, 1
+	if !s.rtcpMux {
+		first += first % 2
+		step = 2
+	}
 
-		conn, err := net.ListenUDP("udp", addr)
+	// Find an available port in the configured range
+	for port := first; port <= s.config.RTPPortMax; port += step {
+		// On every address, IPv4 and IPv6
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
 		if err != nil {
 			continue // Port in use, try next
 		}
 
-		s.rtpConn = conn
+		var rtcp *net.UDPConn
+		if !s.rtcpMux {
+			if port+1 > s.config.RTPPortMax {
+				_ = conn.Close()
+				break
+			}
+			if rtcp, err = net.ListenUDP("udp", &net.UDPAddr{Port: port + 1}); err != nil {
+				_ = conn.Close()
+				continue
+			}
+		}
+
+		udp := newUDPTransport(conn, rtcp, s.symmetricRTP(), s.log)
+		if !udp.symmetric {
+			udp.Offer(s.offeredRTPAddr())
+		}
+		s.media = udp
 		s.rtpPort = port
-		s.StreamSID = uuid.New().String()
-		s.stopChan = make(chan struct{})
 
-		log.Printf("[Session] Allocated RTP port %d for call %s", port, s.CallID)
+		s.log.Debug("Allocated RTP port", "port", port, "rtcp_mux", s.rtcpMux)
 		return nil
 	}
 
 	return fmt.Errorf("no available RTP ports in range %d-%d", s.config.RTPPortMin, s.config.RTPPortMax)
 }
 
-// GenerateSDP generates an SDP answer for the call
+// symmetricRTP reports whether RTP goes back to where the caller's packets
+// come from, as it does unless the call's trunk turns latching off
+func (s *Session) symmetricRTP() bool {
+	return s.trunk == nil || s.trunk.SymmetricRTP
+}
+
+// GenerateSDP generates an SDP answer to the caller's latest offer: our
+// audio stream, in the direction matching the offer's, with any other stream
+// offered (video, ...) rejected. Telephone-events are only answered when
+// offered, on the offer's payload type.
 func (s *Session) GenerateSDP() string {
-	localIP := getLocalIP()
-
-	sdp := fmt.Sprintf(`v=0
-o=blayzen-sip %d %d IN IP4 %s
-s=blayzen-sip
-c=IN IP4 %s
-t=0 0
-m=audio %d RTP/AVP 0
-a=rtpmap:0 PCMU/8000
-a=ptime:20
-a=sendrecv
-`,
-		time.Now().Unix(),
-		time.Now().Unix(),
-		localIP,
-		localIP,
-		s.rtpPort,
+	localIP := s.mediaIP()
+
+	s.sdpMu.Lock()
+	offer := s.RemoteSDP
+	if s.sdpID == 0 {
+		s.sdpID = uint64(time.Now().Unix())
+		s.sdpVersion = s.sdpID
+	}
+	origin := sdp.Origin{
+		Username:       "blayzen-sip",
+		SessionID:      s.sdpID,
+		SessionVersion: s.sdpVersion,
+		Address:        localIP,
+	}
+	s.sdpMu.Unlock()
+
+	audio := &sdp.Media{
+		Type:       "audio",
+		Port:       s.rtpPort,
+		Proto:      "RTP/AVP",
+		Formats:    []string{strconv.Itoa(rtp.PayloadTypePCMU)},
+		Attributes: []sdp.Attribute{{Key: "rtpmap", Value: "0 PCMU/8000"}},
+	}
+	if pt := offeredEventPT(offer); pt >= 0 {
+		eventPT := strconv.Itoa(int(pt))
+		audio.Formats = append(audio.Formats, eventPT)
+		audio.Attributes = append(audio.Attributes,
+			sdp.Attribute{Key: "rtpmap", Value: eventPT + " telephone-event/8000"},
+			sdp.Attribute{Key: "fmtp", Value: eventPT + " " + offeredEventFmtp(offer, pt)},
+		)
+	}
+	audio.Attributes = append(audio.Attributes,
+		sdp.Attribute{Key: "ptime", Value: "20"},
+		sdp.Attribute{Key: answerDirection(offer)},
 	)
+	if s.rtcpMux {
+		audio.Attributes = append(audio.Attributes, sdp.Attribute{Key: "rtcp-mux"})
+	}
+
+	answer := &sdp.SessionDescription{
+		Origin:            origin,
+		SessionName:       s.sdpSessionName(),
+		ConnectionAddress: localIP,
+		Media:             answerMedia(offer, audio),
+	}
 
-	return sdp
+	return string(answer.Marshal())
 }
 
-// ConnectAgent establishes WebSocket connection to the Blayzen agent
+// ConnectAgent establishes WebSocket connection to the Blayzen agent,
+// retrying as the route's connect retry policy allows. Each failed attempt
+// is traced as an agent event of the call.
 func (s *Session) ConnectAgent(ctx context.Context) error {
-	log.Printf("[Session] Connecting to agent: %s", s.WebSocketURL)
+	attempts := 1
+	if s.Route.ConnectRetry != nil {
+		attempts = s.Route.ConnectRetry.Attempts
+	}
 
-	// Connect with timeout
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 10 * time.Second,
+	for attempt := 1; ; attempt++ {
+		conn, err := s.dialAgent(ctx)
+		if err == nil {
+			return s.startAgent(conn, false)
+		}
+		if attempt >= attempts || ctx.Err() != nil {
+			if attempts > 1 {
+				s.trace(eventstream.EventAgent, "", fmt.Sprintf("connect attempt %d/%d failed, giving up: %v", attempt, attempts, err))
+			}
+			return err
+		}
+
+		delay := connectRetryDelay(s.Route.ConnectRetry, attempt)
+		s.log.Warn("Agent connect attempt failed, retrying", "attempt", attempt, "attempts", attempts, "retry_in", delay, "error", err)
+		s.trace(eventstream.EventAgent, "", fmt.Sprintf("connect attempt %d/%d failed, retrying in %s: %v", attempt, attempts, delay, err))
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-s.stopChan:
+			return fmt.Errorf("call ended")
+		case <-time.After(delay):
+		}
 	}
+}
 
-	conn, _, err := dialer.DialContext(ctx, s.WebSocketURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to connect to agent: %w", err)
+// connectRetryDelay returns the backoff after failed connect attempt n
+// (from 1): the policy's backoff doubled per attempt before, plus jitter
+func connectRetryDelay(retry *models.ConnectRetry, n int) time.Duration {
+	backoff := time.Duration(retry.BackoffMs) * time.Millisecond << (n - 1)
+	if jitter := int64(backoff) * int64(retry.JitterPercent) / 100; jitter > 0 {
+		backoff += time.Duration(rand.Int63n(jitter + 1))
 	}
+	return backoff
+}
 
+// startAgent greets a newly connected agent and starts serving it. resume is
+// set when reconnecting mid-call.
+func (s *Session) startAgent(conn *websocket.Conn, resume bool) error {
+	s.wsMu.Lock()
+	if s.isClosed() {
+		s.wsMu.Unlock()
+		_ = conn.Close()
+		return fmt.Errorf("call ended")
+	}
 	s.wsConn = conn
+	s.wsMu.Unlock()
 
 	// Send connected message
-	connectedMsg := exotel.NewConnectedMessage()
-	if err := s.sendWSMessage(connectedMsg); err != nil {
+	if err := s.sendWSMessage(s.agent.Connected()); err != nil {
 		return fmt.Errorf("failed to send connected message: %w", err)
 	}
 
-	// Send start message with call metadata
-	startMsg := exotel.NewStartMessage(
-		s.StreamSID,
-		s.CallID,
-		s.Route.AccountID,
-		s.FromUser,
-		s.ToUser,
-	)
-
-	// Add custom data from route
-	if s.Route.CustomData != nil {
-		startMsg.CustomData = s.Route.CustomData
+	// Send start message with call metadata and custom data from route
+	customData := make(map[string]interface{}, len(s.Route.CustomData)+1)
+	for k, v := range s.Route.CustomData {
+		customData[k] = v
+	}
+	if s.Route.BinaryAudio {
+		customData[agentproto.CustomDataMediaFraming] = agentproto.FramingBinary
+	}
+	if s.amdResult != "" {
+		customData[agentproto.CustomDataAMDResult] = s.amdResult
+	}
+	if s.Route.Language != nil {
+		customData[agentproto.CustomDataLanguage] = *s.Route.Language
+	}
+	if resume {
+		customData[agentproto.CustomDataResume] = true
+	}
+	if s.conversationID != "" {
+		customData[agentproto.CustomDataConversationID] = s.conversationID
 	}
 
-	if err := s.sendWSMessage(startMsg); err != nil {
+	if err := s.sendWSMessage(s.agent.Start(s, customData)); err != nil {
 		return fmt.Errorf("failed to send start message: %w", err)
 	}
 
-	log.Printf("[Session] Agent connected for call %s", s.CallID)
+	s.log.Info("Agent connected")
+	s.trace(eventstream.EventAgent, "", "connected to "+s.WebSocketURL)
+
+	// Pongs, like any message, show the agent is still alive
+	conn.SetPongHandler(func(string) error {
+		s.extendAgentDeadline(conn)
+		return nil
+	})
 
-	// Start receiving agent responses
-	go s.receiveFromAgent()
+	// Start receiving agent responses and keep the connection alive
+	go s.receiveFromAgent(conn)
+	go s.pingAgent(conn)
 
 	return nil
 }
 
+// dialAgent tries the agent URLs in order, giving each
+// AgentConnectTimeout, and returns the first connection established
+func (s *Session) dialAgent(ctx context.Context) (*websocket.Conn, error) {
+	var errs []string
+	for _, agentURL := range s.agentURLs {
+		s.log.Info("Connecting to agent", "agent_url", agentURL)
+
+		conn, err := s.dialAgentURL(ctx, agentURL)
+		if err == nil {
+			if agentURL != s.WebSocketURL {
+				s.log.Warn("Failed over to agent", "agent_url", agentURL)
+				s.wsMu.Lock()
+				s.WebSocketURL = agentURL
+				s.wsMu.Unlock()
+				if err := s.store.SetCallWebSocketURL(ctx, s.CallID, agentURL, s.Route.AgentRegion(agentURL)); err != nil {
+					s.log.Error("Failed to update call agent URL", "error", err)
+				}
+			}
+			return conn, nil
+		}
+
+		s.log.Warn("Failed to connect to agent", "agent_url", agentURL, "error", err)
+		metrics.AgentConnectFailures.Inc()
+		errs = append(errs, err.Error())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("failed to connect to agent: %s", strings.Join(errs, "; "))
+}
+
+// dialAgentURL connects to a single agent URL
+func (s *Session) dialAgentURL(ctx context.Context, agentURL string) (*websocket.Conn, error) {
+	if err := s.checkAgentURL(ctx, agentURL); err != nil {
+		return nil, err
+	}
+
+	header, err := s.agentHeaders(agentURL)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := s.defaults.Get().AgentConnectTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialer := websocket.Dialer{
+		NetDialContext:   (&net.Dialer{Resolver: s.resolver}).DialContext,
+		HandshakeTimeout: timeout,
+	}
+	start := time.Now()
+	conn, _, err := dialer.DialContext(ctx, agentURL, header)
+	if region := s.Route.AgentRegion(agentURL); region != "" && s.regions != nil && ctx.Err() != context.Canceled {
+		s.regions.observe(region, time.Since(start), err)
+	}
+	return conn, err
+}
+
+// checkAgentURL refuses agent URLs the account doesn't allow. Routes are
+// checked when saved; this also covers screening re-routes and routes saved
+// before the allowlist changed. Calls on the default route have no account.
+func (s *Session) checkAgentURL(ctx context.Context, agentURL string) error {
+	if s.Route.AccountID == "" {
+		return nil
+	}
+	if !s.allowlistLoaded {
+		account, err := s.store.GetAccount(ctx, s.Route.AccountID)
+		if err != nil {
+			return fmt.Errorf("failed to read allowed agent URLs: %w", err)
+		}
+		s.allowedAgentURLs = account.AllowedAgentURLs
+		s.allowlistLoaded = true
+	}
+	return models.CheckAgentURL(s.allowedAgentURLs, agentURL)
+}
+
+// SetAccount sets the account of the call, read as it arrived: its branding
+// and allowed agent URLs
+func (s *Session) SetAccount(account *models.Account) {
+	s.allowedAgentURLs, s.allowlistLoaded = account.AllowedAgentURLs, true
+	s.branding = &account.Branding
+}
+
+// Branding returns the branding of the call's account, or nil when unknown
+func (s *Session) Branding() *models.Branding {
+	return s.branding
+}
+
+// sdpSessionName returns the session name of our SDP, the account's when
+// branded
+func (s *Session) sdpSessionName() string {
+	if s.branding != nil && s.branding.SessionName != "" {
+		return s.branding.SessionName
+	}
+	return "blayzen-sip"
+}
+
+// MarkRinging records that the caller has been sent 180 Ringing, or 183
+// Session Progress with ringback
+func (s *Session) MarkRinging() {
+	s.ringing.Store(true)
+	if err := s.store.UpdateCallStatus(context.Background(), s.CallID, models.CallStatusRinging); err != nil {
+		s.log.Error("Failed to update call status", "error", err)
+	}
+	s.notify(models.CallStatusRinging)
+}
+
+// notify sends the account's webhooks and event stream subscribers the
+// event for a call status
+func (s *Session) notify(status models.CallStatus) {
+	s.events.CallStatus(s.Route.AccountID, s.CallID, status)
+	s.stream.Publish(s.Route.AccountID, eventstream.Event{
+		Event:     models.WebhookEventForStatus(status),
+		ID:        s.callLogID,
+		CallID:    s.CallID,
+		Status:    status,
+		Timestamp: time.Now(),
+	})
+}
+
+// trace publishes a trace event of the call for streams following it live.
+// direction is empty for events neither received nor sent.
+func (s *Session) trace(event string, direction models.SIPMessageDirection, detail string) {
+	s.stream.Trace(s.Route.AccountID, eventstream.Event{
+		Event:     event,
+		ID:        s.callLogID,
+		CallID:    s.CallID,
+		Direction: string(direction),
+		Detail:    detail,
+		Timestamp: time.Now(),
+	})
+}
+
+// traceSIP publishes a SIP message of the call as a trace event
+func (s *Session) traceSIP(msg *models.SIPMessage) {
+	detail := msg.Method
+	if msg.StatusCode != nil {
+		detail = fmt.Sprintf("%d %s (%s)", *msg.StatusCode, *msg.Reason, msg.Method)
+	}
+	if msg.RemoteAddr != "" {
+		peer := "from"
+		if msg.Direction == models.SIPMessageOutbound {
+			peer = "to"
+		}
+		detail += " " + peer + " " + msg.RemoteAddr
+	}
+	s.trace(eventstream.EventSIP, msg.Direction, detail)
+}
+
 // StartMedia starts the media streaming between RTP and WebSocket
 func (s *Session) StartMedia() {
-	log.Printf("[Session] Starting media for call %s", s.CallID)
+	// ACKs of re-INVITEs find the media already started
+	if !s.mediaStarted.CompareAndSwap(0, time.Now().UnixNano()) {
+		return
+	}
+	s.log.Info("Starting media")
+	s.trace(eventstream.EventMedia, "", "started, caller at "+s.mediaRemoteAddr())
 
 	// Update call status
 	ctx := context.Background()
 	if err := s.store.UpdateCallStatus(ctx, s.CallID, models.CallStatusAnswered); err != nil {
-		log.Printf("[Session] Failed to update call status: %v", err)
+		s.log.Error("Failed to update call status", "error", err)
 	}
+	s.notify(models.CallStatusAnswered)
 
-	// Start RTP receiver
-	go s.receiveRTP()
+	s.startRecording()
+	s.startAccounting()
+
+	// Start RTP receiver, jitter-buffered forwarding and paced playout,
+	// unless already carrying early media
+	if !s.earlyMedia.Load() {
+		go s.receiveRTP()
+		go s.runPlayout()
+	}
+	go s.keepDialogAlive()
+	go s.runSessionTimer()
+
+	// Two-stage answer: the agent is connected once a human is detected
+	if s.Route.DetectHuman {
+		go s.detectHuman()
+		return
+	}
+
+	if !s.earlyMedia.Load() {
+		go s.forwardToAgent()
+	}
+	go s.monitorSilence()
 }
 
 // receiveRTP receives RTP packets and forwards to WebSocket
 func (s *Session) receiveRTP() {
+	defer s.running(loopReceiveRTP)()
+
 	buffer := make([]byte, 1500)
 
 	for {
@@ -177,46 +679,56 @@ func (s *Session) receiveRTP() {
 		default:
 		}
 
-		// Set read deadline
-		if err := s.rtpConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		n, err := s.media.ReadRTP(buffer, 100*time.Millisecond)
+		if err != nil {
+			if err != ErrMediaTimeout {
+				rtpReadErrors.log(s.log, slog.LevelError, "RTP read error", "error", err)
+			}
 			continue
 		}
 
-		n, addr, err := s.rtpConn.ReadFromUDP(buffer)
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue
-			}
-			log.Printf("[Session] RTP read error: %v", err)
+		// RTCP reports share the port on rtcp-mux calls and aren't used
+		if s.rtcpMux && rtp.IsRTCP(buffer[:n]) {
 			continue
 		}
 
-		// Store remote address for sending RTP back
-		if s.remoteAddr == nil {
-			s.remoteAddr = addr
-			log.Printf("[Session] Remote RTP address: %s", addr.String())
+		s.lastRTP.Store(time.Now().UnixNano())
+		rtpInPackets.Inc()
+		rtpInBytes.Add(float64(n))
+		s.packetsIn.Add(1)
+		s.bytesIn.Add(int64(n))
+		s.mediaBytesIn.Add(float64(n))
+		s.traceRTP(buffer[:n], true)
+
+		packet, err := rtp.Unmarshal(buffer[:n])
+		if err != nil {
+			continue
 		}
 
-		// Parse RTP header (12 bytes minimum)
-		if n < 12 {
+		// Telephone-events carry DTMF, not audio
+		if int32(packet.PayloadType) == s.eventPT.Load() {
+			s.handleTelephoneEvent(packet.Timestamp, packet.Payload)
 			continue
 		}
 
-		// Extract audio payload (skip RTP header)
-		payload := buffer[12:n]
+		// Reorder and de-jitter before forwarding to the agent
+		s.jitter.Push(packet.SequenceNumber, packet.Timestamp, packet.Payload, time.Now())
+	}
+}
 
-		// Send to agent via WebSocket
-		s.chunkCount++
-		msg := exotel.NewMediaMessage(s.StreamSID, payload, s.chunkCount, time.Now().UnixMilli())
+// receiveFromAgent receives messages from the WebSocket agent
+func (s *Session) receiveFromAgent(conn *websocket.Conn) {
+	defer s.running(loopReceiveFromAgent)()
 
-		if err := s.sendWSMessage(msg); err != nil {
-			log.Printf("[Session] Failed to send media: %v", err)
+	budget := &agentErrorBudget{limit: s.config.AgentErrorBudget}
+	reject := func(err error) bool {
+		if s.rejectAgentMessage(conn, budget, err) {
+			return true
 		}
+		s.agentLost(errAgentErrorBudget)
+		return false
 	}
-}
 
-// receiveFromAgent receives messages from the WebSocket agent
-func (s *Session) receiveFromAgent() {
 	for {
 		select {
 		case <-s.stopChan:
@@ -224,37 +736,99 @@ func (s *Session) receiveFromAgent() {
 		default:
 		}
 
-		_, data, err := s.wsConn.ReadMessage()
+		s.extendAgentDeadline(conn)
+		msgType, data, err := readAgentMessage(conn, s.config.AgentMaxMessageBytes)
+		var rejected *agentMessageError
+		if errors.As(err, &rejected) {
+			s.lastAgentMsg.Store(time.Now().UnixNano())
+			if !reject(err) {
+				return
+			}
+			continue
+		}
 		if err != nil {
+			// A connection replaced by a reroute is let go quietly
+			s.wsMu.Lock()
+			replaced := s.wsConn != conn
+			s.wsMu.Unlock()
+			if replaced {
+				return
+			}
+
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				log.Printf("[Session] WebSocket read error: %v", err)
+				s.log.Warn("WebSocket read error", "error", err)
 			}
+			s.agentLost(err)
 			return
 		}
+		s.lastAgentMsg.Store(time.Now().UnixNano())
 
-		msg, err := exotel.ParseMessage(data)
-		if err != nil {
-			log.Printf("[Session] Failed to parse agent message: %v", err)
+		// Binary messages carry raw audio frames
+		if msgType == websocket.BinaryMessage {
+			frame, err := agentproto.UnmarshalAudioFrame(data)
+			if err != nil {
+				if !reject(malformedMessage(fmt.Errorf("invalid audio frame: %w", err))) {
+					return
+				}
+				continue
+			}
+			s.markActivity()
+			s.chunksReceived.Add(1)
+			s.queuePlayout(s.agentAudio.ToCaller(frame.Payload))
 			continue
 		}
 
-		switch m := msg.(type) {
-		case *exotel.MediaMessage:
-			// Decode audio and send via RTP
-			audio, err := m.DecodeAudio()
-			if err != nil {
-				log.Printf("[Session] Failed to decode audio: %v", err)
-				continue
+		ev, err := s.agent.Decode(data)
+		if err == nil {
+			err = ev.validate()
+		}
+		if err != nil {
+			if !reject(err) {
+				return
 			}
-			s.sendRTP(audio)
+			continue
+		}
+		if ev.Event != exotel.EventMedia {
+			s.trace(eventstream.EventAgent, models.SIPMessageInbound, strings.TrimSpace(ev.Event+" "+ev.Digits+ev.Mark+ev.Target))
+		}
 
-		case *exotel.ClearMessage:
+		switch ev.Event {
+		case exotel.EventMedia:
+			// Convert and queue for paced RTP playout
+			s.markActivity()
+			s.chunksReceived.Add(1)
+			s.queuePlayout(s.agentAudio.ToCaller(ev.Audio))
+
+		case exotel.EventDTMF:
+			// Agent wants to send keypad digits to the caller
+			s.handleAgentDTMF(ev.Digits, ev.DurationMs)
+
+		case exotel.EventMark:
+			// Echoed back once the audio queued before it has played
+			s.queueMark(ev.Mark)
+
+		case exotel.EventClear:
 			// Clear audio buffer (for barge-in)
-			log.Printf("[Session] Clear buffer requested")
+			s.log.Debug("Clear buffer requested")
+			s.clearPlayout()
+
+		case agentproto.EventAnswer:
+			// Agent streaming early media wants the call answered
+			s.requestAnswer()
 
-		case *exotel.StopMessage:
+		case agentproto.EventTransfer:
+			// Agent wants the caller transferred; waiting for the far end
+			// mustn't hold up the agent's audio
+			go func() {
+				if err := s.Transfer(context.Background(), ev.Target, models.HangupPartyAgent); err != nil {
+					s.log.Warn("Agent transfer failed", "target", ev.Target, "error", err)
+				}
+			}()
+
+		case exotel.EventStop:
 			// Agent requested call end
-			log.Printf("[Session] Agent requested stop")
+			s.log.Info("Agent requested stop")
+			s.SetHangup(models.HangupCauseNormalClearing, models.HangupPartyAgent)
 			go s.Close()
 			return
 		}
@@ -262,28 +836,53 @@ func (s *Session) receiveFromAgent() {
 }
 
 // sendRTP sends audio data via RTP
-func (s *Session) sendRTP(payload []byte) {
-	if s.remoteAddr == nil || s.rtpConn == nil {
+func (s *Session) sendRTP(payload []byte, marker bool) {
+	s.txMu.Lock()
+	timestamp := s.txTimestamp
+	s.txTimestamp += uint32(len(payload)) // PCMU: one byte per sample
+	s.txMu.Unlock()
+
+	s.writeRTP(rtp.PayloadTypePCMU, marker, timestamp, payload)
+}
+
+// advanceTimestamp moves the RTP clock forward without sending audio
+func (s *Session) advanceTimestamp(samples int) {
+	s.txMu.Lock()
+	s.txTimestamp += uint32(samples)
+	s.txMu.Unlock()
+}
+
+// writeRTP sends a single RTP packet with the next sequence number. Nothing
+// is sent while the caller has the call on hold.
+func (s *Session) writeRTP(payloadType byte, marker bool, timestamp uint32, payload []byte) {
+	if s.media == nil || !s.media.Ready() || s.onHold.Load() {
 		return
 	}
 
-	// Build RTP packet
-	// Version: 2, Padding: 0, Extension: 0, CSRC count: 0
-	// Marker: 0, Payload type: 0 (PCMU)
-	rtpHeader := []byte{
-		0x80,                                        // Version 2, no padding, no extension, no CSRC
-		0x00,                                        // Marker 0, payload type 0 (PCMU)
-		byte(s.chunkCount >> 8), byte(s.chunkCount), // Sequence number
-		0x00, 0x00, 0x00, 0x00, // Timestamp (placeholder)
-		0x00, 0x00, 0x00, 0x01, // SSRC
-	}
+	s.txMu.Lock()
+	seq := s.txSeq
+	s.txSeq++
+	s.txMu.Unlock()
 
-	// Combine header and payload
-	packet := append(rtpHeader, payload...)
+	packet := (&rtp.Packet{
+		PayloadType:    payloadType,
+		Marker:         marker,
+		SequenceNumber: seq,
+		Timestamp:      timestamp,
+		SSRC:           s.txSSRC,
+		Payload:        payload,
+	}).Marshal()
 
-	if _, err := s.rtpConn.WriteToUDP(packet, s.remoteAddr); err != nil {
-		log.Printf("[Session] RTP write error: %v", err)
+	if err := s.media.WriteRTP(packet); err != nil {
+		rtpWriteErrors.log(s.log, slog.LevelError, "RTP write error", "error", err)
+		return
 	}
+	rtpOutPackets.Inc()
+	rtpOutBytes.Add(float64(len(packet)))
+	s.packetsOut.Add(1)
+	s.bytesOut.Add(int64(len(packet)))
+	s.mediaBytesOut.Add(float64(len(packet)))
+	s.traceRTP(packet, false)
 }
 
 // sendWSMessage sends a message to the WebSocket agent
@@ -295,7 +894,29 @@ func (s *Session) sendWSMessage(msg interface{}) error {
 		return fmt.Errorf("websocket not connected")
 	}
 
-	return s.wsConn.WriteJSON(msg)
+	s.setWriteDeadline()
+	if err := s.wsConn.WriteJSON(msg); err != nil {
+		metrics.WebSocketSendErrors.Inc()
+		return err
+	}
+	return nil
+}
+
+// sendWSBinary sends a binary message to the WebSocket agent
+func (s *Session) sendWSBinary(data []byte) error {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+
+	if s.wsConn == nil {
+		return fmt.Errorf("websocket not connected")
+	}
+
+	s.setWriteDeadline()
+	if err := s.wsConn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		metrics.WebSocketSendErrors.Inc()
+		return err
+	}
+	return nil
 }
 
 // Close closes the session and releases resources
@@ -308,28 +929,132 @@ func (s *Session) Close() {
 	s.closed = true
 	s.closeMu.Unlock()
 
-	log.Printf("[Session] Closing session: %s", s.CallID)
+	s.log.Info("Closing session")
 
 	// Signal stop
 	close(s.stopChan)
 
-	// Send stop message to agent
+	// Send the call summary and stop message to agent and close the WebSocket
+	s.wsMu.Lock()
 	if s.wsConn != nil {
-		stopMsg := exotel.NewStopMessage(s.StreamSID)
-		_ = s.sendWSMessage(stopMsg)
-
-		// Close WebSocket
-		s.wsMu.Lock()
+		s.setWriteDeadline()
+		_ = s.wsConn.WriteJSON(s.agent.Summary(s, s.summary()))
+		_ = s.wsConn.WriteJSON(s.agent.Stop(s))
 		_ = s.wsConn.Close()
 		s.wsConn = nil
-		s.wsMu.Unlock()
 	}
+	s.wsMu.Unlock()
+
+	// Close the media transport
+	if s.media != nil {
+		_ = s.media.Close()
+	}
+
+	s.closeSupervisors()
+	s.stopRecording()
+	s.stopAccounting()
+	s.saveRTPTrace()
+}
+
+// SetHangup records who ended the call and why, for the agent's call
+// summary. The first cause recorded wins.
+func (s *Session) SetHangup(cause, party string) {
+	s.hangupMu.Lock()
+	defer s.hangupMu.Unlock()
+
+	if s.hangupParty == "" {
+		s.hangupCause, s.hangupParty = cause, party
+	}
+}
+
+// HangupParty returns who ended the call, once recorded
+func (s *Session) HangupParty() string {
+	s.hangupMu.Lock()
+	defer s.hangupMu.Unlock()
+	return s.hangupParty
+}
+
+// summary describes the call as it ends
+func (s *Session) summary() agentproto.CallSummary {
+	now := time.Now()
+	summary := agentproto.CallSummary{
+		CallID:         s.CallID,
+		DurationMs:     now.Sub(s.createdAt).Milliseconds(),
+		ChunksSent:     s.chunksSent.Load(),
+		ChunksReceived: s.chunksReceived.Load(),
+	}
+	if started := s.mediaStarted.Load(); started != 0 {
+		summary.TalkMs = now.Sub(time.Unix(0, started)).Milliseconds()
+	}
+
+	s.hangupMu.Lock()
+	summary.HangupCause, summary.HangupParty = s.hangupCause, s.hangupParty
+	s.hangupMu.Unlock()
 
-	// Close RTP connection
-	if s.rtpConn != nil {
-		_ = s.rtpConn.Close()
-		s.rtpConn = nil
+	lost, late, jitterMs := s.jitter.Stats()
+	summary.Quality = agentproto.CallQuality{
+		PacketsReceived: s.packetsIn.Load(),
+		PacketsSent:     s.packetsOut.Load(),
+		PacketsLost:     lost,
+		PacketsLate:     late,
+		JitterMs:        math.Round(jitterMs*10) / 10,
 	}
+	return summary
+}
+
+// mediaIP returns the IP put in our SDP: the external IP when behind NAT,
+// else the local IP, of the caller's address family when it offers IPv6
+// and we have an IPv6 address
+func (s *Session) mediaIP() string {
+	if s.offersIPv6() {
+		if s.config.ExternalIP6 != "" {
+			return s.config.ExternalIP6
+		}
+		if ip := getLocalIP6(); ip != "" {
+			return ip
+		}
+	}
+	if s.config.ExternalIP != "" {
+		return s.config.ExternalIP
+	}
+	return getLocalIP()
+}
+
+// offersIPv6 reports whether the caller's latest offer has its audio at an
+// IPv6 address (c=IN IP6)
+func (s *Session) offersIPv6() bool {
+	return offerIPv6(s.remoteSDP())
+}
+
+// offerIPv6 reports whether an SDP offer's audio is at an IPv6 address
+func offerIPv6(body string) bool {
+	offer, err := sdp.Parse([]byte(body))
+	if err != nil {
+		return false
+	}
+	media := offer.FirstMedia("audio")
+	if media == nil {
+		return false
+	}
+	ip := net.ParseIP(offer.Address(media))
+	return ip != nil && ip.To4() == nil
+}
+
+// getLocalIP6 returns a global IPv6 address of this host, or "" if it has
+// none
+func getLocalIP6() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() == nil && ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP.String()
+		}
+	}
+
+	return ""
 }
 
 // getLocalIP returns the local IP address
@@ -0,0 +1,241 @@
+package call
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Which side refreshes the session, as named in Session-Expires relative to
+// the INVITE: the caller's side (uac) or us (uas)
+const (
+	RefresherUAC = "uac"
+	RefresherUAS = "uas"
+)
+
+// sessionExpiryMargin is the most time before a session expires that a
+// refresh still expected from the caller's side is given up on (RFC 4028)
+const sessionExpiryMargin = 32 * time.Second
+
+// ErrSessionIntervalTooSmall is returned for requests asking for a session
+// interval shorter than our Min-SE, which are answered with 422
+var ErrSessionIntervalTooSmall = errors.New("session interval too small")
+
+// SessionTimer is a session timer (RFC 4028) agreed with the caller's side:
+// the session expires unless refreshed within Interval. Required is set when
+// the caller's side supports timers, so answers require them.
+type SessionTimer struct {
+	Interval  time.Duration
+	Refresher string
+	Required  bool
+}
+
+// NegotiateSessionTimer agrees on the session timer of an INVITE, re-INVITE
+// or UPDATE from the caller's side, offering expires and accepting intervals
+// down to minSE. Calls get no session timer when expires is 0.
+func NegotiateSessionTimer(req *sip.Request, expires, minSE time.Duration) (*SessionTimer, error) {
+	if expires <= 0 {
+		return nil, nil
+	}
+
+	t := &SessionTimer{
+		Interval:  expires,
+		Refresher: RefresherUAS,
+		Required:  headerHasToken(req, "timer", "Supported", "k") || headerHasToken(req, "timer", "Require"),
+	}
+
+	// An interval asked for is kept unless too short; ours is only shortened
+	// down to the Min-SE the request carries
+	if value := headerValue(req, "Session-Expires", "x"); value != "" {
+		interval, params := parseSessionExpires(value)
+		if interval > 0 {
+			if interval < minSE {
+				return nil, ErrSessionIntervalTooSmall
+			}
+			t.Interval = min(t.Interval, interval)
+		}
+		// Only a caller's side supporting timers can refresh them
+		if refresher := params["refresher"]; t.Required && (refresher == RefresherUAC || refresher == RefresherUAS) {
+			t.Refresher = refresher
+		} else if t.Required && refresher == "" {
+			t.Refresher = RefresherUAC
+		}
+	}
+	if requestMin, _ := parseSessionExpires(headerValue(req, "Min-SE")); requestMin > t.Interval {
+		t.Interval = requestMin
+	}
+	return t, nil
+}
+
+// AddHeaders adds the session timer to a response
+func (t *SessionTimer) AddHeaders(resp *sip.Response) {
+	resp.AppendHeader(sip.NewHeader("Session-Expires", t.header(t.Refresher)))
+	resp.AppendHeader(sip.NewHeader("Supported", "timer"))
+	if t.Required {
+		resp.AppendHeader(sip.NewHeader("Require", "timer"))
+	}
+}
+
+// header returns the Session-Expires value of the timer, naming refresher
+func (t *SessionTimer) header(refresher string) string {
+	return fmt.Sprintf("%d;refresher=%s", int(t.Interval.Seconds()), refresher)
+}
+
+// SetSessionTimer sets the session timer agreed by the INVITE or a refresh
+// from the caller's side, restarting the session's clock
+func (s *Session) SetSessionTimer(t *SessionTimer) {
+	s.sessionTimer.Store(t)
+	select {
+	case s.timerRefresh <- struct{}{}:
+	default:
+	}
+}
+
+// runSessionTimer keeps the session timer until the call ends. When we are
+// the refresher, the caller's side is sent an UPDATE halfway through each
+// interval; otherwise a refresh is expected before the session expires. The
+// call is hung up when a refresh doesn't come, or ours goes unanswered or
+// finds the call gone (481 or 408).
+func (s *Session) runSessionTimer() {
+	defer s.running(loopSessionTimer)()
+
+	for {
+		t := s.sessionTimer.Load()
+		if t == nil || s.inviteReq == nil {
+			return
+		}
+		wait := t.Interval / 2
+		if t.Refresher == RefresherUAC {
+			wait = t.Interval - min(sessionExpiryMargin, t.Interval/3)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.stopChan:
+			timer.Stop()
+			return
+		case <-s.timerRefresh:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		if t.Refresher == RefresherUAC {
+			s.log.Warn("Session not refreshed, hanging up", "session_expires", t.Interval)
+			break
+		}
+		next, err := s.refreshSession(t)
+		if s.isClosed() {
+			return
+		}
+		if err != nil {
+			s.log.Warn("Session refresh failed, hanging up", "error", err)
+			break
+		}
+		// A refresh from the caller's side meanwhile takes precedence
+		s.sessionTimer.CompareAndSwap(t, next)
+	}
+
+	if s.hangup != nil {
+		s.hangup(models.HangupCauseSessionExpired)
+	}
+}
+
+// refreshSession refreshes the session with an UPDATE, returning the timer
+// the caller's side agreed to. A 422 is retried once with the interval it
+// asks for; other rejections leave the timer as it was, as the call is
+// still known to the caller's side.
+func (s *Session) refreshSession(t *SessionTimer) (*SessionTimer, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := s.newDialogRequest(sip.UPDATE)
+		if err != nil {
+			return nil, err
+		}
+		// In our UPDATE we are the uac
+		req.AppendHeader(sip.NewHeader("Session-Expires", t.header(RefresherUAC)))
+		req.AppendHeader(sip.NewHeader("Supported", "timer"))
+		if contact := s.answer.Contact(); contact != nil {
+			req.AppendHeader(contact.Clone())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), keepaliveTimeout)
+		res, err := s.doDialogRequest(ctx, req)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case res.IsSuccess():
+			s.log.Debug("Session refreshed", "session_expires", t.Interval)
+			next := *t
+			if value := headerValue(res, "Session-Expires", "x"); value != "" {
+				interval, params := parseSessionExpires(value)
+				if interval > 0 {
+					next.Interval = interval
+				}
+				// Refresher is relative to the UPDATE: uas is the caller's side
+				if params["refresher"] == RefresherUAS {
+					next.Refresher = RefresherUAC
+				}
+			}
+			return &next, nil
+		case res.StatusCode == sip.StatusCallTransactionDoesNotExists || res.StatusCode == sip.StatusRequestTimeout:
+			return nil, fmt.Errorf("UPDATE rejected: %d %s", res.StatusCode, res.Reason)
+		case res.StatusCode == 422 && attempt == 0:
+			if minSE, _ := parseSessionExpires(headerValue(res, "Min-SE")); minSE > t.Interval {
+				t = &SessionTimer{Interval: minSE, Refresher: t.Refresher, Required: t.Required}
+				continue
+			}
+		}
+		s.log.Info("Session refresh rejected", "status", res.StatusCode)
+		return t, nil
+	}
+}
+
+// parseSessionExpires parses a Session-Expires or Min-SE value: the interval
+// in seconds and its parameters
+func parseSessionExpires(value string) (time.Duration, map[string]string) {
+	parts := strings.Split(value, ";")
+	seconds, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || seconds <= 0 {
+		return 0, nil
+	}
+	params := make(map[string]string, len(parts)-1)
+	for _, p := range parts[1:] {
+		name, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+		params[strings.ToLower(name)] = strings.ToLower(strings.TrimSpace(val))
+	}
+	return time.Duration(seconds) * time.Second, params
+}
+
+// headerValue returns the value of the first of names found in msg, e.g. a
+// header and its compact form
+func headerValue(msg sip.Message, names ...string) string {
+	for _, name := range names {
+		if hdrs := msg.GetHeaders(name); len(hdrs) > 0 {
+			return hdrs[0].Value()
+		}
+	}
+	return ""
+}
+
+// headerHasToken reports whether any of the named headers of msg lists token
+func headerHasToken(msg sip.Message, token string, names ...string) bool {
+	for _, name := range names {
+		for _, h := range msg.GetHeaders(name) {
+			for _, t := range strings.Split(h.Value(), ",") {
+				if strings.EqualFold(strings.TrimSpace(t), token) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package call
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/audio"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// silenceCheckInterval is how often an answered call is checked for silence
+const silenceCheckInterval = 500 * time.Millisecond
+
+// Default silence prompt: two short 440Hz beeps
+const (
+	beepFrequency = 440
+	beepDuration  = 300 * time.Millisecond
+	beepGap       = 200 * time.Millisecond
+	beepAmplitude = 8000
+)
+
+// loadSilencePrompt returns the PCMU prompt played before a silence hangup
+// in a language, read from an 8kHz mono WAV file (16-bit PCM or mu-law), or
+// the default beeps when no file is configured. localized reports whether
+// the file has a version for the language.
+func (m *Manager) loadSilencePrompt(language string) (prompt []byte, localized bool, err error) {
+	if m.silenceName == "" {
+		return beepPrompt(), false, nil
+	}
+
+	data, localized, err := m.silenceFiles.read(m.silenceName, language)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read silence prompt: %w", err)
+	}
+	if prompt, err = wavToMulaw(data); err != nil {
+		return nil, false, fmt.Errorf("invalid silence prompt %s: %w", m.silenceName, err)
+	}
+	return prompt, localized, nil
+}
+
+// wavToMulaw extracts the audio of an 8kHz mono WAV file as PCMU
+func wavToMulaw(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+
+	var format, channels, bitsPerSample uint16
+	var sampleRate uint32
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("short fmt chunk")
+			}
+			format = binary.LittleEndian.Uint16(body[0:2])
+			channels = binary.LittleEndian.Uint16(body[2:4])
+			sampleRate = binary.LittleEndian.Uint32(body[4:8])
+			bitsPerSample = binary.LittleEndian.Uint16(body[14:16])
+
+		case "data":
+			if channels != 1 || sampleRate != 8000 {
+				return nil, fmt.Errorf("need 8kHz mono audio, got %dHz with %d channels", sampleRate, channels)
+			}
+			switch {
+			case format == 1 && bitsPerSample == 16:
+				return audio.SamplesToMulaw(audio.PCM16ToSamples(body)), nil
+			case format == 7 && bitsPerSample == 8:
+				return append([]byte(nil), body...), nil
+			}
+			return nil, fmt.Errorf("need 16-bit PCM or mu-law audio")
+		}
+
+		// Chunks are padded to an even size
+		pos += 8 + size + size%2
+	}
+	return nil, fmt.Errorf("no data chunk")
+}
+
+// beepPrompt synthesises the default prompt
+func beepPrompt() []byte {
+	beep := make([]int16, int(beepDuration.Seconds()*8000))
+	for i := range beep {
+		beep[i] = int16(beepAmplitude * math.Sin(2*math.Pi*beepFrequency*float64(i)/8000))
+	}
+	tone := audio.SamplesToMulaw(beep)
+	gap := bytes.Repeat([]byte{pcmuSilence}, int(beepGap.Seconds()*8000))
+
+	prompt := make([]byte, 0, 2*len(tone)+len(gap))
+	prompt = append(prompt, tone...)
+	prompt = append(prompt, gap...)
+	return append(prompt, tone...)
+}
+
+// markActivity records that the caller or agent just spoke
+func (s *Session) markActivity() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// isSpeech reports whether a frame of PCMU caller audio is loud enough to be
+// speech rather than line noise
+func (s *Session) isSpeech(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+
+	var sum int
+	for _, b := range payload {
+		sample := int(audio.DecodeMulaw(b))
+		if sample < 0 {
+			sample = -sample
+		}
+		sum += sample
+	}
+	return sum/len(payload) >= s.config.SilenceThreshold
+}
+
+// detectSpeech marks activity when a frame of caller audio is speech
+func (s *Session) detectSpeech(payload []byte) {
+	if s.config.SilenceTimeout > 0 && s.isSpeech(payload) {
+		s.markActivity()
+	}
+}
+
+// playoutPending reports whether agent audio is still queued for the caller
+func (s *Session) playoutPending() bool {
+	s.playoutMu.Lock()
+	defer s.playoutMu.Unlock()
+	return len(s.playoutBuf) > 0
+}
+
+// monitorSilence prompts the caller once neither side has spoken for the
+// silence timeout, and hangs up if the silence continues after the prompt
+func (s *Session) monitorSilence() {
+	defer s.running(loopSilence)()
+
+	timeout := s.config.SilenceTimeout
+	if timeout <= 0 || s.hangup == nil {
+		return
+	}
+
+	ticker := time.NewTicker(silenceCheckInterval)
+	defer ticker.Stop()
+
+	s.markActivity()
+	promptLength := time.Duration(len(s.silencePrompt)) * time.Second / 8000
+	var promptedAt time.Time
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		// Nobody is expected to speak while the call is on hold
+		if s.onHold.Load() {
+			s.markActivity()
+			promptedAt = time.Time{}
+			continue
+		}
+
+		now := time.Now()
+		last := time.Unix(0, s.lastActivity.Load())
+
+		if !promptedAt.IsZero() {
+			if last.After(promptedAt) {
+				s.log.Info("Activity resumed")
+				promptedAt = time.Time{}
+				continue
+			}
+			if now.Sub(promptedAt) >= promptLength+s.config.SilenceHangupDelay {
+				s.log.Info("Silence timeout")
+				s.hangup(models.HangupCauseSilenceTimeout)
+				return
+			}
+			continue
+		}
+
+		// Agent audio still playing out counts as the agent speaking
+		if s.playoutPending() {
+			s.markActivity()
+			continue
+		}
+
+		if now.Sub(last) >= timeout {
+			s.log.Info("No speech, prompting caller", "silence", timeout)
+			promptedAt = now
+			s.queuePlayout(s.silencePrompt)
+		}
+	}
+}
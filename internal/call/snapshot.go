@@ -0,0 +1,129 @@
+package call
+
+import (
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Names of the call's goroutines in session snapshots
+const (
+	loopReceiveRTP       = "receive_rtp"
+	loopPlayout          = "playout"
+	loopForwardToAgent   = "forward_to_agent"
+	loopReceiveFromAgent = "receive_from_agent"
+	loopPingAgent        = "ping_agent"
+	loopKeepalive        = "sip_keepalive"
+	loopSessionTimer     = "session_timer"
+	loopSilence          = "silence_monitor"
+	loopDetectHuman      = "detect_human"
+	loopRingback         = "ringback"
+)
+
+// running counts one of the call's goroutines as running until the returned
+// func is called, as in defer s.running(loopPlayout)()
+func (s *Session) running(name string) func() {
+	s.loopsMu.Lock()
+	if s.loops == nil {
+		s.loops = make(map[string]int)
+	}
+	s.loops[name]++
+	s.loopsMu.Unlock()
+
+	return func() {
+		s.loopsMu.Lock()
+		if s.loops[name]--; s.loops[name] == 0 {
+			delete(s.loops, name)
+		}
+		s.loopsMu.Unlock()
+	}
+}
+
+// SessionSnapshot returns the in-memory state of a call in progress on this
+// instance, by SIP Call-ID or call record ID, or nil when there is none
+func (m *Manager) SessionSnapshot(id string) *models.SessionSnapshot {
+	m.mu.RLock()
+	s := m.sessions[id]
+	if s == nil {
+		for _, session := range m.sessions {
+			if session.callLogID == id {
+				s = session
+				break
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	if s == nil {
+		return nil
+	}
+	return s.snapshot(m.config.InstanceID, time.Now())
+}
+
+// snapshot describes the session's state, beyond its live state
+func (s *Session) snapshot(instance string, now time.Time) *models.SessionSnapshot {
+	snap := &models.SessionSnapshot{
+		ActiveCall:     *s.activeCall(instance, now),
+		StreamSID:      s.StreamSID,
+		FinalResponse:  s.answered.Load(),
+		OnHold:         s.onHold.Load(),
+		EarlyMedia:     s.earlyMedia.Load(),
+		Transferring:   s.transferring.Load(),
+		FaxDetected:    s.faxDetected.Load(),
+		LocalRTPPort:   s.rtpPort,
+		RTCPMux:        s.rtcpMux,
+		MediaStartedAt: unixNanoTime(s.mediaStarted.Load()),
+		Goroutines:     make(map[string]int),
+	}
+	if s.inviteReq != nil {
+		snap.SIPRemoteAddr = s.inviteReq.Source()
+	}
+	if t := s.sessionTimer.Load(); t != nil {
+		snap.SessionTimerSeconds = int(t.Interval.Seconds())
+	}
+
+	s.closeMu.Lock()
+	snap.Closed = s.closed
+	s.closeMu.Unlock()
+
+	s.loopsMu.Lock()
+	for name, n := range s.loops {
+		snap.Goroutines[name] = n
+	}
+	s.loopsMu.Unlock()
+
+	s.wsMu.Lock()
+	if s.wsConn != nil {
+		snap.AgentRemoteAddr = s.wsConn.RemoteAddr().String()
+	}
+	s.wsMu.Unlock()
+
+	s.playoutMu.Lock()
+	snap.PlayoutBytes = len(s.playoutBuf)
+	snap.PlayoutMarks = len(s.playoutMarks)
+	s.playoutMu.Unlock()
+
+	s.jitter.mu.Lock()
+	snap.JitterPackets = len(s.jitter.packets)
+	s.jitter.mu.Unlock()
+	snap.JitterLost, snap.JitterLate, snap.JitterMs = s.jitter.Stats()
+
+	s.gapMu.Lock()
+	snap.AgentGap = s.inGap
+	snap.GapAudioBytes = len(s.gapAudio)
+	s.gapMu.Unlock()
+
+	s.announceMu.Lock()
+	snap.Announcements = len(s.announcements)
+	s.announceMu.Unlock()
+
+	s.supervisorsMu.Lock()
+	snap.Supervisors = len(s.supervisors)
+	s.supervisorsMu.Unlock()
+
+	s.hangupMu.Lock()
+	snap.HangupCause, snap.HangupParty = s.hangupCause, s.hangupParty
+	s.hangupMu.Unlock()
+
+	return snap
+}
@@ -0,0 +1,61 @@
+package call
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// sessionsExceeded returns why no more sessions can be held in memory, or ""
+// when they can. Callers must hold m.mu.
+func (m *Manager) sessionsExceeded() string {
+	if limit := m.config.MaxSessions; limit > 0 && len(m.sessions)+len(m.admitting) >= limit {
+		return fmt.Sprintf("session limit %d reached", limit)
+	}
+	return ""
+}
+
+// StaleSessions returns the SIP calls stuck in setup at now: those not
+// answered within SessionSetupTimeout, and those answered but whose 200 OK
+// wasn't ACKed within SessionAckTimeout
+func (m *Manager) StaleSessions(now time.Time) (unanswered, unacked []*Session) {
+	setupTimeout, ackTimeout := m.config.SessionSetupTimeout, m.config.SessionAckTimeout
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, s := range m.sessions {
+		if s.tx == nil {
+			continue // WebRTC calls are set up by the browser's connection
+		}
+		answeredAt := s.answeredAt.Load()
+		switch {
+		case !s.answered.Load():
+			if setupTimeout > 0 && now.Sub(s.createdAt) > setupTimeout {
+				unanswered = append(unanswered, s)
+			}
+		case answeredAt != 0 && s.mediaStarted.Load() == 0:
+			if ackTimeout > 0 && now.Sub(time.Unix(0, answeredAt)) > ackTimeout {
+				unacked = append(unacked, s)
+			}
+		}
+	}
+	return unanswered, unacked
+}
+
+// EndUnacknowledged hangs up a call whose 200 OK the caller never ACKed,
+// as RFC 3261 has a UAS do once its retransmissions time out
+func (m *Manager) EndUnacknowledged(callID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[callID]
+	if !ok || s.mediaStarted.Load() != 0 {
+		return
+	}
+	s.log.Warn("Hanging up call never acknowledged", "answered_at", time.Unix(0, s.answeredAt.Load()))
+	metrics.StaleSessions.With(metrics.StaleAck).Inc()
+	m.endCall(s, models.CallStatusFailed, models.HangupCauseAckTimeout)
+}
@@ -0,0 +1,332 @@
+package call
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/shiv6146/blayzen-sip/internal/audio"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Errors returned when supervising calls
+var (
+	ErrCallNotActive           = errors.New("call is not active on this instance")
+	ErrInvalidSupervisionToken = errors.New("invalid or expired supervision token")
+)
+
+// SupervisionTokenTTL is how long a supervisor has to connect after
+// reserving a leg
+const SupervisionTokenTTL = 30 * time.Second
+
+// Supervisor leg buffering
+const (
+	maxSupervisorAudio     = 8000 // 1s of PCMU waiting to be mixed; older audio is dropped
+	supervisorQueue        = 50   // Messages waiting for a slow supervisor; later ones are dropped
+	supervisorWriteTimeout = 5 * time.Second
+)
+
+// pendingSupervision is a supervisor leg reserved through the API, waiting
+// for the supervisor's WebSocket
+type pendingSupervision struct {
+	callID  string
+	mode    string
+	expires time.Time
+}
+
+// supervisorEvent is a control message on a supervisor's WebSocket. The
+// supervisor sends "mode" to switch modes; blayzen-sip sends "start",
+// "mode" to confirm a switch and "stop" when the call ends.
+type supervisorEvent struct {
+	Event  string `json:"event"`
+	CallID string `json:"call_id,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// supervisorMessage is a WebSocket message queued for a supervisor
+type supervisorMessage struct {
+	typ  int
+	data []byte
+}
+
+// supervisor is a supervisor leg on a call. It is sent the caller and agent
+// mixed, in 20ms PCMU frames, and its own PCMU audio is mixed toward the
+// agent (whisper) or both parties (barge).
+type supervisor struct {
+	id   string
+	conn *websocket.Conn
+	out  chan supervisorMessage // Closed when the leg is removed
+
+	mu       sync.Mutex
+	mode     string
+	caller   []byte // Caller audio not yet sent in a monitor frame
+	toAgent  []byte // Whisper and barge audio for the agent
+	toCaller []byte // Barge audio for the caller
+}
+
+// Supervise reserves a supervisor leg on an active call and returns the
+// token the supervisor connects with
+func (m *Manager) Supervise(callID, mode string) (string, error) {
+	if m.GetSession(callID) == nil {
+		return "", ErrCallNotActive
+	}
+
+	token := uuid.New().String()
+	now := time.Now()
+
+	m.supervisionMu.Lock()
+	defer m.supervisionMu.Unlock()
+	for t, p := range m.supervisions {
+		if now.After(p.expires) {
+			delete(m.supervisions, t)
+		}
+	}
+	m.supervisions[token] = pendingSupervision{callID: callID, mode: mode, expires: now.Add(SupervisionTokenTTL)}
+	return token, nil
+}
+
+// ClaimSupervision redeems a supervision token, once, for the session to
+// supervise and the mode reserved
+func (m *Manager) ClaimSupervision(token string) (*Session, string, error) {
+	m.supervisionMu.Lock()
+	p, ok := m.supervisions[token]
+	delete(m.supervisions, token)
+	m.supervisionMu.Unlock()
+
+	if !ok || time.Now().After(p.expires) {
+		return nil, "", ErrInvalidSupervisionToken
+	}
+	session := m.GetSession(p.callID)
+	if session == nil {
+		return nil, "", ErrCallNotActive
+	}
+	return session, p.mode, nil
+}
+
+// Supervise joins a supervisor's WebSocket to the call. It returns once the
+// leg is set up; the leg lasts until the supervisor disconnects or the call
+// ends.
+func (s *Session) Supervise(conn *websocket.Conn, mode string) error {
+	sup := &supervisor{
+		id:   uuid.New().String(),
+		conn: conn,
+		out:  make(chan supervisorMessage, supervisorQueue),
+		mode: mode,
+	}
+
+	s.supervisorsMu.Lock()
+	if s.isClosed() {
+		s.supervisorsMu.Unlock()
+		return ErrCallNotActive
+	}
+	s.supervisors = append(s.supervisors, sup)
+	sup.sendEvent(supervisorEvent{Event: "start", CallID: s.CallID, Mode: mode})
+	s.supervisorsMu.Unlock()
+
+	s.log.Info("Supervisor joined", "supervisor_id", sup.id, "mode", mode)
+
+	go sup.writeLoop()
+	go s.receiveFromSupervisor(sup)
+	return nil
+}
+
+// receiveFromSupervisor reads a supervisor's audio and mode switches until
+// it disconnects
+func (s *Session) receiveFromSupervisor(sup *supervisor) {
+	defer s.removeSupervisor(sup)
+
+	for {
+		typ, data, err := sup.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		switch typ {
+		case websocket.BinaryMessage:
+			sup.speak(data)
+		case websocket.TextMessage:
+			var event supervisorEvent
+			if err := json.Unmarshal(data, &event); err != nil || event.Event != "mode" {
+				continue
+			}
+			s.supervisorsMu.Lock()
+			if err := models.ValidateSupervisionMode(event.Mode); err != nil {
+				sup.sendEvent(supervisorEvent{Event: "error", Error: err.Error()})
+			} else {
+				sup.setMode(event.Mode)
+				sup.sendEvent(supervisorEvent{Event: "mode", Mode: event.Mode})
+				s.log.Info("Supervisor switched mode", "supervisor_id", sup.id, "mode", event.Mode)
+			}
+			s.supervisorsMu.Unlock()
+		}
+	}
+}
+
+// removeSupervisor ends a supervisor leg, once
+func (s *Session) removeSupervisor(sup *supervisor) {
+	s.supervisorsMu.Lock()
+	defer s.supervisorsMu.Unlock()
+
+	for i, other := range s.supervisors {
+		if other == sup {
+			s.supervisors = append(s.supervisors[:i], s.supervisors[i+1:]...)
+			close(sup.out)
+			s.log.Info("Supervisor left", "supervisor_id", sup.id)
+			return
+		}
+	}
+}
+
+// closeSupervisors tells supervisors the call has ended and disconnects them
+func (s *Session) closeSupervisors() {
+	s.supervisorsMu.Lock()
+	defer s.supervisorsMu.Unlock()
+
+	for _, sup := range s.supervisors {
+		sup.sendEvent(supervisorEvent{Event: "stop", CallID: s.CallID})
+		close(sup.out)
+	}
+	s.supervisors = nil
+}
+
+// superviseCaller queues a frame of caller audio for supervisors' monitor
+// frames, and returns it mixed with whisper and barge audio for the agent
+func (s *Session) superviseCaller(payload []byte) []byte {
+	s.supervisorsMu.Lock()
+	defer s.supervisorsMu.Unlock()
+
+	if len(s.supervisors) == 0 {
+		return payload
+	}
+
+	mixed := append([]byte(nil), payload...)
+	for _, sup := range s.supervisors {
+		sup.mu.Lock()
+		sup.caller = appendBounded(sup.caller, payload)
+		audio.MixMulaw(mixed, take(&sup.toAgent, len(mixed)))
+		sup.mu.Unlock()
+	}
+	return mixed
+}
+
+// superviseAgent sends supervisors a monitor frame of the agent's frame (nil
+// when the agent is silent) mixed with queued caller audio, and returns the
+// frame mixed with barge audio for the caller (nil when still silent)
+func (s *Session) superviseAgent(frame []byte) []byte {
+	s.supervisorsMu.Lock()
+	defer s.supervisorsMu.Unlock()
+
+	if len(s.supervisors) == 0 {
+		return frame
+	}
+
+	agentFrame := frame
+	if agentFrame == nil {
+		agentFrame = silenceFrame
+	}
+
+	var mixed []byte
+	for _, sup := range s.supervisors {
+		sup.mu.Lock()
+		monitor := append([]byte(nil), agentFrame...)
+		audio.MixMulaw(monitor, take(&sup.caller, playoutFrameSize))
+		barge := take(&sup.toCaller, playoutFrameSize)
+		sup.mu.Unlock()
+
+		sup.send(supervisorMessage{websocket.BinaryMessage, monitor})
+
+		if len(barge) > 0 {
+			if mixed == nil {
+				mixed = append([]byte(nil), agentFrame...)
+			}
+			audio.MixMulaw(mixed, barge)
+		}
+	}
+
+	if mixed == nil {
+		return frame
+	}
+	return mixed
+}
+
+// speak queues a supervisor's audio for the parties its mode reaches
+func (sup *supervisor) speak(payload []byte) {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+
+	switch sup.mode {
+	case models.SupervisionWhisper:
+		sup.toAgent = appendBounded(sup.toAgent, payload)
+	case models.SupervisionBarge:
+		sup.toAgent = appendBounded(sup.toAgent, payload)
+		sup.toCaller = appendBounded(sup.toCaller, payload)
+	}
+}
+
+// setMode switches a supervisor's mode, dropping audio no longer meant for
+// a party
+func (sup *supervisor) setMode(mode string) {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+
+	sup.mode = mode
+	if mode != models.SupervisionBarge {
+		sup.toCaller = nil
+	}
+	if mode == models.SupervisionListen {
+		sup.toAgent = nil
+	}
+}
+
+// sendEvent queues a control message. The caller holds the session's
+// supervisorsMu, so the leg can't be removed meanwhile.
+func (sup *supervisor) sendEvent(event supervisorEvent) {
+	data, _ := json.Marshal(event)
+	sup.send(supervisorMessage{websocket.TextMessage, data})
+}
+
+// send queues a message without blocking, dropping it when the supervisor
+// is too slow. The caller holds the session's supervisorsMu.
+func (sup *supervisor) send(msg supervisorMessage) {
+	select {
+	case sup.out <- msg:
+	default:
+	}
+}
+
+// writeLoop writes queued messages to the supervisor, then disconnects it
+// once the leg is removed
+func (sup *supervisor) writeLoop() {
+	defer sup.conn.Close()
+
+	for msg := range sup.out {
+		_ = sup.conn.SetWriteDeadline(time.Now().Add(supervisorWriteTimeout))
+		if err := sup.conn.WriteMessage(msg.typ, msg.data); err != nil {
+			_ = sup.conn.Close() // Ends the read loop, which removes the leg
+			for range sup.out {
+			}
+			return
+		}
+	}
+}
+
+// appendBounded appends audio to a supervisor buffer, dropping the oldest
+// audio past maxSupervisorAudio
+func appendBounded(buf, payload []byte) []byte {
+	buf = append(buf, payload...)
+	if over := len(buf) - maxSupervisorAudio; over > 0 {
+		buf = buf[over:]
+	}
+	return buf
+}
+
+// take removes and returns up to n bytes from the front of a buffer
+func take(buf *[]byte, n int) []byte {
+	chunk := (*buf)[:min(n, len(*buf))]
+	*buf = (*buf)[len(chunk):]
+	return chunk
+}
@@ -0,0 +1,111 @@
+package call
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/trace"
+)
+
+// rtpTracer keeps the headers of a call's first RTP packets, sent and
+// received, for the call's trace
+type rtpTracer struct {
+	mu      sync.Mutex
+	local   string
+	packets []trace.Packet
+	limit   int
+}
+
+// newRTPTracer returns a tracer when SIP tracing is enabled, nil otherwise
+func newRTPTracer(enabled bool, limit int) *rtpTracer {
+	if !enabled || limit <= 0 {
+		return nil
+	}
+	return &rtpTracer{limit: limit}
+}
+
+// traceRTP records an RTP packet to or from the caller
+func (s *Session) traceRTP(packet []byte, inbound bool) {
+	t := s.rtpTrace
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.packets) >= t.limit {
+		return
+	}
+	if t.local == "" {
+		t.local = net.JoinHostPort(getLocalIP(), strconv.Itoa(s.rtpPort))
+	}
+
+	src, dst := t.local, s.mediaRemoteAddr()
+	if inbound {
+		src, dst = dst, src
+	}
+	t.packets = append(t.packets, trace.Packet{
+		Kind:   trace.KindRTP,
+		Time:   time.Now(),
+		Src:    src,
+		Dst:    dst,
+		Data:   append([]byte(nil), trace.RTPHeader(packet)...),
+		Length: len(packet),
+	})
+}
+
+// mediaRemoteAddr returns the caller's media address, when the transport
+// knows it
+func (s *Session) mediaRemoteAddr() string {
+	if t, ok := s.media.(interface{ RemoteAddr() string }); ok {
+		return t.RemoteAddr()
+	}
+	return ""
+}
+
+// saveRTPTrace stores the traced RTP headers once the call has ended
+func (s *Session) saveRTPTrace() {
+	t := s.rtpTrace
+	if t == nil || s.store == nil {
+		return
+	}
+
+	t.mu.Lock()
+	packets := t.packets
+	t.packets = nil
+	t.mu.Unlock()
+	if len(packets) == 0 {
+		return
+	}
+
+	data, err := trace.Marshal(packets)
+	if err != nil {
+		s.log.Error("Failed to encode RTP trace", "error", err)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := s.store.SaveRTPTrace(ctx, s.CallID, data); err != nil {
+			s.log.Error("Failed to store RTP trace", "error", err)
+		}
+	}()
+}
+
+// The local IP SIP messages are traced as sent from or received on
+var (
+	localSIPIPOnce sync.Once
+	localSIPIP     string
+)
+
+// localSIPAddr returns the local SIP address for traces
+func localSIPAddr(port int) string {
+	localSIPIPOnce.Do(func() { localSIPIP = getLocalIP() })
+	return net.JoinHostPort(localSIPIP, strconv.Itoa(port))
+}
@@ -0,0 +1,79 @@
+package call
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// transferTimeout bounds the wait for the caller's side to accept a transfer
+const transferTimeout = 10 * time.Second
+
+// Errors returned when a call can't be transferred
+var (
+	ErrTransferUnsupported = errors.New("browser calls can't be transferred")
+	ErrTransferInProgress  = errors.New("call is already being transferred")
+)
+
+// Transfer moves the caller of an active call on this instance to target, a
+// phone number or SIP URI
+func (m *Manager) Transfer(ctx context.Context, callID, target string) error {
+	session := m.GetSession(callID)
+	if session == nil {
+		return ErrCallNotActive
+	}
+	return session.Transfer(ctx, target, models.HangupPartySystem)
+}
+
+// Transfer asks the caller's side to call target instead (blind transfer
+// with REFER). Once it accepts, the transfer is recorded on the call log and
+// the call hung up, with party (who asked for the transfer) as hangup party.
+func (s *Session) Transfer(ctx context.Context, target, party string) error {
+	if s.isClosed() {
+		return ErrCallNotActive
+	}
+	if s.mediaStarted.Load() == 0 {
+		return ErrCallNotAnswered
+	}
+	if s.inviteReq == nil {
+		return ErrTransferUnsupported
+	}
+	if err := models.ValidateTransferTarget(target); err != nil {
+		return err
+	}
+	if !s.transferring.CompareAndSwap(false, true) {
+		return ErrTransferInProgress
+	}
+
+	uri := transferURI(target, s.inviteReq.From().Address.Host)
+	s.log.Info("Transferring call", "target", uri, "party", party)
+
+	ctx, cancel := context.WithTimeout(ctx, transferTimeout)
+	defer cancel()
+	if err := s.Refer(ctx, s.conversationURI(uri)); err != nil {
+		s.transferring.Store(false)
+		return err
+	}
+
+	if err := s.store.SetCallTransfer(context.Background(), s.CallID, uri); err != nil {
+		s.log.Error("Failed to record transfer", "error", err)
+	}
+	s.SetHangup(models.HangupCauseTransferred, party)
+	if s.hangup != nil {
+		s.hangup(models.HangupCauseTransferred)
+	}
+	return nil
+}
+
+// transferURI returns the Refer-To URI of a transfer target. Phone numbers
+// are addressed to the caller's domain, which routes them as it would any
+// call it places.
+func transferURI(target, host string) string {
+	if strings.Contains(target, ":") {
+		return target
+	}
+	return "sip:" + target + "@" + host + ";user=phone"
+}
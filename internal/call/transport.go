@@ -0,0 +1,140 @@
+package call
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrMediaTimeout is returned by MediaTransport.ReadRTP when no packet
+// arrived within the timeout
+var ErrMediaTimeout = errors.New("media read timeout")
+
+// MediaTransport carries a call's RTP packets to and from the caller: UDP
+// for SIP calls, a WebRTC peer connection for browser calls
+type MediaTransport interface {
+	// ReadRTP reads one RTP packet into buf
+	ReadRTP(buf []byte, timeout time.Duration) (int, error)
+	// WriteRTP sends one RTP packet to the caller
+	WriteRTP(packet []byte) error
+	// Ready reports whether packets can be sent to the caller yet
+	Ready() bool
+	Close() error
+}
+
+// udpTransport is plain RTP over UDP. Packets are sent back to wherever the
+// caller's first packet came from (symmetric RTP), which gets through NAT,
+// unless latching is off, when they go to the address in the caller's SDP.
+type udpTransport struct {
+	conn      *net.UDPConn
+	rtcp      *net.UDPConn // The RTCP port of calls without rtcp-mux, drained
+	symmetric bool
+	log       *slog.Logger
+
+	mu      sync.RWMutex
+	remote  *net.UDPAddr
+	offered *net.UDPAddr // From the caller's SDP, used until remote is learned
+}
+
+// newUDPTransport wraps a bound RTP socket and, for calls without rtcp-mux,
+// the RTCP socket above it. RTCP reports aren't used, so they are read and
+// dropped to keep the port from answering with ICMP errors. Without
+// symmetric RTP, packets only ever go to the offered address.
+func newUDPTransport(conn, rtcp *net.UDPConn, symmetric bool, log *slog.Logger) *udpTransport {
+	if rtcp != nil {
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := rtcp.ReadFromUDP(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	return &udpTransport{conn: conn, rtcp: rtcp, symmetric: symmetric, log: log}
+}
+
+// ReadRTP reads one packet, learning the caller's address from the first
+func (t *udpTransport) ReadRTP(buf []byte, timeout time.Duration) (int, error) {
+	if err := t.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+
+	n, addr, err := t.conn.ReadFromUDP(buf)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return 0, ErrMediaTimeout
+		}
+		return 0, err
+	}
+
+	t.mu.Lock()
+	if t.remote == nil {
+		t.remote = addr
+		t.log.Debug("Remote RTP address", "addr", addr.String())
+	}
+	t.mu.Unlock()
+
+	return n, nil
+}
+
+// Offer sets the address packets are sent to before the caller has sent
+// any, as for early media
+func (t *udpTransport) Offer(addr *net.UDPAddr) {
+	t.mu.Lock()
+	t.offered = addr
+	t.mu.Unlock()
+}
+
+// Retarget sends packets to a new address from the caller's SDP, as when a
+// re-INVITE moves the caller's media, until a packet from the caller shows
+// where to send them again
+func (t *udpTransport) Retarget(addr *net.UDPAddr) {
+	t.mu.Lock()
+	t.offered = addr
+	t.remote = nil
+	t.mu.Unlock()
+}
+
+// WriteRTP sends a packet to the caller's address
+func (t *udpTransport) WriteRTP(packet []byte) error {
+	t.mu.RLock()
+	remote := t.remote
+	if remote == nil || (!t.symmetric && t.offered != nil) {
+		remote = t.offered
+	}
+	t.mu.RUnlock()
+
+	if remote == nil {
+		return nil
+	}
+	_, err := t.conn.WriteToUDP(packet, remote)
+	return err
+}
+
+// RemoteAddr returns the caller's address, or "" until it is known
+func (t *udpTransport) RemoteAddr() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.remote == nil {
+		return ""
+	}
+	return t.remote.String()
+}
+
+// Ready reports whether the caller's address is known
+func (t *udpTransport) Ready() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.remote != nil || t.offered != nil
+}
+
+// Close releases the RTP port, and the RTCP port if any
+func (t *udpTransport) Close() error {
+	if t.rtcp != nil {
+		_ = t.rtcp.Close()
+	}
+	return t.conn.Close()
+}
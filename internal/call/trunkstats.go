@@ -0,0 +1,40 @@
+package call
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+// Trunk returns the trunk the call came in from, or nil
+func (s *Session) Trunk() *models.Trunk {
+	return s.trunk
+}
+
+// RecordTrunkResponse counts a final response exchanged with a trunk: one
+// we sent to its request (inbound) or one it sent to ours (outbound)
+func (m *Manager) RecordTrunkResponse(trunkID string, direction models.CallDirection, method string, statusCode int) {
+	recordTrunkResponse(m.store, trunkID, direction, method, statusCode)
+}
+
+// recordTrunkResponse updates the trunk response metric and, without
+// blocking signaling, the trunk's stored stats
+func recordTrunkResponse(st *store.PostgresStore, trunkID string, direction models.CallDirection, method string, statusCode int) {
+	metrics.TrunkResponses.With(trunkID, string(direction), method, strconv.Itoa(statusCode)).Inc()
+	if st == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := st.RecordTrunkResponse(ctx, trunkID, direction, method, statusCode); err != nil {
+			logger.Warn("Failed to record trunk response", "trunk_id", trunkID, "status", statusCode, "error", err)
+		}
+	}()
+}
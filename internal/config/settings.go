@@ -0,0 +1,76 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Where a setting's value came from, highest precedence first
+const (
+	SourceEnv     = "env"     // The process environment
+	SourceDotEnv  = ".env"    // The .env file in the working directory
+	SourceProfile = "profile" // The CONFIG_PROFILE file
+	SourceDefault = "default" // Unset or invalid, so the built-in default
+)
+
+// Setting is a configuration variable as the instance runs with it. Secrets
+// are redacted.
+type Setting struct {
+	Key    string `json:"key" example:"SIP_PORT"`
+	Value  string `json:"value" example:"5060"`
+	Source string `json:"source" example:"env"`
+}
+
+// redacted replaces secret values in settings
+const redacted = "[redacted]"
+
+// reading tracks the variables read by the Load in progress: the source of
+// each variable set, and the settings read so far
+var reading struct {
+	origins  map[string]string
+	settings []Setting
+}
+
+// addOrigins marks variables from a file, unless set by a source loaded
+// earlier
+func addOrigins(vars map[string]string, source string) {
+	for key := range vars {
+		if _, ok := reading.origins[key]; !ok {
+			reading.origins[key] = source
+		}
+	}
+}
+
+// record notes a variable read by Load, with its value as used
+func record(key, value string, set bool) {
+	source := SourceDefault
+	if set {
+		source = reading.origins[key]
+		if source == "" {
+			source = SourceEnv
+		}
+	}
+	reading.settings = append(reading.settings, Setting{Key: key, Value: redact(key, value), Source: source})
+}
+
+// redact hides the value of secret variables, and passwords in URLs
+func redact(key, value string) string {
+	if value == "" {
+		return value
+	}
+	if strings.HasSuffix(key, "_KEY") || strings.Contains(key, "SECRET") || strings.Contains(key, "PASSWORD") {
+		return redacted
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+	}
+	return value
+}
+
+// Settings returns every configuration variable with its value and source,
+// in the order they are loaded, secrets redacted
+func (c *Config) Settings() []Setting {
+	return append([]Setting(nil), c.settings...)
+}
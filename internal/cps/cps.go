@@ -0,0 +1,215 @@
+// Package cps rate-limits new calls with token buckets, per source address
+// and per account, so a flood of INVITEs (e.g. a carrier's retransmission
+// storm) can't overwhelm route lookups and agents. Buckets are kept on each
+// instance. The limits can be changed through the admin API; changes are
+// saved in Postgres and picked up by the other instances within
+// refreshInterval.
+package cps
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+var logger = logging.Component("cps")
+
+// refreshInterval is how often limits changed on another instance are
+// picked up, and idle buckets forgotten
+const refreshInterval = 15 * time.Second
+
+// EffectiveLimits are the rate limits in use: the configured ones with the
+// runtime overrides applied. A rate of 0 doesn't limit.
+type EffectiveLimits struct {
+	PerSourceCPS    int `json:"per_source_cps" example:"10"`
+	PerSourceBurst  int `json:"per_source_burst" example:"20"`
+	PerAccountCPS   int `json:"per_account_cps" example:"5"`
+	PerAccountBurst int `json:"per_account_burst" example:"10"`
+}
+
+// bucket is a token bucket: a call takes a token, and tokens come back at
+// the limit's rate up to its burst
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // When the bucket is full again, after which it can go
+}
+
+// take takes a token if there is one, at rate tokens a second up to burst
+func (b *bucket) take(now time.Time, rate, burst int) bool {
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*float64(rate))
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / float64(rate) * float64(time.Second)))
+	return true
+}
+
+// Limiter rate-limits new calls
+type Limiter struct {
+	config *config.Config
+	store  *store.PostgresStore
+
+	overrides atomic.Pointer[models.CPSLimits]
+	effective atomic.Pointer[EffectiveLimits]
+
+	mu       sync.Mutex
+	sources  map[string]*bucket
+	accounts map[string]*bucket
+}
+
+// New creates the limiter, with the configured limits until the overrides
+// are loaded
+func New(cfg *config.Config, st *store.PostgresStore) *Limiter {
+	l := &Limiter{
+		config:   cfg,
+		store:    st,
+		sources:  make(map[string]*bucket),
+		accounts: make(map[string]*bucket),
+	}
+	l.apply(&models.CPSLimits{})
+	return l
+}
+
+// Get returns the limits in use
+func (l *Limiter) Get() *EffectiveLimits {
+	return l.effective.Load()
+}
+
+// Overrides returns the limits changed at runtime
+func (l *Limiter) Overrides() *models.CPSLimits {
+	return l.overrides.Load()
+}
+
+// AllowSource reports whether a new call from addr is within the per-source
+// limit, taking its token
+func (l *Limiter) AllowSource(addr string) bool {
+	e := l.Get()
+	return l.allow(l.sources, addr, e.PerSourceCPS, e.PerSourceBurst)
+}
+
+// AllowAccount reports whether a new call to the account is within its
+// limit, taking its token: the account's own calls per second, with as big
+// a burst, or the per-account limit
+func (l *Limiter) AllowAccount(account *models.Account) bool {
+	e := l.Get()
+	rate, burst := e.PerAccountCPS, e.PerAccountBurst
+	if account.CallsPerSecond != nil {
+		rate, burst = *account.CallsPerSecond, *account.CallsPerSecond
+	}
+	return l.allow(l.accounts, account.ID, rate, burst)
+}
+
+// allow takes a token from key's bucket among buckets, at rate calls a
+// second up to burst, one second's worth when 0
+func (l *Limiter) allow(buckets map[string]*bucket, key string, rate, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), last: now}
+		buckets[key] = b
+	}
+	return b.take(now, rate, burst)
+}
+
+// Load reads the overrides from the database
+func (l *Limiter) Load(ctx context.Context) error {
+	overrides, err := l.store.GetCPSLimits(ctx)
+	if err != nil {
+		return err
+	}
+	l.apply(overrides)
+	return nil
+}
+
+// Set validates and saves new overrides, replacing the previous ones, and
+// applies them on this instance at once
+func (l *Limiter) Set(ctx context.Context, overrides *models.CPSLimits) (*models.CPSLimits, error) {
+	if err := overrides.Validate(); err != nil {
+		return nil, err
+	}
+	saved, err := l.store.SetCPSLimits(ctx, overrides)
+	if err != nil {
+		return nil, err
+	}
+	l.apply(saved)
+	logger.Info("INVITE rate limits changed", "limits", l.Get())
+	return saved, nil
+}
+
+// Run reloads the overrides and forgets idle buckets periodically until ctx
+// is cancelled
+func (l *Limiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := l.Load(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to load INVITE rate limits", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.prune(now)
+		}
+	}
+}
+
+// prune forgets buckets full again, which would start out the same
+func (l *Limiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, buckets := range []map[string]*bucket{l.sources, l.accounts} {
+		for key, b := range buckets {
+			if now.After(b.full) {
+				delete(buckets, key)
+			}
+		}
+	}
+}
+
+// apply makes overrides and the limits they result in current
+func (l *Limiter) apply(overrides *models.CPSLimits) {
+	e := &EffectiveLimits{
+		PerSourceCPS:    l.config.CPSPerSource,
+		PerSourceBurst:  l.config.CPSPerSourceBurst,
+		PerAccountCPS:   l.config.CPSPerAccount,
+		PerAccountBurst: l.config.CPSPerAccountBurst,
+	}
+	if overrides.PerSourceCPS != nil {
+		e.PerSourceCPS = *overrides.PerSourceCPS
+	}
+	if overrides.PerSourceBurst != nil {
+		e.PerSourceBurst = *overrides.PerSourceBurst
+	}
+	if overrides.PerAccountCPS != nil {
+		e.PerAccountCPS = *overrides.PerAccountCPS
+	}
+	if overrides.PerAccountBurst != nil {
+		e.PerAccountBurst = *overrides.PerAccountBurst
+	}
+
+	l.overrides.Store(overrides)
+	l.effective.Store(e)
+}
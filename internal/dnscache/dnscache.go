@@ -0,0 +1,334 @@
+// Package dnscache caches DNS answers for agent WebSocket and SIP trunk
+// hostnames, so calls don't wait on the system resolver for every lookup and
+// keep resolving known hosts through a resolver outage
+package dnscache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Config configures a Cache
+type Config struct {
+	TTL         time.Duration // Overrides record TTLs when positive
+	NegativeTTL time.Duration // How long missing names and records are cached
+	MaxStale    time.Duration // How long expired answers are served while upstream fails
+	MaxEntries  int
+}
+
+// defaultMaxEntries bounds the cache. Agents and trunks are a handful of
+// hosts; the bound only matters for hostnames in callers' Contact headers.
+const defaultMaxEntries = 4096
+
+// Cache caches DNS responses by question. It sits between Go's resolver and
+// the nameservers from /etc/resolv.conf, so lookups keep their usual search
+// domains, /etc/hosts entries and A/AAAA handling.
+type Cache struct {
+	cfg    Config
+	dialer net.Dialer
+
+	mu      sync.Mutex
+	entries map[question]*entry
+}
+
+// question identifies a cached response
+type question struct {
+	name  string // Lowercased FQDN
+	typ   dnsmessage.Type
+	class dnsmessage.Class
+}
+
+// entry is a cached response
+type entry struct {
+	msg     []byte
+	expires time.Time
+}
+
+// New creates a DNS cache
+func New(cfg Config) *Cache {
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = defaultMaxEntries
+	}
+	return &Cache{
+		cfg:     cfg,
+		entries: make(map[question]*entry),
+	}
+}
+
+// NewFromConfig returns a resolver for agent and trunk hostnames: a caching
+// one, or the system resolver when DNS caching is disabled
+func NewFromConfig(cfg *config.Config) *net.Resolver {
+	if !cfg.DNSCacheEnabled {
+		return net.DefaultResolver
+	}
+	return New(Config{
+		TTL:         cfg.DNSCacheTTL,
+		NegativeTTL: cfg.DNSCacheNegativeTTL,
+		MaxStale:    cfg.DNSCacheMaxStale,
+	}).Resolver()
+}
+
+// Resolver returns a resolver whose DNS queries are answered from the cache
+func (c *Cache) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, server string) (net.Conn, error) {
+			return &conn{cache: c, network: network, server: server}, nil
+		},
+	}
+}
+
+// exchange answers a query from the cache, or from the server when the cached
+// answer is missing or expired. Expired answers are served for up to MaxStale
+// when the server can't be reached or fails.
+func (c *Cache) exchange(network, server string, query []byte, deadline time.Time) ([]byte, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	key := question{strings.ToLower(q.Name.String()), q.Type, q.Class}
+
+	now := time.Now()
+	c.mu.Lock()
+	cached := c.entries[key]
+	c.mu.Unlock()
+	if cached != nil && now.Before(cached.expires) {
+		return withID(cached.msg, header.ID), nil
+	}
+
+	resp, err := c.forward(network, server, query, deadline)
+	if err == nil {
+		var ttl time.Duration
+		var rcode dnsmessage.RCode
+		if ttl, rcode, err = c.ttl(resp); err == nil && rcode != dnsmessage.RCodeSuccess && rcode != dnsmessage.RCodeNameError {
+			err = fmt.Errorf("server failure: %v", rcode)
+		}
+		if err == nil {
+			if ttl > 0 {
+				c.put(key, &entry{msg: resp, expires: now.Add(ttl)})
+			}
+			return resp, nil
+		}
+	}
+
+	if cached != nil && now.Before(cached.expires.Add(c.cfg.MaxStale)) {
+		return withID(cached.msg, header.ID), nil
+	}
+	if resp != nil {
+		return resp, nil // Let the resolver handle the failure response
+	}
+	return nil, err
+}
+
+// ttl returns how long a response may be cached (0 if it mustn't be) and its
+// response code
+func (c *Cache) ttl(resp []byte) (time.Duration, dnsmessage.RCode, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(resp)
+	if err != nil {
+		return 0, 0, err
+	}
+	if header.RCode != dnsmessage.RCodeSuccess && header.RCode != dnsmessage.RCodeNameError {
+		return 0, header.RCode, nil
+	}
+	if header.Truncated {
+		return 0, header.RCode, nil // Retried over TCP
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0, 0, err
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if header.RCode == dnsmessage.RCodeNameError || len(answers) == 0 {
+		return c.cfg.NegativeTTL, header.RCode, nil
+	}
+	if c.cfg.TTL > 0 {
+		return c.cfg.TTL, header.RCode, nil
+	}
+
+	ttl := answers[0].Header.TTL
+	for _, a := range answers[1:] {
+		ttl = min(ttl, a.Header.TTL)
+	}
+	return time.Duration(ttl) * time.Second, header.RCode, nil
+}
+
+// put caches a response, making room by dropping expired answers. When the
+// cache is still full the response isn't cached.
+func (c *Cache) put(key question, e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.cfg.MaxEntries {
+		now := time.Now()
+		for k, old := range c.entries {
+			if now.After(old.expires.Add(c.cfg.MaxStale)) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.cfg.MaxEntries {
+			return
+		}
+	}
+	c.entries[key] = e
+}
+
+// forward sends a query to the nameserver over network (udp or tcp)
+func (c *Cache) forward(network, server string, query []byte, deadline time.Time) ([]byte, error) {
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	upstream, err := c.dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer upstream.Close()
+	if !deadline.IsZero() {
+		_ = upstream.SetDeadline(deadline)
+	}
+
+	id := binary.BigEndian.Uint16(query)
+	if _, ok := upstream.(net.PacketConn); ok {
+		if _, err := upstream.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		for {
+			n, err := upstream.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			if n >= 2 && binary.BigEndian.Uint16(buf) == id {
+				return bytes.Clone(buf[:n]), nil
+			}
+			// A late answer to an earlier query; keep waiting
+		}
+	}
+
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := upstream.Write(append(framed, query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(upstream, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(upstream, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// withID returns a copy of a cached response answering the query with id
+func withID(msg []byte, id uint16) []byte {
+	resp := bytes.Clone(msg)
+	binary.BigEndian.PutUint16(resp, id)
+	return resp
+}
+
+// conn is the connection Go's resolver sends its queries over. It isn't a
+// net.PacketConn, so queries and responses are framed with a 2-byte length
+// as over TCP, whatever network the resolver asked for.
+type conn struct {
+	cache   *Cache
+	network string
+	server  string
+
+	mu       sync.Mutex
+	deadline time.Time
+	in       bytes.Buffer // Queries written by the resolver
+	out      bytes.Buffer // Responses waiting to be read
+	closed   bool
+}
+
+var errClosed = errors.New("dnscache: connection closed")
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, errClosed
+	}
+
+	c.in.Write(b)
+	for c.in.Len() >= 2 {
+		n := int(binary.BigEndian.Uint16(c.in.Bytes()))
+		if c.in.Len() < 2+n {
+			break
+		}
+		query := bytes.Clone(c.in.Next(2 + n)[2:])
+
+		resp, err := c.cache.exchange(c.network, c.server, query, c.deadline)
+		if err != nil {
+			return 0, err
+		}
+		c.out.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp))))
+		c.out.Write(resp)
+	}
+	return len(b), nil
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, errClosed
+	}
+	if c.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.out.Read(b)
+}
+
+func (c *conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *conn) SetReadDeadline(time.Time) error    { return nil }
+func (c *conn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
+
+func (c *conn) LocalAddr() net.Addr  { return dnsAddr{c.network, "dnscache"} }
+func (c *conn) RemoteAddr() net.Addr { return dnsAddr{c.network, c.server} }
+
+// dnsAddr is a net.Addr for conn
+type dnsAddr struct {
+	network string
+	addr    string
+}
+
+func (a dnsAddr) Network() string { return a.network }
+func (a dnsAddr) String() string  { return a.addr }
@@ -0,0 +1,220 @@
+// Package eventstream pushes accounts' call state changes and active-call
+// counts to API clients as they happen, so dashboards needn't poll. With
+// Valkey configured, every instance's subscribers see every instance's calls.
+package eventstream
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+var logger = logging.Component("eventstream")
+
+// EventSnapshot is the event sent first on each stream, with the account's
+// active-call count
+const EventSnapshot = "snapshot"
+
+// Trace events describe a call's SIP messages, agent connection and messages,
+// and media as they happen. Only streams asking for them receive them.
+const (
+	EventSIP   = "sip"
+	EventAgent = "agent"
+	EventMedia = "media"
+)
+
+// Event is a call state change, a trace event or a snapshot
+type Event struct {
+	Event       string            `json:"event"`             // Webhook event name (e.g. call.answered), trace event or snapshot
+	ID          string            `json:"id,omitempty"`      // Call record ID, as in /api/v1/calls/{id}
+	CallID      string            `json:"call_id,omitempty"` // SIP Call-ID
+	Status      models.CallStatus `json:"status,omitempty"`
+	Direction   string            `json:"direction,omitempty"` // Trace events: in (received) or out (sent), when either
+	Detail      string            `json:"detail,omitempty"`    // Trace events: what happened, e.g. "200 OK (INVITE)"
+	ActiveCalls int               `json:"active_calls"`        // The account's calls in progress after the change
+	Timestamp   time.Time         `json:"timestamp"`
+}
+
+// IsTrace reports whether the event is a trace event
+func (e *Event) IsTrace() bool {
+	switch e.Event {
+	case EventSIP, EventAgent, EventMedia:
+		return true
+	}
+	return false
+}
+
+// Buffering, so slow counting or subscribers never hold up calls
+const (
+	publishQueue    = 1024 // Events waiting to be counted and fanned out
+	subscriberQueue = 64   // Events waiting for a subscriber to read them
+)
+
+// published is an event waiting to be counted (unless a trace event) and
+// fanned out
+type published struct {
+	accountID string
+	event     Event
+}
+
+// Broker fans call events out to subscribers. Events are dropped rather than
+// queued without bound when a subscriber or the broker falls behind.
+type Broker struct {
+	store *store.PostgresStore
+	cache *store.Cache // Shares events between instances when set
+	queue chan published
+
+	mu   sync.Mutex
+	subs map[string]map[chan Event]bool // By account, whether receiving trace events
+}
+
+// New creates a broker. cache may be nil, in which case subscribers only see
+// this instance's calls.
+func New(st *store.PostgresStore, cache *store.Cache) *Broker {
+	b := &Broker{
+		store: st,
+		cache: cache,
+		queue: make(chan published, publishQueue),
+		subs:  make(map[string]map[chan Event]bool),
+	}
+	go b.run()
+	if cache != nil {
+		go b.receive()
+	}
+	return b
+}
+
+// Publish sends subscribers an account's call event. The active-call count
+// is filled in before the event is fanned out.
+func (b *Broker) Publish(accountID string, event Event) {
+	if b == nil || accountID == "" {
+		return
+	}
+	select {
+	case b.queue <- published{accountID, event}:
+	default:
+		logger.Warn("Event stream queue full, dropping event", "account_id", accountID, "event", event.Event)
+	}
+}
+
+// Trace sends subscribers asking for them an account's trace event. Without
+// Valkey, events no local subscriber asks for are skipped.
+func (b *Broker) Trace(accountID string, event Event) {
+	if b == nil || accountID == "" || (b.cache == nil && !b.tracing(accountID)) {
+		return
+	}
+	select {
+	case b.queue <- published{accountID, event}:
+	default:
+		// Trace events are plentiful; dropping them isn't worth a warning
+	}
+}
+
+// tracing reports whether any local subscriber asks for an account's trace
+// events
+func (b *Broker) tracing(accountID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, trace := range b.subs[accountID] {
+		if trace {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveCalls returns how many of an account's calls are in progress
+func (b *Broker) ActiveCalls(ctx context.Context, accountID string) (int, error) {
+	return b.store.CountActiveCalls(ctx, accountID)
+}
+
+// Subscribe returns a channel receiving an account's events, with its trace
+// events when trace is set, and a function that ends the subscription
+func (b *Broker) Subscribe(accountID string, trace bool) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberQueue)
+
+	b.mu.Lock()
+	if b.subs[accountID] == nil {
+		b.subs[accountID] = make(map[chan Event]bool)
+	}
+	b.subs[accountID][ch] = trace
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[accountID], ch)
+		if len(b.subs[accountID]) == 0 {
+			delete(b.subs, accountID)
+		}
+	}
+}
+
+// run counts active calls for published events, in order, and fans them out
+// directly or through Valkey
+func (b *Broker) run() {
+	for p := range b.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if !p.event.IsTrace() {
+			count, err := b.ActiveCalls(ctx, p.accountID)
+			if err != nil {
+				logger.Warn("Failed to count active calls", "account_id", p.accountID, "error", err)
+			}
+			p.event.ActiveCalls = count
+		}
+
+		if b.cache == nil {
+			cancel()
+			b.deliver(p.accountID, p.event)
+			continue
+		}
+
+		payload, _ := json.Marshal(p.event)
+		if err := b.cache.PublishCallEvent(ctx, p.accountID, payload); err != nil {
+			logger.Warn("Failed to publish event, delivering locally", "account_id", p.accountID, "error", err)
+			b.deliver(p.accountID, p.event)
+		}
+		cancel()
+	}
+}
+
+// receive delivers events published by any instance, resubscribing after
+// Valkey errors
+func (b *Broker) receive() {
+	for {
+		err := b.cache.ReceiveCallEvents(context.Background(), func(accountID string, payload []byte) {
+			var event Event
+			if err := json.Unmarshal(payload, &event); err != nil {
+				logger.Warn("Ignoring malformed event", "account_id", accountID, "error", err)
+				return
+			}
+			b.deliver(accountID, event)
+		})
+		logger.Warn("Event subscription ended, resubscribing", "error", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// deliver passes an event to the account's subscribers, skipping any whose
+// queue is full and, for trace events, those not asking for them
+func (b *Broker) deliver(accountID string, event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	trace := event.IsTrace()
+	for ch, wantsTrace := range b.subs[accountID] {
+		if trace && !wantsTrace {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Package failover runs an instance as one of an active/standby pair for
+// single-site deployments. The active instance serves SIP and records a
+// heartbeat in Postgres; the standby watches it and takes over once it stops,
+// or when told to through the admin API (e.g. by a VRRP notify script). Hook
+// scripts run on taking over and stepping down, e.g. to move a virtual IP.
+package failover
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+var logger = logging.Component("failover")
+
+// Modes an instance runs in
+const (
+	ModeStandalone    = "standalone"
+	ModeActiveStandby = "active-standby"
+)
+
+// Roles of an instance in a pair
+const (
+	RoleActive  = "active"
+	RoleStandby = "standby"
+)
+
+// Events hook scripts are run for, passed as their argument
+const (
+	EventPromote = "promote"
+	EventDemote  = "demote"
+)
+
+// hookTimeout is how long a hook script may run
+const hookTimeout = 30 * time.Second
+
+// Status is an instance's part in its pair
+type Status struct {
+	Mode     string                `json:"mode" example:"active-standby"`
+	Instance string                `json:"instance" example:"sip-b"`
+	Role     string                `json:"role" example:"standby"`
+	Active   *models.FailoverState `json:"active,omitempty"` // The pair's active instance, as last recorded
+}
+
+// Pair is this instance's part in an active/standby pair. A standalone
+// instance is active from the start.
+type Pair struct {
+	config *config.Config
+	store  *store.PostgresStore
+
+	promote  chan struct{}
+	active   chan struct{}
+	lost     chan struct{}
+	isActive atomic.Bool
+	lostOnce sync.Once
+}
+
+// New creates the instance's part in its pair, as configured
+func New(cfg *config.Config, st *store.PostgresStore) (*Pair, error) {
+	switch cfg.HAMode {
+	case ModeStandalone:
+	case ModeActiveStandby:
+		if cfg.HAHeartbeatInterval <= 0 || cfg.HAFailoverTimeout < 2*cfg.HAHeartbeatInterval {
+			return nil, fmt.Errorf("invalid HA_FAILOVER_TIMEOUT %s: must be at least twice HA_HEARTBEAT_INTERVAL %s", cfg.HAFailoverTimeout, cfg.HAHeartbeatInterval)
+		}
+	default:
+		return nil, fmt.Errorf("invalid HA_MODE %q: must be %s or %s", cfg.HAMode, ModeStandalone, ModeActiveStandby)
+	}
+
+	p := &Pair{
+		config:  cfg,
+		store:   st,
+		promote: make(chan struct{}, 1),
+		active:  make(chan struct{}),
+		lost:    make(chan struct{}),
+	}
+	if !p.Enabled() {
+		p.isActive.Store(true)
+		close(p.active)
+	}
+	return p, nil
+}
+
+// Enabled reports whether the instance is one of an active/standby pair
+func (p *Pair) Enabled() bool {
+	return p.config.HAMode == ModeActiveStandby
+}
+
+// Active is closed once the instance is active
+func (p *Pair) Active() <-chan struct{} {
+	return p.active
+}
+
+// Lost is closed when the active instance steps down, another instance
+// having taken over or its heartbeat failing for too long. It should stop
+// serving SIP at once.
+func (p *Pair) Lost() <-chan struct{} {
+	return p.lost
+}
+
+// IsActive reports whether the instance is the active one
+func (p *Pair) IsActive() bool {
+	return p.isActive.Load()
+}
+
+// Promote makes the standby take over now, even while the active instance
+// still heartbeats; it steps down on its next heartbeat
+func (p *Pair) Promote() {
+	select {
+	case p.promote <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the instance's part in its pair
+func (p *Pair) Status(ctx context.Context) (*Status, error) {
+	st := &Status{Mode: p.config.HAMode, Instance: p.config.InstanceID, Role: RoleStandby}
+	if p.IsActive() {
+		st.Role = RoleActive
+	}
+	if !p.Enabled() {
+		return st, nil
+	}
+
+	active, err := p.store.GetFailoverState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st.Active = active
+	return st, nil
+}
+
+// Run stands by until the active instance's heartbeat is older than the
+// failover timeout, or until promoted, then takes over and heartbeats until
+// ctx is cancelled or another instance takes over
+func (p *Pair) Run(ctx context.Context) {
+	if !p.Enabled() {
+		return
+	}
+	instance := p.config.InstanceID
+	ticker := time.NewTicker(p.config.HAHeartbeatInterval)
+	defer ticker.Stop()
+
+	logger.Info("Standing by", "instance", instance)
+	for force := false; ; {
+		previous, claimed, err := p.store.ClaimActive(ctx, instance, p.config.HAFailoverTimeout, force)
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to claim the active role", "error", err)
+		}
+		if claimed {
+			p.takeOver(ctx, previous)
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.promote:
+			force = true
+		case <-ticker.C:
+			force = false
+		}
+	}
+
+	// Step down before the standby would consider our heartbeat stale
+	lastBeat := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.promote:
+			continue
+		case <-ticker.C:
+		}
+
+		beatCtx, cancel := context.WithTimeout(ctx, p.config.HAHeartbeatInterval)
+		ok, err := p.store.Heartbeat(beatCtx, instance)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err == nil && ok:
+			lastBeat = time.Now()
+			continue
+		case err == nil && !p.IsActive():
+			return // Released on shutdown
+		case err == nil:
+			logger.Error("Another instance took over, stepping down")
+		case time.Since(lastBeat) < p.config.HAFailoverTimeout-p.config.HAHeartbeatInterval:
+			logger.Warn("Failed to record heartbeat", "error", err)
+			continue
+		default:
+			logger.Error("Heartbeat failed for too long, stepping down", "error", err)
+		}
+		p.stepDown()
+		return
+	}
+}
+
+// Release hands the active role to the standby at once on shutdown, running
+// the demote script so it can take over the virtual IP
+func (p *Pair) Release(ctx context.Context) {
+	if !p.Enabled() || !p.isActive.CompareAndSwap(true, false) {
+		return
+	}
+	if err := p.store.ReleaseActive(ctx, p.config.InstanceID); err != nil {
+		logger.Warn("Failed to release the active role", "error", err)
+	}
+	p.runHook(ctx, p.config.HADemoteScript, EventDemote, p.config.InstanceID)
+	logger.Info("Released the active role")
+}
+
+// takeOver runs the promote script, then makes the instance active
+func (p *Pair) takeOver(ctx context.Context, previous string) {
+	if previous != "" && previous != p.config.InstanceID {
+		logger.Warn("Taking over as active instance", "previous", previous)
+	} else {
+		logger.Info("Became active instance")
+	}
+	p.runHook(ctx, p.config.HAPromoteScript, EventPromote, previous)
+	p.isActive.Store(true)
+	close(p.active)
+}
+
+// stepDown runs the demote script and signals that the instance is no
+// longer active
+func (p *Pair) stepDown() {
+	if !p.isActive.CompareAndSwap(true, false) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	p.runHook(ctx, p.config.HADemoteScript, EventDemote, p.config.InstanceID)
+	p.lostOnce.Do(func() { close(p.lost) })
+}
+
+// runHook runs a hook script, if configured, with the event as argument and
+// the instances involved in its environment. Failures are logged; the role
+// changes regardless.
+func (p *Pair) runHook(ctx context.Context, script, event, previous string) {
+	if script == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, script, event)
+	cmd.Env = append(os.Environ(),
+		"HA_EVENT="+event,
+		"HA_INSTANCE_ID="+p.config.InstanceID,
+		"HA_PREVIOUS_INSTANCE="+previous,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		logger.Error("Failover hook failed", "script", script, "event", event, "error", err, "output", strings.TrimSpace(string(output)))
+		return
+	}
+	logger.Info("Failover hook ran", "script", script, "event", event)
+}
@@ -0,0 +1,128 @@
+// Package leader runs singleton background jobs, such as trunk registration
+// and partition maintenance, on one instance at a time. Each job is guarded
+// by a Postgres advisory lock: the instance holding it runs the job and the
+// others stand by, taking over once the lock is released or its holder's
+// database connection is lost.
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+var logger = logging.Component("leader")
+
+// Elector runs jobs while this instance leads for them
+type Elector struct {
+	store    *store.PostgresStore
+	interval time.Duration // Between lock attempts, and checks while holding it
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	leading map[string]bool
+}
+
+// New creates an elector
+func New(st *store.PostgresStore, interval time.Duration) *Elector {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &Elector{
+		store:    st,
+		interval: interval,
+		leading:  make(map[string]bool),
+	}
+}
+
+// Go runs job in the background whenever this instance leads for name, until
+// ctx is cancelled. The job's context is cancelled when leadership is lost;
+// it should then return promptly.
+func (e *Elector) Go(ctx context.Context, name string, job func(ctx context.Context)) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.run(ctx, name, job)
+	}()
+}
+
+// Wait waits for jobs to stop after their context is cancelled
+func (e *Elector) Wait() {
+	e.wg.Wait()
+}
+
+// Leading reports whether this instance currently leads for name
+func (e *Elector) Leading(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading[name]
+}
+
+// run contends for name's lock until ctx is cancelled, running job while it
+// is held
+func (e *Elector) run(ctx context.Context, name string, job func(ctx context.Context)) {
+	log := logger.With("job", name)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		lock, err := e.store.TryLock(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			log.Warn("Failed to contend for leadership", "error", err)
+		}
+		if lock != nil {
+			e.lead(ctx, log, name, lock, ticker, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead runs job while the lock holds, then releases it
+func (e *Elector) lead(ctx context.Context, log *slog.Logger, name string, lock *store.Lock, ticker *time.Ticker, job func(ctx context.Context)) {
+	log.Info("Became leader")
+	e.setLeading(name, true)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		job(jobCtx)
+	}()
+
+	for held := true; held; {
+		select {
+		case <-ctx.Done():
+			held = false
+		case <-done:
+			held = false
+		case <-ticker.C:
+			checkCtx, cancelCheck := context.WithTimeout(ctx, e.interval)
+			if err := lock.Check(checkCtx); err != nil && ctx.Err() == nil {
+				log.Warn("Lost leadership", "error", err)
+				held = false
+			}
+			cancelCheck()
+		}
+	}
+
+	cancel()
+	<-done
+	lock.Release()
+	e.setLeading(name, false)
+	log.Info("Stepped down")
+}
+
+func (e *Elector) setLeading(name string, leading bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leading[name] = leading
+}
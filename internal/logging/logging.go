@@ -0,0 +1,74 @@
+// Package logging sets up structured logging with log/slog
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup installs the default logger, writing text or JSON (LOG_FORMAT) lines
+// at or above the given level (LOG_LEVEL) to stderr. Output of the standard
+// log package goes through it too, at info level.
+func Setup(level, format string) {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level)}
+
+	var handler slog.Handler
+	if strings.EqualFold(format, "json") {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// ParseLevel parses debug, info, warn or error, defaulting to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// Component returns a logger tagging lines with component=name. It logs
+// through whichever default logger is installed at the time, so packages can
+// create theirs before Setup runs.
+func Component(name string) *slog.Logger {
+	return slog.New(&defaultHandler{}).With("component", name)
+}
+
+// defaultHandler forwards to the current default handler, replaying the
+// attributes and groups added to it
+type defaultHandler struct {
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h *defaultHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (h *defaultHandler) Handle(ctx context.Context, r slog.Record) error {
+	handler := slog.Default().Handler()
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler.Handle(ctx, r)
+}
+
+func (h *defaultHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *defaultHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *defaultHandler) with(wrap func(slog.Handler) slog.Handler) slog.Handler {
+	return &defaultHandler{wrap: append(h.wrap[:len(h.wrap):len(h.wrap)], wrap)}
+}
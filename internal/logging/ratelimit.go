@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rate limit shared by all limiters, set by SetRateLimit
+var (
+	rateBurst    atomic.Int64
+	rateInterval atomic.Int64
+)
+
+func init() {
+	SetRateLimit(10, 10*time.Second)
+}
+
+// SetRateLimit sets how many lines each limiter lets through per interval
+// (LOG_RATE_LIMIT_BURST per LOG_RATE_LIMIT_INTERVAL). A burst of 0 disables
+// rate limiting.
+func SetRateLimit(burst int, interval time.Duration) {
+	rateBurst.Store(int64(burst))
+	rateInterval.Store(int64(interval))
+}
+
+// Limiter rate-limits one repetitive log line, such as a media-path error
+// that can repeat for every packet of every call during an outage. Lines past
+// the burst are dropped until the interval ends, and their count reported with
+// the next line let through. Limiters are shared across calls, so a storm
+// hitting many calls at once still logs a bounded number of lines.
+type Limiter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	logged      int64
+	suppressed  int
+}
+
+// Allow reports whether a line should be logged and, if so, how many were
+// suppressed since the last one that was
+func (l *Limiter) Allow() (ok bool, suppressed int) {
+	burst := rateBurst.Load()
+	if burst <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.windowStart) >= time.Duration(rateInterval.Load()) {
+		l.windowStart = now
+		l.logged = 0
+	}
+	if l.logged >= burst {
+		l.suppressed++
+		return false, 0
+	}
+
+	l.logged++
+	suppressed, l.suppressed = l.suppressed, 0
+	return true, suppressed
+}
@@ -0,0 +1,263 @@
+// Package metrics collects counters, gauges and histograms and serves them
+// in the Prometheus text exposition format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is anything that can write its samples
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+// register adds a metric to the exposition
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+// Handler serves all registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		registryMu.Lock()
+		metrics := append([]metric(nil), registry...)
+		registryMu.Unlock()
+
+		sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range metrics {
+			m.write(w)
+		}
+	})
+}
+
+// =============================================================================
+// Counters
+// =============================================================================
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Inc adds one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds a non-negative amount
+func (c *Counter) Add(v float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// value returns the current count
+func (c *Counter) value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu       sync.RWMutex
+	counters map[string]*Counter
+	values   map[string][]string
+}
+
+// NewCounterVec registers a counter family with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		counters:   make(map[string]*Counter),
+		values:     make(map[string][]string),
+	}
+	register(v)
+	return v
+}
+
+// NewCounter registers a counter without labels
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+// With returns the counter for the given label values, in label order.
+// Hot paths should keep the result rather than look it up each time.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.counters[key]
+	v.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.counters[key]; ok {
+		return c
+	}
+	c = &Counter{}
+	v.counters[key] = c
+	v.values[key] = labelValues
+	return c
+}
+
+func (v *CounterVec) name() string { return v.metricName }
+
+func (v *CounterVec) write(w io.Writer) {
+	writeHeader(w, v.metricName, v.help, "counter")
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	keys := make([]string, 0, len(v.counters))
+	for key := range v.counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, formatLabels(v.labels, v.values[key]), formatValue(v.counters[key].value()))
+	}
+}
+
+// =============================================================================
+// Gauges
+// =============================================================================
+
+// GaugeFunc is a gauge whose value is read when scraped
+type GaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge reporting fn's value
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatValue(g.fn()))
+}
+
+// =============================================================================
+// Histograms
+// =============================================================================
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	metricName string
+	help       string
+	buckets    []float64 // Upper bounds, ascending
+
+	mu     sync.Mutex
+	counts []uint64 // Per bucket, plus +Inf
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		metricName: name,
+		help:       help,
+		buckets:    buckets,
+		counts:     make([]uint64, len(buckets)+1),
+	}
+	register(h)
+	return h
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+}
+
+func (h *Histogram) name() string { return h.metricName }
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.metricName, formatValue(bound), cumulative)
+	}
+	cumulative += h.counts[len(h.buckets)]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.metricName, cumulative)
+	fmt.Fprintf(w, "%s_sum %s\n", h.metricName, formatValue(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.metricName, cumulative)
+}
+
+// =============================================================================
+// Exposition format
+// =============================================================================
+
+// writeHeader writes a metric's HELP and TYPE lines
+func writeHeader(w io.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels renders {name="value",...}, or "" without labels
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escape.Replace(value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatValue renders a sample value
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,135 @@
+package metrics
+
+// RTP directions, relative to the caller
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Route lookup results
+const (
+	RouteMatched   = "matched"
+	RouteUnmatched = "unmatched"
+)
+
+// Media-path error kinds
+const (
+	MediaErrorRTPRead         = "rtp_read"
+	MediaErrorRTPWrite        = "rtp_write"
+	MediaErrorAgentSend       = "agent_send"
+	MediaErrorAgentDecode     = "agent_decode"
+	MediaErrorPlayoutOverflow = "playout_overflow"
+)
+
+// What got a SIP source banned
+const (
+	BanUserAgent = "user_agent"
+	BanFailures  = "failures"
+	BanAdmin     = "admin"
+)
+
+// Concurrent call limits refusing calls
+const (
+	LimitServer    = "server"
+	LimitAccount   = "account"
+	LimitRoute     = "route"
+	LimitBandwidth = "bandwidth"
+	LimitSessions  = "sessions"
+)
+
+// Setup stages calls were abandoned in
+const (
+	StaleSetup = "setup" // Never answered
+	StaleAck   = "ack"   // Answered, never ACKed
+)
+
+// What maintenance windows did with their routes' calls
+const (
+	MaintenanceRejected  = "rejected"
+	MaintenanceAnnounced = "announced" // Rejected after the announcement
+	MaintenanceDiverted  = "diverted"
+)
+
+// Results of agent connections in a route's agent regions
+const (
+	RegionConnected = "connected"
+	RegionFailed    = "failed"
+)
+
+// Call state drift the reconciler repairs
+const (
+	DriftCacheStale   = "cache_stale"
+	DriftCacheMissing = "cache_missing"
+	DriftUnfinished   = "call_unfinished"
+)
+
+// INVITE rate limits refusing calls
+const (
+	RateLimitSource  = "source"
+	RateLimitAccount = "account"
+)
+
+// Metrics exported by blayzen-sip. Active calls are reported by a gauge
+// registered at startup.
+var (
+	Invites = NewCounterVec("blayzen_sip_invites_total",
+		"Inbound INVITEs by final response code", "code")
+	RouteLookups = NewCounterVec("blayzen_sip_route_lookups_total",
+		"Route lookups for inbound INVITEs by result", "result")
+	AgentConnectFailures = NewCounter("blayzen_sip_agent_connect_failures_total",
+		"Failed agent WebSocket connection attempts")
+	WebSocketSendErrors = NewCounter("blayzen_sip_websocket_send_errors_total",
+		"Failed writes to agent WebSockets")
+	MediaErrors = NewCounterVec("blayzen_sip_media_errors_total",
+		"Media-path errors by kind, including those whose log lines were rate limited", "kind")
+	LogLinesSuppressed = NewCounterVec("blayzen_sip_log_lines_suppressed_total",
+		"Media-path error log lines dropped by rate limiting, by error kind", "kind")
+	RTPPackets = NewCounterVec("blayzen_sip_rtp_packets_total",
+		"RTP packets received from (in) and sent to (out) callers", "direction")
+	RTPBytes = NewCounterVec("blayzen_sip_rtp_bytes_total",
+		"RTP bytes received from (in) and sent to (out) callers", "direction")
+	MediaBytes = NewCounterVec("blayzen_sip_media_bytes_total",
+		"RTP bytes received from (in) and sent to (out) callers by route and trunk (empty for calls not from a trunk)",
+		"route_id", "trunk_id", "direction")
+	TrunkResponses = NewCounterVec("blayzen_sip_trunk_responses_total",
+		"Final SIP responses exchanged with trunks by trunk, direction (inbound: sent by us, outbound: sent by the trunk), method and code",
+		"trunk_id", "direction", "method", "code")
+	TrunkRegistrations = NewCounterVec("blayzen_sip_trunk_registrations_total",
+		"Trunk REGISTER attempts, including refreshes, by trunk and result", "trunk_id", "result")
+	FaxCalls = NewCounterVec("blayzen_sip_fax_calls_total",
+		"Fax calls detected on routes with a fax policy, by detection source and policy", "source", "policy")
+	ACLRefused = NewCounterVec("blayzen_sip_acl_refused_total",
+		"Requests refused by the SIP access control list, dropped or answered 403, by method", "method")
+	ScannerBans = NewCounterVec("blayzen_sip_scanner_bans_total",
+		"SIP sources banned, by trigger: scanner User-Agent, too many failed requests or the admin API", "trigger")
+	BannedRequests = NewCounter("blayzen_sip_banned_requests_total",
+		"SIP requests dropped from banned sources")
+	InvitesRateLimited = NewCounterVec("blayzen_sip_invites_rate_limited_total",
+		"New INVITEs answered 503 for going over a calls-per-second limit, by limit: per source address or per account", "limit")
+	CallsLimited = NewCounterVec("blayzen_sip_calls_limited_total",
+		"Calls refused by a concurrent call limit: the server's (or its RTP ports), an account's or a route's, by an account's bandwidth limit or by the cap on sessions in memory", "limit")
+	StaleSessions = NewCounterVec("blayzen_sip_stale_sessions_total",
+		"Calls ended for stalling in setup, by stage: never answered, or answered but never ACKed", "stage")
+	MaintenanceCalls = NewCounterVec("blayzen_sip_maintenance_calls_total",
+		"Calls turned away by a route maintenance window, by action: rejected, rejected after the announcement, or diverted", "action")
+	AgentRegionConnects = NewCounterVec("blayzen_sip_agent_region_connects_total",
+		"Agent WebSocket connection attempts to routes' agent regions, by region and result", "region", "result")
+	SplitCalls = NewCounterVec("blayzen_sip_split_calls_total",
+		"Calls assigned a target of their route's agent splits, by route and target", "route_id", "target")
+	RequestsForwarded = NewCounterVec("blayzen_sip_requests_forwarded_total",
+		"In-dialog requests forwarded to the instance holding their call, by method", "method")
+	ForwardFailures = NewCounter("blayzen_sip_forward_failures_total",
+		"In-dialog requests that couldn't be forwarded to the instance holding their call, or got no response from it")
+	RouteCacheInvalidations = NewCounterVec("blayzen_sip_route_cache_invalidations_total",
+		"Route cache invalidations for changes to routes or trunks notified by the database, by table", "table")
+	ReconcileRepairs = NewCounterVec("blayzen_sip_reconcile_repairs_total",
+		"Call state drift repaired by the reconciler, by kind: stale or missing active call cache entries, or calls left in progress in the database", "kind")
+	SIPCaptureDropped = NewCounter("blayzen_sip_capture_dropped_total",
+		"Captured SIP messages dropped, not stored for call flows, because the capture queue was full")
+	CallSetupSeconds = NewHistogram("blayzen_sip_call_setup_seconds",
+		"Time from INVITE to 200 OK, including the agent connection",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+	CacheSetupSeconds = NewHistogram("blayzen_sip_cache_setup_seconds",
+		"Valkey round trips on the call setup path: route lookups and caching, round-robin counters and active call tracking",
+		[]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1})
+)
@@ -2,119 +2,1818 @@
 package models
 
 import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
 // Account represents a tenant/user account
 type Account struct {
+	ID                 string    `json:"id" db:"id"`
+	Name               string    `json:"name" db:"name"`
+	Active             bool      `json:"active" db:"active"`
+	Timezone           string    `json:"timezone" db:"timezone" example:"America/New_York"`                    // IANA name, used for API timestamps and reporting
+	AllowedAgentURLs   []string  `json:"allowed_agent_urls" db:"allowed_agent_urls" example:"*.mycompany.com"` // Patterns agent URLs must match; empty allows any
+	Branding           Branding  `json:"branding" db:"branding"`
+	MaxConcurrentCalls *int      `json:"max_concurrent_calls,omitempty" db:"max_concurrent_calls" example:"20"` // Calls in progress at once, on all instances; unlimited when unset
+	CallsPerSecond     *int      `json:"calls_per_second,omitempty" db:"calls_per_second" example:"5"`          // New calls per second on each instance, replacing the per-account limit
+	MaxBandwidthKbps   *int      `json:"max_bandwidth_kbps,omitempty" db:"max_bandwidth_kbps" example:"2000"`   // Media bandwidth of calls in progress, each way, on all instances; unlimited when unset
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Location returns the account's timezone, or UTC when unset or unknown
+func (a *Account) Location() *time.Location {
+	loc, err := LoadTimezone(a.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Localize converts the account's timestamps to loc
+func (a *Account) Localize(loc *time.Location) {
+	a.CreatedAt = a.CreatedAt.In(loc)
+	a.UpdatedAt = a.UpdatedAt.In(loc)
+}
+
+// Branding is what an account's callers see of the platform, for resellers
+// white-labeling it. Unset parts keep blayzen-sip's own.
+type Branding struct {
+	ReasonPhrases map[int]string    `json:"reason_phrases,omitempty"`                    // Reason phrases of rejections (400-699) by status
+	Headers       map[string]string `json:"headers,omitempty"`                           // Headers added to rejections, e.g. Server
+	SessionName   string            `json:"session_name,omitempty" example:"Acme Voice"` // SDP session name (s=) of answers
+}
+
+// maxBrandingLength is the longest a branded reason phrase, header value or
+// session name may be
+const maxBrandingLength = 128
+
+// brandingReservedHeaders can't be added to rejections, on top of the
+// protected headers: the caller matches the response to its call with them
+var brandingReservedHeaders = []string{"from", "f", "to", "t", "contact", "m", "content-type", "c"}
+
+// Validate checks the branding's statuses, header names and values
+func (b *Branding) Validate() error {
+	for status, phrase := range b.ReasonPhrases {
+		if status < 400 || status > 699 {
+			return fmt.Errorf("invalid reason phrase status %d: must be 400 to 699", status)
+		}
+		if err := validateBrandingText("reason phrase", phrase); err != nil {
+			return err
+		}
+	}
+	for name, value := range b.Headers {
+		lower := strings.ToLower(name)
+		if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if slices.Contains(protectedHeaders, lower) || slices.Contains(brandingReservedHeaders, lower) {
+			return fmt.Errorf("header %s cannot be added", name)
+		}
+		if err := validateBrandingText("header "+name, value); err != nil {
+			return err
+		}
+	}
+	if b.SessionName != "" {
+		return validateBrandingText("session name", b.SessionName)
+	}
+	return nil
+}
+
+// validateBrandingText checks that a branded text fits on its SIP or SDP line
+func validateBrandingText(name, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("%s must not be empty", name)
+	}
+	if len(text) > maxBrandingLength {
+		return fmt.Errorf("%s is longer than %d characters", name, maxBrandingLength)
+	}
+	if strings.ContainsFunc(text, unicode.IsControl) {
+		return fmt.Errorf("%s must not contain control characters", name)
+	}
+	return nil
+}
+
+// APIKey is a credential for an account's API access. Only a hash of the key
+// is stored, so the key itself is only returned when it is generated.
+type APIKey struct {
+	ID         string     `json:"id" db:"id"`
+	AccountID  string     `json:"account_id" db:"account_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix" example:"3f9a1c2e"` // First characters of the key
+	Key        string     `json:"key,omitempty" db:"-"`                  // Only when generated
+	Scopes     []string   `json:"scopes" db:"scopes" example:"read"`     // Empty for full access
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"` // To the minute
+}
+
+// API key scopes. A key without scopes has full access; each scope a key has
+// adds to what it may do.
+const (
+	APIKeyScopeRead  = "read"  // GET requests to any endpoint
+	APIKeyScopeCalls = "calls" // Any request to the calls API and event stream
+)
+
+// ValidateAPIKeyScopes checks that scopes are known
+func ValidateAPIKeyScopes(scopes []string) error {
+	for _, scope := range scopes {
+		switch scope {
+		case APIKeyScopeRead, APIKeyScopeCalls:
+		default:
+			return fmt.Errorf("unknown API key scope %q (must be %s or %s)", scope, APIKeyScopeRead, APIKeyScopeCalls)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the key's scopes allow a request to an API route
+func (k *APIKey) Allows(method, path string) bool {
+	return ScopesAllow(k.Scopes, method, path)
+}
+
+// NarrowScopes returns the scopes requested for a token issued for a key
+// with the given scopes, or the key's own when none are requested. A token
+// can't be given scopes its key doesn't have.
+func NarrowScopes(keyScopes, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return keyScopes, nil
+	}
+	if err := ValidateAPIKeyScopes(requested); err != nil {
+		return nil, err
+	}
+	if len(keyScopes) > 0 {
+		for _, scope := range requested {
+			if !slices.Contains(keyScopes, scope) {
+				return nil, fmt.Errorf("scope %q is not granted to the API key", scope)
+			}
+		}
+	}
+	return requested, nil
+}
+
+// ScopesAllow reports whether scopes allow a request to an API route. No
+// scopes allow everything.
+func ScopesAllow(scopes []string, method, path string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case APIKeyScopeRead:
+			if method == "GET" || method == "HEAD" {
+				return true
+			}
+		case APIKeyScopeCalls:
+			if path == "/api/v1/calls" || strings.HasPrefix(path, "/api/v1/calls/") || path == "/api/v1/events/stream" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Localize converts the key's timestamps to loc
+func (k *APIKey) Localize(loc *time.Location) {
+	k.CreatedAt = k.CreatedAt.In(loc)
+	k.ExpiresAt = localizeTime(k.ExpiresAt, loc)
+	k.RevokedAt = localizeTime(k.RevokedAt, loc)
+	k.LastUsedAt = localizeTime(k.LastUsedAt, loc)
+}
+
+// locationCache holds loaded timezones, keyed by IANA name
+var locationCache sync.Map
+
+// LoadTimezone loads an IANA timezone such as "Europe/Berlin", once. An
+// empty name is UTC.
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locationCache.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	locationCache.Store(name, loc)
+	return loc, nil
+}
+
+// agentURLPattern is a parsed allowed agent URL pattern:
+// [ws:// or wss://]host[:port], where the host may start with "*." to match
+// any subdomain
+type agentURLPattern struct {
+	scheme string // Any when empty
+	host   string // Lowercased; "*.example.com" matches subdomains only
+	port   string // Any when empty
+}
+
+// parseAgentURLPattern parses an allowed agent URL pattern
+func parseAgentURLPattern(pattern string) (agentURLPattern, error) {
+	var p agentURLPattern
+	rest := pattern
+	if scheme, after, ok := strings.Cut(rest, "://"); ok {
+		if scheme != "ws" && scheme != "wss" {
+			return p, fmt.Errorf("agent URL pattern %q: scheme must be ws or wss", pattern)
+		}
+		p.scheme, rest = scheme, after
+	}
+	if strings.ContainsAny(rest, "/?#") {
+		return p, fmt.Errorf("agent URL pattern %q: patterns match hosts, not paths", pattern)
+	}
+
+	p.host = rest
+	if host, port, err := net.SplitHostPort(rest); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return p, fmt.Errorf("agent URL pattern %q: invalid port", pattern)
+		}
+		p.host, p.port = host, port
+	}
+	p.host = strings.ToLower(strings.Trim(p.host, "[]"))
+
+	if p.host == "" || p.host == "*." || strings.Contains(strings.TrimPrefix(p.host, "*."), "*") {
+		return p, fmt.Errorf("agent URL pattern %q: host must be a name, IP or *.domain", pattern)
+	}
+	return p, nil
+}
+
+// matches reports whether a parsed agent URL is allowed by the pattern
+func (p agentURLPattern) matches(u *url.URL) bool {
+	if p.scheme != "" && u.Scheme != p.scheme {
+		return false
+	}
+	if p.port != "" {
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "wss" {
+				port = "443"
+			}
+		}
+		if port != p.port {
+			return false
+		}
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if domain, ok := strings.CutPrefix(p.host, "*."); ok {
+		return strings.HasSuffix(host, "."+domain)
+	}
+	return host == p.host
+}
+
+// ValidateAgentURLPatterns checks an account's allowed agent URL patterns
+func ValidateAgentURLPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := parseAgentURLPattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckAgentURL returns an error unless one of the allowed patterns matches
+// an agent URL. No patterns permit any URL.
+func CheckAgentURL(patterns []string, agentURL string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+	u, err := url.Parse(agentURL)
+	if err != nil {
+		return fmt.Errorf("invalid agent URL %q: %w", agentURL, err)
+	}
+	for _, pattern := range patterns {
+		p, err := parseAgentURLPattern(pattern)
+		if err == nil && p.matches(u) {
+			return nil
+		}
+	}
+	return fmt.Errorf("agent URL %q is not in the account's allowed agent URLs", agentURL)
+}
+
+// localizeTime converts an optional timestamp to loc
+func localizeTime(t *time.Time, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := t.In(loc)
+	return &local
+}
+
+// Route represents an inbound SIP routing rule
+type Route struct {
+	ID                    string                 `json:"id" db:"id"`
+	AccountID             string                 `json:"account_id" db:"account_id"`
+	Name                  string                 `json:"name" db:"name"`
+	Priority              int                    `json:"priority" db:"priority"`
+	MatchToUser           *string                `json:"match_to_user,omitempty" db:"match_to_user"`
+	MatchFromUser         *string                `json:"match_from_user,omitempty" db:"match_from_user"`
+	MatchToUserType       string                 `json:"match_to_user_type" db:"match_to_user_type"`
+	MatchFromUserType     string                 `json:"match_from_user_type" db:"match_from_user_type"`
+	MatchSIPHeader        *string                `json:"match_sip_header,omitempty" db:"match_sip_header"`
+	MatchSIPHeaderValue   *string                `json:"match_sip_header_value,omitempty" db:"match_sip_header_value"`
+	MatchHeaders          []HeaderCondition      `json:"match_headers,omitempty" db:"match_headers"`
+	MatchGroups           []MatchGroup           `json:"match_groups,omitempty" db:"match_groups"`
+	WebSocketURL          string                 `json:"websocket_url" db:"websocket_url"`
+	AgentURLs             []string               `json:"agent_urls,omitempty" db:"agent_urls"`
+	AgentLBStrategy       string                 `json:"agent_lb_strategy" db:"agent_lb_strategy"`
+	FallbackWebSocketURLs []string               `json:"fallback_websocket_urls,omitempty" db:"fallback_websocket_urls"`
+	CustomData            map[string]interface{} `json:"custom_data,omitempty" db:"custom_data" swaggertype:"object"`
+	AudioFormat           *AudioFormat           `json:"audio_format,omitempty" db:"audio_format"`
+	AgentProtocol         string                 `json:"agent_protocol" db:"agent_protocol"`
+	CallPriority          int                    `json:"call_priority" db:"call_priority"`
+	BinaryAudio           bool                   `json:"binary_audio" db:"binary_audio"`
+	AgentAuth             *AgentAuth             `json:"agent_auth,omitempty" db:"agent_auth"`
+	DetectHuman           bool                   `json:"detect_human" db:"detect_human"`
+	Record                bool                   `json:"record" db:"record"`
+	HeaderRules           []HeaderRule           `json:"header_rules,omitempty" db:"header_rules"`
+	Ringback              *string                `json:"ringback,omitempty" db:"ringback"` // Early media played until answer (see ParseRingback)
+	FaxPolicy             string                 `json:"fax_policy" db:"fax_policy"`
+	FaxTarget             *string                `json:"fax_target,omitempty" db:"fax_target"`                     // SIP URI fax calls are diverted to
+	Language              *string                `json:"language,omitempty" db:"language"`                         // Callers' language, BCP 47 (see ValidateLanguage)
+	EarlyMedia            bool                   `json:"early_media" db:"early_media"`                             // Agent streams before answer, until it sends answer
+	ConnectRetry          *ConnectRetry          `json:"connect_retry,omitempty" db:"connect_retry"`               // Agent connect retries before the call is rejected
+	MaxConcurrentCalls    *int                   `json:"max_concurrent_calls,omitempty" db:"max_concurrent_calls"` // Calls in progress at once, on all instances; unlimited when unset
+	MaxBitrateKbps        *int                   `json:"max_bitrate_kbps,omitempty" db:"max_bitrate_kbps"`         // Media bitrate of each call, each way; calls needing more are refused
+	DTMFShortcuts         []DTMFShortcut         `json:"dtmf_shortcuts,omitempty" db:"dtmf_shortcuts"`             // Actions callers trigger with keypad digits mid-call
+	Schedule              *RouteSchedule         `json:"schedule,omitempty" db:"schedule"`                         // When the route takes calls; always when unset
+	AgentRegions          []AgentRegionPool      `json:"agent_regions,omitempty" db:"agent_regions"`               // Agents by region, tried before the route's own
+	AgentSplits           []AgentSplit           `json:"agent_splits,omitempty" db:"agent_splits"`                 // Weighted agents sharing the calls, tried first
+	SplitStrategy         string                 `json:"split_strategy,omitempty" db:"split_strategy"`             // How calls are assigned to agent_splits
+	Active                bool                   `json:"active" db:"active"`
+	CreatedAt             time.Time              `json:"created_at" db:"created_at"`
+	UpdatedAt             time.Time              `json:"updated_at" db:"updated_at"`
+}
+
+// Ringback spec prefixes: a tone, or an audio file from RINGBACK_DIR
+const (
+	RingbackTonePrefix = "tone:"
+	RingbackFilePrefix = "file:"
+)
+
+// RingbackTone is a ringback cadence: one or two summed frequencies, switched
+// on and off in a repeating pattern
+type RingbackTone struct {
+	Frequencies []int           // Hz
+	Cadence     []time.Duration // Alternating on and off periods, starting on
+}
+
+// ringbackTones are national ringback tones, by name
+var ringbackTones = map[string]string{
+	"us": "440+480/2000/4000",
+	"uk": "400+450/400/200/400/2000",
+	"eu": "425/1000/4000",
+	"au": "400+425/400/200/400/2000",
+	"in": "400+450/400/200/400/2000",
+	"jp": "400/1000/2000",
+}
+
+// ParseRingback parses a route's ringback spec: "tone:" followed by a
+// country (us, uk, eu, au, in, jp) or a custom cadence such as
+// "440+480/2000/4000" (frequencies, then on and off milliseconds), or
+// "file:" followed by the name of an 8kHz mono WAV file. It returns the tone,
+// or the file name.
+func ParseRingback(spec string) (*RingbackTone, string, error) {
+	if name, ok := strings.CutPrefix(spec, RingbackFilePrefix); ok {
+		if name == "" || name != path.Base(name) || strings.ContainsRune(name, '\\') || strings.HasPrefix(name, ".") {
+			return nil, "", fmt.Errorf("invalid ringback file %q: must be a file name", name)
+		}
+		return nil, name, nil
+	}
+
+	cadence, ok := strings.CutPrefix(spec, RingbackTonePrefix)
+	if !ok {
+		return nil, "", fmt.Errorf("invalid ringback %q: must start with %s or %s", spec, RingbackTonePrefix, RingbackFilePrefix)
+	}
+	if preset, ok := ringbackTones[cadence]; ok {
+		cadence = preset
+	}
+
+	parts := strings.Split(cadence, "/")
+	if len(parts) < 3 || len(parts)%2 == 0 {
+		return nil, "", fmt.Errorf("invalid ringback tone %q: use a country or frequencies followed by on/off periods, like 440+480/2000/4000", cadence)
+	}
+
+	tone := &RingbackTone{}
+	freqs := strings.Split(parts[0], "+")
+	if len(freqs) > 2 {
+		return nil, "", fmt.Errorf("invalid ringback tone %q: at most two frequencies", cadence)
+	}
+	for _, f := range freqs {
+		hz, err := strconv.Atoi(f)
+		if err != nil || hz < 100 || hz > 3500 {
+			return nil, "", fmt.Errorf("invalid ringback frequency %q: must be 100-3500 Hz", f)
+		}
+		tone.Frequencies = append(tone.Frequencies, hz)
+	}
+
+	var total time.Duration
+	for _, p := range parts[1:] {
+		ms, err := strconv.Atoi(p)
+		if err != nil || ms <= 0 {
+			return nil, "", fmt.Errorf("invalid ringback period %q: must be positive milliseconds", p)
+		}
+		tone.Cadence = append(tone.Cadence, time.Duration(ms)*time.Millisecond)
+		total += time.Duration(ms) * time.Millisecond
+	}
+	if total > 30*time.Second {
+		return nil, "", fmt.Errorf("invalid ringback tone %q: cadence longer than 30s", cadence)
+	}
+	return tone, "", nil
+}
+
+// Fax policies: what happens to a call found to be a fax machine, from its
+// CNG tone or a T.38 re-INVITE
+const (
+	FaxPolicyIgnore = "ignore" // No detection; the agent hears the fax tones
+	FaxPolicyReject = "reject" // Hang up with the fax_detected cause
+	FaxPolicyDivert = "divert" // Transfer the call to the route's fax target
+	FaxPolicyNotify = "notify" // Send the agent a fax event and let it decide
+)
+
+// ValidateFaxPolicy checks that a fax policy is supported and that diverted
+// calls have a SIP URI to go to
+func ValidateFaxPolicy(policy string, target *string) error {
+	switch policy {
+	case FaxPolicyIgnore, FaxPolicyReject, FaxPolicyNotify:
+		return nil
+	case FaxPolicyDivert:
+		if target == nil || *target == "" {
+			return fmt.Errorf("fax policy %s requires a fax_target", FaxPolicyDivert)
+		}
+		if !strings.HasPrefix(*target, "sip:") && !strings.HasPrefix(*target, "sips:") {
+			return fmt.Errorf("invalid fax target %q: must be a sip: or sips: URI", *target)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported fax policy %q (use %s, %s, %s or %s)",
+		policy, FaxPolicyIgnore, FaxPolicyReject, FaxPolicyDivert, FaxPolicyNotify)
+}
+
+// Agent WebSocket protocols
+const (
+	AgentProtocolExotel = "exotel"
+	AgentProtocolTwilio = "twilio" // Twilio Media Streams
+)
+
+// ValidateAgentProtocol checks that an agent protocol is supported
+func ValidateAgentProtocol(protocol string) error {
+	switch protocol {
+	case AgentProtocolExotel, AgentProtocolTwilio:
+		return nil
+	}
+	return fmt.Errorf("unsupported agent protocol %q (use %s or %s)", protocol, AgentProtocolExotel, AgentProtocolTwilio)
+}
+
+// Call supervision modes. Supervisors always hear the caller and agent.
+const (
+	SupervisionListen  = "listen"
+	SupervisionWhisper = "whisper" // Supervisor heard by the agent only
+	SupervisionBarge   = "barge"   // Supervisor heard by the caller and agent
+)
+
+// ValidateSupervisionMode checks that a supervision mode is supported
+func ValidateSupervisionMode(mode string) error {
+	switch mode {
+	case SupervisionListen, SupervisionWhisper, SupervisionBarge:
+		return nil
+	}
+	return fmt.Errorf("unsupported supervision mode %q (use %s, %s or %s)",
+		mode, SupervisionListen, SupervisionWhisper, SupervisionBarge)
+}
+
+// How audio played on a call through the API combines with the agent's
+const (
+	PlayModeMix     = "mix"     // Heard over the agent's audio
+	PlayModeReplace = "replace" // The agent's audio is discarded while it plays
+)
+
+// ValidatePlayMode checks that a play mode is supported
+func ValidatePlayMode(mode string) error {
+	switch mode {
+	case PlayModeMix, PlayModeReplace:
+		return nil
+	}
+	return fmt.Errorf("unsupported play mode %q (use %s or %s)", mode, PlayModeMix, PlayModeReplace)
+}
+
+// languagePattern matches BCP 47 language tags: a primary language subtag
+// and optional script, region and variant subtags
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// ValidateLanguage checks that a route language is a BCP 47 tag such as
+// "en", "es-MX" or "zh-Hant-TW"
+func ValidateLanguage(language *string) error {
+	if language == nil || languagePattern.MatchString(*language) {
+		return nil
+	}
+	return fmt.Errorf("invalid language %q: must be a BCP 47 tag such as en or es-MX", *language)
+}
+
+// transferNumberPattern matches phone numbers calls can be transferred to
+var transferNumberPattern = regexp.MustCompile(`^\+?[0-9]{3,15}$`)
+
+// ValidateTransferTarget checks that a call can be transferred to target: a
+// phone number, or a sip:, sips: or tel: URI
+func ValidateTransferTarget(target string) error {
+	for _, scheme := range []string{"sip:", "sips:", "tel:"} {
+		if strings.HasPrefix(target, scheme) && len(target) > len(scheme) {
+			return nil
+		}
+	}
+	if transferNumberPattern.MatchString(target) {
+		return nil
+	}
+	return fmt.Errorf("invalid transfer target %q: must be a phone number or a sip:, sips: or tel: URI", target)
+}
+
+// ValidateAgentURL checks that an agent URL is an absolute WebSocket URL
+func ValidateAgentURL(agentURL string) error {
+	u, err := url.Parse(agentURL)
+	if err != nil {
+		return fmt.Errorf("invalid agent URL %q: %w", agentURL, err)
+	}
+	if (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return fmt.Errorf("agent URL %q must be a ws:// or wss:// URL", agentURL)
+	}
+	return nil
+}
+
+// AgentPool returns the agent replicas sharing the route's calls
+func (r *Route) AgentPool() []string {
+	return append([]string{r.WebSocketURL}, r.AgentURLs...)
+}
+
+// WithAgent returns a copy of the route sending its calls to agentURL in
+// place of all of its own agents: replicas, regional pools, split targets
+// and fallbacks
+func (r *Route) WithAgent(agentURL string) *Route {
+	routed := *r
+	routed.WebSocketURL = agentURL
+	routed.AgentURLs = nil
+	routed.AgentRegions = nil
+	routed.AgentSplits = nil
+	routed.FallbackWebSocketURLs = nil
+	return &routed
+}
+
+// AgentRegionPool is a route's agent replicas in one region of a
+// multi-region deployment
+type AgentRegionPool struct {
+	Region string   `json:"region" example:"eu-west"`
+	URLs   []string `json:"urls"`
+}
+
+// regionPattern matches region names
+var regionPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidateAgentRegions checks a route's regional agent pools: named
+// regions, each listed once with at least one agent URL
+func ValidateAgentRegions(pools []AgentRegionPool) error {
+	seen := make(map[string]bool, len(pools))
+	for _, pool := range pools {
+		if !regionPattern.MatchString(pool.Region) {
+			return fmt.Errorf("invalid agent region %q: use lower-case letters, digits and dashes", pool.Region)
+		}
+		if seen[pool.Region] {
+			return fmt.Errorf("agent region %s is listed twice", pool.Region)
+		}
+		seen[pool.Region] = true
+		if len(pool.URLs) == 0 {
+			return fmt.Errorf("agent region %s has no agent URLs", pool.Region)
+		}
+		for _, u := range pool.URLs {
+			if err := ValidateAgentURL(u); err != nil {
+				return fmt.Errorf("agent region %s: %w", pool.Region, err)
+			}
+		}
+	}
+	return nil
+}
+
+// AgentRegion returns the region of one of the route's agent URLs, or ""
+// when it isn't in a regional pool
+func (r *Route) AgentRegion(agentURL string) string {
+	for _, pool := range r.AgentRegions {
+		if slices.Contains(pool.URLs, agentURL) {
+			return pool.Region
+		}
+	}
+	return ""
+}
+
+// RegionDecision records how the agent regions of a call's route were
+// ranked when it arrived, in the order they were tried
+type RegionDecision struct {
+	LocalRegion string       `json:"local_region,omitempty" example:"eu-west"` // This instance's REGION
+	Regions     []RegionRank `json:"regions"`
+}
+
+// RegionRank is the health of an agent region as a call's agents were
+// chosen, as measured by the instance taking the call
+type RegionRank struct {
+	Region    string `json:"region" example:"eu-west"`
+	Healthy   bool   `json:"healthy"`
+	LatencyMs *int   `json:"latency_ms,omitempty" example:"42"` // Average agent handshake time; unset until measured
+}
+
+// AgentSplit is one of the weighted agents a route's calls are split
+// between, as when trying a new agent version on a share of live traffic
+type AgentSplit struct {
+	Name         string `json:"name" example:"agent-v2"` // Recorded on the calls it's assigned
+	WebSocketURL string `json:"websocket_url" example:"wss://agent-v2.example.com/ws"`
+	Weight       int    `json:"weight" example:"10"` // Share of the calls, relative to the other targets' weights
+}
+
+// How a route's calls are assigned to its agent splits
+const (
+	SplitRandom = "random" // Drawn by weight for each call
+	SplitCaller = "caller" // By the caller's number, so repeat callers get the same target
+)
+
+// maxAgentSplits bounds the targets a route's calls are split between
+const maxAgentSplits = 16
+
+// splitNamePattern matches agent split target names
+var splitNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidateAgentSplits checks a route's agent splits and how calls are
+// assigned to them: uniquely named targets with agent URLs and weights, at
+// least one of them positive
+func ValidateAgentSplits(splits []AgentSplit, strategy string) error {
+	switch strategy {
+	case SplitRandom, SplitCaller:
+	default:
+		return fmt.Errorf("unsupported split strategy %q (use %s or %s)", strategy, SplitRandom, SplitCaller)
+	}
+	if len(splits) > maxAgentSplits {
+		return fmt.Errorf("too many agent splits: %d (at most %d)", len(splits), maxAgentSplits)
+	}
+
+	seen := make(map[string]bool, len(splits))
+	total := 0
+	for _, split := range splits {
+		if !splitNamePattern.MatchString(split.Name) {
+			return fmt.Errorf("invalid agent split name %q: use letters, digits, dots, dashes and underscores", split.Name)
+		}
+		if seen[split.Name] {
+			return fmt.Errorf("agent split %s is listed twice", split.Name)
+		}
+		seen[split.Name] = true
+		if err := ValidateAgentURL(split.WebSocketURL); err != nil {
+			return fmt.Errorf("agent split %s: %w", split.Name, err)
+		}
+		if split.Weight < 0 || split.Weight > 1000 {
+			return fmt.Errorf("agent split %s: weight must be between 0 and 1000", split.Name)
+		}
+		total += split.Weight
+	}
+	if len(splits) > 0 && total == 0 {
+		return fmt.Errorf("agent splits need a positive weight")
+	}
+	return nil
+}
+
+// SplitTarget returns the agent split a call is assigned: the one whose
+// share of the route's total weight n, in [0, total), falls in
+func (r *Route) SplitTarget(n int) *AgentSplit {
+	for i := range r.AgentSplits {
+		if n < r.AgentSplits[i].Weight {
+			return &r.AgentSplits[i]
+		}
+		n -= r.AgentSplits[i].Weight
+	}
+	return nil
+}
+
+// SplitWeight returns the total weight of the route's agent splits
+func (r *Route) SplitWeight() int {
+	total := 0
+	for _, split := range r.AgentSplits {
+		total += split.Weight
+	}
+	return total
+}
+
+// Load-balancing strategies across a route's agent replicas
+const (
+	AgentLBRoundRobin  = "round_robin"
+	AgentLBLeastActive = "least_active" // Replica with the fewest active calls on this instance
+	AgentLBRandom      = "random"
+)
+
+// ValidateAgentLBStrategy checks that a load-balancing strategy is supported
+func ValidateAgentLBStrategy(strategy string) error {
+	switch strategy {
+	case AgentLBRoundRobin, AgentLBLeastActive, AgentLBRandom:
+		return nil
+	}
+	return fmt.Errorf("unsupported agent load-balancing strategy %q (use %s, %s or %s)",
+		strategy, AgentLBRoundRobin, AgentLBLeastActive, AgentLBRandom)
+}
+
+// AgentAuthType is how blayzen-sip authenticates to the agent WebSocket
+type AgentAuthType string
+
+const (
+	AgentAuthHeaders AgentAuthType = ""       // Custom headers only
+	AgentAuthBearer  AgentAuthType = "bearer" // Static bearer token
+	AgentAuthJWT     AgentAuthType = "jwt"    // HS256 JWT with call metadata, signed per call
+)
+
+// handshakeHeaders are set by the WebSocket client and can't be configured
+var handshakeHeaders = []string{
+	"host", "upgrade", "connection", "sec-websocket-key", "sec-websocket-version",
+	"sec-websocket-extensions", "sec-websocket-protocol",
+}
+
+// defaultAgentTokenTTL is the lifetime of agent JWTs when none is configured
+const defaultAgentTokenTTL = 60
+
+// AgentAuth configures the credentials sent in the agent WebSocket handshake.
+// Token and Secret are write-only and cleared from API responses.
+type AgentAuth struct {
+	Type       AgentAuthType     `json:"type,omitempty" example:"jwt"`
+	Token      string            `json:"token,omitempty"`
+	Secret     string            `json:"secret,omitempty"`
+	TTLSeconds int               `json:"ttl_seconds,omitempty" example:"60"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// Validate checks that the auth config is complete
+func (a *AgentAuth) Validate() error {
+	switch a.Type {
+	case AgentAuthHeaders:
+	case AgentAuthBearer:
+		if a.Token == "" {
+			return fmt.Errorf("bearer auth requires a token")
+		}
+	case AgentAuthJWT:
+		if len(a.Secret) < 32 {
+			return fmt.Errorf("jwt auth requires a secret of at least 32 characters")
+		}
+	default:
+		return fmt.Errorf("unsupported agent auth type %q (use %s or %s)", a.Type, AgentAuthBearer, AgentAuthJWT)
+	}
+
+	if a.TTLSeconds < 0 {
+		return fmt.Errorf("ttl_seconds must not be negative")
+	}
+
+	for name := range a.Headers {
+		if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if slices.Contains(handshakeHeaders, strings.ToLower(name)) {
+			return fmt.Errorf("header %s is set by the WebSocket handshake", name)
+		}
+	}
+	return nil
+}
+
+// TokenTTL returns the lifetime of agent JWTs
+func (a *AgentAuth) TokenTTL() time.Duration {
+	if a.TTLSeconds == 0 {
+		return defaultAgentTokenTTL * time.Second
+	}
+	return time.Duration(a.TTLSeconds) * time.Second
+}
+
+// KeepSecrets fills a token or secret left empty on update from the stored
+// config of the same type, since they are never returned by the API
+func (a *AgentAuth) KeepSecrets(stored *AgentAuth) {
+	if stored == nil || stored.Type != a.Type {
+		return
+	}
+	if a.Token == "" {
+		a.Token = stored.Token
+	}
+	if a.Secret == "" {
+		a.Secret = stored.Secret
+	}
+}
+
+// ConnectRetry retries connecting a call's agent, e.g. one cold-starting,
+// before the call is rejected. Each attempt tries every agent URL; failed
+// ones are followed by a backoff doubling from BackoffMs, lengthened by up
+// to JitterPercent at random so retries of many calls spread out.
+type ConnectRetry struct {
+	Attempts      int `json:"attempts" example:"3"`        // Including the first
+	BackoffMs     int `json:"backoff_ms" example:"500"`    // Before the second attempt
+	JitterPercent int `json:"jitter_percent" example:"20"` // Of each backoff
+}
+
+// Limits of agent connect retries, which hold the caller in setup
+const (
+	MaxConnectAttempts  = 10
+	MaxConnectBackoffMs = 10000
+)
+
+// Validate checks the retry policy's limits
+func (r *ConnectRetry) Validate() error {
+	if r.Attempts < 1 || r.Attempts > MaxConnectAttempts {
+		return fmt.Errorf("connect_retry attempts must be between 1 and %d", MaxConnectAttempts)
+	}
+	if r.BackoffMs < 0 || r.BackoffMs > MaxConnectBackoffMs {
+		return fmt.Errorf("connect_retry backoff_ms must be between 0 and %d", MaxConnectBackoffMs)
+	}
+	if r.JitterPercent < 0 || r.JitterPercent > 100 {
+		return fmt.Errorf("connect_retry jitter_percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// RouteSchedule limits when a route takes calls: on its weekdays, within
+// its hours, except on its holidays, all in its timezone. Outside it the
+// route's calls go to AfterHoursWebSocketURL when set; otherwise the route
+// doesn't match them, leaving them to lower-priority routes such as a
+// voicemail one.
+type RouteSchedule struct {
+	Timezone               string      `json:"timezone" example:"America/New_York"`                                  // IANA name; the account's when the route is saved without one
+	Weekdays               []string    `json:"weekdays,omitempty" example:"mon,tue,wed,thu,fri"`                     // mon to sun; every day when omitted
+	Hours                  []TimeRange `json:"hours,omitempty"`                                                      // All day when omitted
+	Holidays               []string    `json:"holidays,omitempty" example:"2026-12-25"`                              // YYYY-MM-DD, closed all day
+	AfterHoursWebSocketURL *string     `json:"after_hours_websocket_url,omitempty" example:"ws://voicemail:8081/ws"` // Agent taking the calls outside the schedule
+}
+
+// TimeRange is a time of day range, HH:MM, from Start up to End. Ranges
+// whose End is before their Start run past midnight, and belong to the day
+// they start on.
+type TimeRange struct {
+	Start string `json:"start" example:"09:00"`
+	End   string `json:"end" example:"17:30"`
+}
+
+// scheduleWeekdays are the weekday names of route schedules
+var scheduleWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Validate checks the schedule's timezone, days and hours
+func (s *RouteSchedule) Validate() error {
+	if _, err := LoadTimezone(s.Timezone); err != nil {
+		return fmt.Errorf("schedule: %w", err)
+	}
+	for _, day := range s.Weekdays {
+		if _, ok := scheduleWeekdays[day]; !ok {
+			return fmt.Errorf("schedule: invalid weekday %q (use mon, tue, wed, thu, fri, sat or sun)", day)
+		}
+	}
+	for _, r := range s.Hours {
+		start, err := parseTimeOfDay(r.Start)
+		if err != nil {
+			return fmt.Errorf("schedule: %w", err)
+		}
+		end, err := parseTimeOfDay(r.End)
+		if err != nil {
+			return fmt.Errorf("schedule: %w", err)
+		}
+		if start == end {
+			return fmt.Errorf("schedule: empty hours %s-%s", r.Start, r.End)
+		}
+	}
+	for _, day := range s.Holidays {
+		if _, err := time.Parse(time.DateOnly, day); err != nil {
+			return fmt.Errorf("schedule: invalid holiday %q: must be YYYY-MM-DD", day)
+		}
+	}
+	if s.AfterHoursWebSocketURL != nil {
+		if err := ValidateAgentURL(*s.AfterHoursWebSocketURL); err != nil {
+			return fmt.Errorf("schedule: %w", err)
+		}
+	}
+	return nil
+}
+
+// Open reports whether the schedule takes calls at now
+func (s *RouteSchedule) Open(now time.Time) bool {
+	loc, err := LoadTimezone(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	if len(s.Hours) == 0 {
+		return s.openDay(local)
+	}
+
+	// The early-morning part of a range past midnight is the previous day's
+	minute := local.Hour()*60 + local.Minute()
+	previous := local.AddDate(0, 0, -1)
+	for _, r := range s.Hours {
+		start, err1 := parseTimeOfDay(r.Start)
+		end, err2 := parseTimeOfDay(r.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		switch {
+		case start < end && minute >= start && minute < end && s.openDay(local):
+			return true
+		case start > end && minute >= start && s.openDay(local):
+			return true
+		case start > end && minute < end && s.openDay(previous):
+			return true
+		}
+	}
+	return false
+}
+
+// openDay reports whether the schedule takes calls on the date of t: not
+// one of its holidays, and one of its weekdays when it has any
+func (s *RouteSchedule) openDay(t time.Time) bool {
+	if slices.Contains(s.Holidays, t.Format(time.DateOnly)) {
+		return false
+	}
+	return len(s.Weekdays) == 0 || slices.ContainsFunc(s.Weekdays, func(day string) bool {
+		weekday, ok := scheduleWeekdays[day]
+		return ok && weekday == t.Weekday()
+	})
+}
+
+// parseTimeOfDay parses HH:MM into minutes since midnight
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: must be HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// AfterHours returns the route calls outside its schedule take: a copy of
+// it with the after-hours agent in place of its own
+func (r *Route) AfterHours() *Route {
+	return r.WithAgent(*r.Schedule.AfterHoursWebSocketURL)
+}
+
+// DTMF shortcut actions
+const (
+	DTMFActionTransfer = "transfer" // Transfer the caller to Target
+	DTMFActionHangup   = "hangup"   // Hang up the call
+	DTMFActionSet      = "set"      // Set Key to Value in the call's custom data
+	DTMFActionWebhook  = "webhook"  // Only notify webhooks
+)
+
+// DTMFShortcut is an action a caller triggers by pressing Digits mid-call,
+// e.g. "0" to reach a human. Digits of a sequence must follow each other
+// within DTMFShortcutGap. Shortcuts also notify the account's webhooks
+// (WebhookEventCallDTMFShortcut); the digits still reach the agent.
+type DTMFShortcut struct {
+	Digits string      `json:"digits" example:"0"`                           // 1 to MaxDTMFShortcutDigits of 0-9, *, #, A-D
+	Action string      `json:"action" example:"transfer"`                    // transfer, hangup, set or webhook
+	Target string      `json:"target,omitempty" example:"+14155550100"`      // Number or SIP URI, for transfer
+	Key    string      `json:"key,omitempty" example:"follow_up"`            // Custom data key, for set
+	Value  interface{} `json:"value,omitempty" swaggertype:"object"`         // Custom data value, for set; true when unset
+	Name   string      `json:"name,omitempty" example:"Transfer to a human"` // Label sent to webhooks
+}
+
+// Limits of DTMF shortcuts
+const (
+	MaxDTMFShortcuts      = 16
+	MaxDTMFShortcutDigits = 4
+	DTMFShortcutGap       = 3 * time.Second
+)
+
+// dtmfDigitsPattern matches the digits of a DTMF shortcut
+var dtmfDigitsPattern = regexp.MustCompile(`^[0-9*#A-D]+$`)
+
+// ValidateDTMFShortcuts checks a route's DTMF shortcuts: valid digits, each
+// sequence reachable (not begun by digits another shortcut acts on first),
+// and what each action needs
+func ValidateDTMFShortcuts(shortcuts []DTMFShortcut) error {
+	if len(shortcuts) > MaxDTMFShortcuts {
+		return fmt.Errorf("at most %d dtmf_shortcuts are allowed", MaxDTMFShortcuts)
+	}
+	seen := make(map[string]bool, len(shortcuts))
+	for _, shortcut := range shortcuts {
+		if len(shortcut.Digits) == 0 || len(shortcut.Digits) > MaxDTMFShortcutDigits || !dtmfDigitsPattern.MatchString(shortcut.Digits) {
+			return fmt.Errorf("invalid dtmf_shortcuts digits %q: must be 1 to %d of 0-9, *, # and A-D", shortcut.Digits, MaxDTMFShortcutDigits)
+		}
+		if seen[shortcut.Digits] {
+			return fmt.Errorf("dtmf_shortcuts digits %q are set more than once", shortcut.Digits)
+		}
+		seen[shortcut.Digits] = true
+		for n := 1; n < len(shortcut.Digits); n++ {
+			if other := MatchDTMFShortcut(shortcuts, shortcut.Digits[:n]); other != nil {
+				return fmt.Errorf("dtmf_shortcuts digits %q can't be reached: %q acts first", shortcut.Digits, other.Digits)
+			}
+		}
+
+		switch shortcut.Action {
+		case DTMFActionTransfer:
+			if err := ValidateTransferTarget(shortcut.Target); err != nil {
+				return fmt.Errorf("dtmf_shortcuts %q: %w", shortcut.Digits, err)
+			}
+		case DTMFActionSet:
+			if shortcut.Key == "" {
+				return fmt.Errorf("dtmf_shortcuts %q: set requires a key", shortcut.Digits)
+			}
+		case DTMFActionHangup, DTMFActionWebhook:
+		default:
+			return fmt.Errorf("invalid dtmf_shortcuts action %q: must be transfer, hangup, set or webhook", shortcut.Action)
+		}
+	}
+	return nil
+}
+
+// MatchDTMFShortcut returns the shortcut with the longest digits ending the
+// digits pressed, or nil
+func MatchDTMFShortcut(shortcuts []DTMFShortcut, pressed string) *DTMFShortcut {
+	var match *DTMFShortcut
+	for i := range shortcuts {
+		if strings.HasSuffix(pressed, shortcuts[i].Digits) && (match == nil || len(shortcuts[i].Digits) > len(match.Digits)) {
+			match = &shortcuts[i]
+		}
+	}
+	return match
+}
+
+// Redacted returns the route with agent credentials removed, for API responses
+func (r *Route) Redacted() *Route {
+	if r.AgentAuth == nil {
+		return r
+	}
+
+	redacted := *r
+	auth := *r.AgentAuth
+	auth.Token = ""
+	auth.Secret = ""
+	redacted.AgentAuth = &auth
+	return &redacted
+}
+
+// Audio encodings supported on the agent WebSocket
+const (
+	AudioEncodingMulaw = "mulaw" // G.711 mu-law, one byte per sample
+	AudioEncodingL16   = "l16"   // Signed 16-bit little-endian linear PCM
+)
+
+// audioEncodingAliases maps other names agent stacks use for the supported
+// encodings to ours
+var audioEncodingAliases = map[string]string{
+	"ulaw":      AudioEncodingMulaw,
+	"pcmu":      AudioEncodingMulaw,
+	"s16le":     AudioEncodingL16,
+	"pcm_s16le": AudioEncodingL16,
+	"linear16":  AudioEncodingL16,
+}
+
+// Localize converts the route's timestamps to loc
+func (r *Route) Localize(loc *time.Location) {
+	r.CreatedAt = r.CreatedAt.In(loc)
+	r.UpdatedAt = r.UpdatedAt.In(loc)
+}
+
+// AudioFormat describes the audio exchanged with the agent
+type AudioFormat struct {
+	Encoding   string `json:"encoding" example:"l16"`
+	SampleRate int    `json:"sample_rate" example:"16000"`
+	ChunkMs    int    `json:"chunk_ms,omitempty" example:"20"`
+}
+
+// DefaultAudioFormat is the caller leg's native format: 8kHz mu-law, 20ms chunks
+var DefaultAudioFormat = AudioFormat{
+	Encoding:   AudioEncodingMulaw,
+	SampleRate: 8000,
+	ChunkMs:    20,
+}
+
+// Validate checks that the format is supported, normalizing an alias of its
+// encoding (e.g. s16le for l16)
+func (f *AudioFormat) Validate() error {
+	if encoding, ok := audioEncodingAliases[strings.ToLower(f.Encoding)]; ok {
+		f.Encoding = encoding
+	}
+	switch f.Encoding {
+	case AudioEncodingMulaw, AudioEncodingL16:
+	default:
+		return fmt.Errorf("unsupported audio encoding %q (use %s or %s)", f.Encoding, AudioEncodingMulaw, AudioEncodingL16)
+	}
+
+	switch f.SampleRate {
+	case 8000, 16000, 24000, 48000:
+	default:
+		return fmt.Errorf("unsupported sample rate %d (use 8000, 16000, 24000 or 48000)", f.SampleRate)
+	}
+
+	if f.ChunkMs < 0 || f.ChunkMs%20 != 0 || f.ChunkMs > 1000 {
+		return fmt.Errorf("chunk_ms must be a multiple of 20 up to 1000")
+	}
+	return nil
+}
+
+// BytesPerSample returns the size of one sample in the format's encoding
+func (f *AudioFormat) BytesPerSample() int {
+	if f.Encoding == AudioEncodingL16 {
+		return 2
+	}
+	return 1
+}
+
+// ChunkBytes returns the size of one agent media chunk
+func (f *AudioFormat) ChunkBytes() int {
+	chunkMs := f.ChunkMs
+	if chunkMs == 0 {
+		chunkMs = DefaultAudioFormat.ChunkMs
+	}
+	return f.SampleRate * chunkMs / 1000 * f.BytesPerSample()
+}
+
+// EffectiveAudioFormat returns the route's agent audio format or the default
+func (r *Route) EffectiveAudioFormat() AudioFormat {
+	if r.AudioFormat == nil {
+		return DefaultAudioFormat
+	}
+	return *r.AudioFormat
+}
+
+// HeaderMatchOperator is how a header condition compares the header value
+type HeaderMatchOperator string
+
+const (
+	HeaderMatchExists   HeaderMatchOperator = "exists"
+	HeaderMatchEquals   HeaderMatchOperator = "equals"
+	HeaderMatchWildcard HeaderMatchOperator = "wildcard"
+	HeaderMatchRegex    HeaderMatchOperator = "regex"
+)
+
+// How a route's match_to_user and match_from_user compare a call's number
+const (
+	NumberMatchExact   = "exact"   // The whole number
+	NumberMatchPrefix  = "prefix"  // Numbers starting with the value
+	NumberMatchRegex   = "regex"   // A regular expression, unanchored unless it uses ^ and $
+	NumberMatchPattern = "pattern" // Digits, X for any one digit and * for any rest, as +4420*
+)
+
+// ValidateNumberMatch checks that a route number criterion's match type is
+// supported and that its value compiles
+func ValidateNumberMatch(field, matchType string, value *string) error {
+	switch matchType {
+	case NumberMatchExact, NumberMatchPrefix:
+		return nil
+	case NumberMatchRegex:
+		if value == nil || *value == "" {
+			return nil
+		}
+		if _, err := compileRegex(*value); err != nil {
+			return fmt.Errorf("invalid regex %q for %s: %w", *value, field, err)
+		}
+		return nil
+	case NumberMatchPattern:
+		if value == nil || *value == "" {
+			return nil
+		}
+		if _, err := patternRegex(*value); err != nil {
+			return fmt.Errorf("invalid pattern %q for %s: %w", *value, field, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported match type %q for %s (use %s, %s, %s or %s)",
+		matchType, field, NumberMatchExact, NumberMatchPrefix, NumberMatchRegex, NumberMatchPattern)
+}
+
+// matchNumber compares a call's number with a route number criterion
+func matchNumber(matchType, value, number string) bool {
+	switch matchType {
+	case NumberMatchPrefix:
+		return strings.HasPrefix(number, value)
+	case NumberMatchRegex:
+		re, err := compileRegex(value)
+		return err == nil && re.MatchString(number)
+	case NumberMatchPattern:
+		re, err := patternRegex(value)
+		return err == nil && re.MatchString(number)
+	default:
+		return number == value
+	}
+}
+
+// patternRegex compiles a number pattern to an anchored regex: a leading +,
+// digits, X for any one digit and * for any rest of the number
+func patternRegex(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i, ch := range pattern {
+		switch {
+		case ch == '+' && i == 0:
+			b.WriteString(`\+`)
+		case ch >= '0' && ch <= '9':
+			b.WriteRune(ch)
+		case ch == 'X' || ch == 'x':
+			b.WriteString(`[0-9]`)
+		case ch == '*':
+			b.WriteString(`.*`)
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d: use digits, a leading +, X and *", ch, i)
+		}
+	}
+	b.WriteString("$")
+	return compileRegex(b.String())
+}
+
+// HeaderCondition is a single SIP header match condition on a route
+type HeaderCondition struct {
+	Header   string              `json:"header" example:"X-Customer-Tier"`
+	Operator HeaderMatchOperator `json:"operator" example:"wildcard"`
+	Value    string              `json:"value,omitempty" example:"vip*"`
+}
+
+// MatchGroup is an alternative set of conditions for a route. A group
+// matches when all of its non-empty conditions match.
+type MatchGroup struct {
+	ToUsers   []string          `json:"to_users,omitempty" example:"1000,1001,1002"`
+	FromUsers []string          `json:"from_users,omitempty"`
+	Headers   []HeaderCondition `json:"headers,omitempty"`
+}
+
+// HeaderRuleDirection is which SIP messages a header rule applies to
+type HeaderRuleDirection string
+
+const (
+	HeaderRuleIngress HeaderRuleDirection = "ingress" // Messages we receive
+	HeaderRuleEgress  HeaderRuleDirection = "egress"  // Messages we send
+	HeaderRuleBoth    HeaderRuleDirection = "both"
+)
+
+// HeaderRuleAction is what a header rule does
+type HeaderRuleAction string
+
+const (
+	HeaderRuleAdd     HeaderRuleAction = "add"
+	HeaderRuleRemove  HeaderRuleAction = "remove"
+	HeaderRuleRewrite HeaderRuleAction = "rewrite"
+)
+
+// HeaderRule manipulates a SIP header on a trunk's or route's messages.
+//
+//   - add appends a header with Value
+//   - remove deletes the header, or only the values matching Match if set
+//   - rewrite replaces Match (a regex, whole value if empty) with Value,
+//     which may reference capture groups as $1, ${name}
+type HeaderRule struct {
+	Direction HeaderRuleDirection `json:"direction" example:"ingress"`
+	Action    HeaderRuleAction    `json:"action" example:"rewrite"`
+	Header    string              `json:"header" example:"From"`
+	Match     string              `json:"match,omitempty" example:"@carrier\\.invalid"`
+	Value     string              `json:"value,omitempty" example:"@carrier.example.com"`
+	Methods   []string            `json:"methods,omitempty" example:"INVITE"`
+}
+
+// protectedHeaders cannot be manipulated as transactions depend on them
+var protectedHeaders = []string{"via", "v", "call-id", "i", "cseq", "content-length", "l"}
+
+// Trunk represents an outbound SIP trunk configuration
+type Trunk struct {
+	ID               string       `json:"id" db:"id"`
+	AccountID        string       `json:"account_id" db:"account_id"`
+	Name             string       `json:"name" db:"name"`
+	Host             string       `json:"host" db:"host"`
+	Port             int          `json:"port" db:"port"`
+	Transport        string       `json:"transport" db:"transport"`
+	Username         *string      `json:"username,omitempty" db:"username"`
+	Password         *string      `json:"-" db:"password"` // Never expose password
+	FromUser         *string      `json:"from_user,omitempty" db:"from_user"`
+	FromHost         *string      `json:"from_host,omitempty" db:"from_host"`
+	Register         bool         `json:"register" db:"register"`
+	RegisterInterval int          `json:"register_interval" db:"register_interval"`
+	OutboundProxy    *string      `json:"outbound_proxy,omitempty" db:"outbound_proxy"`
+	HeaderRules      []HeaderRule `json:"header_rules,omitempty" db:"header_rules"`
+	SymmetricRTP     bool         `json:"symmetric_rtp" db:"symmetric_rtp"`                 // Send RTP to where the caller's packets come from, not its SDP address
+	MaxBitrateKbps   *int         `json:"max_bitrate_kbps,omitempty" db:"max_bitrate_kbps"` // Media bitrate of each call over the trunk, each way; calls needing more are refused
+	Active           bool         `json:"active" db:"active"`
+	CreatedAt        time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// TrunkResponseStat counts final SIP responses exchanged with a trunk
+type TrunkResponseStat struct {
+	Direction  CallDirection `json:"direction" example:"outbound"` // inbound: sent by us to the trunk; outbound: sent by the trunk to us
+	Method     string        `json:"method" example:"INVITE"`      // Method of the request answered
+	StatusCode int           `json:"status_code" example:"503"`
+	Count      int64         `json:"count" example:"42"`
+}
+
+// SplitStat summarises the calls assigned one of a route's agent splits
+type SplitStat struct {
+	Target             string  `json:"target" example:"agent-v2"`
+	Calls              int64   `json:"calls" example:"120"`
+	Answered           int64   `json:"answered" example:"112"`
+	Failed             int64   `json:"failed" example:"3"`
+	AvgDurationSeconds float64 `json:"avg_duration_seconds" example:"184.5"` // Of answered calls
+}
+
+// CallStatus represents the state of a call
+type CallStatus string
+
+const (
+	CallStatusInitiated CallStatus = "initiated"
+	CallStatusRinging   CallStatus = "ringing"
+	CallStatusAnswered  CallStatus = "answered"
+	CallStatusCompleted CallStatus = "completed"
+	CallStatusFailed    CallStatus = "failed"
+	CallStatusCancelled CallStatus = "cancelled"
+	CallStatusPreempted CallStatus = "preempted" // Hung up to make room for a higher-priority call
+)
+
+// CallStatuses lists every call status
+var CallStatuses = []CallStatus{
+	CallStatusInitiated, CallStatusRinging, CallStatusAnswered, CallStatusCompleted,
+	CallStatusFailed, CallStatusCancelled, CallStatusPreempted,
+}
+
+// Hangup causes recorded for calls ended by blayzen-sip itself
+const (
+	HangupCausePreempted        = "preempted"
+	HangupCauseSilenceTimeout   = "silence_timeout"   // Neither caller nor agent spoke for too long
+	HangupCauseMachineDetected  = "machine_detected"  // Answering machine detected before the agent was connected
+	HangupCauseAgentUnavailable = "agent_unavailable" // No agent could be reached for an answered call
+	HangupCauseAgentLost        = "agent_lost"        // Agent connection dropped or stopped answering pings
+	HangupCauseFaxDetected      = "fax_detected"      // Fax machine rejected by the route's fax policy
+	HangupCauseFaxDiverted      = "fax_diverted"      // Fax machine transferred to the route's fax target
+	HangupCauseSessionLost      = "session_lost"      // The caller's side no longer knows the call (keepalive failed)
+	HangupCauseSessionExpired   = "session_expired"   // The session timer ran out or its refresh failed
+	HangupCauseTransferred      = "transferred"       // Caller transferred elsewhere by the agent, through the API or a DTMF shortcut
+	HangupCauseFailover         = "failover"          // The instance handling the call failed and its standby took over
+	HangupCauseDTMFShortcut     = "dtmf_shortcut"     // Caller pressed the route's hangup shortcut
+	HangupCauseReconciled       = "reconciled"        // Recorded in progress with no instance handling it, e.g. its end failed to be saved
+	HangupCauseSetupTimeout     = "setup_timeout"     // Not answered within SESSION_SETUP_TIMEOUT
+	HangupCauseAckTimeout       = "ack_timeout"       // Answered but the caller never ACKed the 200 OK
+	HangupCauseMaintenance      = "maintenance"       // Turned away by a maintenance window on the route, after its announcement
+)
+
+// Answering machine detection results
+const (
+	AMDResultHuman   = "human"
+	AMDResultMachine = "machine"
+	AMDResultNotSure = "notsure" // Undecided within the analysis time; treated as human
+)
+
+// HangupCauseNormalClearing is the hangup cause of calls ended by the caller
+// or the agent
+const HangupCauseNormalClearing = "normal_clearing"
+
+// HangupCauseOriginatorCancel is the hangup cause of calls the caller
+// cancelled before they were answered
+const HangupCauseOriginatorCancel = "originator_cancel"
+
+// Hangup parties: who ended the call
+const (
+	HangupPartySystem = "system" // blayzen-sip itself
+	HangupPartyCaller = "caller"
+	HangupPartyAgent  = "agent"
+)
+
+// CallDirection represents whether a call is inbound or outbound
+type CallDirection string
+
+const (
+	CallDirectionInbound  CallDirection = "inbound"
+	CallDirectionOutbound CallDirection = "outbound"
+)
+
+// CallLog represents a call detail record (CDR)
+type CallLog struct {
+	ID                  string                 `json:"id" db:"id"`
+	AccountID           *string                `json:"account_id,omitempty" db:"account_id"`
+	CallID              string                 `json:"call_id" db:"call_id"`
+	Direction           CallDirection          `json:"direction" db:"direction"`
+	FromURI             string                 `json:"from_uri" db:"from_uri"`
+	ToURI               string                 `json:"to_uri" db:"to_uri"`
+	FromUser            string                 `json:"from_user" db:"from_user"`
+	ToUser              string                 `json:"to_user" db:"to_user"`
+	RouteID             *string                `json:"route_id,omitempty" db:"route_id"`
+	TrunkID             *string                `json:"trunk_id,omitempty" db:"trunk_id"`
+	WebSocketURL        string                 `json:"websocket_url" db:"websocket_url"`
+	CallPriority        int                    `json:"call_priority" db:"call_priority"`
+	Status              CallStatus             `json:"status" db:"status"`
+	InitiatedAt         time.Time              `json:"initiated_at" db:"initiated_at"`
+	RingingAt           *time.Time             `json:"ringing_at,omitempty" db:"ringing_at"`
+	AnsweredAt          *time.Time             `json:"answered_at,omitempty" db:"answered_at"`
+	EndedAt             *time.Time             `json:"ended_at,omitempty" db:"ended_at"`
+	DurationSeconds     *int                   `json:"duration_seconds,omitempty" db:"duration_seconds"`
+	HangupCause         *string                `json:"hangup_cause,omitempty" db:"hangup_cause"`
+	HangupParty         *string                `json:"hangup_party,omitempty" db:"hangup_party"`
+	AMDResult           *string                `json:"amd_result,omitempty" db:"amd_result"`
+	OfferedMedia        []string               `json:"offered_media,omitempty" db:"offered_media"`     // Media types in the caller's SDP offer
+	TransferTarget      *string                `json:"transfer_target,omitempty" db:"transfer_target"` // Where the caller was transferred
+	TransferredAt       *time.Time             `json:"transferred_at,omitempty" db:"transferred_at"`
+	CustomData          map[string]interface{} `json:"custom_data,omitempty" db:"custom_data" swaggertype:"object"`
+	RecordingPath       *string                `json:"recording_path,omitempty" db:"recording_path"`
+	RecordingSize       *int64                 `json:"recording_size,omitempty" db:"recording_size"`
+	RecordingDurationMs *int64                 `json:"recording_duration_ms,omitempty" db:"recording_duration_ms"`
+	MediaBytesIn        *int64                 `json:"media_bytes_in,omitempty" db:"media_bytes_in"`   // RTP from the caller, once the call ended
+	MediaBytesOut       *int64                 `json:"media_bytes_out,omitempty" db:"media_bytes_out"` // RTP to the caller
+	InstanceID          *string                `json:"instance_id,omitempty" db:"instance_id"`         // blayzen-sip instance that took the call
+	ConversationID      *string                `json:"conversation_id,omitempty" db:"conversation_id"` // Links the calls of one customer journey
+	AgentRegion         *string                `json:"agent_region,omitempty" db:"agent_region"`       // Region of the agent that took the call
+	RegionDecision      *RegionDecision        `json:"region_decision,omitempty" db:"region_decision"` // How the route's agent regions were ranked
+	SplitTarget         *string                `json:"split_target,omitempty" db:"split_target"`       // Agent split the call was assigned
+	CreatedAt           time.Time              `json:"created_at" db:"created_at"`
+
+	// Raw numbers, encrypted, when CDR number masking is enabled
+	FromUserEncrypted *string `json:"-" db:"from_user_encrypted"`
+	ToUserEncrypted   *string `json:"-" db:"to_user_encrypted"`
+}
+
+// ActiveCall is the live state of a call in progress, as the instance
+// handling it last reported it
+type ActiveCall struct {
+	ID              string     `json:"id,omitempty"` // Call record ID, as in /api/v1/calls/{id}
+	CallID          string     `json:"call_id"`
+	AccountID       string     `json:"account_id"`
+	Instance        string     `json:"instance"` // blayzen-sip instance handling the call
+	Status          CallStatus `json:"status"`
+	FromUser        string     `json:"from_user"` // Masked as in call records
+	ToUser          string     `json:"to_user"`
+	RouteID         string     `json:"route_id,omitempty"`
+	RouteName       string     `json:"route_name,omitempty"`
+	TrunkID         string     `json:"trunk_id,omitempty"`
+	ConversationID  string     `json:"conversation_id,omitempty"`
+	AgentURL        string     `json:"agent_url"`
+	AgentConnected  bool       `json:"agent_connected"`
+	RTPRemoteAddr   string     `json:"rtp_remote_addr,omitempty"` // Where the caller's RTP comes from, once known
+	StartedAt       time.Time  `json:"started_at"`
+	AnsweredAt      *time.Time `json:"answered_at,omitempty"`
+	DurationSeconds int        `json:"duration_seconds"` // So far
+	PacketsIn       int64      `json:"packets_in"`       // RTP from the caller
+	PacketsOut      int64      `json:"packets_out"`      // RTP to the caller
+	BytesIn         int64      `json:"bytes_in"`
+	BytesOut        int64      `json:"bytes_out"`
+	BitrateKbps     int        `json:"bitrate_kbps"`                    // Media bitrate on the wire, each way
+	ChunksSent      int64      `json:"chunks_sent"`                     // Audio chunks sent to the agent
+	ChunksReceived  int64      `json:"chunks_received"`                 // Audio chunks received from the agent
+	LastRTPAt       *time.Time `json:"last_rtp_at,omitempty"`           // Last packet from the caller
+	LastAgentAt     *time.Time `json:"last_agent_message_at,omitempty"` // Last message from the agent
+	LastActivityAt  *time.Time `json:"last_activity_at,omitempty"`      // Last caller speech or agent audio
+	UpdatedAt       time.Time  `json:"updated_at"`                      // When the state was taken
+}
+
+// BandwidthUsage is the media bandwidth an account's calls in progress use,
+// each way, on all instances
+type BandwidthUsage struct {
+	ActiveCalls      int            `json:"active_calls" example:"12"`
+	BitrateKbps      int            `json:"bitrate_kbps" example:"960"`                  // Media bitrate of the calls, each way
+	MaxBandwidthKbps *int           `json:"max_bandwidth_kbps,omitempty" example:"2000"` // The account's limit, if any
+	BytesIn          int64          `json:"bytes_in"`                                    // RTP from the callers so far
+	BytesOut         int64          `json:"bytes_out"`                                   // RTP to the callers so far
+	ByRoute          map[string]int `json:"by_route"`                                    // Bitrate by route ID
+	ByTrunk          map[string]int `json:"by_trunk"`                                    // Bitrate by trunk ID, of calls from trunks
+}
+
+// CallOwner is the entry of a call in progress in the cluster's call
+// registry: the instance holding its session, where that instance takes
+// in-dialog requests landing elsewhere, and enough of the call to find it
+type CallOwner struct {
+	Instance  string    `json:"instance"`
+	Address   string    `json:"address"` // SIP host:port of the instance
+	AccountID string    `json:"account_id"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// SessionSnapshot is the in-memory state of a call in progress on the
+// instance handling it, for diagnosing stuck calls and one-way audio
+type SessionSnapshot struct {
+	ActiveCall
+	StreamSID           string         `json:"stream_sid"`
+	Closed              bool           `json:"closed"`
+	FinalResponse       bool           `json:"final_response"` // The INVITE's final response was sent, or is being
+	OnHold              bool           `json:"on_hold"`
+	EarlyMedia          bool           `json:"early_media"`
+	Transferring        bool           `json:"transferring"`
+	FaxDetected         bool           `json:"fax_detected"`
+	SIPRemoteAddr       string         `json:"sip_remote_addr,omitempty"`   // Where the INVITE came from
+	AgentRemoteAddr     string         `json:"agent_remote_addr,omitempty"` // The agent WebSocket's peer
+	LocalRTPPort        int            `json:"local_rtp_port,omitempty"`
+	RTCPMux             bool           `json:"rtcp_mux"`
+	SessionTimerSeconds int            `json:"session_timer_seconds,omitempty"`
+	MediaStartedAt      *time.Time     `json:"media_started_at,omitempty"`
+	Goroutines          map[string]int `json:"goroutines"`            // The call's goroutines running, by name
+	PlayoutBytes        int            `json:"playout_buffer_bytes"`  // Agent audio queued toward the caller
+	PlayoutMarks        int            `json:"playout_marks"`         // Agent marks waiting for their audio to play
+	JitterPackets       int            `json:"jitter_buffer_packets"` // Caller packets waiting to go to the agent
+	JitterLost          int64          `json:"jitter_lost"`
+	JitterLate          int64          `json:"jitter_late"`
+	JitterMs            float64        `json:"jitter_ms"`
+	AgentGap            bool           `json:"agent_gap"`       // Caller audio is held while the agent reconnects
+	GapAudioBytes       int            `json:"gap_audio_bytes"` // Caller audio held so far
+	Announcements       int            `json:"announcements"`   // Queued through the API
+	Supervisors         int            `json:"supervisors"`
+	HangupCause         string         `json:"hangup_cause,omitempty"`
+	HangupParty         string         `json:"hangup_party,omitempty"`
+}
+
+// Localize converts the call's timestamps to loc
+func (a *ActiveCall) Localize(loc *time.Location) {
+	a.StartedAt = a.StartedAt.In(loc)
+	a.AnsweredAt = localizeTime(a.AnsweredAt, loc)
+	a.LastRTPAt = localizeTime(a.LastRTPAt, loc)
+	a.LastAgentAt = localizeTime(a.LastAgentAt, loc)
+	a.LastActivityAt = localizeTime(a.LastActivityAt, loc)
+	a.UpdatedAt = a.UpdatedAt.In(loc)
+}
+
+// Localize converts the call's timestamps to loc
+func (c *CallLog) Localize(loc *time.Location) {
+	c.InitiatedAt = c.InitiatedAt.In(loc)
+	c.RingingAt = localizeTime(c.RingingAt, loc)
+	c.AnsweredAt = localizeTime(c.AnsweredAt, loc)
+	c.EndedAt = localizeTime(c.EndedAt, loc)
+	c.TransferredAt = localizeTime(c.TransferredAt, loc)
+	c.CreatedAt = c.CreatedAt.In(loc)
+}
+
+// PreemptionAction is what happened to a call because of capacity limits
+type PreemptionAction string
+
+const (
+	PreemptionRejected   PreemptionAction = "rejected"   // New call refused
+	PreemptionTerminated PreemptionAction = "terminated" // Active call hung up to make room
+)
+
+// CallPreemption is an audit record of a call refused or ended for capacity
+type CallPreemption struct {
+	ID           string           `json:"id" db:"id"`
+	AccountID    *string          `json:"account_id,omitempty" db:"account_id"`
+	CallID       string           `json:"call_id" db:"call_id"`
+	Action       PreemptionAction `json:"action" db:"action"`
+	CallPriority int              `json:"call_priority" db:"call_priority"`
+	PreemptedBy  *string          `json:"preempted_by,omitempty" db:"preempted_by"` // Call-ID of the call given the capacity
+	Reason       string           `json:"reason" db:"reason"`
+	CreatedAt    time.Time        `json:"created_at" db:"created_at"`
+}
+
+// Localize converts the record's timestamp to loc
+func (p *CallPreemption) Localize(loc *time.Location) {
+	p.CreatedAt = p.CreatedAt.In(loc)
+}
+
+// Call events delivered to webhooks
+const (
+	WebhookEventCallInitiated = "call.initiated"
+	WebhookEventCallRinging   = "call.ringing"
+	WebhookEventCallAnswered  = "call.answered"
+	WebhookEventCallCompleted = "call.completed" // Also cancelled and preempted calls
+	WebhookEventCallFailed    = "call.failed"
+
+	WebhookEventCallDTMFShortcut = "call.dtmf_shortcut" // A caller pressed a route's DTMF shortcut
+)
+
+// WebhookEvents lists the events webhooks can subscribe to
+var WebhookEvents = []string{
+	WebhookEventCallInitiated, WebhookEventCallRinging, WebhookEventCallAnswered,
+	WebhookEventCallCompleted, WebhookEventCallFailed, WebhookEventCallDTMFShortcut,
+}
+
+// WebhookEventForStatus returns the event sent when a call reaches status
+func WebhookEventForStatus(status CallStatus) string {
+	switch status {
+	case CallStatusInitiated:
+		return WebhookEventCallInitiated
+	case CallStatusRinging:
+		return WebhookEventCallRinging
+	case CallStatusAnswered:
+		return WebhookEventCallAnswered
+	case CallStatusFailed:
+		return WebhookEventCallFailed
+	}
+	return WebhookEventCallCompleted
+}
+
+// Webhook is a URL an account receives call events at
+type Webhook struct {
 	ID        string    `json:"id" db:"id"`
-	Name      string    `json:"name" db:"name"`
-	APIKey    string    `json:"-" db:"api_key"` // Never expose API key in JSON
+	AccountID string    `json:"account_id" db:"account_id"`
+	URL       string    `json:"url" db:"url"`
+	Secret    string    `json:"secret,omitempty" db:"secret"` // HMAC signing key, only shown when created
+	Events    []string  `json:"events" db:"events"`           // Subscribed events; empty means all
 	Active    bool      `json:"active" db:"active"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
-// Route represents an inbound SIP routing rule
-type Route struct {
-	ID                  string                 `json:"id" db:"id"`
-	AccountID           string                 `json:"account_id" db:"account_id"`
-	Name                string                 `json:"name" db:"name"`
-	Priority            int                    `json:"priority" db:"priority"`
-	MatchToUser         *string                `json:"match_to_user,omitempty" db:"match_to_user"`
-	MatchFromUser       *string                `json:"match_from_user,omitempty" db:"match_from_user"`
-	MatchSIPHeader      *string                `json:"match_sip_header,omitempty" db:"match_sip_header"`
-	MatchSIPHeaderValue *string                `json:"match_sip_header_value,omitempty" db:"match_sip_header_value"`
-	WebSocketURL        string                 `json:"websocket_url" db:"websocket_url"`
-	CustomData          map[string]interface{} `json:"custom_data,omitempty" db:"custom_data" swaggertype:"object"`
-	Active              bool                   `json:"active" db:"active"`
-	CreatedAt           time.Time              `json:"created_at" db:"created_at"`
-	UpdatedAt           time.Time              `json:"updated_at" db:"updated_at"`
+// Validate checks the webhook's URL and events
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %w", w.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL %q must be an http:// or https:// URL", w.URL)
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			return fmt.Errorf("unknown webhook event %q (use %s)", event, strings.Join(WebhookEvents, ", "))
+		}
+	}
+	return nil
 }
 
-// Trunk represents an outbound SIP trunk configuration
-type Trunk struct {
-	ID               string    `json:"id" db:"id"`
-	AccountID        string    `json:"account_id" db:"account_id"`
-	Name             string    `json:"name" db:"name"`
-	Host             string    `json:"host" db:"host"`
-	Port             int       `json:"port" db:"port"`
-	Transport        string    `json:"transport" db:"transport"`
-	Username         *string   `json:"username,omitempty" db:"username"`
-	Password         *string   `json:"-" db:"password"` // Never expose password
-	FromUser         *string   `json:"from_user,omitempty" db:"from_user"`
-	FromHost         *string   `json:"from_host,omitempty" db:"from_host"`
-	Register         bool      `json:"register" db:"register"`
-	RegisterInterval int       `json:"register_interval" db:"register_interval"`
-	Active           bool      `json:"active" db:"active"`
-	CreatedAt        time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+// Subscribes reports whether the webhook receives an event
+func (w *Webhook) Subscribes(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
 }
 
-// CallStatus represents the state of a call
-type CallStatus string
+// Localize converts the webhook's timestamps to loc
+func (w *Webhook) Localize(loc *time.Location) {
+	w.CreatedAt = w.CreatedAt.In(loc)
+	w.UpdatedAt = w.UpdatedAt.In(loc)
+}
 
-const (
-	CallStatusInitiated CallStatus = "initiated"
-	CallStatusRinging   CallStatus = "ringing"
-	CallStatusAnswered  CallStatus = "answered"
-	CallStatusCompleted CallStatus = "completed"
-	CallStatusFailed    CallStatus = "failed"
-	CallStatusCancelled CallStatus = "cancelled"
-)
+// WebhookDeadLetter is an event that could not be delivered to a webhook
+// after all retries
+type WebhookDeadLetter struct {
+	ID         string          `json:"id" db:"id"`
+	WebhookID  string          `json:"webhook_id" db:"webhook_id"`
+	AccountID  string          `json:"account_id" db:"account_id"`
+	DeliveryID string          `json:"delivery_id" db:"delivery_id"`
+	Event      string          `json:"event" db:"event"`
+	Payload    json.RawMessage `json:"payload" db:"payload"`
+	Attempts   int             `json:"attempts" db:"attempts"`
+	LastError  string          `json:"last_error" db:"last_error"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+}
 
-// CallDirection represents whether a call is inbound or outbound
-type CallDirection string
+// Localize converts the dead letter's timestamp to loc
+func (d *WebhookDeadLetter) Localize(loc *time.Location) {
+	d.CreatedAt = d.CreatedAt.In(loc)
+}
+
+// SIPMessageDirection is whether a captured SIP message was received or sent
+type SIPMessageDirection string
 
 const (
-	CallDirectionInbound  CallDirection = "inbound"
-	CallDirectionOutbound CallDirection = "outbound"
+	SIPMessageInbound  SIPMessageDirection = "in"
+	SIPMessageOutbound SIPMessageDirection = "out"
 )
 
-// CallLog represents a call detail record (CDR)
-type CallLog struct {
-	ID              string                 `json:"id" db:"id"`
-	AccountID       *string                `json:"account_id,omitempty" db:"account_id"`
-	CallID          string                 `json:"call_id" db:"call_id"`
-	Direction       CallDirection          `json:"direction" db:"direction"`
-	FromURI         string                 `json:"from_uri" db:"from_uri"`
-	ToURI           string                 `json:"to_uri" db:"to_uri"`
-	FromUser        string                 `json:"from_user" db:"from_user"`
-	ToUser          string                 `json:"to_user" db:"to_user"`
-	RouteID         *string                `json:"route_id,omitempty" db:"route_id"`
-	TrunkID         *string                `json:"trunk_id,omitempty" db:"trunk_id"`
-	WebSocketURL    string                 `json:"websocket_url" db:"websocket_url"`
-	Status          CallStatus             `json:"status" db:"status"`
-	InitiatedAt     time.Time              `json:"initiated_at" db:"initiated_at"`
-	RingingAt       *time.Time             `json:"ringing_at,omitempty" db:"ringing_at"`
-	AnsweredAt      *time.Time             `json:"answered_at,omitempty" db:"answered_at"`
-	EndedAt         *time.Time             `json:"ended_at,omitempty" db:"ended_at"`
-	DurationSeconds *int                   `json:"duration_seconds,omitempty" db:"duration_seconds"`
-	HangupCause     *string                `json:"hangup_cause,omitempty" db:"hangup_cause"`
-	HangupParty     *string                `json:"hangup_party,omitempty" db:"hangup_party"`
-	CustomData      map[string]interface{} `json:"custom_data,omitempty" db:"custom_data" swaggertype:"object"`
-	CreatedAt       time.Time              `json:"created_at" db:"created_at"`
+// SIPMessage is a captured SIP request or response belonging to a call
+type SIPMessage struct {
+	ID         int64               `json:"id" db:"id"`
+	CallID     string              `json:"call_id" db:"call_id"`
+	Direction  SIPMessageDirection `json:"direction" db:"direction"`
+	Method     string              `json:"method" db:"method"`
+	StatusCode *int                `json:"status_code,omitempty" db:"status_code"`
+	Reason     *string             `json:"reason,omitempty" db:"reason"`
+	RemoteAddr string              `json:"remote_addr" db:"remote_addr"`
+	CapturedAt time.Time           `json:"captured_at" db:"captured_at"`
+
+	// Kept only when SIP tracing is enabled
+	LocalAddr *string `json:"local_addr,omitempty" db:"local_addr"` // Our address the message was sent from / received on
+	Raw       *string `json:"raw,omitempty" db:"raw"`               // The full message
+}
+
+// Label returns the text shown for the message in a call flow diagram
+func (m *SIPMessage) Label() string {
+	if m.StatusCode == nil {
+		return m.Method
+	}
+	reason := ""
+	if m.Reason != nil {
+		reason = " " + *m.Reason
+	}
+	return fmt.Sprintf("%d%s (%s)", *m.StatusCode, reason, m.Method)
+}
+
+// CallFlowLocalParty is the name used for blayzen-sip itself in call flows
+const CallFlowLocalParty = "blayzen-sip"
+
+// CallFlow is a ladder-diagram representation of a call's signaling
+type CallFlow struct {
+	CallID   string             `json:"call_id"`
+	Parties  []string           `json:"parties"`
+	Messages []*CallFlowMessage `json:"messages"`
+}
+
+// CallFlowMessage is a single arrow in a call flow diagram
+type CallFlowMessage struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Label     string    `json:"label"`
+	Timestamp time.Time `json:"timestamp"`
+	OffsetMs  int64     `json:"offset_ms"`
+}
+
+// NewCallFlow builds a call flow from captured messages in capture order
+func NewCallFlow(callID string, messages []*SIPMessage) *CallFlow {
+	flow := &CallFlow{
+		CallID:   callID,
+		Parties:  []string{},
+		Messages: []*CallFlowMessage{},
+	}
+
+	seen := make(map[string]bool)
+	addParty := func(party string) {
+		if !seen[party] {
+			seen[party] = true
+			flow.Parties = append(flow.Parties, party)
+		}
+	}
+
+	for _, m := range messages {
+		remote := m.RemoteAddr
+		if remote == "" {
+			remote = "unknown"
+		}
+
+		from, to := remote, CallFlowLocalParty
+		if m.Direction == SIPMessageOutbound {
+			from, to = CallFlowLocalParty, remote
+		}
+		addParty(from)
+		addParty(to)
+
+		flow.Messages = append(flow.Messages, &CallFlowMessage{
+			From:      from,
+			To:        to,
+			Label:     m.Label(),
+			Timestamp: m.CapturedAt,
+			OffsetMs:  m.CapturedAt.Sub(messages[0].CapturedAt).Milliseconds(),
+		})
+	}
+
+	return flow
+}
+
+// Localize converts the flow's timestamps to loc
+func (f *CallFlow) Localize(loc *time.Location) {
+	for _, m := range f.Messages {
+		m.Timestamp = m.Timestamp.In(loc)
+	}
+}
+
+// Localize converts the trunk's timestamps to loc
+func (t *Trunk) Localize(loc *time.Location) {
+	t.CreatedAt = t.CreatedAt.In(loc)
+	t.UpdatedAt = t.UpdatedAt.In(loc)
+}
+
+// Proxy returns the outbound proxy SIP for this trunk goes through: the
+// trunk's own, else the global one, or "" when neither is set
+func (t *Trunk) Proxy(globalProxy string) string {
+	if t.OutboundProxy != nil && *t.OutboundProxy != "" {
+		return *t.OutboundProxy
+	}
+	return globalProxy
+}
+
+// NextHop returns the address out-of-dialog egress SIP for this trunk is sent
+// to: its outbound proxy, else the trunk itself
+func (t *Trunk) NextHop(globalProxy string) string {
+	if proxy := t.Proxy(globalProxy); proxy != "" {
+		return proxy
+	}
+	return net.JoinHostPort(strings.Trim(t.Host, "[]"), strconv.Itoa(t.Port))
+}
+
+// IPv6 reports whether the trunk's host is an IPv6 address
+func (t *Trunk) IPv6() bool {
+	return strings.Contains(t.Host, ":")
+}
+
+// URIHost returns the trunk's host as written in a SIP URI, an IPv6
+// address in brackets
+func (t *Trunk) URIHost() string {
+	if t.IPv6() {
+		return "[" + strings.Trim(t.Host, "[]") + "]"
+	}
+	return t.Host
 }
 
 // Matches checks if the route matches the given criteria
 func (r *Route) Matches(toUser, fromUser string, headers map[string]string) bool {
 	// Check To User match
 	if r.MatchToUser != nil && *r.MatchToUser != "" {
-		if toUser != *r.MatchToUser {
+		if !matchNumber(r.MatchToUserType, *r.MatchToUser, toUser) {
 			return false
 		}
 	}
 
 	// Check From User match
 	if r.MatchFromUser != nil && *r.MatchFromUser != "" {
-		if fromUser != *r.MatchFromUser {
+		if !matchNumber(r.MatchFromUserType, *r.MatchFromUser, fromUser) {
 			return false
 		}
 	}
 
 	// Check custom header match
 	if r.MatchSIPHeader != nil && *r.MatchSIPHeader != "" {
-		headerValue, exists := headers[*r.MatchSIPHeader]
+		headerValue, exists := lookupHeader(headers, *r.MatchSIPHeader)
 		if !exists {
 			return false
 		}
@@ -125,6 +1824,569 @@ func (r *Route) Matches(toUser, fromUser string, headers map[string]string) bool
 		}
 	}
 
+	// All header conditions must match
+	for _, cond := range r.MatchHeaders {
+		if !cond.Matches(headers) {
+			return false
+		}
+	}
+
+	// At least one condition group must match, if any are defined
+	if len(r.MatchGroups) == 0 {
+		return true
+	}
+	for _, group := range r.MatchGroups {
+		if group.Matches(toUser, fromUser, headers) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks that the group is well formed
+func (g MatchGroup) Validate() error {
+	if len(g.ToUsers) == 0 && len(g.FromUsers) == 0 && len(g.Headers) == 0 {
+		return fmt.Errorf("match group must have at least one condition")
+	}
+	for _, cond := range g.Headers {
+		if err := cond.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches checks the group's conditions against the call
+func (g MatchGroup) Matches(toUser, fromUser string, headers map[string]string) bool {
+	if len(g.ToUsers) > 0 && !slices.Contains(g.ToUsers, toUser) {
+		return false
+	}
+	if len(g.FromUsers) > 0 && !slices.Contains(g.FromUsers, fromUser) {
+		return false
+	}
+	for _, cond := range g.Headers {
+		if !cond.Matches(headers) {
+			return false
+		}
+	}
 	return true
 }
 
+// Validate checks that the condition is well formed
+func (c HeaderCondition) Validate() error {
+	if c.Header == "" {
+		return fmt.Errorf("header name is required")
+	}
+
+	switch c.Operator {
+	case HeaderMatchExists, HeaderMatchEquals, "":
+		return nil
+	case HeaderMatchWildcard:
+		if _, err := path.Match(c.Value, ""); err != nil {
+			return fmt.Errorf("invalid wildcard %q for header %s: %w", c.Value, c.Header, err)
+		}
+		return nil
+	case HeaderMatchRegex:
+		if _, err := compileRegex(c.Value); err != nil {
+			return fmt.Errorf("invalid regex %q for header %s: %w", c.Value, c.Header, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown operator %q for header %s", c.Operator, c.Header)
+	}
+}
+
+// Matches checks the condition against the request headers
+func (c HeaderCondition) Matches(headers map[string]string) bool {
+	value, exists := lookupHeader(headers, c.Header)
+	if !exists {
+		return false
+	}
+
+	switch c.Operator {
+	case HeaderMatchExists:
+		return true
+	case HeaderMatchWildcard:
+		matched, err := path.Match(c.Value, value)
+		return err == nil && matched
+	case HeaderMatchRegex:
+		re, err := compileRegex(c.Value)
+		return err == nil && re.MatchString(value)
+	default:
+		return value == c.Value
+	}
+}
+
+// lookupHeader finds a header value by case-insensitive name, as SIP
+// header names are case-insensitive
+func lookupHeader(headers map[string]string, name string) (string, bool) {
+	if value, ok := headers[name]; ok {
+		return value, true
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// regexCache holds compiled route regexes, keyed by pattern
+var regexCache sync.Map
+
+// compileRegex compiles a pattern once and reuses it across calls
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// Validate checks that the rule is well formed
+func (r HeaderRule) Validate() error {
+	if r.Header == "" {
+		return fmt.Errorf("header name is required")
+	}
+	if slices.Contains(protectedHeaders, strings.ToLower(r.Header)) {
+		return fmt.Errorf("header %s cannot be manipulated", r.Header)
+	}
+
+	switch r.Direction {
+	case HeaderRuleIngress, HeaderRuleEgress, HeaderRuleBoth:
+	default:
+		return fmt.Errorf("unknown direction %q for header %s", r.Direction, r.Header)
+	}
+
+	switch r.Action {
+	case HeaderRuleAdd:
+		if r.Value == "" {
+			return fmt.Errorf("value is required to add header %s", r.Header)
+		}
+	case HeaderRuleRemove, HeaderRuleRewrite:
+	default:
+		return fmt.Errorf("unknown action %q for header %s", r.Action, r.Header)
+	}
+
+	if r.Match != "" {
+		if _, err := compileRegex(r.Match); err != nil {
+			return fmt.Errorf("invalid regex %q for header %s: %w", r.Match, r.Header, err)
+		}
+	}
+	return nil
+}
+
+// Applies reports whether the rule applies to a message in the given
+// direction for the given method (the CSeq method for responses)
+func (r HeaderRule) Applies(direction HeaderRuleDirection, method string) bool {
+	if r.Direction != HeaderRuleBoth && r.Direction != direction {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(r.Methods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	})
+}
+
+// MatchesValue reports whether a header value is selected by the rule's
+// regex; every value is selected when the rule has none
+func (r HeaderRule) MatchesValue(value string) bool {
+	if r.Match == "" {
+		return true
+	}
+	re, err := compileRegex(r.Match)
+	return err == nil && re.MatchString(value)
+}
+
+// RewriteValue applies a rewrite rule to a header value
+func (r HeaderRule) RewriteValue(value string) string {
+	if r.Match == "" {
+		return r.Value
+	}
+	re, err := compileRegex(r.Match)
+	if err != nil {
+		return value
+	}
+	return re.ReplaceAllString(value, r.Value)
+}
+
+// RoutingDefaults override, while the instances run, the routing settings
+// they were configured with. Unset fields keep the configured value.
+type RoutingDefaults struct {
+	DefaultWebSocketURL    *string    `json:"default_websocket_url,omitempty" example:"ws://agent:8081/ws"` // Agent of calls matching no route; empty rejects them
+	NoRouteStatus          *int       `json:"no_route_status,omitempty" example:"404"`                      // Rejects calls matching no route
+	AgentUnavailableStatus *int       `json:"agent_unavailable_status,omitempty" example:"503"`             // Rejects calls whose agent can't be reached
+	AgentConnectTimeoutMs  *int       `json:"agent_connect_timeout_ms,omitempty" example:"5000"`            // Per agent URL, before trying the next
+	UpdatedAt              *time.Time `json:"updated_at,omitempty"`
+}
+
+// CPSLimits are the INVITE rate limits set through the admin API, in new
+// calls per second, overriding the configured ones. A burst of 0 is one
+// second's worth of calls, and a rate of 0 lifts the limit.
+type CPSLimits struct {
+	PerSourceCPS    *int       `json:"per_source_cps,omitempty" example:"10"` // From one source address
+	PerSourceBurst  *int       `json:"per_source_burst,omitempty" example:"20"`
+	PerAccountCPS   *int       `json:"per_account_cps,omitempty" example:"5"` // To one account's routes
+	PerAccountBurst *int       `json:"per_account_burst,omitempty" example:"10"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+// Validate checks the rate limits set
+func (l *CPSLimits) Validate() error {
+	limits := []struct {
+		name  string
+		value *int
+	}{
+		{"per_source_cps", l.PerSourceCPS},
+		{"per_source_burst", l.PerSourceBurst},
+		{"per_account_cps", l.PerAccountCPS},
+		{"per_account_burst", l.PerAccountBurst},
+	}
+	for _, limit := range limits {
+		if limit.value != nil && *limit.value < 0 {
+			return fmt.Errorf("%s can't be negative", limit.name)
+		}
+	}
+	return nil
+}
+
+// RejectStatuses are the SIP statuses calls can be rejected with by
+// default, with their reason phrases
+var RejectStatuses = map[int]string{
+	403: "Forbidden",
+	404: "Not Found",
+	480: "Temporarily Unavailable",
+	486: "Busy Here",
+	500: "Server Internal Error",
+	503: "Service Unavailable",
+	603: "Decline",
+}
+
+// Limits of the agent connect timeout
+const (
+	MinAgentConnectTimeoutMs = 100
+	MaxAgentConnectTimeoutMs = 60000
+)
+
+// Validate checks the routing defaults set
+func (d *RoutingDefaults) Validate() error {
+	if d.DefaultWebSocketURL != nil && *d.DefaultWebSocketURL != "" {
+		if err := ValidateAgentURL(*d.DefaultWebSocketURL); err != nil {
+			return err
+		}
+	}
+	if err := validateRejectStatus("no_route_status", d.NoRouteStatus); err != nil {
+		return err
+	}
+	if err := validateRejectStatus("agent_unavailable_status", d.AgentUnavailableStatus); err != nil {
+		return err
+	}
+	if t := d.AgentConnectTimeoutMs; t != nil && (*t < MinAgentConnectTimeoutMs || *t > MaxAgentConnectTimeoutMs) {
+		return fmt.Errorf("invalid agent_connect_timeout_ms %d: must be between %d and %d", *t, MinAgentConnectTimeoutMs, MaxAgentConnectTimeoutMs)
+	}
+	return nil
+}
+
+// validateRejectStatus checks an optional default rejection status
+func validateRejectStatus(name string, status *int) error {
+	if status == nil {
+		return nil
+	}
+	if _, ok := RejectStatuses[*status]; !ok {
+		return fmt.Errorf("invalid %s %d: must be 403, 404, 480, 486, 500, 503 or 603", name, *status)
+	}
+	return nil
+}
+
+// NumberOverride redirects the calls to a number ahead of its routes until
+// it expires, for incident response: to another agent, or out through a
+// trunk. Exactly one of WebSocketURL and TrunkID is set.
+type NumberOverride struct {
+	Number       string    `json:"number" example:"18005551234"`
+	WebSocketURL *string   `json:"websocket_url,omitempty" example:"wss://standby.example.com/ws"` // Agent taking the calls
+	TrunkID      *string   `json:"trunk_id,omitempty"`                                             // Trunk the calls are redirected to
+	Reason       *string   `json:"reason,omitempty" example:"Primary agent outage"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+
+	// The trunk, loaded for routing
+	Trunk *Trunk `json:"-"`
+}
+
+// Limits of how long a number override lasts
+const (
+	DefaultNumberOverrideTTL = time.Hour
+	MaxNumberOverrideTTL     = 7 * 24 * time.Hour
+)
+
+// numberPattern matches the numbers calls are routed by
+var numberPattern = regexp.MustCompile(`^\+?[0-9A-Za-z*#._-]{1,63}$`)
+
+// Validate checks the override's number and target
+func (o *NumberOverride) Validate() error {
+	if !numberPattern.MatchString(o.Number) {
+		return fmt.Errorf("invalid number %q", o.Number)
+	}
+	hasURL := o.WebSocketURL != nil && *o.WebSocketURL != ""
+	hasTrunk := o.TrunkID != nil && *o.TrunkID != ""
+	if hasURL == hasTrunk {
+		return fmt.Errorf("exactly one of websocket_url and trunk_id must be set")
+	}
+	if hasURL {
+		return ValidateAgentURL(*o.WebSocketURL)
+	}
+	return nil
+}
+
+// Active reports whether the override applies at now
+func (o *NumberOverride) Active(now time.Time) bool {
+	return now.Before(o.ExpiresAt)
+}
+
+// RedirectURI returns the URI calls to the override's number are redirected
+// to through its trunk
+func (o *NumberOverride) RedirectURI() string {
+	uri := fmt.Sprintf("sip:%s@%s:%d", o.Number, o.Trunk.URIHost(), o.Trunk.Port)
+	if o.Trunk.Transport != "" && !strings.EqualFold(o.Trunk.Transport, "udp") {
+		uri += ";transport=" + strings.ToLower(o.Trunk.Transport)
+	}
+	return uri
+}
+
+// FailoverState is the instance of an active/standby pair serving SIP
+type FailoverState struct {
+	ActiveInstance string    `json:"active_instance" example:"sip-a"`
+	HeartbeatAt    time.Time `json:"heartbeat_at"`
+	PromotedAt     time.Time `json:"promoted_at"` // When it took over
+}
+
+// FailoverDialog is the dialog of a call in progress on the active instance
+// of a pair, persisted for the instance taking over: the INVITE received,
+// the 2xx answering it once sent, and where the INVITE came from
+type FailoverDialog struct {
+	CallID      string
+	InstanceID  string
+	AccountID   *string
+	Invite      string
+	Answer      *string
+	Source      string
+	Transport   string
+	EgressRules []HeaderRule
+	CreatedAt   time.Time
+}
+
+// SIPCredential is a username and password a caller that isn't one of the
+// account's trunks, e.g. a PBX, answers digest challenges with to call its
+// routes. Only the password's digest HA1 in the realm it was saved for is
+// stored.
+type SIPCredential struct {
+	ID        string    `json:"id" db:"id"`
+	AccountID string    `json:"account_id" db:"account_id"`
+	Username  string    `json:"username" db:"username" example:"office-pbx"`
+	Realm     string    `json:"realm" db:"realm" example:"blayzen-sip"`
+	HA1       string    `json:"-" db:"ha1"` // Never exposed
+	Password  string    `json:"-" db:"-"`   // Only set while saving
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// DigestHA1 returns the digest HA1 of a username's password in a realm,
+// MD5(username:realm:password) (RFC 2617 section 3.2.2.2)
+func DigestHA1(username, realm, password string) string {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// Minimum length of SIP credential passwords
+const MinSIPPasswordLength = 12
+
+// Validate checks the credential's username and password
+func (c *SIPCredential) Validate() error {
+	if c.Username == "" || len(c.Username) > 255 || strings.ContainsFunc(c.Username, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || r == '"' || r == '\\' || r == '@' || r == ':'
+	}) {
+		return fmt.Errorf("invalid username %q", c.Username)
+	}
+	return ValidateSIPPassword(c.Password)
+}
+
+// ValidateSIPPassword checks the length of a SIP credential's password
+func ValidateSIPPassword(password string) error {
+	if len(password) < MinSIPPasswordLength || len(password) > 255 {
+		return fmt.Errorf("password must be %d to 255 characters", MinSIPPasswordLength)
+	}
+	return nil
+}
+
+// Localize converts the credential's timestamps to loc
+func (c *SIPCredential) Localize(loc *time.Location) {
+	c.CreatedAt = c.CreatedAt.In(loc)
+	c.UpdatedAt = c.UpdatedAt.In(loc)
+}
+
+// SIPDomain is a domain an account's callers address INVITEs to, which
+// attributes calls to the account when neither their trunk nor their SIP
+// credentials do
+type SIPDomain struct {
+	ID        string    `json:"id" db:"id"`
+	AccountID string    `json:"account_id" db:"account_id"`
+	Domain    string    `json:"domain" db:"domain" example:"sip.example.com"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Validate checks the domain is a lower-case host name
+func (d *SIPDomain) Validate() error {
+	labels := strings.Split(d.Domain, ".")
+	if d.Domain == "" || len(d.Domain) > 253 {
+		return fmt.Errorf("invalid domain %q", d.Domain)
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' ||
+			strings.ContainsFunc(label, func(r rune) bool {
+				return (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-'
+			}) {
+			return fmt.Errorf("invalid domain %q", d.Domain)
+		}
+	}
+	return nil
+}
+
+// Localize converts the domain's timestamps to loc
+func (d *SIPDomain) Localize(loc *time.Location) {
+	d.CreatedAt = d.CreatedAt.In(loc)
+}
+
+// MaintenanceWindow is a period during which a route's calls are turned
+// away: redirected (302) to DivertTo when set, otherwise rejected with
+// StatusCode, after playing the Announcement as early media when there's one
+type MaintenanceWindow struct {
+	ID           string    `json:"id" db:"id"`
+	AccountID    string    `json:"account_id" db:"account_id"`
+	RouteID      string    `json:"route_id" db:"route_id"`
+	StartsAt     time.Time `json:"starts_at" db:"starts_at"`
+	EndsAt       time.Time `json:"ends_at" db:"ends_at"`
+	StatusCode   int       `json:"status_code" db:"status_code" example:"503"`
+	Reason       *string   `json:"reason,omitempty" db:"reason" example:"Agent Maintenance"`                // Reason phrase of the rejection
+	DivertTo     *string   `json:"divert_to,omitempty" db:"divert_to" example:"sip:backup@pbx.example.com"` // SIP URI calls are redirected to
+	Announcement *string   `json:"announcement,omitempty" db:"announcement" example:"file:maintenance.wav"` // Played once before rejecting, in the ringback format
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// DefaultMaintenanceStatus is the response to calls during a maintenance
+// window that doesn't set one
+const DefaultMaintenanceStatus = 503
+
+// Validate checks the window's period and how it turns calls away
+func (w *MaintenanceWindow) Validate() error {
+	if !w.EndsAt.After(w.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	if !w.EndsAt.After(time.Now()) {
+		return fmt.Errorf("ends_at must be in the future")
+	}
+	if w.StatusCode < 400 || w.StatusCode > 699 {
+		return fmt.Errorf("status_code must be between 400 and 699")
+	}
+	if w.Reason != nil && (*w.Reason == "" || strings.ContainsAny(*w.Reason, "\r\n")) {
+		return fmt.Errorf("invalid reason %q", *w.Reason)
+	}
+	if _, ok := RejectStatuses[w.StatusCode]; !ok && w.Reason == nil {
+		return fmt.Errorf("reason is required for status_code %d", w.StatusCode)
+	}
+	if w.DivertTo != nil {
+		sipURI := strings.HasPrefix(*w.DivertTo, "sip:") || strings.HasPrefix(*w.DivertTo, "sips:")
+		if !sipURI || strings.ContainsAny(*w.DivertTo, "<>\r\n ") {
+			return fmt.Errorf("invalid divert_to %q: must be a sip: or sips: URI", *w.DivertTo)
+		}
+		if w.Announcement != nil {
+			return fmt.Errorf("announcement can't be played to diverted calls")
+		}
+	}
+	if w.Announcement != nil {
+		if _, _, err := ParseRingback(*w.Announcement); err != nil {
+			return fmt.Errorf("invalid announcement: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReasonPhrase returns the reason phrase calls are rejected with
+func (w *MaintenanceWindow) ReasonPhrase() string {
+	if w.Reason != nil {
+		return *w.Reason
+	}
+	return RejectStatuses[w.StatusCode]
+}
+
+// Active reports whether the window applies at now
+func (w *MaintenanceWindow) Active(now time.Time) bool {
+	return !now.Before(w.StartsAt) && now.Before(w.EndsAt)
+}
+
+// Localize converts the window's timestamps to loc
+func (w *MaintenanceWindow) Localize(loc *time.Location) {
+	w.StartsAt = w.StartsAt.In(loc)
+	w.EndsAt = w.EndsAt.In(loc)
+	w.CreatedAt = w.CreatedAt.In(loc)
+}
+
+// ACLEntry allows or denies SIP requests from an address range. Global
+// entries apply to every caller; a trunk's entries are its carrier's ranges,
+// whose INVITEs are taken as the trunk's.
+type ACLEntry struct {
+	ID          string    `json:"id" db:"id"`
+	TrunkID     *string   `json:"trunk_id,omitempty" db:"trunk_id"` // Unset for global entries
+	Action      string    `json:"action" db:"action" example:"allow"`
+	CIDR        string    `json:"cidr" db:"cidr" example:"203.0.113.0/24"`
+	Description *string   `json:"description,omitempty" db:"description" example:"Carrier SBCs"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// ACL entry actions
+const (
+	ACLAllow = "allow"
+	ACLDeny  = "deny"
+)
+
+// Validate checks the entry's action and range, normalizing a bare address
+// to a single-address range and clearing host bits
+func (e *ACLEntry) Validate() error {
+	if e.Action != ACLAllow && e.Action != ACLDeny {
+		return fmt.Errorf("invalid action %q: must be %s or %s", e.Action, ACLAllow, ACLDeny)
+	}
+	prefix, err := ParseCIDR(e.CIDR)
+	if err != nil {
+		return err
+	}
+	e.CIDR = prefix.String()
+	return nil
+}
+
+// ParseCIDR parses an address range, or a bare address as a range of one
+func ParseCIDR(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return prefix.Masked(), nil
+}
+
+// Ban is a SIP source address whose requests are dropped until it expires,
+// having looked like a scanner
+type Ban struct {
+	Address   string    `json:"address" example:"198.51.100.23"`
+	Reason    string    `json:"reason" example:"10 failed requests within 1m0s"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,73 @@
+// Package nat finds the public IP this instance is reached at when it runs
+// behind NAT, for the SDP, Contact and registrations it sends out
+package nat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun/v3"
+)
+
+// discoverTimeout bounds a STUN binding request without a context deadline
+const discoverTimeout = 5 * time.Second
+
+// Discover asks a STUN server (host[:port], port 3478 by default) for the
+// address our UDP packets come from once through the NAT, returning its IP
+func Discover(ctx context.Context, server string) (string, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "3478")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", server)
+	if err != nil {
+		return "", fmt.Errorf("dial STUN server %s: %w", server, err)
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(discoverTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	req, err := stun.Build(stun.TransactionID, stun.BindingRequest, stun.Fingerprint)
+	if err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(req.Raw); err != nil {
+		return "", fmt.Errorf("send STUN binding request: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", fmt.Errorf("read STUN binding response: %w", err)
+		}
+
+		res := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err := res.Decode(); err != nil || res.TransactionID != req.TransactionID {
+			continue // Not the answer to our request
+		}
+		if res.Type != stun.BindingSuccess {
+			return "", errors.New("STUN binding request failed")
+		}
+
+		var xor stun.XORMappedAddress
+		if err := xor.GetFrom(res); err == nil {
+			return xor.IP.String(), nil
+		}
+		var mapped stun.MappedAddress // RFC 3489 servers
+		if err := mapped.GetFrom(res); err == nil {
+			return mapped.IP.String(), nil
+		}
+		return "", errors.New("STUN binding response without a mapped address")
+	}
+}
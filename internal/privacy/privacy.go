@@ -0,0 +1,210 @@
+// Package privacy masks caller and callee numbers in application logs and
+// stored call records, for deployments whose privacy policy forbids keeping
+// raw MSISDNs in plaintext
+package privacy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Masking modes
+const (
+	ModeOff      = "off"
+	ModeHash     = "hash"     // Keyed hash, so the same number always masks the same way
+	ModeTruncate = "truncate" // Last digits replaced, keeping the country and area code
+)
+
+// truncatedDigits is how many trailing characters truncation hides
+const truncatedDigits = 4
+
+// ErrNoKey is returned when decrypting without NUMBER_ENCRYPTION_KEY
+var ErrNoKey = errors.New("no number encryption key configured")
+
+var (
+	logMasker Masker
+	cdrMasker Masker
+	cdrCipher cipher.AEAD
+)
+
+// Masker masks numbers in one of the masking modes
+type Masker struct {
+	mode    string
+	hashKey []byte
+}
+
+// Configure sets up masking of logs and call records from the configuration.
+// Call it once at startup, before calls are handled.
+func Configure(cfg *config.Config) error {
+	var err error
+	if logMasker, err = newMasker(cfg.LogNumberMasking, cfg.NumberHashKey); err != nil {
+		return fmt.Errorf("LOG_NUMBER_MASKING: %w", err)
+	}
+	if cdrMasker, err = newMasker(cfg.CDRNumberMasking, cfg.NumberHashKey); err != nil {
+		return fmt.Errorf("CDR_NUMBER_MASKING: %w", err)
+	}
+
+	cdrCipher = nil
+	if cfg.NumberEncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.NumberEncryptionKey)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("NUMBER_ENCRYPTION_KEY must be 32 bytes, base64-encoded")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		if cdrCipher, err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newMasker validates a masking mode
+func newMasker(mode, hashKey string) (Masker, error) {
+	switch mode {
+	case "", ModeOff:
+		return Masker{mode: ModeOff}, nil
+	case ModeHash:
+		if hashKey == "" {
+			return Masker{}, fmt.Errorf("hash mode requires NUMBER_HASH_KEY")
+		}
+		return Masker{mode: mode, hashKey: []byte(hashKey)}, nil
+	case ModeTruncate:
+		return Masker{mode: mode}, nil
+	}
+	return Masker{}, fmt.Errorf("unknown masking mode %q (must be off, hash or truncate)", mode)
+}
+
+// Number masks a phone number or other SIP user part
+func (m Masker) Number(number string) string {
+	if number == "" {
+		return ""
+	}
+
+	switch m.mode {
+	case ModeHash:
+		mac := hmac.New(sha256.New, m.hashKey)
+		mac.Write([]byte(number))
+		return "h:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	case ModeTruncate:
+		if len(number) <= truncatedDigits {
+			return strings.Repeat("*", len(number))
+		}
+		return number[:len(number)-truncatedDigits] + strings.Repeat("*", truncatedDigits)
+	}
+	return number
+}
+
+// URI masks the user part of a SIP or tel URI
+func (m Masker) URI(uri string) string {
+	if m.mode == ModeOff || uri == "" {
+		return uri
+	}
+
+	scheme, rest, ok := strings.Cut(uri, ":")
+	if !ok {
+		return m.Number(uri)
+	}
+	user, host, ok := strings.Cut(rest, "@")
+	if !ok {
+		return scheme + ":" + m.Number(rest)
+	}
+	return scheme + ":" + m.Number(user) + "@" + host
+}
+
+// Log masks a number for application logs
+func Log(number string) string {
+	return logMasker.Number(number)
+}
+
+// LogURI masks a URI for application logs
+func LogURI(uri string) string {
+	return logMasker.URI(uri)
+}
+
+// CDRNumbersDistinct reports whether numbers as call records store them still
+// tell callers apart: unmasked or hashed, but not truncated
+func CDRNumbersDistinct() bool {
+	return cdrMasker.mode != ModeTruncate
+}
+
+// CDRNumber masks a number as call records store it, to search them by
+// number. Numbers already masked, as call records show them, are returned
+// unchanged.
+func CDRNumber(number string) string {
+	if cdrMasker.mode == ModeHash && strings.HasPrefix(number, "h:") ||
+		cdrMasker.mode == ModeTruncate && strings.HasSuffix(number, "*") {
+		return number
+	}
+	return cdrMasker.Number(number)
+}
+
+// MaskCallLog masks the numbers of a call record before it is stored. With an
+// encryption key the raw numbers are kept encrypted for authorized lookup; the
+// record is masked even when encrypting fails.
+func MaskCallLog(c *models.CallLog) error {
+	if cdrMasker.mode == ModeOff {
+		return nil
+	}
+
+	fromUser, toUser := c.FromUser, c.ToUser
+	c.FromURI = cdrMasker.URI(c.FromURI)
+	c.ToURI = cdrMasker.URI(c.ToURI)
+	c.FromUser = cdrMasker.Number(fromUser)
+	c.ToUser = cdrMasker.Number(toUser)
+
+	if cdrCipher == nil {
+		return nil
+	}
+	from, err := encrypt(fromUser)
+	if err != nil {
+		return err
+	}
+	to, err := encrypt(toUser)
+	if err != nil {
+		return err
+	}
+	c.FromUserEncrypted = &from
+	c.ToUserEncrypted = &to
+	return nil
+}
+
+// encrypt seals a number as base64(nonce || ciphertext)
+func encrypt(number string) (string, error) {
+	nonce := make([]byte, cdrCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(cdrCipher.Seal(nonce, nonce, []byte(number), nil)), nil
+}
+
+// DecryptNumber opens a number encrypted by MaskCallLog
+func DecryptNumber(encrypted string) (string, error) {
+	if cdrCipher == nil {
+		return "", ErrNoKey
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < cdrCipher.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted number")
+	}
+	nonce, sealed := data[:cdrCipher.NonceSize()], data[cdrCipher.NonceSize():]
+	plain, err := cdrCipher.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt number: %w", err)
+	}
+	return string(plain), nil
+}
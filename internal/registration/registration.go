@@ -0,0 +1,345 @@
+// Package registration keeps trunks that require it registered with their
+// provider, so the provider sends their inbound calls to us. It should run on
+// one instance at a time; see package leader.
+package registration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/google/uuid"
+	"github.com/icholy/digest"
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+var logger = logging.Component("registration")
+
+// Registration timing
+const (
+	syncInterval           = 30 * time.Second // How often trunk changes are picked up
+	defaultRegisterExpires = 3600             // Seconds, for trunks without a register_interval
+	minRefresh             = 30 * time.Second
+	retryMin               = 30 * time.Second
+	retryMax               = 5 * time.Minute
+	requestTimeout         = 30 * time.Second
+)
+
+// ResponseRecorder counts a final response from a trunk (see
+// call.Manager.RecordTrunkResponse)
+type ResponseRecorder func(trunkID string, direction models.CallDirection, method string, statusCode int)
+
+// Manager registers trunks with their providers and refreshes the
+// registrations before they expire
+type Manager struct {
+	config       *config.Config
+	store        *store.PostgresStore
+	client       *sipgo.Client
+	contactHost  string // Address providers send calls to
+	contactHost6 string // The same, for providers at an IPv6 address
+	record       ResponseRecorder
+}
+
+// New creates a registration manager. contactHost is the address put in
+// the Contact of registrations, contactHost6 that of registrations with
+// trunks at an IPv6 address.
+func New(cfg *config.Config, st *store.PostgresStore, client *sipgo.Client, contactHost, contactHost6 string, record ResponseRecorder) *Manager {
+	return &Manager{
+		config:       cfg,
+		store:        st,
+		client:       client,
+		contactHost:  contactHost,
+		contactHost6: contactHost6,
+		record:       record,
+	}
+}
+
+// binding is a trunk's registration kept alive in the background
+type binding struct {
+	trunk  models.Trunk
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Run keeps registering trunks until ctx is cancelled, then unregisters them
+func (m *Manager) Run(ctx context.Context) {
+	bindings := make(map[string]*binding)
+	defer func() {
+		for _, b := range bindings {
+			b.cancel()
+		}
+		for _, b := range bindings {
+			<-b.done
+		}
+	}()
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		m.sync(ctx, bindings)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync starts registering new trunks, restarts changed ones and stops
+// registering trunks that were removed, deactivated or no longer register
+func (m *Manager) sync(ctx context.Context, bindings map[string]*binding) {
+	trunks, err := m.store.ListRegisteringTrunks(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("Failed to list trunks to register", "error", err)
+		}
+		return
+	}
+
+	wanted := make(map[string]*models.Trunk, len(trunks))
+	for _, t := range trunks {
+		wanted[t.ID] = t
+	}
+
+	for id, b := range bindings {
+		if t, ok := wanted[id]; ok && sameRegistration(&b.trunk, t) {
+			continue
+		}
+		b.cancel()
+		<-b.done
+		delete(bindings, id)
+	}
+
+	for id, t := range wanted {
+		if _, ok := bindings[id]; ok {
+			continue
+		}
+		bctx, cancel := context.WithCancel(ctx)
+		b := &binding{trunk: *t, cancel: cancel, done: make(chan struct{})}
+		bindings[id] = b
+		go func() {
+			defer close(b.done)
+			m.maintain(bctx, &b.trunk)
+		}()
+	}
+}
+
+// sameRegistration reports whether a trunk's registration settings are
+// unchanged
+func sameRegistration(a, b *models.Trunk) bool {
+	return a.Host == b.Host && a.Port == b.Port && a.Transport == b.Transport &&
+		deref(a.Username) == deref(b.Username) && deref(a.Password) == deref(b.Password) &&
+		deref(a.FromUser) == deref(b.FromUser) && deref(a.FromHost) == deref(b.FromHost) &&
+		a.RegisterInterval == b.RegisterInterval && deref(a.OutboundProxy) == deref(b.OutboundProxy)
+}
+
+// maintain registers a trunk and refreshes the registration until ctx is
+// cancelled, then unregisters it
+func (m *Manager) maintain(ctx context.Context, trunk *models.Trunk) {
+	log := logger.With("trunk_id", trunk.ID, "account_id", trunk.AccountID)
+	reg := &registrar{m: m, trunk: trunk, callID: uuid.New().String(), log: log}
+
+	expires := trunk.RegisterInterval
+	if expires <= 0 {
+		expires = defaultRegisterExpires
+	}
+
+	registered := false
+	backoff := retryMin
+	for {
+		granted, err := reg.register(ctx, expires)
+		wait := backoff
+		switch {
+		case ctx.Err() != nil:
+		case err != nil:
+			log.Warn("Trunk registration failed", "error", err, "retry_in", backoff)
+			metrics.TrunkRegistrations.With(trunk.ID, "failure").Inc()
+			backoff = min(backoff*2, retryMax)
+		default:
+			if !registered {
+				log.Info("Trunk registered", "expires", granted)
+			}
+			metrics.TrunkRegistrations.With(trunk.ID, "success").Inc()
+			registered = true
+			backoff = retryMin
+			// Refresh well before the registration expires
+			wait = max(time.Duration(granted)*time.Second*8/10, minRefresh)
+		}
+
+		select {
+		case <-ctx.Done():
+			if registered {
+				reg.unregister()
+			}
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// unregister removes our binding, so the provider stops sending calls to
+// this instance once another takes over
+func (r *registrar) unregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := r.register(ctx, 0); err != nil {
+		r.log.Warn("Trunk unregistration failed", "error", err)
+		return
+	}
+	r.log.Info("Trunk unregistered")
+}
+
+// registrar sends a trunk's REGISTER requests. Refreshes share a Call-ID,
+// with increasing CSeq numbers.
+type registrar struct {
+	m      *Manager
+	trunk  *models.Trunk
+	callID string
+	cseq   uint32
+	log    *slog.Logger
+}
+
+// register sends a REGISTER asking for expires seconds (0 unregisters),
+// answering any digest challenge, and returns the expiry granted
+func (r *registrar) register(ctx context.Context, expires int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req := r.newRequest(expires)
+	res, err := r.m.client.Do(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	r.cseq = req.CSeq().SeqNo
+
+	if res.StatusCode == sip.StatusUnauthorized || res.StatusCode == sip.StatusProxyAuthRequired {
+		r.recordResponse(res)
+		req = r.newRequest(expires)
+		if err := r.authorize(req, res); err != nil {
+			return 0, err
+		}
+		if res, err = r.m.client.Do(ctx, req); err != nil {
+			return 0, err
+		}
+		r.cseq = req.CSeq().SeqNo
+	}
+
+	r.recordResponse(res)
+	if !res.IsSuccess() {
+		return 0, fmt.Errorf("registration rejected: %d %s", res.StatusCode, res.Reason)
+	}
+
+	granted := expires
+	if h := res.GetHeader("Expires"); h != nil {
+		if v, err := strconv.Atoi(strings.TrimSpace(h.Value())); err == nil && v >= 0 {
+			granted = v
+		}
+	}
+	return granted, nil
+}
+
+// newRequest builds a REGISTER for the trunk's address of record, binding
+// it to our contact address
+func (r *registrar) newRequest(expires int) *sip.Request {
+	trunk := r.trunk
+	user := deref(trunk.FromUser)
+	if user == "" {
+		user = deref(trunk.Username)
+	}
+	domain := deref(trunk.FromHost)
+	if domain == "" {
+		domain = trunk.URIHost()
+	}
+
+	req := sip.NewRequest(sip.REGISTER, sip.Uri{Host: domain})
+	aor := sip.Uri{User: user, Host: domain}
+	from := &sip.FromHeader{Address: aor, Params: sip.NewParams()}
+	from.Params.Add("tag", sip.GenerateTagN(16))
+	req.AppendHeader(from)
+	req.AppendHeader(&sip.ToHeader{Address: aor, Params: sip.NewParams()})
+
+	contactHost := r.m.contactHost
+	if trunk.IPv6() && strings.Contains(r.m.contactHost6, ":") {
+		contactHost = "[" + r.m.contactHost6 + "]"
+	}
+	contact := sip.Uri{User: user, Host: contactHost, Port: r.m.config.SIPPort, UriParams: sip.NewParams()}
+	transport := strings.ToLower(trunk.Transport)
+	if transport != "" && transport != "udp" {
+		contact.UriParams.Add("transport", transport)
+	}
+	req.AppendHeader(&sip.ContactHeader{Address: contact})
+	req.AppendHeader(sip.NewHeader("Expires", strconv.Itoa(expires)))
+
+	callID := sip.CallIDHeader(r.callID)
+	req.AppendHeader(&callID)
+	// Incremented as the request is sent
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: r.cseq, MethodName: sip.REGISTER})
+
+	if transport != "" {
+		req.SetTransport(strings.ToUpper(transport))
+	}
+	req.SetDestination(trunk.NextHop(r.m.config.SIPOutboundProxy))
+	return req
+}
+
+// recordResponse counts a final response in the trunk's stats
+func (r *registrar) recordResponse(res *sip.Response) {
+	if r.m.record != nil {
+		r.m.record(r.trunk.ID, models.CallDirectionOutbound, string(sip.REGISTER), int(res.StatusCode))
+	}
+}
+
+// authorize answers a digest challenge to a REGISTER. sipgo's DoDigestAuth
+// isn't used as it digests "sip:@domain" for a Request-URI without a user.
+func (r *registrar) authorize(req *sip.Request, challenge *sip.Response) error {
+	if r.trunk.Username == nil || r.trunk.Password == nil {
+		return fmt.Errorf("registration challenged (%d) but the trunk has no credentials", challenge.StatusCode)
+	}
+
+	challengeHeader, authHeader := "WWW-Authenticate", "Authorization"
+	if challenge.StatusCode == sip.StatusProxyAuthRequired {
+		challengeHeader, authHeader = "Proxy-Authenticate", "Proxy-Authorization"
+	}
+	h := challenge.GetHeader(challengeHeader)
+	if h == nil {
+		return fmt.Errorf("registration challenged (%d) without %s", challenge.StatusCode, challengeHeader)
+	}
+
+	chal, err := digest.ParseChallenge(h.Value())
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", challengeHeader, err)
+	}
+	chal.Algorithm = strings.ToUpper(chal.Algorithm)
+
+	cred, err := digest.Digest(chal, digest.Options{
+		Method:   string(sip.REGISTER),
+		URI:      req.Recipient.String(),
+		Username: *r.trunk.Username,
+		Password: *r.trunk.Password,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to answer challenge: %w", err)
+	}
+	req.AppendHeader(sip.NewHeader(authHeader, cred.String()))
+	return nil
+}
+
+// deref returns a string pointer's value, or ""
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
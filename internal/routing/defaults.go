@@ -0,0 +1,143 @@
+package routing
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+// defaultsRefreshInterval is how often routing defaults changed on another
+// instance are picked up
+const defaultsRefreshInterval = 15 * time.Second
+
+// Statuses calls are rejected with unless changed at runtime
+const (
+	DefaultNoRouteStatus          = 404
+	DefaultAgentUnavailableStatus = 503
+)
+
+// EffectiveDefaults are the routing defaults in use: the configured ones with
+// the runtime overrides applied
+type EffectiveDefaults struct {
+	DefaultWebSocketURL    string `json:"default_websocket_url" example:"ws://agent:8081/ws"`
+	NoRouteStatus          int    `json:"no_route_status" example:"404"`
+	AgentUnavailableStatus int    `json:"agent_unavailable_status" example:"503"`
+	AgentConnectTimeoutMs  int    `json:"agent_connect_timeout_ms" example:"5000"`
+}
+
+// AgentConnectTimeout returns the agent connect timeout as a duration
+func (e *EffectiveDefaults) AgentConnectTimeout() time.Duration {
+	return time.Duration(e.AgentConnectTimeoutMs) * time.Millisecond
+}
+
+// NoRouteReason returns the reason phrase of the no-route status
+func (e *EffectiveDefaults) NoRouteReason() string {
+	return models.RejectStatuses[e.NoRouteStatus]
+}
+
+// AgentUnavailableReason returns the reason phrase of the agent-unavailable
+// status
+func (e *EffectiveDefaults) AgentUnavailableReason() string {
+	return models.RejectStatuses[e.AgentUnavailableStatus]
+}
+
+// Defaults holds the routing defaults, which the admin API can change while
+// calls are in progress. Changes are saved in Postgres and picked up by the
+// other instances within defaultsRefreshInterval.
+type Defaults struct {
+	config *config.Config
+	store  *store.PostgresStore
+
+	overrides atomic.Pointer[models.RoutingDefaults]
+	effective atomic.Pointer[EffectiveDefaults]
+}
+
+// NewDefaults creates the routing defaults, starting from the configured
+// values until the overrides are loaded
+func NewDefaults(cfg *config.Config, store *store.PostgresStore) *Defaults {
+	d := &Defaults{config: cfg, store: store}
+	d.apply(&models.RoutingDefaults{})
+	return d
+}
+
+// Get returns the routing defaults in use
+func (d *Defaults) Get() *EffectiveDefaults {
+	return d.effective.Load()
+}
+
+// Overrides returns the routing defaults changed at runtime
+func (d *Defaults) Overrides() *models.RoutingDefaults {
+	return d.overrides.Load()
+}
+
+// Load reads the overrides from the database
+func (d *Defaults) Load(ctx context.Context) error {
+	overrides, err := d.store.GetRoutingDefaults(ctx)
+	if err != nil {
+		return err
+	}
+	d.apply(overrides)
+	return nil
+}
+
+// Set validates and saves new overrides, replacing the previous ones, and
+// applies them on this instance at once
+func (d *Defaults) Set(ctx context.Context, overrides *models.RoutingDefaults) (*models.RoutingDefaults, error) {
+	if err := overrides.Validate(); err != nil {
+		return nil, err
+	}
+	saved, err := d.store.SetRoutingDefaults(ctx, overrides)
+	if err != nil {
+		return nil, err
+	}
+	d.apply(saved)
+	logger.Info("Routing defaults changed", "defaults", d.Get())
+	return saved, nil
+}
+
+// Run reloads the overrides periodically until ctx is cancelled
+func (d *Defaults) Run(ctx context.Context) {
+	ticker := time.NewTicker(defaultsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Load(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to load routing defaults", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// apply makes overrides and the defaults they result in current
+func (d *Defaults) apply(overrides *models.RoutingDefaults) {
+	e := &EffectiveDefaults{
+		DefaultWebSocketURL:    d.config.DefaultWebSocketURL,
+		NoRouteStatus:          DefaultNoRouteStatus,
+		AgentUnavailableStatus: DefaultAgentUnavailableStatus,
+		AgentConnectTimeoutMs:  int(d.config.AgentConnectTimeout.Milliseconds()),
+	}
+	if overrides.DefaultWebSocketURL != nil {
+		e.DefaultWebSocketURL = *overrides.DefaultWebSocketURL
+	}
+	if overrides.NoRouteStatus != nil {
+		e.NoRouteStatus = *overrides.NoRouteStatus
+	}
+	if overrides.AgentUnavailableStatus != nil {
+		e.AgentUnavailableStatus = *overrides.AgentUnavailableStatus
+	}
+	if overrides.AgentConnectTimeoutMs != nil {
+		e.AgentConnectTimeoutMs = *overrides.AgentConnectTimeoutMs
+	}
+
+	d.overrides.Store(overrides)
+	d.effective.Store(e)
+}
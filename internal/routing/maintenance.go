@@ -0,0 +1,96 @@
+package routing
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+// Maintenance holds the route maintenance windows that haven't ended, which
+// turn away the calls their routes match while they last. Changes are saved
+// in Postgres and picked up by the other instances within
+// defaultsRefreshInterval; windows stop applying at their end on their own.
+type Maintenance struct {
+	store   *store.PostgresStore
+	windows atomic.Pointer[map[string][]*models.MaintenanceWindow]
+}
+
+// NewMaintenance creates the maintenance windows, empty until loaded
+func NewMaintenance(store *store.PostgresStore) *Maintenance {
+	m := &Maintenance{store: store}
+	m.windows.Store(&map[string][]*models.MaintenanceWindow{})
+	return m
+}
+
+// Lookup returns the maintenance window a route is in now, if any; the
+// earliest started when several overlap
+func (m *Maintenance) Lookup(routeID string) *models.MaintenanceWindow {
+	now := time.Now()
+	for _, w := range (*m.windows.Load())[routeID] {
+		if w.Active(now) {
+			return w
+		}
+	}
+	return nil
+}
+
+// Load reads the windows that haven't ended from the database
+func (m *Maintenance) Load(ctx context.Context) error {
+	windows, err := m.store.ListMaintenanceWindows(ctx)
+	if err != nil {
+		return err
+	}
+	byRoute := make(map[string][]*models.MaintenanceWindow)
+	for _, w := range windows {
+		byRoute[w.RouteID] = append(byRoute[w.RouteID], w)
+	}
+	m.windows.Store(&byRoute)
+	return nil
+}
+
+// Schedule validates and saves a maintenance window, and applies it on this
+// instance at once
+func (m *Maintenance) Schedule(ctx context.Context, w *models.MaintenanceWindow) (*models.MaintenanceWindow, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	saved, err := m.store.CreateMaintenanceWindow(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Load(ctx); err != nil {
+		return nil, err
+	}
+	logger.Info("Route maintenance scheduled", "route_id", saved.RouteID, "starts_at", saved.StartsAt, "ends_at", saved.EndsAt)
+	return saved, nil
+}
+
+// Delete removes a route's maintenance window, on this instance at once
+func (m *Maintenance) Delete(ctx context.Context, accountID, routeID, windowID string) error {
+	if err := m.store.DeleteMaintenanceWindow(ctx, accountID, routeID, windowID); err != nil {
+		return err
+	}
+	logger.Info("Route maintenance removed", "route_id", routeID, "window_id", windowID)
+	return m.Load(ctx)
+}
+
+// Run reloads the windows periodically until ctx is cancelled
+func (m *Maintenance) Run(ctx context.Context) {
+	ticker := time.NewTicker(defaultsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Load(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to load route maintenance windows", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package routing
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/privacy"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+// NumberOverrides holds the number overrides in effect, which take calls to
+// their numbers ahead of route evaluation. Changes are saved in Postgres and
+// picked up by the other instances within defaultsRefreshInterval.
+type NumberOverrides struct {
+	store   *store.PostgresStore
+	entries atomic.Pointer[map[string]*models.NumberOverride]
+}
+
+// NewNumberOverrides creates the number overrides, empty until loaded
+func NewNumberOverrides(store *store.PostgresStore) *NumberOverrides {
+	o := &NumberOverrides{store: store}
+	o.entries.Store(&map[string]*models.NumberOverride{})
+	return o
+}
+
+// Lookup returns the override of a number, if one is in effect
+func (o *NumberOverrides) Lookup(number string) *models.NumberOverride {
+	override := (*o.entries.Load())[number]
+	if override == nil || !override.Active(time.Now()) {
+		return nil
+	}
+	return override
+}
+
+// Load reads the overrides from the database
+func (o *NumberOverrides) Load(ctx context.Context) error {
+	overrides, err := o.store.ListNumberOverrides(ctx)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]*models.NumberOverride, len(overrides))
+	for _, override := range overrides {
+		entries[override.Number] = override
+	}
+	o.entries.Store(&entries)
+	return nil
+}
+
+// Set validates and saves the override of a number, replacing any previous
+// one, and applies it on this instance at once
+func (o *NumberOverrides) Set(ctx context.Context, override *models.NumberOverride) (*models.NumberOverride, error) {
+	if err := override.Validate(); err != nil {
+		return nil, err
+	}
+	saved, err := o.store.SetNumberOverride(ctx, override)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.Load(ctx); err != nil {
+		return nil, err
+	}
+	logger.Warn("Number override set", "number", privacy.Log(saved.Number), "expires_at", saved.ExpiresAt)
+	return saved, nil
+}
+
+// Delete removes the override of a number, on this instance at once
+func (o *NumberOverrides) Delete(ctx context.Context, number string) error {
+	if err := o.store.DeleteNumberOverride(ctx, number); err != nil {
+		return err
+	}
+	logger.Info("Number override removed", "number", privacy.Log(number))
+	return o.Load(ctx)
+}
+
+// Run reloads the overrides periodically until ctx is cancelled
+func (o *NumberOverrides) Run(ctx context.Context) {
+	ticker := time.NewTicker(defaultsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := o.Load(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to load number overrides", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// OverrideRoute returns the route of a call whose number is overridden to
+// an agent: a copy of the route it matched with the override's agent in
+// place of the route's
+func OverrideRoute(route *models.Route, override *models.NumberOverride) *models.Route {
+	return route.WithAgent(*override.WebSocketURL)
+}
@@ -3,36 +3,77 @@ package routing
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
 	"github.com/shiv6146/blayzen-sip/internal/models"
 	"github.com/shiv6146/blayzen-sip/internal/store"
 )
 
+var logger = logging.Component("routing")
+
+// changesRetryDelay is how long to wait before listening for route changes
+// again after the connection failed
+const changesRetryDelay = time.Second
+
+// Selection strategies for several matching routes of equal priority
+const (
+	StrategyFirst      = "first"
+	StrategyRoundRobin = "round_robin"
+	StrategyRandom     = "random"
+)
+
 // Router handles inbound call routing
 type Router struct {
-	store          *store.PostgresStore
-	cache          *store.Cache
-	defaultWSURL   string
+	store    *store.PostgresStore
+	cache    *store.Cache
+	defaults *Defaults
+	strategy string
+
+	// Local round-robin counters, used when Valkey is unavailable
+	rrMu       sync.Mutex
+	rrCounters map[string]uint64
 }
 
-// NewRouter creates a new routing engine
-func NewRouter(store *store.PostgresStore, cache *store.Cache, defaultWSURL string) *Router {
+// NewRouter creates a new routing engine. Calls matching no route go to the
+// default agent in defaults, if any.
+func NewRouter(store *store.PostgresStore, cache *store.Cache, defaults *Defaults, strategy string) *Router {
+	switch strategy {
+	case StrategyFirst, StrategyRoundRobin, StrategyRandom:
+	default:
+		if strategy != "" {
+			logger.Warn("Unknown route selection strategy", "strategy", strategy, "using", StrategyFirst)
+		}
+		strategy = StrategyFirst
+	}
+
 	return &Router{
-		store:        store,
-		cache:        cache,
-		defaultWSURL: defaultWSURL,
+		store:      store,
+		cache:      cache,
+		defaults:   defaults,
+		strategy:   strategy,
+		rrCounters: make(map[string]uint64),
 	}
 }
 
-// FindRoute finds the best matching route for an inbound call
-func (r *Router) FindRoute(ctx context.Context, toUser, fromUser string, headers map[string]string) (*models.Route, error) {
+// FindRoute finds the best matching route for an inbound call among the
+// routes of the account it's attributed to, or of every account when
+// accountID is ""
+func (r *Router) FindRoute(ctx context.Context, accountID, toUser, fromUser string, headers map[string]string) (*models.Route, error) {
 	// Try cache first
 	var routes []*models.Route
 	var err error
 
 	if r.cache != nil {
-		routes, err = r.cache.GetCachedRoutes(ctx, toUser, fromUser)
+		routes, err = r.cache.GetCachedRoutes(ctx, accountID, toUser, fromUser)
 		if err != nil {
 			// Log but don't fail - fall back to database
 			routes = nil
@@ -41,35 +82,105 @@ func (r *Router) FindRoute(ctx context.Context, toUser, fromUser string, headers
 
 	// If not in cache, query database
 	if routes == nil {
-		routes, err = r.store.FindMatchingRoutes(ctx, toUser, fromUser)
+		routes, err = r.store.FindMatchingRoutes(ctx, accountID, toUser, fromUser)
 		if err != nil {
 			return nil, fmt.Errorf("failed to find routes: %w", err)
 		}
 
 		// Cache the results
 		if r.cache != nil && len(routes) > 0 {
-			_ = r.cache.CacheRoutes(ctx, toUser, fromUser, routes)
+			_ = r.cache.CacheRoutes(ctx, accountID, toUser, fromUser, routes)
 		}
 	}
 
-	// Find best match considering custom headers
+	// Collect the highest-priority matches considering custom headers and
+	// schedules. Routes outside their schedule send calls to their
+	// after-hours agent, or leave them to the routes below.
+	now := time.Now()
+	var candidates []*models.Route
 	for _, route := range routes {
-		if route.Matches(toUser, fromUser, headers) {
-			return route, nil
+		if !route.Matches(toUser, fromUser, headers) {
+			continue
+		}
+		if route.Schedule != nil && !route.Schedule.Open(now) {
+			if route.Schedule.AfterHoursWebSocketURL == nil {
+				continue
+			}
+			route = route.AfterHours()
 		}
+		if len(candidates) > 0 && route.Priority < candidates[0].Priority {
+			break
+		}
+		candidates = append(candidates, route)
+	}
+
+	if len(candidates) > 0 {
+		return r.selectRoute(ctx, candidates), nil
 	}
 
 	// No specific route found, use default if available
-	if r.defaultWSURL != "" {
+	if defaultWSURL := r.defaults.Get().DefaultWebSocketURL; defaultWSURL != "" {
 		return &models.Route{
 			Name:         "default",
-			WebSocketURL: r.defaultWSURL,
+			WebSocketURL: defaultWSURL,
 		}, nil
 	}
 
 	return nil, fmt.Errorf("no matching route found for to=%s from=%s", toUser, fromUser)
 }
 
+// selectRoute picks one of several equal-priority routes using the
+// configured strategy
+func (r *Router) selectRoute(ctx context.Context, candidates []*models.Route) *models.Route {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	// Stable order so every instance agrees on what index N means
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	switch r.strategy {
+	case StrategyRandom:
+		return candidates[rand.Intn(len(candidates))]
+	case StrategyRoundRobin:
+		n := r.nextRoundRobin(ctx, candidateKey(candidates))
+		return candidates[n%uint64(len(candidates))]
+	default:
+		return candidates[0]
+	}
+}
+
+// nextRoundRobin returns the next counter value for a candidate set, shared
+// across instances via Valkey when available
+func (r *Router) nextRoundRobin(ctx context.Context, key string) uint64 {
+	if r.cache != nil {
+		n, err := r.cache.IncrRoundRobin(ctx, key)
+		if err == nil {
+			return uint64(n - 1)
+		}
+		logger.Warn("Round-robin counter unavailable, using local counter", "error", err)
+	}
+
+	r.rrMu.Lock()
+	defer r.rrMu.Unlock()
+
+	n := r.rrCounters[key]
+	r.rrCounters[key]++
+	return n
+}
+
+// candidateKey identifies a set of candidate routes
+func candidateKey(candidates []*models.Route) string {
+	ids := make([]string, len(candidates))
+	for i, route := range candidates {
+		ids[i] = route.ID
+	}
+	sum := sha1.Sum([]byte(strings.Join(ids, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
 // InvalidateCache invalidates the routing cache
 func (r *Router) InvalidateCache(ctx context.Context) error {
 	if r.cache != nil {
@@ -78,3 +189,34 @@ func (r *Router) InvalidateCache(ctx context.Context) error {
 	return nil
 }
 
+// WatchChanges invalidates the routing cache whenever routes or trunks change
+// in the database, by any client, until ctx is cancelled. After the
+// notification connection fails the cache is invalidated again as it's
+// reopened, for changes missed meanwhile.
+func (r *Router) WatchChanges(ctx context.Context) {
+	if r.cache == nil {
+		return
+	}
+
+	for {
+		err := r.store.ListenRouteChanges(ctx, func(table string) {
+			metrics.RouteCacheInvalidations.With(table).Inc()
+			if err := r.InvalidateCache(ctx); err != nil && ctx.Err() == nil {
+				logger.Warn("Failed to invalidate route cache", "table", table, "error", err)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn("Route change notifications stopped, listening again", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(changesRetryDelay):
+		}
+		if err := r.InvalidateCache(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to invalidate route cache", "error", err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package scanner bans SIP sources that look like scanners, fail2ban-style:
+// those announcing a known scanner User-Agent, and those failing too many
+// requests (unknown numbers, wrong credentials, unsupported methods) in a
+// short window. Bans are kept in Valkey so every instance drops the source,
+// or in memory when there is no Valkey.
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+var logger = logging.Component("scanner")
+
+// recheckInterval is how long a ban read from Valkey is trusted before it
+// is read again, so bans lifted on another instance apply here too
+const recheckInterval = 10 * time.Second
+
+// pruneInterval is how often expired bans and failure counts are forgotten
+const pruneInterval = time.Minute
+
+// ErrNotBanned means the address isn't banned
+var ErrNotBanned = errors.New("address not banned")
+
+// knownBan is a ban as this instance knows it
+type knownBan struct {
+	ban     *models.Ban
+	checked time.Time
+}
+
+// failureCount is a source's failed requests within its window, kept in
+// memory without Valkey
+type failureCount struct {
+	count int
+	since time.Time
+}
+
+// Guard tracks scanner-like sources and bans them
+type Guard struct {
+	cache       *store.Cache
+	userAgents  []string
+	maxFailures int
+	window      time.Duration
+	banFor      time.Duration
+
+	mu       sync.Mutex
+	bans     map[string]*knownBan
+	failures map[string]*failureCount
+}
+
+// New creates a guard as configured. cache may be nil, keeping bans on this
+// instance only.
+func New(cfg *config.Config, cache *store.Cache) (*Guard, error) {
+	if cfg.ScannerMaxFailures < 1 {
+		return nil, fmt.Errorf("invalid SCANNER_MAX_FAILURES %d: must be at least 1", cfg.ScannerMaxFailures)
+	}
+	if cfg.ScannerFailureWindow <= 0 || cfg.ScannerBanDuration <= 0 {
+		return nil, fmt.Errorf("invalid SCANNER_FAILURE_WINDOW %s or SCANNER_BAN_DURATION %s: must be positive", cfg.ScannerFailureWindow, cfg.ScannerBanDuration)
+	}
+
+	g := &Guard{
+		cache:       cache,
+		maxFailures: cfg.ScannerMaxFailures,
+		window:      cfg.ScannerFailureWindow,
+		banFor:      cfg.ScannerBanDuration,
+		bans:        make(map[string]*knownBan),
+		failures:    make(map[string]*failureCount),
+	}
+	for _, ua := range strings.Split(cfg.ScannerUserAgents, ",") {
+		if ua = strings.ToLower(strings.TrimSpace(ua)); ua != "" {
+			g.userAgents = append(g.userAgents, ua)
+		}
+	}
+	return g, nil
+}
+
+// Admit reports whether a request from addr with userAgent may proceed:
+// not from a banned source, nor from a scanner, which is banned at once
+func (g *Guard) Admit(ctx context.Context, addr, userAgent string) bool {
+	if g.banned(ctx, addr) {
+		metrics.BannedRequests.Inc()
+		return false
+	}
+
+	ua := strings.ToLower(userAgent)
+	for _, scanner := range g.userAgents {
+		if strings.Contains(ua, scanner) {
+			g.ban(ctx, addr, "scanner User-Agent "+scanner, g.banFor, metrics.BanUserAgent)
+			metrics.BannedRequests.Inc()
+			return false
+		}
+	}
+	return true
+}
+
+// Failure counts a failed request from addr, banning it once it failed too
+// many within the window
+func (g *Guard) Failure(ctx context.Context, addr string) {
+	var count int64
+	if g.cache != nil {
+		var err error
+		if count, err = g.cache.IncrFailures(ctx, addr, g.window); err != nil {
+			logger.Warn("Failed to count failed request", "source", addr, "error", err)
+			return
+		}
+	} else {
+		now := time.Now()
+		g.mu.Lock()
+		f := g.failures[addr]
+		if f == nil || now.Sub(f.since) >= g.window {
+			f = &failureCount{since: now}
+			g.failures[addr] = f
+		}
+		f.count++
+		count = int64(f.count)
+		g.mu.Unlock()
+	}
+
+	if count >= int64(g.maxFailures) {
+		g.ban(ctx, addr, fmt.Sprintf("%d failed requests within %s", count, g.window), g.banFor, metrics.BanFailures)
+	}
+}
+
+// Ban bans addr for duration, as asked through the admin API
+func (g *Guard) Ban(ctx context.Context, addr, reason string, duration time.Duration) (*models.Ban, error) {
+	return g.ban(ctx, addr, reason, duration, metrics.BanAdmin)
+}
+
+// ban bans addr for duration, for every instance when there is Valkey
+func (g *Guard) ban(ctx context.Context, addr, reason string, duration time.Duration, trigger string) (*models.Ban, error) {
+	ban := &models.Ban{Address: addr, Reason: reason, ExpiresAt: time.Now().Add(duration)}
+	if g.cache != nil {
+		if err := g.cache.SetBan(ctx, addr, reason, duration); err != nil {
+			logger.Error("Failed to save ban", "source", addr, "error", err)
+			return nil, err
+		}
+	}
+
+	g.mu.Lock()
+	g.bans[addr] = &knownBan{ban: ban, checked: time.Now()}
+	delete(g.failures, addr)
+	g.mu.Unlock()
+
+	metrics.ScannerBans.With(trigger).Inc()
+	logger.Warn("Source banned", "source", addr, "reason", reason, "until", ban.ExpiresAt)
+	return ban, nil
+}
+
+// banned reports whether addr is banned, reading Valkey when this instance
+// doesn't know it to be
+func (g *Guard) banned(ctx context.Context, addr string) bool {
+	now := time.Now()
+	g.mu.Lock()
+	known := g.bans[addr]
+	g.mu.Unlock()
+	if known != nil && now.Before(known.ban.ExpiresAt) && (g.cache == nil || now.Sub(known.checked) < recheckInterval) {
+		return true
+	}
+	if g.cache == nil {
+		return false
+	}
+
+	ban, err := g.cache.GetBan(ctx, addr)
+	if err != nil {
+		logger.Warn("Failed to read ban", "source", addr, "error", err)
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if ban == nil {
+		delete(g.bans, addr)
+		return false
+	}
+	g.bans[addr] = &knownBan{ban: ban, checked: now}
+	return true
+}
+
+// Unban lifts the ban of addr
+func (g *Guard) Unban(ctx context.Context, addr string) error {
+	g.mu.Lock()
+	known := g.bans[addr]
+	delete(g.bans, addr)
+	delete(g.failures, addr)
+	g.mu.Unlock()
+
+	banned := known != nil && time.Now().Before(known.ban.ExpiresAt)
+	if g.cache != nil {
+		var err error
+		if banned, err = g.cache.DeleteBan(ctx, addr); err != nil {
+			return err
+		}
+	}
+	if !banned {
+		return ErrNotBanned
+	}
+	logger.Info("Source unbanned", "source", addr)
+	return nil
+}
+
+// List returns the bans in effect
+func (g *Guard) List(ctx context.Context) ([]*models.Ban, error) {
+	if g.cache != nil {
+		return g.cache.ListBans(ctx)
+	}
+
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var bans []*models.Ban
+	for _, known := range g.bans {
+		if now.Before(known.ban.ExpiresAt) {
+			bans = append(bans, known.ban)
+		}
+	}
+	return bans, nil
+}
+
+// Run forgets expired bans and failure counts until ctx is cancelled
+func (g *Guard) Run(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			g.mu.Lock()
+			for addr, known := range g.bans {
+				if !now.Before(known.ban.ExpiresAt) {
+					delete(g.bans, addr)
+				}
+			}
+			for addr, f := range g.failures {
+				if now.Sub(f.since) >= g.window {
+					delete(g.failures, addr)
+				}
+			}
+			g.mu.Unlock()
+		}
+	}
+}
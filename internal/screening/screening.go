@@ -0,0 +1,121 @@
+// Package screening implements the optional pre-answer call screening webhook
+package screening
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Screening actions returned by the webhook
+const (
+	ActionAccept = "accept"
+	ActionReject = "reject"
+	ActionRoute  = "route"
+)
+
+// Request is the call context POSTed to the screening webhook
+type Request struct {
+	CallID    string            `json:"call_id"`
+	AccountID string            `json:"account_id,omitempty"`
+	RouteID   string            `json:"route_id,omitempty"`
+	RouteName string            `json:"route_name"`
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	FromURI   string            `json:"from_uri"`
+	ToURI     string            `json:"to_uri"`
+	Source    string            `json:"source"`
+	Headers   map[string]string `json:"headers"`
+}
+
+// Decision is the webhook's verdict on a call
+type Decision struct {
+	Action       string                 `json:"action"`
+	StatusCode   int                    `json:"status_code,omitempty"`
+	Reason       string                 `json:"reason,omitempty"`
+	WebSocketURL string                 `json:"websocket_url,omitempty"`
+	CustomData   map[string]interface{} `json:"custom_data,omitempty"`
+	Record       *bool                  `json:"record,omitempty"`
+}
+
+// Screener calls the screening webhook
+type Screener struct {
+	url      string
+	failOpen bool
+	client   *http.Client
+}
+
+// NewScreener creates a screener for the given webhook URL
+func NewScreener(url string, timeout time.Duration, failOpen bool) *Screener {
+	return &Screener{
+		url:      url,
+		failOpen: failOpen,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Screen asks the webhook whether to accept the call. When the webhook fails
+// or times out, the call is accepted if the screener fails open and rejected
+// with 503 otherwise; the error is returned either way for logging.
+func (s *Screener) Screen(ctx context.Context, req *Request) (*Decision, error) {
+	decision, err := s.call(ctx, req)
+	if err == nil {
+		return decision, nil
+	}
+
+	if s.failOpen {
+		return &Decision{Action: ActionAccept}, err
+	}
+	return &Decision{Action: ActionReject, StatusCode: 503, Reason: "Service Unavailable"}, err
+}
+
+// call performs the webhook request and validates the decision
+func (s *Screener) call(ctx context.Context, req *Request) (*Decision, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode screening request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create screening request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("screening webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("screening webhook returned %d", resp.StatusCode)
+	}
+
+	var decision Decision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("invalid screening response: %w", err)
+	}
+
+	switch decision.Action {
+	case ActionAccept:
+	case ActionReject:
+		if decision.StatusCode < 400 || decision.StatusCode > 699 {
+			decision.StatusCode = 403
+		}
+		if decision.Reason == "" {
+			decision.Reason = "Forbidden"
+		}
+	case ActionRoute:
+		if decision.WebSocketURL == "" {
+			return nil, fmt.Errorf("screening route override without websocket_url")
+		}
+	default:
+		return nil, fmt.Errorf("unknown screening action %q", decision.Action)
+	}
+
+	return &decision, nil
+}
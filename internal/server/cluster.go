@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+)
+
+// forwardTimeout bounds waiting for the final response to a request
+// forwarded to the instance holding its call
+const forwardTimeout = 32 * time.Second
+
+// inDialog wraps the handler of requests within a call's dialog: those for
+// calls another instance holds, as a load balancer not pinning dialogs may
+// send them anywhere, are forwarded to it and its responses relayed back
+// (RFC 3261 section 16). The rest, and all requests with no call registry,
+// are handled here. INVITEs are forwarded only when re-INVITEs.
+func (s *SIPServer) inDialog(handler sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		if req.IsInvite() && !req.To().Params.Has("tag") {
+			handler(req, tx)
+			return
+		}
+		owner := s.calls.RemoteOwner(context.Background(), req.CallID().Value())
+		if owner == nil {
+			handler(req, tx)
+			return
+		}
+		s.forward(req, tx, owner.Instance, owner.Address)
+	}
+}
+
+// forward proxies a request to the instance at addr. ACKs get no response
+// and are sent on statelessly; other requests' responses are relayed to
+// the sender with our Via removed.
+func (s *SIPServer) forward(req *sip.Request, tx sip.ServerTransaction, instance, addr string) {
+	callID := req.CallID().Value()
+	log := logger.With("call_id", callID, "method", req.Method, "instance", instance)
+	log.Debug("Forwarding request to the instance holding the call")
+	metrics.RequestsForwarded.With(string(req.Method)).Inc()
+
+	out := req.Clone()
+	out.SetDestination(addr)
+
+	if req.IsAck() {
+		if err := s.client.WriteRequest(out, sipgo.ClientRequestAddVia, sipgo.ClientRequestDecreaseMaxForward); err != nil {
+			log.Warn("Failed to forward request", "error", err)
+		}
+		return
+	}
+
+	fail := func(status sip.StatusCode, reason string) {
+		resp := sip.NewResponseFromRequest(req, status, reason, nil)
+		if err := tx.Respond(resp); err != nil {
+			log.Error("Failed to send response", "status", status, "error", err)
+		}
+	}
+
+	if mf := req.MaxForwards(); mf != nil && mf.Val() <= 1 {
+		fail(sip.StatusTooManyHops, "Too Many Hops")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+	defer cancel()
+	clientTx, err := s.client.TransactionRequest(ctx, out, sipgo.ClientRequestAddVia, sipgo.ClientRequestDecreaseMaxForward)
+	if err != nil {
+		log.Warn("Failed to forward request", "error", err)
+		metrics.ForwardFailures.Inc()
+		fail(sip.StatusServiceUnavailable, "Service Unavailable")
+		return
+	}
+	defer clientTx.Terminate()
+
+	for {
+		select {
+		case res := <-clientTx.Responses():
+			res = res.Clone()
+			res.RemoveHeader("Via")
+			res.SetDestination(req.Source())
+			if err := tx.Respond(res); err != nil {
+				log.Error("Failed to relay response", "status", res.StatusCode, "error", err)
+				return
+			}
+			if !res.IsProvisional() {
+				return
+			}
+		case <-clientTx.Done():
+			log.Warn("Forwarded request got no response", "error", clientTx.Err())
+			metrics.ForwardFailures.Inc()
+			fail(sip.StatusRequestTimeout, "Request Timeout")
+			return
+		case <-ctx.Done():
+			metrics.ForwardFailures.Inc()
+			fail(sip.StatusRequestTimeout, "Request Timeout")
+			return
+		case <-tx.Done():
+			return
+		}
+	}
+}
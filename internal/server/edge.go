@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// advertisedURI returns the SIP URI this instance is reached at over a
+// transport: SIPAdvertisedAddress, or the external or local IP, of the
+// peer's address family, and SIP port
+func (s *SIPServer) advertisedURI(transport string, ipv6 bool) sip.Uri {
+	host, port := advertisedIP(s.config, ipv6), s.config.SIPPort
+	if addr := s.config.SIPAdvertisedAddress; addr != "" {
+		if h, p, err := net.SplitHostPort(addr); err == nil {
+			host = h
+			port, _ = strconv.Atoi(p)
+		} else {
+			host, port = addr, 0
+		}
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 reference
+	}
+
+	uri := sip.Uri{Scheme: "sip", Host: host, Port: port, UriParams: sip.NewParams(), Headers: sip.NewParams()}
+	if t := sip.NetworkToLower(transport); t != "" && t != "udp" {
+		uri.UriParams.Add("transport", t)
+	}
+	return uri
+}
+
+// edgeResponse prepares a response for sending behind a proxy, in edge
+// mode: it goes where the request's top Via says, and responses to INVITEs
+// and UPDATEs establishing or refreshing a dialog carry our Contact, those
+// opening one our Record-Route, so the caller's side and any proxy in
+// between send in-dialog requests to this instance
+func (s *SIPServer) edgeResponse(req *sip.Request, resp *sip.Response) {
+	if !s.config.SIPEdgeMode {
+		return
+	}
+	resp.SetDestination(viaDestination(req))
+
+	if resp.StatusCode <= 100 || resp.StatusCode >= 300 || (req.Method != sip.INVITE && req.Method != sip.UPDATE) {
+		return
+	}
+	uri := s.advertisedURI(req.Transport(), sourceAddr(req).Is6())
+	if resp.Contact() == nil {
+		resp.AppendHeader(&sip.ContactHeader{Address: uri, Params: sip.NewParams()})
+	}
+	if req.Method == sip.INVITE && !req.To().Params.Has("tag") {
+		rr := uri.Clone()
+		rr.UriParams.Add("lr", "")
+		resp.PrependHeader(&sip.RecordRouteHeader{Address: *rr})
+	}
+}
+
+// viaDestination returns where a response to a request goes by its top Via
+// (RFC 3261 section 18.2.2, RFC 3581): the received address, else the
+// sent-by host, at the rport, else the sent-by port. A proxy may receive on
+// one socket and send from another, so its source address isn't always
+// where it listens. Responses on connection-oriented transports, and to a
+// Via whose host would need resolving, go back to the request's source.
+func viaDestination(req *sip.Request) string {
+	via := req.Via()
+	if via == nil || sip.NetworkToLower(req.Transport()) != "udp" {
+		return req.Source()
+	}
+
+	host := via.Host
+	if received, ok := via.Params.Get("received"); ok && received != "" {
+		host = received
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) == nil {
+		return req.Source()
+	}
+
+	port := via.Port
+	if rport, ok := via.Params.Get("rport"); ok && rport != "" {
+		port, _ = strconv.Atoi(rport)
+	}
+	if port == 0 {
+		port = 5060
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+}
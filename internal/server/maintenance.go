@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// handleMaintenance turns away a call to a route in a maintenance window:
+// redirected to the window's divert target, or rejected with its status,
+// after its announcement when it has one
+func (s *SIPServer) handleMaintenance(ctx context.Context, log *slog.Logger, req *sip.Request, tx sip.ServerTransaction, inbound *sip.Request, route *models.Route, trunk *models.Trunk, account *models.Account, egressRules []models.HeaderRule, window *models.MaintenanceWindow) {
+	var branding *models.Branding
+	if account != nil {
+		branding = &account.Branding
+	}
+
+	if window.DivertTo != nil {
+		log.Info("Diverting call for route maintenance", "window_id", window.ID, "divert_to", *window.DivertTo)
+		metrics.MaintenanceCalls.With(metrics.MaintenanceDiverted).Inc()
+		resp := sip.NewResponseFromRequest(req, 302, "Moved Temporarily", nil)
+		resp.AppendHeader(sip.NewHeader("Contact", "<"+*window.DivertTo+">"))
+		if err := s.respond(tx, req, resp, trunk, egressRules); err != nil {
+			log.Error("Failed to send 302", "error", err)
+		}
+		return
+	}
+
+	if window.Announcement != nil {
+		s.announceMaintenance(ctx, log, req, tx, inbound, route, trunk, account, branding, egressRules, window)
+		return
+	}
+
+	log.Info("Rejecting call for route maintenance", "window_id", window.ID, "status", window.StatusCode)
+	metrics.MaintenanceCalls.With(metrics.MaintenanceRejected).Inc()
+	if err := s.respond(tx, req, maintenanceResponse(req, window, branding), trunk, egressRules); err != nil {
+		log.Error("Failed to send response", "status", window.StatusCode, "error", err)
+	}
+}
+
+// announceMaintenance plays a maintenance window's announcement to the
+// caller once, as early media, then rejects the call with the window's
+// status. Calls whose announcement can't be played are rejected at once.
+func (s *SIPServer) announceMaintenance(ctx context.Context, log *slog.Logger, req *sip.Request, tx sip.ServerTransaction, inbound *sip.Request, route *models.Route, trunk *models.Trunk, account *models.Account, branding *models.Branding, egressRules []models.HeaderRule, window *models.MaintenanceWindow) {
+	log.Info("Announcing route maintenance", "window_id", window.ID, "announcement", *window.Announcement)
+	metrics.MaintenanceCalls.With(metrics.MaintenanceAnnounced).Inc()
+
+	// The announcement plays through the call's ringback, so the call is
+	// recorded like any other, ending with the maintenance cause
+	announced := *route
+	announced.Ringback = window.Announcement
+	announced.EarlyMedia = false
+	session, err := s.calls.CreateSession(ctx, req.CallID().Value(), inbound, &announced, trunk)
+	if err != nil {
+		log.Warn("Rejecting call for route maintenance without announcement", "error", err)
+		if err := s.respond(tx, req, maintenanceResponse(req, window, branding), trunk, egressRules); err != nil {
+			log.Error("Failed to send response", "status", window.StatusCode, "error", err)
+		}
+		return
+	}
+	session.SetTransaction(tx, req)
+	if account != nil {
+		session.SetAccount(account)
+	}
+	session.SetEgressRules(egressRules)
+
+	if stx, ok := tx.(*sip.ServerTx); ok {
+		stx.OnCancel(func(*sip.Request) {
+			if session.ClaimFinalResponse() {
+				go s.cancelCall(session, false)
+			}
+		})
+	}
+
+	reject := func() {
+		if !session.ClaimFinalResponse() {
+			return // Cancelled
+		}
+		session.SetHangup(models.HangupCauseMaintenance, models.HangupPartySystem)
+		if err := s.respond(tx, req, maintenanceResponse(req, window, branding), trunk, egressRules); err != nil {
+			log.Error("Failed to send response", "status", window.StatusCode, "error", err)
+		}
+		s.calls.EndSession(session.CallID, models.CallStatusFailed)
+	}
+
+	if !session.PrepareRingback() {
+		reject()
+		return
+	}
+	progress := sip.NewResponseFromRequest(req, 183, "Session Progress", []byte(session.GenerateSDP()))
+	progress.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	if err := s.respond(tx, req, progress, trunk, egressRules); err != nil {
+		log.Error("Failed to send 183", "error", err)
+		reject()
+		return
+	}
+	session.MarkRinging()
+	session.StartRingback()
+
+	go func() {
+		time.Sleep(session.RingbackDuration())
+		session.StopRingback()
+		reject()
+	}()
+}
+
+// maintenanceResponse builds the rejection of a call during a maintenance
+// window, with a Retry-After of when it ends for 503s
+func maintenanceResponse(req *sip.Request, window *models.MaintenanceWindow, branding *models.Branding) *sip.Response {
+	resp := brand(sip.NewResponseFromRequest(req, sip.StatusCode(window.StatusCode), window.ReasonPhrase(), nil), branding)
+	if window.Reason != nil {
+		resp.Reason = *window.Reason
+	}
+	if window.StatusCode == 503 {
+		retry := int(time.Until(window.EndsAt).Seconds()) + 1
+		resp.AppendHeader(sip.NewHeader("Retry-After", strconv.Itoa(retry)))
+	}
+	return resp
+}
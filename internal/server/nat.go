@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/config"
+)
+
+// advertisedIP returns the IP put in our Contact and trunk registrations:
+// the external IP when behind NAT, else the local IP. Toward IPv6 peers it
+// is the external or local IPv6 address, when there is one.
+func advertisedIP(cfg *config.Config, ipv6 bool) string {
+	if ipv6 {
+		if cfg.ExternalIP6 != "" {
+			return cfg.ExternalIP6
+		}
+		if ip := GetLocalIP6(); ip != "" {
+			return ip
+		}
+	}
+	if cfg.ExternalIP != "" {
+		return cfg.ExternalIP
+	}
+	return GetLocalIP()
+}
+
+// markReceived adds a received parameter to a request's top Via when its
+// sent-by host isn't the address the request came from (RFC 3261 section
+// 18.2.2), so responses show a caller behind NAT its public address.
+// Requests asking for rport (RFC 3581) get both as sipgo builds responses.
+func markReceived(req *sip.Request) {
+	via := req.Via()
+	if via == nil || via.Params.Has("rport") || via.Params.Has("received") {
+		return
+	}
+	host, _, err := net.SplitHostPort(req.Source())
+	if err != nil || host == strings.Trim(via.Host, "[]") {
+		return
+	}
+	via.Params.Add("received", host)
+}
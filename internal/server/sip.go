@@ -3,38 +3,109 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
 	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 	"github.com/google/uuid"
+	"github.com/shiv6146/blayzen-sip/internal/accounting"
+	"github.com/shiv6146/blayzen-sip/internal/acl"
 	"github.com/shiv6146/blayzen-sip/internal/call"
 	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/cps"
+	"github.com/shiv6146/blayzen-sip/internal/dnscache"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/nat"
+	"github.com/shiv6146/blayzen-sip/internal/privacy"
+	"github.com/shiv6146/blayzen-sip/internal/registration"
 	"github.com/shiv6146/blayzen-sip/internal/routing"
+	"github.com/shiv6146/blayzen-sip/internal/scanner"
+	"github.com/shiv6146/blayzen-sip/internal/screening"
+	"github.com/shiv6146/blayzen-sip/internal/sipauth"
+	"github.com/shiv6146/blayzen-sip/internal/sipheader"
+	"github.com/shiv6146/blayzen-sip/internal/storage"
 	"github.com/shiv6146/blayzen-sip/internal/store"
+	"github.com/shiv6146/blayzen-sip/pkg/agentproto"
 )
 
+var logger = logging.Component("sip")
+
+// referTimeout bounds handing a transferred call to its new agent and
+// reporting the result
+const referTimeout = 30 * time.Second
+
 // SIPServer handles SIP signaling
 type SIPServer struct {
-	config  *config.Config
-	store   *store.PostgresStore
-	cache   *store.Cache
-	router  *routing.Router
-	ua      *sipgo.UserAgent
-	server  *sipgo.Server
-	calls   *call.Manager
+	config   *config.Config
+	store    *store.PostgresStore
+	cache    *store.Cache
+	router   *routing.Router
+	screener *screening.Screener
+	ua       *sipgo.UserAgent
+	server   *sipgo.Server
+	client   *sipgo.Client
+	calls    *call.Manager
+	trunks   *registration.Manager
+	defaults *routing.Defaults
+
+	// Emergency number overrides, changed through the admin API
+	overrides *routing.NumberOverrides
+
+	// Route maintenance windows, scheduled through the API
+	maintenance *routing.Maintenance
+
+	// Digest authentication of callers that aren't a trunk, when enabled
+	auth *sipauth.Authenticator
+
+	// Source address ranges allowed to send INVITEs and OPTIONS, changed
+	// through the admin API
+	acl *acl.ACL
+
+	// Bans of scanner-like sources, when scanner protection is enabled
+	guard *scanner.Guard
+
+	// INVITE rate limits, changed through the admin API
+	cps *cps.Limiter
+
 	mu      sync.RWMutex
 	running bool
 }
 
-// NewSIPServer creates a new SIP server
-func NewSIPServer(cfg *config.Config, store *store.PostgresStore, cache *store.Cache) (*SIPServer, error) {
+// NewSIPServer creates a new SIP server. blobs keeps call recordings and
+// prompts.
+func NewSIPServer(cfg *config.Config, store *store.PostgresStore, cache *store.Cache, blobs storage.Store) (*SIPServer, error) {
+	if cfg.VideoPolicy != call.VideoPolicyAudioOnly && cfg.VideoPolicy != call.VideoPolicyDecline {
+		return nil, fmt.Errorf("invalid VIDEO_POLICY %q: must be %s or %s", cfg.VideoPolicy, call.VideoPolicyAudioOnly, call.VideoPolicyDecline)
+	}
+	if cfg.ReferPolicy != call.ReferPolicyReroute && cfg.ReferPolicy != call.ReferPolicyDecline {
+		return nil, fmt.Errorf("invalid REFER_POLICY %q: must be %s or %s", cfg.ReferPolicy, call.ReferPolicyReroute, call.ReferPolicyDecline)
+	}
+	if cfg.SIPKeepaliveMethod != string(sip.OPTIONS) && cfg.SIPKeepaliveMethod != string(sip.UPDATE) {
+		return nil, fmt.Errorf("invalid SIP_KEEPALIVE_METHOD %q: must be OPTIONS or UPDATE", cfg.SIPKeepaliveMethod)
+	}
+	if cfg.SessionExpires > 0 && (cfg.SessionMinSE < 90*time.Second || cfg.SessionExpires < cfg.SessionMinSE) {
+		return nil, fmt.Errorf("invalid SESSION_EXPIRES %s: must be at least SESSION_MIN_SE %s, itself at least 90s", cfg.SessionExpires, cfg.SessionMinSE)
+	}
+
+	// Agent and trunk hostnames resolve through the DNS cache
+	resolver := dnscache.NewFromConfig(cfg)
+
 	// Create user agent
 	ua, err := sipgo.NewUA(
 		sipgo.WithUserAgent("blayzen-sip/1.0"),
+		sipgo.WithUserAgentDNSResolver(resolver),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user agent: %w", err)
@@ -46,20 +117,109 @@ func NewSIPServer(cfg *config.Config, store *store.PostgresStore, cache *store.C
 		return nil, fmt.Errorf("failed to create SIP server: %w", err)
 	}
 
+	// Public IP when behind NAT, discovered over STUN unless configured
+	if cfg.ExternalIP == "" && cfg.STUNServer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ip, err := nat.Discover(ctx, cfg.STUNServer)
+		cancel()
+		if err != nil {
+			logger.Warn("Failed to discover external IP, advertising the local IP", "stun_server", cfg.STUNServer, "error", err)
+		} else {
+			logger.Info("Discovered external IP", "ip", ip, "stun_server", cfg.STUNServer)
+			cfg.ExternalIP = ip
+		}
+	}
+
+	// Create SIP client for in-dialog requests toward callers, asking for
+	// rport when behind NAT
+	var clientOpts []sipgo.ClientOption
+	if cfg.ExternalIP != "" {
+		clientOpts = append(clientOpts, sipgo.WithClientNAT())
+	}
+	client, err := sipgo.NewClient(ua, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SIP client: %w", err)
+	}
+
+	// Routing defaults, with any changes made at runtime
+	defaults := routing.NewDefaults(cfg, store)
+	if err := defaults.Load(context.Background()); err != nil {
+		logger.Warn("Using configured routing defaults", "error", err)
+	}
+
+	// Emergency number overrides, ahead of routes
+	overrides := routing.NewNumberOverrides(store)
+	if err := overrides.Load(context.Background()); err != nil {
+		logger.Warn("Failed to load number overrides", "error", err)
+	}
+
+	// Route maintenance windows, turning away their routes' calls
+	maintenance := routing.NewMaintenance(store)
+	if err := maintenance.Load(context.Background()); err != nil {
+		logger.Warn("Failed to load route maintenance windows", "error", err)
+	}
+
+	// Access control list of request sources
+	sourceACL, err := acl.New(cfg, store)
+	if err != nil {
+		return nil, err
+	}
+	if err := sourceACL.Load(context.Background()); err != nil {
+		logger.Warn("Using configured ACL ranges only", "error", err)
+	}
+
+	// INVITE rate limits, with any changes made at runtime
+	limiter := cps.New(cfg, store)
+	if err := limiter.Load(context.Background()); err != nil {
+		logger.Warn("Using configured INVITE rate limits", "error", err)
+	}
+
 	// Create routing engine
-	router := routing.NewRouter(store, cache, cfg.DefaultWebSocketURL)
+	router := routing.NewRouter(store, cache, defaults, cfg.RouteSelectionStrategy)
+
+	// Optional RADIUS accounting of calls
+	acct, err := accounting.NewFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RADIUS accounting configuration: %w", err)
+	}
 
 	// Create call manager
-	callMgr := call.NewManager(cfg, store, cache)
+	callMgr := call.NewManager(cfg, defaults, store, cache, blobs, client, resolver, acct)
 
 	s := &SIPServer{
-		config: cfg,
-		store:  store,
-		cache:  cache,
-		router: router,
-		ua:     ua,
-		server: server,
-		calls:  callMgr,
+		config:   cfg,
+		store:    store,
+		cache:    cache,
+		router:   router,
+		ua:       ua,
+		server:   server,
+		client:   client,
+		calls:    callMgr,
+		trunks:   registration.New(cfg, store, client, advertisedIP(cfg, false), advertisedIP(cfg, true), callMgr.RecordTrunkResponse),
+		defaults: defaults,
+	}
+	s.overrides = overrides
+	s.maintenance = maintenance
+	s.acl = sourceACL
+	s.cps = limiter
+
+	// Optional pre-answer screening webhook
+	if cfg.ScreeningWebhookURL != "" {
+		s.screener = screening.NewScreener(cfg.ScreeningWebhookURL, cfg.ScreeningTimeout, cfg.ScreeningFailOpen)
+	}
+
+	// Optional banning of scanner-like sources
+	if cfg.ScannerProtection {
+		if s.guard, err = scanner.New(cfg, cache); err != nil {
+			return nil, err
+		}
+	}
+
+	// Optional digest authentication of callers that aren't a trunk
+	if cfg.SIPAuthEnabled {
+		if s.auth, err = sipauth.New(cfg, store); err != nil {
+			return nil, err
+		}
 	}
 
 	// Register SIP handlers
@@ -70,179 +230,959 @@ func NewSIPServer(cfg *config.Config, store *store.PostgresStore, cache *store.C
 
 // registerHandlers sets up SIP message handlers
 func (s *SIPServer) registerHandlers() {
+	// Mark requests from behind NAT with the address they came from
+	s.server.ServeRequest(markReceived)
+
 	// Handle INVITE (incoming calls)
-	s.server.OnInvite(s.handleInvite)
+	s.server.OnInvite(s.admit(s.inDialog(s.handleInvite)))
 
 	// Handle ACK
-	s.server.OnAck(s.handleAck)
+	s.server.OnAck(s.inDialog(s.handleAck))
 
 	// Handle BYE (call termination)
-	s.server.OnBye(s.handleBye)
+	s.server.OnBye(s.inDialog(s.handleBye))
 
 	// Handle CANCEL
-	s.server.OnCancel(s.handleCancel)
+	s.server.OnCancel(s.inDialog(s.handleCancel))
 
 	// Handle OPTIONS (keep-alive / health check)
-	s.server.OnOptions(s.handleOptions)
+	s.server.OnOptions(s.admit(s.handleOptions))
+
+	// Handle REFER (transfers by the caller's side)
+	s.server.OnRefer(s.inDialog(s.handleRefer))
+
+	// Handle UPDATE (session refreshes by the caller's side)
+	s.server.OnUpdate(s.inDialog(s.handleUpdate))
+
+	// Refuse other methods, e.g. REGISTER from scanners
+	s.server.OnNoRoute(s.admit(s.handleUnsupported))
+}
+
+// admit wraps a handler with the scanner bans and the access control list:
+// requests from banned or scanner sources are dropped, and those from
+// sources the ACL refuses are dropped or answered 403 when configured,
+// before the handler sees them
+func (s *SIPServer) admit(handler sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		if s.guard != nil && !s.guard.Admit(context.Background(), sourceAddr(req).String(), userAgent(req)) {
+			logger.Debug("Request from banned source dropped", "method", req.Method, "source", req.Source())
+			tx.Terminate()
+			return
+		}
+		if s.acl.Check(sourceAddr(req)).Allowed {
+			handler(req, tx)
+			return
+		}
+
+		metrics.ACLRefused.With(string(req.Method)).Inc()
+		logger.Debug("Request refused by ACL", "method", req.Method, "source", req.Source())
+		if !s.acl.Rejects() {
+			tx.Terminate()
+			return
+		}
+		resp := sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil)
+		s.edgeResponse(req, resp)
+		if err := tx.Respond(resp); err != nil {
+			logger.Error("Failed to send 403", "error", err)
+		}
+	}
+}
+
+// userAgent returns the User-Agent of a request, if any
+func userAgent(req *sip.Request) string {
+	if h := req.GetHeader("User-Agent"); h != nil {
+		return h.Value()
+	}
+	return ""
+}
+
+// recordFailure counts a failed request toward banning its source, unless
+// it came from a trunk, whose carrier legitimately sends calls that fail
+func (s *SIPServer) recordFailure(ctx context.Context, req *sip.Request, trunk *models.Trunk) {
+	if s.guard != nil && trunk == nil {
+		s.guard.Failure(ctx, sourceAddr(req).String())
+	}
+}
+
+// sourceAddr returns the address a request came from, or the zero address
+// when it can't be parsed
+func sourceAddr(req *sip.Request) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(req.Source())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
 }
 
 // handleInvite processes incoming INVITE requests
 func (s *SIPServer) handleInvite(req *sip.Request, tx sip.ServerTransaction) {
 	ctx := context.Background()
+	received := time.Now()
 	callID := req.CallID().Value()
+	s.calls.CaptureRequest(req, models.SIPMessageInbound, req.Source())
+
+	// Re-INVITEs renegotiate established calls; one switching to T.38 means
+	// a fax machine is on the line
+	if session := s.calls.GetSession(callID); session != nil && req.To().Params.Has("tag") {
+		if call.OffersT38(req.Body()) {
+			s.handleFaxReInvite(req, tx, session)
+		} else {
+			s.handleReInvite(req, tx, session)
+		}
+		return
+	}
+
+	log := logger.With("call_id", callID)
+	log.Info("INVITE received",
+		"from", privacy.LogURI(req.From().Address.String()), "to", privacy.LogURI(req.To().Address.String()))
+
+	// New calls over the source's rate are turned away before any lookup
+	if !s.cps.AllowSource(sourceAddr(req).String()) {
+		log.Warn("Rejecting call over the source's call rate", "source", req.Source())
+		s.rejectRateLimited(tx, req, metrics.RateLimitSource, nil, nil, nil)
+		return
+	}
 
-	log.Printf("[SIP] INVITE received: Call-ID=%s From=%s To=%s",
-		callID, req.From().Value(), req.To().Value())
+	// Header rules rewrite what the rest of the call sees (inbound), while
+	// responses are built from the original request so they still match the
+	// caller's transaction. Egress rules apply to everything we send.
+	inbound := req
+	var egressRules []models.HeaderRule
+	trunk := s.findSourceTrunk(ctx, req)
+	if trunk != nil {
+		inbound = applyIngressRules(inbound, trunk.HeaderRules)
+		egressRules = trunk.HeaderRules
+	}
+
+	// Callers that aren't a trunk authenticate with an account's SIP
+	// credentials, when required
+	var credential *models.SIPCredential
+	if trunk == nil && s.auth != nil {
+		var ok bool
+		if credential, ok = s.authenticate(ctx, log, req, tx); !ok {
+			return
+		}
+	}
 
 	// Extract call info
-	toURI := req.To().Address
-	fromURI := req.From().Address
+	toUser := inbound.To().Address.User
+	fromUser := inbound.From().Address.User
+	headers := headerMap(inbound)
 
-	toUser := toURI.User
-	fromUser := fromURI.User
+	// Only the routes of the account the call is attributed to are considered
+	accountID := s.inboundAccount(ctx, inbound, trunk, credential)
 
-	// Extract custom headers for routing
-	headers := make(map[string]string)
-	for _, h := range req.Headers() {
-		name := h.Name()
-		if len(name) > 2 && name[:2] == "X-" {
-			headers[name] = h.Value()
+	// Emergency number overrides take calls ahead of their routes: out
+	// through a trunk, or to another agent
+	override := s.overrides.Lookup(toUser)
+	if override != nil && override.Trunk != nil {
+		log.Warn("Redirecting call by number override", "trunk", override.Trunk.Name)
+		resp := sip.NewResponseFromRequest(req, 302, "Moved Temporarily", nil)
+		resp.AppendHeader(sip.NewHeader("Contact", "<"+override.RedirectURI()+">"))
+		if err := s.respond(tx, req, resp, trunk, egressRules); err != nil {
+			log.Error("Failed to send 302", "error", err)
 		}
+		return
 	}
 
 	// Find matching route
-	route, err := s.router.FindRoute(ctx, toUser, fromUser, headers)
+	var route *models.Route
+	var err error
+	if accountID == "" && s.config.InboundAccountRequired {
+		err = errors.New("call not attributed to an account")
+	} else {
+		route, err = s.router.FindRoute(ctx, accountID, toUser, fromUser, headers)
+	}
+	// An override agent takes the place of the matched route's; calls
+	// matching no route, or attributed to no account when one is required,
+	// are rejected as usual
+	if err == nil && override != nil && override.WebSocketURL != nil {
+		log.Warn("Sending call to number override agent", "agent_url", *override.WebSocketURL)
+		route = routing.OverrideRoute(route, override)
+	}
 	if err != nil {
-		log.Printf("[SIP] No route found for call %s: %v", callID, err)
-		// Send 404 Not Found
-		resp := sip.NewResponseFromRequest(req, 404, "Not Found", nil)
-		if err := tx.Respond(resp); err != nil {
-			log.Printf("[SIP] Failed to send 404: %v", err)
+		log.Info("No route found", "error", err)
+		metrics.RouteLookups.With(metrics.RouteUnmatched).Inc()
+		// Send 404 Not Found, or the status set at runtime, branded for the
+		// account the call is attributed to
+		var branding *models.Branding
+		if account := s.callAccount(ctx, log, accountID); account != nil {
+			branding = &account.Branding
+		}
+		defaults := s.defaults.Get()
+		resp := sip.NewResponseFromRequest(req, sip.StatusCode(defaults.NoRouteStatus), defaults.NoRouteReason(), nil)
+		if err := s.respond(tx, req, brand(resp, branding), trunk, egressRules); err != nil {
+			log.Error("Failed to send response", "status", defaults.NoRouteStatus, "error", err)
 		}
+		s.recordFailure(ctx, req, trunk)
+		return
+	}
+
+	log = log.With("account_id", route.AccountID)
+	log.Info("Route matched", "route", route.Name, "agent_url", route.WebSocketURL)
+	metrics.RouteLookups.With(metrics.RouteMatched).Inc()
+
+	// Rejections and answers carry the account's branding
+	account := s.callAccount(ctx, log, route.AccountID)
+	var branding *models.Branding
+	if account != nil {
+		branding = &account.Branding
+
+		if !s.cps.AllowAccount(account) {
+			log.Warn("Rejecting call over the account's call rate", "account_id", account.ID)
+			s.rejectRateLimited(tx, req, metrics.RateLimitAccount, branding, trunk, egressRules)
+			return
+		}
+	}
+
+	// Routes under maintenance turn their calls away
+	if window := s.maintenance.Lookup(route.ID); window != nil {
+		s.handleMaintenance(ctx, log, req, tx, inbound, route, trunk, account, egressRules, window)
 		return
 	}
 
-	log.Printf("[SIP] Route matched: %s -> %s", route.Name, route.WebSocketURL)
+	// Route rules apply inside the trunk's: after them on ingress, before on egress
+	if len(route.HeaderRules) > 0 {
+		inbound = applyIngressRules(inbound, route.HeaderRules)
+		headers = headerMap(inbound)
+		egressRules = append(slices.Clone(route.HeaderRules), egressRules...)
+	}
+
+	// Only audio is carried: offers without audio are declined, as are
+	// offers with video when the video policy says so
+	if offered := call.OfferedMedia(req.Body()); len(offered) > 0 {
+		video := slices.Contains(offered, "video")
+		if !slices.Contains(offered, "audio") || (video && s.config.VideoPolicy == call.VideoPolicyDecline) {
+			log.Info("Declining call for its media", "offered_media", offered)
+			resp := sip.NewResponseFromRequest(req, 488, "Not Acceptable Here", nil)
+			if err := s.respond(tx, req, brand(resp, branding), trunk, egressRules); err != nil {
+				log.Error("Failed to send 488", "error", err)
+			}
+			return
+		}
+	}
+
+	// Session timers shorter than ours are refused, with the minimum
+	timer, err := call.NegotiateSessionTimer(req, s.config.SessionExpires, s.config.SessionMinSE)
+	if err != nil {
+		log.Info("Declining call for its session interval", "min_se", s.config.SessionMinSE)
+		if err := s.respond(tx, req, brand(s.intervalTooSmall(req), branding), trunk, egressRules); err != nil {
+			log.Error("Failed to send 422", "error", err)
+		}
+		return
+	}
 
 	// Send 100 Trying
 	trying := sip.NewResponseFromRequest(req, 100, "Trying", nil)
-	if err := tx.Respond(trying); err != nil {
-		log.Printf("[SIP] Failed to send 100 Trying: %v", err)
+	if err := s.respond(tx, req, trying, trunk, egressRules); err != nil {
+		log.Error("Failed to send 100 Trying", "error", err)
+	}
+
+	// Let the screening webhook accept, reject or re-route the call
+	if s.screener != nil {
+		var rejected *screening.Decision
+		route, rejected = s.screenCall(ctx, log, inbound, route, headers)
+		if rejected != nil {
+			resp := sip.NewResponseFromRequest(req, sip.StatusCode(rejected.StatusCode), rejected.Reason, nil)
+			if err := s.respond(tx, req, brand(resp, branding), trunk, egressRules); err != nil {
+				log.Error("Failed to send response", "status", rejected.StatusCode, "error", err)
+			}
+			return
+		}
 	}
 
 	// Create call session
-	session, err := s.calls.CreateSession(ctx, callID, req, route)
+	session, err := s.calls.CreateSession(ctx, callID, inbound, route, trunk)
 	if err != nil {
-		log.Printf("[SIP] Failed to create session: %v", err)
-		// Send 500 Internal Server Error
+		log.Error("Failed to create session", "error", err)
+		// Send 503 when at capacity, 486 when the route is, 488 when its
+		// media is over a bitrate limit, 500 Internal Server Error otherwise
 		resp := sip.NewResponseFromRequest(req, 500, "Internal Server Error", nil)
-		if err := tx.Respond(resp); err != nil {
-			log.Printf("[SIP] Failed to send 500: %v", err)
+		switch {
+		case errors.Is(err, call.ErrNoCapacity):
+			resp = sip.NewResponseFromRequest(req, 503, "Service Unavailable", nil)
+			if retry := int(s.config.CapacityRetryAfter.Seconds()); retry > 0 {
+				resp.AppendHeader(sip.NewHeader("Retry-After", strconv.Itoa(retry)))
+			}
+		case errors.Is(err, call.ErrRouteBusy):
+			resp = sip.NewResponseFromRequest(req, 486, "Busy Here", nil)
+		case errors.Is(err, call.ErrBitrateExceeded):
+			resp = sip.NewResponseFromRequest(req, 488, "Not Acceptable Here", nil)
+		}
+		if err := s.respond(tx, req, brand(resp, branding), trunk, egressRules); err != nil {
+			log.Error("Failed to send response", "status", resp.StatusCode, "error", err)
 		}
 		return
 	}
 
 	// Store transaction for later use
-	session.SetTransaction(tx)
+	session.SetTransaction(tx, req)
+	if account != nil {
+		session.SetAccount(account)
+	}
+	session.SetEgressRules(egressRules)
+	if timer != nil {
+		session.SetSessionTimer(timer)
+	}
 
-	// Send 180 Ringing
-	ringing := sip.NewResponseFromRequest(req, 180, "Ringing", nil)
-	if err := tx.Respond(ringing); err != nil {
-		log.Printf("[SIP] Failed to send 180 Ringing: %v", err)
+	// A CANCEL matching the transaction is answered by it, which sends the
+	// 487 itself once the call gave up its final response
+	if stx, ok := tx.(*sip.ServerTx); ok {
+		stx.OnCancel(func(*sip.Request) {
+			if session.ClaimFinalResponse() {
+				go s.cancelCall(session, false)
+			}
+		})
+	}
+
+	// Send 180 Ringing, or 183 Session Progress for the route's ringback or
+	// early media
+	progress := sip.NewResponseFromRequest(req, 180, "Ringing", nil)
+	if session.PrepareRingback() || session.Route.EarlyMedia {
+		progress = sip.NewResponseFromRequest(req, 183, "Session Progress", []byte(session.GenerateSDP()))
+		progress.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	}
+	if err := s.respond(tx, req, progress, trunk, egressRules); err != nil {
+		log.Error("Failed to send progress", "status", progress.StatusCode, "error", err)
+	} else {
+		session.MarkRinging()
+		session.StartRingback()
 	}
 
-	// Connect to WebSocket agent (async)
+	// Connect to WebSocket agent (async). Routes detecting humans answer
+	// first and connect the agent once media has started.
 	go func() {
-		if err := session.ConnectAgent(ctx); err != nil {
-			log.Printf("[SIP] Failed to connect to agent: %v", err)
-			// Send 503 Service Unavailable
-			resp := sip.NewResponseFromRequest(req, 503, "Service Unavailable", nil)
-			if err := tx.Respond(resp); err != nil {
-				log.Printf("[SIP] Failed to send 503: %v", err)
+		if !session.Route.DetectHuman {
+			if err := session.ConnectAgent(ctx); err != nil {
+				log.Error("Failed to connect to agent", "error", err)
+				if !session.ClaimFinalResponse() {
+					return // Cancelled
+				}
+				// Send 503 Service Unavailable, or the status set at runtime
+				defaults := s.defaults.Get()
+				resp := sip.NewResponseFromRequest(req, sip.StatusCode(defaults.AgentUnavailableStatus), defaults.AgentUnavailableReason(), nil)
+				if err := s.respond(tx, req, brand(resp, branding), trunk, egressRules); err != nil {
+					log.Error("Failed to send response", "status", defaults.AgentUnavailableStatus, "error", err)
+				}
+				s.calls.EndSession(callID, models.CallStatusFailed)
+				return
 			}
-			s.calls.RemoveSession(callID)
-			return
 		}
 
-		// Agent connected, answer the call
+		// Answer the call, ending any ringback first
+		session.StopRingback()
+
+		// Early media routes let the agent stream until it asks for the
+		// answer; an agent stopping the call first declines it
+		if session.Route.EarlyMedia && !session.Route.DetectHuman {
+			session.StartEarlyMedia()
+			if err := session.WaitForAnswer(); err != nil {
+				if !session.ClaimFinalResponse() {
+					return // Cancelled
+				}
+				resp := sip.NewResponseFromRequest(req, 603, "Decline", nil)
+				log.Info("Call ended in early media", "status", resp.StatusCode)
+				if err := s.respond(tx, req, brand(resp, branding), trunk, egressRules); err != nil {
+					log.Error("Failed to send response", "status", resp.StatusCode, "error", err)
+				}
+				s.calls.EndSession(callID, models.CallStatusFailed)
+				return
+			}
+		}
+
+		if !session.ClaimFinalResponse() {
+			return // Cancelled
+		}
+
 		// Generate SDP for RTP
 		sdp := session.GenerateSDP()
 
 		// Send 200 OK with SDP
 		ok := sip.NewResponseFromRequest(req, 200, "OK", []byte(sdp))
 		ok.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+		if timer != nil {
+			timer.AddHeaders(ok)
+		}
 
-		if err := tx.Respond(ok); err != nil {
-			log.Printf("[SIP] Failed to send 200 OK: %v", err)
+		if err := s.respond(tx, req, ok, trunk, egressRules); err != nil {
+			log.Error("Failed to send 200 OK", "error", err)
 			session.Close()
-			s.calls.RemoveSession(callID)
+			s.calls.EndSession(callID, models.CallStatusFailed)
 			return
 		}
+		session.SetAnswer(ok)
+		metrics.CallSetupSeconds.Observe(time.Since(received).Seconds())
 
-		log.Printf("[SIP] Call %s answered", callID)
+		log.Info("Call answered")
 	}()
 }
 
+// handleReInvite answers a re-INVITE renegotiating an established call:
+// hold and resume, or the caller's media moving. Offers the call can't
+// continue with are declined, leaving it as it was.
+func (s *SIPServer) handleReInvite(req *sip.Request, tx sip.ServerTransaction, session *call.Session) {
+	log := logger.With("call_id", session.CallID)
+	log.Info("re-INVITE received")
+
+	reply := func(resp *sip.Response) {
+		if err := s.respond(tx, req, resp, session.Trunk(), session.EgressRules()); err != nil {
+			log.Error("Failed to send response", "status", resp.StatusCode, "error", err)
+		}
+	}
+
+	// Re-INVITEs also refresh the session timer
+	timer, err := call.NegotiateSessionTimer(req, s.config.SessionExpires, s.config.SessionMinSE)
+	if err != nil {
+		reply(s.intervalTooSmall(req))
+		return
+	}
+
+	answer, declined := renegotiate(log, req, session)
+	if declined != nil {
+		reply(declined)
+		return
+	}
+
+	ok := sip.NewResponseFromRequest(req, 200, "OK", []byte(answer))
+	ok.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	if timer != nil {
+		timer.AddHeaders(ok)
+		session.SetSessionTimer(timer)
+	}
+	reply(ok)
+}
+
+// handleUpdate answers an UPDATE from the caller's side refreshing the
+// session timer (RFC 4028), and renegotiating media when it carries an offer
+func (s *SIPServer) handleUpdate(req *sip.Request, tx sip.ServerTransaction) {
+	callID := req.CallID().Value()
+	s.calls.CaptureRequest(req, models.SIPMessageInbound, req.Source())
+
+	session := s.calls.GetSession(callID)
+	if session == nil {
+		resp := sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+		if err := s.respond(tx, req, resp, nil, nil); err != nil {
+			logger.Error("Failed to send 481", "call_id", callID, "error", err)
+		}
+		return
+	}
+	log := logger.With("call_id", callID)
+	log.Debug("UPDATE received")
+
+	reply := func(resp *sip.Response) {
+		if err := s.respond(tx, req, resp, session.Trunk(), session.EgressRules()); err != nil {
+			log.Error("Failed to send response", "status", resp.StatusCode, "error", err)
+		}
+	}
+
+	timer, err := call.NegotiateSessionTimer(req, s.config.SessionExpires, s.config.SessionMinSE)
+	if err != nil {
+		reply(s.intervalTooSmall(req))
+		return
+	}
+
+	ok := sip.NewResponseFromRequest(req, 200, "OK", nil)
+	if len(req.Body()) > 0 {
+		answer, declined := renegotiate(log, req, session)
+		if declined != nil {
+			reply(declined)
+			return
+		}
+		ok.SetBody([]byte(answer))
+		ok.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	}
+	if timer != nil {
+		timer.AddHeaders(ok)
+		session.SetSessionTimer(timer)
+	}
+	reply(ok)
+}
+
+// renegotiate applies the SDP offer of a re-INVITE or UPDATE, returning our
+// answer, or the response declining the offer
+func renegotiate(log *slog.Logger, req *sip.Request, session *call.Session) (string, *sip.Response) {
+	answer, err := session.Renegotiate(req.Body())
+	switch {
+	case errors.Is(err, call.ErrCallNotAnswered):
+		// The INVITE is still being answered
+		return "", sip.NewResponseFromRequest(req, 491, "Request Pending", nil)
+	case err != nil:
+		log.Info("Declining offer", "method", req.Method, "error", err)
+		return "", sip.NewResponseFromRequest(req, 488, "Not Acceptable Here", nil)
+	}
+	return answer, nil
+}
+
+// intervalTooSmall returns the 422 refusing a request whose session timer
+// is shorter than SESSION_MIN_SE
+func (s *SIPServer) intervalTooSmall(req *sip.Request) *sip.Response {
+	resp := sip.NewResponseFromRequest(req, 422, "Session Interval Too Small", nil)
+	resp.AppendHeader(sip.NewHeader("Min-SE", strconv.Itoa(int(s.config.SessionMinSE.Seconds()))))
+	return resp
+}
+
+// handleFaxReInvite declines a T.38 re-INVITE, keeping the call on audio,
+// and applies the route's fax policy
+func (s *SIPServer) handleFaxReInvite(req *sip.Request, tx sip.ServerTransaction, session *call.Session) {
+	logger.Info("T.38 re-INVITE received", "call_id", session.CallID)
+
+	resp := sip.NewResponseFromRequest(req, 488, "Not Acceptable Here", nil)
+	if err := s.respond(tx, req, resp, session.Trunk(), session.EgressRules()); err != nil {
+		logger.Error("Failed to send 488", "call_id", session.CallID, "error", err)
+	}
+	go session.HandleFax(agentproto.FaxSourceT38)
+}
+
+// screenCall consults the screening webhook before the call is answered. It
+// returns the (possibly overridden) route, or the decision if the call is to
+// be rejected.
+func (s *SIPServer) screenCall(ctx context.Context, log *slog.Logger, req *sip.Request, route *models.Route, headers map[string]string) (*models.Route, *screening.Decision) {
+	callID := req.CallID().Value()
+
+	decision, err := s.screener.Screen(ctx, &screening.Request{
+		CallID:    callID,
+		AccountID: route.AccountID,
+		RouteID:   route.ID,
+		RouteName: route.Name,
+		From:      req.From().Address.User,
+		To:        req.To().Address.User,
+		FromURI:   req.From().Address.String(),
+		ToURI:     req.To().Address.String(),
+		Source:    req.Source(),
+		Headers:   headers,
+	})
+	if err != nil {
+		log.Warn("Screening failed", "error", err, "action", decision.Action)
+	}
+
+	if decision.Action == screening.ActionReject {
+		log.Info("Call rejected by screening", "status", decision.StatusCode, "reason", decision.Reason)
+		return nil, decision
+	}
+
+	if decision.Action != screening.ActionRoute && decision.Record == nil {
+		return route, nil
+	}
+
+	// Per-call overrides apply to a copy so the cached route is left untouched
+	overridden := *route
+
+	if decision.Action == screening.ActionRoute {
+		log.Info("Call re-routed by screening", "agent_url", decision.WebSocketURL)
+		overridden = *route.WithAgent(decision.WebSocketURL)
+		if decision.CustomData != nil {
+			overridden.CustomData = make(map[string]interface{}, len(route.CustomData)+len(decision.CustomData))
+			for k, v := range route.CustomData {
+				overridden.CustomData[k] = v
+			}
+			for k, v := range decision.CustomData {
+				overridden.CustomData[k] = v
+			}
+		}
+	}
+
+	if decision.Record != nil {
+		overridden.Record = *decision.Record
+	}
+
+	return &overridden, nil
+}
+
 // handleAck processes ACK requests (call setup completion)
 func (s *SIPServer) handleAck(req *sip.Request, tx sip.ServerTransaction) {
 	callID := req.CallID().Value()
-	log.Printf("[SIP] ACK received: Call-ID=%s", callID)
+	logger.Debug("ACK received", "call_id", callID)
+	s.calls.CaptureRequest(req, models.SIPMessageInbound, req.Source())
 
 	session := s.calls.GetSession(callID)
 	if session == nil {
-		log.Printf("[SIP] No session found for ACK: %s", callID)
+		logger.Warn("No session found for ACK", "call_id", callID)
 		return
 	}
 
-	// Start media streaming
+	// An ACK with SDP answers the offer we made to an INVITE without one
+	if len(req.Body()) > 0 {
+		if _, err := session.Renegotiate(req.Body()); err != nil {
+			logger.Warn("Ignoring SDP in ACK", "call_id", callID, "error", err)
+		}
+	}
+
+	// Start media streaming, unless already started (re-INVITEs)
 	go session.StartMedia()
 }
 
 // handleBye processes BYE requests (call termination)
 func (s *SIPServer) handleBye(req *sip.Request, tx sip.ServerTransaction) {
 	callID := req.CallID().Value()
-	log.Printf("[SIP] BYE received: Call-ID=%s", callID)
+	logger.Info("BYE received", "call_id", callID)
+	s.calls.CaptureRequest(req, models.SIPMessageInbound, req.Source())
 
+	var egressRules []models.HeaderRule
+	var trunk *models.Trunk
 	session := s.calls.GetSession(callID)
 	if session != nil {
+		egressRules = session.EgressRules()
+		trunk = session.Trunk()
+		session.SetHangup(models.HangupCauseNormalClearing, models.HangupPartyCaller)
 		session.Close()
 		s.calls.RemoveSession(callID)
 	}
 
 	// Send 200 OK
 	ok := sip.NewResponseFromRequest(req, 200, "OK", nil)
-	if err := tx.Respond(ok); err != nil {
-		log.Printf("[SIP] Failed to send 200 OK for BYE: %v", err)
+	if err := s.respond(tx, req, ok, trunk, egressRules); err != nil {
+		logger.Error("Failed to send 200 OK for BYE", "call_id", callID, "error", err)
 	}
 }
 
-// handleCancel processes CANCEL requests
+// handleCancel processes CANCEL requests the INVITE transaction didn't
+// match, e.g. once it was replaced. The CANCEL is answered 200 and the
+// INVITE 487 Request Terminated, unless the call was answered already,
+// which a CANCEL can't undo.
 func (s *SIPServer) handleCancel(req *sip.Request, tx sip.ServerTransaction) {
 	callID := req.CallID().Value()
-	log.Printf("[SIP] CANCEL received: Call-ID=%s", callID)
+	logger.Info("CANCEL received", "call_id", callID)
+	s.calls.CaptureRequest(req, models.SIPMessageInbound, req.Source())
 
 	session := s.calls.GetSession(callID)
-	if session != nil {
-		session.Close()
-		s.calls.RemoveSession(callID)
+	if session == nil {
+		resp := sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+		if err := s.respond(tx, req, resp, nil, nil); err != nil {
+			logger.Error("Failed to send 481 for CANCEL", "call_id", callID, "error", err)
+		}
+		return
 	}
 
 	// Send 200 OK
 	ok := sip.NewResponseFromRequest(req, 200, "OK", nil)
-	if err := tx.Respond(ok); err != nil {
-		log.Printf("[SIP] Failed to send 200 OK for CANCEL: %v", err)
+	if err := s.respond(tx, req, ok, session.Trunk(), session.EgressRules()); err != nil {
+		logger.Error("Failed to send 200 OK for CANCEL", "call_id", callID, "error", err)
 	}
+	if session.ClaimFinalResponse() {
+		s.cancelCall(session, true)
+	}
+}
+
+// cancelCall ends a call the caller cancelled before it was answered,
+// answering its INVITE 487 Request Terminated when respond is set, and
+// records it cancelled by the caller. The caller must have claimed the
+// INVITE's final response.
+func (s *SIPServer) cancelCall(session *call.Session, respond bool) {
+	log := logger.With("call_id", session.CallID)
+	log.Info("Call cancelled by caller")
+
+	session.SetHangup(models.HangupCauseOriginatorCancel, models.HangupPartyCaller)
+	if respond {
+		tx, req := session.Transaction()
+		resp := sip.NewResponseFromRequest(req, sip.StatusRequestTerminated, "Request Terminated", nil)
+		if err := s.respond(tx, req, brand(resp, session.Branding()), session.Trunk(), session.EgressRules()); err != nil {
+			log.Error("Failed to send 487", "error", err)
+		}
+	}
+	s.calls.EndSession(session.CallID, models.CallStatusCancelled)
 }
 
-// handleOptions processes OPTIONS requests (health check / keep-alive)
+// handleOptions processes OPTIONS requests (health check / keep-alive).
+// Load is optionally reported so upstream SBCs pinging with OPTIONS can
+// dispatch by capacity.
 func (s *SIPServer) handleOptions(req *sip.Request, tx sip.ServerTransaction) {
 	ok := sip.NewResponseFromRequest(req, 200, "OK", nil)
-	ok.AppendHeader(sip.NewHeader("Allow", "INVITE, ACK, BYE, CANCEL, OPTIONS"))
+	ok.AppendHeader(sip.NewHeader("Allow", "INVITE, ACK, BYE, CANCEL, OPTIONS, REFER, UPDATE"))
 	ok.AppendHeader(sip.NewHeader("Accept", "application/sdp"))
 
+	if s.config.OptionsCapacityHeaders {
+		active, limit := s.calls.Capacity()
+		ok.AppendHeader(sip.NewHeader("X-Active-Calls", strconv.Itoa(active)))
+		ok.AppendHeader(sip.NewHeader("X-Max-Calls", strconv.Itoa(limit)))
+		ok.AppendHeader(sip.NewHeader("X-Available-Capacity", strconv.Itoa(max(limit-active, 0))))
+	}
+
+	s.edgeResponse(req, ok)
 	if err := tx.Respond(ok); err != nil {
-		log.Printf("[SIP] Failed to send OPTIONS response: %v", err)
+		logger.Error("Failed to send OPTIONS response", "error", err)
+	}
+}
+
+// handleUnsupported refuses requests of methods we don't handle with 405.
+// Those outside a dialog, like a scanner's REGISTER, count toward banning
+// their source.
+func (s *SIPServer) handleUnsupported(req *sip.Request, tx sip.ServerTransaction) {
+	resp := sip.NewResponseFromRequest(req, 405, "Method Not Allowed", nil)
+	resp.AppendHeader(sip.NewHeader("Allow", "INVITE, ACK, BYE, CANCEL, OPTIONS, REFER, UPDATE"))
+	s.edgeResponse(req, resp)
+	if err := tx.Respond(resp); err != nil {
+		logger.Error("Failed to send 405", "method", req.Method, "error", err)
+	}
+
+	if s.guard != nil && !req.To().Params.Has("tag") {
+		ctx := context.Background()
+		s.recordFailure(ctx, req, s.findSourceTrunk(ctx, req))
+	}
+}
+
+// handleRefer processes a REFER from the caller's side, transferring the
+// call to one of our numbers. Targets matching another route of the call's
+// account hand the call to that route's agent, with NOTIFYs reporting how it
+// went unless the REFER asks for none (RFC 4488); other transfers, and
+// attended ones replacing a dialog we aren't part of, are declined with 603.
+func (s *SIPServer) handleRefer(req *sip.Request, tx sip.ServerTransaction) {
+	callID := req.CallID().Value()
+	s.calls.CaptureRequest(req, models.SIPMessageInbound, req.Source())
+
+	session := s.calls.GetSession(callID)
+	if session == nil {
+		resp := sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+		if err := s.respond(tx, req, resp, nil, nil); err != nil {
+			logger.Error("Failed to send 481", "call_id", callID, "error", err)
+		}
+		return
+	}
+	log := logger.With("call_id", callID, "account_id", session.Route.AccountID)
+	reply := func(resp *sip.Response) error {
+		err := s.respond(tx, req, resp, session.Trunk(), session.EgressRules())
+		if err != nil {
+			log.Error("Failed to answer REFER", "status", resp.StatusCode, "error", err)
+		}
+		return err
+	}
+
+	referTo := req.GetHeader("Refer-To")
+	if referTo == nil {
+		reply(sip.NewResponseFromRequest(req, 400, "Missing Refer-To", nil))
+		return
+	}
+	var target sip.Uri
+	if err := sip.ParseUri(headerURI(referTo.Value()), &target); err != nil {
+		log.Info("Declining REFER with invalid target", "refer_to", referTo.Value(), "error", err)
+		reply(sip.NewResponseFromRequest(req, 400, "Bad Refer-To", nil))
+		return
+	}
+	log.Info("REFER received", "target", privacy.LogURI(target.Addr()))
+
+	var route *models.Route
+	switch {
+	case s.config.ReferPolicy == call.ReferPolicyDecline:
+	case target.Headers != nil && target.Headers.Has("Replaces"):
+		log.Info("Declining attended transfer")
+	default:
+		found, err := s.router.FindRoute(context.Background(), session.Route.AccountID, target.User, session.FromUser, headerMap(req))
+		if err == nil && found.ID != "" && found.ID != session.Route.ID && found.AccountID == session.Route.AccountID {
+			route = found
+		}
+	}
+	if route == nil {
+		log.Info("Declining REFER", "target", privacy.LogURI(target.Addr()))
+		reply(sip.NewResponseFromRequest(req, 603, "Decline", nil))
+		return
+	}
+
+	resp := sip.NewResponseFromRequest(req, 202, "Accepted", nil)
+	notify := true
+	if h := req.GetHeader("Refer-Sub"); h != nil && strings.EqualFold(strings.TrimSpace(h.Value()), "false") {
+		resp.AppendHeader(sip.NewHeader("Refer-Sub", "false"))
+		notify = false
+	}
+	if err := reply(resp); err != nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), referTimeout)
+		defer cancel()
+
+		if notify {
+			if err := session.NotifyRefer(ctx, 100, "Trying", false); err != nil {
+				log.Warn("Failed to send REFER progress", "error", err)
+			}
+		}
+
+		status, reason := 200, "OK"
+		if err := s.calls.Reroute(ctx, callID, route, target.Addr()); err != nil {
+			log.Error("Failed to reroute transferred call", "route", route.Name, "error", err)
+			status, reason = 503, "Service Unavailable"
+		}
+		if notify {
+			if err := session.NotifyRefer(ctx, status, reason, true); err != nil {
+				log.Warn("Failed to send REFER result", "error", err)
+			}
+		}
+	}()
+}
+
+// respond applies the call's egress header rules, sends a response on the
+// transaction and captures it for the call flow. Final responses to requests
+// from a trunk are counted in its stats.
+func (s *SIPServer) respond(tx sip.ServerTransaction, req *sip.Request, resp *sip.Response, trunk *models.Trunk, egressRules []models.HeaderRule) error {
+	if err := sipheader.Apply(resp, egressRules, models.HeaderRuleEgress); err != nil {
+		logger.Warn("Failed to apply header rules", "call_id", req.CallID().Value(), "error", err)
+	}
+	s.edgeResponse(req, resp)
+	if err := tx.Respond(resp); err != nil {
+		return err
+	}
+	s.calls.CaptureResponse(resp, models.SIPMessageOutbound, req.Source())
+	if req.Method == sip.INVITE && resp.StatusCode >= 200 {
+		metrics.Invites.With(strconv.Itoa(int(resp.StatusCode))).Inc()
+	}
+	if trunk != nil && resp.StatusCode >= 200 {
+		s.calls.RecordTrunkResponse(trunk.ID, models.CallDirectionInbound, string(req.Method), int(resp.StatusCode))
+	}
+	return nil
+}
+
+// authenticate checks that a caller that isn't a trunk answered our digest
+// challenge with an account's SIP credentials, returning them. Otherwise the
+// INVITE is challenged with 407, or refused with 403 for wrong credentials,
+// and authenticate reports false.
+func (s *SIPServer) authenticate(ctx context.Context, log *slog.Logger, req *sip.Request, tx sip.ServerTransaction) (*models.SIPCredential, bool) {
+	credential, err := s.auth.Authenticate(ctx, req)
+	if err == nil {
+		log.Info("Caller authenticated", "username", credential.Username, "account_id", credential.AccountID)
+		return credential, true
+	}
+
+	var resp *sip.Response
+	switch {
+	case errors.Is(err, sipauth.ErrNoCredentials):
+		resp = s.auth.Challenge(req, false)
+	case errors.Is(err, sipauth.ErrStaleNonce):
+		resp = s.auth.Challenge(req, true)
+	case errors.Is(err, sipauth.ErrInvalidCredentials):
+		log.Warn("Refusing caller with invalid SIP credentials", "source", req.Source())
+		resp = sip.NewResponseFromRequest(req, 403, "Forbidden", nil)
+		s.recordFailure(ctx, req, nil)
+	default:
+		log.Error("Failed to authenticate caller", "error", err)
+		resp = sip.NewResponseFromRequest(req, 500, "Server Internal Error", nil)
+	}
+	if err := s.respond(tx, req, resp, nil, nil); err != nil {
+		log.Error("Failed to send response", "status", resp.StatusCode, "error", err)
+	}
+	return nil, false
+}
+
+// callAccount reads the account of a call as it arrives. It returns nil for
+// calls without an account, or when it can't be read.
+func (s *SIPServer) callAccount(ctx context.Context, log *slog.Logger, accountID string) *models.Account {
+	if accountID == "" {
+		return nil
+	}
+	account, err := s.store.GetAccount(ctx, accountID)
+	if err != nil {
+		log.Warn("Failed to read account", "error", err)
+		return nil
+	}
+	return account
+}
+
+// rejectRateLimited answers an INVITE over a call rate limit with 503, asking
+// the caller to retry a second later
+func (s *SIPServer) rejectRateLimited(tx sip.ServerTransaction, req *sip.Request, limit string, branding *models.Branding, trunk *models.Trunk, egressRules []models.HeaderRule) {
+	metrics.InvitesRateLimited.With(limit).Inc()
+	resp := sip.NewResponseFromRequest(req, 503, "Service Unavailable", nil)
+	resp.AppendHeader(sip.NewHeader("Retry-After", "1"))
+	if err := s.respond(tx, req, brand(resp, branding), trunk, egressRules); err != nil {
+		logger.Error("Failed to send 503", "call_id", req.CallID().Value(), "error", err)
+	}
+}
+
+// brand gives a response rejecting a call the reason phrase and headers
+// its account's branding sets, if any
+func brand(resp *sip.Response, branding *models.Branding) *sip.Response {
+	if branding == nil || resp.StatusCode < 400 {
+		return resp
+	}
+	if reason, ok := branding.ReasonPhrases[int(resp.StatusCode)]; ok {
+		resp.Reason = reason
+	}
+	for _, name := range slices.Sorted(maps.Keys(branding.Headers)) {
+		resp.AppendHeader(sip.NewHeader(name, branding.Headers[name]))
+	}
+	return resp
+}
+
+// headerURI returns the URI of a name-addr header value such as Refer-To,
+// without display name or header parameters
+func headerURI(value string) string {
+	if start := strings.IndexByte(value, '<'); start >= 0 {
+		if end := strings.IndexByte(value[start:], '>'); end >= 0 {
+			return value[start+1 : start+end]
+		}
+	}
+	uri, _, _ := strings.Cut(strings.TrimSpace(value), ";")
+	return uri
+}
+
+// findSourceTrunk returns the trunk an inbound request came from, if any:
+// the one whose host sent it, or whose ACL ranges it came from
+func (s *SIPServer) findSourceTrunk(ctx context.Context, req *sip.Request) *models.Trunk {
+	host, _, err := net.SplitHostPort(req.Source())
+	if err != nil {
+		return nil
+	}
+
+	trunk, err := s.store.FindTrunkByHost(ctx, host)
+	if err == nil {
+		return trunk
+	}
+	if trunkID := s.acl.Check(sourceAddr(req)).TrunkID; trunkID != "" {
+		if trunk, err := s.store.FindTrunkByID(ctx, trunkID); err == nil {
+			return trunk
+		}
+	}
+	return nil
+}
+
+// inboundAccount returns the ID of the account an inbound INVITE is
+// attributed to: its trunk's, its SIP credential's, or the one owning the
+// domain it's addressed to. It returns "" when none is.
+func (s *SIPServer) inboundAccount(ctx context.Context, req *sip.Request, trunk *models.Trunk, credential *models.SIPCredential) string {
+	switch {
+	case trunk != nil:
+		return trunk.AccountID
+	case credential != nil:
+		return credential.AccountID
+	}
+
+	domain := strings.TrimSuffix(strings.ToLower(req.Recipient.Host), ".")
+	if domain == "" {
+		return ""
+	}
+	accountID, err := s.store.FindSIPDomainAccount(ctx, domain)
+	if err != nil {
+		return ""
+	}
+	return accountID
+}
+
+// applyIngressRules returns a copy of the request with the ingress header
+// rules applied, or the request itself when there are none
+func applyIngressRules(req *sip.Request, rules []models.HeaderRule) *sip.Request {
+	if len(rules) == 0 {
+		return req
+	}
+
+	rewritten := req.Clone()
+	rewritten.SetSource(req.Source())
+	rewritten.SetDestination(req.Destination())
+	rewritten.SetTransport(req.Transport())
+
+	if err := sipheader.Apply(rewritten, rules, models.HeaderRuleIngress); err != nil {
+		logger.Warn("Failed to apply header rules", "call_id", req.CallID().Value(), "error", err)
 	}
+	return rewritten
+}
+
+// headerMap collects request headers for routing (first value wins for
+// repeated headers)
+func headerMap(req *sip.Request) map[string]string {
+	headers := make(map[string]string)
+	for _, h := range req.Headers() {
+		if _, exists := headers[h.Name()]; !exists {
+			headers[h.Name()] = h.Value()
+		}
+	}
+	return headers
 }
 
 // Start starts the SIP server
@@ -255,29 +1195,53 @@ func (s *SIPServer) Start(ctx context.Context) error {
 	s.running = true
 	s.mu.Unlock()
 
-	addr := fmt.Sprintf("%s:%d", s.config.SIPHost, s.config.SIPPort)
-
-	// Start UDP listener
-	if s.config.SIPTransport == "udp" || s.config.SIPTransport == "both" {
-		go func() {
-			log.Printf("[SIP] Starting UDP server on %s", addr)
-			if err := s.server.ListenAndServe(ctx, "udp", addr); err != nil {
-				log.Printf("[SIP] UDP server error: %v", err)
+	// Listen on SIP_HOST and, for IPv6 peers, on SIP_HOST6 alongside it:
+	// each on its own address family, so the two don't contend for the port
+	port := strconv.Itoa(s.config.SIPPort)
+	for _, transport := range []string{"udp", "tcp"} {
+		if s.config.SIPTransport != transport && s.config.SIPTransport != "both" {
+			continue
+		}
+		listeners := map[string]string{transport: net.JoinHostPort(s.config.SIPHost, port)}
+		if s.config.SIPHost6 != "" {
+			listeners = map[string]string{
+				transport + "4": net.JoinHostPort(s.config.SIPHost, port),
+				transport + "6": net.JoinHostPort(s.config.SIPHost6, port),
 			}
-		}()
+		}
+		for network, addr := range listeners {
+			go func() {
+				logger.Info("Starting SIP listener", "network", network, "addr", addr)
+				if err := s.server.ListenAndServe(ctx, network, addr); err != nil {
+					logger.Error("SIP listener error", "network", network, "addr", addr, "error", err)
+				}
+			}()
+		}
 	}
 
-	// Start TCP listener
-	if s.config.SIPTransport == "tcp" || s.config.SIPTransport == "both" {
-		go func() {
-			log.Printf("[SIP] Starting TCP server on %s", addr)
-			if err := s.server.ListenAndServe(ctx, "tcp", addr); err != nil {
-				log.Printf("[SIP] TCP server error: %v", err)
-			}
-		}()
+	// Let every instance list this one's calls
+	go s.calls.PublishActiveCalls(ctx)
+
+	// Repair drift between calls in memory, Valkey and the database
+	go s.calls.Reconcile(ctx)
+
+	// End calls abandoned in setup
+	go s.reapStaleSessions(ctx)
+
+	// Drop cached routes as soon as routes or trunks change
+	go s.router.WatchChanges(ctx)
+
+	// Pick up routing defaults changed through other instances
+	go s.defaults.Run(ctx)
+	go s.overrides.Run(ctx)
+	go s.maintenance.Run(ctx)
+	go s.acl.Run(ctx)
+	go s.cps.Run(ctx)
+	if s.guard != nil {
+		go s.guard.Run(ctx)
 	}
 
-	log.Printf("[SIP] Server started on %s (%s)", addr, s.config.SIPTransport)
+	logger.Info("Server started", "addr", net.JoinHostPort(s.config.SIPHost, port), "transport", s.config.SIPTransport)
 	return nil
 }
 
@@ -295,7 +1259,7 @@ func (s *SIPServer) Stop() error {
 	// Close all active calls
 	s.calls.CloseAll()
 
-	log.Println("[SIP] Server stopped")
+	logger.Info("Server stopped")
 	return nil
 }
 
@@ -317,8 +1281,68 @@ func GetLocalIP() string {
 	return "127.0.0.1"
 }
 
+// GetLocalIP6 returns a global IPv6 address of this host for SDP, or "" if
+// it has none
+func GetLocalIP6() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() == nil && ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP.String()
+		}
+	}
+
+	return ""
+}
+
 // GenerateCallID generates a unique call ID
 func GenerateCallID() string {
 	return uuid.New().String()
 }
 
+// Calls returns the server's call manager, shared with the browser softphone
+func (s *SIPServer) Calls() *call.Manager {
+	return s.calls
+}
+
+// Trunks returns the manager registering trunks with their providers. Run it
+// on one instance at a time.
+func (s *SIPServer) Trunks() *registration.Manager {
+	return s.trunks
+}
+
+// RoutingDefaults returns the routing defaults, which the admin API changes
+func (s *SIPServer) RoutingDefaults() *routing.Defaults {
+	return s.defaults
+}
+
+// NumberOverrides returns the emergency number overrides, which the admin
+// API changes
+func (s *SIPServer) NumberOverrides() *routing.NumberOverrides {
+	return s.overrides
+}
+
+// Maintenance returns the route maintenance windows, which the API schedules
+func (s *SIPServer) Maintenance() *routing.Maintenance {
+	return s.maintenance
+}
+
+// ACL returns the access control list of request sources, which the admin
+// API changes
+func (s *SIPServer) ACL() *acl.ACL {
+	return s.acl
+}
+
+// CPS returns the INVITE rate limits, which the admin API changes
+func (s *SIPServer) CPS() *cps.Limiter {
+	return s.cps
+}
+
+// ScannerGuard returns the bans of scanner-like sources, which the admin API
+// lists and changes, or nil when scanner protection is disabled
+func (s *SIPServer) ScannerGuard() *scanner.Guard {
+	return s.guard
+}
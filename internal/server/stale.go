@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/call"
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// staleSweepInterval is how often calls stuck in setup are looked for
+const staleSweepInterval = 5 * time.Second
+
+// reapStaleSessions ends calls abandoned in setup until ctx is cancelled, so
+// INVITEs never answered and 200 OKs never ACKed on flaky networks don't
+// hold memory, RTP ports and call slots
+func (s *SIPServer) reapStaleSessions(ctx context.Context) {
+	if s.config.SessionSetupTimeout <= 0 && s.config.SessionAckTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(staleSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			unanswered, unacked := s.calls.StaleSessions(time.Now())
+			for _, session := range unanswered {
+				s.expireSetup(session)
+			}
+			for _, session := range unacked {
+				s.calls.EndUnacknowledged(session.CallID)
+			}
+		}
+	}
+}
+
+// expireSetup ends a call not answered within SESSION_SETUP_TIMEOUT,
+// answering its INVITE 408 Request Timeout, unless it was answered or
+// cancelled meanwhile
+func (s *SIPServer) expireSetup(session *call.Session) {
+	if !session.ClaimFinalResponse() {
+		return
+	}
+	log := logger.With("call_id", session.CallID)
+	log.Warn("Ending call not answered in time", "timeout", s.config.SessionSetupTimeout)
+	metrics.StaleSessions.With(metrics.StaleSetup).Inc()
+
+	session.SetHangup(models.HangupCauseSetupTimeout, models.HangupPartySystem)
+	tx, req := session.Transaction()
+	resp := sip.NewResponseFromRequest(req, sip.StatusRequestTimeout, "Request Timeout", nil)
+	if err := s.respond(tx, req, brand(resp, session.Branding()), session.Trunk(), session.EgressRules()); err != nil {
+		log.Error("Failed to send 408", "error", err)
+	}
+	s.calls.EndSession(session.CallID, models.CallStatusFailed)
+}
@@ -0,0 +1,229 @@
+// Package sipauth challenges INVITEs from callers that aren't one of the
+// accounts' trunks with SIP digest authentication (RFC 3261 section 22),
+// checking their answers against the HA1 of the accounts' SIP credentials.
+// Nonces are stateless: signed with their issue time and the caller's
+// address, so any instance sharing the secret accepts them. Each instance
+// remembers the nonce counts answered with the nonces it accepted, so an
+// answer can't be replayed to it.
+package sipauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/icholy/digest"
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+// nonceLifetime is how long a challenge can be answered; answers to older
+// ones are challenged again as stale
+const nonceLifetime = 5 * time.Minute
+
+var (
+	// ErrNoCredentials means the request doesn't answer one of our
+	// challenges, and should be challenged
+	ErrNoCredentials = errors.New("no credentials")
+
+	// ErrStaleNonce means the request answers a challenge that expired, and
+	// should be challenged again without asking for new credentials
+	ErrStaleNonce = errors.New("stale nonce")
+
+	// ErrInvalidCredentials means the request's answer is wrong or names an
+	// unknown user
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator challenges requests and checks their answers
+type Authenticator struct {
+	realm  string
+	secret []byte
+	store  *store.PostgresStore
+
+	// Highest nonce count answered with each unexpired nonce
+	countsMu sync.Mutex
+	counts   map[string]nonceCount
+}
+
+// nonceCount is the highest nonce count answered with a nonce, and when the
+// nonce was issued
+type nonceCount struct {
+	nc     int
+	issued time.Time
+}
+
+// New creates an authenticator for the configured realm
+func New(cfg *config.Config, st *store.PostgresStore) (*Authenticator, error) {
+	secret := []byte(cfg.SIPAuthNonceSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce secret: %w", err)
+		}
+	}
+	return &Authenticator{
+		realm:  cfg.SIPAuthRealm,
+		secret: secret,
+		store:  st,
+		counts: make(map[string]nonceCount),
+	}, nil
+}
+
+// Challenge returns the 407 Proxy Authentication Required response
+// challenging req; stale tells the caller its credentials may be right but
+// the nonce it answered expired
+func (a *Authenticator) Challenge(req *sip.Request, stale bool) *sip.Response {
+	chal := &digest.Challenge{
+		Realm:     a.realm,
+		Nonce:     a.nonce(time.Now(), req.Source()),
+		Algorithm: "MD5",
+		QOP:       []string{"auth"},
+		Stale:     stale,
+	}
+	resp := sip.NewResponseFromRequest(req, sip.StatusProxyAuthRequired, "Proxy Authentication Required", nil)
+	resp.AppendHeader(sip.NewHeader("Proxy-Authenticate", chal.String()))
+	return resp
+}
+
+// Authenticate checks the request's answer to our challenge in its
+// Proxy-Authorization header, returning the credential it was made with
+func (a *Authenticator) Authenticate(ctx context.Context, req *sip.Request) (*models.SIPCredential, error) {
+	var cred *digest.Credentials
+	for _, h := range req.GetHeaders("Proxy-Authorization") {
+		c, err := digest.ParseCredentials(h.Value())
+		if err == nil && c.Realm == a.realm {
+			cred = c
+			break
+		}
+	}
+	if cred == nil {
+		return nil, ErrNoCredentials
+	}
+
+	issued, ok := a.checkNonce(cred.Nonce, req.Source())
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	if time.Since(issued) > nonceLifetime {
+		return nil, ErrStaleNonce
+	}
+	if (cred.QOP != "" && cred.QOP != "auth") || (cred.Algorithm != "" && cred.Algorithm != "MD5") || cred.Userhash {
+		return nil, ErrInvalidCredentials
+	}
+
+	// The nonce count must go up with each answer to a nonce; without qop
+	// there's none, and a nonce may only be answered once
+	if cred.QOP != "" && cred.Nc <= 0 {
+		return nil, ErrInvalidCredentials
+	}
+	if !a.countFresh(cred.Nonce, cred.Nc) {
+		return nil, ErrStaleNonce
+	}
+
+	candidates, err := a.store.FindSIPCredentials(ctx, a.realm, cred.Username)
+	if err != nil {
+		return nil, err
+	}
+	for _, stored := range candidates {
+		expected := response(stored.HA1, string(req.Method), cred)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(cred.Response))) != 1 {
+			continue
+		}
+		if !a.useCount(cred.Nonce, cred.Nc, issued) {
+			return nil, ErrStaleNonce
+		}
+		return stored, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// response returns the digest response (RFC 2617 section 3.2.2.1) expected
+// of credentials with HA1 answering a challenge to a method
+func response(ha1, method string, cred *digest.Credentials) string {
+	ha2 := md5Hex(method + ":" + cred.URI)
+	if cred.QOP == "" {
+		return md5Hex(ha1 + ":" + cred.Nonce + ":" + ha2)
+	}
+	return md5Hex(fmt.Sprintf("%s:%s:%08x:%s:%s:%s", ha1, cred.Nonce, cred.Nc, cred.Cnonce, cred.QOP, ha2))
+}
+
+// md5Hex returns the hex MD5 of s
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// countFresh reports whether a nonce count is above the highest one answered
+// with the nonce so far
+func (a *Authenticator) countFresh(nonce string, nc int) bool {
+	a.countsMu.Lock()
+	defer a.countsMu.Unlock()
+
+	last, ok := a.counts[nonce]
+	return !ok || nc > last.nc
+}
+
+// useCount records a nonce count answered with a nonce issued at issued,
+// reporting false when it isn't above the highest one so far, as when the
+// answer is replayed. Expired nonces are forgotten.
+func (a *Authenticator) useCount(nonce string, nc int, issued time.Time) bool {
+	a.countsMu.Lock()
+	defer a.countsMu.Unlock()
+
+	for n, c := range a.counts {
+		if time.Since(c.issued) > nonceLifetime {
+			delete(a.counts, n)
+		}
+	}
+	if last, ok := a.counts[nonce]; ok && nc <= last.nc {
+		return false
+	}
+	a.counts[nonce] = nonceCount{nc: nc, issued: issued}
+	return true
+}
+
+// nonce returns a nonce issued at t to a caller at source (host:port)
+func (a *Authenticator) nonce(t time.Time, source string) string {
+	issued := strconv.FormatInt(t.Unix(), 16)
+	return issued + "." + a.sign(issued, source)
+}
+
+// checkNonce verifies that we issued a nonce to the caller at source,
+// returning when
+func (a *Authenticator) checkNonce(nonce, source string) (time.Time, bool) {
+	issued, signature, ok := strings.Cut(nonce, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(a.sign(issued, source))) {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(issued, 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// sign signs a nonce's issue time for the caller's host, so it can't be
+// answered from elsewhere
+func (a *Authenticator) sign(issued, source string) string {
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		host = source
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(issued + "|" + host))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,135 @@
+// Package sipheader applies header manipulation rules to SIP messages
+package sipheader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Apply runs the rules that match the message's direction and method, in
+// order. A rule that produces an unparseable header is skipped and reported
+// in the returned error; the remaining rules are still applied.
+func Apply(msg sip.Message, rules []models.HeaderRule, direction models.HeaderRuleDirection) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	method := messageMethod(msg)
+
+	var errs []string
+	for _, rule := range rules {
+		if !rule.Applies(direction, method) {
+			continue
+		}
+		if err := applyRule(msg, rule); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("header rules failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// applyRule applies a single rule to the message
+func applyRule(msg sip.Message, rule models.HeaderRule) error {
+	if rule.Action == models.HeaderRuleAdd {
+		h, err := newHeader(rule.Header, rule.Value)
+		if err != nil {
+			return err
+		}
+		msg.AppendHeader(h)
+		return nil
+	}
+
+	existing := msg.GetHeaders(rule.Header)
+	if len(existing) == 0 {
+		return nil
+	}
+
+	// Decide the new values first, then replace all instances of the header
+	values := make([]string, 0, len(existing))
+	changed := false
+	for _, h := range existing {
+		value := h.Value()
+		if !rule.MatchesValue(value) {
+			values = append(values, value)
+			continue
+		}
+
+		changed = true
+		if rule.Action == models.HeaderRuleRewrite {
+			values = append(values, rule.RewriteValue(value))
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	headers := make([]sip.Header, 0, len(values))
+	for _, value := range values {
+		h, err := newHeader(rule.Header, value)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, h)
+	}
+
+	// RemoveHeader drops one instance at a time, and only the concrete
+	// message types have it
+	switch m := msg.(type) {
+	case *sip.Request:
+		for m.RemoveHeader(rule.Header) {
+		}
+	case *sip.Response:
+		for m.RemoveHeader(rule.Header) {
+		}
+	}
+	for _, h := range headers {
+		msg.AppendHeader(h)
+	}
+	return nil
+}
+
+// newHeader builds a header, parsing the address headers sipgo keeps typed
+// (From, To, Contact) so the message accessors see the new value
+func newHeader(name, value string) (sip.Header, error) {
+	switch strings.ToLower(name) {
+	case "from", "f", "to", "t", "contact", "m":
+	default:
+		return sip.NewHeader(name, value), nil
+	}
+
+	var uri sip.Uri
+	params := sip.NewParams()
+	displayName, err := sip.ParseAddressValue(value, &uri, params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header %q: %w", name, value, err)
+	}
+
+	switch strings.ToLower(name) {
+	case "from", "f":
+		return &sip.FromHeader{DisplayName: displayName, Address: uri, Params: params}, nil
+	case "to", "t":
+		return &sip.ToHeader{DisplayName: displayName, Address: uri, Params: params}, nil
+	default:
+		return &sip.ContactHeader{DisplayName: displayName, Address: uri, Params: params}, nil
+	}
+}
+
+// messageMethod returns the request method, or the CSeq method of a response
+func messageMethod(msg sip.Message) string {
+	switch m := msg.(type) {
+	case *sip.Request:
+		return string(m.Method)
+	case *sip.Response:
+		if cseq := m.CSeq(); cseq != nil {
+			return string(cseq.MethodName)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+// Package softphone is a WebRTC gateway that lets a browser call a route
+// directly, so agents can be tested from a web page without a SIP client or
+// trunk. Browser audio joins the same session pipeline as SIP calls.
+package softphone
+
+import (
+	"context"
+	_ "embed" // Test page
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v4"
+	"github.com/shiv6146/blayzen-sip/internal/call"
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// Page is the browser softphone test page
+//
+//go:embed phone.html
+var Page []byte
+
+// Errors returned by Gateway.Dial
+var (
+	ErrInvalidOffer     = errors.New("invalid offer")
+	ErrAgentUnavailable = errors.New("agent unavailable")
+)
+
+var logger = logging.Component("softphone")
+
+// Gateway answers browser offers and bridges them into call sessions
+type Gateway struct {
+	calls      *call.Manager
+	api        *webrtc.API
+	iceServers []webrtc.ICEServer
+
+	// Account of each active browser call
+	mu       sync.Mutex
+	accounts map[string]string
+}
+
+// Call is an answered browser call
+type Call struct {
+	ID     string
+	Answer string // SDP answer for the browser
+}
+
+// New creates a gateway. Browsers may only negotiate PCMU, the codec the
+// session pipeline carries, and media uses the RTP port range.
+func New(cfg *config.Config, calls *call.Manager) (*Gateway, error) {
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: pcmu,
+		PayloadType:        0,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, fmt.Errorf("failed to register PCMU: %w", err)
+	}
+
+	settings := webrtc.SettingEngine{}
+	if err := settings.SetEphemeralUDPPortRange(uint16(cfg.RTPPortMin), uint16(cfg.RTPPortMax)); err != nil {
+		return nil, fmt.Errorf("invalid RTP port range: %w", err)
+	}
+	if cfg.WebRTCPublicIP != "" {
+		settings.SetNAT1To1IPs([]string{cfg.WebRTCPublicIP}, webrtc.ICECandidateTypeHost)
+	}
+
+	var iceServers []webrtc.ICEServer
+	for _, u := range strings.Split(cfg.WebRTCICEServers, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			iceServers = append(iceServers, webrtc.ICEServer{URLs: []string{u}})
+		}
+	}
+
+	return &Gateway{
+		calls:      calls,
+		api:        webrtc.NewAPI(webrtc.WithMediaEngine(media), webrtc.WithSettingEngine(settings)),
+		iceServers: iceServers,
+		accounts:   make(map[string]string),
+	}, nil
+}
+
+// pcmu is the only codec offered to browsers
+var pcmu = webrtc.RTPCodecCapability{
+	MimeType:  webrtc.MimeTypePCMU,
+	ClockRate: 8000,
+	Channels:  1,
+}
+
+// Dial answers a browser's SDP offer with a call to the route. The agent is
+// connected before answering (unless the route detects humans first), so a
+// failure is reported to the browser rather than as a silent call.
+func (g *Gateway) Dial(ctx context.Context, route *models.Route, from, offer string) (*Call, error) {
+	pc, err := g.api.NewPeerConnection(webrtc.Configuration{ICEServers: g.iceServers})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create peer connection: %w", err)
+	}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(pcmu, "audio", "blayzen-sip")
+	if err != nil {
+		_ = pc.Close()
+		return nil, fmt.Errorf("failed to create audio track: %w", err)
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		_ = pc.Close()
+		return nil, fmt.Errorf("failed to add audio track: %w", err)
+	}
+
+	// RTCP must be read for pion's interceptors to run
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	callID := uuid.New().String()
+	log := logger.With("call_id", callID, "account_id", route.AccountID)
+	media := newPeerTransport(pc, track)
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		log.Info("Receiving audio", "codec", remote.Codec().MimeType)
+		media.readTrack(remote)
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Info("Connection state changed", "state", state.String())
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			media.connected.Store(true)
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			media.connected.Store(false)
+			g.end(callID, models.CallStatusCompleted)
+		}
+	})
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		_ = pc.Close()
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOffer, err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		_ = pc.Close()
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOffer, err)
+	}
+
+	// Answer with all candidates rather than trickling them
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		_ = pc.Close()
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		_ = pc.Close()
+		return nil, ctx.Err()
+	}
+
+	session, err := g.calls.CreateWebRTCSession(ctx, callID, from, route, media)
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+
+	g.mu.Lock()
+	g.accounts[callID] = route.AccountID
+	g.mu.Unlock()
+
+	if !route.DetectHuman {
+		if err := session.ConnectAgent(ctx); err != nil {
+			log.Error("Failed to connect to agent", "error", err)
+			g.end(callID, models.CallStatusFailed)
+			return nil, fmt.Errorf("%w: %v", ErrAgentUnavailable, err)
+		}
+	}
+
+	go session.StartMedia()
+	log.Info("Call answered", "route", route.Name)
+
+	return &Call{ID: callID, Answer: pc.LocalDescription().SDP}, nil
+}
+
+// Hangup ends an account's browser call. It reports false if the account
+// has no such call.
+func (g *Gateway) Hangup(accountID, callID string) bool {
+	g.mu.Lock()
+	owner, ok := g.accounts[callID]
+	g.mu.Unlock()
+
+	if !ok || owner != accountID {
+		return false
+	}
+	g.end(callID, models.CallStatusCompleted)
+	return true
+}
+
+// end removes a browser call's session, closing its peer connection, and
+// records the status the call ended with
+func (g *Gateway) end(callID string, status models.CallStatus) {
+	g.mu.Lock()
+	_, ok := g.accounts[callID]
+	delete(g.accounts, callID)
+	g.mu.Unlock()
+
+	if ok {
+		g.calls.EndSession(callID, status)
+	}
+}
@@ -0,0 +1,93 @@
+package softphone
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/shiv6146/blayzen-sip/internal/call"
+)
+
+// inboundQueue is how many browser RTP packets may wait for the session
+// (about a second of 20ms frames)
+const inboundQueue = 50
+
+// peerTransport carries a session's RTP over a browser peer connection. The
+// browser's audio track feeds ReadRTP; WriteRTP goes out on our audio track,
+// whose SSRC and payload type pion sets from the negotiated codec.
+type peerTransport struct {
+	pc    *webrtc.PeerConnection
+	track *webrtc.TrackLocalStaticRTP
+
+	packets   chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	connected atomic.Bool
+}
+
+// newPeerTransport wraps a peer connection and its outbound audio track
+func newPeerTransport(pc *webrtc.PeerConnection, track *webrtc.TrackLocalStaticRTP) *peerTransport {
+	return &peerTransport{
+		pc:      pc,
+		track:   track,
+		packets: make(chan []byte, inboundQueue),
+		done:    make(chan struct{}),
+	}
+}
+
+// readTrack queues the browser's RTP packets until the track ends. Packets
+// are dropped when the session falls behind.
+func (t *peerTransport) readTrack(remote *webrtc.TrackRemote) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			return
+		}
+
+		select {
+		case t.packets <- append([]byte(nil), buf[:n]...):
+		case <-t.done:
+			return
+		default:
+		}
+	}
+}
+
+// ReadRTP returns the next packet from the browser
+func (t *peerTransport) ReadRTP(buf []byte, timeout time.Duration) (int, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case packet := <-t.packets:
+		return copy(buf, packet), nil
+	case <-timer.C:
+		return 0, call.ErrMediaTimeout
+	case <-t.done:
+		return 0, net.ErrClosed
+	}
+}
+
+// WriteRTP sends a packet to the browser
+func (t *peerTransport) WriteRTP(packet []byte) error {
+	_, err := t.track.Write(packet)
+	return err
+}
+
+// Ready reports whether the peer connection is up
+func (t *peerTransport) Ready() bool {
+	return t.connected.Load()
+}
+
+// Close closes the peer connection
+func (t *peerTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.done)
+		err = t.pc.Close()
+	})
+	return err
+}
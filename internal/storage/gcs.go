@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Google Cloud Storage endpoints
+const (
+	gcsHost      = "storage.googleapis.com"
+	gcsScope     = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsTokenURL  = "https://oauth2.googleapis.com/token"
+	gcsJWTGrant  = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	gcsTokenSkew = time.Minute // Before expiry that access tokens are renewed
+)
+
+// gcsCredentials is a service account key file, as downloaded from the
+// Cloud console
+type gcsCredentials struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// GCS keeps blobs in a Google Cloud Storage bucket through its JSON API,
+// authenticating as a service account. Download URLs are V4 signed URLs.
+type GCS struct {
+	bucket   string
+	prefix   string
+	email    string
+	key      *rsa.PrivateKey
+	tokenURL string
+	client   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewGCS creates a GCS client from a service account key file's contents
+func NewGCS(bucket, prefix string, credentials []byte) (*GCS, error) {
+	var creds gcsCredentials
+	if err := json.Unmarshal(credentials, &creds); err != nil {
+		return nil, fmt.Errorf("invalid GCS credentials: %w", err)
+	}
+	if creds.Type != "service_account" || creds.ClientEmail == "" {
+		return nil, fmt.Errorf("invalid GCS credentials: not a service account key")
+	}
+
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid GCS credentials: no private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GCS credentials: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid GCS credentials: not an RSA key")
+	}
+
+	tokenURL := creds.TokenURI
+	if tokenURL == "" {
+		tokenURL = gcsTokenURL
+	}
+	return &GCS{
+		bucket:   bucket,
+		prefix:   prefix,
+		email:    creds.ClientEmail,
+		key:      key,
+		tokenURL: tokenURL,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// Put uploads an object and returns its URL
+func (g *GCS) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	query := url.Values{"uploadType": {"media"}, "name": {g.name(key)}}
+	uploadURL := "https://" + gcsHost + "/upload/storage/v1/b/" + url.PathEscape(g.bucket) + "/o?" + query.Encode()
+
+	resp, err := g.do(ctx, http.MethodPost, uploadURL, data, contentType)
+	if err != nil {
+		return "", fmt.Errorf("upload failed: %w", err)
+	}
+	resp.Body.Close()
+	return g.Location(key), nil
+}
+
+// Get downloads an object
+func (g *GCS) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := g.do(ctx, http.MethodGet, g.objectURL(g.name(key))+"?alt=media", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Delete deletes an object by URL
+func (g *GCS) Delete(ctx context.Context, location string) error {
+	name, err := g.objectName(location)
+	if err != nil {
+		return err
+	}
+	resp, err := g.do(ctx, http.MethodDelete, g.objectURL(name), nil, "")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// gcsObjects is a page of an objects list response
+type gcsObjects struct {
+	Items []struct {
+		Name    string    `json:"name"`
+		Size    string    `json:"size"` // A decimal string
+		Updated time.Time `json:"updated"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// List lists the objects under prefix a page at a time
+func (g *GCS) List(ctx context.Context, prefix string, fn func(Object) error) error {
+	root := g.name("")
+	query := url.Values{"prefix": {listPrefix(g.prefix, prefix)}, "fields": {"items(name,size,updated),nextPageToken"}}
+
+	for {
+		listURL := "https://" + gcsHost + "/storage/v1/b/" + url.PathEscape(g.bucket) + "/o?" + query.Encode()
+		resp, err := g.do(ctx, http.MethodGet, listURL, nil, "")
+		if err != nil {
+			return fmt.Errorf("list failed: %w", err)
+		}
+		var page gcsObjects
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("invalid list response: %w", err)
+		}
+
+		for _, item := range page.Items {
+			size, _ := strconv.ParseInt(item.Size, 10, 64)
+			key := strings.TrimPrefix(strings.TrimPrefix(item.Name, root), "/")
+			if err := fn(Object{Key: key, Size: size, Modified: item.Updated}); err != nil {
+				return err
+			}
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// Location returns the public URL of the object under key, which downloads
+// are signed for
+func (g *GCS) Location(key string) string {
+	return "https://" + gcsHost + "/" + g.bucket + (&url.URL{Path: "/" + g.name(key)}).EscapedPath()
+}
+
+// URL returns a V4 signed download URL for an object
+func (g *GCS) URL(location string, expiry time.Duration) (string, error) {
+	return g.sign(location, expiry, time.Now())
+}
+
+// sign builds a V4 signed GET URL, signed with the service account key
+func (g *GCS) sign(location string, expiry time.Duration, now time.Time) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid object URL: %w", err)
+	}
+
+	now = now.UTC()
+	scope := now.Format("20060102") + "/auto/storage/goog4_request"
+
+	query := url.Values{}
+	query.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	query.Set("X-Goog-Credential", g.email+"/"+scope)
+	query.Set("X-Goog-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Goog-Expires", fmt.Sprintf("%d", int(expiry.Seconds())))
+	query.Set("X-Goog-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"GOOG4-RSA-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, g.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	query.Set("X-Goog-Signature", hex.EncodeToString(signature))
+	u.RawQuery = canonicalQuery(query)
+	return u.String(), nil
+}
+
+// name returns the object name of a blob key, under the configured prefix
+func (g *GCS) name(key string) string {
+	return path.Join(g.prefix, key)
+}
+
+// objectURL returns the JSON API URL of an object
+func (g *GCS) objectURL(name string) string {
+	return "https://" + gcsHost + "/storage/v1/b/" + url.PathEscape(g.bucket) + "/o/" + url.PathEscape(name)
+}
+
+// objectName returns the name of the object at a location in the bucket
+func (g *GCS) objectName(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Host != gcsHost {
+		return "", fmt.Errorf("not a GCS object URL: %s", location)
+	}
+	name, found := strings.CutPrefix(u.Path, "/"+g.bucket+"/")
+	if !found {
+		return "", fmt.Errorf("not an object of bucket %s: %s", g.bucket, location)
+	}
+	return name, nil
+}
+
+// do sends a request authorized with an access token, failing unless it
+// succeeds. A 404 is returned as os.ErrNotExist.
+func (g *GCS) do(ctx context.Context, method, rawURL string, body []byte, contentType string) (*http.Response, error) {
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return checkResponse(resp)
+}
+
+// accessToken returns an OAuth access token for the service account,
+// exchanging a signed JWT for a new one when the last is about to expire
+func (g *GCS) accessToken(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if g.token != "" && now.Add(gcsTokenSkew).Before(g.expires) {
+		return g.token, nil
+	}
+
+	assertion, err := g.assertion(now)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"grant_type": {gcsJWTGrant}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	if resp, err = checkResponse(resp); err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	g.token = token.AccessToken
+	g.expires = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return g.token, nil
+}
+
+// assertion returns the JWT exchanged for an access token (RFC 7523)
+func (g *GCS) assertion(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   g.email,
+		"scope": gcsScope,
+		"aud":   g.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, g.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+var logger = logging.Component("storage")
+
+// lifecycleInterval is how often blobs past their retention are deleted
+const lifecycleInterval = time.Hour
+
+// Lifecycle deletes recordings and saved exports once past their retention
+type Lifecycle struct {
+	blobs Store
+	store *store.PostgresStore
+	rules []retention
+}
+
+// retention is how long blobs under a prefix are kept
+type retention struct {
+	prefix string
+	maxAge time.Duration
+}
+
+// NewLifecycle creates the lifecycle of the configured retention periods
+func NewLifecycle(cfg *config.Config, blobs Store, st *store.PostgresStore) *Lifecycle {
+	l := &Lifecycle{blobs: blobs, store: st}
+	if cfg.RecordingRetentionDays > 0 {
+		l.rules = append(l.rules, retention{PrefixRecordings, days(cfg.RecordingRetentionDays)})
+	}
+	if cfg.ExportRetentionDays > 0 {
+		l.rules = append(l.rules, retention{PrefixExports, days(cfg.ExportRetentionDays)})
+	}
+	return l
+}
+
+// Run deletes expired blobs now and then hourly until ctx is cancelled
+func (l *Lifecycle) Run(ctx context.Context) {
+	if len(l.rules) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(lifecycleInterval)
+	defer ticker.Stop()
+
+	for {
+		l.expire(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expire deletes the blobs of each rule modified before its retention
+func (l *Lifecycle) expire(ctx context.Context, now time.Time) {
+	for _, rule := range l.rules {
+		before := now.Add(-rule.maxAge)
+		deleted := 0
+		err := l.blobs.List(ctx, rule.prefix, func(object Object) error {
+			if !object.Modified.Before(before) {
+				return nil
+			}
+			if err := l.blobs.Delete(ctx, l.blobs.Location(object.Key)); err != nil {
+				return err
+			}
+			deleted++
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			logger.Error("Failed to delete expired blobs", "prefix", rule.prefix, "error", err)
+		}
+		if deleted > 0 {
+			logger.Info("Deleted expired blobs", "prefix", rule.prefix, "count", deleted)
+		}
+
+		if rule.prefix != PrefixRecordings {
+			continue
+		}
+		// The calls of deleted recordings no longer offer them for download
+		if cleared, err := l.store.ClearRecordings(ctx, before); err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Failed to clear expired recordings", "error", err)
+			}
+		} else if cleared > 0 {
+			logger.Info("Cleared expired recordings from calls", "count", cleared)
+		}
+	}
+}
+
+// days returns a duration of n days
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
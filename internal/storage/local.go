@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local keeps blobs as files under a directory
+type Local struct {
+	root string // The directory joined with the prefix
+}
+
+// NewLocal creates a store keeping blobs under dir/prefix
+func NewLocal(dir, prefix string) *Local {
+	return &Local{root: filepath.Join(dir, filepath.FromSlash(prefix))}
+}
+
+// Put writes data to the key's file, through a temporary file so readers
+// never see it half written
+func (l *Local) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	location := l.Location(key)
+	if err := os.MkdirAll(filepath.Dir(location), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp := location + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", location, err)
+	}
+	if err := os.Rename(tmp, location); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("failed to write %s: %w", location, err)
+	}
+	return location, nil
+}
+
+// Get reads the key's file
+func (l *Local) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(l.Location(key))
+}
+
+// Delete removes a file
+func (l *Local) Delete(ctx context.Context, location string) error {
+	if IsRemote(location) {
+		return fmt.Errorf("not a local file: %s", location)
+	}
+	if err := os.Remove(location); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks the files under prefix
+func (l *Local) List(ctx context.Context, prefix string, fn func(Object) error) error {
+	dir := filepath.Join(l.root, filepath.FromSlash(prefix))
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed since listed
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		return fn(Object{Key: filepath.ToSlash(rel), Size: info.Size(), Modified: info.ModTime()})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // Nothing stored yet
+	}
+	return err
+}
+
+// Location returns the key's file path
+func (l *Local) Location(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(key))
+}
+
+// URL returns "", local files being served by the API
+func (l *Local) URL(location string, expiry time.Duration) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config configures an S3-compatible object store (AWS S3, MinIO, GCS
+// interoperability with HMAC keys, ...)
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com, https://storage.googleapis.com
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool // bucket in the path instead of the host name (MinIO)
+}
+
+// S3 keeps blobs in an S3-compatible store using AWS Signature Version 4
+type S3 struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+// NewS3 creates an S3 client
+func NewS3(cfg S3Config) *S3 {
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &S3{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Put uploads an object and returns its URL
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	objectURL := s.Location(key)
+	resp, err := s.do(ctx, http.MethodPut, objectURL, data, contentType)
+	if err != nil {
+		return "", fmt.Errorf("upload failed: %w", err)
+	}
+	resp.Body.Close()
+	return objectURL, nil
+}
+
+// Get downloads an object
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.Location(key), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Delete deletes an object by URL
+func (s *S3) Delete(ctx context.Context, location string) error {
+	if !IsRemote(location) {
+		return fmt.Errorf("not an object URL: %s", location)
+	}
+	resp, err := s.do(ctx, http.MethodDelete, location, nil, "")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listBucketResult is a page of a ListObjectsV2 response
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List lists the objects under prefix a page at a time
+func (s *S3) List(ctx context.Context, prefix string, fn func(Object) error) error {
+	root := s.key("")
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", listPrefix(s.cfg.Prefix, prefix))
+
+	for {
+		resp, err := s.do(ctx, http.MethodGet, s.bucketURL()+"?"+canonicalQuery(query), nil, "")
+		if err != nil {
+			return fmt.Errorf("list failed: %w", err)
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("invalid list response: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			key := strings.TrimPrefix(strings.TrimPrefix(object.Key, root), "/")
+			if err := fn(Object{Key: key, Size: object.Size, Modified: object.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// Location returns the URL of the object under key
+func (s *S3) Location(key string) string {
+	return s.objectURL(s.key(key))
+}
+
+// URL returns a presigned download URL for an object
+func (s *S3) URL(location string, expiry time.Duration) (string, error) {
+	return s.presign(location, expiry, time.Now())
+}
+
+// key returns the object key of a blob key, under the configured prefix
+func (s *S3) key(key string) string {
+	return path.Join(s.cfg.Prefix, key)
+}
+
+// do sends a signed request, failing unless it succeeds. A 404 is returned
+// as os.ErrNotExist.
+func (s *S3) do(ctx context.Context, method, rawURL string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return checkResponse(resp)
+}
+
+// checkResponse returns a successful response, or an error with the body of
+// a failed one
+func checkResponse(resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", resp.Request.URL.Path, os.ErrNotExist)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// presign builds a SigV4 query-string signed GET URL
+func (s *S3) presign(objectURL string, expiry time.Duration, now time.Time) (string, error) {
+	u, err := url.Parse(objectURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid object URL: %w", err)
+	}
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	query.Set("X-Amz-Signature", s.signature(now, amzDate, scope, canonicalRequest))
+	u.RawQuery = canonicalQuery(query)
+	return u.String(), nil
+}
+
+// bucketURL returns the URL of the bucket
+func (s *S3) bucketURL() string {
+	return s.objectURL("")
+}
+
+// objectURL returns the URL of an object key
+func (s *S3) objectURL(key string) string {
+	escaped := (&url.URL{Path: "/" + key}).EscapedPath()
+	if s.cfg.PathStyle {
+		return fmt.Sprintf("%s/%s%s", s.cfg.Endpoint, s.cfg.Bucket, escaped)
+	}
+
+	scheme, host, found := strings.Cut(s.cfg.Endpoint, "://")
+	if !found {
+		scheme, host = "https", s.cfg.Endpoint
+	}
+	return fmt.Sprintf("%s://%s.%s%s", scheme, s.cfg.Bucket, host, escaped)
+}
+
+// sign adds SigV4 authorization headers to a request
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		signedHeaders = append([]string{"content-type"}, signedHeaders...)
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, strings.Join(signedHeaders, ";"),
+		s.signature(now, amzDate, scope, canonicalRequest),
+	))
+}
+
+// scope returns the credential scope for the given time
+func (s *S3) scope(now time.Time) string {
+	return fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), s.cfg.Region)
+}
+
+// signature computes the SigV4 signature of a canonical request
+func (s *S3) signature(now time.Time, amzDate, scope, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalQuery encodes query parameters sorted by key, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, escape(k)+"="+escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape percent-encodes everything but unreserved characters (RFC 3986)
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// hmacSHA256 computes HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
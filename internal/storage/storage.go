@@ -0,0 +1,131 @@
+// Package storage keeps blobs (call recordings, prompts and saved exports)
+// on local disk, in an S3-compatible object store or in Google Cloud
+// Storage, and deletes them once past their retention.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/internal/config"
+)
+
+// Storage backends
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+	BackendGCS   = "gcs"
+)
+
+// Prefixes of the kinds of blobs stored
+const (
+	PrefixRecordings = "recordings"
+	PrefixPrompts    = "prompts"
+	PrefixExports    = "exports"
+)
+
+// Store keeps blobs by key, a slash-separated name under the configured
+// prefix. Where a blob is kept is its location: a file path on local disk
+// or an object URL, which is what the database records.
+type Store interface {
+	// Put stores data under key, replacing any blob there, and returns its
+	// location
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+
+	// Get returns the blob under key, or an error wrapping os.ErrNotExist
+	// when there is none
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Delete removes the blob at a location. A blob already gone isn't an
+	// error.
+	Delete(ctx context.Context, location string) error
+
+	// List calls fn with each blob whose key starts with prefix, stopping at
+	// the first error
+	List(ctx context.Context, prefix string, fn func(Object) error) error
+
+	// Location returns the location of the blob under key
+	Location(key string) string
+
+	// URL returns a download URL valid for expiry for a blob at a location,
+	// or "" when it's a local file to be served directly
+	URL(location string, expiry time.Duration) (string, error)
+}
+
+// Object is a stored blob
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// NewFromConfig returns the configured store
+func NewFromConfig(cfg *config.Config) (Store, error) {
+	switch cfg.StorageBackend {
+	case BackendLocal:
+		return NewLocal(cfg.StorageDir, cfg.StoragePrefix), nil
+	case BackendS3:
+		if cfg.StorageS3Bucket == "" {
+			return nil, fmt.Errorf("STORAGE_S3_BUCKET is required for s3 storage")
+		}
+		return NewS3(S3Config{
+			Endpoint:  cfg.StorageS3Endpoint,
+			Region:    cfg.StorageS3Region,
+			Bucket:    cfg.StorageS3Bucket,
+			Prefix:    cfg.StoragePrefix,
+			AccessKey: cfg.StorageS3AccessKey,
+			SecretKey: cfg.StorageS3SecretKey,
+			PathStyle: cfg.StorageS3PathStyle,
+		}), nil
+	case BackendGCS:
+		if cfg.StorageGCSBucket == "" {
+			return nil, fmt.Errorf("STORAGE_GCS_BUCKET is required for gcs storage")
+		}
+		key, err := os.ReadFile(cfg.StorageGCSCredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GCS credentials: %w", err)
+		}
+		gcs, err := NewGCS(cfg.StorageGCSBucket, cfg.StoragePrefix, key)
+		if err != nil {
+			return nil, err
+		}
+		return gcs, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (must be local, s3 or gcs)", cfg.StorageBackend)
+	}
+}
+
+// DatedKey returns the key of a blob named name under prefix, partitioned
+// by the UTC day so listings stay browsable
+func DatedKey(prefix, name string, now time.Time) string {
+	return path.Join(prefix, now.UTC().Format("2006/01/02"), name)
+}
+
+// listPrefix returns the object name prefix listing key prefix under root,
+// keeping a trailing slash so only a directory's objects match
+func listPrefix(root, prefix string) string {
+	name := path.Join(root, prefix)
+	if strings.HasSuffix(prefix, "/") && name != "" {
+		name += "/"
+	}
+	return name
+}
+
+// PutFile stores a local file under key and returns its location
+func PutFile(ctx context.Context, st Store, key, localPath, contentType string) (string, error) {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", localPath, err)
+	}
+	return st.Put(ctx, key, data, contentType)
+}
+
+// IsRemote reports whether a location is an object URL rather than a local
+// file
+func IsRemote(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
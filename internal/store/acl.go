@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// ListACLEntries returns the SIP access control list: the global entries
+// and those of active trunks
+func (s *PostgresStore) ListACLEntries(ctx context.Context) ([]*models.ACLEntry, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT e.id, e.trunk_id, e.action, e.cidr::text, e.description, e.created_at
+		FROM sip_acl_entries e
+		LEFT JOIN sip_trunks t ON t.id = e.trunk_id
+		WHERE e.trunk_id IS NULL OR t.active = true
+		ORDER BY e.created_at ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.ACLEntry
+	for rows.Next() {
+		var e models.ACLEntry
+		if err := rows.Scan(&e.ID, &e.TrunkID, &e.Action, &e.CIDR, &e.Description, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// CreateACLEntry adds an entry to the SIP access control list
+func (s *PostgresStore) CreateACLEntry(ctx context.Context, entry *models.ACLEntry) (*models.ACLEntry, error) {
+	var e models.ACLEntry
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO sip_acl_entries (trunk_id, action, cidr, description)
+		VALUES ($1, $2, $3::cidr, $4)
+		RETURNING id, trunk_id, action, cidr::text, description, created_at
+	`, entry.TrunkID, entry.Action, entry.CIDR, entry.Description).Scan(
+		&e.ID, &e.TrunkID, &e.Action, &e.CIDR, &e.Description, &e.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// DeleteACLEntry removes an entry from the SIP access control list. It
+// returns pgx.ErrNoRows when there is no such entry.
+func (s *PostgresStore) DeleteACLEntry(ctx context.Context, id string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM sip_acl_entries WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/shiv6146/blayzen-sip/internal/metrics"
 	"github.com/shiv6146/blayzen-sip/internal/models"
 	"github.com/valkey-io/valkey-go"
 )
@@ -47,14 +51,33 @@ func (c *Cache) Close() {
 	c.client.Close()
 }
 
-// routeKey generates the cache key for a route lookup
-func routeKey(toUser, fromUser string) string {
-	return fmt.Sprintf("route:%s:%s", toUser, fromUser)
+// doMulti sends commands in a single round trip, returning the first error
+func (c *Cache) doMulti(ctx context.Context, cmds ...valkey.Completed) ([]valkey.ValkeyResult, error) {
+	results := c.client.DoMulti(ctx, cmds...)
+	for _, result := range results {
+		if err := result.Error(); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// observeSetup records how long a cache operation on the call setup path
+// took, from start
+func observeSetup(start time.Time) {
+	metrics.CacheSetupSeconds.Observe(time.Since(start).Seconds())
+}
+
+// routeKey generates the cache key for a route lookup, within an account or
+// across all of them ("")
+func routeKey(accountID, toUser, fromUser string) string {
+	return fmt.Sprintf("route:%s:%s:%s", accountID, toUser, fromUser)
 }
 
 // CacheRoutes caches routes for a specific lookup
-func (c *Cache) CacheRoutes(ctx context.Context, toUser, fromUser string, routes []*models.Route) error {
-	key := routeKey(toUser, fromUser)
+func (c *Cache) CacheRoutes(ctx context.Context, accountID, toUser, fromUser string, routes []*models.Route) error {
+	defer observeSetup(time.Now())
+	key := routeKey(accountID, toUser, fromUser)
 
 	data, err := json.Marshal(routes)
 	if err != nil {
@@ -67,8 +90,9 @@ func (c *Cache) CacheRoutes(ctx context.Context, toUser, fromUser string, routes
 }
 
 // GetCachedRoutes retrieves cached routes
-func (c *Cache) GetCachedRoutes(ctx context.Context, toUser, fromUser string) ([]*models.Route, error) {
-	key := routeKey(toUser, fromUser)
+func (c *Cache) GetCachedRoutes(ctx context.Context, accountID, toUser, fromUser string) ([]*models.Route, error) {
+	defer observeSetup(time.Now())
+	key := routeKey(accountID, toUser, fromUser)
 
 	result, err := c.client.Do(ctx, c.client.B().Get().Key(key).Build()).ToString()
 	if err != nil {
@@ -102,31 +126,49 @@ func (c *Cache) InvalidateRouteCache(ctx context.Context) error {
 	return c.client.Do(ctx, c.client.B().Del().Key(keys...).Build()).Error()
 }
 
+// roundRobinKey generates the cache key for a round-robin counter. It lives
+// outside the route: namespace so route cache invalidation keeps the rotation.
+func roundRobinKey(candidates string) string {
+	return fmt.Sprintf("rr:routes:%s", candidates)
+}
+
+// IncrRoundRobin increments and returns the round-robin counter for a set of
+// equal-priority routes, refreshing its expiry in the same round trip
+func (c *Cache) IncrRoundRobin(ctx context.Context, candidates string) (int64, error) {
+	defer observeSetup(time.Now())
+	key := roundRobinKey(candidates)
+
+	// Expire idle counters (a changed candidate set gets a new key)
+	results, err := c.doMulti(ctx,
+		c.client.B().Incr().Key(key).Build(),
+		c.client.B().Expire().Key(key).Seconds(86400).Build(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return results[0].AsInt64()
+}
+
+// activeCallTTL is how long active call entries are kept (calls shouldn't
+// last longer)
+const activeCallTTL = time.Hour
+
 // activeCallKey generates the cache key for tracking active calls
 func activeCallKey(callID string) string {
 	return fmt.Sprintf("call:active:%s", callID)
 }
 
-// SetActiveCall marks a call as active in the cache
+// SetActiveCall marks a call as active in the cache, storing its data and
+// expiry in a single round trip
 func (c *Cache) SetActiveCall(ctx context.Context, callID string, data map[string]string) error {
+	defer observeSetup(time.Now())
 	key := activeCallKey(callID)
 
-	// Store call data with 1 hour TTL (calls shouldn't last longer)
-	args := make([]string, 0, len(data)*2)
-	for k, v := range data {
-		args = append(args, k, v)
-	}
-
-	if err := c.client.Do(ctx,
-		c.client.B().Hset().Key(key).FieldValue().FieldValue(args[0], args[1]).Build(),
-	).Error(); err != nil {
-		return err
-	}
-
-	// Set TTL
-	return c.client.Do(ctx,
-		c.client.B().Expire().Key(key).Seconds(3600).Build(),
-	).Error()
+	_, err := c.doMulti(ctx,
+		c.client.B().Hset().Key(key).FieldValue().FieldValueIter(maps.All(data)).Build(),
+		c.client.B().Expire().Key(key).Seconds(int64(activeCallTTL.Seconds())).Build(),
+	)
+	return err
 }
 
 // GetActiveCall retrieves active call data
@@ -147,6 +189,34 @@ func (c *Cache) RemoveActiveCall(ctx context.Context, callID string) error {
 	return c.client.Do(ctx, c.client.B().Del().Key(key).Build()).Error()
 }
 
+// ActiveCallAges returns how long ago each active call entry was set, by
+// Call-ID
+func (c *Cache) ActiveCallAges(ctx context.Context) (map[string]time.Duration, error) {
+	prefix := activeCallKey("")
+	keys, err := c.client.Do(ctx, c.client.B().Keys().Pattern(prefix+"*").Build()).AsStrSlice()
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	cmds := make([]valkey.Completed, len(keys))
+	for i, key := range keys {
+		cmds[i] = c.client.B().Pttl().Key(key).Build()
+	}
+	results, err := c.doMulti(ctx, cmds...)
+	if err != nil {
+		return nil, err
+	}
+	ages := make(map[string]time.Duration, len(keys))
+	for i, result := range results {
+		ttl, err := result.AsInt64()
+		if err != nil || ttl < 0 {
+			continue // Expired since listed
+		}
+		ages[strings.TrimPrefix(keys[i], prefix)] = activeCallTTL - time.Duration(ttl)*time.Millisecond
+	}
+	return ages, nil
+}
+
 // GetActiveCallCount returns the number of active calls
 func (c *Cache) GetActiveCallCount(ctx context.Context) (int64, error) {
 	keys, err := c.client.Do(ctx, c.client.B().Keys().Pattern("call:active:*").Build()).AsStrSlice()
@@ -156,3 +226,256 @@ func (c *Cache) GetActiveCallCount(ctx context.Context) (int64, error) {
 	return int64(len(keys)), nil
 }
 
+// instanceCallsKey is the key of an instance's active-call snapshot
+func instanceCallsKey(instance string) string {
+	return fmt.Sprintf("calls:live:%s", instance)
+}
+
+// SetInstanceCalls stores an instance's snapshot of its active calls, kept
+// for ttl unless refreshed
+func (c *Cache) SetInstanceCalls(ctx context.Context, instance string, payload []byte, ttl time.Duration) error {
+	return c.client.Do(ctx,
+		c.client.B().Set().Key(instanceCallsKey(instance)).Value(string(payload)).Px(ttl).Build(),
+	).Error()
+}
+
+// RemoveInstanceCalls removes an instance's active-call snapshot
+func (c *Cache) RemoveInstanceCalls(ctx context.Context, instance string) error {
+	return c.client.Do(ctx, c.client.B().Del().Key(instanceCallsKey(instance)).Build()).Error()
+}
+
+// GetInstanceCalls returns every instance's active-call snapshot, by instance
+func (c *Cache) GetInstanceCalls(ctx context.Context) (map[string][]byte, error) {
+	prefix := instanceCallsKey("")
+	keys, err := c.client.Do(ctx, c.client.B().Keys().Pattern(prefix+"*").Build()).AsStrSlice()
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	values, err := c.client.Do(ctx, c.client.B().Mget().Key(keys...).Build()).ToArray()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make(map[string][]byte, len(keys))
+	for i, v := range values {
+		if payload, err := v.ToString(); err == nil {
+			snapshots[strings.TrimPrefix(keys[i], prefix)] = []byte(payload)
+		}
+	}
+	return snapshots, nil
+}
+
+// callOwnerKey is the key of a call's entry in the call registry
+func callOwnerKey(callID string) string {
+	return fmt.Sprintf("calls:owner:%s", callID)
+}
+
+// SetCallOwners registers or refreshes the instance owning each call, by
+// Call-ID, kept for ttl unless refreshed
+func (c *Cache) SetCallOwners(ctx context.Context, owners map[string]*models.CallOwner, ttl time.Duration) error {
+	if len(owners) == 0 {
+		return nil
+	}
+	cmds := make([]valkey.Completed, 0, len(owners))
+	for callID, owner := range owners {
+		payload, err := json.Marshal(owner)
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, c.client.B().Set().Key(callOwnerKey(callID)).Value(string(payload)).Px(ttl).Build())
+	}
+	_, err := c.doMulti(ctx, cmds...)
+	return err
+}
+
+// GetCallOwner returns the instance owning a call, or nil when no instance
+// registered it
+func (c *Cache) GetCallOwner(ctx context.Context, callID string) (*models.CallOwner, error) {
+	payload, err := c.client.Do(ctx, c.client.B().Get().Key(callOwnerKey(callID)).Build()).ToString()
+	if valkey.IsValkeyNil(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var owner models.CallOwner
+	if err := json.Unmarshal([]byte(payload), &owner); err != nil {
+		return nil, fmt.Errorf("invalid call owner: %w", err)
+	}
+	return &owner, nil
+}
+
+// RemoveCallOwner removes a call from the call registry
+func (c *Cache) RemoveCallOwner(ctx context.Context, callID string) error {
+	return c.client.Do(ctx, c.client.B().Del().Key(callOwnerKey(callID)).Build()).Error()
+}
+
+// callEventChannel is the pub/sub channel of an account's call events
+func callEventChannel(accountID string) string {
+	return fmt.Sprintf("events:calls:%s", accountID)
+}
+
+// PublishCallEvent publishes a call event to every instance
+func (c *Cache) PublishCallEvent(ctx context.Context, accountID string, payload []byte) error {
+	return c.client.Do(ctx,
+		c.client.B().Publish().Channel(callEventChannel(accountID)).Message(string(payload)).Build(),
+	).Error()
+}
+
+// ReceiveCallEvents passes call events published by any instance to fn, with
+// their account, until ctx is cancelled or the subscription fails
+func (c *Cache) ReceiveCallEvents(ctx context.Context, fn func(accountID string, payload []byte)) error {
+	prefix := callEventChannel("")
+	return c.client.Receive(ctx, c.client.B().Psubscribe().Pattern(prefix+"*").Build(), func(msg valkey.PubSubMessage) {
+		fn(strings.TrimPrefix(msg.Channel, prefix), []byte(msg.Message))
+	})
+}
+
+// banKey is the key of a banned SIP source address
+func banKey(addr string) string {
+	return fmt.Sprintf("ban:sip:%s", addr)
+}
+
+// failuresKey is the key of a SIP source address's failed request count
+func failuresKey(addr string) string {
+	return fmt.Sprintf("failures:sip:%s", addr)
+}
+
+// SetBan bans a SIP source address for ttl, for every instance, and clears
+// its failure count
+func (c *Cache) SetBan(ctx context.Context, addr, reason string, ttl time.Duration) error {
+	_, err := c.doMulti(ctx,
+		c.client.B().Set().Key(banKey(addr)).Value(reason).Px(ttl).Build(),
+		c.client.B().Del().Key(failuresKey(addr)).Build(),
+	)
+	return err
+}
+
+// GetBan returns the ban of a SIP source address, or nil when it isn't
+// banned
+func (c *Cache) GetBan(ctx context.Context, addr string) (*models.Ban, error) {
+	key := banKey(addr)
+	results := c.client.DoMulti(ctx,
+		c.client.B().Get().Key(key).Build(),
+		c.client.B().Pttl().Key(key).Build(),
+	)
+	reason, err := results[0].ToString()
+	if valkey.IsValkeyNil(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := results[1].AsInt64()
+	if err != nil {
+		return nil, err
+	}
+	return &models.Ban{Address: addr, Reason: reason, ExpiresAt: time.Now().Add(time.Duration(ttl) * time.Millisecond)}, nil
+}
+
+// ListBans returns the banned SIP source addresses
+func (c *Cache) ListBans(ctx context.Context) ([]*models.Ban, error) {
+	prefix := banKey("")
+	keys, err := c.client.Do(ctx, c.client.B().Keys().Pattern(prefix+"*").Build()).AsStrSlice()
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	var bans []*models.Ban
+	for _, key := range keys {
+		ban, err := c.GetBan(ctx, strings.TrimPrefix(key, prefix))
+		if err != nil {
+			return nil, err
+		}
+		if ban != nil {
+			bans = append(bans, ban)
+		}
+	}
+	return bans, nil
+}
+
+// DeleteBan lifts the ban of a SIP source address, reporting whether it was
+// banned
+func (c *Cache) DeleteBan(ctx context.Context, addr string) (bool, error) {
+	results, err := c.doMulti(ctx,
+		c.client.B().Del().Key(banKey(addr)).Build(),
+		c.client.B().Del().Key(failuresKey(addr)).Build(),
+	)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := results[0].AsInt64()
+	return deleted > 0, err
+}
+
+// IncrFailures counts a failed request from a SIP source address, returning
+// its count within window, which starts at its first failure
+func (c *Cache) IncrFailures(ctx context.Context, addr string, window time.Duration) (int64, error) {
+	key := failuresKey(addr)
+	results, err := c.doMulti(ctx,
+		c.client.B().Incr().Key(key).Build(),
+		c.client.B().Pexpire().Key(key).Milliseconds(window.Milliseconds()).Nx().Build(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return results[0].AsInt64()
+}
+
+// callSlotsKey is the key of the calls in progress counted against a
+// concurrent call limit, such as an account's or a route's
+func callSlotsKey(scope string) string {
+	return fmt.Sprintf("calls:slots:%s", scope)
+}
+
+// claimCallSlot takes a slot for a call, scored by when it expires, unless
+// the unexpired slots of other calls already number the limit
+var claimCallSlot = valkey.NewLuaScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if not redis.call('ZSCORE', KEYS[1], ARGV[4]) and redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// ClaimCallSlot takes one of a concurrent call limit's slots for a call,
+// held for ttl unless refreshed. It reports false when every slot is taken.
+func (c *Cache) ClaimCallSlot(ctx context.Context, scope, callID string, limit int, ttl time.Duration) (bool, error) {
+	defer observeSetup(time.Now())
+	now := time.Now()
+	claimed, err := claimCallSlot.Exec(ctx, c.client, []string{callSlotsKey(scope)}, []string{
+		strconv.FormatInt(now.UnixMilli(), 10),
+		strconv.Itoa(limit),
+		strconv.FormatInt(now.Add(ttl).UnixMilli(), 10),
+		callID,
+		strconv.FormatInt(ttl.Milliseconds(), 10),
+	}).AsInt64()
+	return claimed == 1, err
+}
+
+// RefreshCallSlots holds the slots of calls still in progress, by scope, for
+// another ttl
+func (c *Cache) RefreshCallSlots(ctx context.Context, slots map[string][]string, ttl time.Duration) error {
+	if len(slots) == 0 {
+		return nil
+	}
+	expires := float64(time.Now().Add(ttl).UnixMilli())
+	cmds := make(valkey.Commands, 0, 2*len(slots))
+	for scope, callIDs := range slots {
+		key := callSlotsKey(scope)
+		zadd := c.client.B().Zadd().Key(key).Xx().ScoreMember()
+		for _, callID := range callIDs {
+			zadd = zadd.ScoreMember(expires, callID)
+		}
+		cmds = append(cmds, zadd.Build(), c.client.B().Pexpire().Key(key).Milliseconds(ttl.Milliseconds()).Build())
+	}
+	_, err := c.doMulti(ctx, cmds...)
+	return err
+}
+
+// ReleaseCallSlot frees the slot a call took
+func (c *Cache) ReleaseCallSlot(ctx context.Context, scope, callID string) error {
+	return c.client.Do(ctx, c.client.B().Zrem().Key(callSlotsKey(scope)).Member(callID).Build()).Error()
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// ClaimActive makes instance the active instance of its pair, unless another
+// one is active and its heartbeat is younger than staleAfter. force takes
+// over regardless. It returns the instance active before, if any, and
+// whether the claim succeeded.
+func (s *PostgresStore) ClaimActive(ctx context.Context, instance string, staleAfter time.Duration, force bool) (string, bool, error) {
+	var previous *string
+	var claimed bool
+	err := s.pool.QueryRow(ctx, `
+		WITH previous AS (
+			SELECT instance_id FROM failover_active WHERE id
+		), claimed AS (
+			INSERT INTO failover_active (id, instance_id, heartbeat_at, promoted_at)
+			VALUES (TRUE, $1, NOW(), NOW())
+			ON CONFLICT (id) DO UPDATE SET
+				instance_id = EXCLUDED.instance_id,
+				heartbeat_at = EXCLUDED.heartbeat_at,
+				promoted_at = EXCLUDED.promoted_at
+			WHERE failover_active.instance_id = $1
+			   OR failover_active.heartbeat_at < NOW() - make_interval(secs => $2)
+			   OR $3
+			RETURNING instance_id
+		)
+		SELECT (SELECT instance_id FROM previous), EXISTS (SELECT 1 FROM claimed)
+	`, instance, staleAfter.Seconds(), force).Scan(&previous, &claimed)
+	if err != nil {
+		return "", false, err
+	}
+	if previous == nil {
+		return "", claimed, nil
+	}
+	return *previous, claimed, nil
+}
+
+// Heartbeat records that instance, the active one, is alive. It reports
+// false when another instance has taken over, or instance released it.
+func (s *PostgresStore) Heartbeat(ctx context.Context, instance string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE failover_active SET heartbeat_at = NOW()
+		WHERE id AND instance_id = $1 AND heartbeat_at > 'epoch'
+	`, instance)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReleaseActive lets the standby take over from instance at once, as if its
+// heartbeat had stopped long ago
+func (s *PostgresStore) ReleaseActive(ctx context.Context, instance string) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE failover_active SET heartbeat_at = 'epoch'
+		WHERE id AND instance_id = $1
+	`, instance)
+	return err
+}
+
+// GetFailoverState returns the active instance of the pair, or nil before
+// any instance became active
+func (s *PostgresStore) GetFailoverState(ctx context.Context) (*models.FailoverState, error) {
+	var st models.FailoverState
+	err := s.pool.QueryRow(ctx, `
+		SELECT instance_id, heartbeat_at, promoted_at FROM failover_active WHERE id
+	`).Scan(&st.ActiveInstance, &st.HeartbeatAt, &st.PromotedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// SaveFailoverDialog persists the dialog of a call as its INVITE arrives
+func (s *PostgresStore) SaveFailoverDialog(ctx context.Context, d *models.FailoverDialog) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO failover_dialogs (call_id, instance_id, account_id, invite, source, transport)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (call_id) DO NOTHING
+	`, d.CallID, d.InstanceID, d.AccountID, d.Invite, d.Source, d.Transport)
+	return err
+}
+
+// SetFailoverDialogAnswer records the 2xx answering a call's INVITE and the
+// header rules applied to requests toward the caller
+func (s *PostgresStore) SetFailoverDialogAnswer(ctx context.Context, callID, answer string, egressRules []models.HeaderRule) error {
+	if egressRules == nil {
+		egressRules = []models.HeaderRule{}
+	}
+	_, err := s.pool.Exec(ctx, `
+		UPDATE failover_dialogs SET answer = $2, egress_rules = $3 WHERE call_id = $1
+	`, callID, answer, egressRules)
+	return err
+}
+
+// ListFailoverDialogs returns the persisted dialogs of every call
+func (s *PostgresStore) ListFailoverDialogs(ctx context.Context) ([]*models.FailoverDialog, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT call_id, instance_id, account_id, invite, answer, source, transport, egress_rules, created_at
+		FROM failover_dialogs
+		ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dialogs []*models.FailoverDialog
+	for rows.Next() {
+		var d models.FailoverDialog
+		if err := rows.Scan(
+			&d.CallID, &d.InstanceID, &d.AccountID, &d.Invite, &d.Answer,
+			&d.Source, &d.Transport, &d.EgressRules, &d.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		dialogs = append(dialogs, &d)
+	}
+	return dialogs, rows.Err()
+}
+
+// DeleteFailoverDialog forgets the dialog of a call that ended
+func (s *PostgresStore) DeleteFailoverDialog(ctx context.Context, callID string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM failover_dialogs WHERE call_id = $1`, callID)
+	return err
+}
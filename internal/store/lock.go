@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Lock is a session-level Postgres advisory lock, held on a connection of
+// its own for as long as the connection lives
+type Lock struct {
+	conn *pgxpool.Conn
+	key  int64
+}
+
+// TryLock takes the named advisory lock unless another session holds it, in
+// which case it returns nil. Locks are shared by every instance on the
+// database, so only one of them holds a name at a time.
+func (s *PostgresStore) TryLock(ctx context.Context, name string) (*Lock, error) {
+	h := fnv.New64a()
+	h.Write([]byte("blayzen-sip:" + name))
+	key := int64(h.Sum64())
+
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	if !locked {
+		conn.Release()
+		return nil, nil
+	}
+	return &Lock{conn: conn, key: key}, nil
+}
+
+// Check verifies the lock's connection, and so the lock, is still alive
+func (l *Lock) Check(ctx context.Context) error {
+	return l.conn.Ping(ctx)
+}
+
+// Release unlocks and returns the connection to the pool. A connection that
+// can't unlock is closed instead, which releases the lock too.
+func (l *Lock) Release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := l.conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, l.key); err != nil {
+		_ = l.conn.Conn().Close(ctx)
+	}
+	l.conn.Release()
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// ListMaintenanceWindows returns the maintenance windows that haven't
+// ended, on the routes of active accounts, by start
+func (s *PostgresStore) ListMaintenanceWindows(ctx context.Context) ([]*models.MaintenanceWindow, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, account_id, route_id, starts_at, ends_at, status_code, reason, divert_to, announcement, created_at
+		FROM route_maintenance_windows
+		WHERE ends_at > NOW()
+		  AND account_id IN (SELECT id FROM accounts WHERE active = true)
+		ORDER BY starts_at ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []*models.MaintenanceWindow
+	for rows.Next() {
+		var w models.MaintenanceWindow
+		err := rows.Scan(&w.ID, &w.AccountID, &w.RouteID, &w.StartsAt, &w.EndsAt, &w.StatusCode,
+			&w.Reason, &w.DivertTo, &w.Announcement, &w.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, &w)
+	}
+	return windows, rows.Err()
+}
+
+// ListRouteMaintenanceWindows returns the maintenance windows of a route,
+// latest first, including those that ended
+func (s *PostgresStore) ListRouteMaintenanceWindows(ctx context.Context, accountID, routeID string) ([]*models.MaintenanceWindow, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, account_id, route_id, starts_at, ends_at, status_code, reason, divert_to, announcement, created_at
+		FROM route_maintenance_windows
+		WHERE account_id = $1 AND route_id = $2
+		ORDER BY starts_at DESC
+	`, accountID, routeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []*models.MaintenanceWindow
+	for rows.Next() {
+		var w models.MaintenanceWindow
+		err := rows.Scan(&w.ID, &w.AccountID, &w.RouteID, &w.StartsAt, &w.EndsAt, &w.StatusCode,
+			&w.Reason, &w.DivertTo, &w.Announcement, &w.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, &w)
+	}
+	return windows, rows.Err()
+}
+
+// CreateMaintenanceWindow schedules a maintenance window on a route
+func (s *PostgresStore) CreateMaintenanceWindow(ctx context.Context, w *models.MaintenanceWindow) (*models.MaintenanceWindow, error) {
+	var created models.MaintenanceWindow
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO route_maintenance_windows (account_id, route_id, starts_at, ends_at, status_code, reason, divert_to, announcement)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, account_id, route_id, starts_at, ends_at, status_code, reason, divert_to, announcement, created_at
+	`, w.AccountID, w.RouteID, w.StartsAt, w.EndsAt, w.StatusCode, w.Reason, w.DivertTo, w.Announcement).Scan(
+		&created.ID, &created.AccountID, &created.RouteID, &created.StartsAt, &created.EndsAt, &created.StatusCode,
+		&created.Reason, &created.DivertTo, &created.Announcement, &created.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// DeleteMaintenanceWindow removes a route's maintenance window, ending it
+// early. It returns pgx.ErrNoRows when the route has no such window.
+func (s *PostgresStore) DeleteMaintenanceWindow(ctx context.Context, accountID, routeID, windowID string) error {
+	tag, err := s.pool.Exec(ctx, `
+		DELETE FROM route_maintenance_windows WHERE id = $1 AND account_id = $2 AND route_id = $3
+	`, windowID, accountID, routeID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// routeChangesChannel is the channel migration 045's triggers notify of
+// statements changing routes or trunks
+const routeChangesChannel = "route_changes"
+
+// ListenRouteChanges passes the table (sip_routes or sip_trunks) of every
+// change to routes or trunks, from any client of the database, to fn until
+// ctx is cancelled or the connection fails. It holds a connection of its
+// own, out of the pool, for as long as it listens.
+func (s *PostgresStore) ListenRouteChanges(ctx context.Context, fn func(table string)) error {
+	pooled, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := pooled.Hijack()
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = conn.Close(closeCtx)
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+routeChangesChannel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		fn(n.Payload)
+	}
+}
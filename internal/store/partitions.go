@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// partitionedTables are partitioned by month (see migration 016)
+var partitionedTables = []string{"call_logs", "sip_messages"}
+
+// partitionMaintenanceInterval is how often partitions are maintained
+const partitionMaintenanceInterval = 24 * time.Hour
+
+// MaintainPartitions creates the monthly partitions of the call log tables for
+// the current month and monthsAhead more, and drops partitions older than the
+// last retentionMonths full months (0 keeps everything)
+func (s *PostgresStore) MaintainPartitions(ctx context.Context, monthsAhead, retentionMonths int) error {
+	for _, table := range partitionedTables {
+		if _, err := s.pool.Exec(ctx, `
+			SELECT create_monthly_partitions($1, (NOW() AT TIME ZONE 'UTC')::DATE, $2)
+		`, table, monthsAhead+1); err != nil {
+			return fmt.Errorf("failed to create %s partitions: %w", table, err)
+		}
+
+		if retentionMonths <= 0 {
+			continue
+		}
+		var dropped int
+		if err := s.pool.QueryRow(ctx, `
+			SELECT drop_monthly_partitions($1, $2)
+		`, table, retentionMonths).Scan(&dropped); err != nil {
+			return fmt.Errorf("failed to drop old %s partitions: %w", table, err)
+		}
+		if dropped > 0 {
+			logger.Info("Dropped old partitions", "table", table, "count", dropped, "retention_months", retentionMonths)
+		}
+	}
+
+	// RTP traces aren't partitioned; they go with their month's SIP messages
+	if retentionMonths > 0 {
+		if _, err := s.pool.Exec(ctx, `
+			DELETE FROM rtp_traces
+			WHERE captured_at < (date_trunc('month', NOW() AT TIME ZONE 'UTC') - make_interval(months => $1)) AT TIME ZONE 'UTC'
+		`, retentionMonths); err != nil {
+			return fmt.Errorf("failed to delete old RTP traces: %w", err)
+		}
+	}
+	return nil
+}
+
+// RunPartitionMaintenance maintains partitions now and then daily until ctx
+// is cancelled
+func (s *PostgresStore) RunPartitionMaintenance(ctx context.Context, monthsAhead, retentionMonths int) {
+	ticker := time.NewTicker(partitionMaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.MaintainPartitions(ctx, monthsAhead, retentionMonths); err != nil {
+			logger.Error("Partition maintenance failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -8,12 +8,17 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shiv6146/blayzen-sip/internal/apikey"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
 	"github.com/shiv6146/blayzen-sip/internal/models"
 )
 
+var logger = logging.Component("store")
+
 // PostgresStore implements database operations
 type PostgresStore struct {
-	pool *pgxpool.Pool
+	pool    *pgxpool.Pool
+	replica *pgxpool.Pool // Optional, for reporting queries
 }
 
 // NewPostgresStore creates a new PostgreSQL store
@@ -38,6 +43,9 @@ func NewPostgresStore(ctx context.Context, databaseURL string) (*PostgresStore,
 
 // Close closes the connection pool
 func (s *PostgresStore) Close() {
+	if s.replica != nil {
+		s.replica.Close()
+	}
 	s.pool.Close()
 }
 
@@ -45,36 +53,218 @@ func (s *PostgresStore) Close() {
 // Account Operations
 // =============================================================================
 
-// ValidateAPIKey validates an API key and returns the account
-func (s *PostgresStore) ValidateAPIKey(ctx context.Context, accountID, apiKey string) (*models.Account, error) {
+// ValidateAPIKey validates an API key and returns its account and key.
+// Revoked and expired keys, and keys of deactivated accounts, are refused.
+// The key's last use is recorded, and a hash from before argon2id replaced.
+func (s *PostgresStore) ValidateAPIKey(ctx context.Context, accountID, apiKey string) (*models.Account, *models.APIKey, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT a.id, a.name, a.active, a.timezone, a.allowed_agent_urls, a.branding, a.max_concurrent_calls, a.calls_per_second, a.max_bandwidth_kbps, a.created_at, a.updated_at,
+		       k.id, k.account_id, k.name, k.prefix, k.scopes, k.created_at, k.expires_at, k.revoked_at, k.last_used_at,
+		       k.key_hash
+		FROM accounts a
+		JOIN api_keys k ON k.account_id = a.id
+		WHERE a.id = $1 AND k.prefix = $2 AND a.active = true
+		  AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW())
+	`, accountID, apikey.Prefix(apiKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var account models.Account
+	var key models.APIKey
+	var hash string
+	found := false
+	for rows.Next() {
+		err := rows.Scan(
+			&account.ID, &account.Name,
+			&account.Active, &account.Timezone, &account.AllowedAgentURLs, &account.Branding, &account.MaxConcurrentCalls, &account.CallsPerSecond, &account.MaxBandwidthKbps, &account.CreatedAt, &account.UpdatedAt,
+			&key.ID, &key.AccountID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.ExpiresAt, &key.RevokedAt, &key.LastUsedAt,
+			&hash,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		if apikey.Verify(apiKey, hash) {
+			found = true
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	rows.Close()
+	if !found {
+		return nil, nil, fmt.Errorf("invalid credentials")
+	}
+
+	var rehash *string
+	if apikey.NeedsRehash(hash) {
+		if h, err := apikey.Hash(apiKey); err == nil {
+			rehash = &h
+		}
+	}
+	if rehash != nil || key.LastUsedAt == nil || time.Since(*key.LastUsedAt) >= time.Minute {
+		_, err := s.pool.Exec(ctx, `
+			UPDATE api_keys SET last_used_at = NOW(), key_hash = COALESCE($2, key_hash)
+			WHERE id = $1
+		`, key.ID, rehash)
+		if err != nil {
+			logger.Warn("Failed to record API key use", "key_id", key.ID, "error", err)
+		}
+	}
+
+	return &account, &key, nil
+}
+
+// ListAccounts returns all accounts
+func (s *PostgresStore) ListAccounts(ctx context.Context) ([]*models.Account, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, name, active, timezone, allowed_agent_urls, branding, max_concurrent_calls, calls_per_second, max_bandwidth_kbps, created_at, updated_at
+		FROM accounts
+		ORDER BY created_at ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*models.Account
+	for rows.Next() {
+		var account models.Account
+		err := rows.Scan(
+			&account.ID, &account.Name,
+			&account.Active, &account.Timezone, &account.AllowedAgentURLs, &account.Branding, &account.MaxConcurrentCalls, &account.CallsPerSecond, &account.MaxBandwidthKbps, &account.CreatedAt, &account.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, &account)
+	}
+
+	return accounts, rows.Err()
+}
+
+// GetAccount returns an account by ID
+func (s *PostgresStore) GetAccount(ctx context.Context, id string) (*models.Account, error) {
 	var account models.Account
 	err := s.pool.QueryRow(ctx, `
-		SELECT id, name, api_key, active, created_at, updated_at
+		SELECT id, name, active, timezone, allowed_agent_urls, branding, max_concurrent_calls, calls_per_second, max_bandwidth_kbps, created_at, updated_at
 		FROM accounts
-		WHERE id = $1 AND api_key = $2 AND active = true
-	`, accountID, apiKey).Scan(
-		&account.ID, &account.Name, &account.APIKey,
-		&account.Active, &account.CreatedAt, &account.UpdatedAt,
+		WHERE id = $1
+	`, id).Scan(
+		&account.ID, &account.Name,
+		&account.Active, &account.Timezone, &account.AllowedAgentURLs, &account.Branding, &account.MaxConcurrentCalls, &account.CallsPerSecond, &account.MaxBandwidthKbps, &account.CreatedAt, &account.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// CreateAccount creates an account with its first API key
+func (s *PostgresStore) CreateAccount(ctx context.Context, account *models.Account, apiKey string) (*models.Account, *models.APIKey, error) {
+	allowedAgentURLs := account.AllowedAgentURLs
+	if allowedAgentURLs == nil {
+		allowedAgentURLs = []string{}
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var a models.Account
+	err = tx.QueryRow(ctx, `
+		INSERT INTO accounts (name, timezone, allowed_agent_urls, branding, max_concurrent_calls, calls_per_second, max_bandwidth_kbps)
+		VALUES ($1, COALESCE(NULLIF($2, ''), 'UTC'), $3, $4, $5, $6, $7)
+		RETURNING id, name, active, timezone, allowed_agent_urls, branding, max_concurrent_calls, calls_per_second, max_bandwidth_kbps, created_at, updated_at
+	`, account.Name, account.Timezone, allowedAgentURLs, account.Branding, account.MaxConcurrentCalls, account.CallsPerSecond, account.MaxBandwidthKbps).Scan(
+		&a.ID, &a.Name,
+		&a.Active, &a.Timezone, &a.AllowedAgentURLs, &a.Branding, &a.MaxConcurrentCalls, &a.CallsPerSecond, &a.MaxBandwidthKbps, &a.CreatedAt, &a.UpdatedAt,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := createAPIKey(ctx, tx, a.ID, "default", apiKey, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, err
+	}
+	return &a, key, nil
+}
+
+// CreateInitialAccount creates an account and its API key only if no
+// accounts exist yet. It returns nil without error when the database already
+// has accounts.
+func (s *PostgresStore) CreateInitialAccount(ctx context.Context, name, apiKey string) (*models.Account, error) {
+	hash, err := apikey.Hash(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var account models.Account
+	err = s.pool.QueryRow(ctx, `
+		WITH account AS (
+			INSERT INTO accounts (name)
+			SELECT $1
+			WHERE NOT EXISTS (SELECT 1 FROM accounts)
+			RETURNING id, name, active, timezone, allowed_agent_urls, branding, max_concurrent_calls, calls_per_second, max_bandwidth_kbps, created_at, updated_at
+		), key AS (
+			INSERT INTO api_keys (account_id, name, key_hash, prefix)
+			SELECT id, 'bootstrap', $2, $3 FROM account
+		)
+		SELECT id, name, active, timezone, allowed_agent_urls, branding, max_concurrent_calls, calls_per_second, max_bandwidth_kbps, created_at, updated_at FROM account
+	`, name, hash, apikey.Prefix(apiKey)).Scan(
+		&account.ID, &account.Name,
+		&account.Active, &account.Timezone, &account.AllowedAgentURLs, &account.Branding, &account.MaxConcurrentCalls, &account.CallsPerSecond, &account.MaxBandwidthKbps, &account.CreatedAt, &account.UpdatedAt,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("invalid credentials")
+			return nil, nil
 		}
 		return nil, err
 	}
 	return &account, nil
 }
 
-// GetAccount returns an account by ID
-func (s *PostgresStore) GetAccount(ctx context.Context, id string) (*models.Account, error) {
+// AccountUpdate holds account settings to change. Empty strings, a nil
+// allowlist, branding, call limit or Active keep the current values; a call
+// limit of 0 removes it.
+type AccountUpdate struct {
+	Name               string
+	Timezone           string
+	AllowedAgentURLs   []string
+	Branding           *models.Branding
+	MaxConcurrentCalls *int
+	CallsPerSecond     *int
+	MaxBandwidthKbps   *int
+	Active             *bool
+}
+
+// UpdateAccount updates an account's settings
+func (s *PostgresStore) UpdateAccount(ctx context.Context, id string, update AccountUpdate) (*models.Account, error) {
 	var account models.Account
 	err := s.pool.QueryRow(ctx, `
-		SELECT id, name, api_key, active, created_at, updated_at
-		FROM accounts
+		UPDATE accounts SET
+			name = COALESCE(NULLIF($2, ''), name),
+			timezone = COALESCE(NULLIF($3, ''), timezone),
+			allowed_agent_urls = COALESCE($4, allowed_agent_urls),
+			active = COALESCE($5, active),
+			branding = COALESCE($6, branding),
+			max_concurrent_calls = CASE WHEN $7::int IS NULL THEN max_concurrent_calls ELSE NULLIF($7, 0) END,
+			calls_per_second = CASE WHEN $8::int IS NULL THEN calls_per_second ELSE NULLIF($8, 0) END,
+			max_bandwidth_kbps = CASE WHEN $9::int IS NULL THEN max_bandwidth_kbps ELSE NULLIF($9, 0) END
 		WHERE id = $1
-	`, id).Scan(
-		&account.ID, &account.Name, &account.APIKey,
-		&account.Active, &account.CreatedAt, &account.UpdatedAt,
+		RETURNING id, name, active, timezone, allowed_agent_urls, branding, max_concurrent_calls, calls_per_second, max_bandwidth_kbps, created_at, updated_at
+	`, id, update.Name, update.Timezone, update.AllowedAgentURLs, update.Active, update.Branding, update.MaxConcurrentCalls, update.CallsPerSecond, update.MaxBandwidthKbps).Scan(
+		&account.ID, &account.Name,
+		&account.Active, &account.Timezone, &account.AllowedAgentURLs, &account.Branding, &account.MaxConcurrentCalls, &account.CallsPerSecond, &account.MaxBandwidthKbps, &account.CreatedAt, &account.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -82,6 +272,123 @@ func (s *PostgresStore) GetAccount(ctx context.Context, id string) (*models.Acco
 	return &account, nil
 }
 
+// =============================================================================
+// API Key Operations
+// =============================================================================
+
+// ListAPIKeys returns an account's API keys, including revoked and expired
+// ones. Keys themselves aren't stored, so only their prefixes are returned.
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, accountID string) ([]*models.APIKey, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, account_id, name, prefix, scopes, created_at, expires_at, revoked_at, last_used_at
+		FROM api_keys
+		WHERE account_id = $1
+		ORDER BY created_at ASC
+	`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		var k models.APIKey
+		err := rows.Scan(&k.ID, &k.AccountID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.RevokedAt, &k.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &k)
+	}
+
+	return keys, rows.Err()
+}
+
+// CreateAPIKey adds an API key to an account. Empty scopes give full
+// access, and expiresAt may be nil for a key that doesn't expire.
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, accountID, name, apiKey string, scopes []string, expiresAt *time.Time) (*models.APIKey, error) {
+	return createAPIKey(ctx, s.pool, accountID, name, apiKey, scopes, expiresAt)
+}
+
+// createAPIKey inserts an API key, in a transaction or not
+func createAPIKey(ctx context.Context, db interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}, accountID, name, apiKey string, scopes []string, expiresAt *time.Time) (*models.APIKey, error) {
+	if scopes == nil {
+		scopes = []string{}
+	}
+	hash, err := apikey.Hash(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var k models.APIKey
+	err = db.QueryRow(ctx, `
+		INSERT INTO api_keys (account_id, name, key_hash, prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, account_id, name, prefix, scopes, created_at, expires_at, revoked_at, last_used_at
+	`, accountID, name, hash, apikey.Prefix(apiKey), scopes, expiresAt).Scan(
+		&k.ID, &k.AccountID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.RevokedAt, &k.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	k.Key = apiKey
+	return &k, nil
+}
+
+// RotateAPIKey replaces a valid API key with a new one of the same name and
+// scopes. The
+// old key keeps working for the grace period, or is revoked right away when
+// grace is zero. It returns pgx.ErrNoRows when the key doesn't exist or is
+// no longer valid.
+func (s *PostgresStore) RotateAPIKey(ctx context.Context, accountID, keyID, newKey string, grace time.Duration) (*models.APIKey, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var name string
+	var scopes []string
+	err = tx.QueryRow(ctx, `
+		UPDATE api_keys SET
+			expires_at = CASE WHEN $3::int > 0 THEN LEAST(COALESCE(expires_at, 'infinity'), NOW() + make_interval(secs => $3::int)) ELSE expires_at END,
+			revoked_at = CASE WHEN $3::int > 0 THEN revoked_at ELSE NOW() END
+		WHERE id = $1 AND account_id = $2
+		  AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING name, scopes
+	`, keyID, accountID, int(grace/time.Second)).Scan(&name, &scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := createAPIKey(ctx, tx, accountID, name, newKey, scopes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RevokeAPIKey revokes an API key. It returns pgx.ErrNoRows when the key
+// doesn't exist or is already revoked.
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, accountID, keyID string) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = $1 AND account_id = $2 AND revoked_at IS NULL
+	`, keyID, accountID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
 // =============================================================================
 // Route Operations
 // =============================================================================
@@ -90,8 +397,9 @@ func (s *PostgresStore) GetAccount(ctx context.Context, id string) (*models.Acco
 func (s *PostgresStore) ListRoutes(ctx context.Context, accountID string) ([]*models.Route, error) {
 	rows, err := s.pool.Query(ctx, `
 		SELECT id, account_id, name, priority, 
-		       match_to_user, match_from_user, match_sip_header, match_sip_header_value,
-		       websocket_url, custom_data, active, created_at, updated_at
+		       match_to_user, match_from_user, match_to_user_type, match_from_user_type, match_sip_header, match_sip_header_value,
+		       match_headers, match_groups, websocket_url, agent_urls, agent_lb_strategy, fallback_websocket_urls, custom_data, audio_format, agent_protocol,
+		       call_priority, binary_audio, agent_auth, detect_human, record, header_rules, ringback, fax_policy, fax_target, language, early_media, connect_retry, max_concurrent_calls, max_bitrate_kbps, dtmf_shortcuts, schedule, agent_regions, agent_splits, split_strategy, active, created_at, updated_at
 		FROM sip_routes
 		WHERE account_id = $1
 		ORDER BY priority DESC, name ASC
@@ -106,8 +414,10 @@ func (s *PostgresStore) ListRoutes(ctx context.Context, accountID string) ([]*mo
 		var r models.Route
 		err := rows.Scan(
 			&r.ID, &r.AccountID, &r.Name, &r.Priority,
-			&r.MatchToUser, &r.MatchFromUser, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
-			&r.WebSocketURL, &r.CustomData, &r.Active, &r.CreatedAt, &r.UpdatedAt,
+			&r.MatchToUser, &r.MatchFromUser, &r.MatchToUserType, &r.MatchFromUserType, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
+			&r.MatchHeaders, &r.MatchGroups,
+			&r.WebSocketURL, &r.AgentURLs, &r.AgentLBStrategy, &r.FallbackWebSocketURLs, &r.CustomData, &r.AudioFormat, &r.AgentProtocol, &r.CallPriority,
+			&r.BinaryAudio, &r.AgentAuth, &r.DetectHuman, &r.Record, &r.HeaderRules, &r.Ringback, &r.FaxPolicy, &r.FaxTarget, &r.Language, &r.EarlyMedia, &r.ConnectRetry, &r.MaxConcurrentCalls, &r.MaxBitrateKbps, &r.DTMFShortcuts, &r.Schedule, &r.AgentRegions, &r.AgentSplits, &r.SplitStrategy, &r.Active, &r.CreatedAt, &r.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -123,14 +433,17 @@ func (s *PostgresStore) GetRoute(ctx context.Context, accountID, routeID string)
 	var r models.Route
 	err := s.pool.QueryRow(ctx, `
 		SELECT id, account_id, name, priority,
-		       match_to_user, match_from_user, match_sip_header, match_sip_header_value,
-		       websocket_url, custom_data, active, created_at, updated_at
+		       match_to_user, match_from_user, match_to_user_type, match_from_user_type, match_sip_header, match_sip_header_value,
+		       match_headers, match_groups, websocket_url, agent_urls, agent_lb_strategy, fallback_websocket_urls, custom_data, audio_format, agent_protocol,
+		       call_priority, binary_audio, agent_auth, detect_human, record, header_rules, ringback, fax_policy, fax_target, language, early_media, connect_retry, max_concurrent_calls, max_bitrate_kbps, dtmf_shortcuts, schedule, agent_regions, agent_splits, split_strategy, active, created_at, updated_at
 		FROM sip_routes
 		WHERE id = $1 AND account_id = $2
 	`, routeID, accountID).Scan(
 		&r.ID, &r.AccountID, &r.Name, &r.Priority,
-		&r.MatchToUser, &r.MatchFromUser, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
-		&r.WebSocketURL, &r.CustomData, &r.Active, &r.CreatedAt, &r.UpdatedAt,
+		&r.MatchToUser, &r.MatchFromUser, &r.MatchToUserType, &r.MatchFromUserType, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
+		&r.MatchHeaders, &r.MatchGroups,
+		&r.WebSocketURL, &r.AgentURLs, &r.AgentLBStrategy, &r.FallbackWebSocketURLs, &r.CustomData, &r.AudioFormat, &r.AgentProtocol, &r.CallPriority,
+		&r.BinaryAudio, &r.AgentAuth, &r.DetectHuman, &r.Record, &r.HeaderRules, &r.Ringback, &r.FaxPolicy, &r.FaxTarget, &r.Language, &r.EarlyMedia, &r.ConnectRetry, &r.MaxConcurrentCalls, &r.MaxBitrateKbps, &r.DTMFShortcuts, &r.Schedule, &r.AgentRegions, &r.AgentSplits, &r.SplitStrategy, &r.Active, &r.CreatedAt, &r.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -145,20 +458,65 @@ func (s *PostgresStore) CreateRoute(ctx context.Context, accountID string, route
 		customData = make(map[string]interface{})
 	}
 
+	matchHeaders := route.MatchHeaders
+	if matchHeaders == nil {
+		matchHeaders = []models.HeaderCondition{}
+	}
+
+	matchGroups := route.MatchGroups
+	if matchGroups == nil {
+		matchGroups = []models.MatchGroup{}
+	}
+
+	headerRules := route.HeaderRules
+	if headerRules == nil {
+		headerRules = []models.HeaderRule{}
+	}
+
+	agentURLs := route.AgentURLs
+	if agentURLs == nil {
+		agentURLs = []string{}
+	}
+
+	fallbackURLs := route.FallbackWebSocketURLs
+	if fallbackURLs == nil {
+		fallbackURLs = []string{}
+	}
+
+	agentRegions := route.AgentRegions
+	if agentRegions == nil {
+		agentRegions = []models.AgentRegionPool{}
+	}
+
+	agentSplits := route.AgentSplits
+	if agentSplits == nil {
+		agentSplits = []models.AgentSplit{}
+	}
+
 	var r models.Route
 	err := s.pool.QueryRow(ctx, `
 		INSERT INTO sip_routes (account_id, name, priority, match_to_user, match_from_user,
-		                        match_sip_header, match_sip_header_value, websocket_url, custom_data)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id, account_id, name, priority, match_to_user, match_from_user,
-		          match_sip_header, match_sip_header_value, websocket_url, custom_data,
-		          active, created_at, updated_at
+		                        match_sip_header, match_sip_header_value, websocket_url, custom_data,
+		                        match_headers, match_groups, audio_format, agent_protocol, call_priority, binary_audio, agent_auth, detect_human, record, header_rules,
+		                        fallback_websocket_urls, agent_urls, agent_lb_strategy, ringback, fax_policy, fax_target, language, early_media, connect_retry, max_concurrent_calls, dtmf_shortcuts, max_bitrate_kbps,
+		                        match_to_user_type, match_from_user_type, schedule, agent_regions, agent_splits, split_strategy)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20,
+		        $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35, $36, $37)
+		RETURNING id, account_id, name, priority, match_to_user, match_from_user, match_to_user_type, match_from_user_type,
+		          match_sip_header, match_sip_header_value, match_headers, match_groups,
+		          websocket_url, agent_urls, agent_lb_strategy, fallback_websocket_urls, custom_data, audio_format, agent_protocol, call_priority,
+		          binary_audio, agent_auth, detect_human, record, header_rules, ringback, fax_policy, fax_target, language, early_media, connect_retry, max_concurrent_calls, max_bitrate_kbps, dtmf_shortcuts, schedule, agent_regions, agent_splits, split_strategy, active, created_at, updated_at
 	`, accountID, route.Name, route.Priority, route.MatchToUser, route.MatchFromUser,
 		route.MatchSIPHeader, route.MatchSIPHeaderValue, route.WebSocketURL, customData,
+		matchHeaders, matchGroups, route.AudioFormat, route.AgentProtocol, route.CallPriority, route.BinaryAudio, route.AgentAuth, route.DetectHuman, route.Record, headerRules,
+		fallbackURLs, agentURLs, route.AgentLBStrategy, route.Ringback, route.FaxPolicy, route.FaxTarget, route.Language, route.EarlyMedia, route.ConnectRetry, route.MaxConcurrentCalls, route.DTMFShortcuts, route.MaxBitrateKbps,
+		route.MatchToUserType, route.MatchFromUserType, route.Schedule, agentRegions, agentSplits, route.SplitStrategy,
 	).Scan(
 		&r.ID, &r.AccountID, &r.Name, &r.Priority,
-		&r.MatchToUser, &r.MatchFromUser, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
-		&r.WebSocketURL, &r.CustomData, &r.Active, &r.CreatedAt, &r.UpdatedAt,
+		&r.MatchToUser, &r.MatchFromUser, &r.MatchToUserType, &r.MatchFromUserType, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
+		&r.MatchHeaders, &r.MatchGroups,
+		&r.WebSocketURL, &r.AgentURLs, &r.AgentLBStrategy, &r.FallbackWebSocketURLs, &r.CustomData, &r.AudioFormat, &r.AgentProtocol, &r.CallPriority,
+		&r.BinaryAudio, &r.AgentAuth, &r.DetectHuman, &r.Record, &r.HeaderRules, &r.Ringback, &r.FaxPolicy, &r.FaxTarget, &r.Language, &r.EarlyMedia, &r.ConnectRetry, &r.MaxConcurrentCalls, &r.MaxBitrateKbps, &r.DTMFShortcuts, &r.Schedule, &r.AgentRegions, &r.AgentSplits, &r.SplitStrategy, &r.Active, &r.CreatedAt, &r.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -173,22 +531,69 @@ func (s *PostgresStore) UpdateRoute(ctx context.Context, accountID string, route
 		customData = make(map[string]interface{})
 	}
 
+	matchHeaders := route.MatchHeaders
+	if matchHeaders == nil {
+		matchHeaders = []models.HeaderCondition{}
+	}
+
+	matchGroups := route.MatchGroups
+	if matchGroups == nil {
+		matchGroups = []models.MatchGroup{}
+	}
+
+	headerRules := route.HeaderRules
+	if headerRules == nil {
+		headerRules = []models.HeaderRule{}
+	}
+
+	agentURLs := route.AgentURLs
+	if agentURLs == nil {
+		agentURLs = []string{}
+	}
+
+	fallbackURLs := route.FallbackWebSocketURLs
+	if fallbackURLs == nil {
+		fallbackURLs = []string{}
+	}
+
+	agentRegions := route.AgentRegions
+	if agentRegions == nil {
+		agentRegions = []models.AgentRegionPool{}
+	}
+
+	agentSplits := route.AgentSplits
+	if agentSplits == nil {
+		agentSplits = []models.AgentSplit{}
+	}
+
 	var r models.Route
 	err := s.pool.QueryRow(ctx, `
 		UPDATE sip_routes
 		SET name = $3, priority = $4, match_to_user = $5, match_from_user = $6,
 		    match_sip_header = $7, match_sip_header_value = $8, websocket_url = $9,
-		    custom_data = $10, active = $11
+		    custom_data = $10, match_headers = $11,
+		    match_groups = $12, audio_format = $13, agent_protocol = $14,
+		    call_priority = $15, binary_audio = $16, agent_auth = $17, record = $18, header_rules = $19, active = $20,
+		    fallback_websocket_urls = $21, detect_human = $22, agent_urls = $23, agent_lb_strategy = $24,
+		    ringback = $25, fax_policy = $26, fax_target = $27, language = $28, early_media = $29, connect_retry = $30, max_concurrent_calls = $31, dtmf_shortcuts = $32, max_bitrate_kbps = $33,
+		    match_to_user_type = $34, match_from_user_type = $35, schedule = $36, agent_regions = $37,
+		    agent_splits = $38, split_strategy = $39
 		WHERE id = $1 AND account_id = $2
-		RETURNING id, account_id, name, priority, match_to_user, match_from_user,
-		          match_sip_header, match_sip_header_value, websocket_url, custom_data,
-		          active, created_at, updated_at
+		RETURNING id, account_id, name, priority, match_to_user, match_from_user, match_to_user_type, match_from_user_type,
+		          match_sip_header, match_sip_header_value, match_headers, match_groups,
+		          websocket_url, agent_urls, agent_lb_strategy, fallback_websocket_urls, custom_data, audio_format, agent_protocol, call_priority,
+		          binary_audio, agent_auth, detect_human, record, header_rules, ringback, fax_policy, fax_target, language, early_media, connect_retry, max_concurrent_calls, max_bitrate_kbps, dtmf_shortcuts, schedule, agent_regions, agent_splits, split_strategy, active, created_at, updated_at
 	`, route.ID, accountID, route.Name, route.Priority, route.MatchToUser, route.MatchFromUser,
-		route.MatchSIPHeader, route.MatchSIPHeaderValue, route.WebSocketURL, customData, route.Active,
+		route.MatchSIPHeader, route.MatchSIPHeaderValue, route.WebSocketURL, customData, matchHeaders, matchGroups,
+		route.AudioFormat, route.AgentProtocol, route.CallPriority, route.BinaryAudio, route.AgentAuth, route.Record, headerRules, route.Active,
+		fallbackURLs, route.DetectHuman, agentURLs, route.AgentLBStrategy, route.Ringback, route.FaxPolicy, route.FaxTarget, route.Language, route.EarlyMedia, route.ConnectRetry, route.MaxConcurrentCalls, route.DTMFShortcuts, route.MaxBitrateKbps,
+		route.MatchToUserType, route.MatchFromUserType, route.Schedule, agentRegions, agentSplits, route.SplitStrategy,
 	).Scan(
 		&r.ID, &r.AccountID, &r.Name, &r.Priority,
-		&r.MatchToUser, &r.MatchFromUser, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
-		&r.WebSocketURL, &r.CustomData, &r.Active, &r.CreatedAt, &r.UpdatedAt,
+		&r.MatchToUser, &r.MatchFromUser, &r.MatchToUserType, &r.MatchFromUserType, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
+		&r.MatchHeaders, &r.MatchGroups,
+		&r.WebSocketURL, &r.AgentURLs, &r.AgentLBStrategy, &r.FallbackWebSocketURLs, &r.CustomData, &r.AudioFormat, &r.AgentProtocol, &r.CallPriority,
+		&r.BinaryAudio, &r.AgentAuth, &r.DetectHuman, &r.Record, &r.HeaderRules, &r.Ringback, &r.FaxPolicy, &r.FaxTarget, &r.Language, &r.EarlyMedia, &r.ConnectRetry, &r.MaxConcurrentCalls, &r.MaxBitrateKbps, &r.DTMFShortcuts, &r.Schedule, &r.AgentRegions, &r.AgentSplits, &r.SplitStrategy, &r.Active, &r.CreatedAt, &r.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -204,18 +609,23 @@ func (s *PostgresStore) DeleteRoute(ctx context.Context, accountID, routeID stri
 	return err
 }
 
-// FindMatchingRoutes finds routes that could match the given criteria
-func (s *PostgresStore) FindMatchingRoutes(ctx context.Context, toUser, fromUser string) ([]*models.Route, error) {
+// FindMatchingRoutes finds routes that could match the given criteria,
+// among an account's routes, or every account's when accountID is "". Only
+// exact number criteria are compared here; Route.Matches checks the others.
+func (s *PostgresStore) FindMatchingRoutes(ctx context.Context, accountID, toUser, fromUser string) ([]*models.Route, error) {
 	rows, err := s.pool.Query(ctx, `
 		SELECT id, account_id, name, priority,
-		       match_to_user, match_from_user, match_sip_header, match_sip_header_value,
-		       websocket_url, custom_data, active, created_at, updated_at
+		       match_to_user, match_from_user, match_to_user_type, match_from_user_type, match_sip_header, match_sip_header_value,
+		       match_headers, match_groups, websocket_url, agent_urls, agent_lb_strategy, fallback_websocket_urls, custom_data, audio_format, agent_protocol,
+		       call_priority, binary_audio, agent_auth, detect_human, record, header_rules, ringback, fax_policy, fax_target, language, early_media, connect_retry, max_concurrent_calls, max_bitrate_kbps, dtmf_shortcuts, schedule, agent_regions, agent_splits, split_strategy, active, created_at, updated_at
 		FROM sip_routes
 		WHERE active = true
-		  AND (match_to_user IS NULL OR match_to_user = '' OR match_to_user = $1)
-		  AND (match_from_user IS NULL OR match_from_user = '' OR match_from_user = $2)
+		  AND account_id IN (SELECT id FROM accounts WHERE active = true)
+		  AND (match_to_user IS NULL OR match_to_user = '' OR match_to_user_type <> 'exact' OR match_to_user = $1)
+		  AND (match_from_user IS NULL OR match_from_user = '' OR match_from_user_type <> 'exact' OR match_from_user = $2)
+		  AND ($3 = '' OR account_id::text = $3)
 		ORDER BY priority DESC
-	`, toUser, fromUser)
+	`, toUser, fromUser, accountID)
 	if err != nil {
 		return nil, err
 	}
@@ -226,8 +636,10 @@ func (s *PostgresStore) FindMatchingRoutes(ctx context.Context, toUser, fromUser
 		var r models.Route
 		err := rows.Scan(
 			&r.ID, &r.AccountID, &r.Name, &r.Priority,
-			&r.MatchToUser, &r.MatchFromUser, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
-			&r.WebSocketURL, &r.CustomData, &r.Active, &r.CreatedAt, &r.UpdatedAt,
+			&r.MatchToUser, &r.MatchFromUser, &r.MatchToUserType, &r.MatchFromUserType, &r.MatchSIPHeader, &r.MatchSIPHeaderValue,
+			&r.MatchHeaders, &r.MatchGroups,
+			&r.WebSocketURL, &r.AgentURLs, &r.AgentLBStrategy, &r.FallbackWebSocketURLs, &r.CustomData, &r.AudioFormat, &r.AgentProtocol, &r.CallPriority,
+			&r.BinaryAudio, &r.AgentAuth, &r.DetectHuman, &r.Record, &r.HeaderRules, &r.Ringback, &r.FaxPolicy, &r.FaxTarget, &r.Language, &r.EarlyMedia, &r.ConnectRetry, &r.MaxConcurrentCalls, &r.MaxBitrateKbps, &r.DTMFShortcuts, &r.Schedule, &r.AgentRegions, &r.AgentSplits, &r.SplitStrategy, &r.Active, &r.CreatedAt, &r.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -247,7 +659,7 @@ func (s *PostgresStore) ListTrunks(ctx context.Context, accountID string) ([]*mo
 	rows, err := s.pool.Query(ctx, `
 		SELECT id, account_id, name, host, port, transport,
 		       username, password, from_user, from_host,
-		       register, register_interval, active, created_at, updated_at
+		       register, register_interval, outbound_proxy, header_rules, symmetric_rtp, max_bitrate_kbps, active, created_at, updated_at
 		FROM sip_trunks
 		WHERE account_id = $1
 		ORDER BY name ASC
@@ -263,7 +675,7 @@ func (s *PostgresStore) ListTrunks(ctx context.Context, accountID string) ([]*mo
 		err := rows.Scan(
 			&t.ID, &t.AccountID, &t.Name, &t.Host, &t.Port, &t.Transport,
 			&t.Username, &t.Password, &t.FromUser, &t.FromHost,
-			&t.Register, &t.RegisterInterval, &t.Active, &t.CreatedAt, &t.UpdatedAt,
+			&t.Register, &t.RegisterInterval, &t.OutboundProxy, &t.HeaderRules, &t.SymmetricRTP, &t.MaxBitrateKbps, &t.Active, &t.CreatedAt, &t.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -280,13 +692,13 @@ func (s *PostgresStore) GetTrunk(ctx context.Context, accountID, trunkID string)
 	err := s.pool.QueryRow(ctx, `
 		SELECT id, account_id, name, host, port, transport,
 		       username, password, from_user, from_host,
-		       register, register_interval, active, created_at, updated_at
+		       register, register_interval, outbound_proxy, header_rules, symmetric_rtp, max_bitrate_kbps, active, created_at, updated_at
 		FROM sip_trunks
 		WHERE id = $1 AND account_id = $2
 	`, trunkID, accountID).Scan(
 		&t.ID, &t.AccountID, &t.Name, &t.Host, &t.Port, &t.Transport,
 		&t.Username, &t.Password, &t.FromUser, &t.FromHost,
-		&t.Register, &t.RegisterInterval, &t.Active, &t.CreatedAt, &t.UpdatedAt,
+		&t.Register, &t.RegisterInterval, &t.OutboundProxy, &t.HeaderRules, &t.SymmetricRTP, &t.MaxBitrateKbps, &t.Active, &t.CreatedAt, &t.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -296,22 +708,27 @@ func (s *PostgresStore) GetTrunk(ctx context.Context, accountID, trunkID string)
 
 // CreateTrunk creates a new trunk
 func (s *PostgresStore) CreateTrunk(ctx context.Context, accountID string, trunk *models.Trunk) (*models.Trunk, error) {
+	headerRules := trunk.HeaderRules
+	if headerRules == nil {
+		headerRules = []models.HeaderRule{}
+	}
+
 	var t models.Trunk
 	err := s.pool.QueryRow(ctx, `
 		INSERT INTO sip_trunks (account_id, name, host, port, transport,
 		                        username, password, from_user, from_host,
-		                        register, register_interval)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		                        register, register_interval, outbound_proxy, header_rules, symmetric_rtp, max_bitrate_kbps)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 		RETURNING id, account_id, name, host, port, transport,
 		          username, password, from_user, from_host,
-		          register, register_interval, active, created_at, updated_at
+		          register, register_interval, outbound_proxy, header_rules, symmetric_rtp, max_bitrate_kbps, active, created_at, updated_at
 	`, accountID, trunk.Name, trunk.Host, trunk.Port, trunk.Transport,
 		trunk.Username, trunk.Password, trunk.FromUser, trunk.FromHost,
-		trunk.Register, trunk.RegisterInterval,
+		trunk.Register, trunk.RegisterInterval, trunk.OutboundProxy, headerRules, trunk.SymmetricRTP, trunk.MaxBitrateKbps,
 	).Scan(
 		&t.ID, &t.AccountID, &t.Name, &t.Host, &t.Port, &t.Transport,
 		&t.Username, &t.Password, &t.FromUser, &t.FromHost,
-		&t.Register, &t.RegisterInterval, &t.Active, &t.CreatedAt, &t.UpdatedAt,
+		&t.Register, &t.RegisterInterval, &t.OutboundProxy, &t.HeaderRules, &t.SymmetricRTP, &t.MaxBitrateKbps, &t.Active, &t.CreatedAt, &t.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -321,23 +738,75 @@ func (s *PostgresStore) CreateTrunk(ctx context.Context, accountID string, trunk
 
 // UpdateTrunk updates a trunk
 func (s *PostgresStore) UpdateTrunk(ctx context.Context, accountID string, trunk *models.Trunk) (*models.Trunk, error) {
+	headerRules := trunk.HeaderRules
+	if headerRules == nil {
+		headerRules = []models.HeaderRule{}
+	}
+
 	var t models.Trunk
 	err := s.pool.QueryRow(ctx, `
 		UPDATE sip_trunks
 		SET name = $3, host = $4, port = $5, transport = $6,
 		    username = $7, password = $8, from_user = $9, from_host = $10,
-		    register = $11, register_interval = $12, active = $13
+		    register = $11, register_interval = $12, outbound_proxy = $13, header_rules = $14,
+		    symmetric_rtp = $15, active = $16, max_bitrate_kbps = $17
 		WHERE id = $1 AND account_id = $2
 		RETURNING id, account_id, name, host, port, transport,
 		          username, password, from_user, from_host,
-		          register, register_interval, active, created_at, updated_at
+		          register, register_interval, outbound_proxy, header_rules, symmetric_rtp, max_bitrate_kbps, active, created_at, updated_at
 	`, trunk.ID, accountID, trunk.Name, trunk.Host, trunk.Port, trunk.Transport,
 		trunk.Username, trunk.Password, trunk.FromUser, trunk.FromHost,
-		trunk.Register, trunk.RegisterInterval, trunk.Active,
+		trunk.Register, trunk.RegisterInterval, trunk.OutboundProxy, headerRules, trunk.SymmetricRTP, trunk.Active, trunk.MaxBitrateKbps,
 	).Scan(
 		&t.ID, &t.AccountID, &t.Name, &t.Host, &t.Port, &t.Transport,
 		&t.Username, &t.Password, &t.FromUser, &t.FromHost,
-		&t.Register, &t.RegisterInterval, &t.Active, &t.CreatedAt, &t.UpdatedAt,
+		&t.Register, &t.RegisterInterval, &t.OutboundProxy, &t.HeaderRules, &t.SymmetricRTP, &t.MaxBitrateKbps, &t.Active, &t.CreatedAt, &t.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// FindTrunkByHost returns the active trunk whose host matches the source of
+// an inbound request
+func (s *PostgresStore) FindTrunkByHost(ctx context.Context, host string) (*models.Trunk, error) {
+	var t models.Trunk
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, account_id, name, host, port, transport,
+		       username, password, from_user, from_host,
+		       register, register_interval, outbound_proxy, header_rules, symmetric_rtp, max_bitrate_kbps, active, created_at, updated_at
+		FROM sip_trunks
+		WHERE active = true AND trim(both '[]' from host) = $1
+		  AND account_id IN (SELECT id FROM accounts WHERE active = true)
+		ORDER BY created_at ASC
+		LIMIT 1
+	`, host).Scan(
+		&t.ID, &t.AccountID, &t.Name, &t.Host, &t.Port, &t.Transport,
+		&t.Username, &t.Password, &t.FromUser, &t.FromHost,
+		&t.Register, &t.RegisterInterval, &t.OutboundProxy, &t.HeaderRules, &t.SymmetricRTP, &t.MaxBitrateKbps, &t.Active, &t.CreatedAt, &t.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// FindTrunkByID returns an active trunk of an active account, e.g. the one
+// an access control list entry matching a request's source belongs to
+func (s *PostgresStore) FindTrunkByID(ctx context.Context, trunkID string) (*models.Trunk, error) {
+	var t models.Trunk
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, account_id, name, host, port, transport,
+		       username, password, from_user, from_host,
+		       register, register_interval, outbound_proxy, header_rules, symmetric_rtp, max_bitrate_kbps, active, created_at, updated_at
+		FROM sip_trunks
+		WHERE active = true AND id = $1
+		  AND account_id IN (SELECT id FROM accounts WHERE active = true)
+	`, trunkID).Scan(
+		&t.ID, &t.AccountID, &t.Name, &t.Host, &t.Port, &t.Transport,
+		&t.Username, &t.Password, &t.FromUser, &t.FromHost,
+		&t.Register, &t.RegisterInterval, &t.OutboundProxy, &t.HeaderRules, &t.SymmetricRTP, &t.MaxBitrateKbps, &t.Active, &t.CreatedAt, &t.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -353,6 +822,114 @@ func (s *PostgresStore) DeleteTrunk(ctx context.Context, accountID, trunkID stri
 	return err
 }
 
+// ListRegisteringTrunks returns the active trunks of active accounts that
+// register with their provider
+func (s *PostgresStore) ListRegisteringTrunks(ctx context.Context) ([]*models.Trunk, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, account_id, name, host, port, transport,
+		       username, password, from_user, from_host,
+		       register, register_interval, outbound_proxy, header_rules, symmetric_rtp, max_bitrate_kbps, active, created_at, updated_at
+		FROM sip_trunks
+		WHERE register = true AND active = true
+		  AND account_id IN (SELECT id FROM accounts WHERE active = true)
+		ORDER BY created_at ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trunks []*models.Trunk
+	for rows.Next() {
+		var t models.Trunk
+		err := rows.Scan(
+			&t.ID, &t.AccountID, &t.Name, &t.Host, &t.Port, &t.Transport,
+			&t.Username, &t.Password, &t.FromUser, &t.FromHost,
+			&t.Register, &t.RegisterInterval, &t.OutboundProxy, &t.HeaderRules, &t.SymmetricRTP, &t.MaxBitrateKbps, &t.Active, &t.CreatedAt, &t.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		trunks = append(trunks, &t)
+	}
+
+	return trunks, rows.Err()
+}
+
+// RecordTrunkResponse counts a final SIP response exchanged with a trunk in
+// the current hour
+func (s *PostgresStore) RecordTrunkResponse(ctx context.Context, trunkID string, direction models.CallDirection, method string, statusCode int) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO trunk_response_stats (trunk_id, hour, direction, method, status_code, count)
+		VALUES ($1, date_trunc('hour', NOW()), $2, $3, $4, 1)
+		ON CONFLICT (trunk_id, hour, direction, method, status_code)
+		DO UPDATE SET count = trunk_response_stats.count + 1
+	`, trunkID, direction, method, statusCode)
+	return err
+}
+
+// GetTrunkResponseStats totals a trunk's final SIP responses by direction,
+// method and status code, optionally within [from, to). Responses are
+// counted by the hour, so the bounds are rounded down to the hour.
+func (s *PostgresStore) GetTrunkResponseStats(ctx context.Context, trunkID string, from, to *time.Time) ([]*models.TrunkResponseStat, error) {
+	rows, err := s.reportQuery(ctx, `
+		SELECT direction, method, status_code, SUM(count)
+		FROM trunk_response_stats
+		WHERE trunk_id = $1
+		  AND ($2::TIMESTAMPTZ IS NULL OR hour >= date_trunc('hour', $2::TIMESTAMPTZ))
+		  AND ($3::TIMESTAMPTZ IS NULL OR hour < date_trunc('hour', $3::TIMESTAMPTZ))
+		GROUP BY direction, method, status_code
+		ORDER BY direction, method, status_code
+	`, trunkID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*models.TrunkResponseStat
+	for rows.Next() {
+		var st models.TrunkResponseStat
+		if err := rows.Scan(&st.Direction, &st.Method, &st.StatusCode, &st.Count); err != nil {
+			return nil, err
+		}
+		stats = append(stats, &st)
+	}
+
+	return stats, rows.Err()
+}
+
+// GetRouteSplitStats summarises the calls assigned each of a route's agent
+// splits, optionally within [from, to)
+func (s *PostgresStore) GetRouteSplitStats(ctx context.Context, accountID, routeID string, from, to *time.Time) ([]*models.SplitStat, error) {
+	rows, err := s.reportQuery(ctx, `
+		SELECT split_target, COUNT(*),
+		       COUNT(*) FILTER (WHERE answered_at IS NOT NULL),
+		       COUNT(*) FILTER (WHERE status = 'failed'),
+		       COALESCE(AVG(duration_seconds) FILTER (WHERE answered_at IS NOT NULL), 0)::FLOAT8
+		FROM call_logs
+		WHERE account_id = $1 AND route_id = $2 AND split_target IS NOT NULL
+		  AND ($3::TIMESTAMPTZ IS NULL OR created_at >= $3)
+		  AND ($4::TIMESTAMPTZ IS NULL OR created_at < $4)
+		GROUP BY split_target
+		ORDER BY split_target
+	`, accountID, routeID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*models.SplitStat
+	for rows.Next() {
+		var st models.SplitStat
+		if err := rows.Scan(&st.Target, &st.Calls, &st.Answered, &st.Failed, &st.AvgDurationSeconds); err != nil {
+			return nil, err
+		}
+		stats = append(stats, &st)
+	}
+
+	return stats, rows.Err()
+}
+
 // =============================================================================
 // Call Log Operations
 // =============================================================================
@@ -368,18 +945,22 @@ func (s *PostgresStore) CreateCallLog(ctx context.Context, call *models.CallLog)
 	err := s.pool.QueryRow(ctx, `
 		INSERT INTO call_logs (account_id, call_id, direction, from_uri, to_uri,
 		                       from_user, to_user, route_id, trunk_id, websocket_url,
-		                       status, custom_data)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		                       call_priority, status, custom_data,
+		                       from_user_encrypted, to_user_encrypted, offered_media, instance_id, conversation_id,
+		                       agent_region, region_decision, split_target)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
 		RETURNING id, account_id, call_id, direction, from_uri, to_uri,
 		          from_user, to_user, route_id, trunk_id, websocket_url,
-		          status, initiated_at, created_at
+		          call_priority, status, offered_media, instance_id, conversation_id, agent_region, region_decision, split_target, initiated_at, created_at
 	`, call.AccountID, call.CallID, call.Direction, call.FromURI, call.ToURI,
 		call.FromUser, call.ToUser, call.RouteID, call.TrunkID, call.WebSocketURL,
-		call.Status, customData,
+		call.CallPriority, call.Status, customData,
+		call.FromUserEncrypted, call.ToUserEncrypted, call.OfferedMedia, call.InstanceID, call.ConversationID,
+		call.AgentRegion, call.RegionDecision, call.SplitTarget,
 	).Scan(
 		&c.ID, &c.AccountID, &c.CallID, &c.Direction, &c.FromURI, &c.ToURI,
 		&c.FromUser, &c.ToUser, &c.RouteID, &c.TrunkID, &c.WebSocketURL,
-		&c.Status, &c.InitiatedAt, &c.CreatedAt,
+		&c.CallPriority, &c.Status, &c.OfferedMedia, &c.InstanceID, &c.ConversationID, &c.AgentRegion, &c.RegionDecision, &c.SplitTarget, &c.InitiatedAt, &c.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -400,7 +981,7 @@ func (s *PostgresStore) UpdateCallStatus(ctx context.Context, callID string, sta
 	case models.CallStatusAnswered:
 		query = `UPDATE call_logs SET status = $1, answered_at = $2 WHERE call_id = $3`
 		args = []interface{}{status, now, callID}
-	case models.CallStatusCompleted, models.CallStatusFailed, models.CallStatusCancelled:
+	case models.CallStatusCompleted, models.CallStatusFailed, models.CallStatusCancelled, models.CallStatusPreempted:
 		query = `
 			UPDATE call_logs 
 			SET status = $1, ended_at = $2, 
@@ -416,24 +997,263 @@ func (s *PostgresStore) UpdateCallStatus(ctx context.Context, callID string, sta
 	return err
 }
 
-// ListCalls returns recent calls for an account
-func (s *PostgresStore) ListCalls(ctx context.Context, accountID string, limit int) ([]*models.CallLog, error) {
-	if limit <= 0 {
-		limit = 100
+// ListUnfinishedCalls returns calls still recorded in progress (initiated,
+// ringing or answered) that started before a time, oldest first from after
+// the call after (nil for the first page), with their ID, Call-ID, account,
+// instance, status, start and answer time
+func (s *PostgresStore) ListUnfinishedCalls(ctx context.Context, before time.Time, after *models.CallLog, limit int) ([]*models.CallLog, error) {
+	query := `
+		SELECT id, call_id, account_id, instance_id, status, initiated_at, answered_at
+		FROM call_logs
+		WHERE status IN ('initiated', 'ringing', 'answered')
+		  AND initiated_at < $1`
+	args := []interface{}{before, limit}
+	if after != nil {
+		query += ` AND (initiated_at, id) > ($3, $4)`
+		args = append(args, after.InitiatedAt, after.ID)
 	}
+	query += ` ORDER BY initiated_at, id LIMIT $2`
 
-	rows, err := s.pool.Query(ctx, `
-		SELECT id, account_id, call_id, direction, from_uri, to_uri,
-		       from_user, to_user, route_id, trunk_id, websocket_url,
-		       status, initiated_at, ringing_at, answered_at, ended_at,
-		       duration_seconds, hangup_cause, hangup_party, custom_data, created_at
-		FROM call_logs
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var calls []*models.CallLog
+	for rows.Next() {
+		var c models.CallLog
+		if err := rows.Scan(&c.ID, &c.CallID, &c.AccountID, &c.InstanceID, &c.Status, &c.InitiatedAt, &c.AnsweredAt); err != nil {
+			return nil, err
+		}
+		calls = append(calls, &c)
+	}
+	return calls, rows.Err()
+}
+
+// EndUnfinishedCall records a call still in progress as ended now, by the
+// system with a hangup cause: completed when it was answered, else failed.
+// It reports false, changing nothing, once the call has ended.
+func (s *PostgresStore) EndUnfinishedCall(ctx context.Context, callID, cause string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE call_logs
+		SET status = CASE WHEN answered_at IS NULL THEN 'failed' ELSE 'completed' END,
+		    ended_at = NOW(),
+		    duration_seconds = EXTRACT(EPOCH FROM (NOW() - COALESCE(answered_at, initiated_at)))::INT,
+		    hangup_cause = $2, hangup_party = $3
+		WHERE call_id = $1 AND status IN ('initiated', 'ringing', 'answered')
+	`, callID, cause, models.HangupPartySystem)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// CountActiveCalls returns how many of an account's calls are in progress
+// (initiated, ringing or answered) on any instance. Calls left unfinished by
+// a crash for over a day aren't counted.
+func (s *PostgresStore) CountActiveCalls(ctx context.Context, accountID string) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM call_logs
 		WHERE account_id = $1
+		  AND status IN ('initiated', 'ringing', 'answered')
+		  AND initiated_at > NOW() - INTERVAL '1 day'
+	`, accountID).Scan(&count)
+	return count, err
+}
+
+// SetCallWebSocketURL records the agent URL a call was connected to, and
+// the agent region it's in ("" when it isn't in one of its route's regions)
+func (s *PostgresStore) SetCallWebSocketURL(ctx context.Context, callID, websocketURL, region string) error {
+	_, err := s.pool.Exec(ctx, `UPDATE call_logs SET websocket_url = $2, agent_region = NULLIF($3, '') WHERE call_id = $1`, callID, websocketURL, region)
+	return err
+}
+
+// SetCallRoute records the route and agent URL a call was handed to, and
+// the route's agent split it was assigned ("" for none)
+func (s *PostgresStore) SetCallRoute(ctx context.Context, callID, routeID, websocketURL, splitTarget string) error {
+	_, err := s.pool.Exec(ctx, `UPDATE call_logs SET route_id = $2, websocket_url = $3, split_target = NULLIF($4, '') WHERE call_id = $1`, callID, routeID, websocketURL, splitTarget)
+	return err
+}
+
+// SetCallAMDResult records the answering machine detection result of a call
+func (s *PostgresStore) SetCallAMDResult(ctx context.Context, callID, result string) error {
+	_, err := s.pool.Exec(ctx, `UPDATE call_logs SET amd_result = $2 WHERE call_id = $1`, callID, result)
+	return err
+}
+
+// SetCallTransfer records where a call was transferred
+func (s *PostgresStore) SetCallTransfer(ctx context.Context, callID, target string) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE call_logs
+		SET transfer_target = $2, transferred_at = NOW()
+		WHERE call_id = $1
+	`, callID, target)
+	return err
+}
+
+// SetCallData sets a key of a call's custom data
+func (s *PostgresStore) SetCallData(ctx context.Context, callID, key string, value interface{}) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE call_logs
+		SET custom_data = COALESCE(custom_data, '{}'::jsonb) || jsonb_build_object($2::text, $3::jsonb)
+		WHERE call_id = $1
+	`, callID, key, value)
+	return err
+}
+
+// SetCallHangup records why and by whom a call was ended
+func (s *PostgresStore) SetCallHangup(ctx context.Context, callID, cause, party string) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE call_logs
+		SET hangup_cause = $2, hangup_party = $3
+		WHERE call_id = $1
+	`, callID, cause, party)
+	return err
+}
+
+// SetCallMediaUsage records the RTP bytes a call received from and sent to
+// the caller
+func (s *PostgresStore) SetCallMediaUsage(ctx context.Context, callID string, bytesIn, bytesOut int64) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE call_logs
+		SET media_bytes_in = $2, media_bytes_out = $3
+		WHERE call_id = $1
+	`, callID, bytesIn, bytesOut)
+	return err
+}
+
+// FindRecentConversation returns the conversation of the latest call from a
+// caller (as stored, so masked when CDR number masking is on) to an account
+// since a time
+func (s *PostgresStore) FindRecentConversation(ctx context.Context, accountID, fromUser string, since time.Time) (string, error) {
+	var conversationID string
+	err := s.pool.QueryRow(ctx, `
+		SELECT conversation_id::text
+		FROM call_logs
+		WHERE account_id = $1 AND from_user = $2 AND created_at >= $3
+		  AND conversation_id IS NOT NULL
 		ORDER BY created_at DESC
-		LIMIT $2
-	`, accountID, limit)
+		LIMIT 1
+	`, accountID, fromUser, since).Scan(&conversationID)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return conversationID, nil
+}
+
+// SetCallRecording stores the recording metadata of a call
+func (s *PostgresStore) SetCallRecording(ctx context.Context, callID, path string, size, durationMs int64) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE call_logs
+		SET recording_path = $2, recording_size = $3, recording_duration_ms = $4
+		WHERE call_id = $1
+	`, callID, path, size, durationMs)
+	return err
+}
+
+// ClearRecordings forgets the recordings of calls that ended before a time,
+// once deleted from storage, returning how many were cleared
+func (s *PostgresStore) ClearRecordings(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE call_logs
+		SET recording_path = NULL, recording_size = NULL, recording_duration_ms = NULL
+		WHERE recording_path IS NOT NULL AND ended_at < $1
+	`, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CallFilter selects a page of an account's calls. Empty fields match any
+// call.
+type CallFilter struct {
+	From, To       *time.Time // Created in [From, To)
+	Direction      models.CallDirection
+	Status         models.CallStatus
+	FromUser       string // As stored, so masked when CDR number masking is on
+	ToUser         string
+	RouteID        string
+	TrunkID        string
+	ConversationID string
+	Sort           string // A key of CallSorts; newest first when empty
+	Limit          int
+	Offset         int
+}
+
+// CallSorts are the orders calls can be listed in, by sort parameter
+var CallSorts = map[string]string{
+	"created_at":  "created_at ASC",
+	"-created_at": "created_at DESC",
+	"duration":    "duration_seconds ASC NULLS FIRST",
+	"-duration":   "duration_seconds DESC NULLS LAST",
+}
+
+// callFilterSQL is the condition for a CallFilter, with the account ID and
+// filter fields as $1-$10
+const callFilterSQL = `account_id = $1
+		  AND ($2::TIMESTAMPTZ IS NULL OR created_at >= $2)
+		  AND ($3::TIMESTAMPTZ IS NULL OR created_at < $3)
+		  AND ($4::TEXT = '' OR direction = $4)
+		  AND ($5::TEXT = '' OR status = $5)
+		  AND ($6::TEXT = '' OR from_user = $6)
+		  AND ($7::TEXT = '' OR to_user = $7)
+		  AND ($8::TEXT = '' OR route_id = NULLIF($8, '')::UUID)
+		  AND ($9::TEXT = '' OR trunk_id = NULLIF($9, '')::UUID)
+		  AND ($10::TEXT = '' OR conversation_id = NULLIF($10, '')::UUID)`
+
+// ListCalls returns a page of an account's calls matching a filter, and how
+// many calls match in all
+func (s *PostgresStore) ListCalls(ctx context.Context, accountID string, filter CallFilter) ([]*models.CallLog, int, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	order, ok := CallSorts[filter.Sort]
+	if filter.Sort == "" {
+		order, ok = CallSorts["-created_at"], true
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown sort %q", filter.Sort)
+	}
+
+	args := []interface{}{
+		accountID, filter.From, filter.To, string(filter.Direction), string(filter.Status),
+		filter.FromUser, filter.ToUser, filter.RouteID, filter.TrunkID, filter.ConversationID,
+	}
+
+	var total int
+	rows, err := s.reportQuery(ctx, `SELECT COUNT(*) FROM call_logs WHERE `+callFilterSQL, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	for rows.Next() {
+		if err := rows.Scan(&total); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err = s.reportQuery(ctx, `
+		SELECT id, account_id, call_id, direction, from_uri, to_uri,
+		       from_user, to_user, route_id, trunk_id, websocket_url,
+		       call_priority, status, initiated_at, ringing_at, answered_at, ended_at,
+		       duration_seconds, hangup_cause, hangup_party, amd_result, offered_media, transfer_target, transferred_at, custom_data,
+		       recording_path, recording_size, recording_duration_ms, media_bytes_in, media_bytes_out, instance_id, conversation_id, agent_region, region_decision, split_target, created_at
+		FROM call_logs
+		WHERE `+callFilterSQL+`
+		ORDER BY `+order+`, id
+		LIMIT $11 OFFSET $12
+	`, append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -443,16 +1263,65 @@ func (s *PostgresStore) ListCalls(ctx context.Context, accountID string, limit i
 		err := rows.Scan(
 			&c.ID, &c.AccountID, &c.CallID, &c.Direction, &c.FromURI, &c.ToURI,
 			&c.FromUser, &c.ToUser, &c.RouteID, &c.TrunkID, &c.WebSocketURL,
-			&c.Status, &c.InitiatedAt, &c.RingingAt, &c.AnsweredAt, &c.EndedAt,
-			&c.DurationSeconds, &c.HangupCause, &c.HangupParty, &c.CustomData, &c.CreatedAt,
+			&c.CallPriority, &c.Status, &c.InitiatedAt, &c.RingingAt, &c.AnsweredAt, &c.EndedAt,
+			&c.DurationSeconds, &c.HangupCause, &c.HangupParty, &c.AMDResult, &c.OfferedMedia, &c.TransferTarget, &c.TransferredAt, &c.CustomData,
+			&c.RecordingPath, &c.RecordingSize, &c.RecordingDurationMs, &c.MediaBytesIn, &c.MediaBytesOut, &c.InstanceID, &c.ConversationID, &c.AgentRegion, &c.RegionDecision, &c.SplitTarget, &c.CreatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		calls = append(calls, &c)
 	}
 
-	return calls, rows.Err()
+	return calls, total, rows.Err()
+}
+
+// ExportCalls passes the account's calls matching a filter to fn, in the
+// filter's order, ignoring its limit and offset. Rows are read from the
+// database as fn consumes them, so exports of any size use constant memory.
+// An error from fn stops the export and is returned.
+func (s *PostgresStore) ExportCalls(ctx context.Context, accountID string, filter CallFilter, fn func(*models.CallLog) error) error {
+	order, ok := CallSorts[filter.Sort]
+	if filter.Sort == "" {
+		order, ok = CallSorts["created_at"], true
+	}
+	if !ok {
+		return fmt.Errorf("unknown sort %q", filter.Sort)
+	}
+
+	rows, err := s.reportQuery(ctx, `
+		SELECT id, account_id, call_id, direction, from_uri, to_uri,
+		       from_user, to_user, route_id, trunk_id, websocket_url,
+		       call_priority, status, initiated_at, ringing_at, answered_at, ended_at,
+		       duration_seconds, hangup_cause, hangup_party, amd_result, offered_media, transfer_target, transferred_at, custom_data,
+		       recording_path, recording_size, recording_duration_ms, media_bytes_in, media_bytes_out, instance_id, conversation_id, agent_region, region_decision, split_target, created_at
+		FROM call_logs
+		WHERE `+callFilterSQL+`
+		ORDER BY `+order+`, id
+	`, accountID, filter.From, filter.To, string(filter.Direction), string(filter.Status),
+		filter.FromUser, filter.ToUser, filter.RouteID, filter.TrunkID, filter.ConversationID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.CallLog
+		err := rows.Scan(
+			&c.ID, &c.AccountID, &c.CallID, &c.Direction, &c.FromURI, &c.ToURI,
+			&c.FromUser, &c.ToUser, &c.RouteID, &c.TrunkID, &c.WebSocketURL,
+			&c.CallPriority, &c.Status, &c.InitiatedAt, &c.RingingAt, &c.AnsweredAt, &c.EndedAt,
+			&c.DurationSeconds, &c.HangupCause, &c.HangupParty, &c.AMDResult, &c.OfferedMedia, &c.TransferTarget, &c.TransferredAt, &c.CustomData,
+			&c.RecordingPath, &c.RecordingSize, &c.RecordingDurationMs, &c.MediaBytesIn, &c.MediaBytesOut, &c.InstanceID, &c.ConversationID, &c.AgentRegion, &c.RegionDecision, &c.SplitTarget, &c.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if err := fn(&c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
 // GetCall returns a call by ID
@@ -461,15 +1330,45 @@ func (s *PostgresStore) GetCall(ctx context.Context, accountID, callID string) (
 	err := s.pool.QueryRow(ctx, `
 		SELECT id, account_id, call_id, direction, from_uri, to_uri,
 		       from_user, to_user, route_id, trunk_id, websocket_url,
-		       status, initiated_at, ringing_at, answered_at, ended_at,
-		       duration_seconds, hangup_cause, hangup_party, custom_data, created_at
+		       call_priority, status, initiated_at, ringing_at, answered_at, ended_at,
+		       duration_seconds, hangup_cause, hangup_party, amd_result, offered_media, transfer_target, transferred_at, custom_data,
+		       recording_path, recording_size, recording_duration_ms, media_bytes_in, media_bytes_out, instance_id, conversation_id, agent_region, region_decision, split_target, created_at,
+		       from_user_encrypted, to_user_encrypted
 		FROM call_logs
 		WHERE id = $1 AND account_id = $2
 	`, callID, accountID).Scan(
 		&c.ID, &c.AccountID, &c.CallID, &c.Direction, &c.FromURI, &c.ToURI,
 		&c.FromUser, &c.ToUser, &c.RouteID, &c.TrunkID, &c.WebSocketURL,
-		&c.Status, &c.InitiatedAt, &c.RingingAt, &c.AnsweredAt, &c.EndedAt,
-		&c.DurationSeconds, &c.HangupCause, &c.HangupParty, &c.CustomData, &c.CreatedAt,
+		&c.CallPriority, &c.Status, &c.InitiatedAt, &c.RingingAt, &c.AnsweredAt, &c.EndedAt,
+		&c.DurationSeconds, &c.HangupCause, &c.HangupParty, &c.AMDResult, &c.OfferedMedia, &c.TransferTarget, &c.TransferredAt, &c.CustomData,
+		&c.RecordingPath, &c.RecordingSize, &c.RecordingDurationMs, &c.MediaBytesIn, &c.MediaBytesOut, &c.InstanceID, &c.ConversationID, &c.AgentRegion, &c.RegionDecision, &c.SplitTarget, &c.CreatedAt,
+		&c.FromUserEncrypted, &c.ToUserEncrypted,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetCallByCallID returns a call by its SIP Call-ID
+func (s *PostgresStore) GetCallByCallID(ctx context.Context, callID string) (*models.CallLog, error) {
+	var c models.CallLog
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, account_id, call_id, direction, from_uri, to_uri,
+		       from_user, to_user, route_id, trunk_id, websocket_url,
+		       call_priority, status, initiated_at, ringing_at, answered_at, ended_at,
+		       duration_seconds, hangup_cause, hangup_party, amd_result, offered_media, transfer_target, transferred_at, custom_data,
+		       recording_path, recording_size, recording_duration_ms, media_bytes_in, media_bytes_out, instance_id, conversation_id, agent_region, region_decision, split_target, created_at
+		FROM call_logs
+		WHERE call_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, callID).Scan(
+		&c.ID, &c.AccountID, &c.CallID, &c.Direction, &c.FromURI, &c.ToURI,
+		&c.FromUser, &c.ToUser, &c.RouteID, &c.TrunkID, &c.WebSocketURL,
+		&c.CallPriority, &c.Status, &c.InitiatedAt, &c.RingingAt, &c.AnsweredAt, &c.EndedAt,
+		&c.DurationSeconds, &c.HangupCause, &c.HangupParty, &c.AMDResult, &c.OfferedMedia, &c.TransferTarget, &c.TransferredAt, &c.CustomData,
+		&c.RecordingPath, &c.RecordingSize, &c.RecordingDurationMs, &c.MediaBytesIn, &c.MediaBytesOut, &c.InstanceID, &c.ConversationID, &c.AgentRegion, &c.RegionDecision, &c.SplitTarget, &c.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -477,3 +1376,438 @@ func (s *PostgresStore) GetCall(ctx context.Context, accountID, callID string) (
 	return &c, nil
 }
 
+// =============================================================================
+// Call Preemption Operations
+// =============================================================================
+
+// CreateCallPreemption records a call refused or ended for capacity
+func (s *PostgresStore) CreateCallPreemption(ctx context.Context, p *models.CallPreemption) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO call_preemptions (account_id, call_id, action, call_priority, preempted_by, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, p.AccountID, p.CallID, p.Action, p.CallPriority, p.PreemptedBy, p.Reason)
+	return err
+}
+
+// ListCallPreemptions returns recent preemptions for an account
+func (s *PostgresStore) ListCallPreemptions(ctx context.Context, accountID string, limit int) ([]*models.CallPreemption, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := s.reportQuery(ctx, `
+		SELECT id, account_id, call_id, action, call_priority, preempted_by, reason, created_at
+		FROM call_preemptions
+		WHERE account_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var preemptions []*models.CallPreemption
+	for rows.Next() {
+		var p models.CallPreemption
+		err := rows.Scan(
+			&p.ID, &p.AccountID, &p.CallID, &p.Action, &p.CallPriority,
+			&p.PreemptedBy, &p.Reason, &p.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		preemptions = append(preemptions, &p)
+	}
+
+	return preemptions, rows.Err()
+}
+
+// =============================================================================
+// SIP Message Operations
+// =============================================================================
+
+// RecordSIPMessages stores captured SIP messages in one round trip
+func (s *PostgresStore) RecordSIPMessages(ctx context.Context, msgs []*models.SIPMessage) error {
+	batch := &pgx.Batch{}
+	for _, msg := range msgs {
+		batch.Queue(`
+			INSERT INTO sip_messages (call_id, direction, method, status_code, reason, remote_addr, captured_at,
+			                          local_addr, raw)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, msg.CallID, msg.Direction, msg.Method, msg.StatusCode, msg.Reason, msg.RemoteAddr, msg.CapturedAt,
+			msg.LocalAddr, msg.Raw)
+	}
+	return s.pool.SendBatch(ctx, batch).Close()
+}
+
+// ListSIPMessages returns the captured SIP messages for a call in capture order
+func (s *PostgresStore) ListSIPMessages(ctx context.Context, callID string) ([]*models.SIPMessage, error) {
+	rows, err := s.reportQuery(ctx, `
+		SELECT id, call_id, direction, method, status_code, reason, remote_addr, captured_at,
+		       local_addr, raw
+		FROM sip_messages
+		WHERE call_id = $1
+		ORDER BY captured_at ASC, id ASC
+	`, callID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.SIPMessage
+	for rows.Next() {
+		var m models.SIPMessage
+		err := rows.Scan(
+			&m.ID, &m.CallID, &m.Direction, &m.Method, &m.StatusCode, &m.Reason,
+			&m.RemoteAddr, &m.CapturedAt, &m.LocalAddr, &m.Raw,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &m)
+	}
+
+	return messages, rows.Err()
+}
+
+// SaveRTPTrace stores the encoded RTP header trace of a call
+func (s *PostgresStore) SaveRTPTrace(ctx context.Context, callID string, packets []byte) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO rtp_traces (call_id, packets)
+		VALUES ($1, $2)
+		ON CONFLICT (call_id) DO UPDATE SET packets = EXCLUDED.packets, captured_at = NOW()
+	`, callID, packets)
+	return err
+}
+
+// GetRTPTrace returns the encoded RTP header trace of a call, or nil if the
+// call has none
+func (s *PostgresStore) GetRTPTrace(ctx context.Context, callID string) ([]byte, error) {
+	var packets []byte
+	err := s.pool.QueryRow(ctx, `
+		SELECT packets FROM rtp_traces WHERE call_id = $1
+	`, callID).Scan(&packets)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return packets, err
+}
+
+// =============================================================================
+// Webhook Operations
+// =============================================================================
+
+// ListWebhooks returns all webhooks for an account
+func (s *PostgresStore) ListWebhooks(ctx context.Context, accountID string) ([]*models.Webhook, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, account_id, url, secret, events, active, created_at, updated_at
+		FROM webhooks
+		WHERE account_id = $1
+		ORDER BY created_at ASC
+	`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*models.Webhook
+	for rows.Next() {
+		var w models.Webhook
+		err := rows.Scan(
+			&w.ID, &w.AccountID, &w.URL, &w.Secret, &w.Events, &w.Active, &w.CreatedAt, &w.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// GetWebhook returns a webhook by ID
+func (s *PostgresStore) GetWebhook(ctx context.Context, accountID, webhookID string) (*models.Webhook, error) {
+	var w models.Webhook
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, account_id, url, secret, events, active, created_at, updated_at
+		FROM webhooks
+		WHERE id = $1 AND account_id = $2
+	`, webhookID, accountID).Scan(
+		&w.ID, &w.AccountID, &w.URL, &w.Secret, &w.Events, &w.Active, &w.CreatedAt, &w.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// CreateWebhook creates a new webhook
+func (s *PostgresStore) CreateWebhook(ctx context.Context, accountID string, webhook *models.Webhook) (*models.Webhook, error) {
+	events := webhook.Events
+	if events == nil {
+		events = []string{}
+	}
+
+	var w models.Webhook
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO webhooks (account_id, url, secret, events)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, account_id, url, secret, events, active, created_at, updated_at
+	`, accountID, webhook.URL, webhook.Secret, events).Scan(
+		&w.ID, &w.AccountID, &w.URL, &w.Secret, &w.Events, &w.Active, &w.CreatedAt, &w.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// UpdateWebhook updates a webhook, keeping its secret when none is given
+func (s *PostgresStore) UpdateWebhook(ctx context.Context, accountID string, webhook *models.Webhook) (*models.Webhook, error) {
+	events := webhook.Events
+	if events == nil {
+		events = []string{}
+	}
+
+	var w models.Webhook
+	err := s.pool.QueryRow(ctx, `
+		UPDATE webhooks
+		SET url = $3, secret = COALESCE(NULLIF($4, ''), secret), events = $5, active = $6
+		WHERE id = $1 AND account_id = $2
+		RETURNING id, account_id, url, secret, events, active, created_at, updated_at
+	`, webhook.ID, accountID, webhook.URL, webhook.Secret, events, webhook.Active).Scan(
+		&w.ID, &w.AccountID, &w.URL, &w.Secret, &w.Events, &w.Active, &w.CreatedAt, &w.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// DeleteWebhook deletes a webhook
+func (s *PostgresStore) DeleteWebhook(ctx context.Context, accountID, webhookID string) error {
+	_, err := s.pool.Exec(ctx, `
+		DELETE FROM webhooks WHERE id = $1 AND account_id = $2
+	`, webhookID, accountID)
+	return err
+}
+
+// ListWebhooksForEvent returns an account's active webhooks subscribed to an
+// event
+func (s *PostgresStore) ListWebhooksForEvent(ctx context.Context, accountID, event string) ([]*models.Webhook, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, account_id, url, secret, events, active, created_at, updated_at
+		FROM webhooks
+		WHERE account_id = $1 AND active = true AND (events = '{}' OR $2 = ANY(events))
+	`, accountID, event)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*models.Webhook
+	for rows.Next() {
+		var w models.Webhook
+		err := rows.Scan(
+			&w.ID, &w.AccountID, &w.URL, &w.Secret, &w.Events, &w.Active, &w.CreatedAt, &w.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// CreateWebhookDeadLetter records an event that could not be delivered
+func (s *PostgresStore) CreateWebhookDeadLetter(ctx context.Context, d *models.WebhookDeadLetter) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO webhook_dead_letters (webhook_id, account_id, delivery_id, event, payload, attempts, last_error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, d.WebhookID, d.AccountID, d.DeliveryID, d.Event, d.Payload, d.Attempts, d.LastError)
+	return err
+}
+
+// ListWebhookDeadLetters returns an account's most recent undelivered events
+func (s *PostgresStore) ListWebhookDeadLetters(ctx context.Context, accountID string, limit int) ([]*models.WebhookDeadLetter, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := s.reportQuery(ctx, `
+		SELECT id, webhook_id, account_id, delivery_id, event, payload, attempts, last_error, created_at
+		FROM webhook_dead_letters
+		WHERE account_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []*models.WebhookDeadLetter
+	for rows.Next() {
+		var d models.WebhookDeadLetter
+		err := rows.Scan(
+			&d.ID, &d.WebhookID, &d.AccountID, &d.DeliveryID, &d.Event, &d.Payload,
+			&d.Attempts, &d.LastError, &d.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, &d)
+	}
+
+	return letters, rows.Err()
+}
+
+// =============================================================================
+// Routing Defaults Operations
+// =============================================================================
+
+// GetRoutingDefaults returns the routing defaults set through the admin API,
+// with no field set if none ever were
+func (s *PostgresStore) GetRoutingDefaults(ctx context.Context) (*models.RoutingDefaults, error) {
+	var d models.RoutingDefaults
+	err := s.pool.QueryRow(ctx, `
+		SELECT default_websocket_url, no_route_status, agent_unavailable_status, agent_connect_timeout_ms, updated_at
+		FROM routing_defaults
+	`).Scan(&d.DefaultWebSocketURL, &d.NoRouteStatus, &d.AgentUnavailableStatus, &d.AgentConnectTimeoutMs, &d.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return &d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// SetRoutingDefaults replaces the routing defaults; unset fields revert to
+// the configured values
+func (s *PostgresStore) SetRoutingDefaults(ctx context.Context, d *models.RoutingDefaults) (*models.RoutingDefaults, error) {
+	saved := *d
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO routing_defaults (id, default_websocket_url, no_route_status, agent_unavailable_status, agent_connect_timeout_ms, updated_at)
+		VALUES (TRUE, $1, $2, $3, $4, NOW())
+		ON CONFLICT (id) DO UPDATE SET
+			default_websocket_url = EXCLUDED.default_websocket_url,
+			no_route_status = EXCLUDED.no_route_status,
+			agent_unavailable_status = EXCLUDED.agent_unavailable_status,
+			agent_connect_timeout_ms = EXCLUDED.agent_connect_timeout_ms,
+			updated_at = EXCLUDED.updated_at
+		RETURNING updated_at
+	`, d.DefaultWebSocketURL, d.NoRouteStatus, d.AgentUnavailableStatus, d.AgentConnectTimeoutMs).Scan(&saved.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// GetCPSLimits returns the INVITE rate limits set through the admin API,
+// with no field set if none ever were
+func (s *PostgresStore) GetCPSLimits(ctx context.Context) (*models.CPSLimits, error) {
+	var l models.CPSLimits
+	err := s.pool.QueryRow(ctx, `
+		SELECT per_source_cps, per_source_burst, per_account_cps, per_account_burst, updated_at
+		FROM cps_limits
+	`).Scan(&l.PerSourceCPS, &l.PerSourceBurst, &l.PerAccountCPS, &l.PerAccountBurst, &l.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return &l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// SetCPSLimits replaces the INVITE rate limits; unset fields revert to the
+// configured values
+func (s *PostgresStore) SetCPSLimits(ctx context.Context, l *models.CPSLimits) (*models.CPSLimits, error) {
+	saved := *l
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO cps_limits (id, per_source_cps, per_source_burst, per_account_cps, per_account_burst, updated_at)
+		VALUES (TRUE, $1, $2, $3, $4, NOW())
+		ON CONFLICT (id) DO UPDATE SET
+			per_source_cps = EXCLUDED.per_source_cps,
+			per_source_burst = EXCLUDED.per_source_burst,
+			per_account_cps = EXCLUDED.per_account_cps,
+			per_account_burst = EXCLUDED.per_account_burst,
+			updated_at = EXCLUDED.updated_at
+		RETURNING updated_at
+	`, l.PerSourceCPS, l.PerSourceBurst, l.PerAccountCPS, l.PerAccountBurst).Scan(&saved.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// ListNumberOverrides returns the number overrides that haven't expired,
+// with their trunks
+func (s *PostgresStore) ListNumberOverrides(ctx context.Context) ([]*models.NumberOverride, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT o.number, o.websocket_url, o.trunk_id, o.reason, o.created_at, o.expires_at,
+		       t.account_id, t.name, t.host, t.port, t.transport
+		FROM number_overrides o
+		LEFT JOIN sip_trunks t ON t.id = o.trunk_id
+		WHERE o.expires_at > NOW()
+		ORDER BY o.number
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []*models.NumberOverride
+	for rows.Next() {
+		var o models.NumberOverride
+		var accountID, name, host, transport *string
+		var port *int
+		err := rows.Scan(&o.Number, &o.WebSocketURL, &o.TrunkID, &o.Reason, &o.CreatedAt, &o.ExpiresAt,
+			&accountID, &name, &host, &port, &transport)
+		if err != nil {
+			return nil, err
+		}
+		if o.TrunkID != nil && host != nil {
+			o.Trunk = &models.Trunk{ID: *o.TrunkID, AccountID: *accountID, Name: *name, Host: *host, Port: *port, Transport: *transport}
+		}
+		overrides = append(overrides, &o)
+	}
+
+	return overrides, rows.Err()
+}
+
+// SetNumberOverride creates or replaces the override of a number
+func (s *PostgresStore) SetNumberOverride(ctx context.Context, o *models.NumberOverride) (*models.NumberOverride, error) {
+	saved := *o
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO number_overrides (number, websocket_url, trunk_id, reason, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, NOW(), $5)
+		ON CONFLICT (number) DO UPDATE SET
+			websocket_url = EXCLUDED.websocket_url,
+			trunk_id = EXCLUDED.trunk_id,
+			reason = EXCLUDED.reason,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		RETURNING created_at
+	`, o.Number, o.WebSocketURL, o.TrunkID, o.Reason, o.ExpiresAt).Scan(&saved.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// DeleteNumberOverride removes the override of a number. It returns
+// pgx.ErrNoRows when the number has none.
+func (s *PostgresStore) DeleteNumberOverride(ctx context.Context, number string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM number_overrides WHERE number = $1`, number)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AttachReplica connects a read replica that serves reporting queries (call
+// history, preemptions, call flows), keeping that load off the primary used
+// for call setup
+func (s *PostgresStore) AttachReplica(ctx context.Context, replicaURL string) error {
+	config, err := pgxpool.ParseConfig(replicaURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse replica URL: %w", err)
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return fmt.Errorf("failed to create replica connection pool: %w", err)
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return fmt.Errorf("failed to ping replica: %w", err)
+	}
+
+	s.replica = pool
+	return nil
+}
+
+// reportQuery runs a reporting query on the replica, falling back to the
+// primary when there is no replica or the query fails there
+func (s *PostgresStore) reportQuery(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if s.replica != nil {
+		rows, err := s.replica.Query(ctx, sql, args...)
+		if err == nil {
+			return rows, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logger.Warn("Replica query failed, using primary", "error", err)
+	}
+	return s.pool.Query(ctx, sql, args...)
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the latest migration this build's queries are written
+// against. Migrations record themselves in schema_migrations (see migration
+// 043); bump this with each new one.
+const SchemaVersion = "053_sip_credential_ha1"
+
+var (
+	// ErrSchemaBehind is returned by CheckSchema when the database lacks
+	// migrations this build needs
+	ErrSchemaBehind = errors.New("database schema is older than this build")
+	// ErrSchemaAhead is returned by CheckSchema when the database has
+	// migrations newer than this build, as during a rolling upgrade
+	ErrSchemaAhead = errors.New("database schema is newer than this build")
+)
+
+// AppliedSchemaVersion returns the latest migration recorded in the
+// database, or "" when none is, as before migration 043
+func (s *PostgresStore) AppliedSchemaVersion(ctx context.Context) (string, error) {
+	var exists bool
+	if err := s.pool.QueryRow(ctx, `
+		SELECT to_regclass('schema_migrations') IS NOT NULL
+	`).Scan(&exists); err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", nil
+	}
+
+	var version *string
+	err := s.pool.QueryRow(ctx, `
+		SELECT MAX(version) FROM schema_migrations
+	`).Scan(&version)
+	if err != nil || version == nil {
+		return "", err
+	}
+	return *version, nil
+}
+
+// CheckSchema compares the database's latest migration with SchemaVersion,
+// returning it along with ErrSchemaBehind or ErrSchemaAhead on a mismatch.
+// Versions start with a zero-padded sequence number, so they sort in order.
+func (s *PostgresStore) CheckSchema(ctx context.Context) (string, error) {
+	applied, err := s.AppliedSchemaVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	switch {
+	case applied == "":
+		return "", fmt.Errorf("%w: no migrations recorded, apply migrations up to %s", ErrSchemaBehind, SchemaVersion)
+	case applied < SchemaVersion:
+		return applied, fmt.Errorf("%w: at %s, apply migrations up to %s", ErrSchemaBehind, applied, SchemaVersion)
+	case applied > SchemaVersion:
+		return applied, fmt.Errorf("%w: at %s, built for %s", ErrSchemaAhead, applied, SchemaVersion)
+	}
+	return applied, nil
+}
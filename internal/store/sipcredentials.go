@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// ListSIPCredentials returns an account's SIP credentials
+func (s *PostgresStore) ListSIPCredentials(ctx context.Context, accountID string) ([]*models.SIPCredential, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, account_id, username, realm, ha1, created_at, updated_at
+		FROM sip_credentials
+		WHERE account_id = $1
+		ORDER BY created_at ASC
+	`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []*models.SIPCredential
+	for rows.Next() {
+		var c models.SIPCredential
+		if err := rows.Scan(&c.ID, &c.AccountID, &c.Username, &c.Realm, &c.HA1, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		creds = append(creds, &c)
+	}
+	return creds, rows.Err()
+}
+
+// GetSIPCredential returns one of an account's SIP credentials
+func (s *PostgresStore) GetSIPCredential(ctx context.Context, accountID, credID string) (*models.SIPCredential, error) {
+	var c models.SIPCredential
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, account_id, username, realm, ha1, created_at, updated_at
+		FROM sip_credentials
+		WHERE id = $1 AND account_id = $2
+	`, credID, accountID).Scan(&c.ID, &c.AccountID, &c.Username, &c.Realm, &c.HA1, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// FindSIPCredentials returns the SIP credentials with a username in a realm,
+// of active accounts, for authenticating a caller. Usernames are only unique
+// within an account, so several accounts' may be returned, oldest first.
+func (s *PostgresStore) FindSIPCredentials(ctx context.Context, realm, username string) ([]*models.SIPCredential, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT c.id, c.account_id, c.username, c.realm, c.ha1, c.created_at, c.updated_at
+		FROM sip_credentials c
+		JOIN accounts a ON a.id = c.account_id
+		WHERE c.realm = $1 AND c.username = $2 AND a.active = true
+		ORDER BY c.created_at ASC
+	`, realm, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []*models.SIPCredential
+	for rows.Next() {
+		var c models.SIPCredential
+		if err := rows.Scan(&c.ID, &c.AccountID, &c.Username, &c.Realm, &c.HA1, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		creds = append(creds, &c)
+	}
+	return creds, rows.Err()
+}
+
+// CreateSIPCredential creates a SIP credential for an account
+func (s *PostgresStore) CreateSIPCredential(ctx context.Context, accountID string, cred *models.SIPCredential) (*models.SIPCredential, error) {
+	var c models.SIPCredential
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO sip_credentials (account_id, username, realm, ha1)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, account_id, username, realm, ha1, created_at, updated_at
+	`, accountID, cred.Username, cred.Realm, cred.HA1).Scan(
+		&c.ID, &c.AccountID, &c.Username, &c.Realm, &c.HA1, &c.CreatedAt, &c.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// UpdateSIPCredentialHA1 changes the realm and HA1 of an account's SIP
+// credential, as when its password is changed
+func (s *PostgresStore) UpdateSIPCredentialHA1(ctx context.Context, accountID, credID, realm, ha1 string) (*models.SIPCredential, error) {
+	var c models.SIPCredential
+	err := s.pool.QueryRow(ctx, `
+		UPDATE sip_credentials SET realm = $3, ha1 = $4
+		WHERE id = $1 AND account_id = $2
+		RETURNING id, account_id, username, realm, ha1, created_at, updated_at
+	`, credID, accountID, realm, ha1).Scan(
+		&c.ID, &c.AccountID, &c.Username, &c.Realm, &c.HA1, &c.CreatedAt, &c.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// DeleteSIPCredential deletes an account's SIP credential
+func (s *PostgresStore) DeleteSIPCredential(ctx context.Context, accountID, credID string) error {
+	_, err := s.pool.Exec(ctx, `
+		DELETE FROM sip_credentials WHERE id = $1 AND account_id = $2
+	`, credID, accountID)
+	return err
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+
+	"github.com/shiv6146/blayzen-sip/internal/models"
+)
+
+// ListSIPDomains returns an account's SIP domains
+func (s *PostgresStore) ListSIPDomains(ctx context.Context, accountID string) ([]*models.SIPDomain, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, account_id, domain, created_at
+		FROM sip_domains
+		WHERE account_id = $1
+		ORDER BY domain ASC
+	`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []*models.SIPDomain
+	for rows.Next() {
+		var d models.SIPDomain
+		if err := rows.Scan(&d.ID, &d.AccountID, &d.Domain, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		domains = append(domains, &d)
+	}
+	return domains, rows.Err()
+}
+
+// FindSIPDomainAccount returns the ID of the active account a SIP domain
+// belongs to, for attributing an inbound call
+func (s *PostgresStore) FindSIPDomainAccount(ctx context.Context, domain string) (string, error) {
+	var accountID string
+	err := s.pool.QueryRow(ctx, `
+		SELECT d.account_id
+		FROM sip_domains d
+		JOIN accounts a ON a.id = d.account_id
+		WHERE d.domain = $1 AND a.active = true
+	`, domain).Scan(&accountID)
+	if err != nil {
+		return "", err
+	}
+	return accountID, nil
+}
+
+// CreateSIPDomain adds a SIP domain to an account
+func (s *PostgresStore) CreateSIPDomain(ctx context.Context, accountID, domain string) (*models.SIPDomain, error) {
+	var d models.SIPDomain
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO sip_domains (account_id, domain)
+		VALUES ($1, $2)
+		RETURNING id, account_id, domain, created_at
+	`, accountID, domain).Scan(&d.ID, &d.AccountID, &d.Domain, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// DeleteSIPDomain removes a SIP domain from an account
+func (s *PostgresStore) DeleteSIPDomain(ctx context.Context, accountID, domainID string) error {
+	_, err := s.pool.Exec(ctx, `
+		DELETE FROM sip_domains WHERE id = $1 AND account_id = $2
+	`, domainID, accountID)
+	return err
+}
@@ -0,0 +1,110 @@
+package trace
+
+import (
+	"encoding/binary"
+	"io"
+	"net/netip"
+)
+
+// pcap file format constants. Packets are written as raw IP (no link layer)
+// with IP and UDP headers synthesized from the traced addresses.
+const (
+	pcapMagic   = 0xa1b2c3d4 // Microsecond timestamps
+	pcapSnapLen = 65535
+	linkTypeRaw = 101
+	ipv4Header  = 20
+	ipv6Header  = 40
+	udpHeader   = 8
+	protocolUDP = 17
+	defaultTTL  = 64
+)
+
+// WritePCAP writes packets as a pcap file, readable by Wireshark and tcpdump
+func WritePCAP(w io.Writer, packets []Packet) error {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:6], 2) // Version 2.4
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeRaw)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, p := range packets {
+		datagram := ipDatagram(p)
+		wireLen := len(datagram) - len(p.Data) + max(p.Length, len(p.Data))
+
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(p.Time.Unix()))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(p.Time.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(datagram)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(wireLen))
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+		if _, err := w.Write(datagram); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipDatagram wraps a packet's data in IPv4 or IPv6 and UDP headers. Lengths
+// are those on the wire, so truncated RTP shows as such.
+func ipDatagram(p Packet) []byte {
+	src, dst := parseAddr(p.Src), parseAddr(p.Dst)
+
+	udpLen := udpHeader + max(p.Length, len(p.Data))
+	udp := make([]byte, udpHeader, udpHeader+len(p.Data))
+	binary.BigEndian.PutUint16(udp[0:2], src.Port())
+	binary.BigEndian.PutUint16(udp[2:4], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpLen))
+	udp = append(udp, p.Data...) // Checksum left zero (not computed)
+
+	// IPv4 unless either end is IPv6, which maps the other end into IPv6
+	if src.Addr().Is4() && dst.Addr().Is4() {
+		ip := make([]byte, ipv4Header)
+		ip[0] = 0x45 // Version 4, 20-byte header
+		binary.BigEndian.PutUint16(ip[2:4], uint16(ipv4Header+udpLen))
+		ip[8] = defaultTTL
+		ip[9] = protocolUDP
+		s, d := src.Addr().As4(), dst.Addr().As4()
+		copy(ip[12:16], s[:])
+		copy(ip[16:20], d[:])
+		binary.BigEndian.PutUint16(ip[10:12], ipv4Checksum(ip))
+		return append(ip, udp...)
+	}
+
+	ip := make([]byte, ipv6Header)
+	ip[0] = 0x60 // Version 6
+	binary.BigEndian.PutUint16(ip[4:6], uint16(udpLen))
+	ip[6] = protocolUDP
+	ip[7] = defaultTTL
+	s, d := src.Addr().As16(), dst.Addr().As16()
+	copy(ip[8:24], s[:])
+	copy(ip[24:40], d[:])
+	return append(ip, udp...)
+}
+
+// parseAddr parses host:port, falling back to 0.0.0.0:0 for addresses that
+// aren't IP literals (e.g. WebRTC peers)
+func parseAddr(addr string) netip.AddrPort {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// ipv4Checksum computes the IPv4 header checksum
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i : i+2]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,105 @@
+// Package trace holds per-call SIP and RTP traces for debugging interop
+// issues, and renders them as pcap files or text dumps
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/shiv6146/blayzen-sip/pkg/rtp"
+)
+
+// Kind is the protocol of a traced packet
+type Kind int
+
+const (
+	KindSIP Kind = iota
+	KindRTP
+)
+
+func (k Kind) String() string {
+	if k == KindRTP {
+		return "RTP"
+	}
+	return "SIP"
+}
+
+// Packet is one traced UDP datagram
+type Packet struct {
+	Kind   Kind
+	Time   time.Time
+	Src    string // host:port
+	Dst    string // host:port
+	Data   []byte // Captured bytes: the whole SIP message, or the RTP header
+	Length int    // Length on the wire
+}
+
+// Marshal encodes packets for storage
+func Marshal(packets []Packet) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(packets); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes packets encoded by Marshal
+func Unmarshal(data []byte) ([]Packet, error) {
+	var packets []Packet
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&packets); err != nil {
+		return nil, fmt.Errorf("malformed trace: %w", err)
+	}
+	return packets, nil
+}
+
+// RTPHeader returns the header of an RTP packet, including CSRCs and any
+// header extension, without the payload
+func RTPHeader(packet []byte) []byte {
+	if len(packet) < rtp.HeaderSize {
+		return packet
+	}
+
+	n := rtp.HeaderSize + 4*int(packet[0]&0x0f)
+	if packet[0]&0x10 != 0 && len(packet) >= n+4 {
+		n += 4 + 4*int(binary.BigEndian.Uint16(packet[n+2:n+4]))
+	}
+	return packet[:min(n, len(packet))]
+}
+
+// parseRTPHeader decodes a captured RTP header. The padding flag is
+// cleared since the padding length is in the payload, which isn't kept.
+func parseRTPHeader(data []byte) (*rtp.Packet, error) {
+	header := bytes.Clone(data)
+	if len(header) > 0 {
+		header[0] &^= 0x20
+	}
+	return rtp.Unmarshal(header)
+}
+
+// WriteText writes a human-readable dump: SIP messages in full, RTP packets
+// as one line of header fields each
+func WriteText(w io.Writer, packets []Packet) error {
+	for _, p := range packets {
+		_, err := fmt.Fprintf(w, "%s %s %s -> %s (%d bytes)", p.Time.Format("2006-01-02T15:04:05.000000Z07:00"), p.Kind, p.Src, p.Dst, p.Length)
+		if err != nil {
+			return err
+		}
+
+		if p.Kind == KindSIP {
+			_, err = fmt.Fprintf(w, "\n%s\n\n", bytes.TrimRight(p.Data, "\r\n"))
+		} else if header, perr := parseRTPHeader(p.Data); perr == nil {
+			_, err = fmt.Fprintf(w, " pt=%d seq=%d ts=%d ssrc=%#08x marker=%t\n",
+				header.PayloadType, header.SequenceNumber, header.Timestamp, header.SSRC, header.Marker)
+		} else {
+			_, err = fmt.Fprintf(w, " malformed\n")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
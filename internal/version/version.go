@@ -0,0 +1,79 @@
+// Package version describes the running build: the release, commit, branch
+// and build date stamped in with -ldflags at build time (see the Makefile),
+// and the Go runtime it was built with
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Stamped at build time with
+// -ldflags "-X github.com/shiv6146/blayzen-sip/internal/version.Version=..."
+var (
+	Version   = "dev"
+	Commit    = ""
+	Branch    = ""
+	BuildDate = ""
+)
+
+// Info describes a build
+type Info struct {
+	Version   string `json:"version" example:"v1.4.0"`
+	Commit    string `json:"commit,omitempty" example:"3f25fb2"`
+	Branch    string `json:"branch,omitempty" example:"main"`
+	BuildDate string `json:"build_date,omitempty" example:"2025-03-01T12:00:00Z"`
+	GoVersion string `json:"go_version" example:"go1.24.1"`
+	Platform  string `json:"platform" example:"linux/amd64"`
+}
+
+// Get returns the running build's info. Builds without ldflags, as with
+// go run or go install, fall back to the commit and time Go recorded from
+// version control.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Branch:    Branch,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// String formats the info on one line, as printed by `blayzen-sip version`
+func (i Info) String() string {
+	s := "blayzen-sip " + i.Version
+	if i.Commit != "" {
+		s += " (" + shortCommit(i.Commit)
+		if i.Branch != "" {
+			s += ", " + i.Branch
+		}
+		s += ")"
+	}
+	if i.BuildDate != "" {
+		s += " built " + i.BuildDate
+	}
+	return fmt.Sprintf("%s with %s for %s", s, i.GoVersion, i.Platform)
+}
+
+// shortCommit abbreviates a full commit hash
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
@@ -0,0 +1,265 @@
+// Package webhook delivers call events to the URLs accounts register, signed
+// with each webhook's secret and retried with exponential backoff
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shiv6146/blayzen-sip/internal/config"
+	"github.com/shiv6146/blayzen-sip/internal/logging"
+	"github.com/shiv6146/blayzen-sip/internal/models"
+	"github.com/shiv6146/blayzen-sip/internal/store"
+)
+
+var logger = logging.Component("webhook")
+
+// Delivery headers
+const (
+	HeaderEvent     = "X-Blayzen-Event"
+	HeaderDelivery  = "X-Blayzen-Delivery" // Same for every attempt of a delivery
+	HeaderSignature = "X-Blayzen-Signature"
+)
+
+// maxBackoff caps the wait between delivery attempts
+const maxBackoff = 5 * time.Minute
+
+// Event is the JSON body POSTed to webhooks
+type Event struct {
+	ID        string          `json:"id"` // Delivery ID
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      *models.CallLog `json:"data"` // The call record (CDR) as of the event
+
+	Shortcut *models.DTMFShortcut `json:"shortcut,omitempty"` // Pressed, for call.dtmf_shortcut
+}
+
+// Dispatcher delivers call events to webhooks
+type Dispatcher struct {
+	store       *store.PostgresStore
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	slots       chan struct{} // Bounds concurrent requests
+
+	mu      sync.Mutex
+	pending map[string]chan struct{} // Closed once a call's latest event is delivered
+}
+
+// delivery is an event encoded for one webhook
+type delivery struct {
+	webhook *models.Webhook
+	id      string
+	body    []byte
+}
+
+// NewFromConfig returns the call event dispatcher, or nil without a store
+func NewFromConfig(cfg *config.Config, st *store.PostgresStore) *Dispatcher {
+	if st == nil {
+		return nil
+	}
+	return &Dispatcher{
+		store:       st,
+		client:      &http.Client{Timeout: cfg.WebhookTimeout},
+		maxAttempts: max(cfg.WebhookMaxAttempts, 1),
+		backoff:     cfg.WebhookRetryBackoff,
+		slots:       make(chan struct{}, max(cfg.WebhookConcurrency, 1)),
+		pending:     make(map[string]chan struct{}),
+	}
+}
+
+// GenerateSecret returns a new random signing secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header for a body sent at t: the Unix time and
+// the hex HMAC-SHA256 of "<time>.<body>" keyed by the secret
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CallStatus notifies an account's webhooks that a call reached status. The
+// call record is read as of the status, and delivered in the background
+// after the call's earlier events.
+func (d *Dispatcher) CallStatus(accountID, callID string, status models.CallStatus) {
+	d.notify(accountID, callID, models.WebhookEventForStatus(status), status, nil)
+}
+
+// DTMFShortcut notifies an account's webhooks that the caller pressed one of
+// the route's DTMF shortcuts, like CallStatus
+func (d *Dispatcher) DTMFShortcut(accountID, callID string, shortcut *models.DTMFShortcut) {
+	d.notify(accountID, callID, models.WebhookEventCallDTMFShortcut, "", shortcut)
+}
+
+// notify encodes an event about a call for the account's webhooks
+// subscribed to it, with the call record as it is now, and queues it for
+// delivery. status, when set, is the status the event is for.
+func (d *Dispatcher) notify(accountID, callID, event string, status models.CallStatus, shortcut *models.DTMFShortcut) {
+	if d == nil || accountID == "" {
+		return
+	}
+	now := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log := logger.With("call_id", callID, "account_id", accountID, "event", event)
+	webhooks, err := d.store.ListWebhooksForEvent(ctx, accountID, event)
+	if err != nil {
+		log.Error("Failed to look up webhooks", "error", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	cdr, err := d.store.GetCallByCallID(ctx, callID)
+	if err != nil {
+		log.Error("Failed to read call for webhooks", "error", err)
+		return
+	}
+	if status != "" {
+		cdr.Status = status
+	}
+	loc := time.UTC
+	if account, err := d.store.GetAccount(ctx, accountID); err == nil {
+		loc = account.Location()
+	}
+	cdr.Localize(loc)
+
+	deliveries := make([]delivery, 0, len(webhooks))
+	for _, w := range webhooks {
+		deliveryID := uuid.New().String()
+		body, err := json.Marshal(Event{
+			ID:        deliveryID,
+			Event:     event,
+			CreatedAt: now.In(loc),
+			Data:      cdr,
+			Shortcut:  shortcut,
+		})
+		if err != nil {
+			log.Error("Failed to encode webhook event", "error", err)
+			return
+		}
+		deliveries = append(deliveries, delivery{webhook: w, id: deliveryID, body: body})
+	}
+	d.send(callID, event, deliveries)
+}
+
+// send delivers an event to its webhooks once the call's previous event has
+// been delivered, or given up on, so each call's events arrive in order
+func (d *Dispatcher) send(callID, event string, deliveries []delivery) {
+	done := make(chan struct{})
+	d.mu.Lock()
+	prev := d.pending[callID]
+	d.pending[callID] = done
+	d.mu.Unlock()
+
+	go func() {
+		if prev != nil {
+			<-prev
+		}
+
+		var wg sync.WaitGroup
+		for _, dl := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.deliver(dl.webhook, event, dl.id, dl.body)
+			}()
+		}
+		wg.Wait()
+
+		d.mu.Lock()
+		if d.pending[callID] == done {
+			delete(d.pending, callID)
+		}
+		d.mu.Unlock()
+		close(done)
+	}()
+}
+
+// deliver POSTs an event until the webhook accepts it or attempts run out,
+// then records it as a dead letter
+func (d *Dispatcher) deliver(w *models.Webhook, event, deliveryID string, body []byte) {
+	log := logger.With("webhook_id", w.ID, "account_id", w.AccountID, "event", event, "delivery_id", deliveryID)
+
+	backoff := d.backoff
+	var err error
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff = min(backoff*2, maxBackoff)
+		}
+
+		if err = d.post(w, event, deliveryID, body); err == nil {
+			log.Debug("Webhook delivered", "attempt", attempt)
+			return
+		}
+		log.Warn("Webhook delivery failed", "attempt", attempt, "attempts", d.maxAttempts, "error", err)
+	}
+
+	log.Error("Webhook delivery gave up", "attempts", d.maxAttempts, "error", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dead := &models.WebhookDeadLetter{
+		WebhookID:  w.ID,
+		AccountID:  w.AccountID,
+		DeliveryID: deliveryID,
+		Event:      event,
+		Payload:    body,
+		Attempts:   d.maxAttempts,
+		LastError:  err.Error(),
+	}
+	if err := d.store.CreateWebhookDeadLetter(ctx, dead); err != nil {
+		log.Error("Failed to record webhook dead letter", "error", err)
+	}
+}
+
+// post makes one delivery attempt; any 2xx response is success
+func (d *Dispatcher) post(w *models.Webhook, event, deliveryID string, body []byte) error {
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderSignature, Sign(w.Secret, time.Now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+-- blayzen-sip Database Schema
+-- Version: 002_trunk_outbound_proxy
+
+-- =============================================================================
+-- SIP Trunks: outbound proxy
+-- =============================================================================
+-- Next-hop SBC/proxy (host[:port]) for all egress SIP on the trunk.
+-- Overrides the global SIP_OUTBOUND_PROXY; NULL means use the global setting.
+ALTER TABLE sip_trunks ADD COLUMN IF NOT EXISTS outbound_proxy VARCHAR(255);
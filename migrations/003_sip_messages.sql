@@ -0,0 +1,20 @@
+-- blayzen-sip Database Schema
+-- Version: 003_sip_messages
+
+-- =============================================================================
+-- SIP Messages Table
+-- =============================================================================
+-- Captured signaling per call, used to build call flow (ladder) diagrams
+CREATE TABLE IF NOT EXISTS sip_messages (
+    id BIGSERIAL PRIMARY KEY,
+    call_id VARCHAR(255) NOT NULL,        -- SIP Call-ID header
+    direction VARCHAR(3) NOT NULL,        -- 'in' (received) or 'out' (sent)
+    method VARCHAR(20) NOT NULL,          -- Request method, or CSeq method for responses
+    status_code INT,                      -- NULL for requests
+    reason VARCHAR(255),                  -- Response reason phrase
+    remote_addr VARCHAR(255),             -- Peer the message was received from / sent to
+    captured_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Index for per-call lookups
+CREATE INDEX IF NOT EXISTS idx_sip_messages_call_id ON sip_messages(call_id, captured_at);
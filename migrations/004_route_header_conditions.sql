@@ -0,0 +1,9 @@
+-- blayzen-sip Database Schema
+-- Version: 004_route_header_conditions
+
+-- =============================================================================
+-- SIP Routes: header conditions
+-- =============================================================================
+-- Additional SIP header conditions, all of which must match (AND semantics).
+-- Each entry: {"header": "X-Team", "operator": "exists|equals|wildcard|regex", "value": "..."}
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS match_headers JSONB DEFAULT '[]';
@@ -0,0 +1,10 @@
+-- blayzen-sip Database Schema
+-- Version: 005_route_match_groups
+
+-- =============================================================================
+-- SIP Routes: OR condition groups
+-- =============================================================================
+-- Alternative condition groups; when present at least one group must match
+-- (in addition to the route's own match_* columns). Each entry:
+-- {"to_users": ["1000", "1001"], "from_users": [...], "headers": [{...}]}
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS match_groups JSONB DEFAULT '[]';
@@ -0,0 +1,9 @@
+-- blayzen-sip Database Schema
+-- Version: 006_route_audio_format
+
+-- =============================================================================
+-- SIP Routes: agent audio format
+-- =============================================================================
+-- Audio format exchanged with the agent, NULL means 8kHz mu-law in 20ms chunks.
+-- {"encoding": "mulaw|l16", "sample_rate": 16000, "chunk_ms": 20}
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS audio_format JSONB;
@@ -0,0 +1,15 @@
+-- blayzen-sip Database Schema
+-- Version: 007_call_recording
+
+-- =============================================================================
+-- SIP Routes: call recording flag
+-- =============================================================================
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS record BOOLEAN NOT NULL DEFAULT false;
+
+-- =============================================================================
+-- Call Logs: recording metadata
+-- =============================================================================
+-- Stereo WAV (caller left, agent right), set once the recorded call has ended
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS recording_path VARCHAR(1024);
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS recording_size BIGINT;
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS recording_duration_ms BIGINT;
@@ -0,0 +1,14 @@
+-- blayzen-sip Database Schema
+-- Version: 008_header_rules
+
+-- =============================================================================
+-- SIP header manipulation rules
+-- =============================================================================
+-- Applied in order to ingress and/or egress messages of the trunk or route:
+-- [{"direction": "ingress|egress|both", "action": "add|remove|rewrite",
+--   "header": "From", "match": "regex", "value": "$1", "methods": ["INVITE"]}]
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS header_rules JSONB DEFAULT '[]';
+ALTER TABLE sip_trunks ADD COLUMN IF NOT EXISTS header_rules JSONB DEFAULT '[]';
+
+-- Inbound requests are matched to trunks by source host
+CREATE INDEX IF NOT EXISTS idx_trunks_host ON sip_trunks(host) WHERE active = true;
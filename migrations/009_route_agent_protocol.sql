@@ -0,0 +1,8 @@
+-- blayzen-sip Database Schema
+-- Version: 009_route_agent_protocol
+
+-- =============================================================================
+-- SIP Routes: agent WebSocket protocol
+-- =============================================================================
+-- Wire protocol spoken with the agent: exotel or twilio (Media Streams)
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS agent_protocol VARCHAR(16) NOT NULL DEFAULT 'exotel';
@@ -0,0 +1,27 @@
+-- blayzen-sip Database Schema
+-- Version: 010_call_priority
+
+-- =============================================================================
+-- Call priority
+-- =============================================================================
+-- Higher-priority calls (emergency, VIP) may take capacity from lower ones
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS call_priority INT NOT NULL DEFAULT 0;
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS call_priority INT NOT NULL DEFAULT 0;
+
+-- =============================================================================
+-- Call Preemptions Table
+-- =============================================================================
+-- Audit trail of calls refused or hung up because of capacity limits
+CREATE TABLE IF NOT EXISTS call_preemptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    account_id UUID REFERENCES accounts(id) ON DELETE SET NULL,
+    call_id VARCHAR(255) NOT NULL,        -- SIP Call-ID of the affected call
+    action VARCHAR(20) NOT NULL,          -- 'rejected' or 'terminated'
+    call_priority INT NOT NULL DEFAULT 0, -- Priority of the affected call
+    preempted_by VARCHAR(255),            -- Call-ID of the call given the capacity
+    reason VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Index for per-account listing
+CREATE INDEX IF NOT EXISTS idx_call_preemptions_account ON call_preemptions(account_id, created_at DESC);
@@ -0,0 +1,8 @@
+-- blayzen-sip Database Schema
+-- Version: 011_route_binary_audio
+
+-- =============================================================================
+-- SIP Routes: binary audio framing
+-- =============================================================================
+-- Send agent audio as binary WebSocket frames instead of base64 JSON
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS binary_audio BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,8 @@
+-- blayzen-sip Database Schema
+-- Version: 012_route_agent_auth
+
+-- =============================================================================
+-- SIP Routes: agent WebSocket authentication
+-- =============================================================================
+-- Headers, bearer token or signed JWT sent on the agent WebSocket handshake
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS agent_auth JSONB;
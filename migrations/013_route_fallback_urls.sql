@@ -0,0 +1,9 @@
+-- blayzen-sip Database Schema
+-- Version: 013_route_fallback_urls
+
+-- =============================================================================
+-- SIP Routes: agent failover
+-- =============================================================================
+-- Agent WebSocket URLs tried in order when websocket_url can't be reached:
+-- ["wss://agent-b.example.com/ws", "wss://agent-c.example.com/ws"]
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS fallback_websocket_urls JSONB DEFAULT '[]';
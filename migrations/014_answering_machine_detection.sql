@@ -0,0 +1,14 @@
+-- blayzen-sip Database Schema
+-- Version: 014_answering_machine_detection
+
+-- =============================================================================
+-- SIP Routes: two-stage answer
+-- =============================================================================
+-- Answer first and connect the agent only once a human is detected
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS detect_human BOOLEAN NOT NULL DEFAULT false;
+
+-- =============================================================================
+-- Call Logs: answering machine detection result
+-- =============================================================================
+-- 'human', 'machine' or 'notsure'
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS amd_result VARCHAR(20);
@@ -0,0 +1,12 @@
+-- blayzen-sip Database Schema
+-- Version: 015_route_agent_pool
+
+-- =============================================================================
+-- SIP Routes: agent load balancing
+-- =============================================================================
+-- Agent replicas sharing the route's calls with websocket_url:
+-- ["wss://agent-2.example.com/ws", "wss://agent-3.example.com/ws"]
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS agent_urls JSONB DEFAULT '[]';
+
+-- 'round_robin', 'least_active' or 'random'
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS agent_lb_strategy VARCHAR(20) NOT NULL DEFAULT 'round_robin';
@@ -0,0 +1,143 @@
+-- blayzen-sip Database Schema
+-- Version: 016_partition_call_logs
+
+-- =============================================================================
+-- Monthly Partition Management
+-- =============================================================================
+-- Call logs and captured SIP messages are partitioned by month (UTC) so inserts
+-- and recent-history queries stay fast as CDRs accumulate, and old months can be
+-- dropped whole. Partitions are named <table>_YYYY_MM. blayzen-sip creates
+-- upcoming partitions at startup and daily; both functions may also be run from
+-- cron or pg_cron.
+
+-- create_monthly_partitions creates partitions of a table for the given number
+-- of months, starting with the month containing from_month
+CREATE OR REPLACE FUNCTION create_monthly_partitions(parent TEXT, from_month DATE, months INT)
+RETURNS VOID AS $$
+DECLARE
+    month_start DATE;
+BEGIN
+    FOR i IN 0..months - 1 LOOP
+        month_start := (date_trunc('month', from_month) + make_interval(months => i))::DATE;
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+            parent || '_' || to_char(month_start, 'YYYY_MM'),
+            parent,
+            month_start::TIMESTAMP AT TIME ZONE 'UTC',
+            (month_start + INTERVAL '1 month')::TIMESTAMP AT TIME ZONE 'UTC'
+        );
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+-- drop_monthly_partitions drops the partitions of a table older than the last
+-- keep_months full months and returns how many were dropped
+CREATE OR REPLACE FUNCTION drop_monthly_partitions(parent TEXT, keep_months INT)
+RETURNS INT AS $$
+DECLARE
+    cutoff DATE := (date_trunc('month', NOW() AT TIME ZONE 'UTC') - make_interval(months => keep_months))::DATE;
+    part RECORD;
+    dropped INT := 0;
+BEGIN
+    FOR part IN
+        SELECT c.relname
+        FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = parent::REGCLASS
+          AND c.relname ~ ('^' || parent || '_\d{4}_\d{2}$')
+    LOOP
+        IF to_date(right(part.relname, 7), 'YYYY_MM') < cutoff THEN
+            EXECUTE format('DROP TABLE %I', part.relname);
+            dropped := dropped + 1;
+        END IF;
+    END LOOP;
+    RETURN dropped;
+END;
+$$ LANGUAGE plpgsql;
+
+-- months_until_now returns how many months from the month containing since up to
+-- and including the current month (UTC)
+CREATE OR REPLACE FUNCTION months_until_now(since DATE)
+RETURNS INT AS $$
+    SELECT ((EXTRACT(YEAR FROM NOW() AT TIME ZONE 'UTC') - EXTRACT(YEAR FROM since)) * 12
+          + EXTRACT(MONTH FROM NOW() AT TIME ZONE 'UTC') - EXTRACT(MONTH FROM since))::INT + 1;
+$$ LANGUAGE sql STABLE;
+
+-- =============================================================================
+-- Call Logs: partition by created_at
+-- =============================================================================
+-- Existing rows are copied into partitions covering their months. Skipped when
+-- call_logs is already partitioned.
+DO $$
+DECLARE
+    first_month DATE;
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'call_logs'::REGCLASS) THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE call_logs RENAME TO call_logs_unpartitioned;
+    ALTER INDEX call_logs_pkey RENAME TO call_logs_unpartitioned_pkey;
+    DROP INDEX IF EXISTS idx_calls_account, idx_calls_call_id, idx_calls_status, idx_calls_created;
+
+    -- The partition key must be part of the primary key
+    CREATE TABLE call_logs (LIKE call_logs_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS)
+        PARTITION BY RANGE (created_at);
+    ALTER TABLE call_logs ALTER COLUMN created_at SET NOT NULL;
+    ALTER TABLE call_logs ADD PRIMARY KEY (id, created_at);
+    ALTER TABLE call_logs ADD FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE SET NULL;
+    ALTER TABLE call_logs ADD FOREIGN KEY (route_id) REFERENCES sip_routes(id) ON DELETE SET NULL;
+    ALTER TABLE call_logs ADD FOREIGN KEY (trunk_id) REFERENCES sip_trunks(id) ON DELETE SET NULL;
+
+    UPDATE call_logs_unpartitioned SET created_at = COALESCE(initiated_at, NOW()) WHERE created_at IS NULL;
+    SELECT date_trunc('month', MIN(created_at) AT TIME ZONE 'UTC')::DATE INTO first_month FROM call_logs_unpartitioned;
+    first_month := COALESCE(first_month, (NOW() AT TIME ZONE 'UTC')::DATE);
+
+    -- Every month with data, plus the next three
+    PERFORM create_monthly_partitions('call_logs', first_month, months_until_now(first_month) + 3);
+
+    INSERT INTO call_logs SELECT * FROM call_logs_unpartitioned;
+    DROP TABLE call_logs_unpartitioned;
+END $$;
+
+-- Indexes for call log queries, created on every partition
+CREATE INDEX IF NOT EXISTS idx_calls_account ON call_logs(account_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_calls_call_id ON call_logs(call_id);
+CREATE INDEX IF NOT EXISTS idx_calls_status ON call_logs(status) WHERE status IN ('initiated', 'ringing', 'answered');
+CREATE INDEX IF NOT EXISTS idx_calls_created ON call_logs(created_at DESC);
+
+-- =============================================================================
+-- SIP Messages: partition by captured_at
+-- =============================================================================
+DO $$
+DECLARE
+    first_month DATE;
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'sip_messages'::REGCLASS) THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE sip_messages RENAME TO sip_messages_unpartitioned;
+    ALTER INDEX sip_messages_pkey RENAME TO sip_messages_unpartitioned_pkey;
+    DROP INDEX IF EXISTS idx_sip_messages_call_id;
+
+    CREATE TABLE sip_messages (LIKE sip_messages_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS)
+        PARTITION BY RANGE (captured_at);
+    ALTER TABLE sip_messages ALTER COLUMN captured_at SET NOT NULL;
+    ALTER TABLE sip_messages ADD PRIMARY KEY (id, captured_at);
+
+    -- Keep the id sequence when the old table is dropped
+    ALTER SEQUENCE sip_messages_id_seq OWNED BY sip_messages.id;
+
+    UPDATE sip_messages_unpartitioned SET captured_at = NOW() WHERE captured_at IS NULL;
+    SELECT date_trunc('month', MIN(captured_at) AT TIME ZONE 'UTC')::DATE INTO first_month FROM sip_messages_unpartitioned;
+    first_month := COALESCE(first_month, (NOW() AT TIME ZONE 'UTC')::DATE);
+
+    PERFORM create_monthly_partitions('sip_messages', first_month, months_until_now(first_month) + 3);
+
+    INSERT INTO sip_messages SELECT * FROM sip_messages_unpartitioned;
+    DROP TABLE sip_messages_unpartitioned;
+END $$;
+
+-- Index for per-call lookups
+CREATE INDEX IF NOT EXISTS idx_sip_messages_call_id ON sip_messages(call_id, captured_at);
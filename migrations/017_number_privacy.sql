@@ -0,0 +1,11 @@
+-- blayzen-sip Database Schema
+-- Version: 017_number_privacy
+
+-- =============================================================================
+-- Encrypted Call Numbers
+-- =============================================================================
+-- With CDR_NUMBER_MASKING enabled, from_user/to_user (and the URIs) hold masked
+-- numbers. When NUMBER_ENCRYPTION_KEY is set the raw numbers are kept here,
+-- AES-GCM encrypted, for lookup through GET /api/v1/calls/{id}/numbers.
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS from_user_encrypted TEXT;
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS to_user_encrypted TEXT;
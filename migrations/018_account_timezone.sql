@@ -0,0 +1,10 @@
+-- blayzen-sip Database Schema
+-- Version: 018_account_timezone
+
+-- =============================================================================
+-- Account Timezone
+-- =============================================================================
+-- IANA timezone (e.g. 'Europe/Berlin') of each account. API timestamps are
+-- returned in it, with their UTC offset, and reporting days start and end at
+-- its midnight. Timestamps are still stored as TIMESTAMPTZ.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
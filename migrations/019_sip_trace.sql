@@ -0,0 +1,18 @@
+-- blayzen-sip Database Schema
+-- Version: 019_sip_trace
+
+-- =============================================================================
+-- SIP Tracing
+-- =============================================================================
+-- With SIP_TRACE_ENABLED, captured SIP messages keep the full message text and
+-- the local address it was sent from or received on, and the headers of each
+-- call's first RTP packets are stored once the call ends. Both are served from
+-- GET /api/v1/calls/{id}/trace as a pcap file or text dump.
+ALTER TABLE sip_messages ADD COLUMN IF NOT EXISTS local_addr VARCHAR(255);
+ALTER TABLE sip_messages ADD COLUMN IF NOT EXISTS raw TEXT;
+
+CREATE TABLE IF NOT EXISTS rtp_traces (
+    call_id VARCHAR(255) PRIMARY KEY,
+    packets BYTEA NOT NULL, -- gob-encoded trace packets (RTP headers only)
+    captured_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
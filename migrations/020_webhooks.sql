@@ -0,0 +1,44 @@
+-- blayzen-sip Database Schema
+-- Version: 020_webhooks
+
+-- =============================================================================
+-- Webhooks
+-- =============================================================================
+-- URLs an account receives call events at (call.initiated, call.ringing,
+-- call.answered, call.completed, call.failed), signed with the webhook's secret
+CREATE TABLE IF NOT EXISTS webhooks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    account_id UUID NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,             -- HMAC-SHA256 signing key
+    events TEXT[] NOT NULL DEFAULT '{}',      -- Subscribed events; empty means all
+    active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_account_id ON webhooks(account_id);
+
+DROP TRIGGER IF EXISTS update_webhooks_updated_at ON webhooks;
+CREATE TRIGGER update_webhooks_updated_at
+    BEFORE UPDATE ON webhooks
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- =============================================================================
+-- Webhook Dead Letters
+-- =============================================================================
+-- Events that could not be delivered after all retries
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    account_id UUID NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    delivery_id VARCHAR(255) NOT NULL,        -- X-Blayzen-Delivery of the attempts
+    event VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL,
+    last_error TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_dead_letters_account_id ON webhook_dead_letters(account_id, created_at);
@@ -0,0 +1,10 @@
+-- blayzen-sip Database Schema
+-- Version: 021_allowed_agent_urls
+
+-- =============================================================================
+-- Allowed Agent URLs
+-- =============================================================================
+-- Patterns ('*.mycompany.com', 'wss://agent.example.com:8443') that agent
+-- WebSocket URLs of an account's routes must match, checked when routes are
+-- saved and again when calls connect. Empty allows any agent URL.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS allowed_agent_urls TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,39 @@
+-- blayzen-sip Database Schema
+-- Version: 022_api_keys
+
+-- =============================================================================
+-- API Keys
+-- =============================================================================
+-- Credentials for each account's API access. Only a SHA-256 hash of each key
+-- is stored; the key itself is shown once, when generated. An account may have
+-- several keys, so a key can be rotated without downtime: the old key stays
+-- valid until its expires_at.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    account_id UUID NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    key_hash CHAR(64) UNIQUE NOT NULL,        -- Hex SHA-256 of the key
+    prefix VARCHAR(16) NOT NULL,              -- First characters, to tell keys apart
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE,      -- Set when rotated with a grace period
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_account_id ON api_keys(account_id);
+
+-- Move the single plaintext key each account had into api_keys
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'accounts' AND column_name = 'api_key'
+    ) THEN
+        INSERT INTO api_keys (account_id, name, key_hash, prefix)
+        SELECT id, 'default', encode(sha256(convert_to(api_key, 'UTF8')), 'hex'), left(api_key, 8)
+        FROM accounts
+        ON CONFLICT (key_hash) DO NOTHING;
+
+        DROP INDEX IF EXISTS idx_accounts_api_key;
+        ALTER TABLE accounts DROP COLUMN api_key;
+    END IF;
+END $$;
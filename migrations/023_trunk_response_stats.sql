@@ -0,0 +1,17 @@
+-- blayzen-sip Database Schema
+-- Version: 023_trunk_response_stats
+
+-- =============================================================================
+-- Trunk Response Stats
+-- =============================================================================
+-- Final SIP responses exchanged with each trunk, counted by the hour, so
+-- carrier-side failures can be told apart from our own
+CREATE TABLE IF NOT EXISTS trunk_response_stats (
+    trunk_id UUID NOT NULL REFERENCES sip_trunks(id) ON DELETE CASCADE,
+    hour TIMESTAMP WITH TIME ZONE NOT NULL,
+    direction VARCHAR(10) NOT NULL,           -- inbound: sent by us to the trunk; outbound: sent by the trunk to us
+    method VARCHAR(20) NOT NULL,              -- Method of the request answered
+    status_code INT NOT NULL,
+    count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (trunk_id, hour, direction, method, status_code)
+);
@@ -0,0 +1,18 @@
+-- blayzen-sip Database Schema
+-- Version: 024_api_key_scopes
+
+-- =============================================================================
+-- API Key Scopes and Hashing
+-- =============================================================================
+-- Keys are hashed with argon2id (salted, so no longer unique) and found by
+-- their prefix. Hex SHA-256 hashes are replaced when their key is next used.
+ALTER TABLE api_keys ALTER COLUMN key_hash TYPE TEXT;
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_key_hash_key;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_prefix ON api_keys(account_id, prefix);
+
+-- Scopes limit what a key may do; none means full access
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{}';
+
+-- Updated at most once a minute
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,10 @@
+-- blayzen-sip Database Schema
+-- Version: 025_route_ringback
+
+-- =============================================================================
+-- SIP Routes: custom ringback
+-- =============================================================================
+-- Early media streamed with 183 Session Progress until the call is answered,
+-- replacing the carrier's ringback: "tone:<country or cadence>" or
+-- "file:<name>" (a WAV file in RINGBACK_DIR). NULL sends a plain 180 Ringing.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS ringback TEXT;
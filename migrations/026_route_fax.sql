@@ -0,0 +1,13 @@
+-- blayzen-sip Database Schema
+-- Version: 026_route_fax
+
+-- =============================================================================
+-- SIP Routes: fax handling
+-- =============================================================================
+-- What happens to fax calls, detected from their CNG tone or a T.38
+-- re-INVITE: 'ignore', 'reject', 'divert' (to fax_target) or 'notify' (the
+-- agent)
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS fax_policy VARCHAR(16) NOT NULL DEFAULT 'ignore';
+
+-- SIP URI fax calls are transferred to with the 'divert' policy
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS fax_target TEXT;
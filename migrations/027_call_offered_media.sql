@@ -0,0 +1,9 @@
+-- blayzen-sip Database Schema
+-- Version: 027_call_offered_media
+
+-- =============================================================================
+-- Call Logs: offered media
+-- =============================================================================
+-- Media types in the caller's SDP offer, e.g. {audio,video} for a video call
+-- answered audio-only. NULL when the INVITE carried no offer.
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS offered_media TEXT[];
@@ -0,0 +1,10 @@
+-- blayzen-sip Database Schema
+-- Version: 028_call_transfers
+
+-- =============================================================================
+-- Call Logs: transfers
+-- =============================================================================
+-- Where the caller was transferred (blind transfer with REFER) and when the
+-- far end accepted it. NULL for calls never transferred.
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS transfer_target TEXT;
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS transferred_at TIMESTAMPTZ;
@@ -0,0 +1,17 @@
+-- blayzen-sip Database Schema
+-- Version: 029_routing_defaults
+
+-- =============================================================================
+-- Routing Defaults
+-- =============================================================================
+-- Routing settings changed at runtime through the admin API, overriding the
+-- instances' configuration without a restart. A single row; NULL columns
+-- keep the configured value.
+CREATE TABLE IF NOT EXISTS routing_defaults (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    default_websocket_url TEXT,
+    no_route_status INTEGER,
+    agent_unavailable_status INTEGER,
+    agent_connect_timeout_ms INTEGER,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
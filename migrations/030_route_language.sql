@@ -0,0 +1,10 @@
+-- blayzen-sip Database Schema
+-- Version: 030_route_language
+
+-- =============================================================================
+-- SIP Routes: caller language
+-- =============================================================================
+-- Language of the route's callers (BCP 47 tag, e.g. 'es-MX'), passed to the
+-- agent and used to pick the ringback and silence prompt files. NULL when
+-- unset.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS language VARCHAR(35);
@@ -0,0 +1,17 @@
+-- blayzen-sip Database Schema
+-- Version: 031_number_overrides
+
+-- =============================================================================
+-- Number Overrides
+-- =============================================================================
+-- Emergency redirects of the calls to a number, taking precedence over its
+-- routes until they expire: to another agent, or out through a trunk.
+CREATE TABLE IF NOT EXISTS number_overrides (
+    number VARCHAR(64) PRIMARY KEY,
+    websocket_url TEXT,
+    trunk_id UUID REFERENCES sip_trunks(id) ON DELETE CASCADE,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    CHECK ((websocket_url IS NULL) <> (trunk_id IS NULL))
+);
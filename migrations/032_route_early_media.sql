@@ -0,0 +1,10 @@
+-- blayzen-sip Database Schema
+-- Version: 032_route_early_media
+
+-- =============================================================================
+-- SIP Routes: early media
+-- =============================================================================
+-- Whether the route's agent is connected before the call is answered and
+-- streams to the caller in early media (183 Session Progress), until it asks
+-- for the call to be answered.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS early_media BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,28 @@
+-- blayzen-sip Database Schema
+-- Version: 033_failover
+
+-- =============================================================================
+-- Active/Standby Failover
+-- =============================================================================
+-- The instance of an active/standby pair serving SIP, and its heartbeat. A
+-- single row; the standby takes it over once the heartbeat is too old.
+CREATE TABLE IF NOT EXISTS failover_active (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    instance_id VARCHAR(255) NOT NULL,
+    heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    promoted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Dialogs of the calls in progress on the active instance, so the instance
+-- taking over can end the calls it left behind
+CREATE TABLE IF NOT EXISTS failover_dialogs (
+    call_id VARCHAR(255) PRIMARY KEY,
+    instance_id VARCHAR(255) NOT NULL,
+    account_id UUID,
+    invite TEXT NOT NULL,
+    answer TEXT,
+    source VARCHAR(255) NOT NULL,
+    transport VARCHAR(10) NOT NULL,
+    egress_rules JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
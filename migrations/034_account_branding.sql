@@ -0,0 +1,10 @@
+-- blayzen-sip Database Schema
+-- Version: 034_account_branding
+
+-- =============================================================================
+-- Account Branding
+-- =============================================================================
+-- What an account's callers see of the platform, for resellers white-labeling
+-- it: reason phrases and extra headers of the responses rejecting its calls,
+-- and the session name of its SDP answers. Empty keeps blayzen-sip's own.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS branding JSONB NOT NULL DEFAULT '{}';
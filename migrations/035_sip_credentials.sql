@@ -0,0 +1,25 @@
+-- blayzen-sip Database Schema
+-- Version: 035_sip_credentials
+
+-- =============================================================================
+-- SIP Credentials
+-- =============================================================================
+-- Usernames and passwords callers that aren't one of an account's trunks
+-- (PBXs, softphones) answer SIP digest challenges with to call its routes,
+-- when SIP_AUTH_ENABLED is set. Passwords are kept as digest needs them.
+CREATE TABLE IF NOT EXISTS sip_credentials (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    account_id UUID NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    username VARCHAR(255) NOT NULL UNIQUE,
+    password VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sip_credentials_account_id ON sip_credentials(account_id);
+
+DROP TRIGGER IF EXISTS update_sip_credentials_updated_at ON sip_credentials;
+CREATE TRIGGER update_sip_credentials_updated_at
+    BEFORE UPDATE ON sip_credentials
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
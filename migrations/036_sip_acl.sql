@@ -0,0 +1,20 @@
+-- blayzen-sip Database Schema
+-- Version: 036_sip_acl
+
+-- =============================================================================
+-- SIP Access Control List
+-- =============================================================================
+-- Address ranges SIP requests are allowed or denied from, checked before an
+-- INVITE is processed. Entries without a trunk apply to every caller; a
+-- trunk's entries are its carrier's SBC ranges, whose INVITEs are taken as
+-- the trunk's, less any of its deny ranges.
+CREATE TABLE IF NOT EXISTS sip_acl_entries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    trunk_id UUID REFERENCES sip_trunks(id) ON DELETE CASCADE,
+    action VARCHAR(8) NOT NULL CHECK (action IN ('allow', 'deny')),
+    cidr CIDR NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sip_acl_entries_trunk_id ON sip_acl_entries(trunk_id);
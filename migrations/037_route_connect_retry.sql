@@ -0,0 +1,9 @@
+-- blayzen-sip Database Schema
+-- Version: 037_route_connect_retry
+
+-- =============================================================================
+-- SIP Routes: agent connect retries
+-- =============================================================================
+-- How often connecting the route's agent is retried before a call is
+-- rejected, and the backoff with jitter between attempts. NULL tries once.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS connect_retry JSONB;
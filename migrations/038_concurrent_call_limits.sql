@@ -0,0 +1,12 @@
+-- blayzen-sip Database Schema
+-- Version: 038_concurrent_call_limits
+
+-- =============================================================================
+-- Concurrent call limits
+-- =============================================================================
+-- The most calls an account, or one of its routes, may have in progress at
+-- once across all instances. NULL doesn't limit them.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS max_concurrent_calls INTEGER
+    CHECK (max_concurrent_calls > 0);
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS max_concurrent_calls INTEGER
+    CHECK (max_concurrent_calls > 0);
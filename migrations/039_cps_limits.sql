@@ -0,0 +1,21 @@
+-- blayzen-sip Database Schema
+-- Version: 039_cps_limits
+
+-- =============================================================================
+-- INVITE Rate Limits
+-- =============================================================================
+-- Calls per second allowed from one source address and to one account,
+-- changed at runtime through the admin API. A single row; NULL columns keep
+-- the configured value.
+CREATE TABLE IF NOT EXISTS cps_limits (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    per_source_cps INTEGER,
+    per_source_burst INTEGER,
+    per_account_cps INTEGER,
+    per_account_burst INTEGER,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- An account's own calls per second, replacing the per-account limit
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS calls_per_second INTEGER
+    CHECK (calls_per_second > 0);
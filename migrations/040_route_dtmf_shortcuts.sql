@@ -0,0 +1,9 @@
+-- blayzen-sip Database Schema
+-- Version: 040_route_dtmf_shortcuts
+
+-- =============================================================================
+-- SIP Routes: DTMF shortcuts
+-- =============================================================================
+-- Actions callers trigger by pressing digits mid-call (transfer, hangup,
+-- set custom data or notify webhooks), as a JSON array. NULL has none.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS dtmf_shortcuts JSONB;
@@ -0,0 +1,10 @@
+-- blayzen-sip Database Schema
+-- Version: 041_call_log_instance
+
+-- =============================================================================
+-- Call Logs: handling instance
+-- =============================================================================
+-- INSTANCE_ID of the blayzen-sip instance that took the call, so calls left
+-- in progress by an instance that died can be told from live ones. NULL for
+-- calls logged before it was recorded.
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS instance_id VARCHAR(255);
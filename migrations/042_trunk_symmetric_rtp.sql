@@ -0,0 +1,11 @@
+-- blayzen-sip Database Schema
+-- Version: 042_trunk_symmetric_rtp
+
+-- =============================================================================
+-- SIP Trunks: symmetric RTP
+-- =============================================================================
+-- Whether RTP to the trunk's calls goes back to where the trunk's packets
+-- come from (latching, which gets through NAT) or always to the address in
+-- its SDP, for carriers sending media from a different address than they
+-- receive it on.
+ALTER TABLE sip_trunks ADD COLUMN IF NOT EXISTS symmetric_rtp BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,60 @@
+-- blayzen-sip Database Schema
+-- Version: 043_schema_migrations
+
+-- =============================================================================
+-- Schema Migrations
+-- =============================================================================
+-- The migrations applied to the database, checked by blayzen-sip at startup
+-- against the latest one it was built for, so an instance doesn't run against
+-- a partially upgraded schema. Every migration from this one on records
+-- itself as its last statement; those before it are recorded here.
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version VARCHAR(255) PRIMARY KEY,
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO schema_migrations (version) VALUES
+    ('001_initial'),
+    ('002_trunk_outbound_proxy'),
+    ('003_sip_messages'),
+    ('004_route_header_conditions'),
+    ('005_route_match_groups'),
+    ('006_route_audio_format'),
+    ('007_call_recording'),
+    ('008_header_rules'),
+    ('009_route_agent_protocol'),
+    ('010_call_priority'),
+    ('011_route_binary_audio'),
+    ('012_route_agent_auth'),
+    ('013_route_fallback_urls'),
+    ('014_answering_machine_detection'),
+    ('015_route_agent_pool'),
+    ('016_partition_call_logs'),
+    ('017_number_privacy'),
+    ('018_account_timezone'),
+    ('019_sip_trace'),
+    ('020_webhooks'),
+    ('021_allowed_agent_urls'),
+    ('022_api_keys'),
+    ('023_trunk_response_stats'),
+    ('024_api_key_scopes'),
+    ('025_route_ringback'),
+    ('026_route_fax'),
+    ('027_call_offered_media'),
+    ('028_call_transfers'),
+    ('029_routing_defaults'),
+    ('030_route_language'),
+    ('031_number_overrides'),
+    ('032_route_early_media'),
+    ('033_failover'),
+    ('034_account_branding'),
+    ('035_sip_credentials'),
+    ('036_sip_acl'),
+    ('037_route_connect_retry'),
+    ('038_concurrent_call_limits'),
+    ('039_cps_limits'),
+    ('040_route_dtmf_shortcuts'),
+    ('041_call_log_instance'),
+    ('042_trunk_symmetric_rtp'),
+    ('043_schema_migrations')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,21 @@
+-- blayzen-sip Database Schema
+-- Version: 044_media_bandwidth
+
+-- =============================================================================
+-- Media bandwidth limits
+-- =============================================================================
+-- The most media bitrate (kbps, each way) a call may need on a route or over
+-- a trunk, so wider media isn't sent over constrained links, and the most
+-- an account's calls in progress may use together. NULL is unlimited.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS max_bitrate_kbps INTEGER CHECK (max_bitrate_kbps > 0);
+ALTER TABLE sip_trunks ADD COLUMN IF NOT EXISTS max_bitrate_kbps INTEGER CHECK (max_bitrate_kbps > 0);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS max_bandwidth_kbps INTEGER CHECK (max_bandwidth_kbps > 0);
+
+-- =============================================================================
+-- Call Logs: media usage
+-- =============================================================================
+-- RTP bytes a call received from and sent to the caller, recorded as it ends.
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS media_bytes_in BIGINT;
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS media_bytes_out BIGINT;
+
+INSERT INTO schema_migrations (version) VALUES ('044_media_bandwidth') ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,31 @@
+-- blayzen-sip Database Schema
+-- Version: 045_route_change_notify
+
+-- =============================================================================
+-- Route change notifications
+-- =============================================================================
+-- Every statement changing routes or trunks, whether through the API, direct
+-- SQL or another tool, notifies the route_changes channel with the table's
+-- name. Instances listening on it drop their cached routes at once instead
+-- of serving stale ones until CACHE_ROUTE_TTL runs out.
+CREATE OR REPLACE FUNCTION notify_route_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('route_changes', TG_TABLE_NAME);
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS notify_routes_changed ON sip_routes;
+CREATE TRIGGER notify_routes_changed
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON sip_routes
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_route_change();
+
+DROP TRIGGER IF EXISTS notify_trunks_changed ON sip_trunks;
+CREATE TRIGGER notify_trunks_changed
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON sip_trunks
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_route_change();
+
+INSERT INTO schema_migrations (version) VALUES ('045_route_change_notify') ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,20 @@
+-- blayzen-sip Database Schema
+-- Version: 046_sip_domains
+
+-- =============================================================================
+-- SIP Domains
+-- =============================================================================
+-- Domains an account's callers address INVITEs to (the Request-URI host), so
+-- calls coming from neither one of its trunks nor with its SIP credentials are
+-- still attributed to it, and only its routes are considered. A domain
+-- belongs to one account.
+CREATE TABLE IF NOT EXISTS sip_domains (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    account_id UUID NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    domain VARCHAR(253) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sip_domains_account_id ON sip_domains(account_id);
+
+INSERT INTO schema_migrations (version) VALUES ('046_sip_domains') ON CONFLICT (version) DO NOTHING;
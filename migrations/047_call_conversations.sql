@@ -0,0 +1,15 @@
+-- blayzen-sip Database Schema
+-- Version: 047_call_conversations
+
+-- =============================================================================
+-- Call Logs: conversations
+-- =============================================================================
+-- The conversation a call belongs to, linking the calls of one customer
+-- journey: retries and callbacks from the same caller, transfers back to us
+-- and campaign attempts sharing an X-Conversation-ID. A call starting a
+-- conversation gets a new ID. NULL for calls from before this migration.
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS conversation_id UUID;
+
+CREATE INDEX IF NOT EXISTS idx_calls_conversation ON call_logs(account_id, conversation_id) WHERE conversation_id IS NOT NULL;
+
+INSERT INTO schema_migrations (version) VALUES ('047_call_conversations') ON CONFLICT (version) DO NOTHING;
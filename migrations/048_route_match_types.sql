@@ -0,0 +1,16 @@
+-- blayzen-sip Database Schema
+-- Version: 048_route_match_types
+
+-- =============================================================================
+-- Routes: number match types
+-- =============================================================================
+-- How match_to_user and match_from_user compare a call's numbers: the whole
+-- number (exact), its start (prefix), a regular expression (regex) or a
+-- number pattern of digits, X for any one digit and * for any rest, as
+-- +4420* (pattern). Existing routes keep matching exactly.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS match_to_user_type VARCHAR(16) NOT NULL DEFAULT 'exact'
+    CHECK (match_to_user_type IN ('exact', 'prefix', 'regex', 'pattern'));
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS match_from_user_type VARCHAR(16) NOT NULL DEFAULT 'exact'
+    CHECK (match_from_user_type IN ('exact', 'prefix', 'regex', 'pattern'));
+
+INSERT INTO schema_migrations (version) VALUES ('048_route_match_types') ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,29 @@
+-- blayzen-sip Database Schema
+-- Version: 049_route_maintenance
+
+-- =============================================================================
+-- Route Maintenance Windows
+-- =============================================================================
+-- Periods during which a route's calls are turned away, so one agent can be
+-- taken offline without touching global state: rejected with status_code
+-- and reason, after an announcement when one is set, or redirected (302) to
+-- divert_to. Windows past ends_at no longer apply and are left for history
+-- until their route is deleted.
+CREATE TABLE IF NOT EXISTS route_maintenance_windows (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    account_id UUID NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    route_id UUID NOT NULL REFERENCES sip_routes(id) ON DELETE CASCADE,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 503 CHECK (status_code BETWEEN 400 AND 699),
+    reason VARCHAR(255),
+    divert_to VARCHAR(255),
+    announcement VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_maintenance_route ON route_maintenance_windows(route_id, ends_at);
+CREATE INDEX IF NOT EXISTS idx_route_maintenance_ends_at ON route_maintenance_windows(ends_at);
+
+INSERT INTO schema_migrations (version) VALUES ('049_route_maintenance') ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,13 @@
+-- blayzen-sip Database Schema
+-- Version: 050_route_schedules
+
+-- =============================================================================
+-- Routes: schedules
+-- =============================================================================
+-- When a route takes calls: weekdays, time of day ranges and holidays in a
+-- timezone, and the agent taking calls outside them, if any; without one the
+-- route doesn't match those calls, leaving them to lower-priority routes.
+-- NULL takes calls at any time.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS schedule JSONB;
+
+INSERT INTO schema_migrations (version) VALUES ('050_route_schedules') ON CONFLICT (version) DO NOTHING;
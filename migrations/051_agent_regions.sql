@@ -0,0 +1,21 @@
+-- blayzen-sip Database Schema
+-- Version: 051_agent_regions
+
+-- =============================================================================
+-- Routes: agent regions
+-- =============================================================================
+-- Agent pools by region, as [{"region": "eu-west", "urls": [...]}]. Calls
+-- go to the pool in the instance's own region (REGION) while it's healthy,
+-- then to the other healthy ones, fastest first, ahead of the route's
+-- agent_urls and fallbacks. Empty sends every call to those.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS agent_regions JSONB NOT NULL DEFAULT '[]';
+
+-- =============================================================================
+-- Call logs: agent regions
+-- =============================================================================
+-- The region of the agent a call was connected to, NULL for agents outside
+-- its route's regions, and how the regions were ranked when it arrived.
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS agent_region VARCHAR(64);
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS region_decision JSONB;
+
+INSERT INTO schema_migrations (version) VALUES ('051_agent_regions') ON CONFLICT (version) DO NOTHING;
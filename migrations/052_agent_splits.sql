@@ -0,0 +1,23 @@
+-- blayzen-sip Database Schema
+-- Version: 052_agent_splits
+
+-- =============================================================================
+-- Routes: agent splits
+-- =============================================================================
+-- Weighted agents sharing a route's calls, as [{"name": "agent-v2",
+-- "websocket_url": "...", "weight": 10}], for trying a new agent on a share
+-- of live traffic. Each call is assigned one by split_strategy: drawn by
+-- weight (random), or by a hash of the caller's number (caller). The
+-- route's other agents remain the failover. Empty doesn't split calls.
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS agent_splits JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE sip_routes ADD COLUMN IF NOT EXISTS split_strategy VARCHAR(16) NOT NULL DEFAULT 'random'
+    CHECK (split_strategy IN ('random', 'caller'));
+
+-- =============================================================================
+-- Call logs: agent split targets
+-- =============================================================================
+-- The agent split a call was assigned, NULL on routes without splits;
+-- summarised per target by GET /api/v1/routes/{id}/splits.
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS split_target VARCHAR(64);
+
+INSERT INTO schema_migrations (version) VALUES ('052_agent_splits') ON CONFLICT (version) DO NOTHING;
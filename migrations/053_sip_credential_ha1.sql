@@ -0,0 +1,34 @@
+-- blayzen-sip Database Schema
+-- Version: 053_sip_credential_ha1
+
+-- =============================================================================
+-- SIP Credentials: digest HA1
+-- =============================================================================
+-- SIP credentials are kept as their digest HA1, MD5(username:realm:password),
+-- all digest authentication needs, instead of the password. HA1 is bound to
+-- a realm, so each credential records the one it was saved for, and
+-- usernames are unique within an account and realm instead of across
+-- accounts.
+ALTER TABLE sip_credentials ADD COLUMN IF NOT EXISTS realm VARCHAR(255) NOT NULL DEFAULT 'blayzen-sip';
+ALTER TABLE sip_credentials ADD COLUMN IF NOT EXISTS ha1 CHAR(32);
+
+-- Hash the plaintext passwords, taken to be saved for the default realm
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'sip_credentials' AND column_name = 'password'
+    ) THEN
+        UPDATE sip_credentials SET ha1 = md5(username || ':' || realm || ':' || password);
+        ALTER TABLE sip_credentials DROP COLUMN password;
+    END IF;
+END $$;
+
+ALTER TABLE sip_credentials ALTER COLUMN ha1 SET NOT NULL;
+ALTER TABLE sip_credentials ALTER COLUMN realm DROP DEFAULT;
+
+ALTER TABLE sip_credentials DROP CONSTRAINT IF EXISTS sip_credentials_username_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sip_credentials_account_realm_username ON sip_credentials(account_id, realm, username);
+CREATE INDEX IF NOT EXISTS idx_sip_credentials_realm_username ON sip_credentials(realm, username);
+
+INSERT INTO schema_migrations (version) VALUES ('053_sip_credential_ha1') ON CONFLICT (version) DO NOTHING;
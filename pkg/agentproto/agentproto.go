@@ -0,0 +1,121 @@
+// Package agentproto defines the blayzen-sip extensions to the exotel agent
+// WebSocket protocol (github.com/shiv6146/blayzen/pkg/protocol/exotel), for
+// agents and other components talking to blayzen-sip
+package agentproto
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// EventDTMF is the event name of DTMF messages, in both directions
+const EventDTMF = "dtmf"
+
+// CustomDataMediaFormat is the start message custom data key announcing the
+// audio format used on the WebSocket
+const CustomDataMediaFormat = "media_format"
+
+// CustomDataAMDResult is the start message custom data key carrying the
+// answering machine detection result (human or notsure) of calls whose agent
+// was connected only after detection
+const CustomDataAMDResult = "amd_result"
+
+// CustomDataResume is the start message custom data key set to true when the
+// agent connection is re-established mid-call. The stream SID is unchanged and
+// caller audio from the gap follows.
+const CustomDataResume = "resume"
+
+// CustomDataLanguage is the start message custom data key carrying the
+// callers' language set on the route, a BCP 47 tag such as "es-MX"
+const CustomDataLanguage = "language"
+
+// CustomDataConversationID is the start message custom data key carrying the
+// ID of the conversation the call belongs to, shared by the calls of one
+// customer journey (retries, callbacks, transfers, campaign attempts)
+const CustomDataConversationID = "conversation_id"
+
+// Audio encodings used on the WebSocket
+const (
+	EncodingMulaw = "mulaw" // G.711 mu-law, one byte per sample
+	EncodingL16   = "l16"   // Signed 16-bit little-endian linear PCM
+)
+
+// DTMFMessage is a keypad digit: sent to the agent when the caller presses a
+// key, and sent by the agent to play a digit to the caller
+type DTMFMessage struct {
+	Event     string      `json:"event"`
+	StreamSID string      `json:"stream_sid"`
+	DTMF      DTMFPayload `json:"dtmf"`
+}
+
+// DTMFPayload carries the digit(s) and the tone duration in milliseconds
+type DTMFPayload struct {
+	Digit    string `json:"digit"`
+	Duration string `json:"duration"`
+}
+
+// NewDTMFMessage creates a DTMF message
+func NewDTMFMessage(streamSID, digit string, durationMs int) *DTMFMessage {
+	return &DTMFMessage{
+		Event:     EventDTMF,
+		StreamSID: streamSID,
+		DTMF: DTMFPayload{
+			Digit:    digit,
+			Duration: strconv.Itoa(durationMs),
+		},
+	}
+}
+
+// ParseDTMFMessage decodes a DTMF message
+func ParseDTMFMessage(data []byte) (*DTMFMessage, error) {
+	var msg DTMFMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("agentproto: invalid dtmf message: %w", err)
+	}
+	return &msg, nil
+}
+
+// DurationMs returns the tone duration, or fallback when unset or invalid
+func (m *DTMFMessage) DurationMs(fallback int) int {
+	if d, err := strconv.Atoi(m.DTMF.Duration); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// MediaFormat describes the audio exchanged on the WebSocket
+type MediaFormat struct {
+	Encoding   string `json:"encoding"`
+	SampleRate int    `json:"sample_rate"`
+	ChunkMs    int    `json:"chunk_ms"`
+}
+
+// DefaultMediaFormat is used when the start message announces no format:
+// the caller's native 8kHz mu-law in 20ms chunks
+var DefaultMediaFormat = MediaFormat{
+	Encoding:   EncodingMulaw,
+	SampleRate: 8000,
+	ChunkMs:    20,
+}
+
+// MediaFormatFromCustomData extracts the media format announced in a start
+// message's custom data, falling back to DefaultMediaFormat
+func MediaFormatFromCustomData(customData map[string]interface{}) MediaFormat {
+	raw, ok := customData[CustomDataMediaFormat]
+	if !ok {
+		return DefaultMediaFormat
+	}
+
+	// Round-trip through JSON to accept both decoded maps and structs
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return DefaultMediaFormat
+	}
+
+	format := DefaultMediaFormat
+	if err := json.Unmarshal(data, &format); err != nil {
+		return DefaultMediaFormat
+	}
+	return format
+}
@@ -0,0 +1,345 @@
+package agentproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDTMFMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      *DTMFMessage
+		json     string
+		duration int // DurationMs with a fallback of 250
+	}{
+		{
+			name:     "digit",
+			msg:      NewDTMFMessage("MZ1", "5", 160),
+			json:     `{"event":"dtmf","stream_sid":"MZ1","dtmf":{"digit":"5","duration":"160"}}`,
+			duration: 160,
+		},
+		{
+			name:     "digits",
+			msg:      NewDTMFMessage("MZ1", "12#", 100),
+			json:     `{"event":"dtmf","stream_sid":"MZ1","dtmf":{"digit":"12#","duration":"100"}}`,
+			duration: 100,
+		},
+		{
+			name:     "zero duration falls back",
+			msg:      NewDTMFMessage("MZ1", "*", 0),
+			json:     `{"event":"dtmf","stream_sid":"MZ1","dtmf":{"digit":"*","duration":"0"}}`,
+			duration: 250,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.msg)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("Marshal() = %s, want %s", data, tt.json)
+			}
+
+			got, err := ParseDTMFMessage(data)
+			if err != nil {
+				t.Fatalf("ParseDTMFMessage() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("ParseDTMFMessage() = %+v, want %+v", got, tt.msg)
+			}
+			if d := got.DurationMs(250); d != tt.duration {
+				t.Errorf("DurationMs(250) = %d, want %d", d, tt.duration)
+			}
+		})
+	}
+}
+
+func TestDTMFDurationFromAgents(t *testing.T) {
+	tests := []struct {
+		json string
+		want int
+	}{
+		{`{"event":"dtmf","dtmf":{"digit":"1"}}`, 200},
+		{`{"event":"dtmf","dtmf":{"digit":"1","duration":"abc"}}`, 200},
+		{`{"event":"dtmf","dtmf":{"digit":"1","duration":"-5"}}`, 200},
+		{`{"event":"dtmf","dtmf":{"digit":"1","duration":"400"}}`, 400},
+	}
+
+	for _, tt := range tests {
+		msg, err := ParseDTMFMessage([]byte(tt.json))
+		if err != nil {
+			t.Fatalf("ParseDTMFMessage(%s) error = %v", tt.json, err)
+		}
+		if got := msg.DurationMs(200); got != tt.want {
+			t.Errorf("DurationMs(200) of %s = %d, want %d", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	parsers := map[string]func([]byte) error{
+		"dtmf":     func(d []byte) error { _, err := ParseDTMFMessage(d); return err },
+		"answer":   func(d []byte) error { _, err := ParseAnswerMessage(d); return err },
+		"fax":      func(d []byte) error { _, err := ParseFaxMessage(d); return err },
+		"hold":     func(d []byte) error { _, err := ParseHoldMessage(d); return err },
+		"summary":  func(d []byte) error { _, err := ParseSummaryMessage(d); return err },
+		"transfer": func(d []byte) error { _, err := ParseTransferMessage(d); return err },
+		"twilio":   func(d []byte) error { _, err := ParseTwilioMessage(d); return err },
+	}
+
+	for name, parse := range parsers {
+		for _, data := range []string{"", "{", "[]", `{"event":1}`} {
+			if err := parse([]byte(data)); err == nil {
+				t.Errorf("%s: parsing %q succeeded, want an error", name, data)
+			}
+		}
+	}
+}
+
+func TestControlMessages(t *testing.T) {
+	summary := CallSummary{
+		CallID:         "call-1",
+		DurationMs:     61000,
+		TalkMs:         60000,
+		HangupCause:    "normal_clearing",
+		HangupParty:    "caller",
+		ChunksSent:     3000,
+		ChunksReceived: 2990,
+		Quality:        CallQuality{PacketsReceived: 3000, PacketsSent: 2990, PacketsLost: 2, PacketsLate: 1, JitterMs: 1.5},
+	}
+
+	tests := []struct {
+		name  string
+		msg   any
+		json  string
+		parse func([]byte) (any, error)
+	}{
+		{
+			name:  "answer",
+			msg:   NewAnswerMessage("MZ1"),
+			json:  `{"event":"answer","stream_sid":"MZ1"}`,
+			parse: func(d []byte) (any, error) { return ParseAnswerMessage(d) },
+		},
+		{
+			name:  "fax",
+			msg:   NewFaxMessage("MZ1", FaxSourceT38),
+			json:  `{"event":"fax","stream_sid":"MZ1","fax":{"source":"t38"}}`,
+			parse: func(d []byte) (any, error) { return ParseFaxMessage(d) },
+		},
+		{
+			name:  "hold",
+			msg:   NewHoldMessage("MZ1", true),
+			json:  `{"event":"hold","stream_sid":"MZ1"}`,
+			parse: func(d []byte) (any, error) { return ParseHoldMessage(d) },
+		},
+		{
+			name:  "resume",
+			msg:   NewHoldMessage("MZ1", false),
+			json:  `{"event":"resume","stream_sid":"MZ1"}`,
+			parse: func(d []byte) (any, error) { return ParseHoldMessage(d) },
+		},
+		{
+			name:  "transfer",
+			msg:   NewTransferMessage("MZ1", "sip:support@example.com"),
+			json:  `{"event":"transfer","stream_sid":"MZ1","transfer":{"target":"sip:support@example.com"}}`,
+			parse: func(d []byte) (any, error) { return ParseTransferMessage(d) },
+		},
+		{
+			name: "summary",
+			msg:  NewSummaryMessage("MZ1", summary),
+			json: `{"event":"summary","stream_sid":"MZ1","summary":{"call_id":"call-1","duration_ms":61000,"talk_ms":60000,` +
+				`"hangup_cause":"normal_clearing","hangup_party":"caller","chunks_sent":3000,"chunks_received":2990,` +
+				`"quality":{"packets_received":3000,"packets_sent":2990,"packets_lost":2,"packets_late":1,"jitter_ms":1.5}}}`,
+			parse: func(d []byte) (any, error) { return ParseSummaryMessage(d) },
+		},
+		{
+			name:  "summary without hangup",
+			msg:   NewSummaryMessage("MZ1", CallSummary{CallID: "call-2"}),
+			json:  `{"event":"summary","stream_sid":"MZ1","summary":{"call_id":"call-2","duration_ms":0,"talk_ms":0,"chunks_sent":0,"chunks_received":0,"quality":{"packets_received":0,"packets_sent":0,"packets_lost":0,"packets_late":0,"jitter_ms":0}}}`,
+			parse: func(d []byte) (any, error) { return ParseSummaryMessage(d) },
+		},
+		{
+			name: "error",
+			msg:  NewErrorMessage("MZ1", ProtocolError{Code: ErrorUnknownEvent, Message: "unknown event", Event: "dance"}),
+			json: `{"event":"error","stream_sid":"MZ1","error":{"code":"unknown_event","message":"unknown event","event":"dance"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.msg)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("Marshal() = %s, want %s", data, tt.json)
+			}
+			if tt.parse == nil {
+				return
+			}
+
+			got, err := tt.parse(data)
+			if err != nil {
+				t.Fatalf("parse error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("parse = %+v, want %+v", got, tt.msg)
+			}
+		})
+	}
+}
+
+func TestMediaFormatFromCustomData(t *testing.T) {
+	tests := []struct {
+		name       string
+		customData map[string]interface{}
+		want       MediaFormat
+	}{
+		{"none", nil, DefaultMediaFormat},
+		{
+			name:       "struct",
+			customData: map[string]interface{}{CustomDataMediaFormat: MediaFormat{Encoding: EncodingL16, SampleRate: 16000, ChunkMs: 40}},
+			want:       MediaFormat{Encoding: EncodingL16, SampleRate: 16000, ChunkMs: 40},
+		},
+		{
+			name: "decoded map",
+			customData: map[string]interface{}{CustomDataMediaFormat: map[string]interface{}{
+				"encoding": "l16", "sample_rate": float64(24000), "chunk_ms": float64(20),
+			}},
+			want: MediaFormat{Encoding: EncodingL16, SampleRate: 24000, ChunkMs: 20},
+		},
+		{
+			name:       "partial map keeps the defaults",
+			customData: map[string]interface{}{CustomDataMediaFormat: map[string]interface{}{"sample_rate": float64(16000)}},
+			want:       MediaFormat{Encoding: EncodingMulaw, SampleRate: 16000, ChunkMs: 20},
+		},
+		{
+			name:       "wrong type",
+			customData: map[string]interface{}{CustomDataMediaFormat: "l16"},
+			want:       DefaultMediaFormat,
+		},
+		{
+			name:       "unencodable",
+			customData: map[string]interface{}{CustomDataMediaFormat: func() {}},
+			want:       DefaultMediaFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MediaFormatFromCustomData(tt.customData); got != tt.want {
+				t.Errorf("MediaFormatFromCustomData() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudioFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame AudioFrame
+		data  []byte
+	}{
+		{
+			name:  "audio",
+			frame: AudioFrame{Sequence: 1, Timestamp: 20, Payload: []byte{0xff, 0x7f}},
+			data:  []byte{FrameTypeAudio, 0, 0, 0, 1, 0, 0, 0, 20, 0xff, 0x7f},
+		},
+		{
+			name:  "large counters",
+			frame: AudioFrame{Sequence: 0xfffffffe, Timestamp: 0x01020304, Payload: []byte{}},
+			data:  []byte{FrameTypeAudio, 0xff, 0xff, 0xff, 0xfe, 0x01, 0x02, 0x03, 0x04},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.frame.Marshal()
+			if !bytes.Equal(data, tt.data) {
+				t.Fatalf("Marshal() = % x, want % x", data, tt.data)
+			}
+
+			got, err := UnmarshalAudioFrame(data)
+			if err != nil {
+				t.Fatalf("UnmarshalAudioFrame() error = %v", err)
+			}
+			if got.Sequence != tt.frame.Sequence || got.Timestamp != tt.frame.Timestamp || !bytes.Equal(got.Payload, tt.frame.Payload) {
+				t.Errorf("UnmarshalAudioFrame() = %+v, want %+v", got, tt.frame)
+			}
+		})
+	}
+}
+
+func TestUnmarshalAudioFrameInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		{FrameTypeAudio, 0, 0, 0, 1, 0, 0, 0},  // Short header
+		{0x02, 0, 0, 0, 1, 0, 0, 0, 20, 0xff},  // Unknown frame type
+		[]byte(`{"event":"media","media":{}}`), // JSON
+	} {
+		if _, err := UnmarshalAudioFrame(data); !errors.Is(err, ErrInvalidAudioFrame) {
+			t.Errorf("UnmarshalAudioFrame(% x) error = %v, want ErrInvalidAudioFrame", data, err)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Unix(1700000000, 0)
+	claims := &TokenClaims{
+		Issuer:    TokenIssuer,
+		Subject:   "call-1",
+		Audience:  "wss://agent.example.com/ws",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		CallID:    "call-1",
+		StreamSID: "MZ1",
+		AccountID: "account-1",
+		RouteID:   "route-1",
+		From:      "+15550100",
+		To:        "+15550199",
+	}
+
+	token, err := SignToken(claims, secret)
+	if err != nil {
+		t.Fatalf("SignToken() error = %v", err)
+	}
+	tampered := token[:len(token)-2] + "AA"
+	if tampered == token {
+		tampered = token[:len(token)-2] + "BB"
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		secret []byte
+		now    time.Time
+		err    error
+	}{
+		{"valid", token, secret, now, nil},
+		{"just before expiry", token, secret, now.Add(time.Minute - time.Second), nil},
+		{"expired", token, secret, now.Add(time.Minute), ErrTokenExpired},
+		{"wrong secret", token, []byte("other"), now, ErrInvalidToken},
+		{"tampered signature", tampered, secret, now, ErrInvalidToken},
+		{"not a JWT", "abc", secret, now, ErrInvalidToken},
+		{"other header", "eyJhbGciOiJub25lIn0." + token[len(jwtHeader)+1:], secret, now, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyToken(tt.token, tt.secret, tt.now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("VerifyToken() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && !reflect.DeepEqual(got, claims) {
+				t.Errorf("VerifyToken() = %+v, want %+v", got, claims)
+			}
+		})
+	}
+}
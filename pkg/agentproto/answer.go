@@ -0,0 +1,33 @@
+package agentproto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EventAnswer is the event name of the message agents of early media routes
+// send to have the call answered. Twilio routes send it as a Twilio message
+// with the same event name.
+const EventAnswer = "answer"
+
+// AnswerMessage asks blayzen-sip to answer a call whose agent has been
+// streaming to the caller in early media. It is ignored once the call is
+// answered.
+type AnswerMessage struct {
+	Event     string `json:"event"`
+	StreamSID string `json:"stream_sid"`
+}
+
+// NewAnswerMessage creates an answer message
+func NewAnswerMessage(streamSID string) *AnswerMessage {
+	return &AnswerMessage{Event: EventAnswer, StreamSID: streamSID}
+}
+
+// ParseAnswerMessage decodes an answer message
+func ParseAnswerMessage(data []byte) (*AnswerMessage, error) {
+	var msg AnswerMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("agentproto: invalid answer message: %w", err)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,64 @@
+package agentproto
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// CustomDataMediaFraming is the start message custom data key announcing how
+// audio is framed on the WebSocket
+const CustomDataMediaFraming = "media_framing"
+
+// Media framings
+const (
+	FramingJSON   = "json"   // Base64 audio in JSON media messages
+	FramingBinary = "binary" // Raw audio in binary WebSocket messages
+)
+
+// FrameTypeAudio marks a binary message carrying audio
+const FrameTypeAudio = 0x01
+
+// AudioFrameHeaderSize is the size of the binary audio frame header:
+//
+//	0       1               5               9
+//	+-------+---------------+---------------+---------...
+//	| type  | sequence (BE) | timestamp (BE)| audio
+//	+-------+---------------+---------------+---------...
+//
+// The timestamp is in milliseconds of audio since the start of the stream.
+const AudioFrameHeaderSize = 9
+
+// ErrInvalidAudioFrame is returned for binary messages that are not audio frames
+var ErrInvalidAudioFrame = errors.New("agentproto: invalid binary audio frame")
+
+// AudioFrame is a chunk of audio sent as a binary WebSocket message, in the
+// format announced by media_format. Control messages stay JSON.
+type AudioFrame struct {
+	Sequence  uint32
+	Timestamp uint32
+	Payload   []byte
+}
+
+// Marshal encodes the frame
+func (f *AudioFrame) Marshal() []byte {
+	data := make([]byte, AudioFrameHeaderSize+len(f.Payload))
+	data[0] = FrameTypeAudio
+	binary.BigEndian.PutUint32(data[1:5], f.Sequence)
+	binary.BigEndian.PutUint32(data[5:9], f.Timestamp)
+	copy(data[AudioFrameHeaderSize:], f.Payload)
+	return data
+}
+
+// UnmarshalAudioFrame decodes a binary audio frame. The returned payload
+// aliases data.
+func UnmarshalAudioFrame(data []byte) (*AudioFrame, error) {
+	if len(data) < AudioFrameHeaderSize || data[0] != FrameTypeAudio {
+		return nil, ErrInvalidAudioFrame
+	}
+
+	return &AudioFrame{
+		Sequence:  binary.BigEndian.Uint32(data[1:5]),
+		Timestamp: binary.BigEndian.Uint32(data[5:9]),
+		Payload:   data[AudioFrameHeaderSize:],
+	}, nil
+}
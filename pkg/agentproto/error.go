@@ -0,0 +1,38 @@
+package agentproto
+
+// EventError is the event name of the message sent to the agent when one of
+// its messages is rejected. Twilio routes receive it as a Twilio message
+// with the same event name.
+const EventError = "error"
+
+// Why an agent message was rejected
+const (
+	ErrorMessageTooLarge = "message_too_large" // Over the size limit; discarded unread
+	ErrorMalformed       = "malformed"         // Not valid JSON, or an audio frame that doesn't parse
+	ErrorInvalid         = "invalid"           // Valid JSON missing or with bad fields for its event
+	ErrorUnknownEvent    = "unknown_event"     // An event blayzen-sip doesn't take from agents
+)
+
+// ErrorMessage tells the agent one of its messages was rejected. Rejected
+// messages are otherwise ignored; an agent sending too many is disconnected.
+type ErrorMessage struct {
+	Event     string        `json:"event"`
+	StreamSID string        `json:"stream_sid"`
+	Error     ProtocolError `json:"error"`
+}
+
+// ProtocolError describes a rejected agent message
+type ProtocolError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Event   string `json:"event,omitempty"` // The rejected message's event, when known
+}
+
+// NewErrorMessage creates an error message
+func NewErrorMessage(streamSID string, protocolError ProtocolError) *ErrorMessage {
+	return &ErrorMessage{
+		Event:     EventError,
+		StreamSID: streamSID,
+		Error:     protocolError,
+	}
+}
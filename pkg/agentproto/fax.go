@@ -0,0 +1,49 @@
+package agentproto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EventFax is the event name of the message sent to the agent when the
+// caller turns out to be a fax machine, on routes whose fax policy is
+// notify. Twilio routes receive it as a Twilio message with the same event
+// name.
+const EventFax = "fax"
+
+// How a fax call was detected
+const (
+	FaxSourceCNG = "cng" // The calling fax machine's 1100 Hz calling tone
+	FaxSourceT38 = "t38" // A re-INVITE switching the call to T.38 fax relay
+)
+
+// FaxMessage tells the agent the caller is a fax machine. The call stays up;
+// the agent decides whether to hang up.
+type FaxMessage struct {
+	Event     string       `json:"event"`
+	StreamSID string       `json:"stream_sid"`
+	Fax       FaxDetection `json:"fax"`
+}
+
+// FaxDetection describes how a fax call was detected
+type FaxDetection struct {
+	Source string `json:"source"` // cng or t38
+}
+
+// NewFaxMessage creates a fax message
+func NewFaxMessage(streamSID, source string) *FaxMessage {
+	return &FaxMessage{
+		Event:     EventFax,
+		StreamSID: streamSID,
+		Fax:       FaxDetection{Source: source},
+	}
+}
+
+// ParseFaxMessage decodes a fax message
+func ParseFaxMessage(data []byte) (*FaxMessage, error) {
+	var msg FaxMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("agentproto: invalid fax message: %w", err)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,42 @@
+package agentproto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Event names of the messages sent to the agent when the caller's side puts
+// the call on hold (a re-INVITE with a=sendonly, a=inactive or a 0.0.0.0
+// address) and takes it off hold. Twilio routes receive them as Twilio
+// messages with the same event names.
+const (
+	EventHold   = "hold"
+	EventResume = "resume"
+)
+
+// HoldMessage tells the agent the call was put on hold or resumed. No caller
+// audio arrives while the call is on hold, and audio the agent sends isn't
+// played.
+type HoldMessage struct {
+	Event     string `json:"event"`
+	StreamSID string `json:"stream_sid"`
+}
+
+// NewHoldMessage creates a hold message, or a resume message when held is
+// false
+func NewHoldMessage(streamSID string, held bool) *HoldMessage {
+	event := EventResume
+	if held {
+		event = EventHold
+	}
+	return &HoldMessage{Event: event, StreamSID: streamSID}
+}
+
+// ParseHoldMessage decodes a hold or resume message
+func ParseHoldMessage(data []byte) (*HoldMessage, error) {
+	var msg HoldMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("agentproto: invalid hold message: %w", err)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,59 @@
+package agentproto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EventSummary is the event name of the call summary sent to the agent when
+// the call ends, just before the stop message. Twilio routes receive it as a
+// Twilio message with the same event name.
+const EventSummary = "summary"
+
+// SummaryMessage reports how a call went, so agents can log and reconcile
+// calls without the REST API
+type SummaryMessage struct {
+	Event     string      `json:"event"`
+	StreamSID string      `json:"stream_sid"`
+	Summary   CallSummary `json:"summary"`
+}
+
+// CallSummary describes an ended call. Hangup cause and party are empty when
+// unknown, e.g. when blayzen-sip shut down mid-call.
+type CallSummary struct {
+	CallID         string      `json:"call_id"`
+	DurationMs     int64       `json:"duration_ms"` // From the INVITE to the end of the call
+	TalkMs         int64       `json:"talk_ms"`     // From media start to the end of the call, 0 if never answered
+	HangupCause    string      `json:"hangup_cause,omitempty"`
+	HangupParty    string      `json:"hangup_party,omitempty"` // caller, agent or system
+	ChunksSent     int64       `json:"chunks_sent"`            // Audio chunks sent to the agent
+	ChunksReceived int64       `json:"chunks_received"`        // Audio chunks received from the agent
+	Quality        CallQuality `json:"quality"`
+}
+
+// CallQuality describes the caller's RTP stream
+type CallQuality struct {
+	PacketsReceived int64   `json:"packets_received"` // From the caller
+	PacketsSent     int64   `json:"packets_sent"`     // To the caller
+	PacketsLost     int64   `json:"packets_lost"`     // Never received in time to be played out
+	PacketsLate     int64   `json:"packets_late"`     // Received after their slot was played out
+	JitterMs        float64 `json:"jitter_ms"`        // Interarrival jitter estimate at the end of the call
+}
+
+// NewSummaryMessage creates a call summary message
+func NewSummaryMessage(streamSID string, summary CallSummary) *SummaryMessage {
+	return &SummaryMessage{
+		Event:     EventSummary,
+		StreamSID: streamSID,
+		Summary:   summary,
+	}
+}
+
+// ParseSummaryMessage decodes a call summary message
+func ParseSummaryMessage(data []byte) (*SummaryMessage, error) {
+	var msg SummaryMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("agentproto: invalid summary message: %w", err)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,85 @@
+package agentproto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenIssuer is the issuer of the tokens blayzen-sip presents to agents
+const TokenIssuer = "blayzen-sip"
+
+// Token verification errors
+var (
+	ErrInvalidToken = errors.New("agentproto: invalid token")
+	ErrTokenExpired = errors.New("agentproto: token expired")
+)
+
+// TokenClaims are the claims of the HS256 JWT sent as a bearer token in the
+// agent WebSocket handshake, identifying the call the stream belongs to
+type TokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`           // Call ID
+	Audience  string `json:"aud,omitempty"` // Agent WebSocket URL
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	CallID    string `json:"call_id"`
+	StreamSID string `json:"stream_sid"`
+	AccountID string `json:"account_id"`
+	RouteID   string `json:"route_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// jwtHeader is the fixed JOSE header of the tokens
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// SignToken encodes the claims as an HS256-signed JWT
+func SignToken(claims *TokenClaims, secret []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + sign(signingInput, secret), nil
+}
+
+// VerifyToken checks a token's signature and expiry and returns its claims
+func VerifyToken(token string, secret []byte, now time.Time) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, ErrInvalidToken
+	}
+
+	expected := sign(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// sign computes the base64url HMAC-SHA256 signature of the signing input
+func sign(signingInput string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
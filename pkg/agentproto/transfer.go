@@ -0,0 +1,42 @@
+package agentproto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EventTransfer is the event name of the message agents send to transfer
+// the caller elsewhere. Twilio routes send it as a Twilio message with the
+// same event name.
+const EventTransfer = "transfer"
+
+// TransferMessage asks blayzen-sip to transfer the caller to a phone number
+// or SIP URI. The call ends for the agent once the transfer is accepted.
+type TransferMessage struct {
+	Event     string          `json:"event"`
+	StreamSID string          `json:"stream_sid"`
+	Transfer  TransferRequest `json:"transfer"`
+}
+
+// TransferRequest names where the caller is transferred
+type TransferRequest struct {
+	Target string `json:"target"` // Phone number, or sip:, sips: or tel: URI
+}
+
+// NewTransferMessage creates a transfer message
+func NewTransferMessage(streamSID, target string) *TransferMessage {
+	return &TransferMessage{
+		Event:     EventTransfer,
+		StreamSID: streamSID,
+		Transfer:  TransferRequest{Target: target},
+	}
+}
+
+// ParseTransferMessage decodes a transfer message
+func ParseTransferMessage(data []byte) (*TransferMessage, error) {
+	var msg TransferMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("agentproto: invalid transfer message: %w", err)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,131 @@
+package agentproto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Twilio Media Streams events
+const (
+	TwilioEventConnected = "connected"
+	TwilioEventStart     = "start"
+	TwilioEventMedia     = "media"
+	TwilioEventDTMF      = "dtmf"
+	TwilioEventMark      = "mark"
+	TwilioEventClear     = "clear"
+	TwilioEventStop      = "stop"
+
+	// TwilioEventSummary is a blayzen-sip extension, the call summary sent
+	// before stop
+	TwilioEventSummary = EventSummary
+
+	// TwilioEventFax is a blayzen-sip extension, sent when the caller turns
+	// out to be a fax machine
+	TwilioEventFax = EventFax
+
+	// TwilioEventTransfer is a blayzen-sip extension, sent by agents to
+	// transfer the caller
+	TwilioEventTransfer = EventTransfer
+
+	// TwilioEventHold and TwilioEventResume are blayzen-sip extensions,
+	// sent when the caller's side puts the call on hold and resumes it
+	TwilioEventHold   = EventHold
+	TwilioEventResume = EventResume
+
+	// TwilioEventAnswer is a blayzen-sip extension, sent by agents of early
+	// media routes to have the call answered
+	TwilioEventAnswer = EventAnswer
+
+	// TwilioEventError is a blayzen-sip extension, sent when an agent
+	// message is rejected
+	TwilioEventError = EventError
+)
+
+// Tracks of the caller's audio and keypad input
+const (
+	TwilioTrackInbound     = "inbound"
+	TwilioTrackInboundDTMF = "inbound_track"
+)
+
+// TwilioMessage is a Twilio Media Streams message. Only the field matching the
+// event is set.
+type TwilioMessage struct {
+	Event          string `json:"event"`
+	SequenceNumber string `json:"sequenceNumber,omitempty"`
+	StreamSID      string `json:"streamSid,omitempty"`
+
+	// connected
+	Protocol string `json:"protocol,omitempty"`
+	Version  string `json:"version,omitempty"`
+
+	Start *TwilioStart `json:"start,omitempty"`
+	Media *TwilioMedia `json:"media,omitempty"`
+	DTMF  *TwilioDTMF  `json:"dtmf,omitempty"`
+	Mark  *TwilioMark  `json:"mark,omitempty"`
+	Stop  *TwilioStop  `json:"stop,omitempty"`
+
+	Summary  *CallSummary     `json:"summary,omitempty"`
+	Fax      *FaxDetection    `json:"fax,omitempty"`
+	Transfer *TransferRequest `json:"transfer,omitempty"`
+	Error    *ProtocolError   `json:"error,omitempty"`
+}
+
+// TwilioStart describes the stream in the start message
+type TwilioStart struct {
+	StreamSID        string                 `json:"streamSid"`
+	AccountSID       string                 `json:"accountSid"`
+	CallSID          string                 `json:"callSid"`
+	Tracks           []string               `json:"tracks"`
+	CustomParameters map[string]interface{} `json:"customParameters"`
+	MediaFormat      TwilioMediaFormat      `json:"mediaFormat"`
+}
+
+// TwilioMediaFormat is the audio format announced in the start message
+type TwilioMediaFormat struct {
+	Encoding   string `json:"encoding"`
+	SampleRate int    `json:"sampleRate"`
+	Channels   int    `json:"channels"`
+}
+
+// TwilioMedia is a chunk of base64-encoded audio. Agents only set Payload.
+type TwilioMedia struct {
+	Track     string `json:"track,omitempty"`
+	Chunk     string `json:"chunk,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Payload   string `json:"payload"`
+}
+
+// TwilioDTMF is a keypad digit pressed by the caller
+type TwilioDTMF struct {
+	Track string `json:"track"`
+	Digit string `json:"digit"`
+}
+
+// TwilioMark names a point in the agent's audio; it is echoed back once the
+// audio before it has played
+type TwilioMark struct {
+	Name string `json:"name"`
+}
+
+// TwilioStop ends the stream
+type TwilioStop struct {
+	AccountSID string `json:"accountSid"`
+	CallSID    string `json:"callSid"`
+}
+
+// ParseTwilioMessage decodes a Twilio Media Streams message
+func ParseTwilioMessage(data []byte) (*TwilioMessage, error) {
+	var msg TwilioMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("agentproto: invalid twilio message: %w", err)
+	}
+	return &msg, nil
+}
+
+// TwilioEncoding returns the MIME type Twilio uses for an audio encoding
+func TwilioEncoding(encoding string) string {
+	if encoding == EncodingL16 {
+		return "audio/x-l16"
+	}
+	return "audio/x-mulaw"
+}
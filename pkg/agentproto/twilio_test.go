@@ -0,0 +1,153 @@
+package agentproto
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTwilioMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *TwilioMessage
+		json string
+	}{
+		{
+			name: "connected",
+			msg:  &TwilioMessage{Event: TwilioEventConnected, Protocol: "Call", Version: "1.0.0"},
+			json: `{"event":"connected","protocol":"Call","version":"1.0.0"}`,
+		},
+		{
+			name: "start",
+			msg: &TwilioMessage{
+				Event:          TwilioEventStart,
+				SequenceNumber: "1",
+				StreamSID:      "MZ1",
+				Start: &TwilioStart{
+					StreamSID:        "MZ1",
+					AccountSID:       "account-1",
+					CallSID:          "call-1",
+					Tracks:           []string{TwilioTrackInbound},
+					CustomParameters: map[string]interface{}{"from": "+15550100"},
+					MediaFormat:      TwilioMediaFormat{Encoding: "audio/x-mulaw", SampleRate: 8000, Channels: 1},
+				},
+			},
+			json: `{"event":"start","sequenceNumber":"1","streamSid":"MZ1","start":{"streamSid":"MZ1","accountSid":"account-1",` +
+				`"callSid":"call-1","tracks":["inbound"],"customParameters":{"from":"+15550100"},` +
+				`"mediaFormat":{"encoding":"audio/x-mulaw","sampleRate":8000,"channels":1}}}`,
+		},
+		{
+			name: "media from the caller",
+			msg: &TwilioMessage{
+				Event:          TwilioEventMedia,
+				SequenceNumber: "2",
+				StreamSID:      "MZ1",
+				Media:          &TwilioMedia{Track: TwilioTrackInbound, Chunk: "1", Timestamp: "20", Payload: "/38="},
+			},
+			json: `{"event":"media","sequenceNumber":"2","streamSid":"MZ1","media":{"track":"inbound","chunk":"1","timestamp":"20","payload":"/38="}}`,
+		},
+		{
+			name: "media from the agent",
+			msg:  &TwilioMessage{Event: TwilioEventMedia, StreamSID: "MZ1", Media: &TwilioMedia{Payload: "/38="}},
+			json: `{"event":"media","streamSid":"MZ1","media":{"payload":"/38="}}`,
+		},
+		{
+			name: "dtmf",
+			msg:  &TwilioMessage{Event: TwilioEventDTMF, StreamSID: "MZ1", DTMF: &TwilioDTMF{Track: TwilioTrackInboundDTMF, Digit: "#"}},
+			json: `{"event":"dtmf","streamSid":"MZ1","dtmf":{"track":"inbound_track","digit":"#"}}`,
+		},
+		{
+			name: "mark",
+			msg:  &TwilioMessage{Event: TwilioEventMark, StreamSID: "MZ1", Mark: &TwilioMark{Name: "greeting"}},
+			json: `{"event":"mark","streamSid":"MZ1","mark":{"name":"greeting"}}`,
+		},
+		{
+			name: "clear",
+			msg:  &TwilioMessage{Event: TwilioEventClear, StreamSID: "MZ1"},
+			json: `{"event":"clear","streamSid":"MZ1"}`,
+		},
+		{
+			name: "stop",
+			msg:  &TwilioMessage{Event: TwilioEventStop, StreamSID: "MZ1", Stop: &TwilioStop{AccountSID: "account-1", CallSID: "call-1"}},
+			json: `{"event":"stop","streamSid":"MZ1","stop":{"accountSid":"account-1","callSid":"call-1"}}`,
+		},
+		{
+			name: "fax",
+			msg:  &TwilioMessage{Event: TwilioEventFax, StreamSID: "MZ1", Fax: &FaxDetection{Source: FaxSourceCNG}},
+			json: `{"event":"fax","streamSid":"MZ1","fax":{"source":"cng"}}`,
+		},
+		{
+			name: "transfer",
+			msg:  &TwilioMessage{Event: TwilioEventTransfer, StreamSID: "MZ1", Transfer: &TransferRequest{Target: "+15550123"}},
+			json: `{"event":"transfer","streamSid":"MZ1","transfer":{"target":"+15550123"}}`,
+		},
+		{
+			name: "hold",
+			msg:  &TwilioMessage{Event: TwilioEventHold, StreamSID: "MZ1"},
+			json: `{"event":"hold","streamSid":"MZ1"}`,
+		},
+		{
+			name: "answer",
+			msg:  &TwilioMessage{Event: TwilioEventAnswer, StreamSID: "MZ1"},
+			json: `{"event":"answer","streamSid":"MZ1"}`,
+		},
+		{
+			name: "summary",
+			msg:  &TwilioMessage{Event: TwilioEventSummary, StreamSID: "MZ1", Summary: &CallSummary{CallID: "call-1", DurationMs: 1000}},
+			json: `{"event":"summary","streamSid":"MZ1","summary":{"call_id":"call-1","duration_ms":1000,"talk_ms":0,"chunks_sent":0,"chunks_received":0,` +
+				`"quality":{"packets_received":0,"packets_sent":0,"packets_lost":0,"packets_late":0,"jitter_ms":0}}}`,
+		},
+		{
+			name: "error",
+			msg:  &TwilioMessage{Event: TwilioEventError, StreamSID: "MZ1", Error: &ProtocolError{Code: ErrorMalformed, Message: "not JSON"}},
+			json: `{"event":"error","streamSid":"MZ1","error":{"code":"malformed","message":"not JSON"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.msg)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("Marshal() = %s, want %s", data, tt.json)
+			}
+
+			got, err := ParseTwilioMessage(data)
+			if err != nil {
+				t.Fatalf("ParseTwilioMessage() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("ParseTwilioMessage() = %+v, want %+v", got, tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseTwilioMessageIgnoresUnknownFields(t *testing.T) {
+	msg, err := ParseTwilioMessage([]byte(`{"event":"media","streamSid":"MZ1","media":{"payload":"AA=="},"extra":{"a":1}}`))
+	if err != nil {
+		t.Fatalf("ParseTwilioMessage() error = %v", err)
+	}
+	if msg.Event != TwilioEventMedia || msg.Media == nil || msg.Media.Payload != "AA==" {
+		t.Errorf("ParseTwilioMessage() = %+v", msg)
+	}
+}
+
+func TestTwilioEncoding(t *testing.T) {
+	tests := []struct {
+		encoding string
+		want     string
+	}{
+		{EncodingMulaw, "audio/x-mulaw"},
+		{EncodingL16, "audio/x-l16"},
+		{"", "audio/x-mulaw"},
+	}
+
+	for _, tt := range tests {
+		if got := TwilioEncoding(tt.encoding); got != tt.want {
+			t.Errorf("TwilioEncoding(%q) = %q, want %q", tt.encoding, got, tt.want)
+		}
+	}
+}
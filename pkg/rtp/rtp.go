@@ -0,0 +1,113 @@
+// Package rtp implements RTP packet encoding and decoding (RFC 3550)
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Version is the RTP version carried in every packet
+const Version = 2
+
+// HeaderSize is the size of the fixed RTP header, without CSRCs or extension
+const HeaderSize = 12
+
+// Static payload types (RFC 3551)
+const (
+	PayloadTypePCMU = 0
+	PayloadTypePCMA = 8
+)
+
+// ErrShortPacket is returned when a packet is too short for its header
+var ErrShortPacket = errors.New("rtp: packet too short")
+
+// Packet is an RTP packet. Header extensions are skipped when decoding and
+// never written.
+type Packet struct {
+	PayloadType    uint8
+	Marker         bool
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	CSRC           []uint32
+	Payload        []byte
+}
+
+// IsRTCP reports whether a packet received on a port RTP shares with RTCP
+// (rtcp-mux, RFC 5761) is RTCP. RTCP packet types 192-223 sit where RTP's
+// marker bit and payload type would be, in a range RTP payload types avoid.
+func IsRTCP(data []byte) bool {
+	return len(data) >= 2 && data[1] >= 192 && data[1] <= 223
+}
+
+// Unmarshal decodes an RTP packet. The returned payload aliases data.
+func Unmarshal(data []byte) (*Packet, error) {
+	if len(data) < HeaderSize {
+		return nil, ErrShortPacket
+	}
+	if v := data[0] >> 6; v != Version {
+		return nil, fmt.Errorf("rtp: unsupported version %d", v)
+	}
+
+	p := &Packet{
+		PayloadType:    data[1] & 0x7f,
+		Marker:         data[1]&0x80 != 0,
+		SequenceNumber: binary.BigEndian.Uint16(data[2:4]),
+		Timestamp:      binary.BigEndian.Uint32(data[4:8]),
+		SSRC:           binary.BigEndian.Uint32(data[8:12]),
+	}
+
+	offset := HeaderSize
+	csrcCount := int(data[0] & 0x0f)
+	if len(data) < offset+4*csrcCount {
+		return nil, ErrShortPacket
+	}
+	for i := 0; i < csrcCount; i++ {
+		p.CSRC = append(p.CSRC, binary.BigEndian.Uint32(data[offset:offset+4]))
+		offset += 4
+	}
+
+	// Skip the header extension, if any
+	if data[0]&0x10 != 0 {
+		if len(data) < offset+4 {
+			return nil, ErrShortPacket
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:offset+4]))
+		if len(data) < offset {
+			return nil, ErrShortPacket
+		}
+	}
+
+	end := len(data)
+	if data[0]&0x20 != 0 {
+		padding := int(data[end-1])
+		if padding == 0 || end-padding < offset {
+			return nil, fmt.Errorf("rtp: invalid padding length %d", padding)
+		}
+		end -= padding
+	}
+
+	p.Payload = data[offset:end]
+	return p, nil
+}
+
+// Marshal encodes the packet
+func (p *Packet) Marshal() []byte {
+	size := HeaderSize + 4*len(p.CSRC)
+	buf := make([]byte, size, size+len(p.Payload))
+
+	buf[0] = Version<<6 | byte(len(p.CSRC)&0x0f)
+	buf[1] = p.PayloadType & 0x7f
+	if p.Marker {
+		buf[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(buf[2:4], p.SequenceNumber)
+	binary.BigEndian.PutUint32(buf[4:8], p.Timestamp)
+	binary.BigEndian.PutUint32(buf[8:12], p.SSRC)
+	for i, csrc := range p.CSRC {
+		binary.BigEndian.PutUint32(buf[HeaderSize+4*i:], csrc)
+	}
+
+	return append(buf, p.Payload...)
+}
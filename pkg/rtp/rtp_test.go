@@ -0,0 +1,199 @@
+package rtp
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet *Packet
+		want   []byte
+	}{
+		{
+			name: "PCMU",
+			packet: &Packet{
+				PayloadType:    PayloadTypePCMU,
+				SequenceNumber: 0x1234,
+				Timestamp:      0x01020304,
+				SSRC:           0xdeadbeef,
+				Payload:        []byte{0xff, 0x7f},
+			},
+			want: []byte{
+				0x80, 0x00, 0x12, 0x34,
+				0x01, 0x02, 0x03, 0x04,
+				0xde, 0xad, 0xbe, 0xef,
+				0xff, 0x7f,
+			},
+		},
+		{
+			name: "marker and dynamic payload type",
+			packet: &Packet{
+				PayloadType:    101,
+				Marker:         true,
+				SequenceNumber: 0xffff,
+				Timestamp:      0xffffffff,
+				SSRC:           1,
+				Payload:        []byte{1, 0x8a, 0, 160},
+			},
+			want: []byte{
+				0x80, 0xe5, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff,
+				0x00, 0x00, 0x00, 0x01,
+				1, 0x8a, 0, 160,
+			},
+		},
+		{
+			name: "CSRCs",
+			packet: &Packet{
+				PayloadType: PayloadTypePCMA,
+				SSRC:        2,
+				CSRC:        []uint32{3, 4},
+				Payload:     []byte{0xd5},
+			},
+			want: []byte{
+				0x82, 0x08, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x02,
+				0x00, 0x00, 0x00, 0x03,
+				0x00, 0x00, 0x00, 0x04,
+				0xd5,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.packet.Marshal()
+			if !bytes.Equal(data, tt.want) {
+				t.Fatalf("Marshal() = % x, want % x", data, tt.want)
+			}
+
+			got, err := Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.packet) {
+				t.Errorf("Unmarshal(Marshal(p)) = %+v, want %+v", got, tt.packet)
+			}
+		})
+	}
+}
+
+func TestUnmarshalExtensionAndPadding(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		payload []byte
+	}{
+		{
+			name: "header extension skipped",
+			data: []byte{
+				0x90, 0x00, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x01,
+				0xbe, 0xde, 0x00, 0x01, // One word of extension
+				0x10, 0xaa, 0x00, 0x00,
+				0x01, 0x02,
+			},
+			payload: []byte{0x01, 0x02},
+		},
+		{
+			name: "padding removed",
+			data: []byte{
+				0xa0, 0x00, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x01,
+				0x01, 0x02, 0x00, 0x00, 0x03,
+			},
+			payload: []byte{0x01, 0x02},
+		},
+		{
+			name: "header only",
+			data: []byte{
+				0x80, 0x00, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x01,
+			},
+			payload: []byte{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Unmarshal(tt.data)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !bytes.Equal(p.Payload, tt.payload) {
+				t.Errorf("Payload = % x, want % x", p.Payload, tt.payload)
+			}
+		})
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	header := func(first byte, rest ...byte) []byte {
+		data := []byte{first, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+		return append(data, rest...)
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		short bool
+	}{
+		{"empty", nil, true},
+		{"shorter than the header", header(0x80)[:HeaderSize-1], true},
+		{"version 1", header(0x40), false},
+		{"missing CSRC", header(0x81, 0x00, 0x00), true},
+		{"truncated extension header", header(0x90, 0xbe, 0xde), true},
+		{"truncated extension", header(0x90, 0xbe, 0xde, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00), true},
+		{"zero padding", header(0xa0, 0x01, 0x00), false},
+		{"padding longer than payload", header(0xa0, 0x01, 0x05), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Unmarshal(tt.data)
+			if err == nil {
+				t.Fatal("Unmarshal() succeeded, want an error")
+			}
+			if short := errors.Is(err, ErrShortPacket); short != tt.short {
+				t.Errorf("Unmarshal() error = %v, want ErrShortPacket: %v", err, tt.short)
+			}
+		})
+	}
+}
+
+func TestIsRTCP(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"empty", nil, false},
+		{"one byte", []byte{0x80}, false},
+		{"sender report", []byte{0x80, 200}, true},
+		{"receiver report", []byte{0x81, 201}, true},
+		{"lowest RTCP type", []byte{0x80, 192}, true},
+		{"highest RTCP type", []byte{0x80, 223}, true},
+		{"PCMU", []byte{0x80, 0x00}, false},
+		{"PCMU with marker", []byte{0x80, 0x80}, false},
+		{"telephone-event with marker", []byte{0x80, 0x80 | 101}, false},
+		{"payload type 127 with marker", []byte{0x80, 0xff}, false},
+		{"just below the range", []byte{0x80, 191}, false},
+		{"just above the range", []byte{0x80, 224}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRTCP(tt.data); got != tt.want {
+				t.Errorf("IsRTCP(% x) = %v, want %v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
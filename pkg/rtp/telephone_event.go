@@ -0,0 +1,58 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// TelephoneEventSize is the size of a telephone-event payload
+const TelephoneEventSize = 4
+
+// DTMFEvents maps telephone-event codes (the index) to DTMF digits
+const DTMFEvents = "0123456789*#ABCD"
+
+// ErrShortTelephoneEvent is returned for truncated telephone-event payloads
+var ErrShortTelephoneEvent = errors.New("rtp: telephone-event payload too short")
+
+// TelephoneEvent is a named telephone event payload (RFC 4733), used to carry
+// DTMF digits out of band
+type TelephoneEvent struct {
+	Event    uint8
+	End      bool
+	Volume   uint8  // Power level in -dBm0 (0-63)
+	Duration uint16 // In timestamp units since the start of the event
+}
+
+// UnmarshalTelephoneEvent decodes a telephone-event payload
+func UnmarshalTelephoneEvent(payload []byte) (*TelephoneEvent, error) {
+	if len(payload) < TelephoneEventSize {
+		return nil, ErrShortTelephoneEvent
+	}
+
+	return &TelephoneEvent{
+		Event:    payload[0],
+		End:      payload[1]&0x80 != 0,
+		Volume:   payload[1] & 0x3f,
+		Duration: binary.BigEndian.Uint16(payload[2:4]),
+	}, nil
+}
+
+// Marshal encodes the telephone-event payload
+func (e *TelephoneEvent) Marshal() []byte {
+	payload := make([]byte, TelephoneEventSize)
+	payload[0] = e.Event
+	payload[1] = e.Volume & 0x3f
+	if e.End {
+		payload[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(payload[2:4], e.Duration)
+	return payload
+}
+
+// Digit returns the DTMF digit for the event, if it is one
+func (e *TelephoneEvent) Digit() (byte, bool) {
+	if int(e.Event) >= len(DTMFEvents) {
+		return 0, false
+	}
+	return DTMFEvents[e.Event], true
+}
@@ -0,0 +1,106 @@
+package rtp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTelephoneEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		event     TelephoneEvent
+		payload   []byte
+		digit     byte
+		isDigit   bool
+		wantEvent *TelephoneEvent // Decoded, when it differs from event
+	}{
+		{
+			name:    "digit 1 in progress",
+			event:   TelephoneEvent{Event: 1, Volume: 10, Duration: 160},
+			payload: []byte{0x01, 0x0a, 0x00, 0xa0},
+			digit:   '1',
+			isDigit: true,
+		},
+		{
+			name:    "star, end",
+			event:   TelephoneEvent{Event: 10, End: true, Volume: 10, Duration: 1600},
+			payload: []byte{0x0a, 0x8a, 0x06, 0x40},
+			digit:   '*',
+			isDigit: true,
+		},
+		{
+			name:    "pound",
+			event:   TelephoneEvent{Event: 11, Volume: 63, Duration: 0xffff},
+			payload: []byte{0x0b, 0x3f, 0xff, 0xff},
+			digit:   '#',
+			isDigit: true,
+		},
+		{
+			name:    "D",
+			event:   TelephoneEvent{Event: 15, End: true},
+			payload: []byte{0x0f, 0x80, 0x00, 0x00},
+			digit:   'D',
+			isDigit: true,
+		},
+		{
+			name:    "flash is not a digit",
+			event:   TelephoneEvent{Event: 16, Volume: 10, Duration: 800},
+			payload: []byte{0x10, 0x0a, 0x03, 0x20},
+		},
+		{
+			name:      "volume over 63 is truncated",
+			event:     TelephoneEvent{Event: 0, Volume: 0xff},
+			payload:   []byte{0x00, 0x3f, 0x00, 0x00},
+			digit:     '0',
+			isDigit:   true,
+			wantEvent: &TelephoneEvent{Event: 0, Volume: 63},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := tt.event.Marshal()
+			if !bytes.Equal(payload, tt.payload) {
+				t.Fatalf("Marshal() = % x, want % x", payload, tt.payload)
+			}
+
+			got, err := UnmarshalTelephoneEvent(payload)
+			if err != nil {
+				t.Fatalf("UnmarshalTelephoneEvent() error = %v", err)
+			}
+			want := &tt.event
+			if tt.wantEvent != nil {
+				want = tt.wantEvent
+			}
+			if *got != *want {
+				t.Errorf("UnmarshalTelephoneEvent() = %+v, want %+v", got, want)
+			}
+
+			digit, ok := got.Digit()
+			if digit != tt.digit || ok != tt.isDigit {
+				t.Errorf("Digit() = %q, %v, want %q, %v", digit, ok, tt.digit, tt.isDigit)
+			}
+		})
+	}
+}
+
+func TestUnmarshalTelephoneEventReservedBit(t *testing.T) {
+	// The reserved bit between E and the volume is ignored
+	got, err := UnmarshalTelephoneEvent([]byte{0x05, 0xca, 0x00, 0x50})
+	if err != nil {
+		t.Fatalf("UnmarshalTelephoneEvent() error = %v", err)
+	}
+	want := TelephoneEvent{Event: 5, End: true, Volume: 10, Duration: 80}
+	if *got != want {
+		t.Errorf("UnmarshalTelephoneEvent() = %+v, want %+v", got, want)
+	}
+}
+
+func TestUnmarshalTelephoneEventShort(t *testing.T) {
+	for _, payload := range [][]byte{nil, {0x01}, {0x01, 0x0a, 0x00}} {
+		if _, err := UnmarshalTelephoneEvent(payload); !errors.Is(err, ErrShortTelephoneEvent) {
+			t.Errorf("UnmarshalTelephoneEvent(% x) error = %v, want ErrShortTelephoneEvent", payload, err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// Package sdp parses and builds session descriptions (RFC 4566) for the
+// offer/answer exchange of audio calls
+package sdp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SessionDescription is a parsed SDP body. Lines this package does not model
+// (bandwidth, timing, encryption keys, ...) are ignored when parsing.
+type SessionDescription struct {
+	Origin            Origin
+	SessionName       string
+	ConnectionAddress string // Session-level c= address
+	Attributes        []Attribute
+	Media             []*Media
+}
+
+// Origin is the o= line
+type Origin struct {
+	Username       string
+	SessionID      uint64
+	SessionVersion uint64
+	Address        string
+}
+
+// Media is an m= section
+type Media struct {
+	Type              string // audio, video, ...
+	Port              int
+	Proto             string // RTP/AVP, RTP/SAVP, ...
+	Formats           []string
+	ConnectionAddress string // Media-level c= address, overriding the session's
+	Attributes        []Attribute
+}
+
+// Attribute is an a= line; Value is empty for property attributes (a=sendrecv)
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Parse parses an SDP body
+func Parse(data []byte) (*SessionDescription, error) {
+	desc := &SessionDescription{}
+	var media *Media
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[1] != '=' {
+			return nil, fmt.Errorf("sdp: malformed line %q", line)
+		}
+		value := line[2:]
+
+		switch line[0] {
+		case 'o':
+			origin, err := parseOrigin(value)
+			if err != nil {
+				return nil, err
+			}
+			desc.Origin = origin
+
+		case 's':
+			desc.SessionName = value
+
+		case 'c':
+			addr, err := parseConnection(value)
+			if err != nil {
+				return nil, err
+			}
+			if media != nil {
+				media.ConnectionAddress = addr
+			} else {
+				desc.ConnectionAddress = addr
+			}
+
+		case 'm':
+			m, err := parseMedia(value)
+			if err != nil {
+				return nil, err
+			}
+			media = m
+			desc.Media = append(desc.Media, m)
+
+		case 'a':
+			attr := parseAttribute(value)
+			if media != nil {
+				media.Attributes = append(media.Attributes, attr)
+			} else {
+				desc.Attributes = append(desc.Attributes, attr)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+// Marshal encodes the session description with CRLF line endings
+func (s *SessionDescription) Marshal() []byte {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\r\n")
+	}
+
+	line("v=0")
+	username := s.Origin.Username
+	if username == "" {
+		username = "-"
+	}
+	line("o=%s %d %d IN %s %s", username, s.Origin.SessionID, s.Origin.SessionVersion,
+		addressType(s.Origin.Address), s.Origin.Address)
+
+	sessionName := s.SessionName
+	if sessionName == "" {
+		sessionName = "-"
+	}
+	line("s=%s", sessionName)
+
+	if s.ConnectionAddress != "" {
+		line("c=IN %s %s", addressType(s.ConnectionAddress), s.ConnectionAddress)
+	}
+	line("t=0 0")
+	for _, a := range s.Attributes {
+		line("a=%s", a)
+	}
+
+	for _, m := range s.Media {
+		line("m=%s %d %s %s", m.Type, m.Port, m.Proto, strings.Join(m.Formats, " "))
+		if m.ConnectionAddress != "" {
+			line("c=IN %s %s", addressType(m.ConnectionAddress), m.ConnectionAddress)
+		}
+		for _, a := range m.Attributes {
+			line("a=%s", a)
+		}
+	}
+
+	return []byte(b.String())
+}
+
+// String returns the attribute as it appears after "a="
+func (a Attribute) String() string {
+	if a.Value == "" {
+		return a.Key
+	}
+	return a.Key + ":" + a.Value
+}
+
+// FirstMedia returns the first m= section of the given type, or nil
+func (s *SessionDescription) FirstMedia(mediaType string) *Media {
+	for _, m := range s.Media {
+		if m.Type == mediaType {
+			return m
+		}
+	}
+	return nil
+}
+
+// Address returns the connection address for a media section, falling back
+// to the session-level address
+func (s *SessionDescription) Address(m *Media) string {
+	if m.ConnectionAddress != "" {
+		return m.ConnectionAddress
+	}
+	return s.ConnectionAddress
+}
+
+// Attribute returns the value of the first attribute with the given key
+func (m *Media) Attribute(key string) (string, bool) {
+	for _, a := range m.Attributes {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Media directions (RFC 3264)
+const (
+	SendRecv = "sendrecv"
+	SendOnly = "sendonly"
+	RecvOnly = "recvonly"
+	Inactive = "inactive"
+)
+
+// Direction returns the direction of a media section: its own direction
+// attribute, else the session-level one, else sendrecv
+func (s *SessionDescription) Direction(m *Media) string {
+	for _, attrs := range [][]Attribute{m.Attributes, s.Attributes} {
+		for _, a := range attrs {
+			switch a.Key {
+			case SendRecv, SendOnly, RecvOnly, Inactive:
+				return a.Key
+			}
+		}
+	}
+	return SendRecv
+}
+
+// RTPMap returns the encoding name and clock rate mapped to a payload type,
+// taking the static payload types into account
+func (m *Media) RTPMap(payloadType int) (encoding string, clockRate int, ok bool) {
+	prefix := strconv.Itoa(payloadType) + " "
+	for _, a := range m.Attributes {
+		if a.Key != "rtpmap" || !strings.HasPrefix(a.Value, prefix) {
+			continue
+		}
+		// encoding/clock-rate[/channels]
+		parts := strings.Split(strings.TrimSpace(strings.TrimPrefix(a.Value, prefix)), "/")
+		if len(parts) > 1 {
+			clockRate, _ = strconv.Atoi(parts[1])
+		}
+		return parts[0], clockRate, true
+	}
+
+	switch payloadType {
+	case 0:
+		return "PCMU", 8000, true
+	case 8:
+		return "PCMA", 8000, true
+	case 9:
+		return "G722", 8000, true
+	}
+	return "", 0, false
+}
+
+// PayloadType returns the payload type offered for an encoding name
+// (case-insensitive), in order of preference
+func (m *Media) PayloadType(encoding string) (int, bool) {
+	for _, f := range m.Formats {
+		pt, err := strconv.Atoi(f)
+		if err != nil {
+			continue
+		}
+		if name, _, ok := m.RTPMap(pt); ok && strings.EqualFold(name, encoding) {
+			return pt, true
+		}
+	}
+	return 0, false
+}
+
+// parseOrigin parses "username sess-id sess-version nettype addrtype address"
+func parseOrigin(value string) (Origin, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 6 {
+		return Origin{}, fmt.Errorf("sdp: malformed origin %q", value)
+	}
+
+	id, _ := strconv.ParseUint(fields[1], 10, 64)
+	version, _ := strconv.ParseUint(fields[2], 10, 64)
+	return Origin{
+		Username:       fields[0],
+		SessionID:      id,
+		SessionVersion: version,
+		Address:        fields[5],
+	}, nil
+}
+
+// parseConnection parses "nettype addrtype address" and returns the address
+func parseConnection(value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return "", fmt.Errorf("sdp: malformed connection %q", value)
+	}
+
+	// Drop the multicast TTL/count suffix, if any
+	addr, _, _ := strings.Cut(fields[2], "/")
+	return addr, nil
+}
+
+// parseMedia parses "media port[/count] proto fmt ..."
+func parseMedia(value string) (*Media, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("sdp: malformed media %q", value)
+	}
+
+	portField, _, _ := strings.Cut(fields[1], "/")
+	port, err := strconv.Atoi(portField)
+	if err != nil {
+		return nil, fmt.Errorf("sdp: invalid media port %q", fields[1])
+	}
+
+	return &Media{
+		Type:    fields[0],
+		Port:    port,
+		Proto:   fields[2],
+		Formats: fields[3:],
+	}, nil
+}
+
+// parseAttribute splits "key:value" or a property attribute
+func parseAttribute(value string) Attribute {
+	key, val, _ := strings.Cut(value, ":")
+	return Attribute{Key: key, Value: val}
+}
+
+// addressType returns IP4 or IP6 for an address
+func addressType(addr string) string {
+	if strings.Contains(addr, ":") {
+		return "IP6"
+	}
+	return "IP4"
+}
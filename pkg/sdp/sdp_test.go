@@ -0,0 +1,337 @@
+package sdp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *SessionDescription
+	}{
+		{
+			name: "audio offer",
+			body: "v=0\r\n" +
+				"o=alice 2890844526 2890844527 IN IP4 192.0.2.10\r\n" +
+				"s=call\r\n" +
+				"c=IN IP4 192.0.2.10\r\n" +
+				"t=0 0\r\n" +
+				"m=audio 49170 RTP/AVP 0 8 101\r\n" +
+				"a=rtpmap:0 PCMU/8000\r\n" +
+				"a=rtpmap:101 telephone-event/8000\r\n" +
+				"a=fmtp:101 0-15\r\n" +
+				"a=sendrecv\r\n",
+			want: &SessionDescription{
+				Origin:            Origin{Username: "alice", SessionID: 2890844526, SessionVersion: 2890844527, Address: "192.0.2.10"},
+				SessionName:       "call",
+				ConnectionAddress: "192.0.2.10",
+				Media: []*Media{{
+					Type:    "audio",
+					Port:    49170,
+					Proto:   "RTP/AVP",
+					Formats: []string{"0", "8", "101"},
+					Attributes: []Attribute{
+						{Key: "rtpmap", Value: "0 PCMU/8000"},
+						{Key: "rtpmap", Value: "101 telephone-event/8000"},
+						{Key: "fmtp", Value: "101 0-15"},
+						{Key: "sendrecv"},
+					},
+				}},
+			},
+		},
+		{
+			name: "LF line endings, media-level connection and port count",
+			body: "v=0\n" +
+				"o=- 1 1 IN IP6 2001:db8::1\n" +
+				"s=-\n" +
+				"a=recvonly\n" +
+				"m=audio 5004/2 RTP/AVP 0\n" +
+				"c=IN IP6 2001:db8::2\n" +
+				"m=video 0 RTP/AVP 31\n",
+			want: &SessionDescription{
+				Origin:      Origin{Username: "-", SessionID: 1, SessionVersion: 1, Address: "2001:db8::1"},
+				SessionName: "-",
+				Attributes:  []Attribute{{Key: "recvonly"}},
+				Media: []*Media{
+					{Type: "audio", Port: 5004, Proto: "RTP/AVP", Formats: []string{"0"}, ConnectionAddress: "2001:db8::2"},
+					{Type: "video", Port: 0, Proto: "RTP/AVP", Formats: []string{"31"}},
+				},
+			},
+		},
+		{
+			name: "multicast connection",
+			body: "v=0\r\nc=IN IP4 233.252.0.1/127\r\nm=audio 5004 RTP/AVP 0\r\n",
+			want: &SessionDescription{
+				ConnectionAddress: "233.252.0.1",
+				Media:             []*Media{{Type: "audio", Port: 5004, Proto: "RTP/AVP", Formats: []string{"0"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"malformed line", "v=0\r\nnonsense\r\n"},
+		{"short origin", "o=- 1 1 IN IP4\r\n"},
+		{"short connection", "c=IN 192.0.2.1\r\n"},
+		{"short media", "m=audio 5004\r\n"},
+		{"media port not a number", "m=audio port RTP/AVP 0\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.body)); err == nil {
+				t.Errorf("Parse(%q) succeeded, want an error", tt.body)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		desc *SessionDescription
+		want string
+	}{
+		{
+			name: "answer",
+			desc: &SessionDescription{
+				Origin:            Origin{Username: "blayzen-sip", SessionID: 7, SessionVersion: 8, Address: "198.51.100.1"},
+				SessionName:       "blayzen-sip",
+				ConnectionAddress: "198.51.100.1",
+				Media: []*Media{{
+					Type:    "audio",
+					Port:    10000,
+					Proto:   "RTP/AVP",
+					Formats: []string{"0", "96"},
+					Attributes: []Attribute{
+						{Key: "rtpmap", Value: "0 PCMU/8000"},
+						{Key: "rtpmap", Value: "96 telephone-event/8000"},
+						{Key: "fmtp", Value: "96 0-16"},
+						{Key: "ptime", Value: "20"},
+						{Key: "sendonly"},
+					},
+				}},
+			},
+			want: "v=0\r\n" +
+				"o=blayzen-sip 7 8 IN IP4 198.51.100.1\r\n" +
+				"s=blayzen-sip\r\n" +
+				"c=IN IP4 198.51.100.1\r\n" +
+				"t=0 0\r\n" +
+				"m=audio 10000 RTP/AVP 0 96\r\n" +
+				"a=rtpmap:0 PCMU/8000\r\n" +
+				"a=rtpmap:96 telephone-event/8000\r\n" +
+				"a=fmtp:96 0-16\r\n" +
+				"a=ptime:20\r\n" +
+				"a=sendonly\r\n",
+		},
+		{
+			name: "defaults and IPv6",
+			desc: &SessionDescription{
+				Origin: Origin{Address: "2001:db8::1"},
+				Media:  []*Media{{Type: "audio", Port: 0, Proto: "RTP/AVP", Formats: []string{"0"}, ConnectionAddress: "2001:db8::2"}},
+			},
+			want: "v=0\r\n" +
+				"o=- 0 0 IN IP6 2001:db8::1\r\n" +
+				"s=-\r\n" +
+				"t=0 0\r\n" +
+				"m=audio 0 RTP/AVP 0\r\n" +
+				"c=IN IP6 2001:db8::2\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(tt.desc.Marshal()); got != tt.want {
+				t.Errorf("Marshal() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	bodies := []string{
+		"v=0\r\n" +
+			"o=alice 1 2 IN IP4 192.0.2.10\r\n" +
+			"s=call\r\n" +
+			"c=IN IP4 192.0.2.10\r\n" +
+			"t=0 0\r\n" +
+			"a=group:BUNDLE 0\r\n" +
+			"m=audio 49170 RTP/AVP 8 0 97\r\n" +
+			"a=rtpmap:97 telephone-event/8000\r\n" +
+			"a=fmtp:97 0-16\r\n" +
+			"a=ptime:20\r\n" +
+			"a=inactive\r\n" +
+			"a=rtcp-mux\r\n" +
+			"m=video 0 RTP/AVP 31\r\n",
+		"v=0\r\n" +
+			"o=- 3 4 IN IP6 2001:db8::1\r\n" +
+			"s=-\r\n" +
+			"t=0 0\r\n" +
+			"m=audio 5004 RTP/AVP 0\r\n" +
+			"c=IN IP6 2001:db8::2\r\n" +
+			"a=recvonly\r\n",
+	}
+
+	for _, body := range bodies {
+		desc, err := Parse([]byte(body))
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if got := string(desc.Marshal()); got != body {
+			t.Errorf("Marshal(Parse(body)) =\n%q\nwant\n%q", got, body)
+		}
+	}
+}
+
+func TestDirection(t *testing.T) {
+	tests := []struct {
+		name     string
+		session  string
+		media    string
+		want     string
+		wantAddr string
+	}{
+		{"default", "", "", SendRecv, "192.0.2.1"},
+		{"media", "", "a=sendonly\r\n", SendOnly, "192.0.2.1"},
+		{"session", "a=recvonly\r\n", "", RecvOnly, "192.0.2.1"},
+		{"media overrides session", "a=recvonly\r\n", "a=inactive\r\nc=IN IP4 192.0.2.2\r\n", Inactive, "192.0.2.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := "v=0\r\nc=IN IP4 192.0.2.1\r\n" + tt.session + "m=audio 5004 RTP/AVP 0\r\n" + tt.media
+			desc, err := Parse([]byte(body))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			audio := desc.FirstMedia("audio")
+			if got := desc.Direction(audio); got != tt.want {
+				t.Errorf("Direction() = %q, want %q", got, tt.want)
+			}
+			if got := desc.Address(audio); got != tt.wantAddr {
+				t.Errorf("Address() = %q, want %q", got, tt.wantAddr)
+			}
+		})
+	}
+}
+
+func TestRTPMap(t *testing.T) {
+	media := &Media{
+		Formats: []string{"0", "8", "9", "101", "18"},
+		Attributes: []Attribute{
+			{Key: "rtpmap", Value: "101 telephone-event/8000"},
+			{Key: "rtpmap", Value: "96 opus/48000/2"},
+			{Key: "rtpmap", Value: "0 pcmu/8000"},
+		},
+	}
+
+	tests := []struct {
+		pt        int
+		encoding  string
+		clockRate int
+		ok        bool
+	}{
+		{0, "pcmu", 8000, true}, // Mapped explicitly
+		{8, "PCMA", 8000, true}, // Static
+		{9, "G722", 8000, true},
+		{101, "telephone-event", 8000, true},
+		{96, "opus", 48000, true},
+		{18, "", 0, false}, // Static, but not known here
+		{10, "", 0, false}, // Must not match the prefix of 101
+	}
+
+	for _, tt := range tests {
+		encoding, clockRate, ok := media.RTPMap(tt.pt)
+		if encoding != tt.encoding || clockRate != tt.clockRate || ok != tt.ok {
+			t.Errorf("RTPMap(%d) = %q, %d, %v, want %q, %d, %v", tt.pt, encoding, clockRate, ok, tt.encoding, tt.clockRate, tt.ok)
+		}
+	}
+}
+
+func TestPayloadType(t *testing.T) {
+	tests := []struct {
+		name     string
+		media    string
+		encoding string
+		want     int
+		ok       bool
+	}{
+		{"static", "m=audio 5004 RTP/AVP 8 0\r\n", "PCMU", 0, true},
+		{"dynamic", "m=audio 5004 RTP/AVP 0 96\r\na=rtpmap:96 telephone-event/8000\r\n", "telephone-event", 96, true},
+		{"case-insensitive", "m=audio 5004 RTP/AVP 0 100\r\na=rtpmap:100 Telephone-Event/8000\r\n", "telephone-event", 100, true},
+		{"first in preference order", "m=audio 5004 RTP/AVP 97 96\r\na=rtpmap:96 telephone-event/8000\r\na=rtpmap:97 telephone-event/8000\r\n", "telephone-event", 97, true},
+		{"mapped but not offered", "m=audio 5004 RTP/AVP 0\r\na=rtpmap:101 telephone-event/8000\r\n", "telephone-event", 0, false},
+		{"not offered", "m=audio 5004 RTP/AVP 0 8\r\n", "telephone-event", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc, err := Parse([]byte("v=0\r\n" + tt.media))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got, ok := desc.FirstMedia("audio").PayloadType(tt.encoding)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("PayloadType(%q) = %d, %v, want %d, %v", tt.encoding, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFirstMediaAndAttribute(t *testing.T) {
+	desc, err := Parse([]byte(strings.Join([]string{
+		"v=0",
+		"m=video 0 RTP/AVP 31",
+		"m=audio 5004 RTP/AVP 0",
+		"a=ptime:20",
+		"a=ptime:30",
+		"a=rtcp-mux",
+		"m=audio 5006 RTP/AVP 0",
+	}, "\r\n")))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	audio := desc.FirstMedia("audio")
+	if audio == nil || audio.Port != 5004 {
+		t.Fatalf("FirstMedia(audio) = %+v, want the section on port 5004", audio)
+	}
+	if desc.FirstMedia("application") != nil {
+		t.Error("FirstMedia(application) found a section that isn't there")
+	}
+
+	tests := []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{"ptime", "20", true},
+		{"rtcp-mux", "", true},
+		{"fmtp", "", false},
+	}
+	for _, tt := range tests {
+		value, ok := audio.Attribute(tt.key)
+		if value != tt.value || ok != tt.ok {
+			t.Errorf("Attribute(%q) = %q, %v, want %q, %v", tt.key, value, ok, tt.value, tt.ok)
+		}
+	}
+}
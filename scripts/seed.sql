@@ -4,10 +4,19 @@
 -- =============================================================================
 -- Default Account
 -- =============================================================================
-INSERT INTO accounts (id, name, api_key) VALUES 
-    ('00000000-0000-0000-0000-000000000001', 'Default Account', 'test-api-key-12345')
+INSERT INTO accounts (id, name) VALUES 
+    ('00000000-0000-0000-0000-000000000001', 'Default Account')
 ON CONFLICT (id) DO NOTHING;
 
+-- API key: test-api-key-12345 (hex SHA-256, rehashed with argon2id on first use)
+INSERT INTO api_keys (account_id, name, key_hash, prefix)
+SELECT '00000000-0000-0000-0000-000000000001', 'default',
+       encode(sha256(convert_to('test-api-key-12345', 'UTF8')), 'hex'), 'test-api'
+WHERE NOT EXISTS (
+    SELECT 1 FROM api_keys
+    WHERE account_id = '00000000-0000-0000-0000-000000000001' AND prefix = 'test-api'
+);
+
 -- =============================================================================
 -- Sample Inbound Routes
 -- =============================================================================